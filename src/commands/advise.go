@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/notwillk/sqlfs/internal/advise"
+	"github.com/notwillk/sqlfs/internal/builder"
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+var adviseCmd = &cobra.Command{
+	Use:   "advise <root>",
+	Short: "Suggest indexes missing from schema.dbml",
+	Long: `Build the database for <root> and suggest indexes for the columns on
+either side of a relationship that no primary key, unique constraint or index
+covers, with each column's row and distinct value counts so that indexes on
+columns with few distinct values can be skipped.
+
+With --dbml, print the suggestions as indexes blocks to paste into the tables
+of schema.dbml instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdvise,
+}
+
+var adviseDBML bool
+
+func init() {
+	adviseCmd.Flags().BoolVar(&adviseDBML, "dbml", false, "Print the suggested indexes as DBML indexes blocks")
+}
+
+func runAdvise(cmd *cobra.Command, args []string) error {
+	rootDir := args[0]
+
+	cfg, err := config.Load(rootDir)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	src, err := builder.ReadSchema(context.Background(), rootDir, cfg)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s not found; advise needs a schema to suggest indexes for", builder.SchemaLocation(rootDir, cfg))
+	}
+	if err != nil {
+		return err
+	}
+	schema, err := dbml.Parse(src)
+	if err != nil {
+		return fmt.Errorf("parsing schema: %w", err)
+	}
+	builder.ExcludeTables(schema, cfg)
+
+	db, cleanup, err := buildForAdvice(rootDir, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	suggestions, err := advise.Indexes(schema, db)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if adviseDBML {
+		_, err := fmt.Fprint(out, advise.Snippet(suggestions))
+		return err
+	}
+	if len(suggestions) == 0 {
+		fmt.Fprintln(out, "No missing indexes found.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tCOLUMN\tROWS\tDISTINCT\tREASON")
+	for _, sg := range suggestions {
+		reason := sg.Reason
+		if sg.Unique {
+			reason += "; values are unique"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", sg.Table, sg.Column, sg.Rows, sg.Distinct, reason)
+	}
+	return tw.Flush()
+}
+
+// buildForAdvice builds rootDir into a temporary database, keeping invalid
+// files out as warnings rather than failing, and opens it.
+func buildForAdvice(rootDir string, cfg *config.Config) (*sql.DB, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "sqlfs-advise-")
+	if err != nil {
+		return nil, nil, err
+	}
+	outFile := filepath.Join(tmpDir, "advise.db")
+	if _, err := builder.Build(context.Background(), builder.Options{
+		RootDir:    rootDir,
+		OutputFile: outFile,
+		Config:     cfg.WithInvalid(string(config.InvalidWarn)),
+	}); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, nil, fmt.Errorf("building database: %w", err)
+	}
+	db, err := sqlite.OpenReadOnly(outFile)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, nil, err
+	}
+	return db.DB(), func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}, nil
+}
@@ -1,14 +1,21 @@
 package commands
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/notwillk/sqlfs/internal/builder"
 	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/remotecache"
 )
 
 var buildCmd = &cobra.Command{
@@ -22,10 +29,34 @@ static files in the root directory.`,
 
 var buildOutputFile string
 var buildInvalid string
+var buildFormat string
+var buildEncryptionKeyEnv string
+var buildKeepSnapshots int
+var buildTimeout time.Duration
+var buildRemoteCache string
+var buildTablesDir string
+var buildJobs int
+var buildDeterministic bool
+var buildSchema string
+var buildSample string
+var buildLimitPerTable int
+var buildMetricsHistory string
 
 func init() {
 	buildCmd.Flags().StringVarP(&buildOutputFile, "output-file", "o", "", "Output database file (required)")
 	buildCmd.Flags().StringVar(&buildInvalid, "invalid", "", "Behavior on validation failure: silent, warn, fail (default: fail)")
+	buildCmd.Flags().StringVar(&buildFormat, "format", builder.FormatSQLite, "Output format: sqlite, sql, copy")
+	buildCmd.Flags().StringVar(&buildEncryptionKeyEnv, "encryption-key-env", "", "Encrypt the output with the key in this environment variable")
+	buildCmd.Flags().IntVar(&buildKeepSnapshots, "keep-snapshots", 0, "Retain copies of the last N builds in <output-file>.snapshots")
+	buildCmd.Flags().DurationVar(&buildTimeout, "build-timeout", 0, "Abort the build if it takes longer than this, e.g. 30s")
+	buildCmd.Flags().StringVar(&buildRemoteCache, "remote-cache", "", "Restore the incremental cache from, and save it to, this http(s) URL or directory")
+	buildCmd.Flags().StringVar(&buildTablesDir, "tables-dir", "", "Also write a database of each table on its own to this directory")
+	buildCmd.Flags().BoolVar(&buildDeterministic, "deterministic", false, "Derive generated IDs from the records so builds of the same files are identical")
+	buildCmd.Flags().StringVar(&buildSchema, "schema", "", `Read the DBML schema from this path in the root, "-" for standard input, or an http(s) URL`)
+	buildCmd.Flags().IntVar(&buildJobs, "jobs", 0, "Number of files to load and validate at once (default: the number of CPUs)")
+	buildCmd.Flags().StringVar(&buildSample, "sample", "", "Build only about this percentage of each table's records, e.g. 10%, keeping the records they reference")
+	buildCmd.Flags().IntVar(&buildLimitPerTable, "limit-per-table", 0, "Build only the first N records of each table, keeping the records they reference")
+	buildCmd.Flags().StringVar(&buildMetricsHistory, "metrics-history", "", "Append the build's metrics as a line of JSON to this file")
 	buildCmd.MarkFlagRequired("output-file")
 }
 
@@ -36,16 +67,45 @@ func runBuild(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
-	cfg = cfg.WithInvalid(buildInvalid)
+	cfg = cfg.WithInvalid(buildInvalid).
+		WithEncryptionKeyEnv(buildEncryptionKeyEnv).
+		WithKeepSnapshots(buildKeepSnapshots).
+		WithBuildTimeout(buildTimeout).
+		WithRemoteCache(buildRemoteCache).
+		WithDeterministic(buildDeterministic).
+		WithSchema(buildSchema)
 
-	result, err := builder.Build(context.Background(), builder.Options{
-		RootDir:    rootDir,
-		OutputFile: buildOutputFile,
-		Config:     cfg,
+	sample, err := parseSample(buildSample, buildLimitPerTable)
+	if err != nil {
+		return err
+	}
+
+	var encryptionKey string
+	if cfg.EncryptionKeyEnvVar != "" {
+		encryptionKey = os.Getenv(cfg.EncryptionKeyEnvVar)
+		if encryptionKey == "" {
+			return fmt.Errorf("encryption enabled but environment variable %s is not set", cfg.EncryptionKeyEnvVar)
+		}
+	}
+
+	ctx := context.Background()
+	remote := openRemoteCache(ctx, rootDir, cfg, encryptionKey)
+	result, err := builder.Build(ctx, builder.Options{
+		RootDir:        rootDir,
+		OutputFile:     buildOutputFile,
+		Config:         cfg,
+		Format:         buildFormat,
+		EncryptionKey:  encryptionKey,
+		Cache:          remote.cache,
+		TablesDir:      buildTablesDir,
+		Jobs:           buildJobs,
+		Sample:         sample,
+		MetricsHistory: buildMetricsHistory,
 	})
 	if err != nil {
 		return err
 	}
+	remote.save(ctx)
 
 	for _, w := range result.Warnings {
 		fmt.Fprintln(os.Stderr, "warning:", w.Error())
@@ -53,5 +113,108 @@ func runBuild(cmd *cobra.Command, args []string) error {
 
 	fmt.Fprintf(cmd.OutOrStdout(), "Built %d records across %d tables in %s\n",
 		result.RecordsTotal, result.TablesBuilt, result.Duration)
+	if result.SnapshotID != "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "Saved snapshot %s\n", result.SnapshotID)
+	}
 	return nil
 }
+
+// remoteCache is a build cache restored from cfg.RemoteCache. Failing to
+// reach the remote location only costs build time, so errors are warnings.
+type remoteCache struct {
+	store      remotecache.Store
+	key        string
+	passphrase string         // the output's encryption key, sealing the cache
+	cache      *builder.Cache // nil when no remote cache is configured
+}
+
+// openRemoteCache restores the cache stored for rootDir's schema, or starts
+// an empty one on a miss. Builds without a schema file have no cache. When
+// the output is encrypted with passphrase, so is the cache.
+func openRemoteCache(ctx context.Context, rootDir string, cfg *config.Config, passphrase string) *remoteCache {
+	r := &remoteCache{passphrase: passphrase}
+	if cfg.RemoteCache == "" {
+		return r
+	}
+	key, err := builder.CacheKey(ctx, rootDir, cfg)
+	if errors.Is(err, os.ErrNotExist) {
+		return r
+	}
+	if err != nil {
+		log.Printf("warning: remote cache: %v", err)
+		return r
+	}
+	store, err := remotecache.Open(cfg.RemoteCache)
+	if err != nil {
+		log.Printf("warning: remote cache: %v", err)
+		return r
+	}
+	r.store, r.key, r.cache = store, key, builder.NewCache()
+
+	ctx, cancel := context.WithTimeout(ctx, remoteCacheTimeout)
+	defer cancel()
+	data, err := store.Get(ctx, key)
+	switch {
+	case errors.Is(err, remotecache.ErrNotFound):
+		return r
+	case err != nil:
+		log.Printf("warning: restoring remote cache: %v", err)
+		return r
+	}
+	data, err = remotecache.Unseal(data, passphrase)
+	if err != nil {
+		log.Printf("warning: restoring remote cache: %v", err)
+		return r
+	}
+	cache, err := builder.ReadCache(bytes.NewReader(data), rootDir)
+	if err != nil {
+		log.Printf("warning: restoring remote cache: %v", err)
+		return r
+	}
+	r.cache = cache
+	return r
+}
+
+// remoteCacheTimeout bounds each transfer of a remote cache.
+const remoteCacheTimeout = 5 * time.Minute
+
+// save uploads the cache of the finished build and releases it.
+func (r *remoteCache) save(ctx context.Context) {
+	if r.cache == nil {
+		return
+	}
+	defer r.cache.Close()
+	var buf bytes.Buffer
+	if err := r.cache.Save(&buf); err != nil {
+		log.Printf("warning: saving remote cache: %v", err)
+		return
+	}
+	data, err := remotecache.Seal(buf.Bytes(), r.passphrase)
+	if err != nil {
+		log.Printf("warning: saving remote cache: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, remoteCacheTimeout)
+	defer cancel()
+	if err := r.store.Put(ctx, r.key, data); err != nil {
+		log.Printf("warning: saving remote cache: %v", err)
+	}
+}
+
+// parseSample returns the builder.Sample of the --sample and
+// --limit-per-table flags. percent is a percentage such as "10%" or "10".
+func parseSample(percent string, perTable int) (builder.Sample, error) {
+	sample := builder.Sample{PerTable: perTable}
+	if perTable < 0 {
+		return sample, fmt.Errorf("--limit-per-table must not be negative")
+	}
+	if percent == "" {
+		return sample, nil
+	}
+	p, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(percent), "%"), 64)
+	if err != nil || p <= 0 || p > 100 {
+		return sample, fmt.Errorf("--sample %q is not a percentage between 0 and 100, e.g. 10%%", percent)
+	}
+	sample.Percent = p
+	return sample, nil
+}
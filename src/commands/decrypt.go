@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/notwillk/sqlfs/internal/encrypt"
+)
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt <file>",
+	Short: "Decrypt an encrypted build output",
+	Long: `Decrypt a database or SQL dump produced by 'sqlfs build' with encryption
+enabled. The key is read from the environment variable named by --key-env
+(default: SQLFS_ENCRYPTION_KEY).`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDecrypt,
+}
+
+var decryptOutputFile string
+var decryptKeyEnv string
+
+func init() {
+	decryptCmd.Flags().StringVarP(&decryptOutputFile, "output-file", "o", "", "Output file (required)")
+	decryptCmd.Flags().StringVar(&decryptKeyEnv, "key-env", "SQLFS_ENCRYPTION_KEY", "Environment variable holding the encryption key")
+	decryptCmd.MarkFlagRequired("output-file")
+}
+
+func runDecrypt(cmd *cobra.Command, args []string) error {
+	key := os.Getenv(decryptKeyEnv)
+	if key == "" {
+		return fmt.Errorf("environment variable %s is not set", decryptKeyEnv)
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", args[0], err)
+	}
+	defer in.Close()
+	plain, err := encrypt.NewReader(in, key)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(decryptOutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	if _, err := io.Copy(out, plain); err != nil {
+		out.Close()
+		os.Remove(decryptOutputFile)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Decrypted %s to %s\n", args[0], decryptOutputFile)
+	return nil
+}
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/notwillk/sqlfs/internal/advise"
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <root|db> <query>",
+	Short: "Show the query plan SQLite chooses for a query",
+	Long: `Print the plan SQLite chooses for <query> (EXPLAIN QUERY PLAN), with each
+step explained and hints where an index declared in a table's indexes block
+of schema.dbml would make it cheaper. The query is planned, not run.
+
+<root|db> is either a data directory, which is built into a temporary
+database first, or a database written by sqlfs build.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runExplain,
+}
+
+func runExplain(cmd *cobra.Command, args []string) error {
+	target, query := args[0], args[1]
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	var db *sql.DB
+	if info.IsDir() {
+		cfg, err := config.Load(target)
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+		built, cleanup, err := buildForAdvice(target, cfg)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		db = built
+	} else {
+		opened, err := sqlite.OpenReadOnly(target)
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+		db = opened.DB()
+	}
+
+	steps, err := advise.Explain(db, query)
+	if err != nil {
+		return fmt.Errorf("explaining query: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	var hints []string
+	for _, st := range steps {
+		indent := strings.Repeat("  ", st.Depth)
+		fmt.Fprintf(out, "%s%s\n", indent, st.Detail)
+		if st.Note != "" {
+			fmt.Fprintf(out, "%s    %s\n", indent, st.Note)
+		}
+		if st.Hint != "" {
+			hints = append(hints, st.Hint)
+		}
+	}
+	if len(hints) > 0 {
+		fmt.Fprintln(out, "\nHints:")
+		for _, h := range hints {
+			fmt.Fprintf(out, "  - %s\n", h)
+		}
+	}
+	return nil
+}
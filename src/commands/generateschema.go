@@ -43,6 +43,9 @@ func runGenerateSchema(cmd *cobra.Command, args []string) error {
 
 	outPath := generateSchemaOutput
 	if outPath == "" {
+		if !cfg.SchemaIsFile() {
+			return fmt.Errorf("the schema is read from %s; use --output to choose where to write it", builder.SchemaLocation(rootDir, cfg))
+		}
 		outPath = filepath.Join(rootDir, cfg.SchemaFile)
 	}
 
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/notwillk/sqlfs/internal/builder"
+	"github.com/notwillk/sqlfs/internal/config"
+)
+
+var inferCmd = &cobra.Command{
+	Use:   "infer <root>",
+	Short: "Propose a schema.dbml from the values in entity files",
+	Long: `Read the entity files in <root> and print a proposed schema.dbml. Unlike
+generate-schema, which types every column as varchar, infer types each column
+by the values it holds (boolean, integer, float, date, timestamp, json, text
+or varchar), marks columns that every row sets as not null, and makes an id
+column whose values are all set and distinct the primary key.
+
+Files without a table in their name and matching no tables pattern are
+grouped into tables of their own by their fields. Those tables are part of
+the schema, and the tables patterns assigning the files to them are printed
+to standard error, to add to sqlfs.yaml.
+
+Use --output to write the schema to a file instead, and --force to overwrite
+an existing one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInfer,
+}
+
+var inferOutput string
+var inferForce bool
+
+func init() {
+	inferCmd.Flags().StringVarP(&inferOutput, "output", "o", "", "Write the schema to this file instead of standard output")
+	inferCmd.Flags().BoolVar(&inferForce, "force", false, "Overwrite an existing output file")
+}
+
+func runInfer(cmd *cobra.Command, args []string) error {
+	rootDir := args[0]
+
+	cfg, err := config.Load(rootDir)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if inferOutput != "" && !inferForce {
+		if _, err := os.Stat(inferOutput); !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s already exists; use --force to overwrite", inferOutput)
+		}
+	}
+
+	inferred, err := builder.InferSchema(context.Background(), builder.InferSchemaOptions{
+		RootDir: rootDir,
+		Config:  cfg,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(inferred.Clusters) > 0 {
+		stderr := cmd.ErrOrStderr()
+		fmt.Fprintln(stderr, "Files without a table were grouped by their fields; assign them in sqlfs.yaml with:")
+		fmt.Fprintln(stderr, "tables:")
+		for _, c := range inferred.Clusters {
+			for _, f := range c.Files {
+				fmt.Fprintf(stderr, "  %s: %s\n", strconv.Quote(f), c.Table)
+			}
+		}
+	}
+
+	if inferOutput == "" {
+		_, err := fmt.Fprint(cmd.OutOrStdout(), inferred.DBML)
+		return err
+	}
+	if err := os.WriteFile(inferOutput, []byte(inferred.DBML), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", inferOutput, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", inferOutput)
+	return nil
+}
@@ -1,9 +1,12 @@
 package commands
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -14,18 +17,32 @@ import (
 )
 
 var jsonSchemaCmd = &cobra.Command{
-	Use:   "json-schema <root>",
+	Use:   "json-schema <root> [--check <file>...]",
 	Short: "Generate a JSON Schema from schema.dbml",
 	Long: `Parse schema.dbml from the root directory and output a JSON Schema
-document that can be used to validate data files.`,
-	Args: cobra.ExactArgs(1),
+document that can be used to validate data files.
+
+With --check, validate the given data files against the generated schema
+instead, printing one line per violation with its JSON pointer.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if jsonSchemaCheck {
+			return cobra.MinimumNArgs(2)(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	RunE: runJSONSchema,
 }
 
-var jsonSchemaOutputFile string
+var (
+	jsonSchemaOutputFile string
+	jsonSchemaCheck      bool
+	jsonSchemaLocale     string
+)
 
 func init() {
 	jsonSchemaCmd.Flags().StringVarP(&jsonSchemaOutputFile, "output-file", "o", "", "Output file (default: stdout)")
+	jsonSchemaCmd.Flags().StringVar(&jsonSchemaLocale, "locale", "", "Describe tables and columns with the note summaries in this locale, e.g. fr")
+	jsonSchemaCmd.Flags().BoolVar(&jsonSchemaCheck, "check", false, "Validate the data files given after <root> instead of printing the schema")
 }
 
 func runJSONSchema(cmd *cobra.Command, args []string) error {
@@ -35,11 +52,12 @@ func runJSONSchema(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
+	cfg = cfg.WithLocale(jsonSchemaLocale)
 
 	var data []byte
 
-	schemaPath := rootDir + "/" + cfg.SchemaFile
-	if _, statErr := os.Stat(schemaPath); errors.Is(statErr, os.ErrNotExist) {
+	src, err := builder.ReadSchema(context.Background(), rootDir, cfg)
+	if errors.Is(err, os.ErrNotExist) {
 		// Schema-less mode: infer structure from entity files.
 		cols, err := builder.DiscoverColumnMap(rootDir, cfg)
 		if err != nil {
@@ -49,17 +67,24 @@ func runJSONSchema(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return fmt.Errorf("generating JSON schema: %w", err)
 		}
+	} else if err != nil {
+		return err
 	} else {
-		schema, err := dbml.ParseFile(schemaPath)
+		schema, err := dbml.Parse(src)
 		if err != nil {
 			return fmt.Errorf("parsing schema: %w", err)
 		}
+		builder.ExcludeTables(schema, cfg)
 		data, err = jsonschema.Generate(schema, cfg)
 		if err != nil {
 			return fmt.Errorf("generating JSON schema: %w", err)
 		}
 	}
 
+	if jsonSchemaCheck {
+		return checkDataFiles(cmd, rootDir, cfg, data, args[1:])
+	}
+
 	if jsonSchemaOutputFile != "" {
 		if err := os.WriteFile(jsonSchemaOutputFile, data, 0644); err != nil {
 			return fmt.Errorf("writing output file: %w", err)
@@ -71,3 +96,39 @@ func runJSONSchema(cmd *cobra.Command, args []string) error {
 	_, err = cmd.OutOrStdout().Write(append(data, '\n'))
 	return err
 }
+
+// checkDataFiles validates each file against the generated schema document.
+// File paths are reported relative to rootDir when they lie inside it.
+func checkDataFiles(cmd *cobra.Command, rootDir string, cfg *config.Config, schemaDoc []byte, files []string) error {
+	checker, err := jsonschema.NewChecker(schemaDoc, builder.NewRegistry(cfg))
+	if err != nil {
+		return fmt.Errorf("compiling JSON schema: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	failed := 0
+	for _, file := range files {
+		relPath := file
+		if rel, err := filepath.Rel(rootDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			relPath = rel
+		}
+		errs, err := checker.CheckFile(file, relPath)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			failed++
+			continue
+		}
+		for _, e := range errs {
+			fmt.Fprintln(out, e)
+		}
+		if len(errs) > 0 {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed validation", failed, len(files))
+	}
+	fmt.Fprintf(out, "%d file(s) valid\n", len(files))
+	return nil
+}
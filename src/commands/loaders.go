@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/notwillk/sqlfs/internal/builder"
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+var loadersCmd = &cobra.Command{
+	Use:   "loaders [<file>...]",
+	Short: "List the registered file loaders",
+	Long: `List the loaders that read data files, with the extensions each handles
+and the format features it supports: the built-in loaders, the plugin
+loaders of an application embedding sqlfs, and the external loaders that the
+sqlfs.yaml of --root declares under loaders.
+
+Given files, report which loader would read each one and the table it belongs
+to, or why the build skips it. Files are assigned to tables by the tables
+patterns in the sqlfs.yaml of --root, then by their names, and, with
+table_from fields, then by their fields; the score of each table their
+fields match is listed.`,
+	RunE: runLoaders,
+}
+
+var loadersJSON bool
+var loadersRoot string
+
+func init() {
+	loadersCmd.Flags().BoolVar(&loadersJSON, "json", false, "Print the loaders as JSON")
+	loadersCmd.Flags().StringVar(&loadersRoot, "root", ".", "Root directory whose sqlfs.yaml maps files to tables and declares external loaders")
+}
+
+func runLoaders(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(loadersRoot)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	reg := builder.NewRegistry(cfg)
+	if len(args) > 0 {
+		return explainFiles(cmd, cfg, reg, args)
+	}
+
+	infos := reg.Loaders()
+	if loadersJSON {
+		data, err := json.MarshalIndent(map[string]any{"loaders": infos, "plugins": true}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(append(data, '\n'))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOADER\tEXTENSIONS\tOPTIONS")
+	for _, info := range infos {
+		opts := "-"
+		if len(info.Options) > 0 {
+			opts = strings.Join(info.Options, "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", info.Name, strings.Join(info.Extensions, " "), opts)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	var plugins []string
+	for _, info := range infos {
+		if info.Plugin {
+			plugins = append(plugins, info.Name)
+		}
+	}
+	if len(plugins) == 0 {
+		plugins = []string{"none registered"}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "\nPlugins: %s\n", strings.Join(plugins, ", "))
+	return nil
+}
+
+// explainFiles prints the loader and table of each file, or why it is skipped.
+// Paths inside loadersRoot are matched against its tables patterns relative
+// to it.
+func explainFiles(cmd *cobra.Command, cfg *config.Config, reg *loader.Registry, files []string) error {
+	out := cmd.OutOrStdout()
+	var schema *dbml.Schema // read for the first file matched by its fields
+	for _, f := range files {
+		relPath := f
+		if rel, err := filepath.Rel(loadersRoot, f); err == nil && !strings.HasPrefix(rel, "..") {
+			relPath = rel
+		}
+		name := reg.LoaderName(f)
+		table := reg.TableOf(relPath)
+		switch {
+		case cfg.IsIgnored(relPath):
+			fmt.Fprintf(out, "%s: skipped, ignored (hidden, an editor temp file, or matching an ignore pattern)\n", f)
+		case name == "":
+			fmt.Fprintf(out, "%s: skipped, no loader for this extension\n", f)
+		case table == "" && cfg.TableFrom == config.TableFromFields:
+			if schema == nil {
+				var err error
+				if schema, err = loadersSchema(cfg); err != nil {
+					return err
+				}
+			}
+			fr, err := reg.LoadFile(f, relPath)
+			if err != nil {
+				fmt.Fprintf(out, "%s: skipped, %v\n", f, err)
+				continue
+			}
+			m := builder.MatchTable(schema, cfg, fr)
+			if m.Table == "" {
+				fmt.Fprintf(out, "%s: skipped, no table in the file name and %s\n", f, m.Reason)
+			} else {
+				fmt.Fprintf(out, "%s: %s loader, table %s, matched by its fields\n", f, name, m.Table)
+			}
+			for _, s := range m.Scores {
+				fmt.Fprintf(out, "  %s: %.2f\n", s.Table, s.Score)
+			}
+		case table == "":
+			fmt.Fprintf(out, "%s: skipped, no table in the file name (expected name.table.ext) and no tables pattern matches\n", f)
+		default:
+			fmt.Fprintf(out, "%s: %s loader, table %s\n", f, name, table)
+		}
+	}
+	return nil
+}
+
+// loadersSchema reads the schema of loadersRoot that table_from fields
+// matches files against, without its excluded tables.
+func loadersSchema(cfg *config.Config) (*dbml.Schema, error) {
+	src, err := builder.ReadSchema(context.Background(), loadersRoot, cfg)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s not found; table_from fields needs a schema to match files against", builder.SchemaLocation(loadersRoot, cfg))
+	}
+	if err != nil {
+		return nil, err
+	}
+	schema, err := dbml.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	builder.ExcludeTables(schema, cfg)
+	return schema, nil
+}
@@ -14,7 +14,7 @@ var showVersion bool
 var rootCmd = &cobra.Command{
 	Use:   "sqlfs",
 	Short: "Build and serve a SQLite database from static files",
-	Long: `sqlfs creates a SQLite database from static data files (YAML, TOML, JSON, XML, plist)
+	Long: `sqlfs creates a SQLite database from static data files (YAML, TOML, JSON, XML, plist, INI, .env, Markdown)
 validated against a DBML schema.`,
 	SilenceUsage:  true,
 	SilenceErrors: true,
@@ -29,7 +29,7 @@ validated against a DBML schema.`,
 
 func init() {
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Print version and exit")
-	rootCmd.AddCommand(buildCmd, serveCmd, jsonSchemaCmd, configSchemaCmd, generateSchemaCmd)
+	rootCmd.AddCommand(buildCmd, serveCmd, jsonSchemaCmd, configSchemaCmd, generateSchemaCmd, inferCmd, decryptCmd, snapshotsCmd, loadersCmd, adviseCmd, explainCmd)
 }
 
 // Execute runs the root cobra command and returns an exit code.
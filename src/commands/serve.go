@@ -3,84 +3,275 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/notwillk/sqlfs/internal/accesslog"
 	"github.com/notwillk/sqlfs/internal/builder"
+	"github.com/notwillk/sqlfs/internal/changes"
 	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/httpserver"
 	"github.com/notwillk/sqlfs/internal/pgserver"
+	"github.com/notwillk/sqlfs/internal/validator"
 	"github.com/notwillk/sqlfs/internal/watcher"
 )
 
 var serveCmd = &cobra.Command{
-	Use:   "serve <root>",
+	Use:   "serve <root> [<root>...]",
 	Short: "Build and serve a SQLite database via PostgreSQL wire protocol",
 	Long: `Parse schema.dbml, build a SQLite database, and serve it as a
-read-only PostgreSQL-compatible server. Watches for file changes and rebuilds.`,
-	Args: cobra.ExactArgs(1),
+read-only PostgreSQL-compatible server. Watches for file changes and rebuilds.
+
+When several roots are given, each is served as its own database, selected by
+the database name in the client's connection string. The name defaults to the
+root directory's base name; use name=path to choose it explicitly. In this mode
+--output-file is a directory that receives one <name>.db file per root, and
+the port and credentials are taken from the first root's sqlfs.yaml.
+
+With --listen (or listen in sqlfs.yaml), the same databases are also served on
+further TCP addresses or Unix sockets, sharing one reload lifecycle.
+
+With --http-port (or http_port in sqlfs.yaml), the databases are also served
+as a read-only HTTP JSON API on that port.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: runServe,
 }
 
 var serveOutputFile string
 var serveInvalid string
 var servePort int
+var serveListen []string
+var serveHTTPPort int
+var serveKeepSnapshots int
+var serveWebhook string
+var serveBuildTimeout time.Duration
+var serveIncremental bool
+var serveSchema string
+var serveSample string
+var serveLimitPerTable int
+var serveMetricsHistory string
+var serveWarningsLog string
+
+// serveSampled is the sample of every build, parsed from --sample and
+// --limit-per-table.
+var serveSampled builder.Sample
 
 func init() {
-	serveCmd.Flags().StringVarP(&serveOutputFile, "output-file", "o", "", "Database file path (required)")
+	serveCmd.Flags().StringVarP(&serveOutputFile, "output-file", "o", "", "Database file path, or directory when serving several roots (required)")
 	serveCmd.Flags().StringVar(&serveInvalid, "invalid", "", "Behavior on validation failure: silent, warn (default: warn)")
 	serveCmd.Flags().IntVar(&servePort, "port", 0, "Port to serve on (default: 5432)")
+	serveCmd.Flags().StringArrayVar(&serveListen, "listen", nil, "Additional address to serve on: host:port or unix:<path> (repeatable)")
+	serveCmd.Flags().IntVar(&serveHTTPPort, "http-port", 0, "Port to serve the HTTP JSON API on (default: disabled)")
+	serveCmd.Flags().IntVar(&serveKeepSnapshots, "keep-snapshots", 0, "Retain copies of the last N builds in <output-file>.snapshots")
+	serveCmd.Flags().StringVar(&serveWebhook, "webhook", "", "URL to POST a JSON change summary to after each rebuild")
+	serveCmd.Flags().DurationVar(&serveBuildTimeout, "build-timeout", 0, "Abort a build or rebuild that takes longer than this, e.g. 30s")
+	serveCmd.Flags().BoolVar(&serveIncremental, "incremental", false, "Rebuild only the files that changed since the last build")
+	serveCmd.Flags().StringVar(&serveSchema, "schema", "", `Read the DBML schema from this path in the root, "-" for standard input, or an http(s) URL`)
+	serveCmd.Flags().StringVar(&serveSample, "sample", "", "Serve only about this percentage of each table's records, e.g. 10%, keeping the records they reference")
+	serveCmd.Flags().IntVar(&serveLimitPerTable, "limit-per-table", 0, "Serve only the first N records of each table, keeping the records they reference")
+	serveCmd.Flags().StringVar(&serveMetricsHistory, "metrics-history", "", "Append the metrics of each build as a line of JSON to this file")
+	serveCmd.Flags().StringVar(&serveWarningsLog, "warnings-log", "", "Append the validation warnings of each build to this file, a line of JSON each")
 	serveCmd.MarkFlagRequired("output-file")
 }
 
-func runServe(cmd *cobra.Command, args []string) error {
-	rootDir := args[0]
+// servedRoot is one root directory served by the serve command.
+type servedRoot struct {
+	name       string // pg database name; "" when serving a single root
+	rootDir    string
+	outputFile string
+	cfg        *config.Config
+	primary    bool           // whether the server's settings come from this root
+	cache      *builder.Cache // previous build's state when cfg.Incremental is set
+	watcher    *watcher.Watcher
+	nextExpiry time.Time                   // when the served build's first [expires] row expires
+	builtAt    time.Time                   // when the served build was made
+	warnings   []validator.ValidationError // the served build's warnings
+}
 
-	cfg, err := config.Load(rootDir)
-	if err != nil {
-		return fmt.Errorf("loading config: %w", err)
+// buildCache returns the cache for the root's next build, creating or
+// dropping it as cfg.Incremental requires.
+func (r *servedRoot) buildCache() *builder.Cache {
+	if !r.cfg.Incremental {
+		if r.cache != nil {
+			r.cache.Close()
+			r.cache = nil
+		}
+		return nil
 	}
+	if r.cache == nil {
+		r.cache = builder.NewCache()
+	}
+	return r.cache
+}
 
-	// serve defaults to 'warn' for invalid behavior (unlike build which defaults to 'fail').
-	if serveInvalid == "" && cfg.Invalid == config.InvalidFail {
-		cfg = cfg.WithInvalid("warn")
-	} else {
-		cfg = cfg.WithInvalid(serveInvalid)
+// label identifies the root in log output.
+func (r *servedRoot) label() string {
+	if r.name == "" {
+		return ""
 	}
-	cfg = cfg.WithPort(servePort)
+	return "[" + r.name + "] "
+}
 
-	// Initial build.
-	fmt.Fprintf(cmd.OutOrStdout(), "Building database...\n")
-	buildResult, err := builder.Build(context.Background(), builder.Options{
-		RootDir:    rootDir,
-		OutputFile: serveOutputFile,
-		Config:     cfg,
-	})
+// snapshotDir is where retained builds for this root are kept. Rebuilds write
+// to a temp file, so the directory is pinned to the final output path.
+func (r *servedRoot) snapshotDir() string {
+	return r.outputFile + ".snapshots"
+}
+
+// parseServeRoots resolves the positional arguments into servedRoots.
+func parseServeRoots(args []string, outputFile string) ([]*servedRoot, error) {
+	if len(args) == 1 {
+		return []*servedRoot{{rootDir: args[0], outputFile: outputFile}}, nil
+	}
+
+	roots := make([]*servedRoot, 0, len(args))
+	seen := make(map[string]struct{}, len(args))
+	for _, arg := range args {
+		name, dir, ok := strings.Cut(arg, "=")
+		if !ok {
+			dir = arg
+			name = filepath.Base(filepath.Clean(arg))
+		}
+		if name == "" || dir == "" {
+			return nil, fmt.Errorf("invalid root %q: expected <dir> or <name>=<dir>", arg)
+		}
+		if _, dup := seen[name]; dup {
+			return nil, fmt.Errorf("duplicate database name %q; use name=path to disambiguate", name)
+		}
+		seen[name] = struct{}{}
+		roots = append(roots, &servedRoot{
+			name:       name,
+			rootDir:    dir,
+			outputFile: filepath.Join(outputFile, name+".db"),
+		})
+	}
+	return roots, nil
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	roots, err := parseServeRoots(args, serveOutputFile)
 	if err != nil {
-		return fmt.Errorf("initial build: %w", err)
+		return err
 	}
-	for _, w := range buildResult.Warnings {
-		fmt.Fprintln(os.Stderr, "warning:", w.Error())
+	if serveSampled, err = parseSample(serveSample, serveLimitPerTable); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		cfg, err := loadServeConfig(root.rootDir)
+		if err != nil {
+			return fmt.Errorf("%sloading config: %w", root.label(), err)
+		}
+		root.cfg = cfg
+	}
+	roots[0].primary = true
+	primary := roots[0].cfg
+
+	// Initial build.
+	for _, root := range roots {
+		fmt.Fprintf(cmd.OutOrStdout(), "%sBuilding database...\n", root.label())
+		buildResult, err := builder.Build(context.Background(), builder.Options{
+			RootDir:        root.rootDir,
+			OutputFile:     root.outputFile,
+			Config:         root.cfg,
+			SnapshotDir:    root.snapshotDir(),
+			Cache:          root.buildCache(),
+			Sample:         serveSampled,
+			MetricsHistory: serveMetricsHistory,
+			WarningsLog:    serveWarningsLog,
+		})
+		if err != nil {
+			return fmt.Errorf("%sinitial build: %w", root.label(), err)
+		}
+		for _, w := range buildResult.Warnings {
+			fmt.Fprintln(os.Stderr, "warning:", w.Error())
+		}
+		// The first build has nothing to diff against; create the
+		// changes table empty so it is always queryable.
+		root.builtAt = time.Now()
+		if err := changes.Record(root.outputFile, nil, root.builtAt); err != nil {
+			return fmt.Errorf("%srecording changes: %w", root.label(), err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%sBuilt %d records in %s\n", root.label(), buildResult.RecordsTotal, buildResult.Duration)
+		root.nextExpiry = buildResult.NextExpiry
+		root.warnings = buildResult.Warnings
 	}
-	fmt.Fprintf(cmd.OutOrStdout(), "Built %d records in %s\n", buildResult.RecordsTotal, buildResult.Duration)
 
 	// Resolve credentials from environment.
-	username := os.Getenv(cfg.UsernameEnvVar)
-	password := os.Getenv(cfg.PasswordEnvVar)
+	username := os.Getenv(primary.UsernameEnvVar)
+	password := os.Getenv(primary.PasswordEnvVar)
+
+	allowed, err := primary.QueryAllowlist()
+	if err != nil {
+		return err
+	}
+	users, err := serveUsers(primary)
+	if err != nil {
+		return err
+	}
+	accessLog, err := openAccessLog(roots[0].rootDir, primary.AccessLog)
+	if err != nil {
+		return err
+	}
+	if accessLog != nil {
+		defer accessLog.Close()
+	}
 
 	// Start PostgreSQL server.
-	srv, err := pgserver.New(pgserver.Options{
-		Port:     cfg.Port,
-		DBPath:   serveOutputFile,
-		Username: username,
-		Password: password,
-	})
+	srvOpts := pgserver.Options{
+		Port:            primary.Port,
+		Listen:          primary.Listen,
+		Username:        username,
+		Password:        password,
+		AuthMethod:      pgserver.AuthMethod(primary.AuthMethod),
+		Users:           users,
+		AllowedQueries:  allowed,
+		QueryCacheSize:  primary.QueryCacheSize,
+		QueryCacheBytes: primary.QueryCacheBytes,
+		MaxResultRows:   primary.MaxResultRows,
+		MaxResultBytes:  primary.MaxResultBytes,
+		Notice:          primary.Notice,
+	}
+	if accessLog != nil {
+		srvOpts.AccessLog = accesslog.New(accessLog, accesslog.Format(primary.AccessLog.Format))
+	}
+	if len(roots) == 1 {
+		srvOpts.DBPath = roots[0].outputFile
+	} else {
+		srvOpts.Databases = make(map[string]string, len(roots))
+		for _, root := range roots {
+			srvOpts.Databases[root.name] = root.outputFile
+		}
+	}
+	srv, err := pgserver.New(srvOpts)
 	if err != nil {
 		return fmt.Errorf("creating server: %w", err)
 	}
+	var httpSrv *httpserver.Server
+	if primary.HTTPPort > 0 {
+		httpSrv, err = httpserver.New(httpserver.Options{
+			Addrs:         httpAddrs(primary.Listen, primary.HTTPPort),
+			Backend:       srv,
+			MaxResultRows: primary.MaxResultRows,
+			CursorColumn:  primary.StandardColumns.ULID,
+		})
+		if err != nil {
+			return fmt.Errorf("creating HTTP server: %w", err)
+		}
+		defer httpSrv.Close()
+		for _, root := range roots {
+			httpSrv.SetWarnings(root.name, root.builtAt, root.warnings)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -94,50 +285,39 @@ func runServe(cmd *cobra.Command, args []string) error {
 		cancel()
 	}()
 
-	// Start server.
-	serverDone := make(chan error, 1)
+	// Start servers.
+	serverDone := make(chan error, 2)
 	go func() {
 		serverDone <- srv.Serve(ctx)
 	}()
+	if httpSrv != nil {
+		go func() {
+			serverDone <- httpSrv.Serve(ctx)
+		}()
+		fmt.Fprintf(cmd.OutOrStdout(), "Serving HTTP JSON API on port %d\n", primary.HTTPPort)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Serving on port %d (press Ctrl+C to stop)\n", primary.Port)
 
-	fmt.Fprintf(cmd.OutOrStdout(), "Serving on port %d (press Ctrl+C to stop)\n", cfg.Port)
-
-	// Set up file watcher.
-	watcherDone := make(chan error, 1)
-	w, err := watcher.New(rootDir, 300*time.Millisecond, func(wctx context.Context) error {
-		fmt.Fprintln(cmd.OutOrStdout(), "Change detected, rebuilding...")
-		tmpFile := serveOutputFile + ".tmp"
-		result, err := builder.Build(wctx, builder.Options{
-			RootDir:    rootDir,
-			OutputFile: tmpFile,
-			Config:     cfg,
+	// Set up one file watcher per root.
+	watcherDone := make(chan error, len(roots))
+	for _, root := range roots {
+		w, err := watcher.New(root.rootDir, 300*time.Millisecond, func(wctx context.Context) error {
+			return rebuildServedRoot(wctx, cmd, srv, httpSrv, root)
 		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "rebuild error: %v\n", err)
-			return err
-		}
-		for _, w := range result.Warnings {
-			fmt.Fprintln(os.Stderr, "warning:", w.Error())
-		}
-		// Atomically swap the file.
-		if err := os.Rename(tmpFile, serveOutputFile); err != nil {
-			return fmt.Errorf("swapping database: %w", err)
-		}
-		// Reload the server.
-		if err := srv.Reload(serveOutputFile); err != nil {
-			return fmt.Errorf("reloading server: %w", err)
+			return fmt.Errorf("%screating watcher: %w", root.label(), err)
 		}
-		fmt.Fprintf(cmd.OutOrStdout(), "Rebuilt %d records\n", result.RecordsTotal)
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("creating watcher: %w", err)
-	}
-	defer w.Close()
+		defer w.Close()
+		w.SetIgnore(root.cfg.Ignore)
+		root.watcher = w
+		// Rebuild when a row expires, so that it leaves the served database.
+		w.RebuildAt(root.nextExpiry)
 
-	go func() {
-		watcherDone <- w.Start(ctx)
-	}()
+		go func() {
+			watcherDone <- w.Start(ctx)
+		}()
+	}
 
 	// Wait for either server or watcher to finish.
 	select {
@@ -149,3 +329,230 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 }
+
+// loadServeConfig loads rootDir's sqlfs.yaml and applies the serve flags.
+func loadServeConfig(rootDir string) (*config.Config, error) {
+	cfg, err := config.Load(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// serve defaults to 'warn' for invalid behavior (unlike build which defaults to 'fail').
+	if serveInvalid == "" && cfg.Invalid == config.InvalidFail {
+		cfg = cfg.WithInvalid("warn")
+	} else {
+		cfg = cfg.WithInvalid(serveInvalid)
+	}
+	return cfg.WithPort(servePort).
+		WithListen(serveListen).
+		WithHTTPPort(serveHTTPPort).
+		WithKeepSnapshots(serveKeepSnapshots).
+		WithWebhook(serveWebhook).
+		WithBuildTimeout(serveBuildTimeout).
+		WithIncremental(serveIncremental).
+		WithSchema(serveSchema), nil
+}
+
+// restartSettings are the Config fields serve only reads at startup.
+var restartSettings = map[string]bool{
+	"Port":            true,
+	"Listen":          true,
+	"HTTPPort":        true,
+	"UsernameEnvVar":  true,
+	"PasswordEnvVar":  true,
+	"AuthMethod":      true,
+	"Users":           true,
+	"AllowedQueries":  true,
+	"QueryCacheSize":  true,
+	"QueryCacheBytes": true,
+	"MaxResultRows":   true,
+	"MaxResultBytes":  true,
+	"Notice":          true,
+	"AccessLog":       true,
+}
+
+// reloadServeConfig reloads the root's sqlfs.yaml, logging which settings
+// changed. Build settings apply from the rebuild that follows; server
+// settings only take effect after a restart. On error the old configuration
+// is kept.
+func reloadServeConfig(cmd *cobra.Command, root *servedRoot) error {
+	cfg, err := loadServeConfig(root.rootDir)
+	if err != nil {
+		return fmt.Errorf("reloading config: %w", err)
+	}
+	var applied, restart []string
+	for _, name := range root.cfg.Changed(cfg) {
+		if !restartSettings[name] {
+			applied = append(applied, name)
+		} else if root.primary {
+			restart = append(restart, name)
+		}
+	}
+	if len(applied) > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "%sConfig changed: %s\n", root.label(), strings.Join(applied, ", "))
+	}
+	if len(restart) > 0 {
+		fmt.Fprintf(os.Stderr, "%swarning: config changes take effect after a restart: %s\n", root.label(), strings.Join(restart, ", "))
+	}
+	root.cfg = cfg
+	root.watcher.SetIgnore(cfg.Ignore)
+	return nil
+}
+
+// rebuildSettleTime is how long after its last modification a file that
+// fails to parse is retried during a rebuild, in case an editor is still
+// writing it.
+const rebuildSettleTime = 2 * time.Second
+
+// rebuildServedRoot reloads one root's config, rebuilds it into a temp file,
+// and, if the new file passes builder.Verify, swaps it into place and reloads
+// the servers' handles for it. httpSrv is nil when the HTTP API is disabled.
+func rebuildServedRoot(ctx context.Context, cmd *cobra.Command, srv *pgserver.Server, httpSrv *httpserver.Server, root *servedRoot) error {
+	fmt.Fprintf(cmd.OutOrStdout(), "%sChange detected, rebuilding...\n", root.label())
+	if err := reloadServeConfig(cmd, root); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v\n", root.label(), err)
+		return err
+	}
+	tmpFile := root.outputFile + ".tmp"
+	// The temp file is removed on every path but a successful swap.
+	swapped := false
+	defer func() {
+		if !swapped {
+			os.Remove(tmpFile)
+		}
+	}()
+	// The snapshot is saved once the new database has passed its health
+	// check, so a discarded one never takes a good one's place.
+	buildOpts := builder.Options{
+		RootDir:        root.rootDir,
+		OutputFile:     tmpFile,
+		Config:         root.cfg,
+		SnapshotDir:    root.snapshotDir(),
+		DeferSnapshot:  true,
+		Cache:          root.buildCache(),
+		SettleTime:     rebuildSettleTime,
+		Sample:         serveSampled,
+		MetricsHistory: serveMetricsHistory,
+		WarningsLog:    serveWarningsLog,
+	}
+	result, err := builder.Build(ctx, buildOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%srebuild error: %v\n", root.label(), err)
+		return err
+	}
+	for _, w := range result.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w.Error())
+	}
+	root.watcher.RebuildAt(result.NextExpiry)
+
+	// Keep serving the previous database if the new one looks broken.
+	if err := builder.Verify(tmpFile, result); err != nil {
+		fmt.Fprintf(os.Stderr, "%sWARNING: the rebuilt database failed its health check and was discarded; still serving the previous one: %v\n", root.label(), err)
+		return fmt.Errorf("health check: %w", err)
+	}
+
+	// Diff against the database being served and record the delta in the new one.
+	sc := root.cfg.StandardColumns
+	delta, err := changes.Diff(root.outputFile, tmpFile, changes.Options{
+		KeyColumns:    []string{sc.PK, sc.Path},
+		IgnoreColumns: []string{sc.ULID},
+	})
+	if err != nil {
+		return fmt.Errorf("diffing builds: %w", err)
+	}
+	builtAt := time.Now()
+	if err := changes.Record(tmpFile, delta, builtAt); err != nil {
+		return fmt.Errorf("recording changes: %w", err)
+	}
+
+	// Atomically swap the file.
+	if err := os.Rename(tmpFile, root.outputFile); err != nil {
+		return fmt.Errorf("swapping database: %w", err)
+	}
+	swapped = true
+	if err := builder.SaveSnapshot(buildOpts, root.outputFile, result); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v\n", root.label(), err)
+	}
+	// Reload the server.
+	if err := srv.ReloadDatabase(root.name, root.outputFile); err != nil {
+		return fmt.Errorf("reloading server: %w", err)
+	}
+	root.builtAt, root.warnings = builtAt, result.Warnings
+	if httpSrv != nil {
+		httpSrv.SetWarnings(root.name, root.builtAt, root.warnings)
+	}
+
+	ev := changes.NewEvent(root.name, delta, builtAt)
+	srv.Notify(root.name, changes.Channel, ev.NotifyPayload())
+	if root.cfg.WebhookURL != "" {
+		if err := changes.PostWebhook(ctx, root.cfg.WebhookURL, ev); err != nil {
+			fmt.Fprintf(os.Stderr, "%swebhook error: %v\n", root.label(), err)
+		}
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%sRebuilt %d records (%d inserted, %d updated, %d deleted)\n",
+		root.label(), result.RecordsTotal, ev.Inserts, ev.Updates, ev.Deletes)
+	return nil
+}
+
+// serveUsers resolves the passwords of the users in cfg from the environment.
+// Every user must have a password, since their row filters are only as safe
+// as their login.
+func serveUsers(cfg *config.Config) (map[string]pgserver.User, error) {
+	users := make(map[string]pgserver.User, len(cfg.Users))
+	for name, u := range cfg.Users {
+		password := ""
+		if u.Password != "" {
+			password = os.Getenv(u.Password)
+		}
+		if password == "" {
+			return nil, fmt.Errorf("users.%s: password environment variable %q is not set", name, u.Password)
+		}
+		users[name] = pgserver.User{Password: password, RowFilters: u.RowFilters}
+	}
+	return users, nil
+}
+
+// httpAddrs returns the addresses the HTTP JSON API listens on: port on the
+// host of each TCP address in listen or, when there are none, on every
+// interface.
+func httpAddrs(listen []string, port int) []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, addr := range listen {
+		if strings.HasPrefix(addr, "unix:") {
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || seen[host] {
+			continue
+		}
+		seen[host] = true
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	if len(addrs) == 0 {
+		addrs = []string{fmt.Sprintf("0.0.0.0:%d", port)}
+	}
+	return addrs
+}
+
+// openAccessLog opens the access log file described by cfg, resolving a
+// relative path against rootDir. It returns nil when the access log is
+// disabled; "-" logs to standard output.
+func openAccessLog(rootDir string, cfg config.AccessLog) (io.WriteCloser, error) {
+	switch cfg.Path {
+	case "":
+		return nil, nil
+	case "-":
+		return nopCloser{os.Stdout}, nil
+	}
+	path := cfg.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+	return accesslog.OpenRotating(path, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/encrypt"
+	"github.com/notwillk/sqlfs/internal/pgserver"
+	"github.com/notwillk/sqlfs/internal/snapshot"
+)
+
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "Inspect builds retained with --keep-snapshots",
+	Long: `List or serve previous build outputs retained by build or serve with
+--keep-snapshots (or snapshots.keep in sqlfs.yaml). Snapshots live in
+<output-file>.snapshots next to the output database.`,
+}
+
+var snapshotsListCmd = &cobra.Command{
+	Use:   "list <dir>",
+	Short: "List retained snapshots, newest first",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSnapshotsList,
+}
+
+var snapshotsServeCmd = &cobra.Command{
+	Use:   "serve <dir> <id>",
+	Short: "Serve a retained snapshot via PostgreSQL wire protocol",
+	Long: `Serve one retained snapshot read-only. <id> may be any unique prefix of
+the snapshot ID. Credentials are read from SQLFS_USERNAME and SQLFS_PASSWORD.
+A snapshot of an encrypted build is decrypted, to a temporary file removed on
+exit, with the key in the environment variable named by --key-env (default:
+SQLFS_ENCRYPTION_KEY).`,
+	Args: cobra.ExactArgs(2),
+	RunE: runSnapshotsServe,
+}
+
+var snapshotsServePort int
+var snapshotsServeKeyEnv string
+
+func init() {
+	snapshotsServeCmd.Flags().IntVar(&snapshotsServePort, "port", 0, "Port to serve on (default: 5432)")
+	snapshotsServeCmd.Flags().StringVar(&snapshotsServeKeyEnv, "key-env", "SQLFS_ENCRYPTION_KEY", "Environment variable holding the key of an encrypted snapshot")
+	snapshotsCmd.AddCommand(snapshotsListCmd, snapshotsServeCmd)
+}
+
+func runSnapshotsList(cmd *cobra.Command, args []string) error {
+	entries, err := snapshot.List(args[0])
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No snapshots in %s\n", args[0])
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tRECORDS\tTABLES\tROOT")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", e.ID, e.CreatedAt.Local().Format("2006-01-02 15:04:05"), e.Records, e.Tables, e.RootDir)
+	}
+	return tw.Flush()
+}
+
+func runSnapshotsServe(cmd *cobra.Command, args []string) error {
+	entry, dbPath, err := snapshot.Find(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	dbPath, cleanup, err := decryptedSnapshot(dbPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cfg := config.Default().WithPort(snapshotsServePort)
+	srv, err := pgserver.New(pgserver.Options{
+		Port:     cfg.Port,
+		DBPath:   dbPath,
+		Username: os.Getenv(cfg.UsernameEnvVar),
+		Password: os.Getenv(cfg.PasswordEnvVar),
+	})
+	if err != nil {
+		return fmt.Errorf("creating server: %w", err)
+	}
+	defer srv.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Serving snapshot %s (%s) on port %d (press Ctrl+C to stop)\n",
+		entry.ID, entry.CreatedAt.Local().Format("2006-01-02 15:04:05"), cfg.Port)
+	return srv.Serve(ctx)
+}
+
+// decryptedSnapshot returns the path of a plain copy of the snapshot at path,
+// decrypting it to a private temporary directory when it is encrypted, and a
+// function removing that copy.
+func decryptedSnapshot(path string) (string, func(), error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer in.Close()
+	head := make([]byte, 16)
+	n, _ := io.ReadFull(in, head)
+	if !encrypt.IsEncrypted(head[:n]) {
+		return path, func() {}, nil
+	}
+	key := os.Getenv(snapshotsServeKeyEnv)
+	if key == "" {
+		return "", nil, fmt.Errorf("the snapshot is encrypted and environment variable %s is not set", snapshotsServeKeyEnv)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+	plain, err := encrypt.NewReader(in, key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "sqlfs-snapshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out, err := os.OpenFile(filepath.Join(dir, "snapshot.db"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = io.Copy(out, plain)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("decrypting snapshot: %w", err)
+	}
+	return out.Name(), cleanup, nil
+}
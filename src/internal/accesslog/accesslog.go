@@ -0,0 +1,232 @@
+// Package accesslog records one line per query answered by serve: who ran it,
+// from where and with which application, a fingerprint of the query, how
+// many rows it returned, and how long it took. Logs can be written to a file
+// that rotates by size.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format selects how entries are written.
+type Format string
+
+const (
+	// FormatCombined writes lines modeled on the Apache combined log format.
+	FormatCombined Format = "combined"
+	// FormatJSON writes one JSON object per line.
+	FormatJSON Format = "json"
+)
+
+// Entry describes one query.
+type Entry struct {
+	Time        time.Time     `json:"time"`
+	Client      string        `json:"client"`
+	User        string        `json:"user"`
+	Database    string        `json:"database"`
+	Application string        `json:"application,omitempty"`
+	Fingerprint string        `json:"fingerprint"`
+	Rows        int           `json:"rows"`
+	Duration    time.Duration `json:"-"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Logger writes entries to a writer. It is safe for concurrent use.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// New returns a Logger writing entries to w in the given format.
+func New(w io.Writer, format Format) *Logger {
+	return &Logger{w: w, format: format}
+}
+
+// Log writes e. Write errors are ignored: a failing access log must not fail
+// the query it describes.
+func (l *Logger) Log(e Entry) {
+	line := l.encode(e)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line) //nolint:errcheck
+}
+
+func (l *Logger) encode(e Entry) []byte {
+	if l.format == FormatJSON {
+		line, _ := json.Marshal(struct {
+			Entry
+			DurationMS float64 `json:"duration_ms"`
+		}{e, float64(e.Duration.Microseconds()) / 1000})
+		return append(line, '\n')
+	}
+
+	client, user, db, app := e.Client, e.User, e.Database, e.Application
+	for _, s := range []*string{&client, &user, &db, &app} {
+		if *s == "" {
+			*s = "-"
+		}
+	}
+	status := "ok"
+	if e.Error != "" {
+		status = "error"
+	}
+	return fmt.Appendf(nil, "%s - %s [%s] %q %s %d %.3fms %q %q\n",
+		client, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Fingerprint, status, e.Rows, float64(e.Duration.Microseconds())/1000, db, app)
+}
+
+// Fingerprint normalizes query so that queries differing only in their
+// literal values share one fingerprint: string and numeric literals become
+// ?, runs of whitespace become single spaces, and trailing semicolons are
+// dropped. Quoted identifiers are kept as written.
+func Fingerprint(query string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = b.Len() > 0
+			i++
+			continue
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			c = '?'
+		case c == '"':
+			end := skipQuoted(query, i, '"')
+			writeSpace(&b, &space)
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		case isDigit(c) && (i == 0 || !isIdentByte(query[i-1])):
+			for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+				i++
+			}
+			c = '?'
+		case isIdentByte(c):
+			start := i
+			for i < len(query) && isIdentByte(query[i]) {
+				i++
+			}
+			writeSpace(&b, &space)
+			b.WriteString(query[start:i])
+			continue
+		default:
+			i++
+		}
+		writeSpace(&b, &space)
+		b.WriteByte(c)
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+func writeSpace(b *strings.Builder, pending *bool) {
+	if *pending {
+		b.WriteByte(' ')
+		*pending = false
+	}
+}
+
+// skipQuoted returns the index just past the quoted section starting at
+// query[i], treating a doubled quote as an escaped one.
+func skipQuoted(query string, i int, quote byte) int {
+	for i++; i < len(query); i++ {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// RotatingFile is an append-only log file that is renamed aside once it
+// reaches a size limit. Backups are named <path>.1 (newest) to <path>.<n>.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+// OpenRotating opens path for appending, rotating it whenever a write would
+// take it past maxSize bytes and keeping at most backups old files.
+func OpenRotating(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening access log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening access log: %w", err)
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating first if p would not fit.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one, moves the current file to <path>.1,
+// and starts a new file. With no backups kept, the file is truncated.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups)) //nolint:errcheck
+		for n := r.backups - 1; n >= 1; n-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, n), fmt.Sprintf("%s.%d", r.path, n+1)) //nolint:errcheck
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return fmt.Errorf("rotating access log: %w", err)
+		}
+	} else if err := os.Truncate(r.path, 0); err != nil {
+		return fmt.Errorf("rotating access log: %w", err)
+	}
+	return r.open()
+}
+
+// Close closes the current file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
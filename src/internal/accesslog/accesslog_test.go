@@ -0,0 +1,78 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct{ query, want string }{
+		{"SELECT * FROM posts WHERE id = 42;", "SELECT * FROM posts WHERE id = ?"},
+		{"select  title\n  from posts where author = 'O''Brien' and score > 3.5", "select title from posts where author = ? and score > ?"},
+		{`SELECT "col 1", t2.x FROM t2 WHERE v IN (1, 2)`, `SELECT "col 1", t2.x FROM t2 WHERE v IN (?, ?)`},
+	}
+	for _, tt := range tests {
+		if got := Fingerprint(tt.query); got != tt.want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLogger_Formats(t *testing.T) {
+	e := Entry{
+		Time:        time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC),
+		Client:      "10.0.0.7:51234",
+		User:        "alice",
+		Database:    "blog",
+		Application: "psql",
+		Fingerprint: "SELECT * FROM posts WHERE id = ?",
+		Rows:        1,
+		Duration:    1500 * time.Microsecond,
+	}
+
+	var combined strings.Builder
+	New(&combined, FormatCombined).Log(e)
+	want := `10.0.0.7:51234 - alice [01/May/2024:09:30:00 +0000] "SELECT * FROM posts WHERE id = ?" ok 1 1.500ms "blog" "psql"` + "\n"
+	if combined.String() != want {
+		t.Errorf("combined:\n got %q\nwant %q", combined.String(), want)
+	}
+
+	var js strings.Builder
+	e.Error = "boom"
+	New(&js, FormatJSON).Log(e)
+	var got map[string]any
+	if err := json.Unmarshal([]byte(js.String()), &got); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if got["user"] != "alice" || got["rows"] != 1.0 || got["duration_ms"] != 1.5 || got["error"] != "boom" || got["application"] != "psql" {
+		t.Errorf("json entry = %v", got)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotating(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{"": "four\n", ".1": "three\n", ".2": "one\ntwo\n"} {
+		data, err := os.ReadFile(path + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("access.log%s = %q, want %q", name, data, want)
+		}
+	}
+}
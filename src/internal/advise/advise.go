@@ -0,0 +1,128 @@
+// Package advise suggests indexes that a DBML schema does not declare and
+// explains the plans SQLite chooses for queries.
+package advise
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/dbml"
+)
+
+// Suggestion is an index the schema would likely benefit from.
+type Suggestion struct {
+	Table  string
+	Column string
+	// Unique is set for the referencing column of a one-to-one relationship
+	// whose values are all distinct, which a unique index can enforce.
+	Unique bool
+	Reason string
+	// Rows and Distinct count the table's rows and the column's distinct
+	// values in the built database; both are -1 when no database was given.
+	Rows     int64
+	Distinct int64
+}
+
+// Indexes suggests an index for every column on either side of a relationship
+// in s that no primary key, unique constraint or index already covers:
+// referencing columns are joined on, and referenced columns that are not
+// unique are looked up. When db, a database built from s, is non-nil, each
+// suggestion carries the column's cardinality. Suggestions are sorted by
+// table and column.
+func Indexes(s *dbml.Schema, db *sql.DB) ([]Suggestion, error) {
+	seen := make(map[string]bool)
+	var out []Suggestion
+	add := func(ep dbml.RefEndpoint, unique bool, reason string) {
+		t := s.TableByName(ep.Table)
+		if t == nil || t.ColumnByName(ep.Column) == nil || covered(t, ep.Column) {
+			return
+		}
+		key := strings.ToLower(t.Name + "." + ep.Column)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		out = append(out, Suggestion{Table: t.Name, Column: ep.Column, Unique: unique, Reason: reason, Rows: -1, Distinct: -1})
+	}
+	for _, ref := range s.Relationships() {
+		add(ref.From, ref.Relation == dbml.OneToOne, fmt.Sprintf("references %s.%s", ref.To.Table, ref.To.Column))
+		add(ref.To, false, fmt.Sprintf("referenced by %s.%s", ref.From.Table, ref.From.Column))
+	}
+
+	if db != nil {
+		for i := range out {
+			sg := &out[i]
+			query := fmt.Sprintf(`SELECT count(*), count(DISTINCT %s) FROM %s`, quote(sg.Column), quote(sg.Table))
+			if err := db.QueryRow(query).Scan(&sg.Rows, &sg.Distinct); err != nil {
+				return nil, fmt.Errorf("measuring %s.%s: %w", sg.Table, sg.Column, err)
+			}
+			sg.Unique = sg.Unique && sg.Rows > 0 && sg.Distinct == sg.Rows
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Table != out[j].Table {
+			return out[i].Table < out[j].Table
+		}
+		return out[i].Column < out[j].Column
+	})
+	return out, nil
+}
+
+// covered reports whether lookups by column of t can already use an index:
+// the column is unique or a primary key, or leads a composite primary key or
+// a full index.
+func covered(t *dbml.Table, column string) bool {
+	if t.IsUnique(column) {
+		return true
+	}
+	if pk := t.PrimaryKey(); len(pk) > 0 && strings.EqualFold(pk[0], column) {
+		return true
+	}
+	for _, idx := range t.Indexes {
+		if !idx.IsExpr && idx.Where == "" && len(idx.Columns) > 0 && strings.EqualFold(idx.Columns[0], column) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snippet returns, for each table with suggestions, a DBML indexes block to
+// paste into the table's definition.
+func Snippet(suggestions []Suggestion) string {
+	var b strings.Builder
+	for i, sg := range suggestions {
+		if i == 0 || suggestions[i-1].Table != sg.Table {
+			if i > 0 {
+				b.WriteString("}\n\n")
+			}
+			fmt.Fprintf(&b, "// Table %s\nindexes {\n", sg.Table)
+		}
+		settings := ""
+		if sg.Unique {
+			settings = " [unique]"
+		}
+		fmt.Fprintf(&b, "  %s%s\n", dbmlName(sg.Column), settings)
+	}
+	if len(suggestions) > 0 {
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// dbmlName returns name as a DBML identifier, quoted unless it is a plain word.
+func dbmlName(name string) string {
+	for i, r := range name {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9' {
+			continue
+		}
+		return `"` + name + `"`
+	}
+	return name
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
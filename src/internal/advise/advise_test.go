@@ -0,0 +1,122 @@
+package advise
+
+import (
+	"testing"
+
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+const testSchema = `
+Table users {
+  id integer [pk]
+  email varchar [unique]
+}
+
+Table posts {
+  id integer [pk]
+  author_id integer [ref: > users.id]
+  category varchar [ref: > categories.slug]
+}
+
+Table profiles {
+  id integer [pk]
+  user_id integer [ref: - users.id]
+}
+
+Table comments {
+  id integer [pk]
+  post_id integer [ref: > posts.id]
+
+  indexes {
+    post_id
+  }
+}
+
+Table categories {
+  slug varchar
+}
+`
+
+func parse(t *testing.T) *dbml.Schema {
+	t.Helper()
+	s, err := dbml.Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestIndexes(t *testing.T) {
+	got, err := Indexes(parse(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Suggestion{
+		{Table: "categories", Column: "slug", Reason: "referenced by posts.category", Rows: -1, Distinct: -1},
+		{Table: "posts", Column: "author_id", Reason: "references users.id", Rows: -1, Distinct: -1},
+		{Table: "posts", Column: "category", Reason: "references categories.slug", Rows: -1, Distinct: -1},
+		{Table: "profiles", Column: "user_id", Unique: true, Reason: "references users.id", Rows: -1, Distinct: -1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d suggestions, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("suggestion %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestIndexes_Cardinality(t *testing.T) {
+	db, err := sqlite.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ExecDDL([]string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, author_id INTEGER, category TEXT)`,
+		`CREATE TABLE profiles (id INTEGER PRIMARY KEY, user_id INTEGER)`,
+		`CREATE TABLE comments (id INTEGER PRIMARY KEY, post_id INTEGER)`,
+		`CREATE TABLE categories (slug TEXT)`,
+		`INSERT INTO posts VALUES (1, 1, 'a'), (2, 1, 'a'), (3, 2, 'b')`,
+		`INSERT INTO profiles VALUES (1, 1), (2, 1)`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Indexes(parse(t), db.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string][2]int64{}
+	for _, sg := range got {
+		counts[sg.Table+"."+sg.Column] = [2]int64{sg.Rows, sg.Distinct}
+		if sg.Table == "profiles" && sg.Unique {
+			t.Error("profiles.user_id has duplicate values; want Unique false")
+		}
+	}
+	if c := counts["posts.author_id"]; c != [2]int64{3, 2} {
+		t.Errorf("posts.author_id rows, distinct = %v, want [3 2]", c)
+	}
+	if c := counts["categories.slug"]; c != [2]int64{0, 0} {
+		t.Errorf("categories.slug rows, distinct = %v, want [0 0]", c)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	got := Snippet([]Suggestion{
+		{Table: "posts", Column: "author_id"},
+		{Table: "posts", Column: "category"},
+		{Table: "profiles", Column: "user id", Unique: true},
+	})
+	want := "// Table posts\nindexes {\n  author_id\n  category\n}\n\n" +
+		"// Table profiles\nindexes {\n  \"user id\" [unique]\n}\n"
+	if got != want {
+		t.Errorf("Snippet =\n%s\nwant\n%s", got, want)
+	}
+	if got := Snippet(nil); got != "" {
+		t.Errorf("Snippet(nil) = %q, want empty", got)
+	}
+}
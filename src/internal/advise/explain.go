@@ -0,0 +1,142 @@
+package advise
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PlanStep is one step of the plan SQLite chose for a query.
+type PlanStep struct {
+	// Detail is the step as EXPLAIN QUERY PLAN words it, e.g.
+	// "SEARCH users USING INDEX users_email (email=?)".
+	Detail string
+	// Depth is the step's nesting below the top of the plan, for subqueries
+	// and compound queries.
+	Depth int
+	// Note says in plain words what the step does.
+	Note string
+	// Hint, when set, suggests an index that would make the step cheaper.
+	Hint string
+}
+
+var (
+	scanStep   = regexp.MustCompile(`^(SCAN|SEARCH) (?:TABLE )?(\S+)(?: AS \S+)?(?: USING (.*))?$`)
+	autoIndex  = regexp.MustCompile(`^AUTOMATIC (?:PARTIAL )?(?:COVERING )?INDEX \((.*)\)$`)
+	usedIndex  = regexp.MustCompile(`^(COVERING )?INDEX (\S+)(?: \((.*)\))?$`)
+	indexTerm  = regexp.MustCompile(`(\w+|"[^"]*")[=<>]`)
+	tempBTree  = regexp.MustCompile(`^USE TEMP B-TREE FOR (?:(?:LAST|RIGHT PART OF) )?(ORDER BY|GROUP BY|DISTINCT)$`)
+	subqueries = regexp.MustCompile(`^(CORRELATED )?(SCALAR|LIST) SUBQUERY`)
+)
+
+// Explain returns the plan SQLite chooses for query on db, from EXPLAIN
+// QUERY PLAN, with each step annotated. The query is planned, not run.
+func Explain(db *sql.DB, query string) ([]PlanStep, error) {
+	rows, err := db.Query("EXPLAIN QUERY PLAN " + query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	depth := map[int]int{0: -1}
+	var out []PlanStep
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return nil, err
+		}
+		depth[id] = depth[parent] + 1
+		step := PlanStep{Detail: detail, Depth: depth[id]}
+		step.Note, step.Hint = annotate(detail)
+		out = append(out, step)
+	}
+	return out, rows.Err()
+}
+
+// annotate explains a step of a query plan and, where an index would help,
+// suggests one.
+func annotate(detail string) (note, hint string) {
+	if m := scanStep.FindStringSubmatch(detail); m != nil {
+		table, using := m[2], m[3]
+		if m[1] == "SCAN" {
+			switch im := usedIndex.FindStringSubmatch(using); {
+			case using == "":
+				return fmt.Sprintf("reads every row of %s", table),
+					fmt.Sprintf("if the query filters or joins %s on a column, an index on that column in the table's indexes block lets SQLite search it instead", table)
+			case im != nil && im[1] != "":
+				return fmt.Sprintf("reads all of index %s instead of the rows of %s; the index holds every column the query needs", im[2], table), ""
+			case im != nil:
+				return fmt.Sprintf("reads every row of %s in the order of index %s", table, im[2]), ""
+			default:
+				return fmt.Sprintf("reads every row of %s", table), ""
+			}
+		}
+		switch {
+		case strings.HasPrefix(using, "INTEGER PRIMARY KEY") || strings.HasPrefix(using, "PRIMARY KEY"):
+			return fmt.Sprintf("looks up rows of %s by primary key", table), ""
+		case autoIndex.MatchString(using):
+			cols := indexColumns(autoIndex.FindStringSubmatch(using)[1])
+			return fmt.Sprintf("builds a temporary index on %s for this query only", table),
+				fmt.Sprintf("declare it in table %s: indexes { %s }", table, indexSpec(cols))
+		}
+		if im := usedIndex.FindStringSubmatch(using); im != nil {
+			note = fmt.Sprintf("looks up rows of %s through index %s", table, im[2])
+			if cols := indexColumns(im[3]); len(cols) > 0 {
+				note += " on " + strings.Join(cols, ", ")
+			}
+			if im[1] != "" {
+				note += "; the index holds every column the query needs"
+			}
+			return note, ""
+		}
+		return fmt.Sprintf("looks up rows of %s", table), ""
+	}
+
+	if m := tempBTree.FindStringSubmatch(detail); m != nil {
+		return fmt.Sprintf("sorts rows in a temporary b-tree for %s", m[1]),
+			fmt.Sprintf("an index on the %s columns, in the same order, lets SQLite read the rows already sorted", m[1])
+	}
+	if m := subqueries.FindStringSubmatch(detail); m != nil {
+		if m[1] != "" {
+			return "runs the subquery again for every row of the outer query", ""
+		}
+		return "runs the subquery once", ""
+	}
+	switch {
+	case detail == "COMPOUND QUERY":
+		return "combines the results of several SELECTs", ""
+	case strings.HasPrefix(detail, "MULTI-INDEX OR"):
+		return "looks up each side of an OR separately and merges the results", ""
+	case strings.HasPrefix(detail, "CO-ROUTINE"), strings.HasPrefix(detail, "MATERIALIZE"):
+		return "computes a subquery or view used as a table", ""
+	case detail == "CREATE BLOOM FILTER":
+		return "builds a filter that skips lookups bound to miss", ""
+	}
+	return "", ""
+}
+
+// indexColumns returns the columns named by the constraints of an index
+// step, such as "(author_id=? AND created_at>?)".
+func indexColumns(terms string) []string {
+	var cols []string
+	for _, m := range indexTerm.FindAllStringSubmatch(terms, -1) {
+		if m[1] != "rowid" {
+			cols = append(cols, strings.Trim(m[1], `"`))
+		}
+	}
+	return cols
+}
+
+// indexSpec returns cols as the entry of a DBML indexes block.
+func indexSpec(cols []string) string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = dbmlName(c)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
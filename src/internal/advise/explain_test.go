@@ -0,0 +1,94 @@
+package advise
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+func TestExplain(t *testing.T) {
+	db, err := sqlite.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ExecDDL([]string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, author_id INTEGER, title TEXT)`,
+		`CREATE INDEX posts_author_id ON posts (author_id)`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query, detail, note, hint string
+	}{
+		{`SELECT * FROM users WHERE name = 'a'`, "SCAN users", "reads every row of users", "an index on that column"},
+		{`SELECT * FROM users WHERE id = 1`, "SEARCH users", "by primary key", ""},
+		{`SELECT author_id FROM posts WHERE author_id = 1`, "SEARCH posts", "through index posts_author_id on author_id; the index holds", ""},
+		{`SELECT * FROM posts p JOIN users u ON u.name = p.title`, "AUTOMATIC", "temporary index", "indexes { name }"},
+		{`SELECT name FROM users ORDER BY name`, "USE TEMP B-TREE FOR ORDER BY", "temporary b-tree for ORDER BY", "ORDER BY columns"},
+	}
+	for _, tt := range tests {
+		steps, err := Explain(db.DB(), tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var found bool
+		for _, st := range steps {
+			if !strings.Contains(st.Detail, tt.detail) {
+				continue
+			}
+			found = true
+			if !strings.Contains(st.Note, tt.note) {
+				t.Errorf("%s: note for %q = %q, want it to contain %q", tt.query, st.Detail, st.Note, tt.note)
+			}
+			if tt.hint == "" && st.Hint != "" || !strings.Contains(st.Hint, tt.hint) {
+				t.Errorf("%s: hint for %q = %q, want %q", tt.query, st.Detail, st.Hint, tt.hint)
+			}
+		}
+		if !found {
+			t.Errorf("%s: no step contains %q in %+v", tt.query, tt.detail, steps)
+		}
+	}
+}
+
+func TestExplain_Depth(t *testing.T) {
+	db, err := sqlite.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ExecDDL([]string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, author_id INTEGER)`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := Explain(db.DB(), `SELECT (SELECT count(*) FROM posts WHERE author_id = users.id) FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depths := map[string]int{}
+	for _, st := range steps {
+		depths[st.Detail] = st.Depth
+	}
+	if d := depths["SCAN users"]; d != 0 {
+		t.Errorf("SCAN users depth = %d, want 0", d)
+	}
+	if d, ok := depths["SCAN posts"]; !ok || d != 1 {
+		t.Errorf("SCAN posts depth = %d (present %v), want 1; steps %+v", d, ok, steps)
+	}
+}
+
+func TestExplain_Error(t *testing.T) {
+	db, err := sqlite.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := Explain(db.DB(), `SELECT * FROM missing`); err == nil {
+		t.Error("want an error for a missing table")
+	}
+}
@@ -0,0 +1,122 @@
+package builder
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/loader"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// AssetsTable lists, when markdown_assets is set, the local files that the
+// bodies of Markdown files link to, so that broken links can be found with
+// SQL.
+const AssetsTable = "__sqlfs_assets__"
+
+var (
+	// Inline links and images: [text](target "title") and ![alt](target).
+	inlineLinkRe = regexp.MustCompile(`!?\[[^\]]*\]\(\s*(<[^>]*>|[^)\s]+)`)
+	// Link reference definitions: [id]: target "title".
+	refLinkRe = regexp.MustCompile(`(?m)^ {0,3}\[[^\]]+\]:\s*(<[^>]*>|\S+)`)
+	// HTML elements that load or link to a file.
+	htmlLinkRe = regexp.MustCompile(`(?i)<(?:a|img|source|video|audio)\b[^>]*?\s(?:href|src)\s*=\s*["']([^"']+)["']`)
+	// Code, whose links are not links.
+	fencedCodeRe = regexp.MustCompile("(?ms)^ {0,3}```.*?^ {0,3}```|^ {0,3}~~~.*?^ {0,3}~~~")
+	inlineCodeRe = regexp.MustCompile("`[^`\n]+`")
+	urlSchemeRe  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+)
+
+// isMarkdown reports whether relPath is a Markdown file, compressed or not.
+func isMarkdown(relPath string) bool {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(relPath), ".gz")))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// markdownAssets returns the local files that the bodies of fr, loaded from
+// the Markdown file at relPath, link to, as slash-separated paths relative
+// to the root, in the order first linked. Links to URLs and to anchors in
+// the same file are left out. It returns nil unless cfg.MarkdownAssets is
+// set.
+func markdownAssets(cfg *config.Config, relPath string, fr *loader.FileRecord) []string {
+	if !cfg.MarkdownAssets || !isMarkdown(relPath) {
+		return nil
+	}
+	dir := path.Dir(filepath.ToSlash(relPath))
+	var assets []string
+	seen := make(map[string]bool)
+	for _, rec := range fr.Records {
+		body, _ := rec.Fields[cfg.MarkdownBody].(string)
+		body = fencedCodeRe.ReplaceAllString(body, "")
+		body = inlineCodeRe.ReplaceAllString(body, "")
+		for _, re := range []*regexp.Regexp{inlineLinkRe, refLinkRe, htmlLinkRe} {
+			for _, m := range re.FindAllStringSubmatch(body, -1) {
+				p, ok := assetPath(dir, m[1])
+				if ok && !seen[p] {
+					seen[p] = true
+					assets = append(assets, p)
+				}
+			}
+		}
+	}
+	return assets
+}
+
+// assetPath resolves the link target, written in a file in dir, to a path
+// relative to the root. A target starting with "/" is relative to the root.
+func assetPath(dir, target string) (string, bool) {
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		target = target[:i]
+	}
+	if target == "" || strings.HasPrefix(target, "//") || urlSchemeRe.MatchString(target) {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	if strings.HasPrefix(target, "/") {
+		return path.Clean(strings.TrimPrefix(target, "/")), true
+	}
+	return path.Clean(path.Join(dir, target)), true
+}
+
+// createAssetsTable replaces AssetsTable with the assets linked to by each
+// file in files, recording whether each exists under rootDir.
+func createAssetsTable(db *sqlite.DB, rootDir string, files []fileAssets) error {
+	if err := db.Exec("DROP TABLE IF EXISTS " + sqliteQuote(AssetsTable)); err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE TABLE " + sqliteQuote(AssetsTable) +
+		` ("path" TEXT NOT NULL, "referenced_by" TEXT NOT NULL, "exists" INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("creating %s: %w", AssetsTable, err)
+	}
+	exists := make(map[string]bool)
+	for _, f := range files {
+		for _, p := range f.assets {
+			ok, checked := exists[p]
+			if !checked {
+				_, err := os.Stat(filepath.Join(rootDir, filepath.FromSlash(p)))
+				ok = err == nil
+				exists[p] = ok
+			}
+			if err := db.Exec("INSERT INTO "+sqliteQuote(AssetsTable)+` ("path", "referenced_by", "exists") VALUES (?, ?, ?)`,
+				p, f.relPath, ok); err != nil {
+				return fmt.Errorf("filling %s: %w", AssetsTable, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fileAssets are the assets linked to by the file at relPath, a
+// slash-separated path relative to the root.
+type fileAssets struct {
+	relPath string
+	assets  []string
+}
@@ -0,0 +1,74 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/validator"
+)
+
+// atomicGroups holds back the files of each of cfg.AtomicDirs until the last
+// of them has been loaded, and then inserts them all or, when one is
+// invalid, none: inserting the directory's other files without it would
+// leave a half-updated set of records. The files of a directory are
+// consecutive in walk order, so only one directory is held at a time.
+type atomicGroups struct {
+	cfg    *config.Config
+	walked []walkedFile
+
+	held    []int // files of the directory being used, held back
+	invalid int   // index of the first invalid held file; -1 when none is
+	warning validator.ValidationError
+
+	// skipped reports the directories left out when cfg.Invalid is
+	// InvalidWarn, one warning each, naming its first invalid file.
+	skipped []validator.ValidationError
+}
+
+func newAtomicGroups(cfg *config.Config, walked []walkedFile) *atomicGroups {
+	return &atomicGroups{cfg: cfg, walked: walked, invalid: -1}
+}
+
+// use inserts file i, which is invalid when some of its records failed
+// validation with warns, by calling insert(i), unless it lies in an atomic
+// directory. Such files are held until the directory's last file is used,
+// and then either all inserted or, when one is invalid, each passed to
+// skip instead.
+func (g *atomicGroups) use(i int, invalid bool, warns []validator.ValidationError, insert, skip func(i int) error) error {
+	relPath := g.walked[i].relPath
+	dir := g.cfg.AtomicDir(relPath)
+	if dir == "" {
+		return insert(i)
+	}
+	g.held = append(g.held, i)
+	if invalid && g.invalid < 0 {
+		g.invalid = i
+		g.warning = validator.ValidationError{
+			FilePath: filepath.ToSlash(relPath),
+			Message:  fmt.Sprintf("atomic directory %q is left out of the build because this file is invalid", dir),
+		}
+		if len(warns) > 0 {
+			g.warning.RecordKey, g.warning.Field = warns[0].RecordKey, warns[0].Field
+		}
+	}
+	if i+1 < len(g.walked) && g.cfg.AtomicDir(g.walked[i+1].relPath) == dir {
+		return nil
+	}
+
+	held, bad := g.held, g.invalid >= 0
+	if bad && g.cfg.Invalid == config.InvalidWarn {
+		g.skipped = append(g.skipped, g.warning)
+	}
+	g.held, g.invalid, g.warning = nil, -1, validator.ValidationError{}
+	for _, j := range held {
+		use := insert
+		if bad {
+			use = skip
+		}
+		if err := use(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
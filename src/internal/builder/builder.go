@@ -5,23 +5,32 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
-	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/oklog/ulid/v2"
-
 	"github.com/notwillk/sqlfs/internal/config"
 	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/encrypt"
 	"github.com/notwillk/sqlfs/internal/loader"
 	"github.com/notwillk/sqlfs/internal/schema"
+	"github.com/notwillk/sqlfs/internal/snapshot"
 	"github.com/notwillk/sqlfs/internal/sqlite"
 	"github.com/notwillk/sqlfs/internal/validator"
+	"github.com/notwillk/sqlfs/internal/version"
+)
+
+// Output formats accepted by Options.Format.
+const (
+	FormatSQLite = "sqlite" // binary SQLite database file (default)
+	FormatSQL    = "sql"    // plain-text dump of CREATE TABLE and INSERT statements
+	FormatCopy   = "copy"   // psql script loading the rows into PostgreSQL with \copy
 )
 
 // Options configures a build run.
@@ -29,6 +38,44 @@ type Options struct {
 	RootDir    string
 	OutputFile string
 	Config     *config.Config
+	Format     string // FormatSQLite (default), FormatSQL or FormatCopy
+	// EncryptionKey, when non-empty, encrypts the output file at rest
+	// (see package encrypt).
+	EncryptionKey string
+	// SnapshotDir receives a copy of the output when Config.KeepSnapshots > 0.
+	// Defaults to OutputFile + ".snapshots".
+	SnapshotDir string
+	// DeferSnapshot leaves the snapshot to the caller, who saves it with
+	// SaveSnapshot once the output has been accepted, e.g. by Verify.
+	DeferSnapshot bool
+	// Cache, when non-nil, makes the build incremental: it patches the
+	// database of the previous build given the same cache. See Cache.
+	Cache *Cache
+	// SettleTime, when set, retries files that fail to parse while they
+	// were modified less than SettleTime ago; see loader.Registry.SetSettleTime.
+	SettleTime time.Duration
+	// TablesDir, when set, also receives a database of each table on its
+	// own, for consumers that only ship part of the data.
+	TablesDir string
+	// Jobs is the number of files loaded and validated at once; zero means
+	// runtime.GOMAXPROCS(0). Rows are inserted in walk order regardless.
+	Jobs int
+	// Sample, when enabled, builds only a subset of the records. Sampled
+	// builds are never incremental: Cache is ignored.
+	Sample Sample
+	// MetricsHistory, when set, is a JSON Lines file each successful build
+	// appends its metrics to; see Metrics. Failing to write it is logged,
+	// and does not fail the build.
+	MetricsHistory string
+	// WarningsLog, when set, is a JSON Lines file each successful build
+	// appends its warnings to, one line each, and a build failed by invalid
+	// data the error that failed it; see WarningLine. Failing to write it is
+	// logged, and does not fail the build.
+	WarningsLog string
+
+	// references lists the tables each table's relationships reference, for
+	// the load order of FormatCopy output.
+	references map[string][]string
 }
 
 // Result holds the outcome of a build.
@@ -37,15 +84,49 @@ type Result struct {
 	RecordsTotal int
 	Warnings     []validator.ValidationError
 	Duration     time.Duration
+	SnapshotID   string // set when a snapshot of the output was retained
+	// DatasetHash identifies the set of ingested files and their contents;
+	// see datasetHasher.
+	DatasetHash string
+	// NextExpiry is the earliest time in an [expires] column of a built
+	// row, when the next build would leave that row out; zero if none.
+	NextExpiry time.Time
+	// TableRows counts the rows built for each table that received any.
+	TableRows map[string]int
+	// Files is the number of data files built, and FilesCached how many of
+	// them an incremental build took unchanged from its cache.
+	Files       int
+	FilesCached int
 }
 
 // Build executes the full build pipeline.
 // If schema.dbml exists it is used for DDL and validation (DBML mode).
 // If schema.dbml does not exist the schema is inferred from the entity files
-// (schema-less mode) and all user columns are stored as TEXT.
+// (schema-less mode) and all user columns are stored as TEXT. A schema read
+// from standard input or a URL (see ReadSchema) must exist.
+//
+// Build keeps no state between calls other than opts.Cache, so builds with
+// different output files and caches may run concurrently. It stops with ctx's
+// error once ctx is done, checking between records as well as between files;
+// Config.BuildTimeout, when set, bounds the whole build.
 func Build(ctx context.Context, opts Options) (*Result, error) {
 	start := time.Now()
 
+	switch opts.Format {
+	case "", FormatSQLite, FormatSQL, FormatCopy:
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected %s, %s or %s)", opts.Format, FormatSQLite, FormatSQL, FormatCopy)
+	}
+	if opts.SnapshotDir == "" {
+		opts.SnapshotDir = opts.OutputFile + ".snapshots"
+	}
+	if err := opts.Sample.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Sample.enabled() {
+		opts.Cache = nil
+	}
+
 	cfg := opts.Config
 	if cfg == nil {
 		var err error
@@ -54,26 +135,97 @@ func Build(ctx context.Context, opts Options) (*Result, error) {
 			return nil, fmt.Errorf("loading config: %w", err)
 		}
 	}
+	if cfg.KeepSnapshots > 0 && opts.Format != "" && opts.Format != FormatSQLite {
+		return nil, fmt.Errorf("snapshots require %s output", FormatSQLite)
+	}
+	if cfg.Deterministic && cfg.IDStrategy == config.IDSnowflake {
+		return nil, fmt.Errorf("deterministic builds cannot use id_strategy %s", config.IDSnowflake)
+	}
+	if err := version.Require(cfg.MinVersion); err != nil {
+		return nil, fmt.Errorf("sqlfs.yaml: %w", err)
+	}
+	if cfg.BuildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.BuildTimeout)
+		defer cancel()
+	}
 
-	schemaPath := filepath.Join(opts.RootDir, cfg.SchemaFile)
-	if _, err := os.Stat(schemaPath); errors.Is(err, os.ErrNotExist) {
-		return buildSchemaless(ctx, opts, cfg, start)
+	var result *Result
+	var err error
+	src, err := ReadSchema(ctx, opts.RootDir, cfg)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		result, err = buildSchemaless(ctx, opts, cfg, start)
+	case err != nil:
+		return nil, err
+	default:
+		result, err = buildWithDBML(ctx, opts, cfg, src, start)
+	}
+	if errors.Is(err, context.DeadlineExceeded) && cfg.BuildTimeout > 0 {
+		return nil, fmt.Errorf("build exceeded build_timeout of %s: %w", cfg.BuildTimeout, err)
+	}
+	if err != nil {
+		var ve validator.ValidationError
+		if opts.WarningsLog != "" && errors.As(err, &ve) {
+			lines := newWarningLines(opts.RootDir, start, []validator.ValidationError{ve})
+			lines[0].Failed = true
+			logWarnings(opts.WarningsLog, lines)
+		}
+		return nil, err
+	}
+	if opts.MetricsHistory != "" {
+		// The history is a record of builds, not part of them: a build
+		// that succeeded is not failed for it.
+		if err := appendMetrics(opts.MetricsHistory, newMetrics(opts.RootDir, start, result)); err != nil {
+			log.Printf("warning: writing metrics history: %v", err)
+		}
+	}
+	if opts.WarningsLog != "" {
+		logWarnings(opts.WarningsLog, newWarningLines(opts.RootDir, start, result.Warnings))
+	}
+	return result, nil
+}
+
+// NewRegistry returns the loader registry for a build with cfg: the built-in
+// loaders, with Markdown bodies stored in cfg.MarkdownBody, then the external
+// loaders of cfg.Loaders, allowed to run as loader.ExecAllowed says, files
+// assigned to tables by cfg.Tables before their file names, and the records
+// of multi-record files keyed by cfg.KeyField.
+func NewRegistry(cfg *config.Config) *loader.Registry {
+	reg := loader.NewRegistry()
+	reg.Register(&loader.MarkdownLoader{BodyField: cfg.MarkdownBody})
+	for _, l := range cfg.Loaders {
+		reg.Register(&loader.ExecLoader{Ext: l.Extension, Command: l.Command, Dir: l.Dir, Allowed: loader.ExecAllowed()})
 	}
-	return buildWithDBML(ctx, opts, cfg, start)
+	reg.SetTableMapper(cfg.TableFor)
+	reg.SetKeyField(cfg.KeyField)
+	return reg
 }
 
 // ---------------------------------------------------------------------------
 // DBML mode
 // ---------------------------------------------------------------------------
 
-func buildWithDBML(ctx context.Context, opts Options, cfg *config.Config, start time.Time) (*Result, error) {
-	result := &Result{}
+// buildWithDBML builds the database of the DBML schema src.
+func buildWithDBML(ctx context.Context, opts Options, cfg *config.Config, src []byte, start time.Time) (_ *Result, err error) {
+	result := &Result{TableRows: make(map[string]int)}
 
-	schemaPath := filepath.Join(opts.RootDir, cfg.SchemaFile)
-	dbmlSchema, err := dbml.ParseFile(schemaPath)
+	schemaPath := SchemaLocation(opts.RootDir, cfg)
+	dbmlSchema, err := dbml.Parse(src)
 	if err != nil {
 		return nil, fmt.Errorf("parsing schema %q: %w", schemaPath, err)
 	}
+	if dbmlSchema.Project != nil {
+		if err := version.Require(dbmlSchema.Project.MinVersion); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.SchemaFile, err)
+		}
+	}
+
+	excluded := ExcludeTables(dbmlSchema, cfg)
+	hashKey, err := redactKey(dbmlSchema, cfg, excluded)
+	if err != nil {
+		return nil, err
+	}
 
 	gen := schema.New(dbmlSchema, cfg)
 	ddl, err := gen.DDL()
@@ -81,19 +233,61 @@ func buildWithDBML(ctx context.Context, opts Options, cfg *config.Config, start
 		return nil, fmt.Errorf("generating DDL: %w", err)
 	}
 
-	db, err := sqlite.OpenMemory()
-	if err != nil {
-		return nil, fmt.Errorf("opening database: %w", err)
+	cache := opts.Cache
+	var state incrementalState
+	if cache != nil {
+		if state, err = newIncrementalState(opts.RootDir, cfg, src); err != nil {
+			return nil, err
+		}
 	}
-	defer db.Close()
-
-	if err := db.ExecDDL(ddl); err != nil {
-		return nil, fmt.Errorf("applying DDL: %w", err)
+	patch := cache.canPatch(state, dbmlSchema)
+
+	var db *sqlite.DB
+	if patch {
+		db = cache.db
+	} else {
+		if db, err = sqlite.OpenMemory(); err != nil {
+			return nil, fmt.Errorf("opening database: %w", err)
+		}
+		if err := db.ExecDDL(ddl); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("applying DDL: %w", err)
+		}
 	}
-
-	reg := loader.NewRegistry()
-	val := validator.New(dbmlSchema, cfg)
-	tablesSeen := make(map[string]struct{})
+	defer func() {
+		switch {
+		case cache == nil:
+			db.Close()
+		case err != nil:
+			// A failed patch leaves the cached database half updated.
+			if db != cache.db {
+				db.Close()
+			}
+			cache.Close()
+		}
+	}()
+
+	reg := NewRegistry(cfg)
+	reg.SetSettleTime(opts.SettleTime)
+	reg.SetContext(ctx)
+	in := &dbmlIngester{
+		db:       db,
+		cfg:      cfg,
+		reg:      reg,
+		val:      validator.New(dbmlSchema, cfg),
+		exp:      &expander{cfg: cfg, schema: dbmlSchema, redactKey: hashKey},
+		ids:      NewIDGenerator(cfg.IDStrategy, cfg.Deterministic),
+		excluded: excluded,
+		now:      start,
+		matches:  make(map[string]TableMatch),
+	}
+	if in.modTimes, err = gitModTimes(ctx, opts.RootDir, cfg); err != nil {
+		return nil, err
+	}
+	// Walk first and load afterwards, so that when patching the rows of every
+	// changed or removed file are gone before any file's new rows go in.
+	var walked []walkedFile
+	files := make(map[string]*cachedFile) // nil for files still to load
 
 	if err := filepath.WalkDir(opts.RootDir, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -102,18 +296,21 @@ func buildWithDBML(ctx context.Context, opts Options, cfg *config.Config, start
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
-			return filepath.SkipDir
+		if ignored(opts.RootDir, path, cfg) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		if d.IsDir() {
 			return nil
 		}
 
 		name := d.Name()
-		if name == cfg.SchemaFile || name == "sqlfs.yaml" {
+		if isProjectFile(name, cfg) {
 			return nil
 		}
-		if !reg.IsSupported(path) {
+		if !in.reg.IsSupported(path) {
 			return nil
 		}
 
@@ -122,81 +319,740 @@ func buildWithDBML(ctx context.Context, opts Options, cfg *config.Config, start
 			return err
 		}
 
-		entityType := loader.EntityType(relPath)
+		entityType := in.reg.TableOf(relPath)
+		if entityType == "" && cfg.TableFrom == config.TableFromFields {
+			// An unchanged file keeps the table it was matched to.
+			var cf *cachedFile
+			if patch {
+				cf, _ = cache.unchanged(path, relPath, d, start)
+			}
+			if cf != nil {
+				entityType = cf.entityType
+				in.matches[relPath] = TableMatch{Table: entityType, Scores: cf.matches}
+			} else if entityType, err = in.matchTable(dbmlSchema, path, relPath); err != nil {
+				return err
+			}
+		}
 		if entityType == "" {
-			log.Printf("warning: skipping %q: no entity type in filename (expected name.entity-type.ext)", relPath)
+			if cfg.TableFrom != config.TableFromFields {
+				log.Printf("warning: skipping %q: no entity type in filename (expected name.entity-type.ext) and no tables pattern matches", relPath)
+			}
+			return nil
+		}
+		if _, ok := excluded[entityType]; ok {
 			return nil
 		}
 
-		fr, err := reg.LoadFile(path, relPath)
-		if err != nil {
-			return fmt.Errorf("loading %q: %w", relPath, err)
+		walked = append(walked, walkedFile{path, relPath, entityType})
+		files[relPath] = nil
+		if patch {
+			if cf, ok := cache.unchanged(path, relPath, d, start); ok {
+				files[relPath] = cf
+			}
 		}
-		if len(fr.Records) == 0 {
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if opts.Sample.enabled() {
+		if walked, in.sample, in.loaded, err = chooseSample(ctx, walked, reg, in.loaded, opts.Jobs, opts.Sample); err != nil {
+			return nil, err
+		}
+	}
+	if patch {
+		for relPath, old := range cache.files {
+			if files[relPath] != old {
+				if err := deleteRows(db, cfg, old); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// Files are loaded and validated concurrently, and inserted in walk order
+	// in one transaction.
+	tablesSeen := make(map[string]struct{})
+	var dataset datasetHasher
+	cfs := make([]*cachedFile, len(walked)) // set for unchanged files up front
+	frs := make([]*loader.FileRecord, len(walked))
+	unchanged := make([]bool, len(walked))
+	for i, wf := range walked {
+		cfs[i] = files[wf.relPath]
+		unchanged[i] = cfs[i] != nil
+		if unchanged[i] {
+			result.FilesCached++
+		}
+	}
+	result.Files = len(walked)
+	// insertFile inserts the rows of file i, or records that an unchanged
+	// file keeps its rows.
+	insertFile := func(i int) error {
+		wf, cf := walked[i], cfs[i]
+		if fr := frs[i]; fr != nil {
+			frs[i] = nil
+			if err := in.insert(ctx, wf.relPath, fr, cf); err != nil {
+				return err
+			}
+		}
+		files[wf.relPath] = cf
+		result.Warnings = append(result.Warnings, cf.warnings...)
+		result.RecordsTotal += len(cf.rows)
+		for _, r := range cf.rows {
+			result.TableRows[r.table]++
+		}
+		if cf.ingested {
+			dataset.add(filepath.ToSlash(wf.relPath), cf.checksum)
+			tablesSeen[wf.entityType] = struct{}{}
+		}
+		if !cf.expires.IsZero() && (result.NextExpiry.IsZero() || cf.expires.Before(result.NextExpiry)) {
+			result.NextExpiry = cf.expires
+		}
+		return nil
+	}
+	// skipFile leaves file i out of the build, removing the rows an
+	// unchanged file kept. It is not cached, so the next build loads it.
+	skipFile := func(i int) error {
+		wf, cf := walked[i], cfs[i]
+		frs[i] = nil
+		result.Warnings = append(result.Warnings, cf.warnings...)
+		if unchanged[i] {
+			if err := deleteRows(db, cfg, cf); err != nil {
+				return err
+			}
+			result.FilesCached--
+		}
+		delete(files, wf.relPath)
+		return nil
+	}
+	atomic := newAtomicGroups(cfg, walked)
+	if err := db.Exec("BEGIN"); err != nil {
+		return nil, err
+	}
+	// Files checked ahead are kept for load while there is room. Without
+	// room, files chooseSample loaded are not checked, so that they are
+	// not loaded again.
+	type loadedFile struct {
+		cf *cachedFile
+		fr *loader.FileRecord
+	}
+	checked := newCheckedFiles[loadedFile](opts.Jobs)
+	if err := forEachLoaded(ctx, opts.Jobs, len(walked), func(i int) error {
+		if unchanged[i] {
 			return nil
 		}
+		if f, ok := checked.take(i); ok {
+			cfs[i], frs[i] = f.cf, f.fr
+			return nil
+		}
+		wf := walked[i]
+		var err error
+		cfs[i], frs[i], err = in.load(wf.path, wf.relPath, wf.entityType)
+		return err
+	}, func(i int) error {
+		if unchanged[i] {
+			return nil
+		}
+		wf := walked[i]
+		if !checked.reserve(i) {
+			if in.loaded.has(wf.path) {
+				return nil
+			}
+			_, _, err := in.load(wf.path, wf.relPath, wf.entityType)
+			return err
+		}
+		cf, fr, err := in.load(wf.path, wf.relPath, wf.entityType)
+		if err != nil {
+			checked.release(i)
+			return err
+		}
+		checked.put(i, loadedFile{cf, fr})
+		return nil
+	}, func(i int) error {
+		return atomic.use(i, cfs[i].invalid, cfs[i].warnings, insertFile, skipFile)
+	}); err != nil {
+		return nil, err
+	}
+	result.Warnings = append(result.Warnings, atomic.skipped...)
+	if err := db.Exec("COMMIT"); err != nil {
+		return nil, err
+	}
 
-		fr.EntityType = entityType
+	result.TablesBuilt = len(tablesSeen)
 
-		// For validation, use only the scalar fields of the primary record.
-		// Array/object fields will be expanded into child tables — they are not
-		// directly validated against the DBML schema.
-		flatFR := scalarFileRecord(fr)
-		valid, warns, err := val.Validate(flatFR)
-		if err != nil {
-			return fmt.Errorf("validating %q: %w", relPath, err)
+	if err := in.exp.assignIncrements(db, cfg.StandardColumns.PK); err != nil {
+		return nil, err
+	}
+	if err := in.exp.createChildrenTable(db, cfg.StandardColumns.PK); err != nil {
+		return nil, err
+	}
+	if err := resolveIncrementRefs(db, dbmlSchema, cfg.StandardColumns.PK); err != nil {
+		return nil, err
+	}
+	fkWarns, err := checkForeignKeys(db, cfg, files)
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = append(result.Warnings, fkWarns...)
+	if !patch {
+		if err := createNamedQueries(db, cfg); err != nil {
+			return nil, err
+		}
+		if cfg.SchemaTables {
+			if err := createSchemaTables(db, dbmlSchema, cfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.MarkdownAssets {
+		assets := make([]fileAssets, 0, len(walked))
+		for _, wf := range walked {
+			if cf := files[wf.relPath]; cf != nil && len(cf.assets) > 0 {
+				assets = append(assets, fileAssets{filepath.ToSlash(wf.relPath), cf.assets})
+			}
+		}
+		if err := createAssetsTable(db, opts.RootDir, assets); err != nil {
+			return nil, err
 		}
-		result.Warnings = append(result.Warnings, warns...)
+	}
+	if cfg.TableFrom == config.TableFromFields {
+		// Files that a sample left out are not recorded.
+		matches := make(map[string]TableMatch, len(in.matches))
+		for relPath, m := range in.matches {
+			if m.Table == "" {
+				matches[relPath] = m
+			}
+		}
+		for _, wf := range walked {
+			if m, ok := in.matches[wf.relPath]; ok {
+				matches[wf.relPath] = m
+			}
+		}
+		if err := createMatchesTable(db, matches); err != nil {
+			return nil, err
+		}
+	}
+	result.DatasetHash = dataset.sum()
+	if err := writeBuildInfo(db, result.DatasetHash); err != nil {
+		return nil, err
+	}
+	opts.references = tableReferences(dbmlSchema)
+	if err := saveOutput(db, opts); err != nil {
+		return nil, err
+	}
+	if err := saveTableOutputs(db, opts); err != nil {
+		return nil, err
+	}
+	if err := saveSnapshot(opts, cfg, result); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.store(db, state, files)
+	}
 
-		if len(valid) == 0 {
-			return nil
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// dbmlIngester loads data files into the database of a DBML mode build.
+type dbmlIngester struct {
+	db       *sqlite.DB
+	cfg      *config.Config
+	reg      *loader.Registry
+	val      *validator.Validator
+	exp      *expander
+	ids      IDGenerator
+	excluded map[string]struct{}
+	now      time.Time            // rows expiring at or before now are left out
+	modTimes map[string]time.Time // see gitModTimes
+	sample   map[string]struct{}  // primary keys of a sampled build; see chooseSample
+	loaded   *loadedFiles         // files matchTable or chooseSample loaded
+	// matches holds the files matched by their fields; see matchTable.
+	matches map[string]TableMatch
+}
+
+// walkedFile is a data file found by walking the root directory.
+type walkedFile struct{ path, relPath, entityType string }
+
+// matchTable loads the file at path and returns the table of s its fields
+// match, or "" with a warning when MatchTable assigns it to none. The match
+// is recorded in in.matches, and the loaded file kept for load.
+func (in *dbmlIngester) matchTable(s *dbml.Schema, path, relPath string) (string, error) {
+	fr, err := in.loaded.load(in.reg, path, relPath)
+	if err != nil {
+		return "", fmt.Errorf("loading %q: %w", relPath, err)
+	}
+	m := MatchTable(s, in.cfg, fr)
+	in.matches[relPath] = m
+	if m.Table == "" {
+		log.Printf("warning: skipping %q: no entity type in filename and %s", relPath, m.Reason)
+		return "", nil
+	}
+	if in.loaded == nil {
+		in.loaded = &loadedFiles{frs: make(map[string]*loader.FileRecord)}
+	}
+	in.loaded.frs[path] = fr
+	return m.Table, nil
+}
+
+// load loads and validates the file at path, returning what is recorded about
+// it for incremental builds and, when it has rows to insert, its record. It
+// is safe for concurrent use.
+func (in *dbmlIngester) load(path, relPath, entityType string) (*cachedFile, *loader.FileRecord, error) {
+	fr, err := in.loaded.load(in.reg, path, relPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading %q: %w", relPath, err)
+	}
+	cf := &cachedFile{
+		modTime:    fr.ModTime,
+		size:       fr.Size,
+		tombstone:  tombstoneModTime(path),
+		checksum:   fr.Checksum,
+		entityType: entityType,
+	}
+	if m, ok := in.matches[relPath]; ok {
+		cf.matches = m.Scores[:min(len(m.Scores), 2)]
+	}
+	applyModTime(in.modTimes, relPath, fr)
+	applySample(in.sample, fr)
+	if len(fr.Records) == 0 {
+		return cf, nil, nil
+	}
+	if applyTombstone(path, fr, in.cfg.KeepTombstones()) {
+		return cf, nil, nil
+	}
+	applyRenames(in.cfg, entityType, fr)
+	if err := interpolateEnv(in.cfg, fr); err != nil {
+		return nil, nil, fmt.Errorf("loading %q: %w", relPath, err)
+	}
+	if err := applyTransform(in.cfg, entityType, fr); err != nil {
+		return nil, nil, fmt.Errorf("transforming %q: %w", relPath, err)
+	}
+	if len(fr.Records) == 0 {
+		return cf, nil, nil
+	}
+
+	fr.EntityType = entityType
+
+	// For validation, use only the scalar fields of the primary record.
+	// Array/object fields will be expanded into child tables — they are not
+	// directly validated against the DBML schema.
+	flatFR := scalarFileRecord(fr, in.exp)
+	valid, warns, err := in.val.Validate(flatFR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("validating %q: %w", relPath, err)
+	}
+	cf.warnings = warns
+	cf.invalid = len(warns) > 0 || len(valid) < len(flatFR.Records)
+
+	keepValid(fr, valid)
+	live := fr.Records[:0]
+	for _, rec := range fr.Records {
+		if at := in.expiresAt(entityType, rec.Fields); !at.IsZero() {
+			if !at.After(in.now) {
+				continue
+			}
+			if cf.expires.IsZero() || at.Before(cf.expires) {
+				cf.expires = at
+			}
+		}
+		live = append(live, rec)
+	}
+	fr.Records = live
+	if len(live) == 0 {
+		return cf, nil, nil
+	}
+	cf.ingested = true
+	cf.assets = markdownAssets(in.cfg, relPath, fr)
+	return cf, fr, nil
+}
+
+// keepValid leaves in fr only the records that validation returned in
+// valid, matching them by key.
+func keepValid(fr *loader.FileRecord, valid []loader.Record) {
+	if len(valid) == len(fr.Records) {
+		return
+	}
+	keys := make(map[string]struct{}, len(valid))
+	for _, rec := range valid {
+		keys[rec.Key] = struct{}{}
+	}
+	kept := fr.Records[:0]
+	for _, rec := range fr.Records {
+		if _, ok := keys[rec.Key]; ok {
+			kept = append(kept, rec)
 		}
+	}
+	fr.Records = kept
+}
 
-		// Expand and insert.
-		pk := loader.EntityPK(relPath)
-		expanded := expandEntity(entityType, pk, fr, fr.Records[0].Fields, nil)
+// insert expands the records of a loaded file into rows and inserts them,
+// adding them to cf. Files must be inserted in walk order, which the ids of
+// [pk, increment] columns follow.
+func (in *dbmlIngester) insert(ctx context.Context, relPath string, fr *loader.FileRecord, cf *cachedFile) error {
+	for _, rec := range fr.Records {
+		expanded := in.exp.expandEntity(fr.EntityType, fr.RecordPK(rec), recordFile(fr, rec), rec.Fields, rec.FieldOrder(), rec.Lines, "")
+		in.exp.redact(expanded)
 		for _, exp := range expanded {
-			if err := insertExpandedRecord(db, exp, cfg); err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, ok := in.excluded[exp.TableName]; ok {
+				continue
+			}
+			if err := insertExpandedRecord(in.db, exp, in.cfg, in.ids); err != nil {
 				return fmt.Errorf("inserting from %q: %w", relPath, err)
 			}
-			result.RecordsTotal++
+			cf.rows = append(cf.rows, cachedRow{table: exp.TableName, pk: exp.PK})
+		}
+	}
+	return nil
+}
+
+// expiresAt returns the time in the [expires] column of a row of table with
+// the given fields, or the zero time when the table has no such column or
+// the row no time in it.
+func (in *dbmlIngester) expiresAt(table string, fields map[string]any) time.Time {
+	t := in.exp.schema.TableByName(table)
+	if t == nil {
+		return time.Time{}
+	}
+	col := t.ExpiryColumn()
+	if col == nil || fields[col.Name] == nil {
+		return time.Time{}
+	}
+	at, err := loader.ParseTime(fields[col.Name], in.cfg.TimestampLocation)
+	if err != nil {
+		return time.Time{} // reported by the validator
+	}
+	return at
+}
+
+// applyTombstone detects the tombstone markers for the file at absPath: a
+// truthy TombstoneField in the file, or a sibling TombstoneSuffix file. The
+// marker field is stripped from fr so it never reaches validation or the
+// database. A sibling file, or the marker in a single-entity file, deletes
+// the whole file and sets fr.DeletedAt; in a MultiRecord file the marker
+// only deletes its own record, setting the record's DeletedAt, or dropping
+// it unless keep is set. Returns true if the file is left out: it is deleted
+// and keep is not set.
+func applyTombstone(absPath string, fr *loader.FileRecord, keep bool) bool {
+	deleted := false
+	if info, err := os.Stat(absPath + config.TombstoneSuffix); err == nil {
+		fr.DeletedAt = info.ModTime()
+		deleted = true
+	}
+	kept := fr.Records[:0]
+	for _, rec := range fr.Records {
+		v, ok := rec.Fields[config.TombstoneField]
+		if ok {
+			delete(rec.Fields, config.TombstoneField)
+		}
+		if b, _ := v.(bool); b {
+			switch {
+			case !fr.MultiRecord:
+				if !deleted {
+					fr.DeletedAt = fr.ModTime
+					deleted = true
+				}
+			case !keep:
+				continue
+			default:
+				rec.DeletedAt = fr.ModTime
+			}
+		}
+		kept = append(kept, rec)
+	}
+	fr.Records = kept
+	return deleted && !keep
+}
+
+// recordFile returns fr, or for a record deleted on its own by applyTombstone
+// a copy of fr with the record's DeletedAt, for expanding the record into
+// rows.
+func recordFile(fr *loader.FileRecord, rec loader.Record) *loader.FileRecord {
+	if rec.DeletedAt.IsZero() {
+		return fr
+	}
+	deleted := *fr
+	deleted.DeletedAt = rec.DeletedAt
+	return &deleted
+}
+
+// applyRenames renames fields of fr's records according to the renames
+// configured for table. Fields are renamed all at once, so chained renames
+// and swaps move each field once. When a record has both the old and the new
+// name, the new name's value is kept.
+func applyRenames(cfg *config.Config, table string, fr *loader.FileRecord) {
+	renames := cfg.Renames[table]
+	if len(renames) == 0 {
+		return
+	}
+	for i := range fr.Records {
+		rec := &fr.Records[i]
+		var moved []string
+		for from := range rec.Fields {
+			if cfg.RenameField(table, from) != from {
+				moved = append(moved, from)
+			}
+		}
+		if len(moved) == 0 {
+			continue
+		}
+		sort.Strings(moved)
+		values := make(map[string]any, len(moved))
+		for _, from := range moved {
+			values[from] = rec.Fields[from]
+			delete(rec.Fields, from)
+		}
+		for _, from := range moved {
+			to := cfg.RenameField(table, from)
+			if _, exists := rec.Fields[to]; !exists {
+				rec.Fields[to] = values[from]
+			}
+		}
+		for j, k := range rec.Keys {
+			rec.Keys[j] = cfg.RenameField(table, k)
 		}
-		tablesSeen[entityType] = struct{}{}
+	}
+}
+
+// applyTransform runs the transformation configured for table on each of
+// fr's records, replacing its fields with the program's output and dropping
+// records for which the program produces none.
+func applyTransform(cfg *config.Config, table string, fr *loader.FileRecord) error {
+	prog := cfg.Transforms[table]
+	if prog == nil {
 		return nil
-	}); err != nil {
-		return nil, err
 	}
+	kept := fr.Records[:0]
+	for _, rec := range fr.Records {
+		fields, ok, err := prog.Run(rec.Fields)
+		if err != nil {
+			if fr.MultiRecord {
+				return fmt.Errorf("record %q: %w", rec.Key, err)
+			}
+			return err
+		}
+		if !ok {
+			continue
+		}
+		rec.Fields = fields
+		kept = append(kept, rec)
+	}
+	fr.Records = kept
+	return nil
+}
 
-	result.TablesBuilt = len(tablesSeen)
+// interpolateEnv replaces the environment variable references allowed by
+// cfg.InterpolateEnv in the string values of fr's records.
+func interpolateEnv(cfg *config.Config, fr *loader.FileRecord) error {
+	for _, rec := range fr.Records {
+		if err := loader.InterpolateEnv(rec.Fields, cfg.InterpolateEnv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// saveOutput writes the built database to opts.OutputFile in opts.Format.
+// It is written to a temporary file next to the output first and renamed
+// over it once complete, so a failed or interrupted build leaves the previous
+// output, if any, in place.
+func saveOutput(db *sqlite.DB, opts Options) error {
 	if err := os.MkdirAll(filepath.Dir(opts.OutputFile), 0755); err != nil {
-		return nil, fmt.Errorf("creating output directory: %w", err)
+		return fmt.Errorf("creating output directory: %w", err)
 	}
-	if err := db.SaveTo(opts.OutputFile); err != nil {
-		return nil, fmt.Errorf("saving database: %w", err)
+	tmp := opts.OutputFile + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing stale output: %w", err)
 	}
+	if err := writeOutput(db, tmp, opts); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := replaceFile(tmp, opts.OutputFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replacing output: %w", err)
+	}
+	return nil
+}
 
-	result.Duration = time.Since(start)
-	return result, nil
+// writeOutput writes the built database to path in opts.Format. When
+// opts.EncryptionKey is set it is encrypted as it is written, so that the
+// plain database never reaches the disk.
+func writeOutput(db *sqlite.DB, path string, opts Options) error {
+	if opts.EncryptionKey == "" && opts.Format != FormatSQL && opts.Format != FormatCopy {
+		if err := db.SaveTo(path); err != nil {
+			return fmt.Errorf("saving database: %w", err)
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var ew *encrypt.Writer
+	if opts.EncryptionKey != "" {
+		if err := f.Chmod(0600); err != nil {
+			return err
+		}
+		if ew, err = encrypt.NewWriter(f, opts.EncryptionKey); err != nil {
+			return fmt.Errorf("encrypting output: %w", err)
+		}
+		w = ew
+	}
+
+	switch opts.Format {
+	case FormatSQL:
+		if err := db.DumpSQL(w); err != nil {
+			return fmt.Errorf("writing SQL dump: %w", err)
+		}
+	case FormatCopy:
+		if err := writeCopyDump(db, w, opts.references); err != nil {
+			return err
+		}
+	default:
+		data, err := db.Serialize()
+		if err != nil {
+			return fmt.Errorf("saving database: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("encrypting output: %w", err)
+		}
+	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			return fmt.Errorf("encrypting output: %w", err)
+		}
+	}
+	return f.Close()
+}
+
+// SaveSnapshot retains a copy of outputFile, built by a build with opts and
+// opts.DeferSnapshot set that returned result, in opts.SnapshotDir when
+// snapshots are enabled, recording its ID on result. outputFile is where the
+// output has since been moved, or opts.OutputFile.
+func SaveSnapshot(opts Options, outputFile string, result *Result) error {
+	cfg := opts.Config
+	if cfg == nil {
+		var err error
+		if cfg, err = config.Load(opts.RootDir); err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+	}
+	if opts.SnapshotDir == "" {
+		opts.SnapshotDir = opts.OutputFile + ".snapshots"
+	}
+	opts.OutputFile, opts.DeferSnapshot = outputFile, false
+	return saveSnapshot(opts, cfg, result)
+}
+
+// saveSnapshot retains a copy of the output in opts.SnapshotDir when
+// snapshots are enabled and not deferred, recording its ID on result.
+func saveSnapshot(opts Options, cfg *config.Config, result *Result) error {
+	if cfg.KeepSnapshots <= 0 || opts.DeferSnapshot {
+		return nil
+	}
+	entry, err := snapshot.Save(opts.SnapshotDir, opts.OutputFile, snapshot.Entry{
+		RootDir: opts.RootDir,
+		Records: result.RecordsTotal,
+		Tables:  result.TablesBuilt,
+	}, cfg.KeepSnapshots)
+	if err != nil {
+		return fmt.Errorf("saving snapshot: %w", err)
+	}
+	result.SnapshotID = entry.ID
+	return nil
+}
+
+// writeCopyDump writes the rows of db's data tables to w as a psql script
+// of \copy commands. Tables are not created: the script loads into
+// a PostgreSQL database that already has them. A table is loaded after the
+// tables it references, unless they reference each other.
+func writeCopyDump(db *sqlite.DB, w io.Writer, references map[string][]string) error {
+	tables, err := dataTables(db)
+	if err != nil {
+		return fmt.Errorf("listing tables: %w", err)
+	}
+	if err := db.DumpCopy(w, loadOrder(tables, references)); err != nil {
+		return fmt.Errorf("writing COPY dump: %w", err)
+	}
+	return nil
+}
+
+// tableReferences returns, for each table of s, the other tables its
+// relationships reference.
+func tableReferences(s *dbml.Schema) map[string][]string {
+	refs := make(map[string][]string)
+	for _, ref := range s.Relationships() {
+		if ref.From.Table != ref.To.Table {
+			refs[ref.From.Table] = append(refs[ref.From.Table], ref.To.Table)
+		}
+	}
+	return refs
+}
+
+// loadOrder sorts tables so that each comes after the tables it references,
+// keeping the given order otherwise and among tables that reference each
+// other.
+func loadOrder(tables []string, references map[string][]string) []string {
+	wanted := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		wanted[table] = true
+	}
+	done := make(map[string]bool, len(tables))
+	visiting := make(map[string]bool)
+	out := make([]string, 0, len(tables))
+	var visit func(string)
+	visit = func(table string) {
+		if done[table] || visiting[table] || !wanted[table] {
+			return
+		}
+		visiting[table] = true
+		for _, ref := range references[table] {
+			visit(ref)
+		}
+		visiting[table] = false
+		done[table] = true
+		out = append(out, table)
+	}
+	for _, table := range tables {
+		visit(table)
+	}
+	return out
 }
 
 // scalarFileRecord returns a copy of fr whose Records contain only scalar fields.
-// Array and object fields are stripped so validation only checks flat columns.
-func scalarFileRecord(fr *loader.FileRecord) *loader.FileRecord {
+// Array and object fields are stripped so validation only checks flat columns,
+// except arrays that exp stores in a column of the entity's own table.
+func scalarFileRecord(fr *loader.FileRecord, exp *expander) *loader.FileRecord {
 	flat := &loader.FileRecord{
-		EntityType: fr.EntityType,
-		FilePath:   fr.FilePath,
-		Records:    make([]loader.Record, 0, len(fr.Records)),
-		ModTime:    fr.ModTime,
-		CreatedAt:  fr.CreatedAt,
-		Checksum:   fr.Checksum,
+		EntityType:  fr.EntityType,
+		FilePath:    fr.FilePath,
+		Records:     make([]loader.Record, 0, len(fr.Records)),
+		ModTime:     fr.ModTime,
+		CreatedAt:   fr.CreatedAt,
+		Checksum:    fr.Checksum,
+		Size:        fr.Size,
+		MultiRecord: fr.MultiRecord,
 	}
 	for _, rec := range fr.Records {
-		scalar := loader.Record{Key: rec.Key, Fields: make(map[string]any)}
+		scalar := loader.Record{Key: rec.Key, Fields: make(map[string]any), DuplicateKeys: rec.DuplicateKeys}
 		for k, v := range rec.Fields {
 			switch v.(type) {
-			case []any, map[string]any:
-				// Skip — will be expanded into child tables.
+			case []any:
+				if exp.storesAsColumn(fr.EntityType, k) {
+					scalar.Fields[k] = v
+				}
+				// Otherwise skip — will be expanded into child tables.
+			case map[string]any:
+				if exp.storesAsColumn(fr.EntityType, k) {
+					scalar.Fields[k] = v
+				}
+				// Otherwise skip — stored as JSON.
 			default:
 				scalar.Fields[k] = v
 			}
@@ -228,24 +1084,29 @@ func (t *discoveredTable) addColumn(col string) {
 	}
 }
 
-// discoverTables walks rootDir and collects the table/column structure from
-// entity files. Returns the table map and a pk→entityType index.
-func discoverTables(rootDir string, cfg *config.Config, reg *loader.Registry) (map[string]*discoveredTable, map[string]string, error) {
-	tables := make(map[string]*discoveredTable)
-	pathIndex := make(map[string]string)
-
+// walkSchemaless returns the data files under rootDir of a schema-less
+// build, in walk order. With untabled set, it also returns the files no
+// table is found for, with an empty entityType.
+func walkSchemaless(ctx context.Context, rootDir string, cfg *config.Config, reg *loader.Registry, untabled bool) ([]walkedFile, error) {
+	var walked []walkedFile
 	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
-		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
-			return filepath.SkipDir
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if ignored(rootDir, path, cfg) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		if d.IsDir() {
 			return nil
 		}
 		name := d.Name()
-		if name == cfg.SchemaFile || name == "sqlfs.yaml" {
+		if isProjectFile(name, cfg) {
 			return nil
 		}
 		if !reg.IsSupported(path) {
@@ -257,49 +1118,133 @@ func discoverTables(rootDir string, cfg *config.Config, reg *loader.Registry) (m
 			return err
 		}
 
-		entityType := loader.EntityType(relPath)
-		if entityType == "" {
+		entityType := reg.TableOf(relPath)
+		if entityType == "" && !untabled || cfg.IsExcludedTable(entityType) {
 			return nil
 		}
+		walked = append(walked, walkedFile{path, relPath, entityType})
+		return nil
+	})
+	return walked, err
+}
+
+// discoverTables walks rootDir and collects the table/column structure from
+// entity files. Returns the table map and a pk→entityType index.
+func discoverTables(rootDir string, cfg *config.Config, reg *loader.Registry) (map[string]*discoveredTable, map[string]string, error) {
+	walked, err := walkSchemaless(context.Background(), rootDir, cfg, reg, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return discoverWalked(context.Background(), walked, cfg, reg, 0, nil, nil)
+}
 
-		pk := loader.EntityPK(relPath)
-		pathIndex[pk] = entityType
+// discoverWalked is discoverTables for the files already walked, loading
+// them on up to jobs goroutines unless they are in loaded. A non-nil sample
+// limits it to the records with those primary keys.
+func discoverWalked(ctx context.Context, walked []walkedFile, cfg *config.Config, reg *loader.Registry, jobs int, sample map[string]struct{}, loaded *loadedFiles) (map[string]*discoveredTable, map[string]string, error) {
+	tables := make(map[string]*discoveredTable)
+	pathIndex := make(map[string]string)
+	for _, wf := range walked {
+		pathIndex[loader.EntityPK(wf.relPath)] = wf.entityType
+	}
 
-		fr, err := reg.LoadFile(path, relPath)
+	frs := make([]*loader.FileRecord, len(walked))
+	err := forEachLoaded(ctx, jobs, len(walked), func(i int) error {
+		wf := walked[i]
+		fr, err := loaded.load(reg, wf.path, wf.relPath)
 		if err != nil {
 			return nil // skip on error in discovery
 		}
-		if len(fr.Records) > 0 {
-			discoverColumns(entityType, fr.Records[0].Fields, tables, pathIndex)
+		applySample(sample, fr)
+		if applyTombstone(wf.path, fr, cfg.KeepTombstones()) {
+			return nil
+		}
+		applyRenames(cfg, wf.entityType, fr)
+		if err := applyTransform(cfg, wf.entityType, fr); err != nil {
+			return nil
+		}
+		frs[i] = fr
+		return nil
+	}, nil, func(i int) error {
+		fr := frs[i]
+		frs[i] = nil
+		if fr != nil {
+			for _, rec := range fr.Records {
+				discoverColumns(cfg, walked[i].entityType, rec.Fields, rec.FieldOrder(), tables, pathIndex)
+			}
 		}
 		return nil
 	})
+	// Child tables may be excluded too.
+	for name := range tables {
+		if cfg.IsExcludedTable(name) {
+			delete(tables, name)
+		}
+	}
 	return tables, pathIndex, err
 }
 
 func buildSchemaless(ctx context.Context, opts Options, cfg *config.Config, start time.Time) (*Result, error) {
-	result := &Result{}
-	reg := loader.NewRegistry()
+	result := &Result{TableRows: make(map[string]int)}
+	reg := NewRegistry(cfg)
+	reg.SetSettleTime(opts.SettleTime)
+	reg.SetContext(ctx)
+	val := validator.New(nil, cfg)
 
 	// --- Discovery pass ---
-	tables, pathIndex, err := discoverTables(opts.RootDir, cfg, reg)
+	walked, err := walkSchemaless(ctx, opts.RootDir, cfg, reg, false)
+	if err != nil {
+		return nil, err
+	}
+	var sample map[string]struct{}
+	var loaded *loadedFiles
+	if opts.Sample.enabled() {
+		if walked, sample, loaded, err = chooseSample(ctx, walked, reg, nil, opts.Jobs, opts.Sample); err != nil {
+			return nil, err
+		}
+	}
+	tables, pathIndex, err := discoverWalked(ctx, walked, cfg, reg, opts.Jobs, sample, loaded)
 	if err != nil {
 		return nil, err
 	}
 
 	// --- DDL generation ---
 	sc := cfg.StandardColumns
+	tsType := schema.TimestampType(cfg)
 	var ddl []string
-	for _, tbl := range tables {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tbl := tables[name]
 		var cols []string
 		cols = append(cols, fmt.Sprintf(`  %s TEXT PRIMARY KEY`, sqliteQuote(sc.PK)))
 		cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(sc.Path)))
-		cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(sc.CreatedAt)))
-		cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(sc.ModifiedAt)))
+		cols = append(cols, fmt.Sprintf(`  %s %s`, sqliteQuote(sc.CreatedAt), tsType))
+		cols = append(cols, fmt.Sprintf(`  %s %s`, sqliteQuote(sc.ModifiedAt), tsType))
 		cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(sc.Checksum)))
 		cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(sc.ULID)))
+		if cfg.KeepTombstones() {
+			cols = append(cols, fmt.Sprintf(`  %s %s`, sqliteQuote(sc.DeletedAt), tsType))
+		}
+		if cfg.RecordChecksums {
+			cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(sc.RecordChecksum)))
+		}
+		if cfg.SourceLines {
+			cols = append(cols, fmt.Sprintf(`  %s INTEGER`, sqliteQuote(sc.SourceLine)))
+		}
 		for _, col := range tbl.columns {
-			cols = append(cols, fmt.Sprintf(`  %s TEXT`, sqliteQuote(col)))
+			collate, err := schema.CollateClause(cfg.Collation(tbl.name, col))
+			if err != nil {
+				return nil, fmt.Errorf("collations.%s.%s: %w", tbl.name, col, err)
+			}
+			def := fmt.Sprintf(`  %s TEXT`, sqliteQuote(col))
+			if collate != "" {
+				def += " " + collate
+			}
+			cols = append(cols, def)
 		}
 		ddl = append(ddl, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n)",
 			sqliteQuote(tbl.name), strings.Join(cols, ",\n")))
@@ -316,110 +1261,234 @@ func buildSchemaless(ctx context.Context, opts Options, cfg *config.Config, star
 	}
 
 	// --- Insert pass ---
+	exp := &expander{cfg: cfg, pathIndex: pathIndex}
+	ids := NewIDGenerator(cfg.IDStrategy, cfg.Deterministic)
+	modTimes, err := gitModTimes(ctx, opts.RootDir, cfg)
+	if err != nil {
+		return nil, err
+	}
 	tablesSeen := make(map[string]struct{})
-
-	if err := filepath.WalkDir(opts.RootDir, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
+	var dataset datasetHasher
+
+	// Files are loaded and validated concurrently, and inserted in walk order
+	// in one transaction.
+	frs := make([]*loader.FileRecord, len(walked))
+	warns := make([][]validator.ValidationError, len(walked))
+	invalid := make([]bool, len(walked))
+	var assets []fileAssets
+	result.Files = len(walked)
+
+	// load loads and validates the file wf, returning its record when it
+	// has rows to insert, and whether some of its records are invalid.
+	load := func(wf walkedFile) (*loader.FileRecord, []validator.ValidationError, bool, error) {
+		fr, err := reg.LoadFile(wf.path, wf.relPath)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("loading %q: %w", wf.relPath, err)
 		}
-		if ctx.Err() != nil {
-			return ctx.Err()
+		applyModTime(modTimes, wf.relPath, fr)
+		applySample(sample, fr)
+		if len(fr.Records) == 0 {
+			return nil, nil, false, nil
 		}
-		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
-			return filepath.SkipDir
+		if applyTombstone(wf.path, fr, cfg.KeepTombstones()) {
+			return nil, nil, false, nil
 		}
-		if d.IsDir() {
-			return nil
+		applyRenames(cfg, wf.entityType, fr)
+		if err := interpolateEnv(cfg, fr); err != nil {
+			return nil, nil, false, fmt.Errorf("loading %q: %w", wf.relPath, err)
 		}
-		name := d.Name()
-		if name == cfg.SchemaFile || name == "sqlfs.yaml" {
-			return nil
+		if err := applyTransform(cfg, wf.entityType, fr); err != nil {
+			return nil, nil, false, fmt.Errorf("transforming %q: %w", wf.relPath, err)
 		}
-		if !reg.IsSupported(path) {
-			return nil
+		if len(fr.Records) == 0 {
+			return nil, nil, false, nil
 		}
+		fr.EntityType = wf.entityType
 
-		relPath, err := filepath.Rel(opts.RootDir, path)
+		valid, w, err := val.Validate(fr)
 		if err != nil {
-			return err
+			return nil, nil, false, fmt.Errorf("validating %q: %w", wf.relPath, err)
 		}
-
-		entityType := loader.EntityType(relPath)
-		if entityType == "" {
+		bad := len(w) > 0 || len(valid) < len(fr.Records)
+		if len(valid) == 0 {
+			return nil, w, bad, nil
+		}
+		keepValid(fr, valid)
+		return fr, w, bad, nil
+	}
+	// insertFile inserts the rows of file i.
+	insertFile := func(i int) error {
+		wf, fr := walked[i], frs[i]
+		result.Warnings = append(result.Warnings, warns[i]...)
+		frs[i], warns[i] = nil, nil
+		if fr == nil {
 			return nil
 		}
 
-		fr, err := reg.LoadFile(path, relPath)
-		if err != nil {
-			return fmt.Errorf("loading %q: %w", relPath, err)
+		dataset.add(filepath.ToSlash(wf.relPath), fr.Checksum)
+		if a := markdownAssets(cfg, wf.relPath, fr); len(a) > 0 {
+			assets = append(assets, fileAssets{filepath.ToSlash(wf.relPath), a})
 		}
-		if len(fr.Records) == 0 {
+		for _, rec := range fr.Records {
+			expanded := exp.expandEntity(wf.entityType, fr.RecordPK(rec), recordFile(fr, rec), rec.Fields, rec.FieldOrder(), rec.Lines, "")
+			for _, exp := range expanded {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if cfg.IsExcludedTable(exp.TableName) {
+					continue
+				}
+				if err := insertExpandedRecord(db, exp, cfg, ids); err != nil {
+					log.Printf("warning: insert error for table %s pk %s: %v", exp.TableName, exp.PK, err)
+				} else {
+					result.RecordsTotal++
+					result.TableRows[exp.TableName]++
+					tablesSeen[exp.TableName] = struct{}{}
+				}
+			}
+		}
+		return nil
+	}
+	// skipFile leaves file i out of the build.
+	skipFile := func(i int) error {
+		result.Warnings = append(result.Warnings, warns[i]...)
+		frs[i], warns[i] = nil, nil
+		return nil
+	}
+	atomic := newAtomicGroups(cfg, walked)
+	if err := db.Exec("BEGIN"); err != nil {
+		return nil, err
+	}
+	// Files checked ahead are kept for load while there is room.
+	type loadedFile struct {
+		fr      *loader.FileRecord
+		warns   []validator.ValidationError
+		invalid bool
+	}
+	checked := newCheckedFiles[loadedFile](opts.Jobs)
+	if err := forEachLoaded(ctx, opts.Jobs, len(walked), func(i int) error {
+		if f, ok := checked.take(i); ok {
+			frs[i], warns[i], invalid[i] = f.fr, f.warns, f.invalid
 			return nil
 		}
-
-		pk := loader.EntityPK(relPath)
-		expanded := expandEntity(entityType, pk, fr, fr.Records[0].Fields, pathIndex)
-		for _, exp := range expanded {
-			if err := insertExpandedRecord(db, exp, cfg); err != nil {
-				log.Printf("warning: insert error for table %s pk %s: %v", exp.TableName, exp.PK, err)
-			} else {
-				result.RecordsTotal++
-				tablesSeen[exp.TableName] = struct{}{}
-			}
+		var err error
+		frs[i], warns[i], invalid[i], err = load(walked[i])
+		return err
+	}, func(i int) error {
+		if !checked.reserve(i) {
+			_, _, _, err := load(walked[i])
+			return err
+		}
+		fr, w, bad, err := load(walked[i])
+		if err != nil {
+			checked.release(i)
+			return err
 		}
+		checked.put(i, loadedFile{fr, w, bad})
 		return nil
+	}, func(i int) error {
+		return atomic.use(i, invalid[i], warns[i], insertFile, skipFile)
 	}); err != nil {
 		return nil, err
 	}
+	result.Warnings = append(result.Warnings, atomic.skipped...)
+	if err := db.Exec("COMMIT"); err != nil {
+		return nil, err
+	}
 
 	result.TablesBuilt = len(tablesSeen)
 
-	if err := os.MkdirAll(filepath.Dir(opts.OutputFile), 0755); err != nil {
-		return nil, fmt.Errorf("creating output directory: %w", err)
+	if err := exp.createChildrenTable(db, cfg.StandardColumns.PK); err != nil {
+		return nil, err
+	}
+	if err := createNamedQueries(db, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MarkdownAssets {
+		if err := createAssetsTable(db, opts.RootDir, assets); err != nil {
+			return nil, err
+		}
+	}
+	result.DatasetHash = dataset.sum()
+	if err := writeBuildInfo(db, result.DatasetHash); err != nil {
+		return nil, err
+	}
+	if err := saveOutput(db, opts); err != nil {
+		return nil, err
+	}
+	if err := saveTableOutputs(db, opts); err != nil {
+		return nil, err
 	}
-	if err := db.SaveTo(opts.OutputFile); err != nil {
-		return nil, fmt.Errorf("saving database: %w", err)
+	if err := saveSnapshot(opts, cfg, result); err != nil {
+		return nil, err
 	}
 
 	result.Duration = time.Since(start)
 	return result, nil
 }
 
-// discoverColumns walks a field map and records column names for each table.
-func discoverColumns(entityType string, fields map[string]any, tables map[string]*discoveredTable, pathIndex map[string]string) {
+// discoverColumns walks a field map in keys order and records column names for
+// each table.
+func discoverColumns(cfg *config.Config, entityType string, fields map[string]any, keys []string, tables map[string]*discoveredTable, pathIndex map[string]string) {
 	tbl, ok := tables[entityType]
 	if !ok {
 		tbl = newDiscoveredTable(entityType)
 		tables[entityType] = tbl
 	}
 
-	for key, val := range fields {
-		switch v := val.(type) {
+	for _, key := range keys {
+		switch v := fields[key].(type) {
 		case []any:
-			childType := entityType + "_" + key
-			parentFKCol := entityType + "_pk"
+			childType, parentFKCol := cfg.ChildTable(entityType, key)
 			childTbl, ok := tables[childType]
 			if !ok {
 				childTbl = newDiscoveredTable(childType)
 				tables[childType] = childTbl
 			}
 			childTbl.addColumn(parentFKCol)
-			discoverArrayColumns(childType, v, tables, pathIndex)
+			discoverArrayColumns(cfg, childType, v, tables, pathIndex)
 		default:
-			_ = v
-			tbl.addColumn(key)
+			// Every table has the standard columns already; a record
+			// setting one is reported by the validator.
+			if !isStandardColumn(cfg, key) {
+				tbl.addColumn(key)
+			}
+		}
+	}
+}
+
+// isStandardColumn reports whether name is one of cfg's standard columns,
+// ignoring case as SQLite does.
+func isStandardColumn(cfg *config.Config, name string) bool {
+	for std := range cfg.StandardColumnNames() {
+		if strings.EqualFold(std, name) {
+			return true
 		}
 	}
+	return false
 }
 
-func discoverArrayColumns(childType string, elems []any, tables map[string]*discoveredTable, pathIndex map[string]string) {
+// refTable returns the table of the entity at the reference path, given the
+// pk → table index of entity files. A record of a multi-document file is
+// found by its file's part of the path, before the "#".
+func refTable(pathIndex map[string]string, path string) string {
+	if t, ok := pathIndex[path]; ok {
+		return t
+	}
+	if file, _, ok := strings.Cut(path, "#"); ok {
+		return pathIndex[file]
+	}
+	return ""
+}
+
+func discoverArrayColumns(cfg *config.Config, childType string, elems []any, tables map[string]*discoveredTable, pathIndex map[string]string) {
 	tbl := tables[childType]
 	for _, elem := range elems {
 		switch e := elem.(type) {
 		case map[string]any:
-			discoverColumns(childType, e, tables, pathIndex)
+			discoverColumns(cfg, childType, e, loader.OrderedKeys(e, nil), tables, pathIndex)
 		case loader.EntityRef:
-			refEntityType := pathIndex[e.Path]
+			refEntityType := refTable(pathIndex, e.Path)
 			if refEntityType == "" {
 				tbl.addColumn("ref_pk")
 			} else {
@@ -435,9 +1504,48 @@ func discoverArrayColumns(childType string, elems []any, tables map[string]*disc
 // Entity expansion
 // ---------------------------------------------------------------------------
 
+// expander shreds entity field maps into ExpandedRecords.
+type expander struct {
+	cfg        *config.Config
+	schema     *dbml.Schema             // nil in schema-less mode
+	pathIndex  map[string]string        // pk → entity type; nil in DBML mode
+	increments map[string]*incrementLog // table → its [pk, increment] ids
+	children   map[string]childRef      // child table → its parent
+	redactKey  []byte                   // see redactKey
+}
+
+// storesAsColumn reports whether the array field key of table is kept as a
+// JSON value in the table's own column instead of being expanded into a
+// child table. That is the case in DBML mode when the table declares a
+// column of that name and sqlfs.yaml does not map the key to a child table.
+func (x *expander) storesAsColumn(table, key string) bool {
+	if x.schema == nil || x.cfg.IsChildKey(table, key) {
+		return false
+	}
+	t := x.schema.TableByName(table)
+	return t != nil && t.ColumnByName(key) != nil
+}
+
+// isJSONColumn reports whether table declares key as a json or jsonb column
+// and Config.JSONText is set, so its values are all stored as JSON text for
+// json_valid to hold for them.
+func (x *expander) isJSONColumn(table, key string) bool {
+	if x.schema == nil || !x.cfg.JSONText {
+		return false
+	}
+	t := x.schema.TableByName(table)
+	if t == nil {
+		return false
+	}
+	col := t.ColumnByName(key)
+	return col != nil && col.IsJSON()
+}
+
 // expandEntity shreds an entity's raw fields into a primary ExpandedRecord plus
-// child ExpandedRecords for each nested array field.
-func expandEntity(entityType, pk string, fr *loader.FileRecord, fields map[string]any, pathIndex map[string]string) []*loader.ExpandedRecord {
+// child ExpandedRecords for each nested array field, visiting fields in keys
+// order so child rows are produced in the same order on every build. lines
+// is the source record's Record.Lines and at the path of fields in it.
+func (x *expander) expandEntity(entityType, pk string, fr *loader.FileRecord, fields map[string]any, keys []string, lines map[string]int, at string) []*loader.ExpandedRecord {
 	primary := &loader.ExpandedRecord{
 		TableName:  entityType,
 		PK:         pk,
@@ -445,35 +1553,74 @@ func expandEntity(entityType, pk string, fr *loader.FileRecord, fields map[strin
 		ModTime:    fr.ModTime,
 		CreatedAt:  fr.CreatedAt,
 		Checksum:   fr.Checksum,
+		DeletedAt:  fr.DeletedAt,
+		Line:       lines[at],
 		Fields:     make(map[string]any),
 	}
 
 	var all []*loader.ExpandedRecord
 	all = append(all, primary)
 
-	for key, val := range fields {
+	for _, key := range keys {
+		val := fields[key]
 		switch v := val.(type) {
 		case []any:
-			children := expandArray(entityType, pk, key, fr, v, pathIndex)
+			if x.storesAsColumn(entityType, key) {
+				primary.Fields[key] = flattenScalar(v)
+				continue
+			}
+			children := x.expandArray(entityType, pk, key, fr, v, lines, at)
 			all = append(all, children...)
 		case loader.EntityRef:
 			primary.Fields[key] = v.Path
 		default:
+			if x.isJSONColumn(entityType, key) && val != nil {
+				primary.Fields[key] = jsonText(val)
+				continue
+			}
 			primary.Fields[key] = flattenScalar(val)
 		}
 	}
+	x.generateUUIDs(primary)
+	x.assignIncrement(primary)
 
 	return all
 }
 
-// expandArray creates child ExpandedRecords from one array field.
-func expandArray(parentType, parentPK, arrayKey string, fr *loader.FileRecord, elems []any, pathIndex map[string]string) []*loader.ExpandedRecord {
-	childTable := parentType + "_" + arrayKey
-	parentFKCol := parentType + "_pk"
+// generateUUIDs fills the record's omitted uuid primary key columns when
+// Config.GenerateUUIDs is set. Version 7 UUIDs carry the file's creation time,
+// like the ULID standard column.
+func (x *expander) generateUUIDs(rec *loader.ExpandedRecord) {
+	if x.schema == nil || x.cfg.GenerateUUIDs == "" {
+		return
+	}
+	t := x.schema.TableByName(rec.TableName)
+	if t == nil {
+		return
+	}
+	for _, col := range t.Columns {
+		if !col.PK || !strings.EqualFold(col.Type.Name, "uuid") {
+			continue
+		}
+		if v, ok := rec.Fields[col.Name]; ok && v != nil {
+			continue
+		}
+		g := uuidIDs{v7: x.cfg.GenerateUUIDs == config.UUIDv7, deterministic: x.cfg.Deterministic}
+		rec.Fields[col.Name] = g.newID(rec, col.Name)
+	}
+}
+
+// expandArray creates child ExpandedRecords from one array field. Each child
+// carries a back-reference column holding the parent's PK. lines and at
+// locate the parent as in expandEntity.
+func (x *expander) expandArray(parentType, parentPK, arrayKey string, fr *loader.FileRecord, elems []any, lines map[string]int, at string) []*loader.ExpandedRecord {
+	childTable, parentFKCol := x.cfg.ChildTable(parentType, arrayKey)
+	x.noteChild(childTable, parentType, parentFKCol)
 	var all []*loader.ExpandedRecord
 
 	for i, elem := range elems {
 		childPK := parentPK + "#" + childTable + "-" + strconv.Itoa(i)
+		childAt := loader.LinePath(at, arrayKey, i)
 
 		switch e := elem.(type) {
 		case map[string]any:
@@ -489,15 +1636,16 @@ func expandArray(parentType, parentPK, arrayKey string, fr *loader.FileRecord, e
 				ModTime:    fr.ModTime,
 				CreatedAt:  fr.CreatedAt,
 				Checksum:   fr.Checksum,
+				DeletedAt:  fr.DeletedAt,
 			}
-			children := expandEntity(childTable, childPK, childFR, childFields, pathIndex)
+			children := x.expandEntity(childTable, childPK, childFR, childFields, loader.OrderedKeys(childFields, []string{parentFKCol}), lines, childAt)
 			all = append(all, children...)
 
 		case loader.EntityRef:
 			// Reference → join record.
 			refEntityType := ""
-			if pathIndex != nil {
-				refEntityType = pathIndex[e.Path]
+			if x.pathIndex != nil {
+				refEntityType = refTable(x.pathIndex, e.Path)
 			}
 			refFKCol := "ref_pk"
 			if refEntityType != "" {
@@ -510,6 +1658,8 @@ func expandArray(parentType, parentPK, arrayKey string, fr *loader.FileRecord, e
 				ModTime:    fr.ModTime,
 				CreatedAt:  fr.CreatedAt,
 				Checksum:   fr.Checksum,
+				DeletedAt:  fr.DeletedAt,
+				Line:       lines[childAt],
 				Fields: map[string]any{
 					parentFKCol: parentPK,
 					refFKCol:    e.Path,
@@ -526,6 +1676,8 @@ func expandArray(parentType, parentPK, arrayKey string, fr *loader.FileRecord, e
 				ModTime:    fr.ModTime,
 				CreatedAt:  fr.CreatedAt,
 				Checksum:   fr.Checksum,
+				DeletedAt:  fr.DeletedAt,
+				Line:       lines[childAt],
 				Fields: map[string]any{
 					parentFKCol: parentPK,
 					"value":     flattenScalar(elem),
@@ -555,33 +1707,65 @@ func flattenScalar(v any) any {
 	}
 }
 
+// jsonText returns v encoded as JSON text, scalars included.
+func jsonText(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", fmt.Sprint(v))
+	}
+	return string(b)
+}
+
 // insertExpandedRecord inserts one expanded record into SQLite.
-func insertExpandedRecord(db *sqlite.DB, rec *loader.ExpandedRecord, cfg *config.Config) error {
+func insertExpandedRecord(db *sqlite.DB, rec *loader.ExpandedRecord, cfg *config.Config, ids IDGenerator) error {
 	sc := cfg.StandardColumns
 
 	cols := make([]string, 0, len(rec.Fields)+6)
 	vals := make([]any, 0, len(rec.Fields)+6)
 
-	for col, val := range rec.Fields {
+	// Columns go in a fixed order so that records of a table with the same
+	// fields share one prepared statement.
+	for col := range rec.Fields {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	for _, col := range cols {
+		val := rec.Fields[col]
 		if ref, ok := val.(loader.EntityRef); ok {
 			val = ref.Path
 		}
-		cols = append(cols, col)
 		vals = append(vals, val)
 	}
 
-	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
-	id := ulid.MustNew(ulid.Timestamp(rec.CreatedAt), entropy)
-
 	cols = append(cols, sc.PK, sc.Path, sc.CreatedAt, sc.ModifiedAt, sc.Checksum, sc.ULID)
 	vals = append(vals,
 		rec.PK,
-		rec.SourcePath+"#"+rec.PK,
-		rec.CreatedAt.UTC().Format(time.RFC3339),
-		rec.ModTime.UTC().Format(time.RFC3339),
+		cfg.FormatPath(rec.SourcePath, rec.PK),
+		cfg.FormatTimestamp(rec.CreatedAt),
+		cfg.FormatTimestamp(rec.ModTime),
 		rec.Checksum,
-		id.String(),
+		ids.NewID(rec),
 	)
+	if cfg.KeepTombstones() {
+		var deletedAt any
+		if !rec.DeletedAt.IsZero() {
+			deletedAt = cfg.FormatTimestamp(rec.DeletedAt)
+		}
+		cols = append(cols, sc.DeletedAt)
+		vals = append(vals, deletedAt)
+	}
+	if cfg.RecordChecksums {
+		cols = append(cols, sc.RecordChecksum)
+		vals = append(vals, loader.RecordChecksum(rec.Fields))
+	}
+	if cfg.SourceLines {
+		var line any
+		if rec.Line > 0 {
+			line = rec.Line
+		}
+		cols = append(cols, sc.SourceLine)
+		vals = append(vals, line)
+	}
 
 	if err := db.InsertRecord(rec.TableName, cols, vals); err != nil {
 		log.Printf("warning: insert error for table %s pk %s: %v", rec.TableName, rec.PK, err)
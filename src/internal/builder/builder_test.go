@@ -1,13 +1,38 @@
 package builder
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
 
 	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/encrypt"
+	"github.com/notwillk/sqlfs/internal/loader"
+	"github.com/notwillk/sqlfs/internal/snapshot"
 	"github.com/notwillk/sqlfs/internal/sqlite"
+	"github.com/notwillk/sqlfs/internal/transform"
 )
 
 // setupTestDir creates a temp dir with a users schema and two single-entity files.
@@ -255,6 +280,223 @@ func TestBuild_SchemalessMode(t *testing.T) {
 	}
 }
 
+func TestBuild_TableMappings(t *testing.T) {
+	dir := t.TempDir()
+	schema := "Table users {\n  name varchar\n}\nTable admins {\n  name varchar\n}\n"
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(schema), 0644)
+	os.MkdirAll(filepath.Join(dir, "people", "admins"), 0755)
+	os.WriteFile(filepath.Join(dir, "people", "alice.yaml"), []byte("name: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "people", "admins", "root.yaml"), []byte("name: Root\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("name: Bob\n"), 0644)
+
+	cfg := config.Default()
+	cfg.Tables = []config.TableMapping{
+		{Pattern: "people/admins/*.yaml", Table: "admins"},
+		{Pattern: "people/**.yaml", Table: "users"},
+	}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for table, want := range map[string]string{"users": "Alice,Bob", "admins": "Root"} {
+		var got string
+		if err := db.DB().QueryRow(fmt.Sprintf(`SELECT group_concat(name, ',') FROM (SELECT name FROM %s ORDER BY name)`, table)).Scan(&got); err != nil {
+			t.Fatalf("query %s: %v", table, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", table, got, want)
+		}
+	}
+}
+
+func TestBuild_TableFromDirectory(t *testing.T) {
+	dir := t.TempDir()
+	schema := "Table users {\n  name varchar\n}\nTable posts {\n  title varchar\n}\n"
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(schema), 0644)
+	os.MkdirAll(filepath.Join(dir, "users"), 0755)
+	os.MkdirAll(filepath.Join(dir, "content", "posts"), 0755)
+	os.WriteFile(filepath.Join(dir, "users", "alice.yaml"), []byte("name: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "users", "bob.v2.yaml"), []byte("name: Bob\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "content", "posts", "hello.yaml"), []byte("title: Hello\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "carol.users.yaml"), []byte("name: Carol\n"), 0644)
+
+	cfg := config.Default()
+	cfg.TableFrom = config.TableFromDirectory
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for query, want := range map[string]string{
+		`SELECT group_concat(name, ',') FROM (SELECT name FROM users ORDER BY name)`: "Alice,Bob,Carol",
+		`SELECT group_concat(title, ',') FROM posts`:                                 "Hello",
+	} {
+		var got string
+		if err := db.DB().QueryRow(query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestBuild_TableFromFields(t *testing.T) {
+	dir := t.TempDir()
+	schema := "Table users {\n  name varchar\n  email varchar\n  phone varchar\n}\n" +
+		"Table authors {\n  name varchar\n  email varchar\n  bio text\n}\n" +
+		"Table posts {\n  title varchar\n  body text\n}\n"
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(schema), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.yaml"), []byte("name: Alice\nemail: a@example.com\nphone: \"1\"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "hello.yaml"), []byte("title: Hello\nbody: Hi\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "carol.users.yaml"), []byte("name: Carol\n"), 0644)
+	// bob matches users and authors equally well, and note matches posts
+	// too poorly, so both are left out rather than guessed.
+	os.WriteFile(filepath.Join(dir, "bob.yaml"), []byte("name: Bob\nemail: b@example.com\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "note.yaml"), []byte("title: Note\ncolor: red\nsize: 2\n"), 0644)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	cfg := config.Default()
+	cfg.TableFrom = config.TableFromFields
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, want := range []string{
+		`skipping "bob.yaml": no entity type in filename and its fields match authors and users equally well, with a score of 0.67`,
+		`skipping "note.yaml": no entity type in filename and its fields match posts best, with a score of 0.25, below min_match_ratio 0.50`,
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log = %q, want %q", logged.String(), want)
+		}
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for query, want := range map[string]string{
+		`SELECT group_concat(name, ',') FROM (SELECT name FROM users ORDER BY name)`: "Alice,Carol",
+		`SELECT count(*) FROM authors`:               "0",
+		`SELECT group_concat(title, ',') FROM posts`: "Hello",
+	} {
+		var got string
+		if err := db.DB().QueryRow(query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", query, got, want)
+		}
+	}
+
+	// Every file matched by its fields is recorded with its two best
+	// tables, including those skipped; carol got her table from her name.
+	rows, err := db.DB().Query(`SELECT path, coalesce(table_name, ''), round(coalesce(score, 0), 2), coalesce(runner_up, ''), round(coalesce(runner_up_score, 0), 2), skipped IS NOT NULL FROM ` + MatchesTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var path, table, runnerUp string
+		var score, runnerUpScore float64
+		var skipped bool
+		if err := rows.Scan(&path, &table, &score, &runnerUp, &runnerUpScore, &skipped); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %.2f %s %.2f %t", path, table, score, runnerUp, runnerUpScore, skipped))
+	}
+	want := []string{
+		"alice.yaml users 1.00 authors 0.50 false",
+		"bob.yaml authors 0.67 users 0.67 true",
+		"hello.yaml posts 1.00  0.00 false",
+		"note.yaml posts 0.25  0.00 true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", MatchesTable, got, want)
+	}
+
+	// An incremental build keeps the matches of unchanged files.
+	cache := NewCache()
+	for range 2 {
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg, Cache: cache}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+	}
+	db2, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	var alice string
+	if err := db2.DB().QueryRow(`SELECT runner_up FROM ` + MatchesTable + ` WHERE path = 'alice.yaml'`).Scan(&alice); err != nil || alice != "authors" {
+		t.Errorf("runner_up of alice.yaml after an incremental build = %q, %v; want authors", alice, err)
+	}
+}
+
+func TestMatchTable(t *testing.T) {
+	s, err := dbml.Parse([]byte("Table users {\n  id int [pk]\n  name varchar\n  email varchar\n  phone varchar\n}\nTable tags {\n  name varchar\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	fr := &loader.FileRecord{Records: []loader.Record{{Fields: map[string]any{"Name": "a", "email": "b", "__path__": "c"}}}}
+	m := MatchTable(s, cfg, fr)
+	want := []TableScore{{"tags", 0.5}, {"users", 0.5}}
+	if m.Table != "" || !reflect.DeepEqual(m.Scores, want) {
+		t.Errorf("MatchTable = %+v, want a tie with scores %v", m, want)
+	}
+	cfg.MinMatchRatio = 0.25
+	fr.Records[0].Fields["phone"] = "d"
+	if m := MatchTable(s, cfg, fr); m.Table != "users" || m.Scores[0].Score != 0.75 {
+		t.Errorf("MatchTable = %+v, want users with a score of 0.75", m)
+	}
+}
+
+func TestBuild_SchemalessCollations(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice.user.yaml"), []byte("name: Alice\n"), 0644)
+
+	cfg := config.Default()
+	cfg.Collations = map[string]map[string]string{"user": {"name": "nocase"}}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.DB().QueryRow(`SELECT count(*) FROM user WHERE name = 'ALICE'`).Scan(&n); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("case-insensitive match count = %d, want 1", n)
+	}
+
+	cfg.Collations["user"]["name"] = "klingon"
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err == nil {
+		t.Error("expected an error for an unknown collation")
+	}
+}
+
 // TestBuild_SchemalessNested verifies nested arrays create child tables in schema-less mode.
 func TestBuild_SchemalessNested(t *testing.T) {
 	dir := t.TempDir()
@@ -347,3 +589,2501 @@ func TestBuild_ContextCancelled(t *testing.T) {
 		t.Log("no error on cancelled context (walked before cancel took effect)")
 	}
 }
+
+func TestBuild_Timeout(t *testing.T) {
+	dir := setupTestDir(t)
+	cfg := config.Default()
+	cfg.BuildTimeout = time.Nanosecond
+
+	_, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: filepath.Join(t.TempDir(), "test.db"),
+		Config:     cfg,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if !strings.Contains(err.Error(), "build_timeout") {
+		t.Errorf("error should name build_timeout: %v", err)
+	}
+}
+
+func TestBuild_SQLFormat(t *testing.T) {
+	dir := setupTestDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.sql")
+
+	if _, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     config.Default(),
+		Format:     FormatSQL,
+	}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("reading dump: %v", err)
+	}
+	dump := string(data)
+	if !strings.Contains(dump, `CREATE TABLE "users"`) {
+		t.Errorf("dump missing CREATE TABLE:\n%s", dump)
+	}
+	if got := strings.Count(dump, `INSERT INTO "users"`); got != 2 {
+		t.Errorf("INSERT count = %d, want 2", got)
+	}
+}
+
+func TestBuild_CopyFormat(t *testing.T) {
+	dir := setupTestDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.copy")
+
+	if _, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     config.Default(),
+		Format:     FormatCopy,
+	}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("reading dump: %v", err)
+	}
+	dump := string(data)
+	if !strings.Contains(dump, "\\copy \"users\" (") {
+		t.Errorf("dump missing \\copy of users:\n%s", dump)
+	}
+	if strings.Contains(dump, BuildInfoTable) {
+		t.Errorf("dump includes %s:\n%s", BuildInfoTable, dump)
+	}
+	if strings.Contains(dump, "CREATE TABLE") {
+		t.Errorf("dump creates tables:\n%s", dump)
+	}
+}
+
+func TestBuild_UnknownFormat(t *testing.T) {
+	dir := setupTestDir(t)
+	_, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: filepath.Join(t.TempDir(), "test.db"),
+		Config:     config.Default(),
+		Format:     "csv",
+	})
+	if err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestBuild_Encrypted(t *testing.T) {
+	dir := setupTestDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.db")
+
+	if _, err := Build(context.Background(), Options{
+		RootDir:       dir,
+		OutputFile:    outFile,
+		Config:        config.Default(),
+		EncryptionKey: "s3cret",
+	}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encrypt.IsEncrypted(data) {
+		t.Fatal("output is not encrypted")
+	}
+	plain, err := encrypt.Decrypt(data, "s3cret")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !strings.HasPrefix(string(plain), "SQLite format 3") {
+		t.Error("decrypted output is not a SQLite database")
+	}
+}
+
+// setupTombstoneDir extends setupTestDir with one entity deleted via the
+// __deleted__ field and one deleted via a sibling .deleted marker.
+func setupTombstoneDir(t *testing.T) string {
+	t.Helper()
+	dir := setupTestDir(t)
+	carol := "id: 3\nname: Carol\n__deleted__: true\n"
+	if err := os.WriteFile(filepath.Join(dir, "carol.users.yaml"), []byte(carol), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bob.users.yaml.deleted"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestBuild_TombstonesSkipped(t *testing.T) {
+	dir := setupTombstoneDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.db")
+
+	result, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     config.Default(),
+	})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if result.RecordsTotal != 1 {
+		t.Errorf("RecordsTotal = %d, want 1 (only alice)", result.RecordsTotal)
+	}
+}
+
+func TestBuild_TombstonesKept(t *testing.T) {
+	dir := setupTombstoneDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.db")
+
+	cfg := config.Default()
+	cfg.Tombstones = config.TombstoneKeep
+	result, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     cfg,
+	})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if result.RecordsTotal != 3 {
+		t.Errorf("RecordsTotal = %d, want 3", result.RecordsTotal)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT __pk__ FROM users WHERE __deleted_at__ IS NOT NULL ORDER BY __pk__")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var deleted []string
+	for rows.Next() {
+		var pk string
+		rows.Scan(&pk)
+		deleted = append(deleted, pk)
+	}
+	if strings.Join(deleted, ",") != "bob,carol" {
+		t.Errorf("deleted = %v, want [bob carol]", deleted)
+	}
+}
+
+func TestBuild_TombstonesPerRecord(t *testing.T) {
+	dir := setupTestDir(t)
+	more := "id: 4\nname: Dan\n---\nid: 5\nname: Eve\n__deleted__: true\n---\nid: 6\nname: Fay\n"
+	if err := os.WriteFile(filepath.Join(dir, "more.users.yaml"), []byte(more), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		mode        config.TombstoneBehavior
+		live, names string
+	}{
+		{config.TombstoneSkip, "Alice Smith,Bob Jones,Dan,Fay", "Alice Smith,Bob Jones,Dan,Fay"},
+		{config.TombstoneKeep, "Alice Smith,Bob Jones,Dan,Fay", "Alice Smith,Bob Jones,Dan,Eve,Fay"},
+	} {
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		cfg := config.Default()
+		cfg.Tombstones = tc.mode
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("%s: Build: %v", tc.mode, err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		live := "SELECT group_concat(name, ',') FROM (SELECT name FROM users ORDER BY id)"
+		if tc.mode == config.TombstoneKeep {
+			live = "SELECT group_concat(name, ',') FROM (SELECT name FROM users WHERE __deleted_at__ IS NULL ORDER BY id)"
+		}
+		var gotLive, gotNames string
+		if err := db.DB().QueryRow(live).Scan(&gotLive); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DB().QueryRow("SELECT group_concat(name, ',') FROM (SELECT name FROM users ORDER BY id)").Scan(&gotNames); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+		if gotLive != tc.live || gotNames != tc.names {
+			t.Errorf("%s: live = %s, all = %s; want %s and %s", tc.mode, gotLive, gotNames, tc.live, tc.names)
+		}
+	}
+}
+
+func TestBuild_KeepSnapshots(t *testing.T) {
+	dir := setupTestDir(t)
+	outDir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.KeepSnapshots = 2
+	for i := 0; i < 3; i++ {
+		outFile := filepath.Join(outDir, fmt.Sprintf("build%d.db", i))
+		result, err := Build(context.Background(), Options{
+			RootDir:     dir,
+			OutputFile:  outFile,
+			Config:      cfg,
+			SnapshotDir: filepath.Join(outDir, "snapshots"),
+		})
+		if err != nil {
+			t.Fatalf("Build %d: %v", i, err)
+		}
+		if result.SnapshotID == "" {
+			t.Errorf("Build %d: SnapshotID is empty", i)
+		}
+	}
+
+	entries, err := snapshot.List(filepath.Join(outDir, "snapshots"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("len(snapshots) = %d, want 2", len(entries))
+	}
+	if len(entries) > 0 && entries[0].Records != 2 {
+		t.Errorf("snapshot Records = %d, want 2", entries[0].Records)
+	}
+}
+
+func TestBuild_DeferSnapshot(t *testing.T) {
+	dir := setupTestDir(t)
+	outDir := t.TempDir()
+	snapshots := filepath.Join(outDir, "snapshots")
+
+	cfg := config.Default()
+	cfg.KeepSnapshots = 2
+	opts := Options{
+		RootDir:       dir,
+		OutputFile:    filepath.Join(outDir, "out.db.tmp"),
+		Config:        cfg,
+		SnapshotDir:   snapshots,
+		DeferSnapshot: true,
+	}
+	result, err := Build(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if entries, _ := snapshot.List(snapshots); result.SnapshotID != "" || len(entries) != 0 {
+		t.Errorf("deferred build saved snapshot %q, %d listed", result.SnapshotID, len(entries))
+	}
+
+	outFile := filepath.Join(outDir, "out.db")
+	if err := os.Rename(opts.OutputFile, outFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSnapshot(opts, outFile, result); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	entries, err := snapshot.List(snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != result.SnapshotID || entries[0].Records != 2 {
+		t.Errorf("snapshots = %+v, want one of 2 records with ID %q", entries, result.SnapshotID)
+	}
+}
+
+// TestBuild_ChildMapping verifies that a record array in a parent file is
+// expanded into the configured child table with a back-reference column.
+func TestBuild_ChildMapping(t *testing.T) {
+	dir := t.TempDir()
+	schema := `
+Table users {
+  name varchar
+}
+
+Table orders {
+  user_pk varchar
+  sku varchar
+  qty integer
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	alice := `{"name": "Alice", "orders": [{"sku": "A1", "qty": 2}, {"sku": "B2", "qty": 1}]}`
+	if err := os.WriteFile(filepath.Join(dir, "alice.users.json"), []byte(alice), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Children = map[string]map[string]config.ChildMapping{
+		"users": {"orders": {Table: "orders", ParentColumn: "user_pk"}},
+	}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	result, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     cfg,
+	})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if result.RecordsTotal != 3 {
+		t.Errorf("RecordsTotal = %d, want 3", result.RecordsTotal)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT user_pk, sku, qty FROM orders ORDER BY sku")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var parent, sku string
+		var qty int
+		rows.Scan(&parent, &sku, &qty)
+		got = append(got, fmt.Sprintf("%s:%s:%d", parent, sku, qty))
+	}
+	if strings.Join(got, ",") != "alice:A1:2,alice:B2:1" {
+		t.Errorf("orders = %v", got)
+	}
+
+	var child string
+	if err := db.DB().QueryRow(`SELECT table_name || ':' || parent_table || '.' || parent_key || '=' || parent_column FROM __sqlfs_children__`).Scan(&child); err != nil {
+		t.Fatalf("reading %s: %v", ChildrenTable, err)
+	}
+	if child != "orders:users.__pk__=user_pk" {
+		t.Errorf("%s holds %s", ChildrenTable, child)
+	}
+}
+
+// TestBuild_ArrayColumnStoredAsJSON verifies that an array field declared as
+// a column of its own table is stored as JSON rather than expanded.
+func TestBuild_ArrayColumnStoredAsJSON(t *testing.T) {
+	dir := t.TempDir()
+	schema := "Table posts {\n  title varchar\n  tags json\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	post := "title: Hello\ntags:\n  - go\n  - sql\n"
+	if err := os.WriteFile(filepath.Join(dir, "hello.posts.yaml"), []byte(post), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     config.Default(),
+	}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT tags FROM posts")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	rows.Next()
+	var tags string
+	rows.Scan(&tags)
+	if tags != `["go","sql"]` {
+		t.Errorf("tags = %q, want [\"go\",\"sql\"]", tags)
+	}
+}
+
+// TestBuild_SchemalessColumnOrder verifies that discovered columns follow the
+// source document's key order rather than map iteration order.
+func TestBuild_SchemalessColumnOrder(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice.user.yaml"), []byte("zeta: 1\nalpha: 2\nmid: 3\n"), 0644)
+
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     config.Default(),
+	}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT * FROM user")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(cols[len(cols)-3:], ",")
+	if got != "zeta,alpha,mid" {
+		t.Errorf("trailing columns = %s, want zeta,alpha,mid", got)
+	}
+}
+
+// TestBuild_Renames verifies that configured field renames are applied before
+// validation, so old field names pass a strict schema.
+func TestBuild_Renames(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  name varchar [not null]\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("fullname: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("fullname: Old\nname: Bob\n"), 0644)
+
+	cfg := config.Default()
+	cfg.Renames = map[string]map[string]string{"users": {"fullname": "name"}}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: outFile,
+		Config:     cfg,
+	}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT name FROM users ORDER BY __pk__")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		got = append(got, name)
+	}
+	if strings.Join(got, ",") != "Alice,Bob" {
+		t.Errorf("names = %v, want [Alice Bob]", got)
+	}
+}
+
+func TestBuild_Transforms(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  name varchar [not null]\n  age int\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("first: Alice\nlast: Smith\nborn: 1990\nlegacy: x\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "draft.users.yaml"), []byte("first: Draft\nlast: User\nborn: 2000\ndraft: true\n"), 0644)
+
+	cfg := config.Default()
+	prog, err := transform.Compile(`select(.draft | not) | {name: "\(.first) \(.last)", age: (2020 - .born)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Transforms = map[string]*transform.Program{"users": prog}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT name, age, (SELECT count(*) FROM users) FROM users")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var name string
+	var age, count int
+	if !rows.Next() {
+		t.Fatal("no users")
+	}
+	rows.Scan(&name, &age, &count)
+	if name != "Alice Smith" || age != 30 || count != 1 {
+		t.Errorf("got %q, %d across %d rows; want Alice Smith, 30 in 1 row", name, age, count)
+	}
+
+	prog, _ = transform.Compile(`.name = .first + .born`)
+	cfg.Transforms = map[string]*transform.Program{"users": prog}
+	_, err = Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg})
+	if err == nil || !strings.Contains(err.Error(), `transforming "alice.users.yaml"`) {
+		t.Errorf("Build error = %v, want a transformation error naming the file", err)
+	}
+}
+
+// TestBuild_Sample verifies that sampled builds keep a subset of each table
+// along with the records that subset references.
+func TestBuild_Sample(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "authors"), 0755)
+	os.MkdirAll(filepath.Join(dir, "posts"), 0755)
+	for i := 0; i < 20; i++ {
+		os.WriteFile(filepath.Join(dir, "authors", fmt.Sprintf("a%02d.authors.yaml", i)), []byte(fmt.Sprintf("name: Author %d\n", i)), 0644)
+	}
+	for i := 0; i < 20; i++ {
+		os.WriteFile(filepath.Join(dir, "posts", fmt.Sprintf("p%02d.posts.yaml", i)), []byte(fmt.Sprintf("title: Post %d\nauthor: \"&authors/a%02d\"\n", i, 19-i)), 0644)
+	}
+
+	count := func(sample Sample) (posts, authors int, missing int) {
+		t.Helper()
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), Sample: sample}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query(`SELECT (SELECT count(*) FROM posts), (SELECT count(*) FROM authors),
+			(SELECT count(*) FROM posts WHERE author NOT IN (SELECT __pk__ FROM authors))`)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		rows.Next()
+		rows.Scan(&posts, &authors, &missing)
+		return posts, authors, missing
+	}
+
+	// The first two posts reference the last two authors, which are kept
+	// on top of the first two.
+	if posts, authors, missing := count(Sample{PerTable: 2}); posts != 2 || authors != 4 || missing != 0 {
+		t.Errorf("limit 2: %d posts, %d authors, %d dangling; want 2, 4, 0", posts, authors, missing)
+	}
+	posts, authors, missing := count(Sample{Percent: 50})
+	if posts == 0 || posts == 20 || authors < posts || missing != 0 {
+		t.Errorf("50%%: %d posts, %d authors, %d dangling", posts, authors, missing)
+	}
+	if again, _, _ := count(Sample{Percent: 50}); again != posts {
+		t.Errorf("50%% sample kept %d posts, then %d", posts, again)
+	}
+	if posts, authors, _ := count(Sample{}); posts != 20 || authors != 20 {
+		t.Errorf("no sample: %d posts, %d authors; want 20, 20", posts, authors)
+	}
+
+	// The files the sample picks are handed to the build loaded; those kept
+	// only for references are loaded again.
+	var walked []walkedFile
+	for _, table := range []string{"authors", "posts"} {
+		for i := 0; i < 20; i++ {
+			relPath := fmt.Sprintf("%s/%c%02d.%s.yaml", table, table[0], i, table)
+			walked = append(walked, walkedFile{filepath.Join(dir, relPath), relPath, table})
+		}
+	}
+	cfg := config.Default()
+	kept, _, loaded, err := chooseSample(context.Background(), walked, NewRegistry(cfg), nil, 0, Sample{PerTable: 2})
+	if err != nil {
+		t.Fatalf("chooseSample: %v", err)
+	}
+	var preloaded []string
+	for _, wf := range kept {
+		if loaded.frs[wf.path] != nil {
+			preloaded = append(preloaded, wf.relPath)
+		}
+	}
+	if got := strings.Join(preloaded, ","); got != "authors/a00.authors.yaml,authors/a01.authors.yaml,posts/p00.posts.yaml,posts/p01.posts.yaml" {
+		t.Errorf("loaded = %s", got)
+	}
+	if fr, err := loaded.load(nil, kept[0].path, kept[0].relPath); err != nil || fr == nil || loaded.frs[kept[0].path] != nil {
+		t.Errorf("load = %v, %v; want the loaded file, handed out once", fr, err)
+	}
+
+	_, err = Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: config.Default(), Sample: Sample{Percent: 150}})
+	if err == nil || !strings.Contains(err.Error(), "not between 0 and 100") {
+		t.Errorf("Build error = %v, want an invalid percentage", err)
+	}
+}
+
+// TestBuild_SampleRenames verifies that a sampled build renames fields once,
+// like a full build, even when renames chain or swap names.
+func TestBuild_SampleRenames(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table notes {\n  a varchar\n  b varchar\n  c varchar\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "one.notes.yaml"), []byte("a: x\nb: y\n"), 0644)
+
+	cfg := config.Default()
+	cfg.Renames = map[string]map[string]string{"notes": {"a": "b", "b": "c"}}
+	row := func(sample Sample) string {
+		t.Helper()
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg, Sample: sample}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var got string
+		if err := db.DB().QueryRow(`SELECT coalesce(a, '-') || coalesce(b, '-') || coalesce(c, '-') FROM notes`).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if full, sampled := row(Sample{}), row(Sample{PerTable: 10}); full != "-xy" || sampled != full {
+		t.Errorf("full build a, b, c = %s, sampled %s; want -xy", full, sampled)
+	}
+}
+
+// TestBuild_RecordChecksums verifies that the per-record checksum column
+// changes only for the rows whose own fields changed.
+func TestBuild_RecordChecksums(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "meal.meals.yaml"), []byte("name: Dinner\ncourses:\n  - name: Soup\n  - name: Cake\n"), 0644)
+
+	cfg := config.Default()
+	cfg.RecordChecksums = true
+	sums := func() map[string]string {
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query(`SELECT __pk__, __record_checksum__ FROM meals_courses`)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		out := map[string]string{}
+		for rows.Next() {
+			var pk, sum string
+			rows.Scan(&pk, &sum)
+			out[pk] = sum
+		}
+		return out
+	}
+
+	before := sums()
+	os.WriteFile(filepath.Join(dir, "meal.meals.yaml"), []byte("name: Dinner\ncourses:\n  - name: Soup\n  - name: Pie\n"), 0644)
+	after := sums()
+
+	if len(before) != 2 {
+		t.Fatalf("got %d course rows, want 2", len(before))
+	}
+	changed := 0
+	for pk, sum := range before {
+		if sum == "" {
+			t.Errorf("%s: empty record checksum", pk)
+		}
+		if after[pk] != sum {
+			changed++
+		}
+	}
+	if changed != 1 {
+		t.Errorf("%d course checksums changed, want 1", changed)
+	}
+}
+
+// TestBuild_MinVersion verifies that a project requiring a newer sqlfs, via
+// sqlfs.yaml or the DBML Project block, is refused.
+func TestBuild_MinVersion(t *testing.T) {
+	dir := setupTestDir(t)
+	cfg := config.Default()
+	cfg.MinVersion = "999.0"
+	_, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "a.db"), Config: cfg})
+	if err == nil || !strings.Contains(err.Error(), "requires sqlfs 999.0") {
+		t.Errorf("sqlfs.yaml pin: err = %v", err)
+	}
+
+	schema, err := os.ReadFile(filepath.Join(dir, "schema.dbml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := "Project p {\n  min_sqlfs_version: '999.0'\n}\n" + string(schema)
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(pinned), 0644)
+	_, err = Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "b.db"), Config: config.Default()})
+	if err == nil || !strings.Contains(err.Error(), "requires sqlfs 999.0") {
+		t.Errorf("Project pin: err = %v", err)
+	}
+}
+
+// TestBuild_GenerateUUIDs verifies that omitted uuid primary keys are filled
+// with UUIDs of the configured version, and given ones are kept.
+func TestBuild_GenerateUUIDs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id uuid [pk]\n  name varchar\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("name: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("id: 6ba7b810-9dad-11d1-80b4-00c04fd430c8\nname: Bob\n"), 0644)
+
+	for version, want := range map[config.UUIDVersion]uuid.Version{config.UUIDv4: 4, config.UUIDv7: 7} {
+		cfg := config.Default()
+		cfg.GenerateUUIDs = version
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("%s: Build: %v", version, err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := map[string]string{}
+		rows, err := db.Query(`SELECT name, id FROM users`)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		for rows.Next() {
+			var name, id string
+			rows.Scan(&name, &id)
+			ids[name] = id
+		}
+		rows.Close()
+		db.Close()
+
+		if ids["Bob"] != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+			t.Errorf("%s: Bob id = %q, want the given UUID", version, ids["Bob"])
+		}
+		id, err := uuid.Parse(ids["Alice"])
+		if err != nil {
+			t.Fatalf("%s: Alice id %q: %v", version, ids["Alice"], err)
+		}
+		if id.Version() != want {
+			t.Errorf("%s: Alice id version = %d, want %d", version, id.Version(), want)
+		}
+	}
+}
+
+// TestBuild_IDStrategies verifies the format of the ULID standard column
+// under each ID strategy, and that path-hash IDs repeat across builds.
+func TestBuild_IDStrategies(t *testing.T) {
+	dir := setupTestDir(t)
+	for strategy, valid := range map[config.IDStrategy]func(string) bool{
+		config.IDULID:      func(id string) bool { _, err := ulid.Parse(id); return err == nil },
+		config.IDUUIDv4:    func(id string) bool { u, err := uuid.Parse(id); return err == nil && u.Version() == 4 },
+		config.IDUUIDv7:    func(id string) bool { u, err := uuid.Parse(id); return err == nil && u.Version() == 7 },
+		config.IDSnowflake: func(id string) bool { n, err := strconv.ParseInt(id, 10, 64); return err == nil && n > 0 },
+		config.IDPathHash:  func(id string) bool { _, err := hex.DecodeString(id); return err == nil && len(id) == 32 },
+	} {
+		cfg := config.Default()
+		cfg.IDStrategy = strategy
+		var builds [2][]string
+		for i := range builds {
+			outFile := filepath.Join(t.TempDir(), "test.db")
+			if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+				t.Fatalf("%s: Build: %v", strategy, err)
+			}
+			db, err := sqlite.Open(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := db.Query(`SELECT __ulid__ FROM users ORDER BY __pk__`)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			for rows.Next() {
+				var id string
+				rows.Scan(&id)
+				builds[i] = append(builds[i], id)
+			}
+			rows.Close()
+			db.Close()
+		}
+
+		seen := map[string]bool{}
+		for _, id := range builds[0] {
+			if !valid(id) {
+				t.Errorf("%s: malformed id %q", strategy, id)
+			}
+			if seen[id] {
+				t.Errorf("%s: duplicate id %q", strategy, id)
+			}
+			seen[id] = true
+		}
+		stable := strings.Join(builds[0], ",") == strings.Join(builds[1], ",")
+		if stable != (strategy == config.IDPathHash) {
+			t.Errorf("%s: ids the same across builds = %v", strategy, stable)
+		}
+	}
+}
+
+// TestBuild_Deterministic verifies that deterministic builds of the same files
+// are byte-identical under each ID strategy that allows them, and that their
+// IDs keep the format and version of the strategy.
+func TestBuild_Deterministic(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id uuid [pk]\n  name varchar\n}\n"), 0644)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		os.WriteFile(filepath.Join(dir, name+".users.yaml"), []byte("name: "+name+"\n"), 0644)
+	}
+
+	for strategy, valid := range map[config.IDStrategy]func(string) bool{
+		config.IDULID:     func(id string) bool { _, err := ulid.Parse(id); return err == nil },
+		config.IDUUIDv4:   func(id string) bool { u, err := uuid.Parse(id); return err == nil && u.Version() == 4 },
+		config.IDUUIDv7:   func(id string) bool { u, err := uuid.Parse(id); return err == nil && u.Version() == 7 },
+		config.IDPathHash: func(id string) bool { return len(id) == 32 },
+	} {
+		cfg := config.Default()
+		cfg.IDStrategy = strategy
+		cfg.GenerateUUIDs = config.UUIDv7
+		cfg.Deterministic = true
+		var builds [2][]byte
+		for i := range builds {
+			outFile := filepath.Join(t.TempDir(), "test.db")
+			if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+				t.Fatalf("%s: Build: %v", strategy, err)
+			}
+			data, err := os.ReadFile(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			builds[i] = data
+			if i > 0 {
+				continue
+			}
+			db, err := sqlite.Open(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := db.Query(`SELECT id, __ulid__ FROM users`)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			seen := map[string]bool{}
+			for rows.Next() {
+				var id, rowID string
+				rows.Scan(&id, &rowID)
+				if u, err := uuid.Parse(id); err != nil || u.Version() != 7 {
+					t.Errorf("%s: generated uuid %q is not version 7", strategy, id)
+				}
+				if !valid(rowID) || seen[rowID] {
+					t.Errorf("%s: malformed or duplicate id %q", strategy, rowID)
+				}
+				seen[rowID] = true
+			}
+			rows.Close()
+			db.Close()
+		}
+		if !bytes.Equal(builds[0], builds[1]) {
+			t.Errorf("%s: deterministic builds differ", strategy)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.IDStrategy = config.IDSnowflake
+	cfg.Deterministic = true
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: cfg}); err == nil {
+		t.Error("expected error for deterministic snowflake ids")
+	}
+}
+
+// TestBuild_IncrementIDs verifies that omitted [pk, increment] ids are assigned
+// in file order, continue after explicit ids, and that references by path
+// resolve to the assigned ids.
+func TestBuild_IncrementIDs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table users {
+  id integer [pk, increment]
+  name varchar
+}
+Table posts {
+  id integer [pk, increment]
+  author integer [ref: > users.id]
+}
+`), 0644)
+	os.MkdirAll(filepath.Join(dir, "users"), 0755)
+	os.MkdirAll(filepath.Join(dir, "posts"), 0755)
+	os.WriteFile(filepath.Join(dir, "users", "alice.users.yaml"), []byte("name: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "users", "bob.users.yaml"), []byte("id: 10\nname: Bob\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "users", "carol.users.yaml"), []byte("name: Carol\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "posts", "hello.posts.yaml"), []byte("author: \"&users/carol\"\n"), 0644)
+
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default()}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT name, id FROM users ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var name string
+		var id int64
+		rows.Scan(&name, &id)
+		got = append(got, fmt.Sprintf("%s=%d", name, id))
+	}
+	rows.Close()
+	if strings.Join(got, ",") != "Alice=1,Bob=10,Carol=11" {
+		t.Errorf("users = %v, want [Alice=1 Bob=10 Carol=11]", got)
+	}
+
+	var postID, author any
+	if err := db.DB().QueryRow(`SELECT id, author FROM posts`).Scan(&postID, &author); err != nil {
+		t.Fatalf("query posts: %v", err)
+	}
+	if postID != int64(1) || author != int64(11) {
+		t.Errorf("post id, author = %v, %v, want 1, 11", postID, author)
+	}
+
+	var mapped int64
+	if err := db.DB().QueryRow(`SELECT id FROM __sqlfs_increments__ WHERE "table" = 'users' AND pk = 'users/carol'`).Scan(&mapped); err != nil {
+		t.Fatalf("query __sqlfs_increments__: %v", err)
+	}
+	if mapped != 11 {
+		t.Errorf("users/carol mapped to %d, want 11", mapped)
+	}
+}
+
+// TestBuild_IncrementIDsSkipExplicit verifies that assigned ids skip ids a
+// later file gives explicitly.
+func TestBuild_IncrementIDsSkipExplicit(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id integer [pk, increment]\n  name varchar\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.users.yaml"), []byte("name: A\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.users.yaml"), []byte("id: 1\nname: B\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "c.users.yaml"), []byte("name: C\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "d.users.yaml"), []byte("id: 3\nname: D\n"), 0644)
+
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default()}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT name, id FROM users ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name string
+		var id int64
+		rows.Scan(&name, &id)
+		got = append(got, fmt.Sprintf("%s=%d", name, id))
+	}
+	if strings.Join(got, ",") != "A=2,B=1,C=4,D=3" {
+		t.Errorf("users = %v, want [A=2 B=1 C=4 D=3]", got)
+	}
+}
+
+// TestBuild_ReplacesOutput verifies that builds replace an existing output
+// through a temporary file, and that a build failing before the rename
+// leaves the previous output in place.
+func TestBuild_ReplacesOutput(t *testing.T) {
+	dir := setupTestDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	for _, format := range []string{FormatSQLite, FormatSQLite, FormatSQL} {
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), Format: format}); err != nil {
+			t.Fatalf("%s: Build: %v", format, err)
+		}
+		if _, err := os.Stat(outFile + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%s: temporary file left behind: %v", format, err)
+		}
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil || !strings.Contains(string(data), "INSERT INTO") {
+		t.Fatalf("output is not the last build's SQL dump: %v", err)
+	}
+
+	// A directory in the way of the temporary file fails the build.
+	os.MkdirAll(filepath.Join(outFile+".tmp", "sub"), 0755)
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default()}); err == nil {
+		t.Fatal("expected error")
+	}
+	if after, _ := os.ReadFile(outFile); !bytes.Equal(after, data) {
+		t.Error("failed build changed the previous output")
+	}
+}
+
+// TestBuild_SourceLines verifies the source_line standard column of records
+// and of the rows expanded from their nested arrays, in DBML and schema-less
+// mode.
+func TestBuild_SourceLines(t *testing.T) {
+	for _, dbml := range []bool{true, false} {
+		dir := t.TempDir()
+		if dbml {
+			os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table menus {\n  name varchar\n}\nTable menus_courses {\n  menus_pk varchar\n  title varchar\n}\nTable menus_tags {\n  menus_pk varchar\n  value varchar\n}\n"), 0644)
+		}
+		os.WriteFile(filepath.Join(dir, "menu.menus.yaml"), []byte("# a comment\nname: Menu\ncourses:\n  - title: Soup\n\n  - title: Fish\ntags:\n  - a\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "other.menus.json"), []byte(`{"name": "Other"}`), 0644)
+
+		cfg := config.Default()
+		cfg.SourceLines = true
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("dbml %v: Build: %v", dbml, err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]int{}
+		for _, q := range []string{
+			`SELECT name, __source_line__ FROM menus`,
+			`SELECT title, __source_line__ FROM menus_courses`,
+			`SELECT value, __source_line__ FROM menus_tags`,
+		} {
+			rows, err := db.Query(q)
+			if err != nil {
+				t.Fatalf("dbml %v: %s: %v", dbml, q, err)
+			}
+			for rows.Next() {
+				var name string
+				var line int
+				rows.Scan(&name, &line)
+				got[name] = line
+			}
+			rows.Close()
+		}
+		db.Close()
+		want := map[string]int{"Menu": 2, "Other": 1, "Soup": 4, "Fish": 6, "a": 8}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dbml %v: source lines = %v, want %v", dbml, got, want)
+		}
+	}
+}
+
+func TestBuild_MultiDocumentYAML(t *testing.T) {
+	for _, dbml := range []bool{true, false} {
+		dir := t.TempDir()
+		if dbml {
+			os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id varchar\n  name varchar\n  age int\n}\n"), 0644)
+		}
+		os.WriteFile(filepath.Join(dir, "staff.users.yaml"), []byte("id: alice\nname: Alice\nage: 30\n---\nid: bob\nname: Bob\nnickname: Bobby\n---\nname: Carol\n---\n"), 0644)
+
+		cfg := config.Default()
+		cfg.Invalid = config.InvalidSilent
+		cfg.SourceLines = true
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("dbml %v: Build: %v", dbml, err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query(`SELECT __pk__, name, __source_line__ FROM users ORDER BY __pk__`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var pk, name string
+			var line int
+			rows.Scan(&pk, &name, &line)
+			got = append(got, fmt.Sprintf("%s=%s@%d", pk, name, line))
+		}
+		rows.Close()
+		db.Close()
+		// In DBML mode bob's nickname is not in the schema, so his document is
+		// dropped.
+		want := []string{"staff#2=Carol@9", "staff#alice=Alice@1", "staff#bob=Bob@5"}
+		if dbml {
+			want = []string{"staff#2=Carol@9", "staff#alice=Alice@1"}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dbml %v: rows = %v, want %v", dbml, got, want)
+		}
+	}
+}
+
+func TestBuild_ArrayOfRecords(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "export.products.json"), []byte(`[
+  {"sku": "a1", "name": "Apple", "tags": ["fruit"]},
+  {"sku": "b2", "name": "Bread"}
+]`), 0644)
+	os.WriteFile(filepath.Join(dir, "more.products.yaml"), []byte("- name: Cheese\n"), 0644)
+
+	cfg := config.Default()
+	cfg.KeyField = "sku"
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var got []string
+	for _, q := range []string{
+		`SELECT __pk__ || '=' || name FROM products ORDER BY __pk__`,
+		`SELECT products_pk || '=' || value FROM products_tags`,
+	} {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		for rows.Next() {
+			var s string
+			rows.Scan(&s)
+			got = append(got, s)
+		}
+		rows.Close()
+	}
+	want := []string{"export#a1=Apple", "export#b2=Bread", "more#0=Cheese", "export#a1=fruit"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %v, want %v", got, want)
+	}
+}
+
+func TestBuild_SchemaFromURL(t *testing.T) {
+	dir := setupTestDir(t)
+	src, err := os.ReadFile(filepath.Join(dir, "schema.dbml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "schema.dbml"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schema.dbml":
+			w.Write(src)
+		case "/huge.dbml":
+			w.Write(src)
+			w.Write(bytes.Repeat([]byte("\n"), maxSchemaSize))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.Default().WithSchema(srv.URL + "/schema.dbml")
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var typ string
+	if err := db.DB().QueryRow(`SELECT typeof(id) FROM users LIMIT 1`).Scan(&typ); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if typ != "integer" {
+		t.Errorf("id stored as %s, want integer as the schema declares", typ)
+	}
+
+	// A missing remote schema fails the build rather than going schema-less.
+	cfg = config.Default().WithSchema(srv.URL + "/missing.dbml")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Build with missing schema URL: err = %v", err)
+	}
+	cfg = config.Default().WithSchema(srv.URL + "/huge.dbml")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Build with oversized schema: err = %v", err)
+	}
+}
+
+func TestReadSchema_Stdin(t *testing.T) {
+	defer func(r io.Reader) { schemaStdin = r }(schemaStdin)
+	stdinSchemaOnce = sync.Once{}
+	schemaStdin = strings.NewReader("Table users {\n  id integer\n}\n")
+
+	cfg := config.Default().WithSchema(config.SchemaStdin)
+	for i := 0; i < 2; i++ {
+		src, err := ReadSchema(context.Background(), t.TempDir(), cfg)
+		if err != nil {
+			t.Fatalf("ReadSchema: %v", err)
+		}
+		if !strings.HasPrefix(string(src), "Table users") {
+			t.Errorf("read %d: schema = %q", i, src)
+		}
+	}
+	if got := SchemaLocation("root", cfg); got != "standard input" {
+		t.Errorf("SchemaLocation = %q", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := setupTestDir(t)
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default()})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if result.TableRows["users"] != 2 {
+		t.Errorf("TableRows = %v, want 2 users", result.TableRows)
+	}
+	if err := Verify(outFile, result); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(`DELETE FROM users`)
+	db.Close()
+	if err := Verify(outFile, result); err == nil || !strings.Contains(err.Error(), "users is empty") {
+		t.Errorf("Verify of emptied table: err = %v", err)
+	}
+
+	db, err = sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(`DROP TABLE users`)
+	db.Close()
+	if err := Verify(outFile, result); err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("Verify of dropped table: err = %v", err)
+	}
+
+	os.WriteFile(outFile, bytes.Repeat([]byte("x"), 4096), 0644)
+	if err := Verify(outFile, result); err == nil {
+		t.Error("Verify of corrupt file: expected error")
+	}
+}
+
+// TestBuild_Jobs verifies that loading files concurrently assigns the same
+// increment ids and reports the same first error as loading them in turn.
+func TestBuild_Jobs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id integer [pk, increment]\n  name varchar\n}\n"), 0644)
+	for i := 0; i < 200; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("u%03d.users.yaml", i)), []byte(fmt.Sprintf("name: user%d\n", i)), 0644)
+	}
+
+	for _, jobs := range []int{1, 8} {
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), Jobs: jobs})
+		if err != nil {
+			t.Fatalf("jobs %d: Build: %v", jobs, err)
+		}
+		if result.RecordsTotal != 200 {
+			t.Errorf("jobs %d: RecordsTotal = %d, want 200", jobs, result.RecordsTotal)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var misordered int
+		db.DB().QueryRow(`SELECT count(*) FROM users WHERE name != 'user' || (id - 1)`).Scan(&misordered)
+		db.Close()
+		if misordered != 0 {
+			t.Errorf("jobs %d: %d ids out of file order", jobs, misordered)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "u050.users.yaml"), []byte("name: [\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "u150.users.yaml"), []byte("name: [\n"), 0644)
+	for _, jobs := range []int{1, 8} {
+		_, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "fail.db"), Config: config.Default(), Jobs: jobs})
+		if err == nil || !strings.Contains(err.Error(), "u050.users.yaml") {
+			t.Errorf("jobs %d: err = %v, want the error of u050.users.yaml", jobs, err)
+		}
+	}
+}
+
+// TestForEachLoaded verifies that files are used in order, that a file
+// failing to load far ahead is found without using the files before it,
+// and that the error returned does not depend on timing.
+func TestForEachLoaded(t *testing.T) {
+	const n = 1000
+	fails := func(bad ...int) func(i int) error {
+		return func(i int) error {
+			for _, b := range bad {
+				if i == b {
+					return fmt.Errorf("file %d", i)
+				}
+			}
+			return nil
+		}
+	}
+
+	var used []int
+	err := forEachLoaded(context.Background(), 4, n, fails(), fails(), func(i int) error {
+		used = append(used, i)
+		return nil
+	})
+	if err != nil || len(used) != n || used[0] != 0 || used[n-1] != n-1 || !sort.IntsAreSorted(used) {
+		t.Errorf("used %d files, err = %v", len(used), err)
+	}
+
+	used = used[:0]
+	err = forEachLoaded(context.Background(), 4, n, fails(950, 900), fails(950, 900), func(i int) error {
+		time.Sleep(time.Millisecond)
+		used = append(used, i)
+		return nil
+	})
+	if err == nil || err.Error() != "file 900" || len(used) >= 900 {
+		t.Errorf("err = %v after using %d files, want file 900 found early", err, len(used))
+	}
+
+	// A file failing to load wins over a use failing before it.
+	err = forEachLoaded(context.Background(), 4, n, fails(500), fails(500), func(i int) error {
+		if i == 10 {
+			return fmt.Errorf("use %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "file 500" {
+		t.Errorf("err = %v, want file 500", err)
+	}
+	err = forEachLoaded(context.Background(), 1, n, fails(), nil, func(i int) error {
+		if i >= 10 {
+			return fmt.Errorf("use %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "use 10" {
+		t.Errorf("err = %v, want use 10", err)
+	}
+}
+
+// TestCheckedFiles verifies that checked files are kept while there is room
+// and handed to load once, and that a file load asked for before its check
+// finished is not kept.
+func TestCheckedFiles(t *testing.T) {
+	c := newCheckedFiles[string](1)
+	for i := 0; i < loadAhead; i++ {
+		if !c.reserve(i) {
+			t.Fatalf("reserve(%d) = false with room left", i)
+		}
+	}
+	if c.reserve(loadAhead) {
+		t.Error("reserve past the limit = true")
+	}
+	c.put(0, "zero")
+	if f, ok := c.take(0); !ok || f != "zero" {
+		t.Errorf("take(0) = %q, %v", f, ok)
+	}
+	if _, ok := c.take(0); ok {
+		t.Error("take(0) twice = true")
+	}
+	if _, ok := c.take(1); ok {
+		t.Error("take(1) before put = true")
+	}
+	c.put(1, "one")
+	c.release(2)
+	if len(c.files) != 0 || len(c.checking) != loadAhead-3 {
+		t.Errorf("holding %d files and %d checks, want 0 and %d", len(c.files), len(c.checking), loadAhead-3)
+	}
+	if !c.reserve(loadAhead) {
+		t.Error("reserve after room was made = false")
+	}
+}
+
+// TestBuild_InterpolateEnv verifies that allowed environment variables are
+// substituted into data file values.
+func TestBuild_InterpolateEnv(t *testing.T) {
+	t.Setenv("SQLFS_TEST_NAME", "Alice")
+	dir := setupTestDir(t)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\nname: ${SQLFS_TEST_NAME}\n"), 0644)
+
+	cfg := config.Default()
+	cfg.InterpolateEnv = []string{"SQLFS_TEST_NAME"}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var name string
+	if err := db.DB().QueryRow(`SELECT name FROM users WHERE id = 1`).Scan(&name); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if name != "Alice" {
+		t.Errorf("name = %q, want Alice", name)
+	}
+}
+
+// TestBuild_JSONColumnSchema verifies that nested values of a json column are
+// validated against the schema in the column's note.
+func TestBuild_JSONColumnSchema(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table users {
+  id integer [pk]
+  settings json [note: '{"type": "object", "properties": {"theme": {"type": "string"}}}']
+}
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\nsettings:\n  theme: 3\n"), 0644)
+
+	_, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: config.Default()})
+	if err == nil || !strings.Contains(err.Error(), "/theme") {
+		t.Errorf("err = %v, want a /theme validation error", err)
+	}
+}
+
+// TestBuild_JSONColumnsQueryable verifies that with json_text, json column
+// values, scalars included, are stored as valid JSON that json_extract can
+// query, and that without it scalars are stored as they are.
+func TestBuild_JSONColumnsQueryable(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id integer [pk]\n  settings json\n  label json\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\nsettings:\n  theme: dark\nlabel: admin\n"), 0644)
+
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default()}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var label string
+	if err := db.DB().QueryRow(`SELECT label FROM users`).Scan(&label); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	db.Close()
+	if label != "admin" {
+		t.Errorf("label = %q without json_text, want admin", label)
+	}
+
+	cfg := config.Default()
+	cfg.JSONText = true
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err = sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var theme string
+	if err := db.DB().QueryRow(`SELECT json_extract(settings, '$.theme'), json_extract(label, '$') FROM users`).Scan(&theme, &label); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if theme != "dark" || label != "admin" {
+		t.Errorf("theme, label = %q, %q, want dark, admin", theme, label)
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM posts": "",
+		"SELECT * FROM posts WHERE created > :since AND a = :since":    ":since",
+		"SELECT * FROM t WHERE a = ? AND b = @b AND c = $c":            "?,@b,$c",
+		"SELECT ':x', \"@y\" FROM t -- :z\nWHERE t = '12:30' /* $w */": "",
+	}
+	for query, want := range tests {
+		if got := strings.Join(queryParameters(query), ","); got != want {
+			t.Errorf("queryParameters(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+// TestBuild_NamedQueries verifies that parameter-free named queries become
+// views and that every named query is listed in QueriesTable.
+func TestBuild_NamedQueries(t *testing.T) {
+	dir := setupTestDir(t)
+	cfg := config.Default()
+	cfg.Queries = map[string]string{
+		"user_names": "SELECT name FROM users ORDER BY id",
+		"user_by_id": "SELECT * FROM users WHERE id = :id",
+	}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var name string
+	if err := db.DB().QueryRow(`SELECT name FROM user_names LIMIT 1`).Scan(&name); err != nil {
+		t.Fatalf("query view: %v", err)
+	}
+	if name != "Alice Smith" {
+		t.Errorf("name = %q, want Alice Smith", name)
+	}
+
+	var params string
+	if err := db.DB().QueryRow(`SELECT parameters FROM ` + QueriesTable + ` WHERE name = 'user_by_id'`).Scan(&params); err != nil {
+		t.Fatalf("query %s: %v", QueriesTable, err)
+	}
+	if params != `[":id"]` {
+		t.Errorf("parameters = %s, want [\":id\"]", params)
+	}
+	var views int
+	db.DB().QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'view'`).Scan(&views)
+	if views != 1 {
+		t.Errorf("got %d views, want 1", views)
+	}
+}
+
+func TestBuild_SchemaTables(t *testing.T) {
+	dir := t.TempDir()
+	schema := "Enum status {\n  active\n  retired [note: 'No longer used']\n}\n" +
+		"Table users [note: 'People'] {\n  id integer [pk]\n  name varchar(80) [not null, note: 'Display name']\n" +
+		"  email varchar [note: '''\n    summary:\n      en: Contact address\n      fr: Adresse de contact\n    owner: growth\n    pii: true\n    tags: [contact]\n  ''']\n" +
+		"  status status\n}\n" +
+		"Table posts {\n  id integer [pk]\n  author integer [ref: > users.id]\n}\n" +
+		"Ref post_users: posts.id - users.id [delete: cascade]\n"
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(schema), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\nname: Alice\nemail: alice@example.com\n"), 0644)
+
+	cfg := config.Default().WithLocale("fr")
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.DB().QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = ?`, TablesTable).Scan(&n); err != nil || n != 0 {
+		t.Errorf("%s exists without schema_tables (err %v)", TablesTable, err)
+	}
+	db.Close()
+
+	cfg.SchemaTables = true
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err = sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var typ, summary, summaries, owner, tags string
+	var pii bool
+	err = db.DB().QueryRow(`SELECT type, summary, summaries, owner, pii, tags FROM `+ColumnsTable+
+		` WHERE table_name = 'users' AND column_name = 'email'`).Scan(&typ, &summary, &summaries, &owner, &pii, &tags)
+	if err != nil {
+		t.Fatalf("query %s: %v", ColumnsTable, err)
+	}
+	if typ != "varchar" || summary != "Adresse de contact" || owner != "growth" || !pii || tags != `["contact"]` {
+		t.Errorf("email = %q %q %q %v %q", typ, summary, owner, pii, tags)
+	}
+	if summaries != `{"en":"Contact address","fr":"Adresse de contact"}` {
+		t.Errorf("summaries = %s", summaries)
+	}
+
+	for query, want := range map[string]string{
+		`SELECT type || ' ' || position || ' ' || not_null || ' ' || summary FROM ` + ColumnsTable + ` WHERE column_name = 'name'`: "varchar(80) 2 1 Display name",
+		`SELECT count(*) FROM ` + ColumnsTable:                                                                                                                     "6",
+		`SELECT group_concat(name || ':' || ifnull(summary, ''), ',') FROM ` + TablesTable:                                                                         "users:People,posts:",
+		`SELECT group_concat(value || ':' || ifnull(note, ''), ',') FROM ` + EnumsTable + ` WHERE enum_name = 'status'`:                                            "active:,retired:No longer used",
+		`SELECT group_concat(from_table || '.' || from_column || relation || to_table || '.' || to_column || ':' || ifnull(on_delete, ''), ',') FROM ` + RefsTable: "posts.author>users.id:,posts.id-users.id:cascade",
+	} {
+		var got string
+		if err := db.DB().QueryRow(query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestBuild_TablesDir(t *testing.T) {
+	dir := setupTestDir(t)
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id integer [pk]\n  name varchar\n  email varchar\n}\n"+
+		"Table countries {\n  code varchar [pk]\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "fr.countries.yaml"), []byte("code: FR\n"), 0644)
+	cfg := config.Default()
+	cfg.Queries = map[string]string{
+		"user_names":    "SELECT name FROM users",
+		"country_codes": "SELECT code FROM countries",
+	}
+	tablesDir := filepath.Join(t.TempDir(), "tables")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "all.db"), Config: cfg, TablesDir: tablesDir}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	for table, want := range map[string]string{
+		"users":     "__sqlfs_build__,user_names,users",
+		"countries": "__sqlfs_build__,countries,country_codes",
+	} {
+		db, err := sqlite.Open(filepath.Join(tablesDir, table+".db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var objects string
+		if err := db.DB().QueryRow(`SELECT group_concat(name, ',') FROM (SELECT name FROM sqlite_master WHERE type IN ('table', 'view') ORDER BY name)`).Scan(&objects); err != nil {
+			t.Fatal(err)
+		}
+		if objects != want {
+			t.Errorf("%s.db holds %s, want %s", table, objects, want)
+		}
+		db.Close()
+	}
+
+	// Outputs of dropped tables and of the previous format are removed;
+	// other files are left alone.
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  id integer [pk]\n  name varchar\n  email varchar\n}\n"), 0644)
+	os.Remove(filepath.Join(dir, "fr.countries.yaml"))
+	os.WriteFile(filepath.Join(tablesDir, "README"), []byte("tables\n"), 0644)
+	cfg.Queries = nil
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "all.sql"), Config: cfg, TablesDir: tablesDir, Format: FormatSQL}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var names []string
+	entries, _ := os.ReadDir(tablesDir)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got := strings.Join(names, ","); got != ".sqlfs-tables,README,users.sql" {
+		t.Errorf("tables dir holds %s, want .sqlfs-tables,README,users.sql", got)
+	}
+}
+
+func TestBuild_TablesDirHoldsOutput(t *testing.T) {
+	dir := setupTestDir(t)
+	outDir := t.TempDir()
+	outFile := filepath.Join(outDir, "all.db")
+	os.WriteFile(filepath.Join(outDir, "other.db"), []byte("not sqlfs's\n"), 0644)
+	for i := 0; i < 2; i++ {
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), TablesDir: outDir}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+	}
+	var names []string
+	entries, _ := os.ReadDir(outDir)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got, want := strings.Join(names, ","), ".sqlfs-tables,all.db,other.db,users.db"; got != want {
+		t.Errorf("output dir holds %s, want %s", got, want)
+	}
+}
+
+func TestBuild_GitModifiedAt(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := setupTestDir(t)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}, {"commit", "-q", "-m", "data"}} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE=2020-01-02T03:04:05Z", "GIT_COMMITTER_DATE=2020-01-02T03:04:05Z")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	// An uncommitted edit keeps the file system time.
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("id: 2\nname: Robert Jones\n"), 0644)
+
+	cfg := config.Default()
+	cfg.ModifiedAt = config.ModTimeGit
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var alice, bob string
+	db.DB().QueryRow(`SELECT __modified_at__ FROM users WHERE id = 1`).Scan(&alice)
+	db.DB().QueryRow(`SELECT __modified_at__ FROM users WHERE id = 2`).Scan(&bob)
+	if alice != "2020-01-02T03:04:05Z" {
+		t.Errorf("alice modified at %s, want the commit time", alice)
+	}
+	if bob == "2020-01-02T03:04:05Z" || bob == "" {
+		t.Errorf("bob modified at %q, want the file's modification time", bob)
+	}
+}
+
+func TestIsProjectFile(t *testing.T) {
+	cfg := config.Default()
+	for _, name := range []string{"sqlfs.yaml", "schema.dbml"} {
+		if !isProjectFile(name, cfg) {
+			t.Errorf("isProjectFile(%q) = false, want true", name)
+		}
+	}
+	if isProjectFile("users.users.yaml", cfg) {
+		t.Error("isProjectFile(users.users.yaml) = true, want false")
+	}
+	// Case-insensitive file systems treat SQLFS.yaml as the config file.
+	wantFold := runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	if got := isProjectFile("SQLFS.yaml", cfg); got != wantFold {
+		t.Errorf("isProjectFile(SQLFS.yaml) = %v, want %v on %s", got, wantFold, runtime.GOOS)
+	}
+}
+
+func TestBuild_DatasetHash(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("name: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("name: Bob\n"), 0644)
+
+	build := func() string {
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default()})
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var stored string
+		if err := db.DB().QueryRow(`SELECT value FROM __sqlfs_build__ WHERE key = 'dataset_hash'`).Scan(&stored); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		if stored != result.DatasetHash {
+			t.Errorf("stored hash %q != Result.DatasetHash %q", stored, result.DatasetHash)
+		}
+		return stored
+	}
+
+	first := build()
+	if len(first) != 64 {
+		t.Errorf("hash = %q, want 64 hex digits", first)
+	}
+	if again := build(); again != first {
+		t.Errorf("rebuild of the same files: hash %q, want %q", again, first)
+	}
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("name: Robert\n"), 0644)
+	if changed := build(); changed == first {
+		t.Error("hash did not change when a file changed")
+	}
+}
+
+func TestBuild_MetricsHistory(t *testing.T) {
+	dir := setupTestDir(t)
+	history := filepath.Join(t.TempDir(), "metrics.jsonl")
+	cache := NewCache()
+	defer cache.Close()
+	for i := 0; i < 2; i++ {
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), Cache: cache, MetricsHistory: history}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("history has %d lines, want 2:\n%s", len(lines), data)
+	}
+	var full, cached Metrics
+	if err := json.Unmarshal([]byte(lines[0]), &full); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &cached); err != nil {
+		t.Fatal(err)
+	}
+	if full.Root != dir || full.Time.IsZero() || full.Files != 2 || full.FilesCached != 0 || full.CacheHitRate != 0 ||
+		full.Records != 2 || !reflect.DeepEqual(full.TableRows, map[string]int{"users": 2}) || full.Warnings != 0 || full.DatasetHash == "" {
+		t.Errorf("first build: %+v", full)
+	}
+	if cached.Files != 2 || cached.FilesCached != 2 || cached.CacheHitRate != 1 || cached.Records != 2 || cached.DatasetHash != full.DatasetHash {
+		t.Errorf("second build: %+v", cached)
+	}
+
+	// A history that cannot be written is logged, and the build succeeds.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	unwritable := filepath.Join(t.TempDir(), "missing", "history.jsonl")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: config.Default(), MetricsHistory: unwritable}); err != nil {
+		t.Fatalf("Build with an unwritable history: %v", err)
+	}
+	if !strings.Contains(logged.String(), "warning: writing metrics history") {
+		t.Errorf("log = %q, want a warning", logged.String())
+	}
+}
+
+func TestBuild_WarningsLog(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Enum role {\n  admin\n  user\n}\nTable users {\n  id integer [pk]\n  role role\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\nrole: admin\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bob.users.yaml"), []byte("id: 2\nrole: guest\n"), 0644)
+	log := filepath.Join(t.TempDir(), "warnings.jsonl")
+
+	cfg := config.Default().WithInvalid("warn")
+	for i := 0; i < 2; i++ {
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: cfg, WarningsLog: log}); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("log has %d lines, want 2:\n%s", len(lines), data)
+	}
+	var first, second WarningLine
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Root != dir || first.Time.IsZero() || first.File != "bob.users.yaml" || first.Record != "bob" || first.Field != "role" || first.Message == "" {
+		t.Errorf("first build: %+v", first)
+	}
+	if second.Root != dir || second.Time.IsZero() || second.Record != "bob" || second.Failed {
+		t.Errorf("second build: %+v", second)
+	}
+
+	// A build failed by invalid data logs the error that failed it.
+	failLog := filepath.Join(t.TempDir(), "warnings.jsonl")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: config.Default().WithInvalid("fail"), WarningsLog: failLog}); err == nil {
+		t.Fatal("Build succeeded with invalid: fail")
+	}
+	data, err = os.ReadFile(failLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed WarningLine
+	if err := json.Unmarshal(data, &failed); err != nil {
+		t.Fatalf("log %q: %v", data, err)
+	}
+	if failed.Record != "bob" || failed.Field != "role" || !failed.Failed {
+		t.Errorf("failed build: %+v", failed)
+	}
+
+	// A log that cannot be written does not fail the build.
+	unwritable := filepath.Join(t.TempDir(), "missing", "warnings.jsonl")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: cfg, WarningsLog: unwritable}); err != nil {
+		t.Errorf("Build with an unwritable log: %v", err)
+	}
+}
+
+func TestBuild_Incremental(t *testing.T) {
+	dir := setupTestDir(t)
+	cache := NewCache()
+	defer cache.Close()
+
+	build := func() (*Result, map[string][2]string) {
+		t.Helper()
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), Cache: cache})
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query(`SELECT __pk__, name, __ulid__ FROM users`)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		users := make(map[string][2]string)
+		for rows.Next() {
+			var pk, name, id string
+			if err := rows.Scan(&pk, &name, &id); err != nil {
+				t.Fatal(err)
+			}
+			users[pk] = [2]string{name, id}
+		}
+		return result, users
+	}
+	touch := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, first := build()
+
+	touch("bob.users.yaml", "id: 2\nname: Robert Jones\n")
+	touch("carol.users.yaml", "id: 3\nname: Carol White\n")
+	result, second := build()
+	if result.RecordsTotal != 3 || len(second) != 3 {
+		t.Fatalf("RecordsTotal = %d, rows = %v; want 3", result.RecordsTotal, second)
+	}
+	if second["alice"] != first["alice"] {
+		t.Errorf("unchanged alice was rebuilt: %v, want %v", second["alice"], first["alice"])
+	}
+	if second["bob"][0] != "Robert Jones" || second["bob"][1] == first["bob"][1] {
+		t.Errorf("changed bob was not reloaded: %v", second["bob"])
+	}
+	full, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "full.db"), Config: config.Default()})
+	if err != nil {
+		t.Fatalf("full Build: %v", err)
+	}
+	if result.DatasetHash != full.DatasetHash {
+		t.Errorf("DatasetHash = %s, full build has %s", result.DatasetHash, full.DatasetHash)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "carol.users.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	_, third := build()
+	if _, ok := third["carol"]; ok || len(third) != 2 {
+		t.Errorf("removed carol still present: %v", third)
+	}
+
+	// A schema change makes the next build a full one.
+	touch("schema.dbml", "Table users {\n  id integer [pk]\n  name varchar\n  email varchar\n}\n")
+	_, fourth := build()
+	if fourth["alice"][1] == first["alice"][1] {
+		t.Error("alice kept its ULID across a schema change; want a full rebuild")
+	}
+}
+
+func TestBuild_SavedCache(t *testing.T) {
+	dir := setupTestDir(t)
+	ulids := func(outFile string) map[string]string {
+		t.Helper()
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query(`SELECT __pk__, name || ' ' || __ulid__ FROM users`)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		got := make(map[string]string)
+		for rows.Next() {
+			var pk, v string
+			if err := rows.Scan(&pk, &v); err != nil {
+				t.Fatal(err)
+			}
+			got[pk] = v
+		}
+		return got
+	}
+
+	cache := NewCache()
+	firstOut := filepath.Join(t.TempDir(), "first.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: firstOut, Config: config.Default(), Cache: cache}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var saved bytes.Buffer
+	if err := cache.Save(&saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	cache.Close()
+	first := ulids(firstOut)
+
+	// A fresh checkout: every file gets a new mod time, one file changes.
+	later := time.Now().Add(time.Hour)
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		os.Chtimes(filepath.Join(dir, e.Name()), later, later)
+	}
+	bob := filepath.Join(dir, "bob.users.yaml")
+	os.WriteFile(bob, []byte("id: 2\nname: Robert Jones\n"), 0644)
+	os.Chtimes(bob, later, later)
+
+	restored, err := ReadCache(&saved, dir)
+	if err != nil {
+		t.Fatalf("ReadCache: %v", err)
+	}
+	defer restored.Close()
+	secondOut := filepath.Join(t.TempDir(), "second.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: secondOut, Config: config.Default(), Cache: restored}); err != nil {
+		t.Fatalf("Build with restored cache: %v", err)
+	}
+	second := ulids(secondOut)
+	if len(second) != len(first) {
+		t.Fatalf("rows = %v, want %d", second, len(first))
+	}
+	if second["alice"] != first["alice"] {
+		t.Errorf("alice = %q, want %q kept from the cache", second["alice"], first["alice"])
+	}
+	if !strings.HasPrefix(second["bob"], "Robert Jones ") {
+		t.Errorf("bob = %q, want the changed file reloaded", second["bob"])
+	}
+
+	if _, err := ReadCache(strings.NewReader("garbage"), dir); err == nil {
+		t.Error("expected error reading a corrupt cache")
+	}
+
+	// Interpolated variables may be secrets, and saved caches leave the
+	// machine, so only digests of their values are kept.
+	t.Setenv("SQLFS_TEST_SECRET", "hunter2")
+	cfg := config.Default()
+	cfg.InterpolateEnv = []string{"SQLFS_TEST_SECRET"}
+	secret := NewCache()
+	defer secret.Close()
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "secret.db"), Config: cfg, Cache: secret}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if got := secret.env["SQLFS_TEST_SECRET"]; got != envDigest("hunter2") {
+		t.Errorf("cached env = %q, want the value's digest", got)
+	}
+}
+
+func TestBuild_ExpiringRows(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table promos {\n  ends_at timestamp [expires]\n}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "old.promos.yaml"), []byte("ends_at: 2000-01-01T00:00:00Z\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "new.promos.yaml"), []byte("ends_at: 2099-01-01T00:00:00Z\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "forever.promos.yaml"), []byte("{}\n"), 0644)
+
+	cache := NewCache()
+	defer cache.Close()
+	build := func() (*Result, map[string]string) {
+		t.Helper()
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: config.Default(), Cache: cache})
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query(`SELECT __pk__, __ulid__ FROM promos`)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		got := make(map[string]string)
+		for rows.Next() {
+			var pk, id string
+			if err := rows.Scan(&pk, &id); err != nil {
+				t.Fatal(err)
+			}
+			got[pk] = id
+		}
+		return result, got
+	}
+
+	result, first := build()
+	if _, ok := first["old"]; ok || len(first) != 2 {
+		t.Errorf("rows = %v, want new and forever only", first)
+	}
+	if want := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC); !result.NextExpiry.Equal(want) {
+		t.Errorf("NextExpiry = %v, want %v", result.NextExpiry, want)
+	}
+
+	// A cached row whose expiry has passed is not kept by a patch build.
+	cache.files["new.promos.yaml"].expires = time.Now().Add(-time.Second)
+	_, second := build()
+	if second["new"] == first["new"] {
+		t.Error("expired cached row was kept; want its file reloaded")
+	}
+	if second["forever"] != first["forever"] {
+		t.Error("unexpired cached row was reloaded")
+	}
+}
+
+func TestBuild_RedactSensitiveColumns(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table services {
+  name varchar
+  token varchar [note: 'sensitive: API token']
+  settings json [note: 'sensitive']
+  keys json [note: '{"type": "object", "x-sensitive": true}']
+}
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "billing.services.yaml"), []byte("name: Billing\ntoken: s3cret\nsettings:\n  key: abc\nkeys:\n  api: xyz\n"), 0644)
+
+	t.Setenv("SQLFS_REDACT_KEY", "")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "test.db"), Config: config.Default()}); err == nil || !strings.Contains(err.Error(), "SQLFS_REDACT_KEY") {
+		t.Errorf("without a key: err = %v, want an error naming SQLFS_REDACT_KEY", err)
+	}
+	t.Setenv("SQLFS_REDACT_KEY", "pepper")
+	key := []byte("pepper")
+
+	for _, mode := range []config.RedactMode{config.RedactHash, config.RedactDrop} {
+		cfg := config.Default()
+		cfg.Redact = mode
+		cfg.JSONText = true
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("%s: Build: %v", mode, err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var name string
+		var token, settings, keys *string
+		if err := db.DB().QueryRow(`SELECT name, token, settings, keys FROM services`).Scan(&name, &token, &settings, &keys); err != nil {
+			t.Fatalf("%s: query: %v", mode, err)
+		}
+		db.Close()
+
+		if name != "Billing" {
+			t.Errorf("%s: name = %q, want it unredacted", mode, name)
+		}
+		switch mode {
+		case config.RedactHash:
+			if token == nil || *token != redactedHash(key, "s3cret") {
+				t.Errorf("hash: token = %v, want %s", token, redactedHash(key, "s3cret"))
+			}
+			if settings == nil || !strings.HasPrefix(*settings, `"hmac-sha256:`) {
+				t.Errorf("hash: settings = %v, want a JSON string hash", settings)
+			}
+			if keys == nil || !strings.HasPrefix(*keys, `"hmac-sha256:`) {
+				t.Errorf("hash: keys = %v, want a JSON string hash", keys)
+			}
+		case config.RedactDrop:
+			if token != nil || settings != nil || keys != nil {
+				t.Errorf("drop: token, settings, keys = %v, %v, %v, want NULL", token, settings, keys)
+			}
+		}
+	}
+}
+
+func TestBuild_ExcludeTables(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table users {
+  id integer [pk]
+  name varchar
+}
+Table audit_log [exclude] {
+  id integer [pk]
+  user_id integer [ref: > users.id]
+}
+Table drafts {
+  title varchar
+}
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\nname: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "first.audit_log.yaml"), []byte("id: 1\nuser_id: 1\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "wip.drafts.yaml"), []byte("title: WIP\n"), 0644)
+
+	cfg := config.Default()
+	cfg.ExcludeTables = []string{"drafts"}
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if result.RecordsTotal != 1 {
+		t.Errorf("RecordsTotal = %d, want 1", result.RecordsTotal)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, table := range []string{"audit_log", "drafts"} {
+		var n int
+		db.DB().QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = ?`, table).Scan(&n)
+		if n != 0 {
+			t.Errorf("table %s was created", table)
+		}
+	}
+}
+
+func TestBuild_ForeignKeys(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table users {
+  id integer [pk]
+}
+Table posts {
+  id integer [pk]
+  user_id integer [ref: > users.id]
+}
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("id: 1\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "a-hello.posts.yaml"), []byte("id: 1\nuser_id: 1\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b-orphan.posts.yaml"), []byte("id: 2\nuser_id: 9\n"), 0644)
+
+	cfg := config.Default()
+	cfg.ForeignKeys = true
+	_, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "fail.db"), Config: cfg})
+	if err == nil || !strings.Contains(err.Error(), "user_id") {
+		t.Fatalf("expected foreign key error for user_id, got %v", err)
+	}
+
+	cfg.Invalid = config.InvalidWarn
+	result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "warn.db"), Config: cfg})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].RecordKey != "b-orphan" {
+		t.Errorf("Warnings = %v, want one for b-orphan", result.Warnings)
+	} else if result.Warnings[0].FilePath != "b-orphan.posts.yaml" {
+		t.Errorf("FilePath = %q, want the orphan's file", result.Warnings[0].FilePath)
+	}
+}
+
+func TestBuild_ForeignKeysEntityRefs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table users {
+  id varchar [pk]
+}
+Table posts {
+  author varchar [ref: > users.id]
+}
+`), 0644)
+	os.MkdirAll(filepath.Join(dir, "users"), 0755)
+	os.MkdirAll(filepath.Join(dir, "posts"), 0755)
+	os.WriteFile(filepath.Join(dir, "users", "alice.users.yaml"), []byte("id: alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "posts", "hello.posts.yaml"), []byte("author: \"&users/alice\"\n"), 0644)
+
+	cfg := config.Default()
+	cfg.ForeignKeys = true
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "out.db"), Config: cfg}); err != nil {
+		t.Fatalf("Build with an entity reference: %v", err)
+	}
+}
+
+func TestBuild_AtomicDirs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Enum size {\n  small\n  large\n}\nTable products {\n  size size\n}\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "catalog", "shoes"), 0755)
+	os.MkdirAll(filepath.Join(dir, "drafts"), 0755)
+	os.WriteFile(filepath.Join(dir, "catalog", "shoes", "boot.products.yaml"), []byte("size: large\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "drafts", "hat.products.yaml"), []byte("size: huge\n"), 0644)
+
+	for _, invalid := range []string{"warn", "silent"} {
+		cfg := config.Default().WithInvalid(invalid)
+		cfg.AtomicDirs = []string{"catalog/*"}
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "ok.db"), Config: cfg}); err != nil {
+			t.Fatalf("%s: Build with an invalid file outside atomic directories: %v", invalid, err)
+		}
+	}
+
+	// An invalid file leaves its whole directory out.
+	os.Remove(filepath.Join(dir, "drafts", "hat.products.yaml"))
+	os.WriteFile(filepath.Join(dir, "catalog", "shoes", "sandal.products.yaml"), []byte("size: tiny\n"), 0644)
+	for _, invalid := range []string{"warn", "silent"} {
+		cfg := config.Default().WithInvalid(invalid)
+		cfg.AtomicDirs = []string{"catalog/*"}
+		result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "skip.db"), Config: cfg})
+		if err != nil {
+			t.Fatalf("%s: Build: %v", invalid, err)
+		}
+		if result.RecordsTotal != 0 {
+			t.Errorf("%s: records = %d, want the atomic directory left out", invalid, result.RecordsTotal)
+		}
+		var skipped bool
+		for _, w := range result.Warnings {
+			skipped = skipped || strings.Contains(w.Message, `atomic directory "catalog/shoes" is left out`)
+		}
+		if skipped != (invalid == "warn") {
+			t.Errorf("%s: warnings = %v", invalid, result.Warnings)
+		}
+	}
+
+	// Patching a cached build leaves out the rows the directory's
+	// unchanged files kept, until the invalid file is fixed.
+	cfg := config.Default().WithInvalid("warn")
+	cfg.AtomicDirs = []string{"catalog/*"}
+	sandal := filepath.Join(dir, "catalog", "shoes", "sandal.products.yaml")
+	cache := NewCache()
+	defer cache.Close()
+	for _, step := range []struct {
+		sandal  string
+		records int
+	}{
+		{"size: small\n", 2},
+		{"size: tiny\n", 0},
+		{"size: large\n", 2},
+	} {
+		os.WriteFile(sandal, []byte(step.sandal), 0644)
+		later := time.Now().Add(time.Duration(step.records+1) * time.Hour)
+		os.Chtimes(sandal, later, later)
+		result, err := Build(context.Background(), Options{RootDir: dir, OutputFile: filepath.Join(t.TempDir(), "patch.db"), Config: cfg, Cache: cache})
+		if err != nil {
+			t.Fatalf("sandal %q: Build: %v", step.sandal, err)
+		}
+		if result.RecordsTotal != step.records {
+			t.Errorf("sandal %q: records = %d, want %d", step.sandal, result.RecordsTotal, step.records)
+		}
+	}
+}
+
+func TestBuild_IgnoredFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "drafts"), 0755)
+	os.WriteFile(filepath.Join(dir, "alice.users.yaml"), []byte("name: Alice\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".bob.users.yaml"), []byte("name: Bob\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".#alice.users.yaml"), []byte("name: [\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "drafts", "carol.users.yaml"), []byte("name: Carol\n"), 0644)
+
+	for _, schema := range []bool{true, false} {
+		os.Remove(filepath.Join(dir, "schema.dbml"))
+		if schema {
+			os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte("Table users {\n  name varchar\n}\n"), 0644)
+		}
+		cfg, err := config.Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Ignore = append(cfg.Ignore, "drafts/**")
+		outFile := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+			t.Fatalf("schema %v: Build: %v", schema, err)
+		}
+		db, err := sqlite.Open(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names string
+		if err := db.DB().QueryRow(`SELECT group_concat(name) FROM users`).Scan(&names); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+		if names != "Alice" {
+			t.Errorf("schema %v: users = %s, want Alice", schema, names)
+		}
+	}
+}
+
+func TestBuild_MarkdownBody(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table posts {
+  title varchar [not null]
+  content text
+}
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "hello.posts.md"), []byte("---\ntitle: Hello\n---\nFirst post.\n"), 0644)
+
+	cfg := config.Default()
+	cfg.MarkdownBody = "content"
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var title, content string
+	if err := db.DB().QueryRow(`SELECT title, content FROM posts`).Scan(&title, &content); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Hello" || content != "First post.\n" {
+		t.Errorf("title, content = %q, %q", title, content)
+	}
+}
+
+func TestBuild_MarkdownAssets(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(`
+Table posts {
+  title varchar
+  body text
+}
+`), 0644)
+	os.MkdirAll(filepath.Join(dir, "posts", "img"), 0755)
+	os.WriteFile(filepath.Join(dir, "posts", "img", "cat.png"), []byte("png"), 0644)
+	os.WriteFile(filepath.Join(dir, "posts", "hello.posts.md"), []byte("---\ntitle: Hello\n---\n"+
+		"![cat](img/cat.png) and [the other post](other.md#top), [home](https://example.com), [up](#top).\n"+
+		"`[not](a-link.md)`\n\n[ref]: /docs/guide.md\n"), 0644)
+
+	cfg := config.Default()
+	cfg.MarkdownAssets = true
+	outFile := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Build(context.Background(), Options{RootDir: dir, OutputFile: outFile, Config: cfg}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.DB().Query(`SELECT path, referenced_by, "exists" FROM __sqlfs_assets__ ORDER BY path`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var p, by string
+		var exists bool
+		if err := rows.Scan(&p, &by, &exists); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %v", p, by, exists))
+	}
+	want := []string{
+		"docs/guide.md posts/hello.posts.md false",
+		"posts/img/cat.png posts/hello.posts.md true",
+		"posts/other.md posts/hello.posts.md false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("assets = %q, want %q", got, want)
+	}
+}
+
+func TestLoadOrder(t *testing.T) {
+	refs := map[string][]string{
+		"comments": {"posts", "users"},
+		"posts":    {"users"},
+		"a":        {"b"},
+		"b":        {"a"},
+	}
+	got := loadOrder([]string{"a", "b", "comments", "posts", "tags", "users"}, refs)
+	want := []string{"b", "a", "users", "posts", "comments", "tags"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadOrder = %v, want %v", got, want)
+	}
+}
+
+func TestInferSchema(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice.user.yaml"), []byte("id: 1\nname: Alice\nactive: true\njoined: 2024-01-02\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bob.user.yaml"), []byte("id: 2\nname: Bob\nactive: false\nscore: 1.5\njoined: 2024-03-04\n"), 0644)
+	// Files without a table are grouped by their fields.
+	os.MkdirAll(filepath.Join(dir, "places"), 0755)
+	os.WriteFile(filepath.Join(dir, "places", "oslo.yaml"), []byte("city: Oslo\npopulation: 700000\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "places", "bergen.yaml"), []byte("city: Bergen\npopulation: 290000\n"), 0644)
+
+	inferred, err := InferSchema(context.Background(), InferSchemaOptions{RootDir: dir, Config: config.Default()})
+	if err != nil {
+		t.Fatalf("InferSchema: %v", err)
+	}
+	for _, want := range []string{
+		"Table user {",
+		"id     integer [pk]",
+		"active boolean [not null]",
+		"joined date    [not null]",
+		"score  float\n",
+		"Table places {",
+		"population integer [not null]",
+	} {
+		if !strings.Contains(inferred.DBML, want) {
+			t.Errorf("DBML missing %q:\n%s", want, inferred.DBML)
+		}
+	}
+	wantClusters := []FileCluster{{Table: "places", Files: []string{"places/bergen.yaml", "places/oslo.yaml"}}}
+	if !reflect.DeepEqual(inferred.Clusters, wantClusters) {
+		t.Errorf("Clusters = %+v, want %+v", inferred.Clusters, wantClusters)
+	}
+
+	// The proposed schema builds the files it was inferred from.
+	os.WriteFile(filepath.Join(dir, "schema.dbml"), []byte(inferred.DBML), 0644)
+	cfg := config.Default()
+	cfg.Tables = []config.TableMapping{{Pattern: "places/*.yaml", Table: "places"}}
+	if _, err := Build(context.Background(), Options{
+		RootDir:    dir,
+		OutputFile: filepath.Join(t.TempDir(), "test.db"),
+		Config:     cfg,
+	}); err != nil {
+		t.Fatalf("Build with inferred schema: %v", err)
+	}
+}
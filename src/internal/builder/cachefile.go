@@ -0,0 +1,158 @@
+package builder
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+	"github.com/notwillk/sqlfs/internal/validator"
+	"github.com/notwillk/sqlfs/internal/version"
+)
+
+// savedCache is the gob encoding of a Cache written by Save.
+type savedCache struct {
+	Version        string // sqlfs release that wrote it
+	ConfigHash     string
+	SchemaChecksum string
+	Env            map[string]string // digests of the values, see envDigest
+	Files          map[string]savedFile
+	DB             []byte
+}
+
+type savedFile struct {
+	ModTime    time.Time
+	Size       int64
+	Tombstone  time.Time
+	Checksum   string
+	EntityType string
+	Ingested   bool
+	Expires    time.Time
+	Rows       []savedRow
+	Warnings   []validator.ValidationError
+	Assets     []string
+	Matches    []TableScore
+}
+
+type savedRow struct {
+	Table string
+	PK    string
+}
+
+// ErrStaleCache is returned by ReadCache for a cache written by another
+// sqlfs release, whose rows may differ from what this one would build.
+var ErrStaleCache = errors.New("cache was written by another sqlfs release")
+
+// CacheKey returns the name a cache of builds of rootDir is stored under: it
+// changes with the schema file, so that caches of different schemas do not
+// overwrite each other. The error wraps fs.ErrNotExist when the build has
+// no schema.
+func CacheKey(ctx context.Context, rootDir string, cfg *config.Config) (string, error) {
+	data, err := ReadSchema(ctx, rootDir, cfg)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sqlfs-%x.cache", md5.Sum(data)), nil
+}
+
+// Save writes the cache's database and file records to w, gzip-compressed.
+// Saving an empty cache is an error.
+func (c *Cache) Save(w io.Writer) error {
+	if c.db == nil {
+		return errors.New("saving cache: no build to save")
+	}
+	data, err := c.db.Serialize()
+	if err != nil {
+		return fmt.Errorf("saving cache: %w", err)
+	}
+	sc := savedCache{
+		Version:        version.Version,
+		ConfigHash:     c.cfgHash,
+		SchemaChecksum: c.schemaChecksum,
+		Env:            c.env,
+		Files:          make(map[string]savedFile, len(c.files)),
+		DB:             data,
+	}
+	for relPath, cf := range c.files {
+		sf := savedFile{
+			ModTime:    cf.modTime,
+			Size:       cf.size,
+			Tombstone:  cf.tombstone,
+			Checksum:   cf.checksum,
+			EntityType: cf.entityType,
+			Ingested:   cf.ingested,
+			Expires:    cf.expires,
+			Warnings:   cf.warnings,
+			Assets:     cf.assets,
+			Matches:    cf.matches,
+		}
+		for _, r := range cf.rows {
+			sf.Rows = append(sf.Rows, savedRow{Table: r.table, PK: r.pk})
+		}
+		sc.Files[filepath.ToSlash(relPath)] = sf
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(&sc); err != nil {
+		return fmt.Errorf("saving cache: %w", err)
+	}
+	return zw.Close()
+}
+
+// ReadCache reads a cache written by Save for builds of rootDir. Files are
+// matched by content as well as by mod time, since a fresh checkout of the
+// same files gives them new mod times; rows of such files keep the
+// timestamps they were loaded with.
+func ReadCache(r io.Reader, rootDir string) (*Cache, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+	var sc savedCache
+	if err := gob.NewDecoder(zr).Decode(&sc); err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+	if sc.Version != version.Version {
+		return nil, ErrStaleCache
+	}
+	db, err := sqlite.OpenSerialized(sc.DB)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+
+	c := &Cache{
+		db:             db,
+		rootDir:        rootDir,
+		cfgHash:        sc.ConfigHash,
+		schemaChecksum: sc.SchemaChecksum,
+		env:            sc.Env,
+		files:          make(map[string]*cachedFile, len(sc.Files)),
+		byContent:      true,
+	}
+	for relPath, sf := range sc.Files {
+		cf := &cachedFile{
+			modTime:    sf.ModTime,
+			size:       sf.Size,
+			tombstone:  sf.Tombstone,
+			checksum:   sf.Checksum,
+			entityType: sf.EntityType,
+			ingested:   sf.Ingested,
+			expires:    sf.Expires,
+			warnings:   sf.Warnings,
+			assets:     sf.Assets,
+			matches:    sf.Matches,
+		}
+		for _, r := range sf.Rows {
+			cf.rows = append(cf.rows, cachedRow{table: r.Table, pk: r.PK})
+		}
+		c.files[filepath.FromSlash(relPath)] = cf
+	}
+	return c, nil
+}
@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// ChildrenTable lists the child tables that array fields were expanded into,
+// each with its parent table, the back-reference column holding the parent's
+// PK, and the parent's PK column, so that readers such as serve's row filters
+// can follow a child row to its parent.
+const ChildrenTable = "__sqlfs_children__"
+
+// childRef is how a child table refers to its parent.
+type childRef struct {
+	parent, column string
+}
+
+// noteChild records that table holds the records of an array field of parent,
+// referring to them by column.
+func (x *expander) noteChild(table, parent, column string) {
+	if x.children == nil {
+		x.children = make(map[string]childRef)
+	}
+	x.children[table] = childRef{parent, column}
+}
+
+// createChildrenTable adds the child tables the build expanded, and did not
+// exclude, to ChildrenTable. An incremental build keeps the ones the database already
+// lists, since the files it did not reload are not expanded again.
+func (x *expander) createChildrenTable(db *sqlite.DB, pkCol string) error {
+	if len(x.children) == 0 {
+		return nil
+	}
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS " + sqliteQuote(ChildrenTable) +
+		` ("table_name" TEXT PRIMARY KEY, "parent_table" TEXT NOT NULL, "parent_column" TEXT NOT NULL, "parent_key" TEXT NOT NULL)`); err != nil {
+		return fmt.Errorf("creating %s: %w", ChildrenTable, err)
+	}
+	tables := make([]string, 0, len(x.children))
+	for table := range x.children {
+		if !x.cfg.IsExcludedTable(table) {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		ref := x.children[table]
+		if err := db.Exec("INSERT OR REPLACE INTO "+sqliteQuote(ChildrenTable)+` ("table_name", "parent_table", "parent_column", "parent_key") VALUES (?, ?, ?, ?)`,
+			table, ref.parent, ref.column, pkCol); err != nil {
+			return fmt.Errorf("filling %s: %w", ChildrenTable, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// BuildInfoTable holds key/value metadata about the build that produced the
+// database, such as DatasetHashKey.
+const BuildInfoTable = "__sqlfs_build__"
+
+// DatasetHashKey is the BuildInfoTable key of the dataset hash.
+const DatasetHashKey = "dataset_hash"
+
+// datasetHasher collects the checksums of the files ingested by a build.
+type datasetHasher struct {
+	files map[string]string // relative path (slash-separated) → file checksum
+}
+
+func (h *datasetHasher) add(relPath, checksum string) {
+	if h.files == nil {
+		h.files = make(map[string]string)
+	}
+	h.files[relPath] = checksum
+}
+
+// sum returns the hex root of a Merkle tree whose leaves hash each file's
+// path and checksum in path order. Builds of the same files, with the same
+// contents at the same paths, have the same root; the hash of an empty
+// dataset is that of no bytes.
+func (h *datasetHasher) sum() string {
+	paths := make([]string, 0, len(h.files))
+	for p := range h.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	level := make([][]byte, len(paths))
+	for i, p := range paths {
+		leaf := sha256.Sum256([]byte(p + "\x00" + h.files[p]))
+		level[i] = leaf[:]
+	}
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:])
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i]) // an odd node is promoted as is
+				continue
+			}
+			node := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, node[:])
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// writeBuildInfo creates BuildInfoTable, unless an incremental build already
+// did, and records the dataset hash in it.
+func writeBuildInfo(db *sqlite.DB, datasetHash string) error {
+	if err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  \"key\" TEXT PRIMARY KEY,\n  \"value\" TEXT NOT NULL\n)",
+		sqliteQuote(BuildInfoTable))); err != nil {
+		return fmt.Errorf("creating %s: %w", BuildInfoTable, err)
+	}
+	if err := db.Exec(fmt.Sprintf(`INSERT OR REPLACE INTO %s ("key", "value") VALUES (?, ?)`, sqliteQuote(BuildInfoTable)),
+		DatasetHashKey, datasetHash); err != nil {
+		return fmt.Errorf("writing %s: %w", BuildInfoTable, err)
+	}
+	return nil
+}
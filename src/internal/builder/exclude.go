@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+)
+
+// ExcludeTables removes the tables that are never built from s: those with
+// the [exclude] setting and those listed in cfg.ExcludeTables, along with the
+// relationships involving them. It returns the names of the removed tables.
+func ExcludeTables(s *dbml.Schema, cfg *config.Config) map[string]struct{} {
+	excluded := make(map[string]struct{})
+	kept := s.Tables[:0]
+	for _, t := range s.Tables {
+		if t.Exclude || cfg.IsExcludedTable(t.Name) {
+			excluded[t.Name] = struct{}{}
+			continue
+		}
+		kept = append(kept, t)
+	}
+	s.Tables = kept
+	if len(excluded) == 0 {
+		return excluded
+	}
+
+	isExcluded := func(table string) bool {
+		_, ok := excluded[table]
+		return ok
+	}
+	for _, t := range s.Tables {
+		for _, col := range t.Columns {
+			refs := col.Refs[:0]
+			for _, r := range col.Refs {
+				if !isExcluded(r.To.Table) {
+					refs = append(refs, r)
+				}
+			}
+			col.Refs = refs
+		}
+	}
+	refs := s.Refs[:0]
+	for _, r := range s.Refs {
+		if !isExcluded(r.From.Table) && !isExcluded(r.To.Table) {
+			refs = append(refs, r)
+		}
+	}
+	s.Refs = refs
+	return excluded
+}
@@ -0,0 +1,26 @@
+package builder
+
+import (
+	"path/filepath"
+
+	"github.com/notwillk/sqlfs/internal/config"
+)
+
+// configFileName is the project configuration file in the root directory.
+const configFileName = "sqlfs.yaml"
+
+// isProjectFile reports whether the file called name is the schema or the
+// config file, which are never loaded as data files.
+func isProjectFile(name string, cfg *config.Config) bool {
+	return sameFileName(name, cfg.SchemaFile) || sameFileName(name, configFileName)
+}
+
+// ignored reports whether a walk of rootDir skips path, a file or directory
+// that cfg ignores. The root itself is never skipped.
+func ignored(rootDir, path string, cfg *config.Config) bool {
+	if path == rootDir {
+		return false
+	}
+	rel, err := filepath.Rel(rootDir, path)
+	return err == nil && cfg.IsIgnored(rel)
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !darwin
+
+package builder
+
+// sameFileName compares file names exactly, as case-sensitive file systems do.
+func sameFileName(a, b string) bool {
+	return a == b
+}
@@ -0,0 +1,11 @@
+//go:build windows || darwin
+
+package builder
+
+import "strings"
+
+// sameFileName compares file names case-insensitively, as the default file
+// systems of Windows and macOS do: SQLFS.yaml is the config file there.
+func sameFileName(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
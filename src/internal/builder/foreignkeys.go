@@ -0,0 +1,137 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+	"github.com/notwillk/sqlfs/internal/validator"
+)
+
+// fkViolation is one row of PRAGMA foreign_key_check.
+type fkViolation struct {
+	table  string
+	rowid  int64
+	parent string
+	fkid   int
+}
+
+// checkForeignKeys reports the rows whose references do not resolve when
+// Config.ForeignKeys is set. Rows are inserted in file order and references to
+// increment ids are resolved afterwards, so constraints cannot be enforced
+// while inserting; the check runs once the database is complete instead.
+// An entity reference such as "&users/alice" is stored as the target's pk
+// standard column, so a value matching that of a row of the referenced table
+// resolves too. Violations are handled like validation failures: with
+// InvalidFail the first one is returned as an error, with InvalidWarn they
+// are returned as warnings, and with InvalidSilent they are ignored. files
+// are the build's files by path, whose rows name the file each violation is
+// reported for.
+func checkForeignKeys(db *sqlite.DB, cfg *config.Config, files map[string]*cachedFile) ([]validator.ValidationError, error) {
+	if !cfg.ForeignKeys || cfg.Invalid == config.InvalidSilent {
+		return nil, nil
+	}
+
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("checking foreign keys: %w", err)
+	}
+	var violations []fkViolation
+	for rows.Next() {
+		var v fkViolation
+		if err := rows.Scan(&v.table, &v.rowid, &v.parent, &v.fkid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("checking foreign keys: %w", err)
+		}
+		violations = append(violations, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("checking foreign keys: %w", err)
+	}
+
+	var errs []validator.ValidationError
+	var sources map[cachedRow]string
+	for _, v := range violations {
+		ve, err := describeViolation(db, cfg, v)
+		if err != nil {
+			return nil, err
+		}
+		if ve == nil {
+			continue
+		}
+		if sources == nil {
+			sources = make(map[cachedRow]string)
+			for relPath, cf := range files {
+				for _, r := range cf.rows {
+					sources[r] = filepath.ToSlash(relPath)
+				}
+			}
+		}
+		if src, ok := sources[cachedRow{table: v.table, pk: ve.RecordKey}]; ok {
+			ve.FilePath = src
+		}
+		if cfg.Invalid == config.InvalidFail {
+			return nil, *ve
+		}
+		errs = append(errs, *ve)
+	}
+	return errs, nil
+}
+
+// describeViolation looks up the referencing column and value of v. It
+// returns nil when the value is the pk standard column of a row of the
+// referenced table, which is how entity references are stored. The error's
+// FilePath is the table until the caller finds the row's file.
+func describeViolation(db *sqlite.DB, cfg *config.Config, v fkViolation) (*validator.ValidationError, error) {
+	var from, to string
+	rows, err := db.Query(`SELECT id, "from", "to" FROM pragma_foreign_key_list(?)`, v.table)
+	if err != nil {
+		return nil, fmt.Errorf("checking foreign keys: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		var f, t string
+		if err := rows.Scan(&id, &f, &t); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("checking foreign keys: %w", err)
+		}
+		if id == v.fkid {
+			from, to = f, t
+		}
+	}
+	rows.Close()
+
+	var pk, value any
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE rowid = ?",
+		sqliteQuote(cfg.StandardColumns.PK), sqliteQuote(from), sqliteQuote(v.table))
+	r, err := db.Query(query, v.rowid)
+	if err != nil {
+		return nil, fmt.Errorf("checking foreign keys: %w", err)
+	}
+	if r.Next() {
+		err = r.Scan(&pk, &value)
+	}
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("checking foreign keys: %w", err)
+	}
+
+	var found int
+	err = db.DB().QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s = ?",
+		sqliteQuote(v.parent), sqliteQuote(cfg.StandardColumns.PK)), value).Scan(&found)
+	if err != nil {
+		return nil, fmt.Errorf("checking foreign keys: %w", err)
+	}
+	if found > 0 {
+		return nil, nil
+	}
+
+	return &validator.ValidationError{
+		FilePath:  v.table,
+		RecordKey: fmt.Sprint(pk),
+		Field:     from,
+		Message:   fmt.Sprintf("value %v does not match any %s.%s", value, v.parent, to),
+	}, nil
+}
@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	"github.com/notwillk/sqlfs/internal/config"
-	"github.com/notwillk/sqlfs/internal/loader"
 )
 
 // GenerateSchemaOptions configures a schema generation run.
@@ -29,7 +28,7 @@ func GenerateSchema(opts GenerateSchemaOptions) (string, error) {
 		}
 	}
 
-	reg := loader.NewRegistry()
+	reg := NewRegistry(cfg)
 	tables, _, err := discoverTables(opts.RootDir, cfg, reg)
 	if err != nil {
 		return "", fmt.Errorf("discovering schema: %w", err)
@@ -54,7 +53,7 @@ func DiscoverColumnMap(rootDir string, cfg *config.Config) (map[string][]string,
 		}
 	}
 
-	reg := loader.NewRegistry()
+	reg := NewRegistry(cfg)
 	tables, _, err := discoverTables(rootDir, cfg, reg)
 	if err != nil {
 		return nil, fmt.Errorf("discovering schema: %w", err)
@@ -0,0 +1,146 @@
+package builder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// IDGenerator generates the value of the ULID standard column of each row.
+type IDGenerator interface {
+	NewID(rec *loader.ExpandedRecord) string
+}
+
+// NewIDGenerator returns the generator for strategy; the empty strategy is
+// config.IDULID. With deterministic set, the random parts of ULIDs and UUIDs
+// are derived from the record instead (see Config.Deterministic);
+// config.IDSnowflake IDs cannot be made deterministic.
+func NewIDGenerator(strategy config.IDStrategy, deterministic bool) IDGenerator {
+	switch strategy {
+	case config.IDUUIDv4:
+		return uuidIDs{deterministic: deterministic}
+	case config.IDUUIDv7:
+		return uuidIDs{v7: true, deterministic: deterministic}
+	case config.IDSnowflake:
+		return &snowflakeIDs{}
+	case config.IDPathHash:
+		return pathHashIDs{}
+	default:
+		if deterministic {
+			return &ulidIDs{}
+		}
+		return &ulidIDs{entropy: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	}
+}
+
+// ulidIDs generates ULIDs timestamped with the file's creation time. Without
+// entropy, the random part is taken from recordHash.
+type ulidIDs struct {
+	mu      sync.Mutex
+	entropy *rand.Rand
+}
+
+func (g *ulidIDs) NewID(rec *loader.ExpandedRecord) string {
+	if g.entropy == nil {
+		sum := recordHash(rec, "")
+		return ulid.MustNew(ulid.Timestamp(rec.CreatedAt), bytes.NewReader(sum[:])).String()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return ulid.MustNew(ulid.Timestamp(rec.CreatedAt), g.entropy).String()
+}
+
+// recordHash identifies a record by its table, primary key, file and the
+// file's checksum, so that it is the same in every build of the same files.
+// salt tells apart several values derived for one record.
+func recordHash(rec *loader.ExpandedRecord, salt string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(rec.TableName + "\x00" + rec.PK + "\x00" + rec.SourcePath + "\x00" + rec.Checksum + "\x00" + salt))
+}
+
+// uuidIDs generates random UUIDs, or version 7 UUIDs timestamped with the
+// file's creation time. With deterministic set, the random bits are taken
+// from recordHash.
+type uuidIDs struct{ v7, deterministic bool }
+
+func (g uuidIDs) NewID(rec *loader.ExpandedRecord) string {
+	return g.newID(rec, "")
+}
+
+// newID returns a UUID for rec; salt tells apart the UUIDs of one record
+// when they are deterministic.
+func (g uuidIDs) newID(rec *loader.ExpandedRecord, salt string) string {
+	var id uuid.UUID
+	if g.deterministic {
+		sum := recordHash(rec, salt)
+		copy(id[:], sum[:])
+		id[6] = id[6]&0x0f | 0x40 // version 4, changed to 7 below if v7
+		id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+		if g.v7 {
+			id[6] = id[6]&0x0f | 0x70
+		}
+	} else if g.v7 {
+		id = uuid.Must(uuid.NewV7())
+	} else {
+		return uuid.New().String()
+	}
+	if !g.v7 {
+		return id.String()
+	}
+	ms := uint64(rec.CreatedAt.UnixMilli())
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	return id.String()
+}
+
+// snowflakeEpoch is the Unix millisecond that snowflake timestamps count
+// from, Twitter's original epoch.
+const snowflakeEpoch = 1288834974657
+
+// snowflakeIDs generates snowflake IDs in decimal: 41 bits of milliseconds
+// since snowflakeEpoch, 10 bits of machine id (always zero) and a 12-bit
+// sequence number within the millisecond. Unlike the other strategies, the
+// time is that of generation, so IDs never repeat within a process.
+type snowflakeIDs struct {
+	mu   sync.Mutex
+	last int64 // milliseconds of the latest ID
+	seq  int64
+}
+
+func (g *snowflakeIDs) NewID(*loader.ExpandedRecord) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms := time.Now().UnixMilli() - snowflakeEpoch
+	if ms < g.last {
+		ms = g.last // the clock went back
+	}
+	if ms == g.last {
+		g.seq = (g.seq + 1) & 0xfff
+		if g.seq == 0 {
+			ms++ // sequence exhausted: borrow the next millisecond
+		}
+	} else {
+		g.seq = 0
+	}
+	g.last = ms
+	return strconv.FormatInt(ms<<22|g.seq, 10)
+}
+
+// pathHashIDs derives IDs from the row's table and primary key, so a row
+// keeps its ID across builds.
+type pathHashIDs struct{}
+
+func (pathHashIDs) NewID(rec *loader.ExpandedRecord) string {
+	sum := sha256.Sum256([]byte(rec.TableName + "\x00" + rec.PK))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,179 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/loader"
+	"github.com/notwillk/sqlfs/internal/schema"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// incrementColumn returns the table's [pk, increment] integer column, or nil.
+func incrementColumn(t *dbml.Table) *dbml.Column {
+	for _, col := range t.Columns {
+		if col.PK && col.Increment && schema.DBMLTypeToSQLite(col.Type) == "INTEGER" {
+			return col
+		}
+	}
+	return nil
+}
+
+// IncrementsTable maps each row whose [pk, increment] id the build assigned
+// to that id.
+const IncrementsTable = "__sqlfs_increments__"
+
+// placeholderID is below the placeholder ids omitted [pk, increment] ids are
+// inserted with until assignIncrements replaces them, so that they cannot
+// collide with explicit ids, not all of which are known yet.
+const placeholderID = -(1 << 62)
+
+// incrementLog records, in expansion order, the [pk, increment] ids of a
+// table's records: the explicit ones, and the pks of the records that omit
+// theirs.
+type incrementLog struct {
+	col      string
+	explicit map[int64]bool
+	events   []incrementEvent
+}
+
+// incrementEvent is an explicit id, or the pk of a record that omitted its
+// id, inserted with the placeholder id placeholderID-n for the event's
+// 1-based index n.
+type incrementEvent struct {
+	id      int64
+	pk      string
+	omitted bool
+}
+
+// assignIncrement fills the record's omitted [pk, increment] column with a
+// placeholder, to be replaced with its id by assignIncrements once every
+// explicit id of the table has been seen.
+func (x *expander) assignIncrement(rec *loader.ExpandedRecord) {
+	if x.schema == nil {
+		return
+	}
+	t := x.schema.TableByName(rec.TableName)
+	if t == nil {
+		return
+	}
+	col := incrementColumn(t)
+	if col == nil {
+		return
+	}
+	if x.increments == nil {
+		x.increments = make(map[string]*incrementLog)
+	}
+	l := x.increments[t.Name]
+	if l == nil {
+		l = &incrementLog{col: col.Name, explicit: make(map[int64]bool)}
+		x.increments[t.Name] = l
+	}
+	if v, ok := rec.Fields[col.Name]; ok && v != nil {
+		if id, ok := integerValue(v); ok {
+			l.explicit[id] = true
+			l.events = append(l.events, incrementEvent{id: id})
+		}
+		return
+	}
+	l.events = append(l.events, incrementEvent{pk: rec.PK, omitted: true})
+	rec.Fields[col.Name] = placeholderID - int64(len(l.events))
+}
+
+// assignIncrements replaces the placeholder ids of the records that omitted
+// their [pk, increment] id. Ids follow the order records were expanded in,
+// which is the walk's lexical file order, so every build assigns the same
+// ids: each record gets the id after the largest one seen before it, skipping
+// ids that any record of the table gives explicitly. The assignments are
+// recorded in IncrementsTable.
+func (x *expander) assignIncrements(db *sqlite.DB, pkCol string) error {
+	if len(x.increments) == 0 {
+		return nil
+	}
+	if err := db.Exec("CREATE TABLE " + sqliteQuote(IncrementsTable) +
+		` ("table" TEXT NOT NULL, "pk" TEXT NOT NULL, "id" INTEGER NOT NULL, PRIMARY KEY ("table", "pk"))`); err != nil {
+		return fmt.Errorf("creating %s: %w", IncrementsTable, err)
+	}
+	if err := db.Exec("BEGIN"); err != nil {
+		return err
+	}
+	tables := make([]string, 0, len(x.increments))
+	for table := range x.increments {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		l := x.increments[table]
+		update := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, sqliteQuote(table), sqliteQuote(l.col), sqliteQuote(l.col))
+		record := fmt.Sprintf(`INSERT INTO %s ("table", "pk", "id") SELECT ?, %s, %s FROM %s WHERE %s = ?`,
+			sqliteQuote(IncrementsTable), sqliteQuote(pkCol), sqliteQuote(l.col), sqliteQuote(table), sqliteQuote(l.col))
+		var last int64
+		for i, e := range l.events {
+			if !e.omitted {
+				last = max(last, e.id)
+				continue
+			}
+			last++
+			for l.explicit[last] {
+				last++
+			}
+			if err := db.Exec(update, last, placeholderID-int64(i+1)); err != nil {
+				db.Exec("ROLLBACK")
+				return fmt.Errorf("assigning %s.%s of %s: %w", table, l.col, e.pk, err)
+			}
+			if err := db.Exec(record, table, last); err != nil {
+				db.Exec("ROLLBACK")
+				return fmt.Errorf("filling %s: %w", IncrementsTable, err)
+			}
+		}
+	}
+	return db.Exec("COMMIT")
+}
+
+// integerValue returns v as an int64 when it holds a whole number, whichever
+// numeric type its loader decoded it as.
+func integerValue(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// resolveIncrementRefs rewrites entity references to tables with a
+// [pk, increment] column. A reference is stored as the target's path, which is
+// also the target row's __pk__, so each referencing column is updated to the id
+// of the row whose __pk__ it holds. Other values are left as-is.
+func resolveIncrementRefs(db *sqlite.DB, s *dbml.Schema, pkCol string) error {
+	for _, ref := range s.Relationships() {
+		to := s.TableByName(ref.To.Table)
+		if to == nil || s.TableByName(ref.From.Table) == nil {
+			continue
+		}
+		col := incrementColumn(to)
+		if col == nil || !strings.EqualFold(col.Name, ref.To.Column) {
+			continue
+		}
+		from, fromCol := sqliteQuote(ref.From.Table), sqliteQuote(ref.From.Column)
+		target, targetPK := sqliteQuote(to.Name), sqliteQuote(pkCol)
+		query := fmt.Sprintf(
+			`UPDATE %s SET %s = (SELECT %s FROM %s WHERE %s = %s.%s) WHERE typeof(%s) = 'text' AND %s IN (SELECT %s FROM %s)`,
+			from, fromCol, sqliteQuote(col.Name), target, targetPK, from, fromCol,
+			fromCol, fromCol, targetPK, target)
+		if err := db.Exec(query); err != nil {
+			return fmt.Errorf("resolving references %s.%s → %s.%s: %w",
+				ref.From.Table, ref.From.Column, to.Name, col.Name, err)
+		}
+	}
+	return nil
+}
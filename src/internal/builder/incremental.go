@@ -0,0 +1,228 @@
+package builder
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/loader"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+	"github.com/notwillk/sqlfs/internal/validator"
+)
+
+// Cache carries the state of incremental builds from one Build to the next:
+// the database the last build produced and, for each data file, the mod time
+// and checksum it was loaded with and the rows it contributed. A Build given a
+// cache it can use re-loads only the files that were added or changed since,
+// deletes the rows of changed and removed files and inserts their new rows
+// into that database, instead of building a new one from scratch.
+//
+// A cache can be saved and read back by another process or on another
+// machine; see Save and ReadCache.
+//
+// A build is done in full, and the cache refilled, when the cache is empty,
+// when the root directory, the configuration, the schema file or an
+// interpolated environment variable changed, when there is no schema file, or
+// when a table has a [pk, increment] column, since its ids depend on the
+// order of all files. A build that fails empties the cache.
+//
+// Rows a patched database keeps are identical to those of a full build,
+// ULIDs aside, but new rows are stored after them rather than in file order.
+// A Cache must not be used by concurrent builds.
+type Cache struct {
+	db             *sqlite.DB
+	rootDir        string
+	cfgHash        string
+	schemaChecksum string
+	env            map[string]string
+	files          map[string]*cachedFile // relative path → file
+	// byContent also matches files whose mod time changed by their
+	// checksum, for caches read back where a checkout reset mod times.
+	byContent bool
+}
+
+// cachedFile is what a build recorded about one data file.
+type cachedFile struct {
+	modTime    time.Time
+	size       int64
+	tombstone  time.Time // mod time of the tombstone marker; zero if none
+	checksum   string
+	entityType string
+	ingested   bool      // passed validation and counts towards the dataset hash
+	expires    time.Time // when its row expires; zero if never
+	rows       []cachedRow
+	warnings   []validator.ValidationError
+	assets     []string // see markdownAssets
+	invalid    bool     // has records that failed validation; see atomicGroups
+	// matches are the two best TableMatch scores of a file matched to its
+	// table by its fields.
+	matches []TableScore
+}
+
+// cachedRow identifies a row inserted from a file.
+type cachedRow struct {
+	table string
+	pk    string
+}
+
+// NewCache returns an empty cache; the first Build given it is a full build.
+func NewCache() *Cache {
+	return &Cache{}
+}
+
+// Close releases the cached database. The cache is empty afterwards.
+func (c *Cache) Close() error {
+	var err error
+	if c.db != nil {
+		err = c.db.Close()
+	}
+	*c = Cache{}
+	return err
+}
+
+// incrementalState is the part of a build's inputs that must not change
+// between two builds for the second to patch the first's database.
+type incrementalState struct {
+	rootDir        string
+	cfgHash        string
+	schemaChecksum string
+	env            map[string]string // digests of the variables' values
+}
+
+// newIncrementalState captures the inputs of a DBML mode build of the schema
+// schemaSrc.
+func newIncrementalState(rootDir string, cfg *config.Config, schemaSrc []byte) (incrementalState, error) {
+	env := make(map[string]string, len(cfg.InterpolateEnv))
+	for _, name := range cfg.InterpolateEnv {
+		env[name] = envDigest(os.Getenv(name))
+	}
+	if cfg.Redact == config.RedactHash {
+		// Changing the key changes every hashed value.
+		env[cfg.RedactKeyEnvVar] = envDigest(os.Getenv(cfg.RedactKeyEnvVar))
+	}
+	cfgHash, err := configHash(cfg)
+	if err != nil {
+		return incrementalState{}, err
+	}
+	return incrementalState{
+		rootDir:        rootDir,
+		cfgHash:        cfgHash,
+		schemaChecksum: fmt.Sprintf("%x", md5.Sum(schemaSrc)),
+		env:            env,
+	}, nil
+}
+
+// envDigest returns the SHA-256 digest of an environment variable's value.
+// Caches keep digests rather than values, which may be secrets, since saved
+// caches leave the machine.
+func envDigest(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
+}
+
+// canPatch reports whether a build with state st and schema s may patch the
+// cached database.
+func (c *Cache) canPatch(st incrementalState, s *dbml.Schema) bool {
+	if c == nil || c.db == nil || c.rootDir != st.rootDir || c.schemaChecksum != st.schemaChecksum {
+		return false
+	}
+	if c.cfgHash != st.cfgHash || len(c.env) != len(st.env) {
+		return false
+	}
+	for name, v := range st.env {
+		if cv, ok := c.env[name]; !ok || cv != v {
+			return false
+		}
+	}
+	for _, t := range s.Tables {
+		if incrementColumn(t) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// store replaces the cache's contents with the outcome of a successful
+// build. The cache takes ownership of db, closing the database it held unless
+// that is db itself.
+func (c *Cache) store(db *sqlite.DB, st incrementalState, files map[string]*cachedFile) {
+	if c.db != nil && c.db != db {
+		c.db.Close()
+	}
+	c.db = db
+	c.rootDir = st.rootDir
+	c.cfgHash = st.cfgHash
+	c.schemaChecksum = st.schemaChecksum
+	c.env = st.env
+	c.files = files
+	c.byContent = false
+}
+
+// configHash fingerprints every setting of cfg, so that caches built with
+// different configurations are told apart even across processes.
+func configHash(cfg *config.Config) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("hashing config: %w", err)
+	}
+	// *time.Location has no exported fields; add its name.
+	data = append(data, cfg.TimestampLocation.String()...)
+	return fmt.Sprintf("%x", md5.Sum(data)), nil
+}
+
+// unchanged returns the cached record of the file at relPath when the file's
+// size, mod time and tombstone marker are as they were when it was loaded
+// and its row has not expired by now.
+// With byContent set, a file whose mod times differ is unchanged if its
+// checksum and the presence of its tombstone marker are the same.
+func (c *Cache) unchanged(absPath, relPath string, d fs.DirEntry, now time.Time) (*cachedFile, bool) {
+	cf, ok := c.files[relPath]
+	if !ok || !cf.expires.IsZero() && !cf.expires.After(now) {
+		return nil, false
+	}
+	info, err := d.Info()
+	if err != nil || info.Size() != cf.size {
+		return nil, false
+	}
+	tombstone := tombstoneModTime(absPath)
+	if info.ModTime().Equal(cf.modTime) && tombstone.Equal(cf.tombstone) {
+		return cf, true
+	}
+	if !c.byContent || tombstone.IsZero() != cf.tombstone.IsZero() {
+		return nil, false
+	}
+	checksum, _, err := loader.FileChecksum(absPath)
+	if err != nil || checksum != cf.checksum {
+		return nil, false
+	}
+	// Record the new mod times so that the next build need not read it.
+	cf.modTime = info.ModTime()
+	cf.tombstone = tombstone
+	return cf, true
+}
+
+// tombstoneModTime returns the mod time of the tombstone marker of the file at
+// absPath, or the zero time when there is none.
+func tombstoneModTime(absPath string) time.Time {
+	info, err := os.Stat(absPath + config.TombstoneSuffix)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// deleteRows removes the rows a file contributed to db.
+func deleteRows(db *sqlite.DB, cfg *config.Config, cf *cachedFile) error {
+	for _, r := range cf.rows {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", sqliteQuote(r.table), sqliteQuote(cfg.StandardColumns.PK))
+		if err := db.Exec(query, r.pk); err != nil {
+			return fmt.Errorf("deleting %s row %q: %w", r.table, r.pk, err)
+		}
+	}
+	return nil
+}
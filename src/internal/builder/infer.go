@@ -0,0 +1,472 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// InferSchemaOptions configures a schema inference run.
+type InferSchemaOptions struct {
+	RootDir string
+	Config  *config.Config
+}
+
+// InferredSchema is a schema proposed for the data files of a root.
+type InferredSchema struct {
+	// DBML is the proposed schema.
+	DBML string
+	// Clusters are the tables proposed for files no table is found for by
+	// their names or the tables patterns of sqlfs.yaml, which the schema
+	// includes, in order of their first files.
+	Clusters []FileCluster
+}
+
+// FileCluster is a table proposed for files whose records have similar
+// fields.
+type FileCluster struct {
+	Table string
+	// Files are the slash-separated paths of the files, relative to the
+	// root, in walk order.
+	Files []string
+}
+
+// clusterSimilarity is the least share of their fields that a file must
+// have in common with the files of a cluster to join it.
+const clusterSimilarity = 0.5
+
+// longText is the length from which string values make a text column
+// rather than a varchar one.
+const longText = 255
+
+// InferSchema proposes a DBML schema for the data files in RootDir, as a
+// starting point for data without one. Like GenerateSchema, it has a table
+// per table of a schema-less build, and a child table per array field, but
+// it types each column by the values it holds, marks columns that every row
+// sets as not null, and makes an id column whose values are all set and
+// distinct the primary key. Files no table is found for are grouped into
+// tables of their own by their fields; see InferredSchema.Clusters.
+func InferSchema(ctx context.Context, opts InferSchemaOptions) (*InferredSchema, error) {
+	cfg := opts.Config
+	if cfg == nil {
+		var err error
+		cfg, err = config.Load(opts.RootDir)
+		if err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+	}
+
+	reg := NewRegistry(cfg)
+	walked, err := walkSchemaless(ctx, opts.RootDir, cfg, reg, true)
+	if err != nil {
+		return nil, fmt.Errorf("discovering schema: %w", err)
+	}
+	frs := make([]*loader.FileRecord, len(walked))
+	if err := forEachLoaded(ctx, 0, len(walked), func(i int) error {
+		wf := walked[i]
+		fr, err := reg.LoadFile(wf.path, wf.relPath)
+		if err != nil {
+			return nil // files that fail to load are left out, as in discovery
+		}
+		if applyTombstone(wf.path, fr, cfg.KeepTombstones()) {
+			return nil
+		}
+		if wf.entityType != "" {
+			applyRenames(cfg, wf.entityType, fr)
+			if err := applyTransform(cfg, wf.entityType, fr); err != nil {
+				return nil
+			}
+		}
+		frs[i] = fr
+		return nil
+	}, nil, func(int) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	result := &InferredSchema{}
+	taken := make(map[string]bool)
+	for _, wf := range walked {
+		if wf.entityType != "" {
+			taken[wf.entityType] = true
+		}
+	}
+	result.Clusters = clusterFiles(walked, frs, taken)
+	clusterOf := make(map[string]string)
+	for _, c := range result.Clusters {
+		for _, f := range c.Files {
+			clusterOf[f] = c.Table
+		}
+	}
+	for i := range walked {
+		if walked[i].entityType == "" {
+			walked[i].entityType = clusterOf[filepath.ToSlash(walked[i].relPath)]
+		}
+	}
+
+	in := &inference{cfg: cfg, tables: make(map[string]*inferredTable)}
+	for i, wf := range walked {
+		if frs[i] == nil || wf.entityType == "" {
+			continue
+		}
+		for _, rec := range frs[i].Records {
+			in.observe(wf.entityType, rec.Fields, rec.FieldOrder())
+		}
+	}
+	for name := range in.tables {
+		if cfg.IsExcludedTable(name) {
+			delete(in.tables, name)
+		}
+	}
+	if len(in.tables) == 0 {
+		return nil, fmt.Errorf("no entity files found in %q", opts.RootDir)
+	}
+	result.DBML = in.render()
+	return result, nil
+}
+
+// clusterFiles groups the loaded files without a table by their fields:
+// each joins the first cluster whose files' fields it shares the most of,
+// when that is at least clusterSimilarity, or starts a new one. A cluster's
+// table is named after the directory most of its files are in, or "records"
+// for files in the root, and numbered to keep clear of the names in taken.
+func clusterFiles(walked []walkedFile, frs []*loader.FileRecord, taken map[string]bool) []FileCluster {
+	type cluster struct {
+		fields map[string]struct{}
+		files  []string
+		dirs   map[string]int
+	}
+	var clusters []*cluster
+	for i, wf := range walked {
+		if wf.entityType != "" || frs[i] == nil || len(frs[i].Records) == 0 {
+			continue
+		}
+		fields := make(map[string]struct{})
+		for _, rec := range frs[i].Records {
+			for k := range rec.Fields {
+				fields[k] = struct{}{}
+			}
+		}
+		var best *cluster
+		bestScore := 0.0
+		for _, c := range clusters {
+			if score := jaccard(fields, c.fields); score >= clusterSimilarity && score > bestScore {
+				best, bestScore = c, score
+			}
+		}
+		if best == nil {
+			best = &cluster{fields: make(map[string]struct{}), dirs: make(map[string]int)}
+			clusters = append(clusters, best)
+		}
+		for k := range fields {
+			best.fields[k] = struct{}{}
+		}
+		rel := filepath.ToSlash(wf.relPath)
+		best.files = append(best.files, rel)
+		best.dirs[path.Base(path.Dir(rel))]++
+	}
+
+	out := make([]FileCluster, len(clusters))
+	for i, c := range clusters {
+		dir, n := "", 0
+		for d, count := range c.dirs {
+			if count > n || count == n && d < dir {
+				dir, n = d, count
+			}
+		}
+		name := tableIdent(dir)
+		if dir == "." || name == "" {
+			name = "records"
+		}
+		table := name
+		for k := 2; taken[table]; k++ {
+			table = fmt.Sprintf("%s_%d", name, k)
+		}
+		taken[table] = true
+		out[i] = FileCluster{Table: table, Files: c.files}
+	}
+	return out
+}
+
+// jaccard returns the share of the fields of a and b that both have.
+func jaccard(a, b map[string]struct{}) float64 {
+	both := 0
+	for k := range a {
+		if _, ok := b[k]; ok {
+			both++
+		}
+	}
+	if all := len(a) + len(b) - both; all > 0 {
+		return float64(both) / float64(all)
+	}
+	return 1
+}
+
+var nonIdent = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// tableIdent returns name as a lower-case table name of letters, digits and
+// underscores.
+func tableIdent(name string) string {
+	return strings.Trim(nonIdent.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// inference collects the tables and columns of the records it observes,
+// with what their values have in common.
+type inference struct {
+	cfg    *config.Config
+	tables map[string]*inferredTable
+}
+
+type inferredTable struct {
+	rows    int
+	columns []*inferredColumn
+	byName  map[string]*inferredColumn
+}
+
+// inferredColumn describes the values of a column: how many rows set it,
+// the kinds of value seen (see valueKind), and for an id column whether
+// they are all distinct.
+type inferredColumn struct {
+	name      string
+	set       int
+	kinds     map[string]int
+	long      bool
+	ids       map[string]struct{}
+	duplicate bool
+}
+
+func (in *inference) table(name string) *inferredTable {
+	t, ok := in.tables[name]
+	if !ok {
+		t = &inferredTable{byName: make(map[string]*inferredColumn)}
+		in.tables[name] = t
+	}
+	return t
+}
+
+func (t *inferredTable) column(name string) *inferredColumn {
+	c, ok := t.byName[name]
+	if !ok {
+		c = &inferredColumn{name: name, kinds: make(map[string]int)}
+		if name == "id" {
+			c.ids = make(map[string]struct{})
+		}
+		t.byName[name] = c
+		t.columns = append(t.columns, c)
+	}
+	return c
+}
+
+// add records v as the value of the column in one row.
+func (c *inferredColumn) add(v any) {
+	if v == nil {
+		return
+	}
+	c.set++
+	kind := valueKind(v)
+	c.kinds[kind]++
+	if s, ok := v.(string); ok && (len(s) > longText || strings.Contains(s, "\n")) {
+		c.long = true
+	}
+	if c.ids != nil {
+		id := fmt.Sprint(v)
+		if _, ok := c.ids[id]; ok {
+			c.duplicate = true
+		}
+		c.ids[id] = struct{}{}
+	}
+}
+
+// observe records a row of table with fields, visiting them in keys order,
+// and the rows of the child tables its array fields expand into, as a
+// schema-less build would.
+func (in *inference) observe(table string, fields map[string]any, keys []string) {
+	t := in.table(table)
+	t.rows++
+	for _, key := range keys {
+		switch v := fields[key].(type) {
+		case []any:
+			childTable, parentFKCol := in.cfg.ChildTable(table, key)
+			for _, elem := range v {
+				switch e := elem.(type) {
+				case map[string]any:
+					childFields := make(map[string]any, len(e)+1)
+					for k, ev := range e {
+						childFields[k] = ev
+					}
+					childFields[parentFKCol] = "parent"
+					in.observe(childTable, childFields, loader.OrderedKeys(childFields, []string{parentFKCol}))
+				case loader.EntityRef:
+					// A build with a schema stores references in arrays
+					// as ref_pk, whichever table they point to.
+					child := in.table(childTable)
+					child.rows++
+					child.column(parentFKCol).add("parent")
+					child.column("ref_pk").add(e.Path)
+				default:
+					child := in.table(childTable)
+					child.rows++
+					child.column(parentFKCol).add("parent")
+					child.column("value").add(e)
+				}
+			}
+			if len(v) == 0 {
+				in.table(childTable).column(parentFKCol)
+			}
+		case loader.EntityRef:
+			t.column(key).add(v.Path)
+		default:
+			if !isStandardColumn(in.cfg, key) {
+				t.column(key).add(v)
+			}
+		}
+	}
+}
+
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// valueKind classifies a field value for typing its column: "bool", "int",
+// "float", "date", "timestamp", "json" or "string".
+func valueKind(v any) string {
+	switch v := v.(type) {
+	case bool:
+		return "bool"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "int"
+	case float32:
+		return floatKind(float64(v))
+	case float64:
+		return floatKind(v)
+	case time.Time:
+		return "timestamp"
+	case map[string]any, []any:
+		return "json"
+	case string:
+		switch {
+		case datePattern.MatchString(v):
+			if _, err := time.Parse(time.DateOnly, v); err == nil {
+				return "date"
+			}
+		case len(v) >= len(time.DateOnly)+1 && datePattern.MatchString(v[:len(time.DateOnly)]):
+			if _, err := time.Parse(time.RFC3339, v); err == nil {
+				return "timestamp"
+			}
+		case strings.HasPrefix(v, "{") || strings.HasPrefix(v, "["):
+			// Nested objects reach the builder as JSON text.
+			if json.Valid([]byte(v)) {
+				return "json"
+			}
+		}
+		return "string"
+	default:
+		return "string"
+	}
+}
+
+// floatKind returns "int" for whole numbers, which JSON-based formats load
+// as floats, and "float" otherwise.
+func floatKind(f float64) string {
+	if f == float64(int64(f)) {
+		return "int"
+	}
+	return "float"
+}
+
+// columnType returns the DBML type of the column: the type of its kind of
+// value, the wider of integer and float, timestamp for a mix of dates and
+// timestamps, and varchar, or text for long values, for any other mix and
+// for columns that are never set.
+func (c *inferredColumn) columnType() string {
+	has := func(kinds ...string) bool {
+		n := 0
+		for _, k := range kinds {
+			n += c.kinds[k]
+		}
+		return n == c.set
+	}
+	switch {
+	case c.set == 0:
+	case has("bool"):
+		return "boolean"
+	case has("int"):
+		return "integer"
+	case has("int", "float"):
+		return "float"
+	case has("date"):
+		return "date"
+	case has("date", "timestamp"):
+		return "timestamp"
+	case has("json"):
+		return "json"
+	}
+	if c.long {
+		return "text"
+	}
+	return "varchar"
+}
+
+// render writes the inferred tables as DBML, in name order, with each
+// table's columns in the order they were first seen.
+func (in *inference) render() string {
+	names := make([]string, 0, len(in.tables))
+	for name := range in.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for i, name := range names {
+		t := in.tables[name]
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "// Inferred from %d %s.\n", t.rows, plural(t.rows, "row", "rows"))
+		fmt.Fprintf(&sb, "Table %s {\n", quoteDBMLName(name))
+		nameWidth, typeWidth := 0, 0
+		for _, c := range t.columns {
+			nameWidth = max(nameWidth, len(quoteDBMLName(c.name)))
+			typeWidth = max(typeWidth, len(c.columnType()))
+		}
+		for _, c := range t.columns {
+			var settings []string
+			if c.ids != nil && !c.duplicate && c.set == t.rows && c.set > 0 {
+				settings = append(settings, "pk")
+			} else if c.set == t.rows && c.set > 0 {
+				settings = append(settings, "not null")
+			}
+			line := fmt.Sprintf("  %-*s %-*s", nameWidth, quoteDBMLName(c.name), typeWidth, c.columnType())
+			if len(settings) > 0 {
+				line += " [" + strings.Join(settings, ", ") + "]"
+			}
+			sb.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+var plainDBMLName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// quoteDBMLName returns name as written in DBML: double-quoted unless it is
+// a plain identifier.
+func quoteDBMLName(name string) string {
+	if plainDBMLName.MatchString(name) {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
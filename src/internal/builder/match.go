@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/loader"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// MatchesTable records, when table_from is fields, how each file whose name
+// gives no table was matched to one: the best-scoring table and the runner-up
+// with their scores, and why the file was skipped when it was.
+const MatchesTable = "__sqlfs_matches__"
+
+// TableScore is how well the fields of a file match the columns of a table:
+// the number of names they share over the number of names in either, from 0
+// to 1. Standard columns are not counted.
+type TableScore struct {
+	Table string  `json:"table"`
+	Score float64 `json:"score"`
+}
+
+// TableMatch is the table that MatchTable assigns a file to, with the score
+// of every table it considered.
+type TableMatch struct {
+	// Table is the matched table; empty when none matched, and Reason
+	// says why.
+	Table  string
+	Reason string
+	// Scores holds every table with a score above zero, best first and
+	// tables of equal score by name.
+	Scores []TableScore
+}
+
+// MatchTable assigns fr to the table of s whose columns its fields match
+// best, for table_from fields. It refuses to guess: a file whose best score
+// is below cfg.MinMatchRatio, or that two tables match equally well, is
+// assigned to none. s should be without excluded tables; see ExcludeTables.
+func MatchTable(s *dbml.Schema, cfg *config.Config, fr *loader.FileRecord) TableMatch {
+	fields := make(map[string]struct{})
+	for _, rec := range fr.Records {
+		for name := range rec.Fields {
+			if !isStandardColumn(cfg, name) {
+				fields[strings.ToLower(name)] = struct{}{}
+			}
+		}
+	}
+
+	var m TableMatch
+	for _, t := range s.Tables {
+		columns, shared := 0, 0
+		for _, col := range t.Columns {
+			if isStandardColumn(cfg, col.Name) {
+				continue
+			}
+			columns++
+			if _, ok := fields[strings.ToLower(col.Name)]; ok {
+				shared++
+			}
+		}
+		if shared == 0 {
+			continue
+		}
+		m.Scores = append(m.Scores, TableScore{t.Name, float64(shared) / float64(columns+len(fields)-shared)})
+	}
+	sort.Slice(m.Scores, func(i, j int) bool {
+		if m.Scores[i].Score != m.Scores[j].Score {
+			return m.Scores[i].Score > m.Scores[j].Score
+		}
+		return m.Scores[i].Table < m.Scores[j].Table
+	})
+
+	switch {
+	case len(m.Scores) == 0:
+		m.Reason = "its fields match no table's columns"
+	case m.Scores[0].Score < cfg.MinMatchRatio:
+		m.Reason = fmt.Sprintf("its fields match %s best, with a score of %.2f, below min_match_ratio %.2f", m.Scores[0].Table, m.Scores[0].Score, cfg.MinMatchRatio)
+	case len(m.Scores) > 1 && m.Scores[1].Score == m.Scores[0].Score:
+		m.Reason = fmt.Sprintf("its fields match %s and %s equally well, with a score of %.2f", m.Scores[0].Table, m.Scores[1].Table, m.Scores[0].Score)
+	default:
+		m.Table = m.Scores[0].Table
+	}
+	return m
+}
+
+// createMatchesTable replaces MatchesTable with how each file in matches was
+// matched, in path order.
+func createMatchesTable(db *sqlite.DB, matches map[string]TableMatch) error {
+	if err := db.Exec("DROP TABLE IF EXISTS " + sqliteQuote(MatchesTable)); err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE TABLE " + sqliteQuote(MatchesTable) +
+		` ("path" TEXT NOT NULL, "table_name" TEXT, "score" REAL, "runner_up" TEXT, "runner_up_score" REAL, "skipped" TEXT)`); err != nil {
+		return fmt.Errorf("creating %s: %w", MatchesTable, err)
+	}
+	paths := make([]string, 0, len(matches))
+	for relPath := range matches {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+	for _, relPath := range paths {
+		m := matches[relPath]
+		values := []any{filepath.ToSlash(relPath), nil, nil, nil, nil, nullIfEmpty(m.Reason)}
+		for i, s := range m.Scores[:min(len(m.Scores), 2)] {
+			values[1+2*i], values[2+2*i] = s.Table, s.Score
+		}
+		if err := db.Exec("INSERT INTO "+sqliteQuote(MatchesTable)+` ("path", "table_name", "score", "runner_up", "runner_up_score", "skipped") VALUES (?, ?, ?, ?, ?, ?)`,
+			values...); err != nil {
+			return fmt.Errorf("filling %s: %w", MatchesTable, err)
+		}
+	}
+	return nil
+}
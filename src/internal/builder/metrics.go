@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Metrics is the line a build appends to Options.MetricsHistory, so that
+// build times can be followed as a dataset grows.
+type Metrics struct {
+	// Root is the root directory built, as it was given.
+	Root string `json:"root"`
+	// Time is when the build started, in UTC.
+	Time       time.Time `json:"time"`
+	DurationMS int64     `json:"duration_ms"`
+	Files      int       `json:"files"`
+	// FilesCached is how many of Files an incremental build took from its
+	// cache, and CacheHitRate their share of Files; both are 0 for a full
+	// build.
+	FilesCached  int            `json:"files_cached"`
+	CacheHitRate float64        `json:"cache_hit_rate"`
+	Records      int            `json:"records"`
+	TableRows    map[string]int `json:"table_rows"`
+	Warnings     int            `json:"warnings"`
+	DatasetHash  string         `json:"dataset_hash"`
+}
+
+// newMetrics returns the metrics of the build of rootDir started at start that
+// returned result.
+func newMetrics(rootDir string, start time.Time, result *Result) Metrics {
+	m := Metrics{
+		Root:        rootDir,
+		Time:        start.UTC(),
+		DurationMS:  result.Duration.Milliseconds(),
+		Files:       result.Files,
+		FilesCached: result.FilesCached,
+		Records:     result.RecordsTotal,
+		TableRows:   result.TableRows,
+		Warnings:    len(result.Warnings),
+		DatasetHash: result.DatasetHash,
+	}
+	if result.Files > 0 {
+		m.CacheHitRate = float64(result.FilesCached) / float64(result.Files)
+	}
+	return m
+}
+
+// appendMetrics appends m to the JSON Lines file at path, creating it if need
+// be.
+func appendMetrics(path string, m Metrics) error {
+	return appendJSONLines(path, m)
+}
+
+// appendJSONLines appends values, one line of JSON each, to the file at path,
+// creating it if need be.
+func appendJSONLines[T any](path string, values ...T) error {
+	var buf []byte
+	for _, v := range values {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// One write for all the lines, so that concurrent builds appending to
+	// the same file do not interleave them.
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,34 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/githistory"
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// gitModTimes returns the last commit time of each file under rootDir when
+// cfg takes modified-at times from git, and nil otherwise.
+func gitModTimes(ctx context.Context, rootDir string, cfg *config.Config) (map[string]time.Time, error) {
+	if cfg.ModifiedAt != config.ModTimeGit {
+		return nil, nil
+	}
+	times, err := githistory.ModTimes(ctx, rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading modification times from git: %w", err)
+	}
+	return times, nil
+}
+
+// applyModTime replaces fr's modification time with the file's time in
+// times, if it has one. Files that are untracked or have uncommitted changes
+// keep their file system time.
+func applyModTime(times map[string]time.Time, relPath string, fr *loader.FileRecord) {
+	if t, ok := times[filepath.ToSlash(relPath)]; ok {
+		fr.ModTime = t
+	}
+}
@@ -0,0 +1,243 @@
+package builder
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// loadAhead is how many files per job may be loaded ahead of the one being
+// inserted, bounding the memory held by loaded files.
+const loadAhead = 4
+
+// forEachLoaded calls load(i) for each i in [0, n) on up to jobs goroutines
+// (runtime.GOMAXPROCS(0) when jobs is zero or less), and use(i) on the
+// calling goroutine in order of i, each once load(i) has returned. load must
+// be safe to call concurrently; use sees the effects of load(i).
+//
+// At most jobs*loadAhead loaded files wait to be used. While that many do,
+// up to jobs-1 idle goroutines run ahead calling check(i) on the following
+// files, which must fail just as load(i) would, so that a file that fails to
+// load late in a long run is found without waiting for the files before it
+// to be used. check may keep up to jobs*loadAhead of its results in a
+// checkedFiles for load(i) to take when its turn comes; the other files are
+// loaded again. check may be nil not to run ahead.
+//
+// Once a load or check fails, use is not called again. The error returned is
+// that of the first file, in order of i, that fails to load, and failing
+// that, of the first use that fails, so it is the same for any number of
+// jobs and however the loads interleave.
+func forEachLoaded(ctx context.Context, jobs, n int, load, check, use func(i int) error) error {
+	jobs = resolveJobs(jobs)
+	ctx, cancel := context.WithCancel(ctx)
+	p := &pipeline{
+		n:       n,
+		aheadOK: jobs - 1,
+		ready:   make([]bool, jobs*loadAhead),
+		failAt:  n,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		p.mu.Lock()
+		p.stopped = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}()
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(load, check)
+		}()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		for !p.ready[i%len(p.ready)] && !p.failing() && !p.stopped {
+			p.cond.Wait()
+		}
+		if p.stopped {
+			return ctx.Err()
+		}
+		if p.failing() {
+			break
+		}
+		p.ready[i%len(p.ready)] = false
+		p.mu.Unlock()
+		err := use(i)
+		p.mu.Lock()
+		if err != nil {
+			p.useErr = err
+			p.cond.Broadcast()
+			break
+		}
+		p.used++
+		p.cond.Broadcast()
+	}
+	if p.failing() {
+		// Whether an earlier file fails too is only known once every file
+		// before the first known failure has been loaded or checked.
+		for (p.inFlight > 0 || p.ahead() < p.failAt) && !p.stopped {
+			p.cond.Wait()
+		}
+		if p.stopped {
+			return ctx.Err()
+		}
+	}
+	if p.failAt < n {
+		return p.failErr
+	}
+	return p.useErr
+}
+
+// pipeline is the state shared by the goroutines of forEachLoaded, guarded
+// by mu. Files before nextLoad have been handed out to load, and those from
+// nextLoad to nextCheck to check.
+type pipeline struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	n         int
+	aheadOK   int    // how many checks may run at once
+	ready     []bool // ready[i%len(ready)]: load(i) has returned, for i in [used, used+len(ready))
+	used      int    // number of files used
+	nextLoad  int
+	nextCheck int
+	inFlight  int // loads and checks running
+	checking  int // checks running
+	failAt    int // first file known to fail to load; n if none
+	failErr   error
+	useErr    error
+	stopped   bool // the context is done
+}
+
+// failing reports whether the run is known to fail.
+func (p *pipeline) failing() bool { return p.failAt < p.n || p.useErr != nil }
+
+// ahead returns the first file not yet handed out.
+func (p *pipeline) ahead() int { return max(p.nextLoad, p.nextCheck) }
+
+// work loads and checks files until the run ends.
+func (p *pipeline) work(load, check func(i int) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.stopped {
+		var i int
+		var f func(i int) error
+		keep, runAhead := false, false
+		switch {
+		case p.failing():
+			// Only whether the files before the first failure load matters.
+			if i = p.ahead(); i >= p.failAt {
+				p.cond.Wait()
+				continue
+			}
+			p.nextCheck = i + 1
+			if f = check; f == nil {
+				f = load
+			}
+		case p.nextLoad < p.n && p.nextLoad < p.used+len(p.ready):
+			i, f, keep = p.nextLoad, load, true
+			p.nextLoad++
+		case check != nil && p.checking < p.aheadOK && p.ahead() < p.n:
+			i, f, runAhead = p.ahead(), check, true
+			p.nextCheck = i + 1
+			p.checking++
+		default:
+			p.cond.Wait()
+			continue
+		}
+
+		p.inFlight++
+		p.mu.Unlock()
+		err := f(i)
+		p.mu.Lock()
+		p.inFlight--
+		if runAhead {
+			p.checking--
+		}
+		if err != nil && i < p.failAt {
+			p.failAt, p.failErr = i, err
+		}
+		if keep {
+			p.ready[i%len(p.ready)] = true
+		}
+		p.cond.Broadcast()
+	}
+}
+
+// resolveJobs returns jobs, or runtime.GOMAXPROCS(0) when it is zero or less.
+func resolveJobs(jobs int) int {
+	if jobs <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return jobs
+}
+
+// checkedFiles keeps what the check calls of forEachLoaded loaded, by file
+// index, for load to take instead of loading the files again. It holds at
+// most max of them, counting those being checked, bounding the memory they
+// take beside the files waiting to be used. It is safe for concurrent use.
+type checkedFiles[T any] struct {
+	mu       sync.Mutex
+	max      int
+	checking map[int]bool // being checked; true once load has asked for it
+	files    map[int]T
+}
+
+// newCheckedFiles returns a checkedFiles holding up to jobs*loadAhead files.
+func newCheckedFiles[T any](jobs int) *checkedFiles[T] {
+	return &checkedFiles[T]{max: resolveJobs(jobs) * loadAhead, checking: make(map[int]bool), files: make(map[int]T)}
+}
+
+// reserve reports whether there is room for file i, taking it if so. The
+// caller must then call put or release.
+func (c *checkedFiles[T]) reserve(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.checking)+len(c.files) >= c.max {
+		return false
+	}
+	c.checking[i] = false
+	return true
+}
+
+// put keeps file i in the room reserve took, unless load has asked for it
+// in the meantime.
+func (c *checkedFiles[T]) put(i int, file T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checking[i] {
+		c.files[i] = file
+	}
+	delete(c.checking, i)
+}
+
+// release gives back the room reserve took for file i without keeping it.
+func (c *checkedFiles[T]) release(i int) {
+	c.mu.Lock()
+	delete(c.checking, i)
+	c.mu.Unlock()
+}
+
+// take returns file i and makes room for another, if it was kept.
+func (c *checkedFiles[T]) take(i int) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file, ok := c.files[i]
+	if ok {
+		delete(c.files, i)
+	} else if _, ok := c.checking[i]; ok {
+		c.checking[i] = true
+	}
+	return file, ok
+}
@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// QueriesTable lists the named queries of sqlfs.yaml in the built database.
+const QueriesTable = "__sqlfs_queries__"
+
+// createNamedQueries records cfg.Queries in QueriesTable and creates a view
+// for each query without parameters. SQLite views cannot take parameters, so
+// parameterized queries are only recorded, for clients to bind and run.
+func createNamedQueries(db *sqlite.DB, cfg *config.Config) error {
+	if len(cfg.Queries) == 0 {
+		return nil
+	}
+	if err := db.Exec(fmt.Sprintf("CREATE TABLE %s (\n  \"name\" TEXT PRIMARY KEY,\n  \"sql\" TEXT NOT NULL,\n  \"parameters\" TEXT NOT NULL\n)",
+		sqliteQuote(QueriesTable))); err != nil {
+		return fmt.Errorf("creating %s: %w", QueriesTable, err)
+	}
+
+	names := make([]string, 0, len(cfg.Queries))
+	for name := range cfg.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		query := cfg.Queries[name]
+		params := queryParameters(query)
+		if len(params) == 0 {
+			if err := db.Exec(fmt.Sprintf("CREATE VIEW %s AS %s", sqliteQuote(name), query)); err != nil {
+				return fmt.Errorf("query %q: %w", name, err)
+			}
+		}
+		paramsJSON, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		if err := db.InsertRecord(QueriesTable, []string{"name", "sql", "parameters"}, []any{name, query, string(paramsJSON)}); err != nil {
+			return fmt.Errorf("query %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// queryParameters returns the named parameters (:name, @name, $name) of
+// query in order of first use, plus "?" for each positional parameter.
+// String literals, quoted identifiers, and comments are skipped.
+func queryParameters(query string) []string {
+	params := []string{}
+	seen := make(map[string]struct{})
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipPast(query, i+1, string(c))
+		case c == '[':
+			i = skipPast(query, i+1, "]")
+		case strings.HasPrefix(query[i:], "--"):
+			i = skipPast(query, i+2, "\n")
+		case strings.HasPrefix(query[i:], "/*"):
+			i = skipPast(query, i+2, "*/")
+		case c == '?':
+			params = append(params, "?")
+		case c == ':' || c == '@' || c == '$':
+			j := i + 1
+			for j < len(query) && isIdentByte(query[j], j == i+1) {
+				j++
+			}
+			if j == i+1 {
+				continue
+			}
+			name := query[i:j]
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				params = append(params, name)
+			}
+			i = j - 1
+		}
+	}
+	return params
+}
+
+// skipPast returns the index of the last byte of the first delim in query at
+// or after from, or the end of query if there is none.
+func skipPast(query string, from int, delim string) int {
+	n := strings.Index(query[from:], delim)
+	if n < 0 {
+		return len(query)
+	}
+	return from + n + len(delim) - 1
+}
+
+func isIdentByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// redactKey returns the secret key that sensitive values are hashed with,
+// read from the environment variable Config.RedactKeyEnvVar names. It is an
+// error for the variable to be unset when a table that is built has a
+// sensitive column and Config.Redact is RedactHash.
+func redactKey(s *dbml.Schema, cfg *config.Config, excluded map[string]struct{}) ([]byte, error) {
+	if cfg.Redact != config.RedactHash {
+		return nil, nil
+	}
+	for _, t := range s.Tables {
+		if _, ok := excluded[t.Name]; ok {
+			continue
+		}
+		for _, col := range t.Columns {
+			if !col.IsSensitive() {
+				continue
+			}
+			key := os.Getenv(cfg.RedactKeyEnvVar)
+			if key == "" {
+				return nil, fmt.Errorf("%s.%s is sensitive, but environment variable %s holding the key to hash its values with is not set; set it (see redact_key) or use redact: drop",
+					t.Name, col.Name, cfg.RedactKeyEnvVar)
+			}
+			return []byte(key), nil
+		}
+	}
+	return nil, nil
+}
+
+// redact replaces the values of the records' sensitive columns (see
+// dbml.Column.IsSensitive) as Config.Redact directs, so that secrets in the
+// source files never reach the output. Values are redacted after validation,
+// which still sees the originals.
+func (x *expander) redact(records []*loader.ExpandedRecord) {
+	if x.schema == nil {
+		return
+	}
+	for _, rec := range records {
+		t := x.schema.TableByName(rec.TableName)
+		if t == nil {
+			continue
+		}
+		for _, col := range t.Columns {
+			if !col.IsSensitive() {
+				continue
+			}
+			v, ok := rec.Fields[col.Name]
+			if !ok || v == nil {
+				continue
+			}
+			if x.cfg.Redact == config.RedactDrop {
+				rec.Fields[col.Name] = nil
+				continue
+			}
+			if col.IsJSON() && x.cfg.JSONText {
+				// Keep json_valid true for the column's CHECK constraint.
+				rec.Fields[col.Name] = jsonText(redactedHash(x.redactKey, v))
+				continue
+			}
+			rec.Fields[col.Name] = redactedHash(x.redactKey, v)
+		}
+	}
+}
+
+// redactedHash returns "hmac-sha256:" and the hex HMAC-SHA256 of v's text
+// keyed by key, which lets equal values still be matched without revealing
+// them. Without the key, guessed values cannot be hashed to be compared.
+func redactedHash(key []byte, v any) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprint(v)))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,26 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// replaceFile renames tmp over path once tmp's contents are on disk, then
+// syncs path's directory so the rename survives a crash too.
+func replaceFile(tmp, path string) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,204 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// Sample selects a subset of the records to build, for quick iterations on
+// large datasets. The zero Sample builds every record.
+type Sample struct {
+	// Percent keeps about this percentage of each table's records, chosen
+	// by a hash of their keys so that the same records are kept from one
+	// build to the next. Zero or 100 keeps them all.
+	Percent float64
+	// PerTable, when positive, keeps at most the first PerTable records of
+	// each table in walk order.
+	PerTable int
+}
+
+// enabled reports whether s leaves out any records.
+func (s Sample) enabled() bool {
+	return (s.Percent > 0 && s.Percent < 100) || s.PerTable > 0
+}
+
+func (s Sample) validate() error {
+	if s.Percent < 0 || s.Percent > 100 {
+		return fmt.Errorf("sample percentage %g is not between 0 and 100", s.Percent)
+	}
+	if s.PerTable < 0 {
+		return fmt.Errorf("per-table limit %d is negative", s.PerTable)
+	}
+	return nil
+}
+
+// sampled reports whether s picks the record with primary key pk, before
+// the per-table limit and references are taken into account.
+func (s Sample) sampled(pk string) bool {
+	if s.Percent <= 0 || s.Percent >= 100 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(pk))
+	return float64(h.Sum64()%10000) < s.Percent*100
+}
+
+// loadedFiles holds files chooseSample has already loaded, by path, so that
+// the build need not parse them again. The nil *loadedFiles holds none.
+type loadedFiles struct {
+	mu  sync.Mutex
+	frs map[string]*loader.FileRecord
+}
+
+// load returns the file at path, loaded earlier or now. A file loaded earlier
+// is handed out once. It is safe for concurrent use.
+func (l *loadedFiles) load(reg *loader.Registry, path, relPath string) (*loader.FileRecord, error) {
+	if l != nil {
+		l.mu.Lock()
+		fr := l.frs[path]
+		delete(l.frs, path)
+		l.mu.Unlock()
+		if fr != nil {
+			return fr, nil
+		}
+	}
+	return reg.LoadFile(path, relPath)
+}
+
+// has reports whether the file at path is held, to be handed out by load.
+func (l *loadedFiles) has(path string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.frs[path] != nil
+}
+
+// chooseSample loads the walked files and returns the primary keys of the
+// records sample keeps, along with the walked files holding any of them.
+// Records referenced by a kept record, through an entity reference or a
+// string equal to their primary key, are kept too, and so on, so that
+// relationships within the sample resolve; that may take a table past
+// sample.PerTable. Files that fail to load are kept, so that the build
+// reports the error. The files with records sample picks itself are returned
+// as loaded, before renames and transformations, for the build to use; only
+// files kept for references are loaded again. Files in loaded are taken from
+// it rather than loaded again.
+func chooseSample(ctx context.Context, walked []walkedFile, reg *loader.Registry, loaded *loadedFiles, jobs int, sample Sample) ([]walkedFile, map[string]struct{}, *loadedFiles, error) {
+	type sampleRecord struct {
+		file int
+		refs []string
+	}
+	records := make(map[string]sampleRecord)
+	var queue []string
+	perTable := make(map[string]int)
+	failed := make(map[int]bool)
+	var order []string // primary keys in walk order
+	earlier := loaded
+	loaded = &loadedFiles{frs: make(map[string]*loader.FileRecord)}
+
+	frs := make([]*loader.FileRecord, len(walked))
+	err := forEachLoaded(ctx, jobs, len(walked), func(i int) error {
+		fr, err := earlier.load(reg, walked[i].path, walked[i].relPath)
+		if err != nil {
+			return nil // kept below, to fail the build
+		}
+		frs[i] = fr
+		return nil
+	}, nil, func(i int) error {
+		fr := frs[i]
+		frs[i] = nil
+		if fr == nil {
+			failed[i] = true
+			return nil
+		}
+		for _, rec := range fr.Records {
+			pk := fr.RecordPK(rec)
+			records[pk] = sampleRecord{file: i, refs: collectRefs(rec.Fields, nil)}
+			order = append(order, pk)
+			if !sample.sampled(pk) {
+				continue
+			}
+			table := walked[i].entityType
+			if sample.PerTable > 0 && perTable[table] >= sample.PerTable {
+				continue
+			}
+			perTable[table]++
+			queue = append(queue, pk)
+			loaded.frs[walked[i].path] = fr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keep := make(map[string]struct{})
+	for len(queue) > 0 {
+		pk := queue[0]
+		queue = queue[1:]
+		if _, ok := keep[pk]; ok {
+			continue
+		}
+		keep[pk] = struct{}{}
+		for _, ref := range records[pk].refs {
+			if _, ok := records[ref]; ok {
+				queue = append(queue, ref)
+			}
+		}
+	}
+
+	files := make(map[int]bool)
+	for _, pk := range order {
+		if _, ok := keep[pk]; ok {
+			files[records[pk].file] = true
+		}
+	}
+	var kept []walkedFile
+	for i, wf := range walked {
+		if files[i] || failed[i] {
+			kept = append(kept, wf)
+		}
+	}
+	return kept, keep, loaded, nil
+}
+
+// collectRefs appends the entity reference paths and strings within v,
+// which may be a primary key of another record, to refs.
+func collectRefs(v any, refs []string) []string {
+	switch v := v.(type) {
+	case loader.EntityRef:
+		refs = append(refs, v.Path)
+	case string:
+		refs = append(refs, v)
+	case []any:
+		for _, e := range v {
+			refs = collectRefs(e, refs)
+		}
+	case map[string]any:
+		for _, e := range v {
+			refs = collectRefs(e, refs)
+		}
+	}
+	return refs
+}
+
+// applySample drops the records of fr that are not in keep; a nil keep
+// keeps them all.
+func applySample(keep map[string]struct{}, fr *loader.FileRecord) {
+	if keep == nil {
+		return
+	}
+	kept := fr.Records[:0]
+	for _, rec := range fr.Records {
+		if _, ok := keep[fr.RecordPK(rec)]; ok {
+			kept = append(kept, rec)
+		}
+	}
+	fr.Records = kept
+}
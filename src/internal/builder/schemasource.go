@@ -0,0 +1,95 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/config"
+)
+
+// schemaStdin is where a schema of config.SchemaStdin is read from.
+var schemaStdin io.Reader = os.Stdin
+
+// Standard input can only be read once, so a schema read from it is kept
+// for every later build of the process, such as serve's rebuilds.
+var (
+	stdinSchemaOnce sync.Once
+	stdinSchema     []byte
+	stdinSchemaErr  error
+)
+
+// schemaFetchTimeout bounds the download of a schema from a URL.
+const schemaFetchTimeout = 30 * time.Second
+
+// maxSchemaSize bounds the size of a schema downloaded from a URL, so that a
+// misbehaving server cannot make the build read without end.
+const maxSchemaSize = 16 << 20
+
+// ReadSchema returns the DBML source of the schema cfg.SchemaFile names for
+// rootDir: the file in rootDir, standard input, or the body of an http(s)
+// URL, which is downloaded again for every build. The error wraps
+// fs.ErrNotExist only when a schema file in rootDir does not exist, in which
+// case the build is a schema-less one.
+func ReadSchema(ctx context.Context, rootDir string, cfg *config.Config) ([]byte, error) {
+	switch {
+	case cfg.SchemaFile == config.SchemaStdin:
+		stdinSchemaOnce.Do(func() {
+			stdinSchema, stdinSchemaErr = io.ReadAll(schemaStdin)
+		})
+		if stdinSchemaErr != nil {
+			return nil, fmt.Errorf("reading schema from standard input: %w", stdinSchemaErr)
+		}
+		return stdinSchema, nil
+	case cfg.SchemaIsURL():
+		return fetchSchema(ctx, cfg.SchemaFile)
+	default:
+		return os.ReadFile(filepath.Join(rootDir, cfg.SchemaFile))
+	}
+}
+
+// SchemaLocation describes where ReadSchema reads cfg's schema from, for
+// messages.
+func SchemaLocation(rootDir string, cfg *config.Config) string {
+	switch {
+	case cfg.SchemaFile == config.SchemaStdin:
+		return "standard input"
+	case cfg.SchemaIsURL():
+		return cfg.SchemaFile
+	default:
+		return filepath.Join(rootDir, cfg.SchemaFile)
+	}
+}
+
+// fetchSchema downloads the schema at url, of up to maxSchemaSize bytes. A
+// missing schema is an error, not a schema-less build, since the URL was
+// given on purpose.
+func fetchSchema(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, schemaFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching schema: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching schema %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching schema %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSchemaSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching schema %s: %w", url, err)
+	}
+	if len(data) > maxSchemaSize {
+		return nil, fmt.Errorf("fetching schema %s: larger than %d MB", url, maxSchemaSize>>20)
+	}
+	return data, nil
+}
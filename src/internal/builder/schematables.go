@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// The schema tables describe the logical schema parsed from DBML, so SQL
+// clients can introspect notes, enum values and relationships without the
+// source files.
+const (
+	TablesTable  = "__sqlfs_tables__"
+	ColumnsTable = "__sqlfs_columns__"
+	RefsTable    = "__sqlfs_refs__"
+	EnumsTable   = "__sqlfs_enums__"
+)
+
+// createSchemaTables records s in the schema tables. Summaries are
+// descriptions in cfg.Locale; the other fields of structured notes (see
+// dbml.NoteFields) have columns of their own, and "summaries" holds a
+// localized summary as a JSON object.
+func createSchemaTables(db *sqlite.DB, s *dbml.Schema, cfg *config.Config) error {
+	var tables, columns, refs, enums [][]any
+	for _, t := range s.Tables {
+		tables = append(tables, append([]any{t.Name, nullIfEmpty(t.Alias), nullIfEmpty(t.Description(cfg.Locale))},
+			noteValues(dbml.ParseNote(t.Note), t.Note)...))
+		for i, col := range t.Columns {
+			columns = append(columns, append([]any{t.Name, col.Name, i + 1, columnType(col), col.PK, col.NotNull, col.Unique,
+				nullIfEmpty(col.Description(cfg.Locale))}, noteValues(col.NoteFields(), col.Note)...))
+			for _, r := range col.Refs {
+				refs = append(refs, []any{nil, t.Name, col.Name, r.To.Table, r.To.Column, r.Relation.String(), nil, nil})
+			}
+		}
+	}
+	for _, r := range s.Refs {
+		refs = append(refs, []any{nullIfEmpty(r.Name), r.From.Table, r.From.Column, r.To.Table, r.To.Column,
+			r.Relation.String(), nullIfEmpty(r.OnDelete), nullIfEmpty(r.OnUpdate)})
+	}
+	for _, e := range s.Enums {
+		for i, v := range e.Values {
+			enums = append(enums, []any{e.Name, v.Name, i + 1, nullIfEmpty(v.Note)})
+		}
+	}
+
+	for _, mt := range []struct {
+		name    string
+		columns string
+		names   []string
+		rows    [][]any
+	}{
+		{TablesTable, `"name" TEXT PRIMARY KEY, "alias" TEXT, "summary" TEXT, ` + noteColumns,
+			[]string{"name", "alias", "summary", "summaries", "owner", "pii", "tags", "note"}, tables},
+		{ColumnsTable, `"table_name" TEXT NOT NULL, "column_name" TEXT NOT NULL, "position" INTEGER NOT NULL, ` +
+			`"type" TEXT NOT NULL, "pk" INTEGER NOT NULL, "not_null" INTEGER NOT NULL, "unique" INTEGER NOT NULL, ` +
+			`"summary" TEXT, ` + noteColumns + `, PRIMARY KEY ("table_name", "column_name")`,
+			[]string{"table_name", "column_name", "position", "type", "pk", "not_null", "unique", "summary", "summaries", "owner", "pii", "tags", "note"}, columns},
+		{RefsTable, `"name" TEXT, "from_table" TEXT NOT NULL, "from_column" TEXT NOT NULL, ` +
+			`"to_table" TEXT NOT NULL, "to_column" TEXT NOT NULL, "relation" TEXT NOT NULL, "on_delete" TEXT, "on_update" TEXT`,
+			[]string{"name", "from_table", "from_column", "to_table", "to_column", "relation", "on_delete", "on_update"}, refs},
+		{EnumsTable, `"enum_name" TEXT NOT NULL, "value" TEXT NOT NULL, "position" INTEGER NOT NULL, "note" TEXT, ` +
+			`PRIMARY KEY ("enum_name", "value")`,
+			[]string{"enum_name", "value", "position", "note"}, enums},
+	} {
+		if err := db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", sqliteQuote(mt.name), mt.columns)); err != nil {
+			return fmt.Errorf("creating %s: %w", mt.name, err)
+		}
+		if err := db.InsertBatch(mt.name, mt.names, mt.rows); err != nil {
+			return fmt.Errorf("writing %s: %w", mt.name, err)
+		}
+	}
+	return nil
+}
+
+// noteColumns are the columns noteValues fills.
+const noteColumns = `"summaries" TEXT, "owner" TEXT, "pii" INTEGER NOT NULL, "tags" TEXT, "note" TEXT`
+
+// noteValues returns the values of noteColumns for a note and its fields,
+// which are nil when the note is plain text.
+func noteValues(f *dbml.NoteFields, note string) []any {
+	var summaries, owner, tags any
+	pii := false
+	if f != nil {
+		if len(f.Summary) > 0 && f.Summary[0].Locale != "" {
+			byLocale := make(map[string]string, len(f.Summary))
+			for _, lt := range f.Summary {
+				byLocale[lt.Locale] = lt.Text
+			}
+			data, _ := json.Marshal(byLocale)
+			summaries = string(data)
+		}
+		if f.Owner != "" {
+			owner = f.Owner
+		}
+		if len(f.Tags) > 0 {
+			data, _ := json.Marshal(f.Tags)
+			tags = string(data)
+		}
+		pii = f.PII
+	}
+	return []any{summaries, owner, pii, tags, nullIfEmpty(note)}
+}
+
+// columnType returns the column's type as written in the schema, e.g.
+// "varchar(255)".
+func columnType(col *dbml.Column) string {
+	if len(col.Type.Args) == 0 {
+		return col.Type.Name
+	}
+	args := make([]string, len(col.Type.Args))
+	for i, a := range col.Type.Args {
+		args[i] = strconv.Itoa(a)
+	}
+	return col.Type.Name + "(" + strings.Join(args, ",") + ")"
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
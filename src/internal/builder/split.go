@@ -0,0 +1,199 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// saveTableOutputs writes a database of each data table of db to
+// opts.TablesDir, named after the table: "countries.db", "countries.sql"
+// with FormatSQL, or "countries.copy" with FormatCopy. Each holds the table
+// with its indexes, BuildInfoTable, and the named query views that only read
+// that table. The names written are recorded in TablesManifest, and outputs
+// an earlier build recorded there, of tables that are gone or in another
+// format, are removed.
+func saveTableOutputs(db *sqlite.DB, opts Options) error {
+	if opts.TablesDir == "" {
+		return nil
+	}
+	tables, err := dataTables(db)
+	if err != nil {
+		return fmt.Errorf("listing tables: %w", err)
+	}
+	data, err := db.Serialize()
+	if err != nil {
+		return fmt.Errorf("copying database: %w", err)
+	}
+	ext := ".db"
+	switch opts.Format {
+	case FormatSQL:
+		ext = ".sql"
+	case FormatCopy:
+		ext = ".copy"
+	}
+	previous, err := readTablesManifest(opts.TablesDir)
+	if err != nil {
+		return err
+	}
+	written := make([]string, 0, len(tables))
+	for _, table := range tables {
+		tableOpts := opts
+		tableOpts.OutputFile = filepath.Join(opts.TablesDir, table+ext)
+		if err := saveTableOutput(data, table, tableOpts); err != nil {
+			return fmt.Errorf("writing table %s: %w", table, err)
+		}
+		written = append(written, table+ext)
+	}
+	if err := writeTablesManifest(opts.TablesDir, written); err != nil {
+		return err
+	}
+	return removeStaleTableOutputs(opts, previous, written)
+}
+
+// TablesManifest is the file of the tables directory that lists, one per
+// line, the outputs the last build wrote there, so that the next build only
+// removes files it knows sqlfs wrote.
+const TablesManifest = ".sqlfs-tables"
+
+// readTablesManifest returns the names listed in dir's TablesManifest, or
+// none when there is no manifest.
+func readTablesManifest(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, TablesManifest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading tables manifest: %w", err)
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// writeTablesManifest replaces dir's TablesManifest with names.
+func writeTablesManifest(dir string, names []string) error {
+	path := filepath.Join(dir, TablesManifest)
+	tmp := path + ".tmp"
+	var data []byte
+	for _, name := range names {
+		data = append(data, name+"\n"...)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing tables manifest: %w", err)
+	}
+	if err := replaceFile(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing tables manifest: %w", err)
+	}
+	return nil
+}
+
+// removeStaleTableOutputs removes the files of opts.TablesDir named in
+// previous, the last build's manifest, but not in written. Names that are not
+// plain file names of the directory, and opts.OutputFile, are never removed.
+func removeStaleTableOutputs(opts Options, previous, written []string) error {
+	keep := make(map[string]bool, len(written))
+	for _, name := range written {
+		keep[name] = true
+	}
+	output, err := filepath.Abs(opts.OutputFile)
+	if err != nil {
+		return err
+	}
+	for _, name := range previous {
+		if keep[name] || name != filepath.Base(name) || name == "." || name == ".." || name == TablesManifest {
+			continue
+		}
+		path := filepath.Join(opts.TablesDir, name)
+		if abs, err := filepath.Abs(path); err != nil || abs == output {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing stale table output: %w", err)
+		}
+	}
+	return nil
+}
+
+// saveTableOutput restores data, drops every table but table and
+// BuildInfoTable, and saves the rest to opts.OutputFile.
+func saveTableOutput(data []byte, table string, opts Options) error {
+	db, err := sqlite.OpenSerialized(data)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	names, err := schemaObjects(db, "table")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == table || name == BuildInfoTable {
+			continue
+		}
+		if err := db.Exec("DROP TABLE " + sqliteQuote(name)); err != nil {
+			return err
+		}
+	}
+	// Views are only checked when used, so find those left broken by
+	// querying each.
+	views, err := schemaObjects(db, "view")
+	if err != nil {
+		return err
+	}
+	for _, view := range views {
+		rows, err := db.Query("SELECT * FROM " + sqliteQuote(view) + " LIMIT 0")
+		if err == nil {
+			rows.Close()
+			continue
+		}
+		if err := db.Exec("DROP VIEW " + sqliteQuote(view)); err != nil {
+			return err
+		}
+	}
+	return saveOutput(db, opts)
+}
+
+// dataTables returns the tables of db other than sqlfs's own "__sqlfs_"
+// metadata tables.
+func dataTables(db *sqlite.DB) ([]string, error) {
+	names, err := schemaObjects(db, "table")
+	if err != nil {
+		return nil, err
+	}
+	tables := names[:0]
+	for _, name := range names {
+		if !strings.HasPrefix(name, "__sqlfs_") {
+			tables = append(tables, name)
+		}
+	}
+	return tables, nil
+}
+
+// schemaObjects returns the names of db's objects of the given type, e.g.
+// "table", leaving out SQLite's internal ones.
+func schemaObjects(db *sqlite.DB, typ string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = ? AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY name`, typ)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
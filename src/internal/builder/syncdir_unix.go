@@ -0,0 +1,18 @@
+//go:build !windows
+
+package builder
+
+import "os"
+
+// syncDir flushes dir's entries, such as a file just renamed into it, to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
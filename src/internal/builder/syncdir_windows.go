@@ -0,0 +1,9 @@
+//go:build windows
+
+package builder
+
+// syncDir does nothing on Windows, where directories cannot be opened for
+// syncing; NTFS journals renames itself.
+func syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// Verify runs quick sanity checks on the SQLite database Build wrote to path,
+// for serve to run before it swaps the database in: SQLite's quick_check
+// passes, and every table that result counts rows for exists and is not
+// empty.
+func Verify(path string, result *Result) error {
+	db, err := sqlite.OpenReadOnly(path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.DB().QueryRow("PRAGMA quick_check").Scan(&check); err != nil {
+		return fmt.Errorf("quick_check: %w", err)
+	}
+	if check != "ok" {
+		return fmt.Errorf("quick_check: %s", check)
+	}
+
+	tables := make([]string, 0, len(result.TableRows))
+	for t, n := range result.TableRows {
+		if n > 0 {
+			tables = append(tables, t)
+		}
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		var n int
+		if err := db.DB().QueryRow("SELECT count(*) FROM " + sqliteQuote(t)).Scan(&n); err != nil {
+			return fmt.Errorf("table %s: %w", t, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("table %s is empty, but %d rows were built for it", t, result.TableRows[t])
+		}
+	}
+	return nil
+}
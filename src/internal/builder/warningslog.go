@@ -0,0 +1,54 @@
+package builder
+
+import (
+	"log"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/validator"
+)
+
+// WarningLine is a line a build appends to Options.WarningsLog, so that the
+// quality of a dataset can be monitored as it is edited.
+type WarningLine struct {
+	// Root is the root directory built, as it was given.
+	Root string `json:"root"`
+	// Time is when the build started, in UTC. It is the same for every
+	// warning of a build, telling the builds in the log apart.
+	Time    time.Time `json:"time"`
+	File    string    `json:"file"`
+	Record  string    `json:"record"`
+	Field   string    `json:"field"`
+	Message string    `json:"message"`
+	// Failed is set for the validation error that failed a build with
+	// invalid: fail.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// newWarningLines returns the lines of the warnings of the build of rootDir
+// started at start.
+func newWarningLines(rootDir string, start time.Time, warnings []validator.ValidationError) []WarningLine {
+	lines := make([]WarningLine, len(warnings))
+	for i, w := range warnings {
+		lines[i] = WarningLine{
+			Root:    rootDir,
+			Time:    start.UTC(),
+			File:    w.FilePath,
+			Record:  w.RecordKey,
+			Field:   w.Field,
+			Message: w.Message,
+		}
+	}
+	return lines
+}
+
+// logWarnings appends lines to the warnings log at path. The log records
+// builds rather than taking part in them, so failing to write it is logged
+// as a warning instead of failing the build.
+func logWarnings(path string, lines []WarningLine) {
+	if len(lines) == 0 {
+		return
+	}
+	if err := appendJSONLines(path, lines...); err != nil {
+		log.Printf("warning: writing warnings log: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+// Package changes computes row-level differences between two builds of the
+// same dataset so that consumers of serve can react to what changed.
+package changes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// TableName is the table written into each served database listing the
+// changes made by the build that produced it.
+const TableName = "__sqlfs_changes__"
+
+// Channel is the LISTEN channel on which change events are published.
+const Channel = "sqlfs_changes"
+
+// Operation kinds.
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Change is one row-level difference between two builds.
+type Change struct {
+	Table string `json:"table"`
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+}
+
+// Options controls how rows are matched and compared.
+type Options struct {
+	// KeyColumns are tried in order; the first one present in a table
+	// identifies its rows. Tables with none of them are not diffed.
+	KeyColumns []string
+	// IgnoreColumns are excluded when deciding whether a row was updated
+	// (e.g. the per-build ULID).
+	IgnoreColumns []string
+}
+
+// Diff compares the databases at oldPath and newPath and returns the changes,
+// sorted by table and key.
+func Diff(oldPath, newPath string, opts Options) ([]Change, error) {
+	oldDB, err := sqlite.OpenReadOnly(oldPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening previous database: %w", err)
+	}
+	defer oldDB.Close()
+	newDB, err := sqlite.OpenReadOnly(newPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening new database: %w", err)
+	}
+	defer newDB.Close()
+
+	oldTables, err := listTables(oldDB.DB())
+	if err != nil {
+		return nil, err
+	}
+	newTables, err := listTables(newDB.DB())
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string]struct{}, len(newTables))
+	for _, t := range oldTables {
+		tables[t] = struct{}{}
+	}
+	for _, t := range newTables {
+		tables[t] = struct{}{}
+	}
+	names := make([]string, 0, len(tables))
+	for t := range tables {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	ignore := make(map[string]struct{}, len(opts.IgnoreColumns))
+	for _, c := range opts.IgnoreColumns {
+		ignore[c] = struct{}{}
+	}
+
+	var out []Change
+	for _, table := range names {
+		oldRows, err := rowDigests(oldDB.DB(), table, opts.KeyColumns, ignore)
+		if err != nil {
+			return nil, fmt.Errorf("reading previous %q: %w", table, err)
+		}
+		newRows, err := rowDigests(newDB.DB(), table, opts.KeyColumns, ignore)
+		if err != nil {
+			return nil, fmt.Errorf("reading new %q: %w", table, err)
+		}
+		if oldRows == nil && newRows == nil {
+			continue
+		}
+
+		var tableChanges []Change
+		for key, digest := range newRows {
+			prev, existed := oldRows[key]
+			switch {
+			case !existed:
+				tableChanges = append(tableChanges, Change{Table: table, Op: OpInsert, Key: key})
+			case prev != digest:
+				tableChanges = append(tableChanges, Change{Table: table, Op: OpUpdate, Key: key})
+			}
+		}
+		for key := range oldRows {
+			if _, ok := newRows[key]; !ok {
+				tableChanges = append(tableChanges, Change{Table: table, Op: OpDelete, Key: key})
+			}
+		}
+		sort.Slice(tableChanges, func(i, j int) bool { return tableChanges[i].Key < tableChanges[j].Key })
+		out = append(out, tableChanges...)
+	}
+	return out, nil
+}
+
+// Record (re)creates TableName in the database at dbPath and fills it with
+// changes, all stamped with at.
+func Record(dbPath string, changes []Change, at time.Time) error {
+	db, err := sqlite.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	quoted := `"` + TableName + `"`
+	if err := db.ExecDDL([]string{
+		"DROP TABLE IF EXISTS " + quoted,
+		"CREATE TABLE " + quoted + " (\n  \"table_name\" TEXT,\n  \"op\" TEXT,\n  \"key\" TEXT,\n  \"changed_at\" TEXT\n)",
+	}); err != nil {
+		return err
+	}
+	stamp := at.UTC().Format(time.RFC3339)
+	rows := make([][]any, len(changes))
+	for i, c := range changes {
+		rows[i] = []any{c.Table, c.Op, c.Key, stamp}
+	}
+	return db.InsertBatch(TableName, []string{"table_name", "op", "key", "changed_at"}, rows)
+}
+
+// Event is the JSON document published for one rebuild, both as the NOTIFY
+// payload and as the webhook body.
+type Event struct {
+	Database  string    `json:"database,omitempty"`
+	BuiltAt   time.Time `json:"built_at"`
+	Inserts   int       `json:"inserts"`
+	Updates   int       `json:"updates"`
+	Deletes   int       `json:"deletes"`
+	Changes   []Change  `json:"changes,omitempty"`
+	Truncated bool      `json:"truncated,omitempty"` // Changes omitted to fit the payload limit
+}
+
+// NewEvent summarises changes for database.
+func NewEvent(database string, changes []Change, at time.Time) Event {
+	ev := Event{Database: database, BuiltAt: at.UTC(), Changes: changes}
+	for _, c := range changes {
+		switch c.Op {
+		case OpInsert:
+			ev.Inserts++
+		case OpUpdate:
+			ev.Updates++
+		case OpDelete:
+			ev.Deletes++
+		}
+	}
+	return ev
+}
+
+// maxNotifyPayload mirrors PostgreSQL's limit on NOTIFY payloads.
+const maxNotifyPayload = 7999
+
+// NotifyPayload encodes ev for NOTIFY, dropping the change list when the
+// full document would exceed PostgreSQL's payload limit.
+func (ev Event) NotifyPayload() string {
+	data, _ := json.Marshal(ev)
+	if len(data) <= maxNotifyPayload {
+		return string(data)
+	}
+	ev.Changes = nil
+	ev.Truncated = true
+	data, _ = json.Marshal(ev)
+	return string(data)
+}
+
+// PostWebhook sends ev as a JSON POST to url.
+func PostWebhook(ctx context.Context, url string, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func listTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name != TableName {
+			names = append(names, name)
+		}
+	}
+	return names, rows.Err()
+}
+
+// rowDigests returns key → content digest for every row of table, or nil if
+// the table does not exist or has no usable key column.
+func rowDigests(db *sql.DB, table string, keyColumns []string, ignore map[string]struct{}) (map[string]string, error) {
+	cols, err := tableColumns(db, table)
+	if err != nil || len(cols) == 0 {
+		return nil, err
+	}
+	keyIdx := -1
+	for _, kc := range keyColumns {
+		for i, c := range cols {
+			if c == kc {
+				keyIdx = i
+				break
+			}
+		}
+		if keyIdx >= 0 {
+			break
+		}
+	}
+	if keyIdx < 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s", quoteName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	out := make(map[string]string)
+	var sb strings.Builder
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		sb.Reset()
+		for i, v := range vals {
+			if _, skip := ignore[cols[i]]; skip {
+				continue
+			}
+			fmt.Fprintf(&sb, "%s=%T:%v\x00", cols[i], v, v)
+		}
+		sum := sha256.Sum256([]byte(sb.String()))
+		out[fmt.Sprintf("%v", vals[keyIdx])] = string(sum[:])
+	}
+	return out, rows.Err()
+}
+
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt any
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
+
+func quoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,118 @@
+package changes
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+func makeDB(t *testing.T, rows [][3]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db.db")
+	db, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ExecDDL([]string{`CREATE TABLE users (name TEXT, __pk__ TEXT, __ulid__ TEXT)`}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := db.InsertRecord("users", []string{"__pk__", "name", "__ulid__"}, []any{r[0], r[1], r[2]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestDiff(t *testing.T) {
+	oldPath := makeDB(t, [][3]string{
+		{"alice", "Alice", "u1"},
+		{"bob", "Bob", "u2"},
+		{"carol", "Carol", "u3"},
+	})
+	newPath := makeDB(t, [][3]string{
+		{"alice", "Alice", "u9"}, // only the ignored ULID changed
+		{"bob", "Robert", "u8"},
+		{"dave", "Dave", "u7"},
+	})
+
+	got, err := Diff(oldPath, newPath, Options{
+		KeyColumns:    []string{"__pk__"},
+		IgnoreColumns: []string{"__ulid__"},
+	})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := []Change{
+		{Table: "users", Op: OpUpdate, Key: "bob"},
+		{Table: "users", Op: OpDelete, Key: "carol"},
+		{Table: "users", Op: OpInsert, Key: "dave"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d changes %v, want %v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	path := makeDB(t, nil)
+	if err := Record(path, []Change{{Table: "users", Op: OpInsert, Key: "alice"}}, time.Now()); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	// Recording again replaces the previous contents.
+	if err := Record(path, []Change{{Table: "users", Op: OpDelete, Key: "bob"}}, time.Now()); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	db, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT op, key FROM "__sqlfs_changes__"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var op, key string
+		rows.Scan(&op, &key)
+		got = append(got, op+":"+key)
+	}
+	if strings.Join(got, ",") != "delete:bob" {
+		t.Errorf("changes table = %v, want [delete:bob]", got)
+	}
+}
+
+func TestEvent_NotifyPayloadTruncates(t *testing.T) {
+	var many []Change
+	for i := 0; i < 500; i++ {
+		many = append(many, Change{Table: "users", Op: OpInsert, Key: strings.Repeat("k", 20)})
+	}
+	ev := NewEvent("db", many, time.Now())
+	if ev.Inserts != 500 {
+		t.Errorf("Inserts = %d, want 500", ev.Inserts)
+	}
+
+	payload := ev.NotifyPayload()
+	if len(payload) > maxNotifyPayload {
+		t.Fatalf("payload length %d exceeds limit", len(payload))
+	}
+	var decoded Event
+	if err := json.Unmarshal([]byte(payload), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Truncated || decoded.Changes != nil || decoded.Inserts != 500 {
+		t.Errorf("decoded = %+v, want truncated summary", decoded)
+	}
+}
@@ -1,10 +1,23 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/notwillk/sqlfs/internal/transform"
 )
 
 // InvalidBehavior controls how schema validation failures are handled.
@@ -16,53 +29,429 @@ const (
 	InvalidFail   InvalidBehavior = "fail"
 )
 
-// StandardColumns holds the column names for the six injected standard columns.
+// TombstoneBehavior controls what happens to entities marked as deleted.
+type TombstoneBehavior string
+
+const (
+	TombstoneSkip TombstoneBehavior = "skip" // omit deleted entities from the build
+	TombstoneKeep TombstoneBehavior = "keep" // keep them, with the deleted_at column set
+)
+
+// Tombstone markers. An entity is deleted when its file sets TombstoneField to
+// true, or when a sibling file named <file>+TombstoneSuffix exists
+// (e.g. "alice.users.yaml.deleted").
+const (
+	TombstoneField  = "__deleted__"
+	TombstoneSuffix = ".deleted"
+)
+
+// TimestampFormat controls how the standard timestamp columns are stored.
+type TimestampFormat string
+
+const (
+	TimestampRFC3339 TimestampFormat = "rfc3339" // TEXT, e.g. "2024-01-02T15:04:05Z"
+	TimestampUnix    TimestampFormat = "unix"    // INTEGER seconds since the Unix epoch
+)
+
+// ModTimeSource selects where the modified-at standard column comes from.
+type ModTimeSource string
+
+const (
+	ModTimeFilesystem ModTimeSource = "filesystem" // the file's modification time
+	ModTimeGit        ModTimeSource = "git"        // the last commit touching the file
+)
+
+// UUIDVersion selects the kind of UUID generated for omitted uuid primary keys.
+type UUIDVersion string
+
+const (
+	UUIDv4 UUIDVersion = "v4" // random
+	UUIDv7 UUIDVersion = "v7" // time-ordered by the file's creation time, like __ulid__
+)
+
+// IDStrategy selects how the ULID standard column's values are generated.
+type IDStrategy string
+
+const (
+	IDULID      IDStrategy = "ulid"      // ULID timestamped with the file's creation time
+	IDUUIDv4    IDStrategy = "uuidv4"    // random UUID
+	IDUUIDv7    IDStrategy = "uuidv7"    // UUID timestamped with the file's creation time
+	IDSnowflake IDStrategy = "snowflake" // 64-bit integer, ordered by generation time
+	IDPathHash  IDStrategy = "path-hash" // hash of the row's path, the same in every build
+)
+
+// TableSource selects how a data file's table is derived when no Tables
+// pattern matches it.
+type TableSource string
+
+const (
+	TableFromFileName  TableSource = "filename"  // the name.table.ext file name convention
+	TableFromDirectory TableSource = "directory" // the name of the file's parent directory
+	TableFromFields    TableSource = "fields"    // the schema table whose columns best match the file's fields
+)
+
+// RedactMode controls what the builder stores for columns noted as sensitive.
+type RedactMode string
+
+const (
+	RedactHash RedactMode = "hash" // "hmac-sha256:" + the hex HMAC-SHA256 of the value
+	RedactDrop RedactMode = "drop" // NULL
+)
+
+// AuthMethod is how clients of serve send their password, named as in
+// PostgreSQL's pg_hba.conf.
+type AuthMethod string
+
+const (
+	AuthPassword AuthMethod = "password"      // cleartext
+	AuthMD5      AuthMethod = "md5"           // salted MD5 hash
+	AuthSCRAM    AuthMethod = "scram-sha-256" // SCRAM-SHA-256 challenge-response
+)
+
+// StandardColumns holds the column names for the six injected standard columns,
+// plus the deleted_at column that is only added when tombstones are kept,
+// the record_checksum column that is only added when record checksums are on
+// and the source_line column that is only added when source lines are on.
 type StandardColumns struct {
-	PK         string `yaml:"pk"`
-	Path       string `yaml:"path"`
-	CreatedAt  string `yaml:"created_at"`
-	ModifiedAt string `yaml:"modified_at"`
-	Checksum   string `yaml:"checksum"`
-	ULID       string `yaml:"ulid"`
+	PK             string `yaml:"pk"`
+	Path           string `yaml:"path"`
+	CreatedAt      string `yaml:"created_at"`
+	ModifiedAt     string `yaml:"modified_at"`
+	Checksum       string `yaml:"checksum"`
+	ULID           string `yaml:"ulid"`
+	DeletedAt      string `yaml:"deleted_at"`
+	RecordChecksum string `yaml:"record_checksum"`
+	SourceLine     string `yaml:"source_line"`
+}
+
+// PrefixedColumns returns the standard column names made of prefix followed
+// by each column's key under columns in sqlfs.yaml, e.g. "_sqlfs_path" for
+// the prefix "_sqlfs_".
+func PrefixedColumns(prefix string) StandardColumns {
+	return StandardColumns{
+		PK:             prefix + "pk",
+		Path:           prefix + "path",
+		CreatedAt:      prefix + "created_at",
+		ModifiedAt:     prefix + "modified_at",
+		Checksum:       prefix + "checksum",
+		ULID:           prefix + "ulid",
+		DeletedAt:      prefix + "deleted_at",
+		RecordChecksum: prefix + "record_checksum",
+		SourceLine:     prefix + "source_line",
+	}
+}
+
+// ChildMapping directs a record array nested in a parent file into a child
+// table. Empty fields take the defaults described on Config.ChildTable.
+type ChildMapping struct {
+	Table        string `yaml:"table"`
+	ParentColumn string `yaml:"parent_column"`
+}
+
+// TableMapping assigns the data files matching a glob pattern to a table.
+type TableMapping struct {
+	// Pattern is a slash-separated path relative to the root. "*" and "?"
+	// match within a path segment; "**" also matches across segments.
+	Pattern string
+	Table   string
+}
+
+// ExternalLoader reads the data files of an extension with a command, for
+// formats sqlfs has no loader for.
+type ExternalLoader struct {
+	// Extension is the lower-cased extension, with its dot, e.g. ".csv".
+	Extension string
+	// Command is the program to run and its arguments.
+	Command []string
+	// Dir is the absolute root directory the command runs in.
+	Dir string
+}
+
+// User is a serve login with its own password and row filters.
+type User struct {
+	// Password names the environment variable holding the user's password.
+	Password string `yaml:"password"`
+	// RowFilters maps table names to SQL conditions limiting the rows the
+	// user's queries see, e.g. "tenant = 'a'".
+	RowFilters map[string]string `yaml:"row_filters"`
+}
+
+// AccessLog configures the log of queries answered by serve.
+type AccessLog struct {
+	// Path is the log file; "-" writes to standard output. Empty disables
+	// the access log.
+	Path string `yaml:"path"`
+	// Format is "combined" or "json".
+	Format string `yaml:"format"`
+	// MaxSizeMB is the size at which the file is rotated.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int `yaml:"max_backups"`
 }
 
 // fileConfig is the raw YAML structure from sqlfs.yaml.
 type fileConfig struct {
-	Schema      string          `yaml:"schema"`
-	Invalid     string          `yaml:"invalid"`
-	Port        int             `yaml:"port"`
-	Credentials struct {
+	Schema          string   `yaml:"schema"`
+	MinVersion      string   `yaml:"min_sqlfs_version"`
+	Invalid         string   `yaml:"invalid"`
+	Tombstones      string   `yaml:"tombstones"`
+	EnumTables      bool     `yaml:"enum_tables"`
+	JSONText        bool     `yaml:"json_text"`
+	RecordChecksums bool     `yaml:"record_checksums"`
+	SourceLines     bool     `yaml:"source_lines"`
+	ForbidStandard  bool     `yaml:"forbid_standard_columns"`
+	ForeignKeys     bool     `yaml:"foreign_keys"`
+	PathTemplate    string   `yaml:"path_template"`
+	MarkdownBody    string   `yaml:"markdown_body"`
+	MarkdownAssets  bool     `yaml:"markdown_assets"`
+	SchemaTables    bool     `yaml:"schema_tables"`
+	KeyField        string   `yaml:"key_field"`
+	ColumnPrefix    string   `yaml:"column_prefix"`
+	GenerateUUIDs   string   `yaml:"generate_uuids"`
+	IDStrategy      string   `yaml:"id_strategy"`
+	Deterministic   bool     `yaml:"deterministic"`
+	Redact          string   `yaml:"redact"`
+	RedactKey       string   `yaml:"redact_key"`
+	TableFrom       string   `yaml:"table_from"`
+	MinMatchRatio   float64  `yaml:"min_match_ratio"`
+	Locale          string   `yaml:"locale"`
+	ExcludeTables   []string `yaml:"exclude_tables"`
+	AtomicDirs      []string `yaml:"atomic_dirs"`
+	Ignore          []string `yaml:"ignore"`
+	InterpolateEnv  []string `yaml:"interpolate_env"`
+	AllowedQueries  []string `yaml:"allowed_queries"`
+	QueryCacheSize  int      `yaml:"query_cache_size"`
+	QueryCacheBytes int64    `yaml:"query_cache_bytes"`
+	Notice          string   `yaml:"notice"`
+	MaxResultRows   int      `yaml:"max_result_rows"`
+	MaxResultBytes  int64    `yaml:"max_result_bytes"`
+	Port            int      `yaml:"port"`
+	Listen          []string `yaml:"listen"`
+	HTTPPort        int      `yaml:"http_port"`
+	Webhook         string   `yaml:"webhook"`
+	BuildTimeout    string   `yaml:"build_timeout"`
+	Incremental     bool     `yaml:"incremental"`
+	RemoteCache     string   `yaml:"remote_cache"`
+	Credentials     struct {
 		Username string `yaml:"username"`
 		Password string `yaml:"password"`
+		Method   string `yaml:"method"`
 	} `yaml:"credentials"`
-	Columns StandardColumns `yaml:"columns"`
+	Encryption struct {
+		Key string `yaml:"key"`
+	} `yaml:"encryption"`
+	Snapshots struct {
+		Keep int `yaml:"keep"`
+	} `yaml:"snapshots"`
+	Timestamps struct {
+		Zone       string `yaml:"zone"`
+		Format     string `yaml:"format"`
+		ModifiedAt string `yaml:"modified_at"`
+	} `yaml:"timestamps"`
+	Columns    StandardColumns                    `yaml:"columns"`
+	Children   map[string]map[string]ChildMapping `yaml:"children"`
+	Renames    map[string]map[string]string       `yaml:"renames"`
+	Transforms map[string]string                  `yaml:"transforms"`
+	Collations map[string]map[string]string       `yaml:"collations"`
+	Tables     yaml.Node                          `yaml:"tables"` // pattern → table, in order
+	Loaders    map[string][]string                `yaml:"loaders"`
+	Queries    map[string]string                  `yaml:"queries"`
+	Users      map[string]User                    `yaml:"users"`
+	AccessLog  AccessLog                          `yaml:"access_log"`
 }
 
 // Config is the fully merged, resolved configuration.
 type Config struct {
-	SchemaFile      string
-	Invalid         InvalidBehavior
-	Port            int
-	UsernameEnvVar  string
-	PasswordEnvVar  string
+	// SchemaFile is where the DBML schema is read from: a path relative to
+	// the root directory, SchemaStdin, or an http(s) URL. See SchemaIsFile.
+	SchemaFile string
+	// MinVersion is the oldest sqlfs release allowed to build the project.
+	// Empty means any version.
+	MinVersion string
+	Invalid    InvalidBehavior
+	Tombstones TombstoneBehavior
+	// EnumTables materializes each DBML enum as a lookup table referenced by
+	// the columns that use it.
+	EnumTables bool
+	// JSONText stores every value of json and jsonb columns, scalars
+	// included, as JSON text, and checks them with json_valid.
+	JSONText bool
+	// RecordChecksums adds the record_checksum standard column: a checksum
+	// of each row's own fields, unlike the file-level checksum.
+	RecordChecksums bool
+	// SourceLines adds the source_line standard column: the line of the
+	// source file where each row's record or array element starts.
+	SourceLines bool
+	// ForbidStandardColumns makes a record that sets a standard column, such
+	// as __path__, invalid instead of letting its value through.
+	ForbidStandardColumns bool
+	// ForeignKeys emits a FOREIGN KEY constraint for each DBML relationship
+	// and has the build check that every reference resolves.
+	ForeignKeys bool
+	// PathTemplate formats the path standard column; see FormatPath.
+	PathTemplate string
+	// MarkdownBody is the field that holds the body of Markdown files.
+	MarkdownBody string
+	// MarkdownAssets records the local files that Markdown bodies link to
+	// in the assets table.
+	MarkdownAssets bool
+	// SchemaTables describes the DBML schema in the schema tables of the
+	// database it builds.
+	SchemaTables bool
+	// KeyField is the field whose value keys each record of a file holding
+	// several. Empty means its id field, or else its key field.
+	KeyField string
+	// GenerateUUIDs fills omitted uuid primary keys with UUIDs of this
+	// version. Empty disables generation.
+	GenerateUUIDs UUIDVersion
+	// IDStrategy generates the values of the ULID standard column.
+	IDStrategy IDStrategy
+	// Deterministic derives the random parts of generated ULIDs and UUIDs
+	// from each record's table, key, path and checksum, so that builds of
+	// the same files are byte-identical. IDSnowflake cannot be used with it.
+	Deterministic bool
+	// ExcludeTables lists tables that are never built: no table is created
+	// for them and their files are skipped. See IsExcludedTable.
+	ExcludeTables []string
+	// AtomicDirs are glob patterns of directories whose files are only
+	// loaded when all of them are valid. See AtomicDir.
+	AtomicDirs []string
+	// Ignore lists glob patterns of the files and directories that builds
+	// and serve's watcher skip: DefaultIgnore followed by the ignore setting.
+	// See IsIgnored.
+	Ignore []string
+	// Redact is what replaces the values of columns whose DBML note marks
+	// them sensitive.
+	Redact RedactMode
+	// RedactKeyEnvVar names the environment variable holding the secret key
+	// that RedactHash hashes values with.
+	RedactKeyEnvVar string
+	// InterpolateEnv lists the environment variables whose ${NAME}
+	// references are replaced in data file string values. Empty disables
+	// interpolation.
+	InterpolateEnv []string
+	// AllowedQueries are regular expressions; when set, serve only runs
+	// queries matching one of them in full. See QueryAllowlist.
+	AllowedQueries []string
+	// QueryCacheSize is the number of query results serve caches between
+	// rebuilds. Zero disables the cache.
+	QueryCacheSize int
+	// QueryCacheBytes caps the bytes of values those cached results hold
+	// together.
+	QueryCacheBytes int64
+	// MaxResultRows and MaxResultBytes cap the size of one query's result
+	// during serve. Zero means unlimited.
+	MaxResultRows  int
+	MaxResultBytes int64
+	Port           int
+	// Listen lists further addresses serve listens on besides Port:
+	// "host:port" for TCP or "unix:<path>" for a Unix socket.
+	Listen []string
+	// Notice is the message serve sends to clients when they connect, with
+	// placeholders such as {built_at}; see pgserver.Options.Notice. Empty
+	// sends none.
+	Notice string
+	// HTTPPort is the port of serve's HTTP JSON API. Zero disables it.
+	HTTPPort       int
+	UsernameEnvVar string
+	PasswordEnvVar string
+	// AuthMethod is how serve asks clients for the password.
+	AuthMethod AuthMethod
+	// WebhookURL receives a JSON POST describing the changes of every
+	// rebuild during serve. Empty disables the webhook.
+	WebhookURL string
+	// EncryptionKeyEnvVar names the environment variable holding the output
+	// encryption key. Empty disables encryption.
+	EncryptionKeyEnvVar string
+	// KeepSnapshots is the number of previous build outputs to retain.
+	// Zero disables snapshots.
+	KeepSnapshots int
+	// BuildTimeout bounds the wall-clock time of one build. Zero means no
+	// limit.
+	BuildTimeout time.Duration
+	// Incremental has serve rebuild by patching the previous build's
+	// database with the files that changed; see builder.Cache.
+	Incremental bool
+	// RemoteCache is where build saves its incremental cache and restores
+	// it from, keyed by the schema; see remotecache.Open. Empty disables it.
+	RemoteCache string
+	// TimestampLocation is the zone the standard timestamp columns are
+	// converted to before formatting.
+	TimestampLocation *time.Location
+	TimestampFormat   TimestampFormat
+	// ModifiedAt is where the modified-at column's times come from.
+	ModifiedAt      ModTimeSource
 	StandardColumns StandardColumns
+	// Children maps parent table → array field → child table mapping.
+	Children map[string]map[string]ChildMapping
+	// Renames maps table → old field name → new column name. The builder
+	// renames fields before validation so data files can lag a schema change.
+	Renames map[string]map[string]string
+	// Transforms maps table → the jq program the builder runs on each of
+	// its records after renames and before validation.
+	Transforms map[string]*transform.Program
+	// Tables assigns files to tables by path, ahead of the name.table.ext
+	// file name convention; the first matching pattern wins. See TableFor.
+	Tables []TableMapping
+	// Loaders lists the external loaders, sorted by extension. Each
+	// replaces any built-in loader of its extension.
+	Loaders []ExternalLoader
+	// TableFrom derives the table of files no Tables pattern matches: from
+	// the file name (the default), from the parent directory's name, or,
+	// for files whose name gives none, from the schema table their fields
+	// match best.
+	TableFrom TableSource
+	// MinMatchRatio is how well a file's fields must match a table's
+	// columns, from 0 to 1, for TableFrom fields to assign it to the table.
+	MinMatchRatio float64
+	// Locale picks the summary of structured DBML notes written in several
+	// locales, e.g. "fr"; empty means the first one written.
+	Locale string
+	// Collations maps table → column → the collation its values are
+	// compared with, overriding the DBML [collate] setting.
+	Collations map[string]map[string]string
+	// Queries maps names to SQL queries that the build stores with the
+	// database, as views when they take no parameters.
+	Queries map[string]string
+	// Users maps serve user names to their passwords and row filters.
+	Users map[string]User
+	// AccessLog configures serve's log of queries.
+	AccessLog AccessLog
 }
 
 // Default returns a Config populated entirely with default values.
 func Default() *Config {
 	return &Config{
-		SchemaFile: "schema.dbml",
-		Invalid:    InvalidFail,
-		Port:       5432,
-		UsernameEnvVar: "SQLFS_USERNAME",
-		PasswordEnvVar: "SQLFS_PASSWORD",
+		SchemaFile:        "schema.dbml",
+		Invalid:           InvalidFail,
+		Tombstones:        TombstoneSkip,
+		PathTemplate:      "{path}#{key}",
+		MarkdownBody:      "body",
+		Port:              5432,
+		UsernameEnvVar:    "SQLFS_USERNAME",
+		PasswordEnvVar:    "SQLFS_PASSWORD",
+		AuthMethod:        AuthSCRAM,
+		TimestampLocation: time.UTC,
+		TimestampFormat:   TimestampRFC3339,
+		ModifiedAt:        ModTimeFilesystem,
+		Ignore:            DefaultIgnore,
+		Redact:            RedactHash,
+		RedactKeyEnvVar:   "SQLFS_REDACT_KEY",
+		TableFrom:         TableFromFileName,
+		MinMatchRatio:     0.5,
+		IDStrategy:        IDULID,
+		AccessLog:         AccessLog{Format: "combined", MaxSizeMB: 100, MaxBackups: 3},
+		QueryCacheBytes:   64 << 20,
 		StandardColumns: StandardColumns{
-			PK:         "__pk__",
-			Path:       "__path__",
-			CreatedAt:  "__created_at__",
-			ModifiedAt: "__modified_at__",
-			Checksum:   "__checksum__",
-			ULID:       "__ulid__",
+			PK:             "__pk__",
+			Path:           "__path__",
+			CreatedAt:      "__created_at__",
+			ModifiedAt:     "__modified_at__",
+			Checksum:       "__checksum__",
+			ULID:           "__ulid__",
+			DeletedAt:      "__deleted_at__",
+			RecordChecksum: "__record_checksum__",
+			SourceLine:     "__source_line__",
 		},
 	}
 }
@@ -81,26 +470,222 @@ func Load(rootDir string) (*Config, error) {
 		return nil, err
 	}
 
+	// Unknown keys are errors, so that misspelt settings do not silently
+	// keep their defaults.
 	var fc fileConfig
-	if err := yaml.Unmarshal(data, &fc); err != nil {
-		return nil, err
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && err != io.EOF {
+		return nil, decodeError(err)
 	}
 
 	if fc.Schema != "" {
 		cfg.SchemaFile = fc.Schema
 	}
-	if fc.Invalid != "" {
+	cfg.MinVersion = fc.MinVersion
+	switch InvalidBehavior(fc.Invalid) {
+	case "":
+	case InvalidSilent, InvalidWarn, InvalidFail:
 		cfg.Invalid = InvalidBehavior(fc.Invalid)
+	default:
+		return nil, fmt.Errorf("invalid must be silent, warn or fail, got %q", fc.Invalid)
+	}
+	switch TombstoneBehavior(fc.Tombstones) {
+	case "":
+	case TombstoneSkip, TombstoneKeep:
+		cfg.Tombstones = TombstoneBehavior(fc.Tombstones)
+	default:
+		return nil, fmt.Errorf("tombstones must be skip or keep, got %q", fc.Tombstones)
+	}
+	cfg.EnumTables = fc.EnumTables
+	cfg.JSONText = fc.JSONText
+	cfg.MarkdownAssets = fc.MarkdownAssets
+	cfg.SchemaTables = fc.SchemaTables
+	cfg.RecordChecksums = fc.RecordChecksums
+	cfg.SourceLines = fc.SourceLines
+	cfg.ForbidStandardColumns = fc.ForbidStandard
+	cfg.ForeignKeys = fc.ForeignKeys
+	cfg.Incremental = fc.Incremental
+	cfg.RemoteCache = fc.RemoteCache
+	switch UUIDVersion(fc.GenerateUUIDs) {
+	case "", UUIDv4, UUIDv7:
+		cfg.GenerateUUIDs = UUIDVersion(fc.GenerateUUIDs)
+	default:
+		return nil, fmt.Errorf("generate_uuids must be v4 or v7, got %q", fc.GenerateUUIDs)
+	}
+	switch IDStrategy(fc.IDStrategy) {
+	case "":
+	case IDULID, IDUUIDv4, IDUUIDv7, IDSnowflake, IDPathHash:
+		cfg.IDStrategy = IDStrategy(fc.IDStrategy)
+	default:
+		return nil, fmt.Errorf("id_strategy must be ulid, uuidv4, uuidv7, snowflake or path-hash, got %q", fc.IDStrategy)
+	}
+	if fc.Deterministic && cfg.IDStrategy == IDSnowflake {
+		return nil, fmt.Errorf("deterministic cannot be used with id_strategy %s", IDSnowflake)
+	}
+	cfg.Deterministic = fc.Deterministic
+	cfg.ExcludeTables = fc.ExcludeTables
+	cfg.AtomicDirs = fc.AtomicDirs
+	cfg.Ignore = append(slices.Clip(DefaultIgnore), fc.Ignore...)
+	switch RedactMode(fc.Redact) {
+	case "":
+	case RedactHash, RedactDrop:
+		cfg.Redact = RedactMode(fc.Redact)
+	default:
+		return nil, fmt.Errorf("redact must be hash or drop, got %q", fc.Redact)
+	}
+	if fc.RedactKey != "" {
+		cfg.RedactKeyEnvVar = fc.RedactKey
+	}
+	switch TableSource(fc.TableFrom) {
+	case "":
+	case TableFromFileName, TableFromDirectory, TableFromFields:
+		cfg.TableFrom = TableSource(fc.TableFrom)
+	default:
+		return nil, fmt.Errorf("table_from must be filename, directory or fields, got %q", fc.TableFrom)
+	}
+	if fc.MinMatchRatio < 0 || fc.MinMatchRatio > 1 {
+		return nil, fmt.Errorf("min_match_ratio must be between 0 and 1, got %v", fc.MinMatchRatio)
+	}
+	if fc.MinMatchRatio > 0 {
+		cfg.MinMatchRatio = fc.MinMatchRatio
+	}
+	cfg.Locale = fc.Locale
+	cfg.InterpolateEnv = fc.InterpolateEnv
+	cfg.AllowedQueries = fc.AllowedQueries
+	if fc.QueryCacheSize < 0 {
+		return nil, fmt.Errorf("query_cache_size must not be negative")
+	}
+	cfg.QueryCacheSize = fc.QueryCacheSize
+	if fc.QueryCacheBytes < 0 {
+		return nil, fmt.Errorf("query_cache_bytes must not be negative")
+	}
+	if fc.QueryCacheBytes > 0 {
+		cfg.QueryCacheBytes = fc.QueryCacheBytes
+	}
+	cfg.Notice = fc.Notice
+	if fc.MaxResultRows < 0 || fc.MaxResultBytes < 0 {
+		return nil, fmt.Errorf("max_result_rows and max_result_bytes must not be negative")
+	}
+	cfg.MaxResultRows = fc.MaxResultRows
+	cfg.MaxResultBytes = fc.MaxResultBytes
+	if _, err := cfg.QueryAllowlist(); err != nil {
+		return nil, err
+	}
+	if fc.PathTemplate != "" {
+		cfg.PathTemplate = fc.PathTemplate
+	}
+	if fc.MarkdownBody != "" {
+		cfg.MarkdownBody = fc.MarkdownBody
+	}
+	cfg.KeyField = fc.KeyField
+	if fc.Port < 0 || fc.Port > 65535 {
+		return nil, fmt.Errorf("port must be between 1 and 65535, got %d", fc.Port)
 	}
 	if fc.Port != 0 {
 		cfg.Port = fc.Port
 	}
+	cfg.Listen = fc.Listen
+	if fc.HTTPPort < 0 || fc.HTTPPort > 65535 {
+		return nil, fmt.Errorf("http_port must be between 1 and 65535, got %d", fc.HTTPPort)
+	}
+	cfg.HTTPPort = fc.HTTPPort
+	if fc.Webhook != "" {
+		cfg.WebhookURL = fc.Webhook
+	}
 	if fc.Credentials.Username != "" {
 		cfg.UsernameEnvVar = fc.Credentials.Username
 	}
 	if fc.Credentials.Password != "" {
 		cfg.PasswordEnvVar = fc.Credentials.Password
 	}
+	switch AuthMethod(fc.Credentials.Method) {
+	case "":
+	case AuthPassword, AuthMD5, AuthSCRAM:
+		cfg.AuthMethod = AuthMethod(fc.Credentials.Method)
+	default:
+		return nil, fmt.Errorf("credentials.method must be password, md5 or scram-sha-256, got %q", fc.Credentials.Method)
+	}
+	if fc.Encryption.Key != "" {
+		cfg.EncryptionKeyEnvVar = fc.Encryption.Key
+	}
+	if fc.Snapshots.Keep < 0 {
+		return nil, fmt.Errorf("snapshots.keep must not be negative")
+	}
+	if fc.Snapshots.Keep != 0 {
+		cfg.KeepSnapshots = fc.Snapshots.Keep
+	}
+	if fc.BuildTimeout != "" {
+		d, err := time.ParseDuration(fc.BuildTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("build_timeout: %w", err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("build_timeout must not be negative")
+		}
+		cfg.BuildTimeout = d
+	}
+	if fc.Timestamps.Zone != "" {
+		loc, err := time.LoadLocation(fc.Timestamps.Zone)
+		if err != nil {
+			return nil, fmt.Errorf("timestamps.zone: %w", err)
+		}
+		cfg.TimestampLocation = loc
+	}
+	switch TimestampFormat(fc.Timestamps.Format) {
+	case "":
+	case TimestampRFC3339, TimestampUnix:
+		cfg.TimestampFormat = TimestampFormat(fc.Timestamps.Format)
+	default:
+		return nil, fmt.Errorf("timestamps.format must be rfc3339 or unix, got %q", fc.Timestamps.Format)
+	}
+	switch ModTimeSource(fc.Timestamps.ModifiedAt) {
+	case "":
+	case ModTimeFilesystem, ModTimeGit:
+		cfg.ModifiedAt = ModTimeSource(fc.Timestamps.ModifiedAt)
+	default:
+		return nil, fmt.Errorf("timestamps.modified_at must be filesystem or git, got %q", fc.Timestamps.ModifiedAt)
+	}
+	cfg.Children = fc.Children
+	cfg.Renames = fc.Renames
+	for table, src := range fc.Transforms {
+		prog, err := transform.Compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("transforms.%s: %w", table, err)
+		}
+		if cfg.Transforms == nil {
+			cfg.Transforms = make(map[string]*transform.Program, len(fc.Transforms))
+		}
+		cfg.Transforms[table] = prog
+	}
+	cfg.Collations = fc.Collations
+	if cfg.Tables, err = tableMappings(&fc.Tables); err != nil {
+		return nil, err
+	}
+	if cfg.Loaders, err = externalLoaders(rootDir, fc.Loaders); err != nil {
+		return nil, err
+	}
+	cfg.Queries = fc.Queries
+	cfg.Users = fc.Users
+	cfg.AccessLog.Path = fc.AccessLog.Path
+	if fc.AccessLog.Format != "" {
+		if fc.AccessLog.Format != "combined" && fc.AccessLog.Format != "json" {
+			return nil, fmt.Errorf("access_log.format must be combined or json, got %q", fc.AccessLog.Format)
+		}
+		cfg.AccessLog.Format = fc.AccessLog.Format
+	}
+	if fc.AccessLog.MaxSizeMB < 0 || fc.AccessLog.MaxBackups < 0 {
+		return nil, fmt.Errorf("access_log.max_size_mb and access_log.max_backups must not be negative")
+	}
+	if fc.AccessLog.MaxSizeMB != 0 {
+		cfg.AccessLog.MaxSizeMB = fc.AccessLog.MaxSizeMB
+	}
+	if fc.AccessLog.MaxBackups != 0 {
+		cfg.AccessLog.MaxBackups = fc.AccessLog.MaxBackups
+	}
+	if fc.ColumnPrefix != "" {
+		cfg.StandardColumns = PrefixedColumns(fc.ColumnPrefix)
+	}
 	if fc.Columns.Path != "" {
 		cfg.StandardColumns.Path = fc.Columns.Path
 	}
@@ -119,10 +704,36 @@ func Load(rootDir string) (*Config, error) {
 	if fc.Columns.PK != "" {
 		cfg.StandardColumns.PK = fc.Columns.PK
 	}
+	if fc.Columns.DeletedAt != "" {
+		cfg.StandardColumns.DeletedAt = fc.Columns.DeletedAt
+	}
+	if fc.Columns.RecordChecksum != "" {
+		cfg.StandardColumns.RecordChecksum = fc.Columns.RecordChecksum
+	}
+	if fc.Columns.SourceLine != "" {
+		cfg.StandardColumns.SourceLine = fc.Columns.SourceLine
+	}
 
 	return cfg, nil
 }
 
+// unknownFieldRe matches yaml's report of a key that fileConfig lacks.
+var unknownFieldRe = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// decodeError rewords the errors of decoding sqlfs.yaml for its author,
+// naming unknown keys as settings rather than Go struct fields.
+func decodeError(err error) error {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return err
+	}
+	msgs := make([]string, len(te.Errors))
+	for i, msg := range te.Errors {
+		msgs[i] = unknownFieldRe.ReplaceAllString(msg, `unknown setting "$1"`)
+	}
+	return fmt.Errorf("sqlfs.yaml: %s", strings.Join(msgs, "; "))
+}
+
 // WithInvalid returns a copy of cfg with the InvalidBehavior overridden if override is non-empty.
 func (c *Config) WithInvalid(override string) *Config {
 	if override == "" {
@@ -143,14 +754,427 @@ func (c *Config) WithPort(override int) *Config {
 	return &copy
 }
 
+// WithListen returns a copy of cfg with Listen overridden if override is non-empty.
+func (c *Config) WithListen(override []string) *Config {
+	if len(override) == 0 {
+		return c
+	}
+	copy := *c
+	copy.Listen = override
+	return &copy
+}
+
+// WithHTTPPort returns a copy of cfg with HTTPPort overridden if override > 0.
+func (c *Config) WithHTTPPort(override int) *Config {
+	if override <= 0 {
+		return c
+	}
+	copy := *c
+	copy.HTTPPort = override
+	return &copy
+}
+
+// WithWebhook returns a copy of cfg with WebhookURL overridden if override is non-empty.
+func (c *Config) WithWebhook(override string) *Config {
+	if override == "" {
+		return c
+	}
+	copy := *c
+	copy.WebhookURL = override
+	return &copy
+}
+
+// WithEncryptionKeyEnv returns a copy of cfg with EncryptionKeyEnvVar overridden if override is non-empty.
+func (c *Config) WithEncryptionKeyEnv(override string) *Config {
+	if override == "" {
+		return c
+	}
+	copy := *c
+	copy.EncryptionKeyEnvVar = override
+	return &copy
+}
+
+// WithLocale returns a copy of cfg with Locale overridden if override is non-empty.
+func (c *Config) WithLocale(override string) *Config {
+	if override == "" {
+		return c
+	}
+	copy := *c
+	copy.Locale = override
+	return &copy
+}
+
+// WithKeepSnapshots returns a copy of cfg with KeepSnapshots overridden if override > 0.
+func (c *Config) WithKeepSnapshots(override int) *Config {
+	if override <= 0 {
+		return c
+	}
+	copy := *c
+	copy.KeepSnapshots = override
+	return &copy
+}
+
+// WithBuildTimeout returns a copy of cfg with BuildTimeout overridden if override > 0.
+func (c *Config) WithBuildTimeout(override time.Duration) *Config {
+	if override <= 0 {
+		return c
+	}
+	copy := *c
+	copy.BuildTimeout = override
+	return &copy
+}
+
+// WithDeterministic returns a copy of cfg with Deterministic set if override is true.
+func (c *Config) WithDeterministic(override bool) *Config {
+	if !override {
+		return c
+	}
+	copy := *c
+	copy.Deterministic = true
+	return &copy
+}
+
+// WithIncremental returns a copy of cfg with Incremental set if override is true.
+func (c *Config) WithIncremental(override bool) *Config {
+	if !override {
+		return c
+	}
+	copy := *c
+	copy.Incremental = true
+	return &copy
+}
+
+// WithSchema returns a copy of cfg with SchemaFile overridden if override is non-empty.
+func (c *Config) WithSchema(override string) *Config {
+	if override == "" {
+		return c
+	}
+	copy := *c
+	copy.SchemaFile = override
+	return &copy
+}
+
+// SchemaStdin is the SchemaFile that reads the schema from standard input.
+const SchemaStdin = "-"
+
+// SchemaIsFile reports whether SchemaFile names a file in the root
+// directory, rather than standard input or an http(s) URL.
+func (c *Config) SchemaIsFile() bool {
+	return c.SchemaFile != SchemaStdin && !c.SchemaIsURL()
+}
+
+// SchemaIsURL reports whether SchemaFile is an http(s) URL.
+func (c *Config) SchemaIsURL() bool {
+	return strings.HasPrefix(c.SchemaFile, "http://") || strings.HasPrefix(c.SchemaFile, "https://")
+}
+
+// WithRemoteCache returns a copy of cfg with RemoteCache overridden if override is non-empty.
+func (c *Config) WithRemoteCache(override string) *Config {
+	if override == "" {
+		return c
+	}
+	copy := *c
+	copy.RemoteCache = override
+	return &copy
+}
+
+// IsExcludedTable reports whether table is listed in ExcludeTables.
+func (c *Config) IsExcludedTable(table string) bool {
+	for _, t := range c.ExcludeTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// AtomicDir returns the directory of relPath, or of one of its ancestors,
+// that an AtomicDirs pattern matches, innermost first; "" if there is none.
+func (c *Config) AtomicDir(relPath string) string {
+	for dir := path.Dir(filepath.ToSlash(relPath)); dir != "."; dir = path.Dir(dir) {
+		for _, pattern := range c.AtomicDirs {
+			if matchGlob(pattern, dir) {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+// DefaultIgnore lists the patterns every build and watcher skips: hidden
+// files and directories, which include Emacs lock files (".#*") and macOS's
+// ".DS_Store", and the backup and swap files of editors.
+var DefaultIgnore = []string{".*", "*~", "*.swp"}
+
+// IsIgnored reports whether builds skip relPath, because it or one of its
+// directories matches an Ignore pattern.
+func (c *Config) IsIgnored(relPath string) bool {
+	return MatchIgnore(c.Ignore, relPath)
+}
+
+// MatchIgnore reports whether relPath or one of its directories matches one
+// of patterns. A pattern without a "/" matches file and directory names, e.g.
+// "*.bak"; one with a "/" matches paths relative to the root, e.g.
+// "drafts/**".
+func MatchIgnore(patterns []string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for p := relPath; p != "." && p != "/" && p != ".."; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			name := p
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(p)
+			}
+			if matchGlob(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Changed returns the names of the Config fields whose values differ between c
+// and other, in declaration order.
+func (c *Config) Changed(other *Config) []string {
+	a, b := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		if name == "TimestampLocation" {
+			// Each load yields a distinct *time.Location; compare by name.
+			if c.TimestampLocation.String() != other.TimestampLocation.String() {
+				changed = append(changed, name)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
 // StandardColumnNames returns all standard column names as a set for quick lookup.
 func (c *Config) StandardColumnNames() map[string]struct{} {
 	return map[string]struct{}{
-		c.StandardColumns.PK:         {},
-		c.StandardColumns.Path:       {},
-		c.StandardColumns.CreatedAt:  {},
-		c.StandardColumns.ModifiedAt: {},
-		c.StandardColumns.Checksum:   {},
-		c.StandardColumns.ULID:       {},
+		c.StandardColumns.PK:             {},
+		c.StandardColumns.Path:           {},
+		c.StandardColumns.CreatedAt:      {},
+		c.StandardColumns.ModifiedAt:     {},
+		c.StandardColumns.Checksum:       {},
+		c.StandardColumns.ULID:           {},
+		c.StandardColumns.DeletedAt:      {},
+		c.StandardColumns.RecordChecksum: {},
+		c.StandardColumns.SourceLine:     {},
+	}
+}
+
+// ChildTable returns the child table and back-reference column that records
+// in the array field key of a parent table expand into. Unless configured
+// under children in sqlfs.yaml, the table is "<parent>_<key>" and the
+// back-reference column is "<parent>_pk".
+func (c *Config) ChildTable(parent, key string) (table, parentColumn string) {
+	table = parent + "_" + key
+	parentColumn = parent + "_pk"
+	if m, ok := c.Children[parent][key]; ok {
+		if m.Table != "" {
+			table = m.Table
+		}
+		if m.ParentColumn != "" {
+			parentColumn = m.ParentColumn
+		}
+	}
+	return table, parentColumn
+}
+
+// IsChildKey reports whether key of parent is explicitly mapped to a child table.
+func (c *Config) IsChildKey(parent, key string) bool {
+	_, ok := c.Children[parent][key]
+	return ok
+}
+
+// RenameField returns the column name for field of table: its configured
+// rename, or field itself.
+func (c *Config) RenameField(table, field string) string {
+	if to, ok := c.Renames[table][field]; ok && to != "" {
+		return to
+	}
+	return field
+}
+
+// tableMappings reads the tables mapping in document order.
+func tableMappings(n *yaml.Node) ([]TableMapping, error) {
+	if n.Kind == 0 {
+		return nil, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("tables must map file patterns to table names")
+	}
+	mappings := make([]TableMapping, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pattern, table := n.Content[i].Value, n.Content[i+1].Value
+		if pattern == "" || table == "" || n.Content[i+1].Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("tables: %q must map to a table name", pattern)
+		}
+		mappings = append(mappings, TableMapping{Pattern: pattern, Table: table})
+	}
+	return mappings, nil
+}
+
+// externalLoaders checks the loaders mapping of extensions to commands and
+// returns its loaders, run in rootDir, sorted by extension.
+func externalLoaders(rootDir string, m map[string][]string) ([]ExternalLoader, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	dir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	loaders := make([]ExternalLoader, 0, len(m))
+	for ext, command := range m {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./\\") {
+			return nil, fmt.Errorf("loaders: %q is not a file extension such as .csv", ext)
+		}
+		if strings.EqualFold(ext, ".gz") {
+			return nil, fmt.Errorf("loaders: .gz files are decompressed and loaded by the extension before it")
+		}
+		if len(command) == 0 || command[0] == "" {
+			return nil, fmt.Errorf("loaders.%s: command must list the program to run and its arguments", ext)
+		}
+		loaders = append(loaders, ExternalLoader{Extension: strings.ToLower(ext), Command: command, Dir: dir})
 	}
+	slices.SortFunc(loaders, func(a, b ExternalLoader) int { return strings.Compare(a.Extension, b.Extension) })
+	for i := 1; i < len(loaders); i++ {
+		if loaders[i].Extension == loaders[i-1].Extension {
+			return nil, fmt.Errorf("loaders: %s is given more than once", loaders[i].Extension)
+		}
+	}
+	return loaders, nil
+}
+
+// TableFor returns the table of the first Tables pattern matching relPath.
+// When none does and TableFrom is directory, it returns the name of the
+// file's parent directory; otherwise, and for files in the root directory,
+// it returns "" and the table comes from the file name, or, when TableFrom
+// is fields and the name gives none, from the file's fields.
+func (c *Config) TableFor(relPath string) string {
+	relPath = filepath.ToSlash(relPath)
+	for _, m := range c.Tables {
+		if matchGlob(m.Pattern, relPath) {
+			return m.Table
+		}
+	}
+	if c.TableFrom == TableFromDirectory {
+		if dir := path.Dir(relPath); dir != "." {
+			return path.Base(dir)
+		}
+	}
+	return ""
+}
+
+// matchGlob reports whether the slash-separated name matches pattern. "*"
+// matches any run of characters other than "/", "?" any one of them, and
+// "**" any run of characters at all; "**/" also matches no directory, so
+// "**/*.yaml" matches "a.yaml".
+func matchGlob(pattern, name string) bool {
+	for pattern != "" {
+		switch {
+		case strings.HasPrefix(pattern, "**/"):
+			rest := pattern[3:]
+			if matchGlob(rest, name) {
+				return true
+			}
+			for i := 0; i < len(name); i++ {
+				if name[i] == '/' && matchGlob(rest, name[i+1:]) {
+					return true
+				}
+			}
+			return false
+		case strings.HasPrefix(pattern, "**"):
+			rest := pattern[2:]
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case pattern[0] == '*':
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(rest, name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' {
+					break
+				}
+			}
+			return false
+		case pattern[0] == '?':
+			r, size := utf8.DecodeRuneInString(name)
+			if name == "" || r == '/' {
+				return false
+			}
+			pattern, name = pattern[1:], name[size:]
+		default:
+			if name == "" || pattern[0] != name[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return name == ""
+}
+
+// Collation returns the collation configured for column of table, or "".
+func (c *Config) Collation(table, column string) string {
+	return c.Collations[table][column]
+}
+
+// QueryAllowlist compiles AllowedQueries. Each pattern must match a whole
+// query, so it is anchored at both ends.
+func (c *Config) QueryAllowlist() ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(c.AllowedQueries))
+	for _, pattern := range c.AllowedQueries {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("allowed_queries: %w", err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// FormatPath returns the path standard column value for the record with the
+// given key in the file at relPath, by substituting {path} and {key} in
+// PathTemplate. Both always use "/" as the separator, whatever the OS.
+func (c *Config) FormatPath(relPath, key string) string {
+	return strings.NewReplacer(
+		"{path}", filepath.ToSlash(relPath),
+		"{key}", filepath.ToSlash(key),
+	).Replace(c.PathTemplate)
+}
+
+// UnixTimestamps reports whether the standard timestamp columns hold Unix
+// epoch integers rather than RFC 3339 text.
+func (c *Config) UnixTimestamps() bool {
+	return c.TimestampFormat == TimestampUnix
+}
+
+// FormatTimestamp returns t as stored in a standard timestamp column: an
+// int64 of Unix seconds, or RFC 3339 text in TimestampLocation.
+func (c *Config) FormatTimestamp(t time.Time) any {
+	if c.UnixTimestamps() {
+		return t.Unix()
+	}
+	loc := c.TimestampLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// KeepTombstones reports whether deleted entities are kept in the build
+// (and the deleted_at standard column is emitted).
+func (c *Config) KeepTombstones() bool {
+	return c.Tombstones == TombstoneKeep
 }
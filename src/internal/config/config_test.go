@@ -3,7 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefault(t *testing.T) {
@@ -24,6 +27,9 @@ func TestDefault(t *testing.T) {
 	if cfg.PasswordEnvVar != "SQLFS_PASSWORD" {
 		t.Errorf("PasswordEnvVar = %q, want SQLFS_PASSWORD", cfg.PasswordEnvVar)
 	}
+	if cfg.AuthMethod != AuthSCRAM {
+		t.Errorf("AuthMethod = %q, want %q", cfg.AuthMethod, AuthSCRAM)
+	}
 	if cfg.StandardColumns.Path != "__path__" {
 		t.Errorf("Path = %q, want __path__", cfg.StandardColumns.Path)
 	}
@@ -79,21 +85,126 @@ columns:
 	}
 }
 
+func TestLoad_ColumnPrefix(t *testing.T) {
+	dir := t.TempDir()
+	content := `
+column_prefix: _sqlfs_
+columns:
+  pk: id
+`
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := PrefixedColumns("_sqlfs_")
+	want.PK = "id" // columns entries take precedence
+	if cfg.StandardColumns != want {
+		t.Errorf("StandardColumns = %+v, want %+v", cfg.StandardColumns, want)
+	}
+	if want.Path != "_sqlfs_path" || want.SourceLine != "_sqlfs_source_line" {
+		t.Errorf("PrefixedColumns = %+v", want)
+	}
+	if _, ok := cfg.StandardColumnNames()["_sqlfs_checksum"]; !ok {
+		t.Errorf("StandardColumnNames = %v, want the prefixed names", cfg.StandardColumnNames())
+	}
+}
+
 func TestLoad_FullOverride(t *testing.T) {
 	dir := t.TempDir()
 	content := `
 schema: custom.dbml
+min_sqlfs_version: "1.2"
 invalid: silent
+tombstones: keep
+enum_tables: true
+json_text: true
+record_checksums: true
+source_lines: true
+forbid_standard_columns: true
+foreign_keys: true
+markdown_body: content
+markdown_assets: true
+schema_tables: true
+key_field: sku
+path_template: "{path}"
+generate_uuids: v7
+id_strategy: snowflake
+redact: drop
+redact_key: TOKEN_HASH_KEY
+table_from: directory
+min_match_ratio: 0.75
+locale: fr
+exclude_tables: [drafts]
+atomic_dirs: ["catalog/*"]
+ignore: ["drafts/**"]
+interpolate_env: [BUCKET, HOST]
+allowed_queries: ["SELECT 1"]
+query_cache_size: 64
+query_cache_bytes: 1000000
+notice: "read-only dataset {database}, built {built_at}"
+access_log:
+  path: access.log
+  format: json
+  max_size_mb: 10
+max_result_rows: 1000
+max_result_bytes: 1048576
 port: 1234
+listen: ["127.0.0.1:6543", "unix:/tmp/sqlfs.sock"]
+http_port: 8080
+webhook: http://localhost:9000/hook
 credentials:
   username: MY_USER
   password: MY_PASS
+  method: md5
+encryption:
+  key: MY_KEY
+snapshots:
+  keep: 5
+build_timeout: 90s
+incremental: true
+remote_cache: https://cache.example.com/sqlfs
+timestamps:
+  zone: America/New_York
+  format: unix
+  modified_at: git
+children:
+  users:
+    orders:
+      table: orders
+      parent_column: user_pk
+renames:
+  users:
+    fullname: name
+transforms:
+  users: del(.legacy)
+collations:
+  users:
+    email: nocase
+tables:
+  "people/admins/*.yaml": admins
+  "people/**.yaml": users
+loaders:
+  .CSV: [python3, tools/csv2json.py]
+queries:
+  recent: SELECT 1
+users:
+  tenant_a:
+    password: TENANT_A_PASSWORD
+    row_filters:
+      posts: tenant = 'a'
 columns:
   path: p
   created_at: ca
   modified_at: ma
   checksum: cs
   ulid: ul
+  deleted_at: da
+  record_checksum: rc
+  source_line: sl
 `
 	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte(content), 0644); err != nil {
 		t.Fatal(err)
@@ -107,24 +218,189 @@ columns:
 	if cfg.SchemaFile != "custom.dbml" {
 		t.Errorf("SchemaFile = %q", cfg.SchemaFile)
 	}
+	if cfg.MinVersion != "1.2" {
+		t.Errorf("MinVersion = %q", cfg.MinVersion)
+	}
 	if cfg.Invalid != InvalidSilent {
 		t.Errorf("Invalid = %q", cfg.Invalid)
 	}
+	if !cfg.KeepTombstones() {
+		t.Errorf("Tombstones = %q, want keep", cfg.Tombstones)
+	}
+	if !cfg.EnumTables {
+		t.Error("EnumTables = false, want true")
+	}
+	if !cfg.JSONText {
+		t.Error("JSONText = false, want true")
+	}
+	if cfg.PathTemplate != "{path}" {
+		t.Errorf("PathTemplate = %q", cfg.PathTemplate)
+	}
+	if u := cfg.Users["tenant_a"]; u.Password != "TENANT_A_PASSWORD" || u.RowFilters["posts"] != "tenant = 'a'" {
+		t.Errorf("Users = %v", cfg.Users)
+	}
+	if strings.Join(cfg.Listen, ",") != "127.0.0.1:6543,unix:/tmp/sqlfs.sock" {
+		t.Errorf("Listen = %v", cfg.Listen)
+	}
+	if cfg.Queries["recent"] != "SELECT 1" {
+		t.Errorf("Queries = %v", cfg.Queries)
+	}
+	if len(cfg.AllowedQueries) != 1 || cfg.AllowedQueries[0] != "SELECT 1" {
+		t.Errorf("AllowedQueries = %v", cfg.AllowedQueries)
+	}
+	if cfg.QueryCacheSize != 64 {
+		t.Errorf("QueryCacheSize = %d, want 64", cfg.QueryCacheSize)
+	}
+	if cfg.QueryCacheBytes != 1000000 {
+		t.Errorf("QueryCacheBytes = %d, want 1000000", cfg.QueryCacheBytes)
+	}
+	if cfg.Notice != "read-only dataset {database}, built {built_at}" {
+		t.Errorf("Notice = %q", cfg.Notice)
+	}
+	if cfg.AccessLog != (AccessLog{Path: "access.log", Format: "json", MaxSizeMB: 10, MaxBackups: 3}) {
+		t.Errorf("AccessLog = %+v", cfg.AccessLog)
+	}
+	if cfg.MaxResultRows != 1000 || cfg.MaxResultBytes != 1048576 {
+		t.Errorf("MaxResultRows, MaxResultBytes = %d, %d", cfg.MaxResultRows, cfg.MaxResultBytes)
+	}
+	if strings.Join(cfg.InterpolateEnv, ",") != "BUCKET,HOST" {
+		t.Errorf("InterpolateEnv = %v", cfg.InterpolateEnv)
+	}
+	if !cfg.IsExcludedTable("drafts") || cfg.IsExcludedTable("users") {
+		t.Errorf("ExcludeTables = %v", cfg.ExcludeTables)
+	}
+	if got := cfg.AtomicDir("catalog/shoes/sizes/a.sizes.yaml"); got != "catalog/shoes" {
+		t.Errorf("AtomicDir = %q, want catalog/shoes", got)
+	}
+	if !cfg.IsIgnored("drafts/a.posts.md") || !cfg.IsIgnored(".hidden.posts.md") {
+		t.Errorf("Ignore = %v, want the defaults and drafts/**", cfg.Ignore)
+	}
+	if got := cfg.AtomicDir("catalog/a.products.yaml"); got != "" {
+		t.Errorf("AtomicDir(catalog/a.products.yaml) = %q, want none", got)
+	}
+	if cfg.MinMatchRatio != 0.75 {
+		t.Errorf("MinMatchRatio = %v, want 0.75", cfg.MinMatchRatio)
+	}
+	if cfg.Locale != "fr" {
+		t.Errorf("Locale = %q, want fr", cfg.Locale)
+	}
+	if cfg.Redact != RedactDrop {
+		t.Errorf("Redact = %q, want drop", cfg.Redact)
+	}
+	if cfg.RedactKeyEnvVar != "TOKEN_HASH_KEY" {
+		t.Errorf("RedactKeyEnvVar = %q, want TOKEN_HASH_KEY", cfg.RedactKeyEnvVar)
+	}
+	if cfg.GenerateUUIDs != UUIDv7 {
+		t.Errorf("GenerateUUIDs = %q", cfg.GenerateUUIDs)
+	}
+	if cfg.IDStrategy != IDSnowflake {
+		t.Errorf("IDStrategy = %q", cfg.IDStrategy)
+	}
+	if !cfg.RecordChecksums || cfg.StandardColumns.RecordChecksum != "rc" {
+		t.Errorf("RecordChecksums = %v, column %q", cfg.RecordChecksums, cfg.StandardColumns.RecordChecksum)
+	}
+	if !cfg.SourceLines || cfg.StandardColumns.SourceLine != "sl" {
+		t.Errorf("SourceLines = %v, column %q", cfg.SourceLines, cfg.StandardColumns.SourceLine)
+	}
+	if !cfg.ForbidStandardColumns {
+		t.Error("ForbidStandardColumns = false, want true")
+	}
+	if want := []ExternalLoader{{Extension: ".csv", Command: []string{"python3", "tools/csv2json.py"}, Dir: dir}}; !reflect.DeepEqual(cfg.Loaders, want) {
+		t.Errorf("Loaders = %+v, want %+v", cfg.Loaders, want)
+	}
+	if cfg.MarkdownBody != "content" {
+		t.Errorf("MarkdownBody = %q", cfg.MarkdownBody)
+	}
+	if !cfg.MarkdownAssets {
+		t.Error("MarkdownAssets = false, want true")
+	}
+	if !cfg.SchemaTables {
+		t.Error("SchemaTables = false, want true")
+	}
+	if cfg.KeyField != "sku" {
+		t.Errorf("KeyField = %q", cfg.KeyField)
+	}
+	if !cfg.ForeignKeys {
+		t.Error("ForeignKeys = false")
+	}
 	if cfg.Port != 1234 {
 		t.Errorf("Port = %d", cfg.Port)
 	}
+	if cfg.HTTPPort != 8080 {
+		t.Errorf("HTTPPort = %d", cfg.HTTPPort)
+	}
+	if cfg.WebhookURL != "http://localhost:9000/hook" {
+		t.Errorf("WebhookURL = %q", cfg.WebhookURL)
+	}
 	if cfg.UsernameEnvVar != "MY_USER" {
 		t.Errorf("UsernameEnvVar = %q", cfg.UsernameEnvVar)
 	}
 	if cfg.PasswordEnvVar != "MY_PASS" {
 		t.Errorf("PasswordEnvVar = %q", cfg.PasswordEnvVar)
 	}
+	if cfg.AuthMethod != AuthMD5 {
+		t.Errorf("AuthMethod = %q, want md5", cfg.AuthMethod)
+	}
+	if cfg.EncryptionKeyEnvVar != "MY_KEY" {
+		t.Errorf("EncryptionKeyEnvVar = %q", cfg.EncryptionKeyEnvVar)
+	}
+	if cfg.KeepSnapshots != 5 {
+		t.Errorf("KeepSnapshots = %d", cfg.KeepSnapshots)
+	}
+	if cfg.BuildTimeout != 90*time.Second {
+		t.Errorf("BuildTimeout = %v", cfg.BuildTimeout)
+	}
+	if !cfg.Incremental {
+		t.Error("Incremental = false")
+	}
+	if cfg.RemoteCache != "https://cache.example.com/sqlfs" {
+		t.Errorf("RemoteCache = %q", cfg.RemoteCache)
+	}
+	if cfg.TimestampLocation.String() != "America/New_York" {
+		t.Errorf("TimestampLocation = %v", cfg.TimestampLocation)
+	}
+	if !cfg.UnixTimestamps() {
+		t.Errorf("TimestampFormat = %q, want unix", cfg.TimestampFormat)
+	}
+	if cfg.ModifiedAt != ModTimeGit {
+		t.Errorf("ModifiedAt = %q, want git", cfg.ModifiedAt)
+	}
+	if table, col := cfg.ChildTable("users", "orders"); table != "orders" || col != "user_pk" {
+		t.Errorf("ChildTable(users, orders) = %q, %q", table, col)
+	}
+	if p := cfg.Transforms["users"]; p == nil || p.String() != "del(.legacy)" {
+		t.Errorf("Transforms = %v, want del(.legacy) for users", cfg.Transforms)
+	}
+	if got := cfg.RenameField("users", "fullname"); got != "name" {
+		t.Errorf("RenameField(users, fullname) = %q, want name", got)
+	}
+	if got := cfg.TableFor("people/admins/root.yaml"); got != "admins" {
+		t.Errorf("TableFor(people/admins/root.yaml) = %q, want admins (first match)", got)
+	}
+	if got := cfg.TableFor(filepath.Join("people", "staff", "bob.yaml")); got != "users" {
+		t.Errorf("TableFor(people/staff/bob.yaml) = %q, want users", got)
+	}
+	if got := cfg.TableFor("posts/2024/hello.md"); got != "2024" {
+		t.Errorf("TableFor(posts/2024/hello.md) = %q, want 2024 (parent directory)", got)
+	}
+	if got := cfg.TableFor("alice.users.yaml"); got != "" {
+		t.Errorf("TableFor(alice.users.yaml) = %q, want empty in the root directory", got)
+	}
+	if got := cfg.Collation("users", "email"); got != "nocase" {
+		t.Errorf("Collation(users, email) = %q, want nocase", got)
+	}
+	if got := cfg.RenameField("users", "age"); got != "age" {
+		t.Errorf("RenameField(users, age) = %q, want age", got)
+	}
 	if cfg.StandardColumns.Path != "p" {
 		t.Errorf("Path = %q", cfg.StandardColumns.Path)
 	}
 	if cfg.StandardColumns.ULID != "ul" {
 		t.Errorf("ULID = %q", cfg.StandardColumns.ULID)
 	}
+	if cfg.StandardColumns.DeletedAt != "da" {
+		t.Errorf("DeletedAt = %q", cfg.StandardColumns.DeletedAt)
+	}
 }
 
 func TestLoad_InvalidYAML(t *testing.T) {
@@ -169,6 +445,54 @@ func TestWithPort(t *testing.T) {
 	}
 }
 
+func TestWithHTTPPort(t *testing.T) {
+	cfg := Default()
+	if cfg.HTTPPort != 0 {
+		t.Errorf("default HTTPPort = %d, want 0", cfg.HTTPPort)
+	}
+	if got := cfg.WithHTTPPort(8080).HTTPPort; got != 8080 {
+		t.Errorf("HTTPPort = %d, want 8080", got)
+	}
+	if got := cfg.WithHTTPPort(0).HTTPPort; got != 0 {
+		t.Errorf("zero override changed HTTPPort to %d", got)
+	}
+}
+
+func TestWithListen(t *testing.T) {
+	cfg := Default()
+	cfg.Listen = []string{":6543"}
+	if got := cfg.WithListen([]string{"unix:/tmp/s"}).Listen; len(got) != 1 || got[0] != "unix:/tmp/s" {
+		t.Errorf("Listen = %v, want [unix:/tmp/s]", got)
+	}
+	if got := cfg.WithListen(nil).Listen; len(got) != 1 || got[0] != ":6543" {
+		t.Errorf("empty override changed Listen to %v", got)
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	cfg := Default()
+	if !cfg.SchemaIsFile() {
+		t.Error("default schema is not a file")
+	}
+	if got := cfg.WithSchema("").SchemaFile; got != "schema.dbml" {
+		t.Errorf("empty override changed SchemaFile to %q", got)
+	}
+	for _, tc := range []struct {
+		schema      string
+		file, isURL bool
+	}{
+		{"shared/schema.dbml", true, false},
+		{SchemaStdin, false, false},
+		{"https://example.com/schema.dbml", false, true},
+		{"http://example.com/schema.dbml", false, true},
+	} {
+		c := cfg.WithSchema(tc.schema)
+		if c.SchemaFile != tc.schema || c.SchemaIsFile() != tc.file || c.SchemaIsURL() != tc.isURL {
+			t.Errorf("%q: SchemaFile = %q, SchemaIsFile = %v, SchemaIsURL = %v", tc.schema, c.SchemaFile, c.SchemaIsFile(), c.SchemaIsURL())
+		}
+	}
+}
+
 func TestStandardColumnNames(t *testing.T) {
 	cfg := Default()
 	names := cfg.StandardColumnNames()
@@ -178,3 +502,256 @@ func TestStandardColumnNames(t *testing.T) {
 		}
 	}
 }
+
+func TestChildTable_Defaults(t *testing.T) {
+	cfg := Default()
+	table, col := cfg.ChildTable("users", "orders")
+	if table != "users_orders" || col != "users_pk" {
+		t.Errorf("ChildTable(users, orders) = %q, %q, want users_orders, users_pk", table, col)
+	}
+	if cfg.IsChildKey("users", "orders") {
+		t.Error("IsChildKey should be false without a mapping")
+	}
+}
+
+func TestLoad_InvalidTimestampZone(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("timestamps:\n  zone: Nowhere/Special\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for unknown time zone")
+	}
+}
+
+func TestLoad_InvalidBuildTimeout(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("build_timeout: soon\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for invalid build_timeout")
+	}
+}
+
+func TestLoad_InvalidAllowedQuery(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("allowed_queries: [\"SELECT (\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for invalid allowed_queries pattern")
+	}
+}
+
+func TestLoad_InvalidTransform(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("transforms:\n  users: \".name |\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "transforms.users: column 8") {
+		t.Errorf("Load error = %v, want a syntax error in transforms.users", err)
+	}
+}
+
+func TestLoad_InvalidLoaders(t *testing.T) {
+	for src, want := range map[string]string{
+		"loaders:\n  csv: [cat]\n":             `loaders: "csv" is not a file extension`,
+		"loaders:\n  .tar.x: [cat]\n":          `loaders: ".tar.x" is not a file extension`,
+		"loaders:\n  .gz: [cat]\n":             "loaders: .gz files are decompressed",
+		"loaders:\n  .csv: []\n":               "loaders..csv: command must list the program",
+		"loaders:\n  .csv: [a]\n  .CSV: [b]\n": "loaders: .csv is given more than once",
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load(%q) error = %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestQueryAllowlist_Anchored(t *testing.T) {
+	cfg := Default()
+	cfg.AllowedQueries = []string{"SELECT 1"}
+	res, err := cfg.QueryAllowlist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res[0].MatchString("SELECT 1") || res[0].MatchString("SELECT 1; DROP TABLE t") {
+		t.Error("patterns should match whole queries only")
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	cfg := Default()
+	if got := cfg.FormatTimestamp(ts); got != "2024-01-02T15:04:05Z" {
+		t.Errorf("default = %v", got)
+	}
+
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("time zone database unavailable")
+	}
+	cfg.TimestampLocation = loc
+	if got := cfg.FormatTimestamp(ts); got != "2024-01-03T00:04:05+09:00" {
+		t.Errorf("Asia/Tokyo = %v", got)
+	}
+
+	cfg.TimestampFormat = TimestampUnix
+	if got := cfg.FormatTimestamp(ts); got != ts.Unix() {
+		t.Errorf("unix = %v, want %d", got, ts.Unix())
+	}
+}
+
+func TestFormatPath(t *testing.T) {
+	cfg := Default()
+	if got := cfg.FormatPath("users/alice.users.yaml", "users/alice"); got != "users/alice.users.yaml#users/alice" {
+		t.Errorf("default = %q", got)
+	}
+	cfg.PathTemplate = "{path}:{key}"
+	if got := cfg.FormatPath(filepath.Join("users", "alice.users.yaml"), "users/alice"); got != "users/alice.users.yaml:users/alice" {
+		t.Errorf("custom = %q", got)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"users/*.yaml", "users/alice.yaml", true},
+		{"users/*.yaml", "users/a/alice.yaml", false},
+		{"users/**.yaml", "users/a/alice.yaml", true},
+		{"users/**/*.yaml", "users/alice.yaml", true},
+		{"**/*.md", "posts/2024/hello.md", true},
+		{"**/*.md", "hello.md", true},
+		{"posts/?.md", "posts/é.md", true},
+		{"posts/?.md", "posts/ab.md", false},
+		{"*.yaml", "a/b.yaml", false},
+		{"**", "anything/at/all", true},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.name); got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestMatchIgnore(t *testing.T) {
+	patterns := append(DefaultIgnore, "drafts/**", "*.bak")
+	cases := []struct {
+		relPath string
+		want    bool
+	}{
+		{"alice.users.yaml", false},
+		{".alice.users.yaml", true},
+		{".#alice.users.yaml", true},
+		{".DS_Store", true},
+		{"alice.users.yaml~", true},
+		{"alice.users.yaml.swp", true},
+		{".git/config", true},
+		{"users/.cache/alice.users.yaml", true},
+		{"drafts/alice.users.yaml", true},
+		{"posts/drafts/alice.users.yaml", false},
+		{"old/alice.bak", true},
+		{"../other/alice.users.yaml", false},
+	}
+	for _, c := range cases {
+		if got := MatchIgnore(patterns, c.relPath); got != c.want {
+			t.Errorf("MatchIgnore(%q) = %v, want %v", c.relPath, got, c.want)
+		}
+	}
+}
+
+func TestLoad_InvalidTables(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("tables: [users]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for tables that is not a mapping")
+	}
+}
+
+func TestLoad_InvalidTableFrom(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("table_from: extension\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for unknown table_from")
+	}
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	cases := []struct {
+		yaml, want string
+	}{
+		{"invalid: warnn\n", `invalid must be silent, warn or fail, got "warnn"`},
+		{"tombstones: delete\n", `tombstones must be skip or keep, got "delete"`},
+		{"generate_uuids: v1\n", `generate_uuids must be v4 or v7, got "v1"`},
+		{"timestamps:\n  format: epoch\n", `timestamps.format must be rfc3339 or unix, got "epoch"`},
+		{"port: 70000\n", "port must be between 1 and 65535, got 70000"},
+		{"http_port: -1\n", "http_port must be between 1 and 65535, got -1"},
+		{"snapshots:\n  keep: -1\n", "snapshots.keep must not be negative"},
+		{"min_match_ratio: 1.5\n", "min_match_ratio must be between 0 and 1, got 1.5"},
+		{"id_strategy: snowflake\ndeterministic: true\n", "deterministic cannot be used with id_strategy snowflake"},
+		{"invalidd: warn\ncolumns:\n  pathh: p\n", `sqlfs.yaml: line 1: unknown setting "invalidd"; line 3: unknown setting "pathh"`},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte(c.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Load(dir)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Load(%q): err = %v, want %s", c.yaml, err, c.want)
+		}
+	}
+}
+
+func TestLoad_EmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err != nil {
+		t.Errorf("Load of an empty sqlfs.yaml: %v", err)
+	}
+}
+
+func TestLoad_InvalidIDStrategy(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("id_strategy: serial\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for unknown id_strategy")
+	}
+}
+
+func TestLoad_InvalidAuthMethod(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sqlfs.yaml"), []byte("credentials:\n  method: trust\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for unknown credentials.method")
+	}
+}
+
+func TestChanged(t *testing.T) {
+	a := Default()
+	b := a.WithInvalid("warn").WithPort(6543)
+	b.TimestampLocation, _ = time.LoadLocation("UTC")
+	got := a.Changed(b)
+	if strings.Join(got, ",") != "Invalid,Port" {
+		t.Errorf("Changed = %v, want [Invalid Port]", got)
+	}
+	if got := a.Changed(Default()); len(got) != 0 {
+		t.Errorf("Changed(Default()) = %v, want none", got)
+	}
+}
@@ -28,11 +28,39 @@ func (s *Schema) EnumByName(name string) *Enum {
 	return nil
 }
 
+// Relationships returns every relationship in s, inline or standalone,
+// oriented so From holds the referencing column and To the referenced one.
+// Many-to-many relationships have no referencing column and are omitted.
+func (s *Schema) Relationships() []*Ref {
+	var refs []*Ref
+	add := func(r Ref) {
+		switch r.Relation {
+		case ManyToOne, OneToOne:
+			refs = append(refs, &r)
+		case OneToMany:
+			r.From, r.To, r.Relation = r.To, r.From, ManyToOne
+			refs = append(refs, &r)
+		}
+	}
+	for _, t := range s.Tables {
+		for _, col := range t.Columns {
+			for _, ir := range col.Refs {
+				add(Ref{From: RefEndpoint{Table: t.Name, Column: col.Name}, To: ir.To, Relation: ir.Relation})
+			}
+		}
+	}
+	for _, r := range s.Refs {
+		add(*r)
+	}
+	return refs
+}
+
 // Project holds optional project metadata from DBML.
 type Project struct {
 	Name         string
 	DatabaseType string
 	Note         string
+	MinVersion   string // min_sqlfs_version: oldest sqlfs release that may build the project
 }
 
 // Table represents a DBML Table definition.
@@ -42,6 +70,9 @@ type Table struct {
 	Note    string
 	Columns []*Column
 	Indexes []*Index
+	// Exclude is set by the [exclude] table setting: the table is documented
+	// in the schema but never built.
+	Exclude bool
 }
 
 // ColumnByName returns the column with the given name, or nil.
@@ -54,6 +85,52 @@ func (t *Table) ColumnByName(name string) *Column {
 	return nil
 }
 
+// PrimaryKey returns the columns of the table's composite primary key, declared
+// in the indexes block as (a, b) [pk], or nil if there is none.
+func (t *Table) PrimaryKey() []string {
+	for _, idx := range t.Indexes {
+		if idx.PK {
+			return idx.Columns
+		}
+	}
+	return nil
+}
+
+// InPrimaryKey reports whether the named column is part of the table's
+// composite primary key.
+func (t *Table) InPrimaryKey(name string) bool {
+	for _, c := range t.PrimaryKey() {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiryColumn returns the table's [expires] column, or nil if it has none.
+func (t *Table) ExpiryColumn() *Column {
+	for _, c := range t.Columns {
+		if c.Expires {
+			return c
+		}
+	}
+	return nil
+}
+
+// IsUnique reports whether the named column alone identifies a row: it is the
+// primary key, marked unique, or the only column of a unique or [pk] index.
+func (t *Table) IsUnique(name string) bool {
+	if col := t.ColumnByName(name); col != nil && (col.PK || col.Unique) {
+		return true
+	}
+	for _, idx := range t.Indexes {
+		if (idx.PK || idx.Unique) && !idx.IsExpr && idx.Where == "" && len(idx.Columns) == 1 && idx.Columns[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Column represents a column within a Table.
 type Column struct {
 	Name      string
@@ -65,6 +142,10 @@ type Column struct {
 	Default   *DefaultValue
 	Note      string
 	Refs      []*InlineRef
+	Collate   string // collation from [collate: name]; empty for the default
+	// Expires is set by the [expires] setting: the column holds the time
+	// after which a row is left out of builds.
+	Expires bool
 }
 
 // ColumnType is the parsed column type, e.g. varchar(255).
@@ -104,6 +185,8 @@ type Index struct {
 	PK      bool
 	Name    string
 	Type    string // e.g. "btree", "hash"
+	Where   string // partial index condition, e.g. "deleted = 0"; empty for a full index
+	Collate string // collation the indexed columns are compared with; empty for theirs
 }
 
 // Enum represents a DBML enum definition.
@@ -145,6 +228,22 @@ const (
 	ManyToMany                    // <>
 )
 
+// String returns the relation's DBML symbol, e.g. ">".
+func (r RefRelation) String() string {
+	switch r {
+	case ManyToOne:
+		return ">"
+	case OneToMany:
+		return "<"
+	case OneToOne:
+		return "-"
+	case ManyToMany:
+		return "<>"
+	default:
+		return "?"
+	}
+}
+
 // Position is a source location used in error messages.
 type Position struct {
 	Line   int
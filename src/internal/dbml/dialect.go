@@ -0,0 +1,95 @@
+package dbml
+
+import "strings"
+
+// Dialect is the target database named by a Project's database_type.
+type Dialect string
+
+const (
+	DialectGeneric    Dialect = "" // no or unrecognized database_type
+	DialectSQLite     Dialect = "sqlite"
+	DialectPostgreSQL Dialect = "postgresql"
+	DialectMySQL      Dialect = "mysql"
+)
+
+// Dialect returns the dialect declared by the Project block's database_type,
+// matched case-insensitively ("PostgreSQL", "postgres", and "pg" are all
+// PostgreSQL). Schemas without a Project, or with an unknown database_type,
+// are DialectGeneric.
+func (s *Schema) Dialect() Dialect {
+	if s.Project == nil {
+		return DialectGeneric
+	}
+	switch strings.ToLower(strings.TrimSpace(s.Project.DatabaseType)) {
+	case "sqlite", "sqlite3":
+		return DialectSQLite
+	case "postgresql", "postgres", "pg":
+		return DialectPostgreSQL
+	case "mysql", "mariadb":
+		return DialectMySQL
+	default:
+		return DialectGeneric
+	}
+}
+
+// dialectTypes lists the built-in type names of dialects that have a fixed
+// set. SQLite accepts any type name, so it and DialectGeneric are absent.
+var dialectTypes = map[Dialect]map[string]struct{}{
+	DialectPostgreSQL: typeSet(
+		"smallint", "integer", "int", "int2", "int4", "int8", "bigint",
+		"smallserial", "serial", "bigserial", "serial2", "serial4", "serial8",
+		"real", "float4", "float8", "double precision", "numeric", "decimal", "money",
+		"boolean", "bool",
+		"char", "character", "varchar", "character varying", "text", "citext", "bpchar",
+		"bytea",
+		"date", "time", "timetz", "timestamp", "timestamptz", "interval",
+		"uuid", "json", "jsonb", "xml",
+		"inet", "cidr", "macaddr", "macaddr8",
+		"point", "line", "lseg", "box", "path", "polygon", "circle",
+		"bit", "varbit", "tsvector", "tsquery",
+	),
+	DialectMySQL: typeSet(
+		"tinyint", "smallint", "mediumint", "int", "integer", "bigint",
+		"decimal", "numeric", "float", "double", "double precision", "real", "bit",
+		"bool", "boolean", "serial",
+		"date", "datetime", "timestamp", "time", "year",
+		"char", "varchar", "binary", "varbinary",
+		"tinyblob", "blob", "mediumblob", "longblob",
+		"tinytext", "text", "mediumtext", "longtext",
+		"enum", "set", "json",
+		"geometry", "point", "linestring", "polygon",
+	),
+}
+
+func typeSet(names ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		set[n] = struct{}{}
+	}
+	return set
+}
+
+// HasType reports whether typeName is a built-in type of the dialect.
+// Dialects without a fixed set of types accept every name.
+func (d Dialect) HasType(typeName string) bool {
+	set, ok := dialectTypes[d]
+	if !ok {
+		return true
+	}
+	_, known := set[strings.ToLower(typeName)]
+	return known
+}
+
+// String returns the dialect's display name.
+func (d Dialect) String() string {
+	switch d {
+	case DialectSQLite:
+		return "SQLite"
+	case DialectPostgreSQL:
+		return "PostgreSQL"
+	case DialectMySQL:
+		return "MySQL"
+	default:
+		return "generic"
+	}
+}
@@ -0,0 +1,34 @@
+package dbml
+
+import "testing"
+
+func TestSchema_Dialect(t *testing.T) {
+	cases := map[string]Dialect{
+		"PostgreSQL": DialectPostgreSQL,
+		"postgres":   DialectPostgreSQL,
+		"SQLite":     DialectSQLite,
+		"MySQL":      DialectMySQL,
+		"Oracle":     DialectGeneric,
+	}
+	for dbType, want := range cases {
+		s := &Schema{Project: &Project{DatabaseType: dbType}}
+		if got := s.Dialect(); got != want {
+			t.Errorf("Dialect(%q) = %q, want %q", dbType, got, want)
+		}
+	}
+	if got := (&Schema{}).Dialect(); got != DialectGeneric {
+		t.Errorf("no project: Dialect = %q, want generic", got)
+	}
+}
+
+func TestDialect_HasType(t *testing.T) {
+	if !DialectPostgreSQL.HasType("UUID") || DialectPostgreSQL.HasType("datetime") {
+		t.Error("PostgreSQL: want uuid known, datetime unknown")
+	}
+	if !DialectMySQL.HasType("datetime") || DialectMySQL.HasType("uuid") {
+		t.Error("MySQL: want datetime known, uuid unknown")
+	}
+	if !DialectSQLite.HasType("anything") || !DialectGeneric.HasType("anything") {
+		t.Error("SQLite and generic should accept any type")
+	}
+}
@@ -0,0 +1,29 @@
+package dbml
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// IsJSON reports whether the column has the json or jsonb type.
+func (c *Column) IsJSON() bool {
+	name := strings.ToLower(c.Type.Name)
+	return name == "json" || name == "jsonb"
+}
+
+// JSONSchema returns the JSON Schema attached to a json or jsonb column. A
+// column's schema is written as its note, which must then be a JSON object:
+//
+//	settings json [note: '{"type": "object", "required": ["theme"]}']
+//
+// It returns nil for other columns and for notes that are not a JSON object.
+func (c *Column) JSONSchema() map[string]any {
+	if !c.IsJSON() || !strings.HasPrefix(strings.TrimSpace(c.Note), "{") {
+		return nil
+	}
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(c.Note), &doc); err != nil {
+		return nil
+	}
+	return doc
+}
@@ -0,0 +1,124 @@
+package dbml
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NoteFields is the structured form of a note written as a YAML mapping,
+// usually in a triple-quoted string:
+//
+//	email varchar [note: '''
+//	  summary:
+//	    en: Contact address
+//	    fr: Adresse de contact
+//	  owner: growth
+//	  pii: true
+//	  tags: [contact, marketing]
+//	''']
+type NoteFields struct {
+	// Summary holds the summary in each locale, in the order written. A
+	// plain-text summary is one entry with an empty Locale.
+	Summary []LocalizedText
+	Owner   string
+	PII     bool
+	Tags    []string
+	// Sensitive marks the column as holding secrets; see IsSensitive.
+	Sensitive bool
+}
+
+// LocalizedText is a text in one locale, e.g. "en" or "pt-BR".
+type LocalizedText struct {
+	Locale string
+	Text   string
+}
+
+// noteKeys are the keys a structured note may have.
+type noteKeys struct {
+	Summary yaml.Node `yaml:"summary"`
+	Owner   string    `yaml:"owner"`
+	PII     bool      `yaml:"pii"`
+	Tags    []string  `yaml:"tags"`
+	// Sensitive is a bool, so that "sensitive: API token" stays text.
+	Sensitive bool `yaml:"sensitive"`
+}
+
+// ParseNote returns the fields of a structured note, or nil when note is
+// plain text: anything but a YAML mapping whose keys are all among summary,
+// owner, pii, tags and sensitive. Notes such as "sensitive: API token" stay
+// text, since sensitive must be a boolean.
+func ParseNote(note string) *NoteFields {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(note), &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	var keys noteKeys
+	dec := yaml.NewDecoder(strings.NewReader(note))
+	dec.KnownFields(true)
+	if err := dec.Decode(&keys); err != nil {
+		return nil
+	}
+
+	f := &NoteFields{Owner: keys.Owner, PII: keys.PII, Tags: keys.Tags, Sensitive: keys.Sensitive}
+	switch s := &keys.Summary; s.Kind {
+	case 0:
+	case yaml.ScalarNode:
+		f.Summary = []LocalizedText{{Text: s.Value}}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(s.Content); i += 2 {
+			if s.Content[i+1].Kind != yaml.ScalarNode {
+				return nil
+			}
+			f.Summary = append(f.Summary, LocalizedText{Locale: s.Content[i].Value, Text: s.Content[i+1].Value})
+		}
+	default:
+		return nil
+	}
+	return f
+}
+
+// SummaryIn returns the summary in locale, falling back to the locale's
+// language ("pt" for "pt-BR") and then to the first summary written. It
+// returns "" when there is no summary.
+func (f *NoteFields) SummaryIn(locale string) string {
+	if len(f.Summary) == 0 {
+		return ""
+	}
+	lang, _, _ := strings.Cut(locale, "-")
+	for _, want := range []string{locale, lang} {
+		for _, s := range f.Summary {
+			if want != "" && strings.EqualFold(s.Locale, want) {
+				return s.Text
+			}
+		}
+	}
+	return f.Summary[0].Text
+}
+
+// NoteFields returns the fields of the column's note when it is structured,
+// and nil otherwise. The JSON Schema note of a json column is not structured.
+func (c *Column) NoteFields() *NoteFields {
+	if c.Note == "" || c.JSONSchema() != nil {
+		return nil
+	}
+	return ParseNote(c.Note)
+}
+
+// Description returns the text that documents the column: the summary in
+// locale (see SummaryIn) of a structured note, or else the note itself.
+func (c *Column) Description(locale string) string {
+	if f := c.NoteFields(); f != nil {
+		return f.SummaryIn(locale)
+	}
+	return c.Note
+}
+
+// Description returns the text that documents the table, like
+// Column.Description.
+func (t *Table) Description(locale string) string {
+	if f := ParseNote(t.Note); f != nil {
+		return f.SummaryIn(locale)
+	}
+	return t.Note
+}
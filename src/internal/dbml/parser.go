@@ -132,7 +132,7 @@ func (p *parser) parseTable() (*Table, error) {
 
 	// Optional table-level settings [...]
 	if p.peek().Kind == TokLBracket {
-		if err := p.skipBracketBlock(); err != nil {
+		if err := p.parseTableSettings(tbl); err != nil {
 			return nil, err
 		}
 	}
@@ -176,6 +176,43 @@ func (p *parser) parseTable() (*Table, error) {
 	return tbl, nil
 }
 
+// parseTableSettings parses [...] table settings. Only note and exclude are
+// kept; others, such as headercolor, are skipped.
+func (p *parser) parseTableSettings(tbl *Table) error {
+	p.next() // consume [
+	for p.peek().Kind != TokRBracket && p.peek().Kind != TokEOF {
+		t := p.peek()
+		switch {
+		case t.Kind == TokIdent && strings.ToLower(t.Value) == "exclude":
+			p.next()
+			tbl.Exclude = true
+		case t.Kind == TokIdent && strings.ToLower(t.Value) == "note":
+			p.next()
+			if _, err := p.expect(TokColon); err != nil {
+				return err
+			}
+			note, err := p.expectString()
+			if err != nil {
+				return err
+			}
+			tbl.Note = note
+		default:
+			// Unknown setting — skip until comma or ]
+			p.next()
+			for p.peek().Kind != TokComma && p.peek().Kind != TokRBracket && p.peek().Kind != TokEOF {
+				p.next()
+			}
+		}
+		if p.peek().Kind == TokComma {
+			p.next()
+		}
+	}
+	if _, err := p.expect(TokRBracket); err != nil {
+		return err
+	}
+	return nil
+}
+
 // parseColumn parses a single column definition.
 func (p *parser) parseColumn() (*Column, error) {
 	col := &Column{}
@@ -266,6 +303,9 @@ func (p *parser) parseColumnSettings(col *Column) error {
 		case "increment":
 			p.next()
 			col.Increment = true
+		case "expires":
+			p.next()
+			col.Expires = true
 		case "note":
 			p.next()
 			if _, err := p.expect(TokColon); err != nil {
@@ -296,6 +336,16 @@ func (p *parser) parseColumnSettings(col *Column) error {
 				return err
 			}
 			col.Refs = append(col.Refs, ref)
+		case "collate":
+			p.next()
+			if _, err := p.expect(TokColon); err != nil {
+				return err
+			}
+			name, err := p.expectCollation()
+			if err != nil {
+				return err
+			}
+			col.Collate = name
 		default:
 			// Unknown setting — skip until comma or ]
 			p.next()
@@ -403,6 +453,15 @@ func (p *parser) parseRefEndpoint() (RefEndpoint, error) {
 	return ep, nil
 }
 
+// expectCollation consumes a collation name, written bare or quoted.
+func (p *parser) expectCollation() (string, error) {
+	t := p.next()
+	if t.Kind != TokIdent && t.Kind != TokString {
+		return "", p.parseError(t, fmt.Sprintf("expected collation name, got %q", t.Value))
+	}
+	return t.Value, nil
+}
+
 func (p *parser) parseIndexes() ([]*Index, error) {
 	if _, err := p.expect(TokLBrace); err != nil {
 		return nil, err
@@ -485,6 +544,22 @@ func (p *parser) parseIndex() (*Index, error) {
 					return nil, err
 				}
 				idx.Type = tp
+			case "where":
+				p.next()
+				p.next() // :
+				cond := p.next()
+				if cond.Kind != TokBacktick && cond.Kind != TokString {
+					return nil, p.parseError(cond, fmt.Sprintf("expected backtick expression or string for index where, got %q", cond.Value))
+				}
+				idx.Where = cond.Value
+			case "collate":
+				p.next()
+				p.next() // :
+				name, err := p.expectCollation()
+				if err != nil {
+					return nil, err
+				}
+				idx.Collate = name
 			default:
 				p.next()
 			}
@@ -693,6 +768,16 @@ func (p *parser) parseProject() (*Project, error) {
 				return nil, err
 			}
 			proj.DatabaseType = val
+		case "min_sqlfs_version":
+			p.next()
+			if _, err := p.expect(TokColon); err != nil {
+				return nil, err
+			}
+			val, err := p.expectString()
+			if err != nil {
+				return nil, err
+			}
+			proj.MinVersion = val
 		case "note":
 			p.next()
 			val, err := p.parseNoteValue()
@@ -789,19 +874,3 @@ func (p *parser) skipBlock() error {
 	}
 	return fmt.Errorf("unclosed block")
 }
-
-func (p *parser) skipBracketBlock() error {
-	depth := 0
-	for p.peek().Kind != TokEOF {
-		t := p.next()
-		if t.Kind == TokLBracket {
-			depth++
-		} else if t.Kind == TokRBracket {
-			depth--
-			if depth == 0 {
-				return nil
-			}
-		}
-	}
-	return fmt.Errorf("unclosed bracket block")
-}
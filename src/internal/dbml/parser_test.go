@@ -208,10 +208,40 @@ Table posts {
 	}
 }
 
+func TestParse_IndexTypeAndWhere(t *testing.T) {
+	src := `
+Table posts {
+  id integer [pk]
+  slug varchar
+  status varchar
+
+  indexes {
+    slug [type: hash, where: ` + "`status = 'published'`" + `]
+    status [where: 'status IS NOT NULL']
+  }
+}
+`
+	schema, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	idxs := schema.Tables[0].Indexes
+	if idxs[0].Type != "hash" {
+		t.Errorf("idx[0] type = %q, want hash", idxs[0].Type)
+	}
+	if idxs[0].Where != "status = 'published'" {
+		t.Errorf("idx[0] where = %q", idxs[0].Where)
+	}
+	if idxs[1].Where != "status IS NOT NULL" {
+		t.Errorf("idx[1] where = %q", idxs[1].Where)
+	}
+}
+
 func TestParse_Project(t *testing.T) {
 	src := `
 Project myapp {
   database_type: 'SQLite'
+  min_sqlfs_version: '0.1.0'
   Note: 'My application'
 }
 `
@@ -225,6 +255,12 @@ Project myapp {
 	if schema.Project.DatabaseType != "SQLite" {
 		t.Errorf("DatabaseType = %q", schema.Project.DatabaseType)
 	}
+	if schema.Project.MinVersion != "0.1.0" {
+		t.Errorf("MinVersion = %q", schema.Project.MinVersion)
+	}
+	if schema.Project.Note != "My application" {
+		t.Errorf("Note = %q", schema.Project.Note)
+	}
 }
 
 func TestParse_Comments(t *testing.T) {
@@ -260,6 +296,47 @@ func TestParse_QuotedNames(t *testing.T) {
 	}
 }
 
+func TestParse_Collate(t *testing.T) {
+	src := `Table t {
+  email varchar [collate: nocase]
+  name varchar [not null, collate: "unicode_nocase"]
+  indexes {
+    email [unique, collate: rtrim]
+  }
+}`
+	schema, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tbl := schema.Tables[0]
+	if got := tbl.Columns[0].Collate; got != "nocase" {
+		t.Errorf("email collate = %q", got)
+	}
+	if got := tbl.Columns[1].Collate; got != "unicode_nocase" || !tbl.Columns[1].NotNull {
+		t.Errorf("name collate = %q, not null = %v", got, tbl.Columns[1].NotNull)
+	}
+	if got := tbl.Indexes[0].Collate; got != "rtrim" || !tbl.Indexes[0].Unique {
+		t.Errorf("index collate = %q, unique = %v", got, tbl.Indexes[0].Unique)
+	}
+}
+
+func TestParse_Expires(t *testing.T) {
+	schema, err := Parse([]byte(`Table promos {
+  code varchar [pk]
+  ends_at timestamp [expires, not null]
+}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tbl := schema.Tables[0]
+	if col := tbl.ExpiryColumn(); col == nil || col.Name != "ends_at" || !col.NotNull {
+		t.Errorf("ExpiryColumn = %+v, want ends_at", col)
+	}
+	if tbl.Columns[0].Expires {
+		t.Error("code: unexpected Expires")
+	}
+}
+
 func TestParse_BacktickDefault(t *testing.T) {
 	src := `Table t { created_at timestamp [default: ` + "`now()`" + `] }`
 	schema, err := Parse([]byte(src))
@@ -332,3 +409,116 @@ Ref r1: B.a_id > A.id
 		t.Errorf("expected 1 inline ref on a_id")
 	}
 }
+
+func TestColumn_IsSensitive(t *testing.T) {
+	for note, want := range map[string]bool{
+		"sensitive":                  true,
+		"Sensitive: contact address": true,
+		"  SENSITIVE, rotate yearly": true,
+		"":                           false,
+		"not sensitive":              false,
+		"sensitivity level":          false,
+	} {
+		c := &Column{Note: note}
+		if got := c.IsSensitive(); got != want {
+			t.Errorf("IsSensitive(note %q) = %v, want %v", note, got, want)
+		}
+	}
+
+	// Structured and JSON Schema notes mark columns sensitive too.
+	for note, want := range map[string]bool{
+		"summary: API token\nsensitive: true":     true,
+		"summary: API token\npii: true":           false,
+		`{"type": "object", "x-sensitive": true}`: true,
+		`{"type": "object"}`:                      false,
+	} {
+		c := &Column{Type: ColumnType{Name: "json"}, Note: note}
+		if got := c.IsSensitive(); got != want {
+			t.Errorf("IsSensitive(note %q) = %v, want %v", note, got, want)
+		}
+	}
+}
+
+func TestParseNote(t *testing.T) {
+	f := ParseNote("summary:\n  en: Contact address\n  fr: Adresse de contact\nowner: growth\npii: true\ntags: [contact, marketing]\n")
+	if f == nil {
+		t.Fatal("ParseNote returned nil for a structured note")
+	}
+	if f.Owner != "growth" || !f.PII || len(f.Tags) != 2 || f.Tags[1] != "marketing" {
+		t.Errorf("fields = %+v", f)
+	}
+	for locale, want := range map[string]string{
+		"fr":    "Adresse de contact",
+		"fr-CA": "Adresse de contact",
+		"de":    "Contact address",
+		"":      "Contact address",
+	} {
+		if got := f.SummaryIn(locale); got != want {
+			t.Errorf("SummaryIn(%q) = %q, want %q", locale, got, want)
+		}
+	}
+
+	if f := ParseNote("summary: Primary email"); f == nil || f.SummaryIn("fr") != "Primary email" {
+		t.Errorf("plain summary = %+v", f)
+	}
+	for _, note := range []string{"Primary email", "sensitive: API token", "summary: [a, b]", "- summary"} {
+		if f := ParseNote(note); f != nil {
+			t.Errorf("ParseNote(%q) = %+v, want nil", note, f)
+		}
+	}
+}
+
+func TestColumn_Description(t *testing.T) {
+	s, err := Parse([]byte(`
+Table users {
+  id integer [pk]
+  email varchar [note: '''
+    summary:
+      en: Contact address
+      fr: Adresse de contact
+    pii: true
+  ''']
+  name varchar [note: 'Display name']
+  Note: '''
+    summary:
+      en: People
+      fr: Personnes
+  '''
+}
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	users := s.TableByName("users")
+	if got := users.ColumnByName("email").Description("fr"); got != "Adresse de contact" {
+		t.Errorf("email description = %q", got)
+	}
+	if got := users.ColumnByName("name").Description("fr"); got != "Display name" {
+		t.Errorf("name description = %q", got)
+	}
+	if got := users.Description("fr"); got != "Personnes" {
+		t.Errorf("table description = %q", got)
+	}
+}
+
+func TestParse_TableSettings(t *testing.T) {
+	src := `
+Table users [headercolor: #3498DB, note: 'People'] {
+  id integer [pk]
+}
+Table audit_log [exclude] {
+  id integer [pk]
+}
+`
+	s, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	users, audit := s.TableByName("users"), s.TableByName("audit_log")
+	if users.Note != "People" || users.Exclude {
+		t.Errorf("users: note %q, exclude %v", users.Note, users.Exclude)
+	}
+	if !audit.Exclude {
+		t.Error("audit_log: want Exclude set")
+	}
+}
@@ -0,0 +1,23 @@
+package dbml
+
+import "strings"
+
+// IsSensitive reports whether the column is marked as holding secrets by a
+// note starting with the word "sensitive", by "sensitive: true" in a
+// structured note, or by "x-sensitive": true in the JSON Schema note of a
+// json column:
+//
+//	api_token varchar [note: 'sensitive']
+//	email varchar [note: 'Sensitive: contact address']
+//	keys json [note: '{"type": "object", "x-sensitive": true}']
+func (c *Column) IsSensitive() bool {
+	if doc := c.JSONSchema(); doc != nil {
+		sensitive, _ := doc["x-sensitive"].(bool)
+		return sensitive
+	}
+	if f := c.NoteFields(); f != nil {
+		return f.Sensitive
+	}
+	word, _, _ := strings.Cut(strings.TrimSpace(c.Note), " ")
+	return strings.EqualFold(strings.TrimRight(word, ":,.;"), "sensitive")
+}
@@ -0,0 +1,257 @@
+// Package encrypt wraps build artifacts in an AES-256-GCM container so that
+// output databases can be kept encrypted at rest.
+//
+// Container layout:
+//
+//	magic (8 bytes, "SQLFSENC") | version (1 byte) | salt (16 bytes) | nonce prefix (7 bytes) | chunks
+//
+// The 256-bit key is derived from the passphrase supplied by the caller and
+// the container's random salt with argon2id, so any non-empty string may be
+// used as a key, and guessing it takes a costly derivation per guess. The
+// contents are sealed in chunks of 64 KiB, each with a nonce made of the
+// prefix, the chunk's index, and a flag set on the last chunk, so that
+// containers can be written and read as streams and truncation is detected.
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	version byte = 1
+
+	saltSize        = 16
+	noncePrefixSize = 7
+	chunkSize       = 64 << 10
+
+	// argon2id parameters, the second recommended option of RFC 9106.
+	argonTime    = 3
+	argonMemory  = 64 << 10 // KiB
+	argonThreads = 4
+)
+
+var magic = []byte("SQLFSENC")
+
+// ErrNotEncrypted is returned by Decrypt when the input lacks the container header.
+var ErrNotEncrypted = errors.New("data is not an sqlfs encrypted container")
+
+var errDecrypt = errors.New("decryption failed: wrong key or corrupted data")
+
+// Encrypt seals plaintext with a key derived from passphrase.
+func Encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decrypt opens a container produced by Encrypt or a Writer.
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// IsEncrypted reports whether data starts with the container header.
+func IsEncrypted(data []byte) bool {
+	return len(data) > len(magic) && bytes.Equal(data[:len(magic)], magic)
+}
+
+// Writer seals what is written to it into a container. Close must be called
+// to write the last chunk; the container is incomplete until then.
+type Writer struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint32
+	buf    []byte
+	closed bool
+}
+
+// NewWriter returns a Writer writing a container sealed with a key derived
+// from passphrase to w.
+func NewWriter(w io.Writer, passphrase string) (*Writer, error) {
+	if passphrase == "" {
+		return nil, errors.New("encryption key is empty")
+	}
+	header := make([]byte, 0, len(magic)+1+saltSize+noncePrefixSize)
+	header = append(header, magic...)
+	header = append(header, version)
+	salt := make([]byte, saltSize+noncePrefixSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+	header = append(header, salt...)
+
+	gcm, err := newGCM(deriveKey(passphrase, salt[:saltSize]))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, salt[saltSize:])
+	return &Writer{w: w, gcm: gcm, header: header, nonce: nonce, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// Write buffers p, sealing and writing each full chunk once more follows it.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypt.Writer")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		c := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+	}
+	return n, nil
+}
+
+// Close seals and writes the last chunk. It does not close the underlying
+// writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *Writer) flush(last bool) error {
+	sealed := w.gcm.Seal(nil, chunkNonce(w.nonce, w.index, last), w.buf, w.header)
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// NewReader returns a reader of the plaintext of the container read from r.
+// An error reading the container, including a wrong key, is returned by the
+// reader's Read.
+func NewReader(r io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(magic) + 1)
+	if err != nil || !IsEncrypted(head) {
+		return nil, ErrNotEncrypted
+	}
+	if passphrase == "" {
+		return nil, errors.New("encryption key is empty")
+	}
+	if v := head[len(magic)]; v != version {
+		return nil, fmt.Errorf("unsupported container version %d", v)
+	}
+
+	header := make([]byte, len(magic)+1+saltSize+noncePrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, errors.New("encrypted container is truncated")
+	}
+	salt := header[len(magic)+1:]
+	gcm, err := newGCM(deriveKey(passphrase, salt[:saltSize]))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, salt[saltSize:])
+	return &reader{r: br, gcm: gcm, header: header, nonce: nonce, chunk: make([]byte, chunkSize+gcm.Overhead())}, nil
+}
+
+type reader struct {
+	r      *bufio.Reader
+	gcm    cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint32
+	chunk  []byte
+	plain  []byte
+	done   bool
+	err    error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk. A chunk is the last when nothing
+// follows it, which its nonce must confirm.
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.chunk)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	last := n < len(r.chunk)
+	if !last {
+		if _, err := r.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+	plain, err := r.gcm.Open(r.chunk[:0:0], chunkNonce(r.nonce, r.index, last), r.chunk[:n], r.header)
+	if err != nil {
+		return errDecrypt
+	}
+	r.index++
+	r.plain = plain
+	r.done = last
+	return nil
+}
+
+// chunkNonce returns the nonce of the chunk at index: the container's nonce
+// prefix, then index, then whether it is the last chunk.
+func chunkNonce(nonce []byte, index uint32, last bool) []byte {
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, 32)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,109 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEncryptDecrypt_RoundTrip(t *testing.T) {
+	plain := []byte("SQLite format 3\x00 some database bytes")
+
+	sealed, err := Encrypt(plain, "s3cret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(sealed) {
+		t.Error("IsEncrypted = false for sealed data")
+	}
+	if bytes.Contains(sealed, []byte("some database bytes")) {
+		t.Error("ciphertext contains plaintext")
+	}
+
+	got, err := Decrypt(sealed, "s3cret")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Decrypt = %q, want %q", got, plain)
+	}
+}
+
+func TestDecrypt_WrongKey(t *testing.T) {
+	sealed, err := Encrypt([]byte("data"), "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(sealed, "wrong"); err == nil {
+		t.Fatal("expected error for wrong key")
+	}
+}
+
+func TestDecrypt_NotEncrypted(t *testing.T) {
+	_, err := Decrypt([]byte("SQLite format 3\x00"), "key")
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("err = %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestEncrypt_EmptyKey(t *testing.T) {
+	if _, err := Encrypt([]byte("data"), ""); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+}
+
+func TestWriter_Chunks(t *testing.T) {
+	// Several chunks, the last one partial, written in uneven pieces.
+	plain := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/16*3+5)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 1000)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(sealed), "k")
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("read %d bytes, want %d", len(got), len(plain))
+	}
+
+	// Dropping the last chunk, or any bytes, is detected.
+	for _, n := range []int{len(sealed) - 1, len(sealed) - (len(plain)%chunkSize + 16)} {
+		if _, err := Decrypt(sealed[:n], "k"); err == nil {
+			t.Errorf("no error decrypting the first %d of %d bytes", n, len(sealed))
+		}
+	}
+}
+
+func TestEncrypt_Salted(t *testing.T) {
+	a, err := Encrypt([]byte("data"), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Encrypt([]byte("data"), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := len(magic) + 1 + saltSize
+	if bytes.Equal(a[:header], b[:header]) {
+		t.Error("two containers share their salt")
+	}
+}
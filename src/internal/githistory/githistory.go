@@ -0,0 +1,81 @@
+// Package githistory reads when files were last changed from git history,
+// which unlike file modification times survives a fresh checkout.
+package githistory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModTimes returns the time of the last commit touching each file under dir
+// that is tracked by git, keyed by its slash-separated path relative to dir.
+// Files with uncommitted changes are left out, as their latest change is not
+// in history yet.
+func ModTimes(ctx context.Context, dir string) (map[string]time.Time, error) {
+	times := make(map[string]time.Time)
+	if _, err := git(ctx, dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, err
+	}
+	if _, err := git(ctx, dir, "rev-parse", "--quiet", "--verify", "HEAD"); err != nil {
+		return times, nil // no commits yet
+	}
+
+	// Commits come newest first, each as a NUL-prefixed committer time
+	// followed by the names of the files it changed.
+	out, err := git(ctx, dir, "log", "--format=%x00%ct", "--name-only", "--no-renames", "--relative", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	var at time.Time
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if ts, ok := strings.CutPrefix(line, "\x00"); ok {
+			secs, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("git log: unexpected commit time %q", ts)
+			}
+			at = time.Unix(secs, 0)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if _, seen := times[line]; !seen {
+			times[line] = at
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	dirty, err := git(ctx, dir, "diff", "--name-only", "--relative", "HEAD", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(string(dirty), "\n") {
+		delete(times, name)
+	}
+	return times, nil
+}
+
+// git runs git with args in dir and returns its standard output.
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir, "-c", "core.quotepath=off"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,66 @@
+package githistory
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModTimes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	dir := filepath.Join(repo, "data")
+	os.MkdirAll(filepath.Join(dir, "users"), 0755)
+	run := func(date string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("", "init", "-q")
+	if times, err := ModTimes(context.Background(), dir); err != nil || len(times) != 0 {
+		t.Fatalf("ModTimes without commits = %v, %v; want none", times, err)
+	}
+
+	write("data/users/alice.users.yaml", "name: Alice\n")
+	write("data/bob.users.yaml", "name: Bob\n")
+	write("outside.yaml", "x: 1\n")
+	run("2020-01-02T03:04:05Z", "add", ".")
+	run("2020-01-02T03:04:05Z", "commit", "-q", "-m", "first")
+	write("data/bob.users.yaml", "name: Robert\n")
+	run("2021-06-07T08:09:10Z", "commit", "-q", "-am", "second")
+	write("data/users/alice.users.yaml", "name: Alicia\n") // uncommitted
+
+	times, err := ModTimes(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("ModTimes: %v", err)
+	}
+	want := map[string]time.Time{"bob.users.yaml": time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)}
+	if len(times) != len(want) {
+		t.Errorf("ModTimes = %v, want %v", times, want)
+	}
+	for name, at := range want {
+		if !times[name].Equal(at) {
+			t.Errorf("%s: %v, want %v", name, times[name], at)
+		}
+	}
+
+	if _, err := ModTimes(context.Background(), t.TempDir()); err == nil {
+		t.Error("ModTimes outside a git repository: expected error")
+	}
+}
@@ -0,0 +1,565 @@
+// Package httpserver serves built databases as a read-only HTTP JSON API, for
+// clients such as web apps that would rather not use a PostgreSQL driver. It
+// serves the databases of a pgserver.Server, with the same logins, row
+// filters, and allowed queries.
+package httpserver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/pgserver"
+	"github.com/notwillk/sqlfs/internal/validator"
+)
+
+// DefaultPageSize is the number of rows GET /tables/{name}/rows returns when
+// the request sets no limit, or Options.MaxResultRows if that is smaller.
+const DefaultPageSize = 100
+
+// Options configures the HTTP server.
+type Options struct {
+	// Addrs are the TCP addresses to listen on, e.g. ":8080".
+	Addrs []string
+	// Backend is the SQL server whose databases, users, and allowed queries
+	// are served. When it serves several databases, requests choose one
+	// with the "database" query parameter.
+	Backend *pgserver.Server
+	// MaxResultRows caps the rows one request may return. Zero means
+	// unlimited.
+	MaxResultRows int
+	// CursorColumn is the column GET /tables/{name}/rows pages through with
+	// the "after" parameter in tables that have it, typically the ULID
+	// standard column. Empty pages with "offset" only.
+	CursorColumn string
+}
+
+// Server is a read-only HTTP JSON API backed by SQLite. It serves:
+//
+//	GET  /tables              the tables and their columns
+//	GET  /tables/{name}/rows  a table's rows, filtered and paginated
+//	POST /query               the result of a read-only SQL query
+//	GET  /warnings            the validation warnings of the served build
+type Server struct {
+	opts     Options
+	mu       sync.RWMutex
+	warnings map[string]buildWarnings // keyed by database name; "" in single-database mode
+	listener net.Listener             // the first of Addrs
+	http     *http.Server
+}
+
+// New creates a new Server. Call Serve to start accepting requests.
+func New(opts Options) (*Server, error) {
+	if opts.Backend == nil {
+		return nil, errors.New("no backend to serve")
+	}
+	s := &Server{opts: opts, warnings: make(map[string]buildWarnings)}
+	s.http = &http.Server{Handler: s.Handler()}
+	return s, nil
+}
+
+// Handler returns the API's routes, with authentication applied.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tables", s.handleTables)
+	mux.HandleFunc("GET /tables/{name}/rows", s.handleRows)
+	mux.HandleFunc("POST /query", s.handleQuery)
+	mux.HandleFunc("GET /warnings", s.handleWarnings)
+	return s.authenticate(mux)
+}
+
+// Serve listens on every one of Addrs and blocks until ctx is cancelled or
+// one of the listeners fails.
+func (s *Server) Serve(ctx context.Context) error {
+	if len(s.opts.Addrs) == 0 {
+		return errors.New("no address to listen on")
+	}
+	lns := make([]net.Listener, 0, len(s.opts.Addrs))
+	for _, addr := range s.opts.Addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return fmt.Errorf("listen %s: %w", addr, err)
+		}
+		lns = append(lns, ln)
+	}
+	s.listener = lns[0]
+
+	go func() {
+		<-ctx.Done()
+		s.http.Close()
+	}()
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			errc <- s.http.Serve(ln)
+		}(ln)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		s.http.Close()
+		return err
+	}
+	return nil
+}
+
+// Addr returns the address the server is listening on (after Serve is called via a goroutine).
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// SetWarnings sets the validation warnings GET /warnings reports for the
+// database served under name, those of its build at builtAt. Call it
+// whenever the database is built or reloaded.
+func (s *Server) SetWarnings(name string, builtAt time.Time, warnings []validator.ValidationError) {
+	s.mu.Lock()
+	s.warnings[name] = buildWarnings{builtAt: builtAt, warnings: warnings}
+	s.mu.Unlock()
+}
+
+// Close shuts down the server. The backend is left open.
+func (s *Server) Close() error {
+	return s.http.Close()
+}
+
+// userKey is the context key of the user a request authenticated as.
+type userKey struct{}
+
+// authenticate requires HTTP basic auth as one of the backend's logins when
+// it has any, and records the user in the request's context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if !s.opts.Backend.AuthRequired() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !s.opts.Backend.Authenticate(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="sqlfs"`)
+			writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// requestUser returns the user the request authenticated as, "" when no
+// login is required.
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// requestDatabase returns the name of the database the request's "database"
+// parameter names, "" in single-database mode, replying with an error and
+// returning false when it is missing or unknown.
+func (s *Server) requestDatabase(w http.ResponseWriter, r *http.Request) (string, bool) {
+	requested := r.URL.Query().Get("database")
+	name, ok := s.opts.Backend.ResolveDatabase(requested)
+	if !ok {
+		if requested == "" {
+			writeError(w, http.StatusBadRequest, errors.New("the database parameter is required"))
+		} else {
+			writeError(w, http.StatusNotFound, fmt.Errorf("database %q does not exist", requested))
+		}
+		return "", false
+	}
+	return name, true
+}
+
+// requestConn returns a connection to the database the request's "database"
+// parameter names, as the request's user sees it, replying with an error and
+// returning nil when there is none. The connection refuses writes, even to
+// temporary tables, until it is passed to releaseConn.
+func (s *Server) requestConn(w http.ResponseWriter, r *http.Request) *sql.Conn {
+	name, ok := s.requestDatabase(w, r)
+	if !ok {
+		return nil
+	}
+	conn, err := s.opts.Backend.Conn(r.Context(), name, requestUser(r))
+	if err == nil {
+		if _, err = conn.ExecContext(r.Context(), "PRAGMA query_only = 1"); err != nil {
+			releaseConn(conn)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil
+	}
+	return conn
+}
+
+// releaseConn returns a connection from requestConn to the backend's pool,
+// which is shared with SQL clients, discarding it if it cannot be made
+// writable again.
+func releaseConn(conn *sql.Conn) {
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = 0"); err != nil {
+		conn.Raw(func(any) error { return driver.ErrBadConn }) //nolint:errcheck
+	}
+	conn.Close()
+}
+
+// column describes a column in GET /tables.
+type column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	PK   bool   `json:"pk"`
+}
+
+// table describes a table in GET /tables.
+type table struct {
+	Name    string   `json:"name"`
+	Columns []column `json:"columns"`
+}
+
+func (s *Server) handleTables(w http.ResponseWriter, r *http.Request) {
+	db := s.requestConn(w, r)
+	if db == nil {
+		return
+	}
+	defer releaseConn(db)
+	names, err := tableNames(r.Context(), db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tables := make([]table, 0, len(names))
+	for _, name := range names {
+		cols, err := tableColumns(r.Context(), db, name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		tables = append(tables, table{Name: name, Columns: cols})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tables": tables})
+}
+
+// Query parameters of GET /tables/{name}/rows other than column filters.
+var pageParams = map[string]bool{"database": true, "limit": true, "offset": true, "order": true, "after": true}
+
+// handleRows answers GET /tables/{name}/rows. Every query parameter other than
+// those in pageParams names a column whose value must equal the parameter's;
+// a column given several times matches any of its values. "order" sorts by a
+// column, descending when prefixed with "-", and "limit" and "offset" page
+// through the rows.
+//
+// Tables with Options.CursorColumn are sorted by it unless "order" or
+// "offset" is given, and "after" then returns the rows following the one
+// with that cursor, so that paging through a large table does not scan the
+// rows skipped by an offset. A page that may be followed by more rows links
+// to the next one with a Link header.
+func (s *Server) handleRows(w http.ResponseWriter, r *http.Request) {
+	db := s.requestConn(w, r)
+	if db == nil {
+		return
+	}
+	defer releaseConn(db)
+	name := r.PathValue("name")
+	cols, err := tableColumns(r.Context(), db, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(cols) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("table %q does not exist", name))
+		return
+	}
+	known := make(map[string]bool, len(cols))
+	cursor := -1 // the index of CursorColumn in cols
+	for i, c := range cols {
+		known[c.Name] = true
+		if c.Name == s.opts.CursorColumn {
+			cursor = i
+		}
+	}
+
+	params := r.URL.Query()
+	var where []string
+	var args []any
+	for key, values := range params {
+		if pageParams[key] {
+			continue
+		}
+		if !known[key] {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("table %q has no column %q", name, key))
+			return
+		}
+		marks := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		where = append(where, fmt.Sprintf("%s IN (%s)", quoteIdent(key), marks))
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+
+	keyset := cursor >= 0 && !params.Has("order") && !params.Has("offset")
+	if params.Has("after") {
+		if !keyset {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("after needs a table with a %s column and cannot be combined with order or offset", s.opts.CursorColumn))
+			return
+		}
+		where = append(where, quoteIdent(s.opts.CursorColumn)+" > ?")
+		args = append(args, params.Get("after"))
+	}
+
+	// Select the columns by name so that the cursor is at its index.
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = quoteIdent(c.Name)
+	}
+	query := "SELECT " + strings.Join(names, ", ") + " FROM " + quoteIdent(name)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if keyset {
+		query += " ORDER BY " + quoteIdent(s.opts.CursorColumn)
+	} else if order := params.Get("order"); order != "" {
+		col, desc := strings.CutPrefix(order, "-")
+		if !known[col] {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("table %q has no column %q", name, col))
+			return
+		}
+		query += " ORDER BY " + quoteIdent(col)
+		if desc {
+			query += " DESC"
+		}
+	}
+
+	maxRows := s.opts.MaxResultRows
+	pageSize := DefaultPageSize
+	if maxRows > 0 && maxRows < pageSize {
+		pageSize = maxRows
+	}
+	limit, err := intParam(params, "limit", pageSize)
+	if err == nil && maxRows > 0 && limit > maxRows {
+		err = fmt.Errorf("limit may be at most %d", maxRows)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := intParam(params, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// One row more than the page tells whether another page follows.
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit+1, offset)
+
+	res, err := runQuery(r.Context(), db, query, args, 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	more := len(res.Rows) > limit
+	if more {
+		res.Rows = res.Rows[:limit]
+	}
+	if more && limit > 0 {
+		next := r.URL.Query()
+		if keyset {
+			next.Set("after", fmt.Sprint(res.Rows[limit-1][cursor]))
+		} else {
+			next.Set("offset", strconv.Itoa(offset+limit))
+		}
+		u := *r.URL
+		u.RawQuery = next.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// queryRequest is the body of POST /query.
+type queryRequest struct {
+	SQL  string `json:"sql"`
+	Args []any  `json:"args"`
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("sql is required"))
+		return
+	}
+	if !s.opts.Backend.QueryAllowed(req.SQL) {
+		writeError(w, http.StatusForbidden, errors.New("query is not in the allowed list"))
+		return
+	}
+	db := s.requestConn(w, r)
+	if db == nil {
+		return
+	}
+	defer releaseConn(db)
+	res, err := runQuery(r.Context(), db, req.SQL, req.Args, s.opts.MaxResultRows)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// buildWarnings are the validation warnings of a database's build.
+type buildWarnings struct {
+	builtAt  time.Time
+	warnings []validator.ValidationError
+}
+
+// warning describes a validation warning in GET /warnings.
+type warning struct {
+	File    string `json:"file"`
+	Record  string `json:"record"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// handleWarnings answers GET /warnings with the validation warnings of the
+// build being served, as last set by SetWarnings. Warnings quote records of
+// every row, so users with row filters may not read them.
+func (s *Server) handleWarnings(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Backend.RowFiltered(requestUser(r)) {
+		writeError(w, http.StatusForbidden, errors.New("this user may not read warnings"))
+		return
+	}
+	name, ok := s.requestDatabase(w, r)
+	if !ok {
+		return
+	}
+	s.mu.RLock()
+	bw := s.warnings[name]
+	s.mu.RUnlock()
+
+	warnings := make([]warning, len(bw.warnings))
+	for i, vw := range bw.warnings {
+		warnings[i] = warning{File: vw.FilePath, Record: vw.RecordKey, Field: vw.Field, Message: vw.Message}
+	}
+	resp := map[string]any{"warnings": warnings}
+	if !bw.builtAt.IsZero() {
+		resp["built_at"] = bw.builtAt.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// result is the response of the row and query endpoints. Each row lists its
+// values in the order of Columns.
+type result struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// runQuery runs query and collects its rows. A result of more than maxRows
+// rows is an error; zero means unlimited.
+func runQuery(ctx context.Context, db *sql.Conn, query string, args []any, maxRows int) (*result, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &result{Columns: cols, Rows: [][]any{}}
+	for rows.Next() {
+		if maxRows > 0 && len(res.Rows) == maxRows {
+			return nil, fmt.Errorf("result exceeds %d rows", maxRows)
+		}
+		row := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, rows.Err()
+}
+
+// tableNames returns the names of db's tables, leaving out SQLite's own.
+func tableNames(ctx context.Context, db *sql.Conn) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// tableColumns returns the columns of the named table, or none when there is
+// no such table.
+func tableColumns(ctx context.Context, db *sql.Conn, name string) ([]column, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, type, pk FROM pragma_table_info(?)`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []column
+	for rows.Next() {
+		var c column
+		var pk int
+		if err := rows.Scan(&c.Name, &c.Type, &pk); err != nil {
+			return nil, err
+		}
+		c.PK = pk > 0
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+// intParam returns the non-negative integer query parameter key, or def when
+// it is not set.
+func intParam(params map[string][]string, key string, def int) (int, error) {
+	v := ""
+	if vs := params[key]; len(vs) > 0 {
+		v = vs[0]
+	}
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,429 @@
+package httpserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notwillk/sqlfs/internal/pgserver"
+	"github.com/notwillk/sqlfs/internal/validator"
+)
+
+// testDB writes a database with a users table to a temp file.
+func testDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, role TEXT)`,
+		`INSERT INTO users VALUES (1, 'alice', 'admin'), (2, 'bob', 'user'), (3, 'carol', 'user')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// backend creates the SQL server whose databases a test serves.
+func backend(t *testing.T, opts pgserver.Options) *pgserver.Server {
+	t.Helper()
+	srv, err := pgserver.New(opts)
+	if err != nil {
+		t.Fatalf("pgserver.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func startTestServer(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	srv, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return ts
+}
+
+// get requests path and decodes the JSON response into v, returning the
+// status code.
+func get(t *testing.T, ts *httptest.Server, path string, v any) int {
+	t.Helper()
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func post(t *testing.T, ts *httptest.Server, body string, v any) int {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/query", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding /query: %v", err)
+	}
+	return resp.StatusCode
+}
+
+// names returns the name of each row.
+func names(res result) string {
+	col := -1
+	for i, c := range res.Columns {
+		if c == "name" {
+			col = i
+		}
+	}
+	var out []string
+	for _, row := range res.Rows {
+		out = append(out, row[col].(string))
+	}
+	return strings.Join(out, ",")
+}
+
+func TestServer_Tables(t *testing.T) {
+	ts := startTestServer(t, Options{Backend: backend(t, pgserver.Options{DBPath: testDB(t)})})
+
+	var got struct{ Tables []table }
+	if code := get(t, ts, "/tables", &got); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if len(got.Tables) != 1 || got.Tables[0].Name != "users" {
+		t.Fatalf("tables = %+v", got.Tables)
+	}
+	want := []column{{"id", "INTEGER", true}, {"name", "TEXT", false}, {"role", "TEXT", false}}
+	for i, c := range got.Tables[0].Columns {
+		if c != want[i] {
+			t.Errorf("column %d = %+v, want %+v", i, c, want[i])
+		}
+	}
+}
+
+func TestServer_Rows(t *testing.T) {
+	ts := startTestServer(t, Options{Backend: backend(t, pgserver.Options{DBPath: testDB(t)}), MaxResultRows: 10})
+
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/tables/users/rows", "alice,bob,carol"},
+		{"/tables/users/rows?role=user", "bob,carol"},
+		{"/tables/users/rows?name=alice&name=carol", "alice,carol"},
+		{"/tables/users/rows?order=-name&limit=2", "carol,bob"},
+		{"/tables/users/rows?order=name&limit=2&offset=2", "carol"},
+	} {
+		var res result
+		if code := get(t, ts, tc.path, &res); code != http.StatusOK {
+			t.Errorf("%s: status = %d", tc.path, code)
+			continue
+		}
+		if got := names(res); got != tc.want {
+			t.Errorf("%s: rows = %s, want %s", tc.path, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/tables/nope/rows", http.StatusNotFound},
+		{"/tables/users/rows?nope=1", http.StatusBadRequest},
+		{"/tables/users/rows?order=nope", http.StatusBadRequest},
+		{"/tables/users/rows?limit=-1", http.StatusBadRequest},
+		{"/tables/users/rows?limit=11", http.StatusBadRequest},
+	} {
+		var res map[string]string
+		if code := get(t, ts, tc.path, &res); code != tc.code || res["error"] == "" {
+			t.Errorf("%s: status = %d, body = %v; want %d with an error", tc.path, code, res, tc.code)
+		}
+	}
+}
+
+func TestServer_RowsPagination(t *testing.T) {
+	path := testDB(t)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE posts (__ulid__ TEXT, name TEXT)`,
+		`INSERT INTO posts VALUES ('01C', 'third'), ('01A', 'first'), ('01D', 'fourth'), ('01B', 'second')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	ts := startTestServer(t, Options{Backend: backend(t, pgserver.Options{DBPath: path}), CursorColumn: "__ulid__"})
+
+	// page requests path and returns the names of its rows and the target
+	// of its next link.
+	page := func(path string) (string, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res result
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding %s: %v", path, err)
+		}
+		link := resp.Header.Get("Link")
+		if link != "" {
+			target, ok := strings.CutSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			if !ok {
+				t.Fatalf("%s: Link = %q", path, link)
+			}
+			link = target
+		}
+		return names(res), link
+	}
+
+	got, next := page("/tables/posts/rows?limit=3")
+	if got != "first,second,third" || next != "/tables/posts/rows?after=01C&limit=3" {
+		t.Fatalf("first page = %s, next %q", got, next)
+	}
+	if got, next = page(next); got != "fourth" || next != "" {
+		t.Errorf("second page = %s, next %q; want fourth and no next page", got, next)
+	}
+	if got, next = page("/tables/users/rows?limit=2"); got != "alice,bob" || next != "/tables/users/rows?limit=2&offset=2" {
+		t.Errorf("users page = %s, next %q; want an offset link", got, next)
+	}
+	if got, next = page("/tables/posts/rows?order=name&limit=2"); got != "first,fourth" || next != "/tables/posts/rows?limit=2&offset=2&order=name" {
+		t.Errorf("ordered page = %s, next %q; want an offset link", got, next)
+	}
+
+	for _, path := range []string{"/tables/users/rows?after=1", "/tables/posts/rows?after=01A&order=name", "/tables/posts/rows?after=01A&offset=1"} {
+		var res map[string]string
+		if code := get(t, ts, path, &res); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, code)
+		}
+	}
+}
+
+func TestServer_Query(t *testing.T) {
+	ts := startTestServer(t, Options{
+		Backend: backend(t, pgserver.Options{
+			DBPath:         testDB(t),
+			AllowedQueries: []*regexp.Regexp{regexp.MustCompile(`(?i)select .*`)},
+		}),
+		MaxResultRows: 2,
+	})
+
+	var res result
+	if code := post(t, ts, `{"sql": "SELECT name FROM users WHERE role = ? ORDER BY id", "args": ["user"]}`, &res); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if got := names(res); got != "bob,carol" || len(res.Columns) != 1 {
+		t.Errorf("result = %+v", res)
+	}
+
+	// Rows list their values in the order the query selects them.
+	if code := post(t, ts, `{"sql": "SELECT role, name, id FROM users WHERE id = 1"}`, &res); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	want := result{Columns: []string{"role", "name", "id"}, Rows: [][]any{{"admin", "alice", float64(1)}}}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("result = %+v, want %+v", res, want)
+	}
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"sql": "SELECT name FROM users"}`, http.StatusBadRequest}, // over MaxResultRows
+		{`{"sql": "DELETE FROM users"}`, http.StatusForbidden},
+		{`{"sql": "select 1; DELETE FROM users"}`, http.StatusBadRequest},
+		{`{"sql": "SELECT * FROM nope"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	} {
+		var res map[string]string
+		if code := post(t, ts, tc.body, &res); code != tc.code || res["error"] == "" {
+			t.Errorf("%s: status = %d, body = %v; want %d with an error", tc.body, code, res, tc.code)
+		}
+	}
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	ts := startTestServer(t, Options{Backend: backend(t, pgserver.Options{DBPath: testDB(t)})})
+	for _, q := range []string{
+		"DELETE FROM users",
+		"CREATE TEMP TABLE t (x)",
+	} {
+		var res map[string]string
+		if code := post(t, ts, `{"sql": "`+q+`"}`, &res); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", q, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// getAs requests path with basic auth as user and decodes the JSON response
+// into v, returning the status code.
+func getAs(t *testing.T, ts *httptest.Server, user, password, path string, v any) int {
+	t.Helper()
+	req, _ := http.NewRequest("GET", ts.URL+path, nil)
+	req.SetBasicAuth(user, password)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestServer_Auth(t *testing.T) {
+	ts := startTestServer(t, Options{Backend: backend(t, pgserver.Options{
+		DBPath:   testDB(t),
+		Username: "admin",
+		Password: "secret",
+		Users:    map[string]pgserver.User{"bob": {Password: "hunter2"}},
+	})})
+
+	var res map[string]any
+	if code := get(t, ts, "/tables", &res); code != http.StatusUnauthorized {
+		t.Errorf("status without credentials = %d, want 401", code)
+	}
+	for _, tc := range []struct {
+		user, password string
+		code           int
+	}{
+		{"admin", "secret", http.StatusOK},
+		{"bob", "hunter2", http.StatusOK},
+		{"bob", "secret", http.StatusUnauthorized},
+		{"carol", "secret", http.StatusUnauthorized},
+	} {
+		if code := getAs(t, ts, tc.user, tc.password, "/tables", &res); code != tc.code {
+			t.Errorf("status as %s/%s = %d, want %d", tc.user, tc.password, code, tc.code)
+		}
+	}
+}
+
+func TestServer_RowFilters(t *testing.T) {
+	srv, err := New(Options{Backend: backend(t, pgserver.Options{
+		DBPath: testDB(t),
+		Users: map[string]pgserver.User{
+			"staff": {Password: "s", RowFilters: map[string]string{"users": "role = 'user'"}},
+			"root":  {Password: "r"},
+		},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Close()
+
+	// With only users configured, every request must log in as one.
+	var res map[string]any
+	if code := get(t, ts, "/tables/users/rows", &res); code != http.StatusUnauthorized {
+		t.Errorf("status without credentials = %d, want 401", code)
+	}
+
+	var rows result
+	if code := getAs(t, ts, "staff", "s", "/tables/users/rows", &rows); code != http.StatusOK || names(rows) != "bob,carol" {
+		t.Errorf("rows as staff = %d %+v", code, rows)
+	}
+	if code := getAs(t, ts, "root", "r", "/tables/users/rows", &rows); code != http.StatusOK || names(rows) != "alice,bob,carol" {
+		t.Errorf("rows as root = %d %+v", code, rows)
+	}
+	if code := getAs(t, ts, "staff", "s", "/warnings", &res); code != http.StatusForbidden {
+		t.Errorf("warnings as staff = %d, want 403", code)
+	}
+	if code := getAs(t, ts, "root", "r", "/warnings", &res); code != http.StatusOK {
+		t.Errorf("warnings as root = %d, want 200", code)
+	}
+}
+
+func TestServer_Databases(t *testing.T) {
+	pg := backend(t, pgserver.Options{Databases: map[string]string{"a": testDB(t), "b": ":memory:"}})
+	srv, err := New(Options{Backend: pg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Close()
+
+	var res map[string]any
+	if code := get(t, ts, "/tables", &res); code != http.StatusBadRequest {
+		t.Errorf("status without database = %d, want 400", code)
+	}
+	if code := get(t, ts, "/tables?database=c", &res); code != http.StatusNotFound {
+		t.Errorf("status for unknown database = %d, want 404", code)
+	}
+	var rows result
+	if code := get(t, ts, "/tables/users/rows?database=a&role=admin", &rows); code != http.StatusOK || names(rows) != "alice" {
+		t.Errorf("rows of a = %d %+v", code, rows)
+	}
+
+	// Reloading the backend swaps the database served under the name.
+	if err := pg.ReloadDatabase("b", testDB(t)); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(t, ts, "/tables/users/rows?database=b", &rows); code != http.StatusOK || names(rows) != "alice,bob,carol" {
+		t.Errorf("rows of b after reload = %d %+v", code, rows)
+	}
+}
+
+func TestServer_Warnings(t *testing.T) {
+	srv, err := New(Options{Backend: backend(t, pgserver.Options{Databases: map[string]string{"a": testDB(t), "b": testDB(t)}})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Close()
+
+	builtAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	srv.SetWarnings("a", builtAt, []validator.ValidationError{
+		{FilePath: "alice.users.yaml", RecordKey: "alice", Field: "role", Message: "value not in enum"},
+	})
+
+	var got struct {
+		BuiltAt  string `json:"built_at"`
+		Warnings []warning
+	}
+	if code := get(t, ts, "/warnings?database=a", &got); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	want := []warning{{File: "alice.users.yaml", Record: "alice", Field: "role", Message: "value not in enum"}}
+	if got.BuiltAt != "2024-05-06T07:08:09Z" || !reflect.DeepEqual(got.Warnings, want) {
+		t.Errorf("warnings of a = %+v", got)
+	}
+
+	// A database with no warnings set has none.
+	got.Warnings = nil
+	if code := get(t, ts, "/warnings?database=b", &got); code != http.StatusOK || got.Warnings == nil || len(got.Warnings) != 0 {
+		t.Errorf("warnings of b = %d %+v", code, got)
+	}
+	var res map[string]any
+	if code := get(t, ts, "/warnings?database=c", &res); code != http.StatusNotFound {
+		t.Errorf("status for unknown database = %d, want 404", code)
+	}
+}
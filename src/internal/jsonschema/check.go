@@ -0,0 +1,166 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	jsonvalidator "github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// CheckError is a single schema violation found in a data file.
+type CheckError struct {
+	FilePath string
+	Pointer  string // JSON pointer into the record, e.g. "/age"; "" for the whole record
+	Message  string
+}
+
+func (e CheckError) Error() string {
+	pointer := e.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	return fmt.Sprintf("%s: %s: %s", e.FilePath, pointer, e.Message)
+}
+
+// Checker validates individual data files against a generated JSON Schema
+// document, using the row schema of the table named by each file's entity type.
+type Checker struct {
+	compiler *jsonvalidator.Compiler
+	rows     map[string]*jsonvalidator.Schema
+	reg      *loader.Registry
+}
+
+// NewChecker compiles doc, the output of Generate or GenerateFromColumns.
+// Files are loaded with reg, or with the default registry when reg is nil.
+func NewChecker(doc []byte, reg *loader.Registry) (*Checker, error) {
+	// v6 AddResource requires a decoded value, not an io.Reader.
+	var schemaDoc any
+	if err := json.Unmarshal(doc, &schemaDoc); err != nil {
+		return nil, fmt.Errorf("decoding schema: %w", err)
+	}
+	c := jsonvalidator.NewCompiler()
+	if err := c.AddResource("schema.json", schemaDoc); err != nil {
+		return nil, fmt.Errorf("adding schema: %w", err)
+	}
+	if reg == nil {
+		reg = loader.NewRegistry()
+	}
+	return &Checker{
+		compiler: c,
+		rows:     make(map[string]*jsonvalidator.Schema),
+		reg:      reg,
+	}, nil
+}
+
+// CheckFile loads the data file at absPath and validates its record. relPath
+// names the file in errors and determines its table, through the registry's
+// table mapper if it has one. The returned error is
+// non-nil only when the file cannot be checked at all.
+func (c *Checker) CheckFile(absPath, relPath string) ([]CheckError, error) {
+	table := c.reg.TableOf(relPath)
+	if table == "" {
+		return nil, fmt.Errorf("%s: no entity type in filename (expected name.entity-type.ext) and no tables pattern matches", relPath)
+	}
+	sch, err := c.rowSchema(table)
+	if err != nil {
+		return nil, fmt.Errorf("%s: no table %q in schema", relPath, table)
+	}
+
+	fr, err := c.reg.LoadFile(absPath, relPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", relPath, err)
+	}
+
+	var errs []CheckError
+	for _, rec := range fr.Records {
+		for _, key := range rec.DuplicateKeys {
+			errs = append(errs, CheckError{FilePath: relPath, Pointer: "/" + escapePointer(key), Message: "duplicate key (the last value is used)"})
+		}
+
+		instance, err := rowInstance(rec.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", relPath, err)
+		}
+		verr := sch.Validate(instance)
+		if verr == nil {
+			continue
+		}
+		ve, ok := verr.(*jsonvalidator.ValidationError)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", relPath, verr)
+		}
+		errs = append(errs, leafErrors(relPath, ve.BasicOutput())...)
+	}
+	return errs, nil
+}
+
+// rowSchema compiles (once) and returns the row schema for table.
+func (c *Checker) rowSchema(table string) (*jsonvalidator.Schema, error) {
+	if sch, ok := c.rows[table]; ok {
+		return sch, nil
+	}
+	sch, err := c.compiler.Compile("schema.json#/$defs/" + table + "_row")
+	if err != nil {
+		return nil, err
+	}
+	c.rows[table] = sch
+	return sch, nil
+}
+
+// rowInstance returns the scalar fields of a record as a JSON-compatible value.
+// Array and object fields expand into child tables and are not part of the row
+// schema. Fields are round-tripped through JSON (e.g. uint64 → float64).
+func rowInstance(fields map[string]any) (any, error) {
+	scalar := make(map[string]any, len(fields))
+	for k, v := range fields {
+		switch v.(type) {
+		case []any, map[string]any:
+			// skip nested structures
+		default:
+			scalar[k] = v
+		}
+	}
+	raw, err := json.Marshal(scalar)
+	if err != nil {
+		return nil, err
+	}
+	var instance any
+	err = json.Unmarshal(raw, &instance)
+	return instance, err
+}
+
+// leafErrors flattens basic output into one CheckError per failing keyword,
+// sorted by pointer.
+func leafErrors(relPath string, out *jsonvalidator.OutputUnit) []CheckError {
+	var errs []CheckError
+	for _, u := range out.Errors {
+		if u.Error == nil || len(u.Errors) > 0 {
+			continue
+		}
+		errs = append(errs, CheckError{FilePath: relPath, Pointer: u.InstanceLocation, Message: u.Error.String()})
+	}
+	if len(errs) == 0 && out.Error != nil {
+		errs = append(errs, CheckError{FilePath: relPath, Pointer: out.InstanceLocation, Message: out.Error.String()})
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Pointer < errs[j].Pointer })
+	return errs
+}
+
+// escapePointer escapes a key for use as a JSON pointer reference token.
+func escapePointer(key string) string {
+	out := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '~':
+			out = append(out, '~', '0')
+		case '/':
+			out = append(out, '~', '1')
+		default:
+			out = append(out, key[i])
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,70 @@
+package jsonschema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/dbml"
+)
+
+func TestChecker_CheckFile(t *testing.T) {
+	schema, err := dbml.Parse([]byte(`
+enum role {
+  admin
+  member
+}
+
+Table users {
+  name varchar [not null]
+  age integer
+  role role
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Generate(schema, config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewChecker(doc, nil)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	ok := write("alice.users.yaml", "name: Alice\nage: 30\nrole: admin\n")
+	errs, err := c.CheckFile(ok, "alice.users.yaml")
+	if err != nil || len(errs) != 0 {
+		t.Errorf("valid file: errs=%v err=%v", errs, err)
+	}
+
+	bad := write("bob.users.yaml", "age: old\nrole: owner\nextra: 1\n")
+	errs, err = c.CheckFile(bad, "bob.users.yaml")
+	if err != nil {
+		t.Fatalf("CheckFile: %v", err)
+	}
+	pointers := map[string]bool{}
+	for _, e := range errs {
+		pointers[e.Pointer] = true
+	}
+	for _, want := range []string{"/age", "/role"} {
+		if !pointers[want] {
+			t.Errorf("missing error at %s; got %v", want, errs)
+		}
+	}
+
+	if _, err := c.CheckFile(write("x.posts.yaml", "a: 1\n"), "x.posts.yaml"); err == nil {
+		t.Error("expected error for unknown table")
+	}
+}
@@ -23,12 +23,15 @@ func GenerateFromColumns(tables map[string][]string, cfg *config.Config) ([]byte
 		rowKey := name + "_row"
 
 		properties := make(map[string]any)
+		var order []string
 		for _, col := range cols {
 			if _, isStd := stdCols[col]; isStd {
 				continue
 			}
 			properties[col] = map[string]any{"type": "string"}
+			order = append(order, col)
 		}
+		properties[config.TombstoneField] = tombstoneProp()
 
 		defs[fileKey] = map[string]any{
 			"type":                 "object",
@@ -36,11 +39,15 @@ func GenerateFromColumns(tables map[string][]string, cfg *config.Config) ([]byte
 			"description":          "Matches files named " + name + ".*",
 			"additionalProperties": map[string]any{"$ref": "#/$defs/" + rowKey},
 		}
-		defs[rowKey] = map[string]any{
+		rowSchema := map[string]any{
 			"type":                 "object",
 			"properties":           properties,
 			"additionalProperties": false,
 		}
+		if len(order) > 0 {
+			rowSchema["propertyOrder"] = order
+		}
+		defs[rowKey] = rowSchema
 		oneOf = append(oneOf, map[string]any{"$ref": "#/$defs/" + fileKey})
 	}
 
@@ -92,7 +99,7 @@ func Generate(schema *dbml.Schema, cfg *config.Config) ([]byte, error) {
 		}
 
 		// Row schema: the columns.
-		rowSchema := buildRowSchema(tbl, schema, stdCols)
+		rowSchema := buildRowSchema(tbl, schema, stdCols, cfg)
 
 		defs[fileKey] = fileSchema
 		defs[rowKey] = rowSchema
@@ -113,9 +120,12 @@ func Generate(schema *dbml.Schema, cfg *config.Config) ([]byte, error) {
 }
 
 // buildRowSchema constructs the JSON Schema for a single row in a table.
-func buildRowSchema(tbl *dbml.Table, schema *dbml.Schema, stdCols map[string]struct{}) map[string]any {
+// JSON objects are unordered, so the DBML column order is also given as
+// propertyOrder for editors that lay out forms by it. Notes are described in
+// cfg.Locale.
+func buildRowSchema(tbl *dbml.Table, schema *dbml.Schema, stdCols map[string]struct{}, cfg *config.Config) map[string]any {
 	properties := make(map[string]any)
-	var required []string
+	var required, order []string
 
 	for _, col := range tbl.Columns {
 		// Exclude standard columns.
@@ -123,33 +133,79 @@ func buildRowSchema(tbl *dbml.Table, schema *dbml.Schema, stdCols map[string]str
 			continue
 		}
 
-		prop := columnSchema(col, schema)
+		prop := columnSchema(col, schema, cfg)
 		properties[col.Name] = prop
+		order = append(order, col.Name)
 
-		// Required if not null and no default and not PK.
-		if col.NotNull && col.Default == nil && !col.PK {
+		// Required if not null and no default and not PK, or if part of a
+		// composite primary key.
+		if (col.NotNull && col.Default == nil && !col.PK) || tbl.InPrimaryKey(col.Name) {
 			required = append(required, col.Name)
 		}
 	}
+	properties[config.TombstoneField] = tombstoneProp()
 
 	rowSchema := map[string]any{
 		"type":                 "object",
 		"properties":           properties,
 		"additionalProperties": false,
 	}
+	if len(order) > 0 {
+		rowSchema["propertyOrder"] = order
+	}
 	if len(required) > 0 {
 		rowSchema["required"] = required
 	}
-	if tbl.Note != "" {
-		rowSchema["description"] = tbl.Note
-	}
+	describe(rowSchema, tbl.Description(cfg.Locale), dbml.ParseNote(tbl.Note))
 
 	return rowSchema
 }
 
+// describe documents a schema with the description of a table or column and
+// the other fields of its structured note, if it has one, as x-owner, x-pii
+// and x-tags.
+func describe(prop map[string]any, description string, note *dbml.NoteFields) {
+	if description != "" {
+		prop["description"] = description
+	}
+	if note == nil {
+		return
+	}
+	if note.Owner != "" {
+		prop["x-owner"] = note.Owner
+	}
+	if note.PII {
+		prop["x-pii"] = true
+	}
+	if len(note.Tags) > 0 {
+		prop["x-tags"] = note.Tags
+	}
+}
+
+// markSensitive sets x-sensitive on the schema of a column whose values are
+// redacted in the database. A JSON Schema note already says so itself.
+func markSensitive(prop map[string]any, col *dbml.Column) {
+	if col.IsSensitive() {
+		prop["x-sensitive"] = true
+	}
+}
+
+// tombstoneProp is the schema for the optional tombstone marker field.
+func tombstoneProp() map[string]any {
+	return map[string]any{
+		"type":        "boolean",
+		"description": "Marks the entity as deleted",
+	}
+}
+
+// jsonColumnComment notes how json and jsonb column values are stored when
+// json_text is set.
+const jsonColumnComment = "Stored as JSON text; query it with SQLite's JSON functions, e.g. json_extract"
+
 // columnSchema returns the JSON Schema for a single column.
-func columnSchema(col *dbml.Column, schema *dbml.Schema) map[string]any {
+func columnSchema(col *dbml.Column, schema *dbml.Schema, cfg *config.Config) map[string]any {
 	prop := make(map[string]any)
+	locale := cfg.Locale
 
 	// Check if the type references an enum.
 	if en := schema.EnumByName(col.Type.Name); en != nil {
@@ -159,13 +215,20 @@ func columnSchema(col *dbml.Column, schema *dbml.Schema) map[string]any {
 			vals[i] = v.Name
 		}
 		prop["enum"] = vals
-		if col.Note != "" {
-			prop["description"] = col.Note
-		}
+		describe(prop, col.Description(locale), col.NoteFields())
+		markSensitive(prop, col)
 		return prop
 	}
 
-	jType, format := dbmlTypeToJSONSchema(col.Type.Name)
+	// A json column's note may be the schema of its value.
+	if doc := col.JSONSchema(); doc != nil {
+		if _, ok := doc["$comment"]; !ok && cfg.JSONText {
+			doc["$comment"] = jsonColumnComment
+		}
+		return doc
+	}
+
+	jType, format := dbmlTypeToJSONSchema(col.Type.Name, schema.Dialect())
 	if jType != "" {
 		prop["type"] = jType
 	}
@@ -173,9 +236,11 @@ func columnSchema(col *dbml.Column, schema *dbml.Schema) map[string]any {
 		prop["format"] = format
 	}
 
-	if col.Note != "" {
-		prop["description"] = col.Note
+	if col.IsJSON() && cfg.JSONText {
+		prop["$comment"] = jsonColumnComment
 	}
+	describe(prop, col.Description(locale), col.NoteFields())
+	markSensitive(prop, col)
 	if col.Default != nil {
 		prop["default"] = col.Default.Value
 	}
@@ -183,8 +248,18 @@ func columnSchema(col *dbml.Column, schema *dbml.Schema) map[string]any {
 	return prop
 }
 
-// dbmlTypeToJSONSchema maps a DBML type name to a JSON Schema type and optional format.
-func dbmlTypeToJSONSchema(typeName string) (schemaType, format string) {
+// dbmlTypeToJSONSchema maps a DBML type name to a JSON Schema type and optional
+// format. Types that only carry a well-defined format in the project's dialect
+// (PostgreSQL uuid and interval) get that format only for that dialect.
+func dbmlTypeToJSONSchema(typeName string, dialect dbml.Dialect) (schemaType, format string) {
+	if dialect == dbml.DialectPostgreSQL {
+		switch strings.ToLower(typeName) {
+		case "uuid":
+			return "string", "uuid"
+		case "interval":
+			return "string", "duration"
+		}
+	}
 	switch strings.ToLower(typeName) {
 	case "int", "integer", "int2", "int4", "int8", "bigint", "smallint",
 		"tinyint", "mediumint", "serial", "bigserial", "smallserial":
@@ -222,15 +297,199 @@ func GenerateConfigSchema() ([]byte, error) {
 		"properties": map[string]any{
 			"schema": map[string]any{
 				"type":        "string",
-				"description": "Path to the DBML schema file (relative to root)",
+				"description": "Path to the DBML schema file (relative to root), \"-\" for standard input, or an http(s) URL",
 				"default":     "schema.dbml",
 			},
+			"min_sqlfs_version": map[string]any{
+				"type":        "string",
+				"description": "Oldest sqlfs version allowed to build this project (e.g. 0.2.0)",
+			},
 			"invalid": map[string]any{
 				"type":        "string",
 				"enum":        []string{"silent", "warn", "fail"},
 				"description": "Behavior when a file fails schema validation",
 				"default":     "fail",
 			},
+			"tombstones": map[string]any{
+				"type":        "string",
+				"enum":        []string{"skip", "keep"},
+				"description": "Whether entities marked as deleted are omitted or kept with the deleted_at column set",
+				"default":     "skip",
+			},
+			"enum_tables": map[string]any{
+				"type":        "boolean",
+				"description": "Materialize each DBML enum as a lookup table referenced by the columns that use it",
+				"default":     false,
+			},
+			"json_text": map[string]any{
+				"type":        "boolean",
+				"description": "Store every value of json and jsonb columns, scalars included, as JSON text checked with json_valid",
+				"default":     false,
+			},
+			"record_checksums": map[string]any{
+				"type":        "boolean",
+				"description": "Add a per-record checksum column computed over each row's fields",
+				"default":     false,
+			},
+			"source_lines": map[string]any{
+				"type":        "boolean",
+				"description": "Add a column holding the line of the source file where each row's record or array element starts",
+				"default":     false,
+			},
+			"forbid_standard_columns": map[string]any{
+				"type":        "boolean",
+				"description": "Treat a record that sets a standard column, such as __path__, as invalid",
+				"default":     false,
+			},
+			"foreign_keys": map[string]any{
+				"type":        "boolean",
+				"description": "Emit a FOREIGN KEY constraint for each DBML relationship and check that every reference resolves",
+				"default":     false,
+			},
+			"path_template": map[string]any{
+				"type":        "string",
+				"description": "Format of the path standard column; {path} is the file path and {key} the record key, both with / separators",
+				"default":     "{path}#{key}",
+			},
+			"markdown_body": map[string]any{
+				"type":        "string",
+				"description": "Column that holds the body of Markdown files after their front matter",
+				"default":     "body",
+			},
+			"markdown_assets": map[string]any{
+				"type":        "boolean",
+				"description": "Record the local files that Markdown bodies link to in the __sqlfs_assets__ table",
+				"default":     false,
+			},
+			"schema_tables": map[string]any{
+				"type":        "boolean",
+				"description": "Describe the DBML schema in the __sqlfs_tables__, __sqlfs_columns__, __sqlfs_refs__, and __sqlfs_enums__ tables",
+				"default":     false,
+			},
+			"key_field": map[string]any{
+				"type":        "string",
+				"description": "Field whose value keys each record of a multi-document YAML file or a top-level list of records; unset means id, else key, else the record's index",
+			},
+			"redact": map[string]any{
+				"type":        "string",
+				"description": "What is stored for columns noted as sensitive: hash (HMAC-SHA256 of the value keyed by redact_key) or drop (NULL)",
+				"enum":        []string{"hash", "drop"},
+				"default":     "hash",
+			},
+			"redact_key": map[string]any{
+				"type":        "string",
+				"description": "Environment variable holding the secret key that redact: hash hashes values with",
+				"default":     "SQLFS_REDACT_KEY",
+			},
+			"exclude_tables": map[string]any{
+				"type":        "array",
+				"description": "Tables that are never built: no table is created and their files are skipped",
+				"items":       map[string]any{"type": "string"},
+			},
+			"atomic_dirs": map[string]any{
+				"type":        "array",
+				"description": "Glob patterns of directories whose files are only loaded when every one of them is valid; otherwise the build fails",
+				"items":       map[string]any{"type": "string"},
+			},
+			"ignore": map[string]any{
+				"type":        "array",
+				"description": "Glob patterns of further files and directories to skip, besides hidden files and editor temp files: names without a /, or paths relative to the root",
+				"items":       map[string]any{"type": "string"},
+			},
+			"generate_uuids": map[string]any{
+				"type":        "string",
+				"description": "Generate omitted uuid primary keys: v4 (random) or v7 (time-ordered by the file's creation time)",
+				"enum":        []string{"v4", "v7"},
+			},
+			"id_strategy": map[string]any{
+				"type":        "string",
+				"description": "How the __ulid__ standard column is generated: ulid, uuidv4, uuidv7 (both timestamped like ulid by the file's creation time), snowflake (64-bit integers ordered by generation time), or path-hash (stable across builds)",
+				"enum":        []string{"ulid", "uuidv4", "uuidv7", "snowflake", "path-hash"},
+				"default":     "ulid",
+			},
+			"deterministic": map[string]any{
+				"type":        "boolean",
+				"description": "Derive generated ULIDs and UUIDs from each record instead of the clock and random numbers, so builds of the same files are byte-identical; not allowed with id_strategy snowflake",
+				"default":     false,
+			},
+			"queries": map[string]any{
+				"type":                 "object",
+				"description":          "Named SQL queries stored with the database; those without parameters become views",
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+			"users": map[string]any{
+				"type":        "object",
+				"description": "Serve logins with their own passwords and row filters, keyed by user name",
+				"additionalProperties": map[string]any{
+					"type":     "object",
+					"required": []string{"password"},
+					"properties": map[string]any{
+						"password": map[string]any{
+							"type":        "string",
+							"description": "Environment variable name for the user's password",
+						},
+						"row_filters": map[string]any{
+							"type":                 "object",
+							"description":          "SQL conditions limiting the rows of each table the user sees, keyed by table",
+							"additionalProperties": map[string]any{"type": "string"},
+						},
+					},
+					"additionalProperties": false,
+				},
+			},
+			"allowed_queries": map[string]any{
+				"type":        "array",
+				"description": "Regular expressions; when set, serve only runs queries that one of them matches in full",
+				"items":       map[string]any{"type": "string"},
+			},
+			"query_cache_size": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Number of query results serve caches until the next rebuild; 0 disables the cache",
+			},
+			"query_cache_bytes": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Bytes of values the cached query results may hold together",
+				"default":     64 << 20,
+			},
+			"notice": map[string]any{
+				"type":        "string",
+				"description": "Message serve sends to clients as a NOTICE when they connect; {database}, {user}, {built_at} and {dataset_hash} are replaced",
+			},
+			"max_result_rows": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Most rows one query may return during serve; 0 means unlimited",
+			},
+			"max_result_bytes": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Most bytes of text-encoded values one query may return during serve; 0 means unlimited",
+			},
+			"interpolate_env": map[string]any{
+				"type":        "array",
+				"description": "Environment variables whose ${NAME} references are replaced in data file string values",
+				"items":       map[string]any{"type": "string"},
+			},
+			"listen": map[string]any{
+				"type":        "array",
+				"description": "Further addresses for the SQL server besides port: host:port for TCP or unix:<path> for a Unix socket",
+				"items":       map[string]any{"type": "string"},
+			},
+			"build_timeout": map[string]any{
+				"type":        "string",
+				"description": "Longest a build may run, as a Go duration such as 30s or 2m; unlimited when unset",
+			},
+			"incremental": map[string]any{
+				"type":        "boolean",
+				"description": "Rebuild during serve by patching the previous database with the files that changed",
+				"default":     false,
+			},
+			"remote_cache": map[string]any{
+				"type":        "string",
+				"description": "http(s) URL or directory where build saves its incremental cache, keyed by the schema, and restores it from",
+			},
 			"port": map[string]any{
 				"type":        "integer",
 				"description": "Port for the SQL server (serve command)",
@@ -238,6 +497,17 @@ func GenerateConfigSchema() ([]byte, error) {
 				"minimum":     1,
 				"maximum":     65535,
 			},
+			"http_port": map[string]any{
+				"type":        "integer",
+				"description": "Port for the HTTP JSON API of the serve command; disabled when unset",
+				"minimum":     1,
+				"maximum":     65535,
+			},
+			"webhook": map[string]any{
+				"type":        "string",
+				"format":      "uri",
+				"description": "URL that receives a JSON POST describing the changes of each rebuild (serve command)",
+			},
 			"credentials": map[string]any{
 				"type":        "object",
 				"description": "Environment variable names for SQL server credentials",
@@ -252,19 +522,195 @@ func GenerateConfigSchema() ([]byte, error) {
 						"description": "Environment variable name for the password",
 						"default":     "SQLFS_PASSWORD",
 					},
+					"method": map[string]any{
+						"type":        "string",
+						"enum":        []string{"password", "md5", "scram-sha-256"},
+						"description": "How clients send the password",
+						"default":     "scram-sha-256",
+					},
+				},
+				"additionalProperties": false,
+			},
+			"encryption": map[string]any{
+				"type":        "object",
+				"description": "Encryption of the build output",
+				"properties": map[string]any{
+					"key": map[string]any{
+						"type":        "string",
+						"description": "Environment variable name for the encryption key; output is encrypted when set",
+					},
+				},
+				"additionalProperties": false,
+			},
+			"access_log": map[string]any{
+				"type":        "object",
+				"description": "Log of the queries answered by serve",
+				"properties": map[string]any{
+					"path": map[string]any{
+						"type":        "string",
+						"description": "Log file, relative to the root directory; - writes to standard output",
+					},
+					"format": map[string]any{
+						"type":    "string",
+						"enum":    []any{"combined", "json"},
+						"default": "combined",
+					},
+					"max_size_mb": map[string]any{
+						"type":        "integer",
+						"description": "Size in megabytes at which the log file is rotated",
+						"default":     100,
+						"minimum":     1,
+					},
+					"max_backups": map[string]any{
+						"type":        "integer",
+						"description": "Number of rotated log files kept",
+						"default":     3,
+						"minimum":     0,
+					},
+				},
+				"additionalProperties": false,
+			},
+			"snapshots": map[string]any{
+				"type":        "object",
+				"description": "Retention of previous build outputs",
+				"properties": map[string]any{
+					"keep": map[string]any{
+						"type":        "integer",
+						"description": "Number of previous builds to retain (0 disables snapshots)",
+						"default":     0,
+						"minimum":     0,
+					},
+				},
+				"additionalProperties": false,
+			},
+			"timestamps": map[string]any{
+				"type":        "object",
+				"description": "Storage of the standard timestamp columns",
+				"properties": map[string]any{
+					"zone": map[string]any{
+						"type":        "string",
+						"description": "IANA time zone (or Local) RFC 3339 timestamps are written in",
+						"default":     "UTC",
+					},
+					"format": map[string]any{
+						"type":        "string",
+						"enum":        []string{"rfc3339", "unix"},
+						"description": "rfc3339 text or unix epoch seconds (INTEGER columns)",
+						"default":     "rfc3339",
+					},
+					"modified_at": map[string]any{
+						"type":        "string",
+						"enum":        []string{"filesystem", "git"},
+						"description": "Take __modified_at__ from the file's modification time or from the last git commit touching it",
+						"default":     "filesystem",
+					},
 				},
 				"additionalProperties": false,
 			},
+			"children": map[string]any{
+				"type":        "object",
+				"description": "Child tables for record arrays nested in entity files, keyed by parent table then array field",
+				"additionalProperties": map[string]any{
+					"type": "object",
+					"additionalProperties": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"table": map[string]any{
+								"type":        "string",
+								"description": "Child table name (default: <parent>_<field>)",
+							},
+							"parent_column": map[string]any{
+								"type":        "string",
+								"description": "Back-reference column name (default: <parent>_pk)",
+							},
+						},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"renames": map[string]any{
+				"type":        "object",
+				"description": "Field renames applied before validation, keyed by table then old field name",
+				"additionalProperties": map[string]any{
+					"type": "object",
+					"additionalProperties": map[string]any{
+						"type":        "string",
+						"description": "New column name",
+					},
+				},
+			},
+			"transforms": map[string]any{
+				"type":        "object",
+				"description": "jq programs reshaping each record of a table after renames and before validation, keyed by table",
+				"additionalProperties": map[string]any{
+					"type":        "string",
+					"description": "A jq program taking the record's fields as an object and producing its new fields; producing no output drops the record",
+				},
+			},
+			"tables": map[string]any{
+				"type":        "object",
+				"description": "Glob patterns of data file paths mapped to the tables they belong to, tried in order before the name.table.ext file name convention; ** matches across directories",
+				"additionalProperties": map[string]any{
+					"type":        "string",
+					"description": "Table name",
+				},
+			},
+			"loaders": map[string]any{
+				"type":          "object",
+				"description":   "External loaders: commands that read the data files of an extension, keyed by the extension, e.g. .csv",
+				"propertyNames": map[string]any{"pattern": "^\\.[^./\\\\]+$"},
+				"additionalProperties": map[string]any{
+					"type":        "array",
+					"description": "The program to run in the root directory and its arguments; it reads the file on standard input and writes its records as JSON objects to standard output",
+					"minItems":    1,
+					"items":       map[string]any{"type": "string"},
+				},
+			},
+			"table_from": map[string]any{
+				"type":        "string",
+				"description": "Where the table of a data file that no tables pattern matches comes from: filename (name.table.ext), directory (the name of its parent directory) or fields (the schema table whose columns best match its fields, for files whose name gives none)",
+				"enum":        []string{"filename", "directory", "fields"},
+				"default":     "filename",
+			},
+			"min_match_ratio": map[string]any{
+				"type":        "number",
+				"description": "How well a file's fields must match a table's columns, from 0 to 1, for table_from fields to assign it to the table",
+				"minimum":     0,
+				"maximum":     1,
+				"default":     0.5,
+			},
+			"locale": map[string]any{
+				"type":        "string",
+				"description": "Locale of the summaries of structured notes used in generated documentation and __sqlfs_columns__, e.g. fr or pt-BR; the first summary written when unset",
+			},
+			"collations": map[string]any{
+				"type":        "object",
+				"description": "Collations of text columns, keyed by table then column; overrides the DBML collate setting",
+				"additionalProperties": map[string]any{
+					"type": "object",
+					"additionalProperties": map[string]any{
+						"type":        "string",
+						"description": "Collation name: binary, nocase, rtrim, or unicode_nocase",
+					},
+				},
+			},
+			"column_prefix": map[string]any{
+				"type":        "string",
+				"description": "Prefix that names every standard column after its key under columns, e.g. _sqlfs_ gives _sqlfs_path; columns entries take precedence",
+			},
 			"columns": map[string]any{
 				"type":        "object",
 				"description": "Custom names for the standard injected columns",
 				"properties": map[string]any{
-					"pk":          columnNameProp("__pk__"),
-					"path":        columnNameProp("__path__"),
-					"created_at":  columnNameProp("__created_at__"),
-					"modified_at": columnNameProp("__modified_at__"),
-					"checksum":    columnNameProp("__checksum__"),
-					"ulid":        columnNameProp("__ulid__"),
+					"pk":              columnNameProp("__pk__"),
+					"path":            columnNameProp("__path__"),
+					"created_at":      columnNameProp("__created_at__"),
+					"modified_at":     columnNameProp("__modified_at__"),
+					"checksum":        columnNameProp("__checksum__"),
+					"ulid":            columnNameProp("__ulid__"),
+					"deleted_at":      columnNameProp("__deleted_at__"),
+					"record_checksum": columnNameProp("__record_checksum__"),
+					"source_line":     columnNameProp("__source_line__"),
 				},
 				"additionalProperties": false,
 			},
@@ -59,6 +59,9 @@ Table users {
 	if _, ok := props["id"]; !ok {
 		t.Error("missing id property")
 	}
+	if _, ok := props[config.TombstoneField]; !ok {
+		t.Errorf("missing %s property", config.TombstoneField)
+	}
 
 	// Required should include 'name' (not null, no default), but not 'id' (pk).
 	req, ok := rowSchema["required"].([]any)
@@ -76,6 +79,36 @@ Table users {
 	}
 }
 
+func TestGenerate_PropertyOrder(t *testing.T) {
+	schema := parseSchema(`
+Table users {
+  zeta varchar
+  alpha varchar
+  __pk__ varchar
+  mid integer
+}
+`, t)
+	data, err := Generate(schema, config.Default())
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	rowSchema := unmarshalJSON(data, t)["$defs"].(map[string]any)["users_row"].(map[string]any)
+	order, _ := json.Marshal(rowSchema["propertyOrder"])
+	if string(order) != `["zeta","alpha","mid"]` {
+		t.Errorf("propertyOrder = %s, want DBML column order", order)
+	}
+
+	data, err = GenerateFromColumns(map[string][]string{"users": {"b", "a"}}, config.Default())
+	if err != nil {
+		t.Fatalf("GenerateFromColumns: %v", err)
+	}
+	rowSchema = unmarshalJSON(data, t)["$defs"].(map[string]any)["users_row"].(map[string]any)
+	order, _ = json.Marshal(rowSchema["propertyOrder"])
+	if string(order) != `["b","a"]` {
+		t.Errorf("propertyOrder = %s, want discovery order", order)
+	}
+}
+
 func TestGenerate_StandardColumnsExcluded(t *testing.T) {
 	src := `Table t { id integer [pk] }`
 	schema := parseSchema(src, t)
@@ -92,6 +125,36 @@ func TestGenerate_StandardColumnsExcluded(t *testing.T) {
 	}
 }
 
+func TestGenerate_PrefixedStandardColumnsExcluded(t *testing.T) {
+	schema := parseSchema(`
+Table users {
+  name varchar
+  _sqlfs_path varchar
+}
+`, t)
+	cfg := config.Default()
+	cfg.StandardColumns = config.PrefixedColumns("_sqlfs_")
+	data, err := Generate(schema, cfg)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	rowSchema := unmarshalJSON(data, t)["$defs"].(map[string]any)["users_row"].(map[string]any)
+	order, _ := json.Marshal(rowSchema["propertyOrder"])
+	if string(order) != `["name"]` {
+		t.Errorf("propertyOrder = %s, want the prefixed standard column left out", order)
+	}
+
+	data, err = GenerateFromColumns(map[string][]string{"users": {"_sqlfs_ulid", "name"}}, cfg)
+	if err != nil {
+		t.Fatalf("GenerateFromColumns: %v", err)
+	}
+	rowSchema = unmarshalJSON(data, t)["$defs"].(map[string]any)["users_row"].(map[string]any)
+	order, _ = json.Marshal(rowSchema["propertyOrder"])
+	if string(order) != `["name"]` {
+		t.Errorf("schema-less propertyOrder = %s, want the prefixed standard column left out", order)
+	}
+}
+
 func TestGenerate_TypeMapping(t *testing.T) {
 	src := `
 Table t {
@@ -138,6 +201,72 @@ Table t {
 	}
 }
 
+func TestGenerate_JSONColumnSchema(t *testing.T) {
+	src := `
+Table users {
+  id integer [pk]
+  settings json [note: '{"type": "object", "required": ["theme"]}']
+  extra json [note: 'Anything goes']
+}
+`
+	schema := parseSchema(src, t)
+	cfg := config.Default()
+	cfg.JSONText = true
+	data, err := Generate(schema, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := unmarshalJSON(data, t)
+	props := doc["$defs"].(map[string]any)["users_row"].(map[string]any)["properties"].(map[string]any)
+
+	settings := props["settings"].(map[string]any)
+	if settings["type"] != "object" || settings["required"] == nil {
+		t.Errorf("settings = %v, want the schema from its note", settings)
+	}
+	extra := props["extra"].(map[string]any)
+	if extra["description"] != "Anything goes" || extra["type"] != nil {
+		t.Errorf("extra = %v, want any value described by its note", extra)
+	}
+	if extra["$comment"] == nil || settings["$comment"] == nil {
+		t.Error("json columns should carry a $comment noting they are stored as JSON")
+	}
+}
+
+func TestGenerate_StructuredNotes(t *testing.T) {
+	src := `
+Table users {
+  id integer [pk]
+  email varchar [note: '''
+    summary:
+      en: Contact address
+      fr: Adresse de contact
+    owner: growth
+    pii: true
+    tags: [contact]
+  ''']
+}
+`
+	schema := parseSchema(src, t)
+	data, err := Generate(schema, config.Default().WithLocale("fr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := unmarshalJSON(data, t)
+	props := doc["$defs"].(map[string]any)["users_row"].(map[string]any)["properties"].(map[string]any)
+	email := props["email"].(map[string]any)
+	if email["description"] != "Adresse de contact" {
+		t.Errorf("description = %v, want the fr summary", email["description"])
+	}
+	if email["x-owner"] != "growth" || email["x-pii"] != true {
+		t.Errorf("email = %v, want x-owner and x-pii from the note", email)
+	}
+	if tags, _ := email["x-tags"].([]any); len(tags) != 1 || tags[0] != "contact" {
+		t.Errorf("x-tags = %v", email["x-tags"])
+	}
+}
+
 func TestGenerate_EnumColumn(t *testing.T) {
 	src := `
 Table posts {
@@ -219,7 +348,7 @@ func TestDBMLTypeToJSONSchema(t *testing.T) {
 		{"jsonb", "", ""},
 	}
 	for _, tt := range tests {
-		gotType, gotFormat := dbmlTypeToJSONSchema(tt.in)
+		gotType, gotFormat := dbmlTypeToJSONSchema(tt.in, dbml.DialectGeneric)
 		if gotType != tt.wantType || gotFormat != tt.wantFormat {
 			t.Errorf("dbmlTypeToJSONSchema(%q) = (%q, %q), want (%q, %q)",
 				tt.in, gotType, gotFormat, tt.wantType, tt.wantFormat)
@@ -227,6 +356,15 @@ func TestDBMLTypeToJSONSchema(t *testing.T) {
 	}
 }
 
+func TestDBMLTypeToJSONSchema_PostgreSQL(t *testing.T) {
+	for in, want := range map[string]string{"uuid": "uuid", "interval": "duration", "timestamptz": "date-time"} {
+		gotType, gotFormat := dbmlTypeToJSONSchema(in, dbml.DialectPostgreSQL)
+		if gotType != "string" || gotFormat != want {
+			t.Errorf("dbmlTypeToJSONSchema(%q, PostgreSQL) = (%q, %q), want (string, %q)", in, gotType, gotFormat, want)
+		}
+	}
+}
+
 func TestGenerateConfigSchema(t *testing.T) {
 	data, err := GenerateConfigSchema()
 	if err != nil {
@@ -238,7 +376,7 @@ func TestGenerateConfigSchema(t *testing.T) {
 		t.Errorf("type = %v, want object", doc["type"])
 	}
 	props := doc["properties"].(map[string]any)
-	for _, key := range []string{"schema", "invalid", "port", "credentials", "columns"} {
+	for _, key := range []string{"schema", "invalid", "port", "credentials", "encryption", "timestamps", "children", "renames", "queries", "users", "access_log", "columns"} {
 		if _, ok := props[key]; !ok {
 			t.Errorf("config schema missing property %q", key)
 		}
@@ -0,0 +1,23 @@
+//go:build darwin || freebsd || netbsd
+
+package loader
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileCreatedAt returns the file's birth time from Birthtimespec.
+// Falls back to ModTime if the stat data is unavailable or has no birth time.
+func fileCreatedAt(info os.FileInfo, _ string) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	sec, nsec := stat.Birthtimespec.Unix()
+	if sec <= 0 && nsec <= 0 {
+		return info.ModTime()
+	}
+	return time.Unix(sec, nsec)
+}
@@ -1,20 +1,14 @@
-//go:build !windows
+//go:build !windows && !darwin && !freebsd && !netbsd
 
 package loader
 
 import (
 	"os"
-	"syscall"
 	"time"
 )
 
-// fileCreatedAt returns the file creation time (birth time) on Unix systems.
-// Falls back to ModTime if birth time is not available.
+// fileCreatedAt returns ModTime: Linux and the remaining Unix systems expose
+// no birth time through os.FileInfo (Ctim is "change time", not creation).
 func fileCreatedAt(info os.FileInfo, _ string) time.Time {
-	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-		// Ctim is "change time" not "creation time" on Linux — fall back to ModTime.
-		// On macOS, use Birthtimespec.
-		_ = stat
-	}
 	return info.ModTime()
 }
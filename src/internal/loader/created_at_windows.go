@@ -0,0 +1,19 @@
+//go:build windows
+
+package loader
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileCreatedAt returns the file's Win32 creation time.
+// Falls back to ModTime if the attribute data is unavailable.
+func fileCreatedAt(info os.FileInfo, _ string) time.Time {
+	attr, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(0, attr.CreationTime.Nanoseconds())
+}
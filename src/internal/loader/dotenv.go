@@ -0,0 +1,157 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DotenvLoader loads .env files of KEY=VALUE lines, as read by docker
+// compose and the dotenv libraries, into a record with a string field per
+// variable. References to other variables are not expanded.
+type DotenvLoader struct{}
+
+func (DotenvLoader) Extensions() []string { return []string{".env"} }
+
+func (DotenvLoader) Name() string { return "dotenv" }
+
+func (DotenvLoader) Options() []string {
+	return []string{
+		"each KEY=VALUE line becomes a string field",
+		"an export prefix is ignored",
+		"double-quoted values may span lines and use \\n, \\t, \\\" and \\\\ escapes",
+		"single-quoted values are taken literally",
+		"# starts a comment",
+		"duplicate keys are reported",
+	}
+}
+
+func (DotenvLoader) Load(absPath, relPath string) (*FileRecord, error) {
+	data, fr, err := readFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, keys, err := parseDotenv(string(data))
+	if err != nil {
+		return nil, err
+	}
+	rec := buildRecord(EntityKey(relPath), fields)
+	rec.Keys = keys
+	rec.DuplicateKeys = repeatedKeys(keys)
+
+	fr.EntityType = EntityType(relPath)
+	fr.Records = []Record{rec}
+	return fr, nil
+}
+
+// parseDotenv parses the variables of a .env file, returning them and their
+// names in file order, repeats included. The last of a repeated name wins.
+func parseDotenv(src string) (map[string]any, []string, error) {
+	src = strings.TrimPrefix(src, "\ufeff")
+	fields := make(map[string]any)
+	var keys []string
+	line := 1
+	for len(src) > 0 {
+		// One entry, or a blank or comment line, per iteration.
+		eol := strings.IndexByte(src, '\n')
+		if eol < 0 {
+			eol = len(src)
+		}
+		entry := strings.TrimSpace(src[:eol])
+		if entry == "" || entry[0] == '#' {
+			src = src[min(eol+1, len(src)):]
+			line++
+			continue
+		}
+
+		entry = strings.TrimLeft(src[:eol], " \t")
+		if rest, ok := strings.CutPrefix(entry, "export"); ok && len(rest) > 0 && (rest[0] == ' ' || rest[0] == '\t') {
+			entry = strings.TrimLeft(rest, " \t")
+		}
+		eq := strings.IndexByte(entry, '=')
+		if eq < 0 {
+			return nil, nil, fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		key := strings.TrimSpace(entry[:eq])
+		if key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, nil, fmt.Errorf("line %d: invalid variable name %q", line, key)
+		}
+
+		// The value starts after the "=" and may run past this line when
+		// quoted, so it is read from the rest of the file.
+		start := eol - len(entry) + eq + 1
+		value, n, err := dotenvValue(src[start:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		fields[key] = value
+		keys = append(keys, key)
+		line += strings.Count(src[:start+n], "\n")
+		src = src[start+n:]
+	}
+	return fields, keys, nil
+}
+
+// dotenvValue reads the value at the start of src, up to and including the
+// end of its line, and returns it along with the number of bytes read.
+func dotenvValue(src string) (string, int, error) {
+	i := 0
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	var value string
+	if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+		quote := src[i]
+		var b strings.Builder
+		j := i + 1
+		for ; j < len(src) && src[j] != quote; j++ {
+			c := src[j]
+			if c == '\\' && quote == '"' && j+1 < len(src) {
+				j++
+				switch src[j] {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				case 'r':
+					c = '\r'
+				case '"', '\\', '$':
+					c = src[j]
+				default:
+					b.WriteByte('\\')
+					c = src[j]
+				}
+			}
+			b.WriteByte(c)
+		}
+		if j == len(src) {
+			return "", 0, fmt.Errorf("unterminated %c-quoted value", quote)
+		}
+		value = b.String()
+		i = j + 1
+	} else {
+		end := strings.IndexByte(src[i:], '\n')
+		if end < 0 {
+			end = len(src) - i
+		}
+		raw := src[i : i+end]
+		if c := strings.Index(raw, " #"); c >= 0 {
+			raw = raw[:c]
+		}
+		if c := strings.Index(raw, "\t#"); c >= 0 {
+			raw = raw[:c]
+		}
+		value = strings.TrimSpace(raw)
+		i += end
+	}
+
+	// Only a comment may follow a quoted value on its line.
+	end := strings.IndexByte(src[i:], '\n')
+	if end < 0 {
+		end = len(src) - i
+	}
+	if rest := strings.TrimSpace(src[i : i+end]); rest != "" && rest[0] != '#' {
+		return "", 0, fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return value, min(i+end+1, len(src)), nil
+}
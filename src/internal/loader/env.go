@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envPattern matches a ${NAME} environment variable reference.
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// InterpolateEnv replaces ${NAME} references in the string values of fields,
+// including those nested in arrays and objects, with the value of environment
+// variable NAME. Only names in allowed are replaced; other references are left
+// as written. It is an error for an allowed variable to be unset.
+func InterpolateEnv(fields map[string]any, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	allow := make(map[string]struct{}, len(allowed))
+	for _, name := range allowed {
+		allow[name] = struct{}{}
+	}
+	for k, v := range fields {
+		iv, err := interpolateValue(v, allow)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", k, err)
+		}
+		fields[k] = iv
+	}
+	return nil
+}
+
+func interpolateValue(v any, allow map[string]struct{}) (any, error) {
+	switch val := v.(type) {
+	case string:
+		var missing string
+		out := envPattern.ReplaceAllStringFunc(val, func(ref string) string {
+			name := envPattern.FindStringSubmatch(ref)[1]
+			if _, ok := allow[name]; !ok {
+				return ref
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok && missing == "" {
+				missing = name
+			}
+			return value
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("environment variable %s is not set", missing)
+		}
+		return out, nil
+	case []any:
+		for i, elem := range val {
+			iv, err := interpolateValue(elem, allow)
+			if err != nil {
+				return nil, err
+			}
+			val[i] = iv
+		}
+		return val, nil
+	case map[string]any:
+		for k, elem := range val {
+			iv, err := interpolateValue(elem, allow)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", k, err)
+			}
+			val[k] = iv
+		}
+		return val, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,163 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// AllowExecEnvVar is the environment variable that must be set to 1 for
+// ExecLoaders to run their commands. The commands come from a root's
+// sqlfs.yaml, so building a directory someone else wrote must not run them
+// unless whoever runs sqlfs allows it.
+const AllowExecEnvVar = "SQLFS_ALLOW_EXEC"
+
+// ExecAllowed reports whether AllowExecEnvVar allows ExecLoaders to run.
+func ExecAllowed() bool {
+	return os.Getenv(AllowExecEnvVar) == "1"
+}
+
+// execEnvVars are the variables of sqlfs's environment passed on to the
+// commands of ExecLoaders, which need little more than to find programs.
+// Credentials and keys in the rest of it are kept from them.
+var execEnvVars = []string{"PATH", "HOME", "TMPDIR", "LANG", "SYSTEMROOT"}
+
+// ExecLoader loads the files of one extension by running a command, so that
+// formats without a built-in loader can be read. The command runs in Dir with
+// the file's contents, decompressed if need be, on its standard input, and
+// SQLFS_FILE set to the file's slash-separated path relative to the root. It
+// writes the file's records to its standard output as JSON: an object is the
+// file's one record, while an array of objects, or several objects one after
+// another as in JSON Lines, are the records of a multi-record file. A command
+// that exits with a non-zero status fails the file, with what it wrote to
+// its standard error as the reason. Files fail to load unless Allowed is set.
+type ExecLoader struct {
+	Ext     string
+	Command []string
+	Dir     string
+	// Allowed lets the loader run Command; see AllowExecEnvVar.
+	Allowed bool
+}
+
+func (l *ExecLoader) Extensions() []string { return []string{l.Ext} }
+
+func (l *ExecLoader) Name() string { return "exec" }
+
+func (l *ExecLoader) Options() []string {
+	return []string{
+		"runs " + strings.Join(l.Command, " ") + " with the file on standard input",
+		"an object on standard output is one record",
+		"an array of objects, or one object per line, are a record each",
+	}
+}
+
+func (l *ExecLoader) Load(absPath, relPath string) (*FileRecord, error) {
+	return l.LoadContext(context.Background(), absPath, relPath)
+}
+
+// LoadContext is Load, killing the command if ctx is done first.
+func (l *ExecLoader) LoadContext(ctx context.Context, absPath, relPath string) (*FileRecord, error) {
+	if !l.Allowed {
+		return nil, fmt.Errorf("external loader %s is not run unless %s=1 is set", strings.Join(l.Command, " "), AllowExecEnvVar)
+	}
+	data, fr, err := readFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, l.Command[0], l.Command[1:]...)
+	cmd.Dir = l.Dir
+	for _, name := range execEnvVars {
+		if v, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+v)
+		}
+	}
+	cmd.Env = append(cmd.Env, "SQLFS_FILE="+filepath.ToSlash(relPath))
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %w", l.Command[0], ctx.Err())
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", l.Command[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", l.Command[0], err)
+	}
+
+	values, err := execOutput(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: output: %w", l.Command[0], err)
+	}
+	fr.EntityType = EntityType(relPath)
+	if len(values) == 1 && bytes.HasPrefix(values[0], []byte("{")) {
+		fr.Records = []Record{execRecord(EntityKey(relPath), values[0])}
+		return fr, nil
+	}
+	if len(values) == 1 {
+		// A top-level array of records.
+		if err := json.Unmarshal(values[0], &values); err != nil {
+			return nil, fmt.Errorf("%s: output: %w", l.Command[0], err)
+		}
+	}
+	fr.MultiRecord = true
+	fr.Records = make([]Record, 0, len(values))
+	for i, v := range values {
+		if !bytes.HasPrefix(v, []byte("{")) {
+			return nil, fmt.Errorf("%s: output: record %d is not a JSON object", l.Command[0], i+1)
+		}
+		fr.Records = append(fr.Records, execRecord("", v))
+	}
+	return fr, nil
+}
+
+// execOutput splits the standard output of an external loader into its
+// top-level JSON values, each an object or, when it is the only one, an
+// array.
+func execOutput(out []byte) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(out))
+	var values []json.RawMessage
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(v, []byte("{")) && !bytes.HasPrefix(v, []byte("[")) {
+			return nil, fmt.Errorf("expected JSON objects, got %s", v)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no records")
+	}
+	if len(values) > 1 {
+		for i, v := range values {
+			if !bytes.HasPrefix(v, []byte("{")) {
+				return nil, fmt.Errorf("record %d is not a JSON object", i+1)
+			}
+		}
+	}
+	return values, nil
+}
+
+// execRecord builds the record keyed key from a JSON object written by an
+// external loader. Where it starts in the file is not known.
+func execRecord(key string, obj json.RawMessage) Record {
+	var m map[string]any
+	_ = json.Unmarshal(obj, &m) // already decoded once as valid JSON
+	rec := buildRecord(key, m)
+	rec.Keys = jsonKeys(obj)
+	rec.DuplicateKeys = repeatedKeys(rec.Keys)
+	rec.Lines = nil
+	return rec
+}
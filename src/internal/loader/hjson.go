@@ -1,7 +1,9 @@
 package loader
 
 import (
+	"bytes"
 	"encoding/json"
+	"regexp"
 
 	hjson "github.com/hjson/hjson-go/v4"
 )
@@ -12,6 +14,16 @@ type HJSONLoader struct{}
 
 func (HJSONLoader) Extensions() []string { return []string{".json", ".jsonc", ".json5"} }
 
+func (HJSONLoader) Name() string { return "hjson" }
+
+func (HJSONLoader) Options() []string {
+	return []string{
+		"comments, trailing commas and unquoted keys (HJSON)",
+		"duplicate top-level keys are reported",
+		"array of records: a top-level list of objects holds a record per element",
+	}
+}
+
 func (HJSONLoader) Load(absPath, relPath string) (*FileRecord, error) {
 	data, fr, err := readFile(absPath, relPath)
 	if err != nil {
@@ -27,12 +39,77 @@ func (HJSONLoader) Load(absPath, relPath string) (*FileRecord, error) {
 		}
 	}
 
+	fr.EntityType = EntityType(relPath)
+	if objs, ok := objectList(raw); ok {
+		setObjectRecords(fr, objs)
+		return fr, nil
+	}
+
 	m, ok := raw.(map[string]any)
 	if !ok {
 		m = map[string]any{"value": raw}
 	}
 
-	fr.EntityType = EntityType(relPath)
-	fr.Records = []Record{buildRecord(EntityKey(relPath), m)}
+	rec := buildRecord(EntityKey(relPath), m)
+	if ok {
+		var om hjson.OrderedMap
+		if err := hjson.Unmarshal(data, &om); err == nil {
+			rec.Keys = om.Keys
+		}
+		rec.DuplicateKeys = jsonDuplicateKeys(data, m)
+	}
+
+	fr.Records = []Record{rec}
 	return fr, nil
 }
+
+// hjsonDuplicateRe extracts the key from hjson-go's duplicate key error.
+var hjsonDuplicateRe = regexp.MustCompile(`for key '(.*?)' at line`)
+
+// jsonDuplicateKeys returns the keys repeated in the top-level object of data,
+// whose parsed form is m. Strict JSON is scanned token by token, finding every
+// repetition. Relaxed syntax (comments, unquoted keys) is re-parsed by hjson-go
+// with duplicates disallowed, which reports only the first repeated key.
+func jsonDuplicateKeys(data []byte, m map[string]any) []string {
+	if json.Valid(data) {
+		return repeatedKeys(jsonKeys(data))
+	}
+
+	opts := hjson.DefaultDecoderOptions()
+	opts.DisallowDuplicateKeys = true
+	var probe any
+	err := hjson.UnmarshalWithOptions(data, &probe, opts)
+	if err == nil {
+		return nil
+	}
+	match := hjsonDuplicateRe.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil
+	}
+	if _, topLevel := m[match[1]]; !topLevel {
+		return nil
+	}
+	return []string{match[1]}
+}
+
+// jsonKeys returns the keys of the top-level object of the strict JSON data
+// in document order, repeats included, or nil if data is not an object.
+func jsonKeys(data []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil
+		}
+	}
+	return keys
+}
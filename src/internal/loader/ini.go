@@ -0,0 +1,117 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// INILoader loads .ini files. Keys before the first section header become
+// fields of the record, and each [section] an object field holding the
+// section's keys, as TOML tables do. Values are strings.
+type INILoader struct{}
+
+func (INILoader) Extensions() []string { return []string{".ini"} }
+
+func (INILoader) Name() string { return "ini" }
+
+func (INILoader) Options() []string {
+	return []string{
+		"keys before the first section become fields",
+		"each [section] becomes an object field",
+		"values are strings, with surrounding quotes removed",
+		"; and # start comments",
+	}
+}
+
+func (INILoader) Load(absPath, relPath string) (*FileRecord, error) {
+	data, fr, err := readFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, keys, dups, err := parseINI(data)
+	if err != nil {
+		return nil, err
+	}
+	rec := buildRecord(EntityKey(relPath), fields)
+	rec.Keys = keys
+	rec.DuplicateKeys = dups
+
+	fr.EntityType = EntityType(relPath)
+	fr.Records = []Record{rec}
+	return fr, nil
+}
+
+// parseINI parses an INI file into its fields, the top-level keys and
+// section names in order of first appearance, and the top-level keys that
+// appear more than once. A section given twice holds the keys of both;
+// within a section, the last of a repeated key wins.
+func parseINI(data []byte) (fields map[string]any, keys, dups []string, err error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	fields = make(map[string]any)
+	var section map[string]any // nil before the first section header
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, nil, nil, fmt.Errorf("line %d: unterminated section header", i+1)
+			}
+			name := strings.TrimSpace(line[1:end])
+			if name == "" {
+				return nil, nil, nil, fmt.Errorf("line %d: empty section name", i+1)
+			}
+			if rest := strings.TrimSpace(line[end+1:]); rest != "" && rest[0] != ';' && rest[0] != '#' {
+				return nil, nil, nil, fmt.Errorf("line %d: unexpected %q after section header", i+1, rest)
+			}
+			switch v := fields[name].(type) {
+			case map[string]any:
+				section = v
+			case nil:
+				section = make(map[string]any)
+				fields[name] = section
+				keys = append(keys, name)
+			default:
+				return nil, nil, nil, fmt.Errorf("line %d: section %q has the name of a key", i+1, name)
+			}
+			continue
+		}
+
+		sep := strings.IndexAny(line, "=:")
+		if sep < 0 {
+			return nil, nil, nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key := strings.TrimSpace(line[:sep])
+		if key == "" {
+			return nil, nil, nil, fmt.Errorf("line %d: empty key", i+1)
+		}
+		value := iniValue(strings.TrimSpace(line[sep+1:]))
+		if section != nil {
+			section[key] = value
+			continue
+		}
+		fields[key] = value
+		keys = append(keys, key)
+	}
+	return fields, keys, repeatedKeys(keys), nil
+}
+
+// iniValue returns the value of an INI entry written as v: v without its
+// surrounding quotes, or without a trailing comment if it is not quoted.
+func iniValue(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') {
+		if end := strings.IndexByte(v[1:], v[0]); end >= 0 {
+			return v[1 : end+1]
+		}
+	}
+	for i := 1; i < len(v); i++ {
+		if (v[i] == ';' || v[i] == '#') && (v[i-1] == ' ' || v[i-1] == '\t') {
+			return strings.TrimSpace(v[:i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,53 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONLinesLoader loads newline-delimited JSON (.jsonl, .ndjson) files, the
+// export format of tools such as BigQuery and Elasticsearch. Each non-blank
+// line is a JSON object holding one record.
+type JSONLinesLoader struct{}
+
+func (JSONLinesLoader) Extensions() []string { return []string{".jsonl", ".ndjson"} }
+
+func (JSONLinesLoader) Name() string { return "jsonl" }
+
+func (JSONLinesLoader) Options() []string {
+	return []string{
+		"one record per line, keyed like the records of other multi-record files",
+		"blank lines are skipped",
+		"duplicate top-level keys are reported",
+	}
+}
+
+func (JSONLinesLoader) Load(absPath, relPath string) (*FileRecord, error) {
+	data, fr, err := readFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+	fr.EntityType = EntityType(relPath)
+	fr.MultiRecord = true
+
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if m == nil {
+			return nil, fmt.Errorf("line %d: not a JSON object", i+1)
+		}
+		rec := buildRecord("", m)
+		rec.Keys = jsonKeys(line)
+		rec.DuplicateKeys = repeatedKeys(rec.Keys)
+		rec.Lines = map[string]int{"": i + 1}
+		fr.Records = append(fr.Records, rec)
+	}
+	return fr, nil
+}
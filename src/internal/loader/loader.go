@@ -1,12 +1,19 @@
 package loader
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,6 +32,31 @@ type EntityRef struct {
 type Record struct {
 	Key    string
 	Fields map[string]any
+	// Keys lists the top-level field names in source document order, when
+	// the loader can recover it. See FieldOrder.
+	Keys []string
+	// DuplicateKeys lists top-level keys that appear more than once in the
+	// source file, in order of first repetition. Fields holds the last value
+	// for each; the validator reports them according to the invalid mode.
+	DuplicateKeys []string
+	// Lines holds the 1-based lines of the source file where the record and
+	// its nested array elements start, when the loader can tell: "" is the
+	// record itself, "key/i" element i of its array field key, and
+	// "key/i/key2/j" element j of key2 within that element. See LinePath.
+	Lines map[string]int
+	// DeletedAt is set by the builder for a tombstoned record of a
+	// MultiRecord file; zero otherwise.
+	DeletedAt time.Time
+}
+
+// LinePath returns the Record.Lines path of element i of the array field
+// key of the record or element at parent.
+func LinePath(parent, key string, i int) string {
+	p := key + "/" + strconv.Itoa(i)
+	if parent != "" {
+		p = parent + "/" + p
+	}
+	return p
 }
 
 // FileRecord is the result of loading one static file.
@@ -37,7 +69,24 @@ type FileRecord struct {
 	Records    []Record
 	ModTime    time.Time
 	CreatedAt  time.Time
-	Checksum   string // hex MD5 of raw file bytes
+	Checksum   string    // hex MD5 of raw file bytes
+	Size       int64     // file size in bytes
+	DeletedAt  time.Time // set by the builder for tombstoned entities; zero otherwise
+	// MultiRecord is set when each of Records is a separate entity of a file
+	// holding several, such as a multi-document YAML file or a top-level
+	// list of objects, identified by its Key. See KeyRecords.
+	MultiRecord bool
+}
+
+// RecordPK returns the __pk__ value of rec, one of fr's records: the file's
+// EntityPK, followed by "#" and the record key for the records of a
+// multi-record file.
+func (fr *FileRecord) RecordPK(rec Record) string {
+	pk := EntityPK(fr.FilePath)
+	if fr.MultiRecord {
+		pk += "#" + rec.Key
+	}
+	return pk
 }
 
 // ExpandedRecord is a flattened row ready for insertion, produced by the builder
@@ -49,9 +98,43 @@ type ExpandedRecord struct {
 	ModTime    time.Time      // for __modified_at__
 	CreatedAt  time.Time      // for __created_at__
 	Checksum   string         // for __checksum__
+	DeletedAt  time.Time      // for __deleted_at__ (zero when not deleted)
+	Line       int            // for __source_line__ (zero when unknown)
 	Fields     map[string]any // scalar fields and resolved EntityRef values only
 }
 
+// FieldOrder returns the names of r.Fields in source document order. Fields
+// the loader did not record an order for follow in sorted order, so the
+// result is stable from run to run either way.
+func (r Record) FieldOrder() []string {
+	return OrderedKeys(r.Fields, r.Keys)
+}
+
+// OrderedKeys returns the keys of fields, taking those listed in order first
+// (skipping names not in fields and repeats) and the rest sorted.
+func OrderedKeys(fields map[string]any, order []string) []string {
+	keys := make([]string, 0, len(fields))
+	seen := make(map[string]struct{}, len(fields))
+	for _, k := range order {
+		if _, ok := fields[k]; !ok {
+			continue
+		}
+		if _, dup := seen[k]; dup {
+			continue
+		}
+		seen[k] = struct{}{}
+		keys = append(keys, k)
+	}
+	rest := make([]string, 0, len(fields)-len(keys))
+	for k := range fields {
+		if _, ok := seen[k]; !ok {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
 // Loader can parse files of specific extension(s).
 type Loader interface {
 	// Extensions returns the file extensions this loader handles (lowercase, with dot).
@@ -60,22 +143,99 @@ type Loader interface {
 	Load(absPath, relPath string) (*FileRecord, error)
 }
 
+// Describer is implemented by loaders that describe themselves for the
+// loaders command.
+type Describer interface {
+	// Name is the loader's short name, e.g. "yaml".
+	Name() string
+	// Options lists the format features the loader supports beyond plain
+	// key/value fields.
+	Options() []string
+}
+
+// ContextLoader is a Loader whose loading can be stopped, such as one that
+// runs a command. Registry.LoadFile calls LoadContext instead of Load with
+// the context given to SetContext.
+type ContextLoader interface {
+	Loader
+	LoadContext(ctx context.Context, absPath, relPath string) (*FileRecord, error)
+}
+
+// Info describes a registered loader.
+type Info struct {
+	Name       string   `json:"name"`
+	Extensions []string `json:"extensions"`
+	Options    []string `json:"options"`
+	// Plugin is set for loaders added with RegisterPlugin.
+	Plugin bool `json:"plugin"`
+}
+
 // Registry holds all registered loaders and dispatches by file extension.
 type Registry struct {
-	loaders map[string]Loader
+	loaders   map[string]Loader
+	tableFor  func(relPath string) string
+	settle    time.Duration   // see SetSettleTime
+	keyFields []string        // see SetKeyField
+	ctx       context.Context // see SetContext
 }
 
+// DefaultKeyFields are the fields the records of multi-record files are
+// keyed by, in order of preference, unless SetKeyField names another.
+var DefaultKeyFields = []string{"id", "key"}
+
 // NewRegistry returns a Registry pre-populated with all built-in loaders.
 func NewRegistry() *Registry {
-	r := &Registry{loaders: make(map[string]Loader)}
+	r := &Registry{loaders: make(map[string]Loader), keyFields: DefaultKeyFields, ctx: context.Background()}
 	r.Register(&YAMLLoader{})
 	r.Register(&TOMLLoader{})
 	r.Register(&HJSONLoader{})
+	r.Register(&JSONLinesLoader{})
+	r.Register(&ParquetLoader{})
+	r.Register(&XLSXLoader{})
 	r.Register(&XMLLoader{})
 	r.Register(&PlistLoader{})
+	r.Register(&INILoader{})
+	r.Register(&DotenvLoader{})
+	r.Register(&MarkdownLoader{})
+	for _, l := range Plugins() {
+		r.Register(l)
+	}
 	return r
 }
 
+var (
+	pluginsMu sync.Mutex
+	plugins   []Loader
+)
+
+// RegisterPlugin adds l to every Registry that NewRegistry returns from then
+// on, after the built-in loaders, so that it replaces the built-in loader of
+// any extension they share. Applications embedding sqlfs register their
+// loaders this way before building; see the sqlfs package.
+func RegisterPlugin(l Loader) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins = append(plugins, l)
+}
+
+// Plugins returns the loaders added with RegisterPlugin, in the order they
+// were registered.
+func Plugins() []Loader {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	return append([]Loader(nil), plugins...)
+}
+
+// isPlugin reports whether l was added with RegisterPlugin.
+func isPlugin(l Loader) bool {
+	for _, p := range Plugins() {
+		if p == l {
+			return true
+		}
+	}
+	return false
+}
+
 // Register adds a Loader for its declared extensions.
 func (r *Registry) Register(l Loader) {
 	for _, ext := range l.Extensions() {
@@ -92,21 +252,223 @@ func (r *Registry) SupportedExtensions() []string {
 	return exts
 }
 
+// Loaders describes the registered loaders, sorted by name, each with the
+// extensions it handles. A loader that is not a Describer is named after its
+// type.
+func (r *Registry) Loaders() []Info {
+	byLoader := make(map[Loader]*Info)
+	var infos []*Info
+	for ext, l := range r.loaders {
+		info, ok := byLoader[l]
+		if !ok {
+			info = &Info{Name: fmt.Sprintf("%T", l), Options: []string{}, Plugin: isPlugin(l)}
+			if d, ok := l.(Describer); ok {
+				info.Name = d.Name()
+				info.Options = append(info.Options, d.Options()...)
+			}
+			byLoader[l] = info
+			infos = append(infos, info)
+		}
+		info.Extensions = append(info.Extensions, ext)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	out := make([]Info, len(infos))
+	for i, info := range infos {
+		sort.Strings(info.Extensions)
+		out[i] = *info
+	}
+	return out
+}
+
+// LoaderName returns the name of the loader that handles path, or "" if its
+// extension is not supported.
+func (r *Registry) LoaderName(path string) string {
+	l, ok := r.loaders[loaderExt(path)]
+	if !ok {
+		return ""
+	}
+	if d, ok := l.(Describer); ok {
+		return d.Name()
+	}
+	return fmt.Sprintf("%T", l)
+}
+
 // IsSupported reports whether the file has a supported extension.
 func (r *Registry) IsSupported(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	_, ok := r.loaders[ext]
+	_, ok := r.loaders[loaderExt(path)]
 	return ok
 }
 
-// LoadFile dispatches to the appropriate loader based on file extension.
+// GzipExt is the extension of gzip-compressed data files. Such a file is
+// loaded by the loader of the extension before it, e.g. people.users.yaml.gz
+// by the YAML loader, and is otherwise named as if it were not compressed.
+const GzipExt = ".gz"
+
+// loaderExt returns the lower-cased extension that selects the loader of
+// path: its own, or for a compressed file the one before GzipExt.
+func loaderExt(path string) string {
+	return strings.ToLower(filepath.Ext(trimGzip(path)))
+}
+
+// trimGzip returns path without a trailing GzipExt, in any case.
+func trimGzip(path string) string {
+	if ext := filepath.Ext(path); strings.EqualFold(ext, GzipExt) {
+		return strings.TrimSuffix(path, ext)
+	}
+	return path
+}
+
+// SetSettleTime makes LoadFile retry files that fail to load while they were
+// modified less than d ago, since editors may save a file in several writes
+// and leave it briefly truncated. Zero, the default, disables retries.
+func (r *Registry) SetSettleTime(d time.Duration) {
+	r.settle = d
+}
+
+// SetKeyField makes LoadFile key the records of multi-record files by the
+// named field instead of DefaultKeyFields. An empty name restores the
+// default.
+func (r *Registry) SetKeyField(name string) {
+	r.keyFields = DefaultKeyFields
+	if name != "" {
+		r.keyFields = []string{name}
+	}
+}
+
+// SetContext makes LoadFile pass ctx to ContextLoaders, so that cancelling
+// it, or its deadline passing, stops them.
+func (r *Registry) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// load loads the file at absPath with l.
+func (r *Registry) load(l Loader, absPath, relPath string) (*FileRecord, error) {
+	if cl, ok := l.(ContextLoader); ok {
+		return cl.LoadContext(r.ctx, absPath, relPath)
+	}
+	return l.Load(absPath, relPath)
+}
+
+// Retries of files still settling wait settleRetryDelay, doubling up to
+// maxSettleRetryDelay.
+const (
+	settleRetryDelay    = 50 * time.Millisecond
+	maxSettleRetryDelay = 800 * time.Millisecond
+)
+
+// LoadFile dispatches to the appropriate loader based on file extension,
+// and keys the records of a multi-record file with KeyRecords. A file
+// compressed with gzip is decompressed as it is read (see GzipExt).
 func (r *Registry) LoadFile(absPath, relPath string) (*FileRecord, error) {
-	ext := strings.ToLower(filepath.Ext(absPath))
+	ext := loaderExt(absPath)
 	l, ok := r.loaders[ext]
 	if !ok {
 		return nil, fmt.Errorf("no loader for extension %q", ext)
 	}
-	return l.Load(absPath, relPath)
+	fr, err := r.load(l, absPath, relPath)
+	for delay := settleRetryDelay; err != nil && delay <= maxSettleRetryDelay && r.settling(absPath) && r.ctx.Err() == nil; delay *= 2 {
+		time.Sleep(delay)
+		fr, err = r.load(l, absPath, relPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fr.MultiRecord {
+		if err := KeyRecords(fr.Records, r.keyFields); err != nil {
+			return nil, err
+		}
+	}
+	return fr, nil
+}
+
+// KeyRecords sets the Key of each of the records of a multi-record file to
+// the value of the first of fields the record has a scalar value for, or
+// else to its index. Two records with the same key are an error.
+func KeyRecords(records []Record, fields []string) error {
+	seen := make(map[string]int, len(records))
+	for i := range records {
+		rec := &records[i]
+		rec.Key = strconv.Itoa(i)
+		for _, name := range fields {
+			if v, ok := rec.Fields[name]; ok && v != nil && !isStructured(v) {
+				rec.Key = fmt.Sprint(v)
+				break
+			}
+		}
+		if j, ok := seen[rec.Key]; ok {
+			return fmt.Errorf("records %d and %d have the same key %q", j, i, rec.Key)
+		}
+		seen[rec.Key] = i
+	}
+	return nil
+}
+
+// isStructured reports whether v is a list or object rather than a scalar.
+func isStructured(v any) bool {
+	switch v.(type) {
+	case []any, map[string]any:
+		return true
+	}
+	return false
+}
+
+// objectList returns the elements of v when v is a non-empty list of
+// objects, the array-of-records layout of a file, and false otherwise.
+func objectList(v any) ([]map[string]any, bool) {
+	list, ok := v.([]any)
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	objs := make([]map[string]any, len(list))
+	for i, elem := range list {
+		m, ok := elem.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		objs[i] = m
+	}
+	return objs, true
+}
+
+// setObjectRecords fills fr with a record per object of the array-of-records
+// layout. Where each object starts in the file is not known.
+func setObjectRecords(fr *FileRecord, objs []map[string]any) {
+	fr.MultiRecord = true
+	fr.Records = make([]Record, len(objs))
+	for i, m := range objs {
+		rec := buildRecord("", m)
+		rec.Lines = nil
+		fr.Records[i] = rec
+	}
+}
+
+// settling reports whether the file at absPath was modified within the
+// registry's settle time.
+func (r *Registry) settling(absPath string) bool {
+	if r.settle <= 0 {
+		return false
+	}
+	info, err := os.Stat(absPath)
+	return err == nil && time.Since(info.ModTime()) < r.settle
+}
+
+// SetTableMapper makes TableOf ask f for a file's table before falling back
+// to the file name; f returns "" for files it does not map.
+func (r *Registry) SetTableMapper(f func(relPath string) string) {
+	r.tableFor = f
+}
+
+// TableOf returns the table the file at relPath belongs to: the one the table
+// mapper assigns, if any, and otherwise its EntityType. Loaders set
+// FileRecord.EntityType from the file name alone, so callers that map tables
+// must overwrite it.
+func (r *Registry) TableOf(relPath string) string {
+	if r.tableFor != nil {
+		if t := r.tableFor(relPath); t != "" {
+			return t
+		}
+	}
+	return EntityType(relPath)
 }
 
 // EntityType extracts the entity type from a relative file path.
@@ -117,7 +479,7 @@ func (r *Registry) LoadFile(absPath, relPath string) (*FileRecord, error) {
 //	"users/alice.users.yaml"               → "users"
 //	"alice.yaml"                           → "" (no entity type)
 func EntityType(relPath string) string {
-	base := filepath.Base(relPath)
+	base := filepath.Base(trimGzip(relPath))
 	ext := filepath.Ext(base)
 	stem := strings.TrimSuffix(base, ext)
 	lastDot := strings.LastIndex(stem, ".")
@@ -135,7 +497,7 @@ func EntityType(relPath string) string {
 //	"users/alice.users.yaml"               → "alice"
 //	"alice.yaml"                           → "alice"
 func EntityKey(relPath string) string {
-	base := filepath.Base(relPath)
+	base := filepath.Base(trimGzip(relPath))
 	ext := filepath.Ext(base)
 	stem := strings.TrimSuffix(base, ext)
 	lastDot := strings.LastIndex(stem, ".")
@@ -153,6 +515,8 @@ func EntityKey(relPath string) string {
 //	"recipes/celeriac-veloute.recipe.yaml" → "recipes/celeriac-veloute"
 //	"users/alice.users.yaml"               → "users/alice"
 func EntityPK(relPath string) string {
+	// Keys use forward slashes on every platform, matching entity refs.
+	relPath = filepath.ToSlash(trimGzip(relPath))
 	// Strip file extension.
 	ext := filepath.Ext(relPath)
 	noExt := strings.TrimSuffix(relPath, ext)
@@ -183,6 +547,20 @@ func flattenValue(v any) any {
 	}
 }
 
+// repeatedKeys returns the keys that occur more than once in keys, each listed
+// once in order of first repetition.
+func repeatedKeys(keys []string) []string {
+	seen := make(map[string]int, len(keys))
+	var dups []string
+	for _, k := range keys {
+		seen[k]++
+		if seen[k] == 2 {
+			dups = append(dups, k)
+		}
+	}
+	return dups
+}
+
 // rawBytesChecksum computes the MD5 hex of a byte slice.
 func rawBytesChecksum(data []byte) string {
 	h := md5.New()
@@ -190,34 +568,131 @@ func rawBytesChecksum(data []byte) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+// RecordChecksum computes the hex MD5 of a record's canonicalized fields: the
+// JSON encoding of the field map (keys sorted), with EntityRefs replaced by
+// their paths. Equal field maps always produce equal checksums.
+func RecordChecksum(fields map[string]any) string {
+	canon := make(map[string]any, len(fields))
+	for k, v := range fields {
+		if ref, ok := v.(EntityRef); ok {
+			v = ref.Path
+		}
+		canon[k] = v
+	}
+	b, err := json.Marshal(canon)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%v", canon))
+	}
+	return rawBytesChecksum(b)
+}
+
+// FileChecksum streams the file at path through MD5 without holding it in
+// memory, returning the hex checksum and the number of bytes read.
+func FileChecksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := md5.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), n, nil
+}
+
 // readFile reads a file and returns its bytes plus metadata.
-// EntityType is left empty; the loader sets it after parsing.
+// The checksum is computed while the file is read, in a single pass.
+// EntityType is left empty; the loader sets it after parsing. The bytes of
+// a file compressed with gzip are decompressed, while its checksum and size
+// remain those of the file as stored.
 func readFile(absPath, relPath string) ([]byte, *FileRecord, error) {
-	data, err := os.ReadFile(absPath)
+	f, err := os.Open(absPath)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer f.Close()
 
-	info, err := os.Stat(absPath)
+	info, err := f.Stat()
 	if err != nil {
 		return nil, nil, err
 	}
 
+	h := md5.New()
+	buf := bytes.NewBuffer(make([]byte, 0, info.Size()+bytes.MinRead))
+	if _, err := buf.ReadFrom(io.TeeReader(f, h)); err != nil {
+		return nil, nil, err
+	}
+
 	fr := &FileRecord{
 		FilePath:  relPath,
 		ModTime:   info.ModTime(),
 		CreatedAt: fileCreatedAt(info, absPath),
-		Checksum:  rawBytesChecksum(data),
+		Checksum:  fmt.Sprintf("%x", h.Sum(nil)),
+		Size:      int64(buf.Len()),
+	}
+	if trimGzip(absPath) != absPath {
+		data, err := gunzip(buf.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, fr, nil
+	}
+	return buf.Bytes(), fr, nil
+}
+
+// ReadFile reads the file at absPath for a loader, returning its contents,
+// decompressed if it is compressed with gzip, and a FileRecord holding its
+// path, times, checksum and size, for the loader to fill in with EntityType
+// and Records.
+func ReadFile(absPath, relPath string) ([]byte, *FileRecord, error) {
+	return readFile(absPath, relPath)
+}
+
+// NewRecord returns the record keyed key of a loader with the top-level
+// fields m. Arrays are kept for the builder to expand into child tables,
+// and other nested values are stored as JSON text.
+func NewRecord(key string, m map[string]any) Record {
+	return buildRecord(key, m)
+}
+
+// maxGunzipped bounds the decompressed size of a gzip-compressed file, so
+// that a small corrupt or hostile file cannot make the loader read without
+// end.
+const maxGunzipped = 1 << 30
+
+// gunzip returns the decompressed contents of the gzip data, which may
+// hold several members, limited to maxGunzipped bytes.
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
+	}
+	defer zr.Close()
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(io.LimitReader(zr, maxGunzipped+1)); err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
 	}
-	return data, fr, nil
+	if out.Len() > maxGunzipped {
+		return nil, fmt.Errorf("decompressing: larger than %d MB", maxGunzipped>>20)
+	}
+	return out.Bytes(), nil
 }
 
-// buildRecord creates a single Record from a field map, flattening nested structures.
+// buildRecord creates a single Record from a field map.
+// Top-level arrays are kept as []any so the builder can expand them into child
+// tables, as it does for YAML; other nested structures are flattened to JSON.
 // Used by non-YAML loaders (TOML, HJSON, XML, plist) which do not support EntityRefs.
 func buildRecord(key string, m map[string]any) Record {
 	fields := make(map[string]any, len(m))
 	for k, v := range m {
+		if arr, ok := v.([]any); ok {
+			fields[k] = arr
+			continue
+		}
 		fields[k] = flattenValue(v)
 	}
-	return Record{Key: key, Fields: fields}
+	// The record is the whole file; where its elements are is not known.
+	return Record{Key: key, Fields: fields, Lines: map[string]int{"": 1}}
 }
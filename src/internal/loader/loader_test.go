@@ -1,8 +1,17 @@
 package loader
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func absPath(name string) string {
@@ -91,6 +100,174 @@ func TestYAMLLoader_Anchors(t *testing.T) {
 	}
 }
 
+func TestLoaders_MultiRecord(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"staff.users.yaml":  "id: alice\nname: Alice\n---\nkey: 7\nname: Bob\n---\nname: Carol\n---\n",
+		"list.users.yaml":   "- id: dan\n  name: Dan\n- name: Eve\n",
+		"list.users.json":   `[{"id": "fay", "name": "Fay"}, {"name": "Gus"}]`,
+		"list.users.plist":  `<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><array><dict><key>name</key><string>Hal</string></dict></array></plist>`,
+		"single.users.yaml": "name: Dana\n---\n",
+		"tags.users.json":   `["a", "b"]`,
+		"dupes.users.yaml":  "- id: a\n- id: a\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reg := NewRegistry()
+	load := func(name string) *FileRecord {
+		t.Helper()
+		fr, err := reg.LoadFile(filepath.Join(dir, name), "people/"+name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return fr
+	}
+	pks := func(fr *FileRecord) []string {
+		var out []string
+		for _, rec := range fr.Records {
+			out = append(out, fr.RecordPK(rec))
+		}
+		return out
+	}
+
+	for name, want := range map[string][]string{
+		"staff.users.yaml":  {"people/staff#alice", "people/staff#7", "people/staff#2"},
+		"list.users.yaml":   {"people/list#dan", "people/list#1"},
+		"list.users.json":   {"people/list#fay", "people/list#1"},
+		"list.users.plist":  {"people/list#0"},
+		"single.users.yaml": {"people/single"},
+		"tags.users.json":   {"people/tags"},
+	} {
+		if got := pks(load(name)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: record PKs = %v, want %v", name, got, want)
+		}
+	}
+	if got := load("staff.users.yaml").Records[1].Lines[""]; got != 4 {
+		t.Errorf("second document starts on line %d, want 4", got)
+	}
+	if got := load("list.users.yaml").Records[1].Lines[""]; got != 3 {
+		t.Errorf("second list element starts on line %d, want 3", got)
+	}
+
+	reg.SetKeyField("name")
+	if got, want := pks(load("list.users.yaml")), []string{"people/list#Dan", "people/list#Eve"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keyed by name: record PKs = %v, want %v", got, want)
+	}
+	reg.SetKeyField("")
+
+	if _, err := reg.LoadFile(filepath.Join(dir, "dupes.users.yaml"), "dupes.users.yaml"); err == nil || !strings.Contains(err.Error(), `same key "a"`) {
+		t.Errorf("duplicate record keys: err = %v", err)
+	}
+}
+
+func TestMarkdownLoader(t *testing.T) {
+	l := &MarkdownLoader{}
+	fr, err := l.Load(absPath("hello.posts.md"), "hello.posts.md")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "posts" {
+		t.Errorf("EntityType = %q, want posts", fr.EntityType)
+	}
+	rec := fr.Records[0]
+	if rec.Fields["title"] != "Hello, world" || rec.Fields["draft"] != false {
+		t.Errorf("front matter fields = %v", rec.Fields)
+	}
+	if ref, ok := rec.Fields["author"].(EntityRef); !ok || ref.Path != "authors/alice" {
+		t.Errorf("author = %#v, want EntityRef to authors/alice", rec.Fields["author"])
+	}
+	if body := rec.Fields["body"]; body != "# Hello\n\nFirst post.\n" {
+		t.Errorf("body = %q", body)
+	}
+	if got := rec.FieldOrder(); !reflect.DeepEqual(got, []string{"title", "draft", "author", "body"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+}
+
+func TestLoaders_Lines(t *testing.T) {
+	dir := t.TempDir()
+	yamlDoc := `# a comment
+name: Menu
+courses:
+  - title: Soup
+    sides:
+      - bread
+      - name: salad
+  - title: Fish
+tags: [a, b]
+`
+	files := map[string]string{
+		"menu.menus.yaml": yamlDoc,
+		"menu.menus.md":   "---\n" + yamlDoc + "---\nBody\n",
+		"menu.menus.toml": "name = \"Menu\"\n",
+	}
+	want := map[string]map[string]int{
+		"menu.menus.yaml": {"": 2, "courses/0": 4, "courses/0/sides/0": 6, "courses/0/sides/1": 7, "courses/1": 8, "tags/0": 9, "tags/1": 9},
+		"menu.menus.md":   {"": 1, "courses/0": 5, "courses/0/sides/0": 7, "courses/0/sides/1": 8, "courses/1": 9, "tags/0": 10, "tags/1": 10},
+		"menu.menus.toml": {"": 1},
+	}
+	reg := NewRegistry()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fr, err := reg.LoadFile(path, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := fr.Records[0].Lines; !reflect.DeepEqual(got, want[name]) {
+			t.Errorf("%s: Lines = %v, want %v", name, got, want[name])
+		}
+	}
+	if got := LinePath("courses/0", "sides", 1); got != "courses/0/sides/1" {
+		t.Errorf("LinePath = %q", got)
+	}
+}
+
+func TestMarkdownLoader_BodyField(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain.pages.md")
+	if err := os.WriteFile(path, []byte("No front matter here.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fr, err := (&MarkdownLoader{BodyField: "content"}).Load(path, "plain.pages.md")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fr.Records[0].Fields; !reflect.DeepEqual(got, map[string]any{"content": "No front matter here.\n"}) {
+		t.Errorf("Fields = %v", got)
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		in, front, body string
+		wantErr         bool
+	}{
+		{in: "---\na: 1\n---\nbody\n", front: "a: 1\n", body: "body\n"},
+		{in: "---\r\na: 1\r\n...\r\nbody", front: "a: 1\r\n", body: "body"},
+		{in: "---\na: 1\n---", front: "a: 1\n", body: ""},
+		{in: "text\n---\n", front: "", body: "text\n---\n"},
+		{in: "---\na: 1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		front, body, err := splitFrontMatter([]byte(tt.in))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil || string(front) != tt.front || string(body) != tt.body {
+			t.Errorf("%q: got %q, %q, %v; want %q, %q", tt.in, front, body, err, tt.front, tt.body)
+		}
+	}
+}
+
 func TestTOMLLoader(t *testing.T) {
 	l := &TOMLLoader{}
 	fr, err := l.Load(absPath("widget.things.toml"), "widget.things.toml")
@@ -114,6 +291,30 @@ func TestTOMLLoader(t *testing.T) {
 	}
 }
 
+func TestTOMLLoader_ArrayOfTables(t *testing.T) {
+	dir := t.TempDir()
+	src := "name = \"Alice\"\n\n[[orders]]\nsku = \"A1\"\nqty = 2\n\n[[orders]]\nsku = \"B2\"\nqty = 1\n"
+	path := filepath.Join(dir, "alice.users.toml")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fr, err := (&TOMLLoader{}).Load(path, "alice.users.toml")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	orders, ok := fr.Records[0].Fields["orders"].([]any)
+	if !ok {
+		t.Fatalf("orders should be []any, got %T", fr.Records[0].Fields["orders"])
+	}
+	if len(orders) != 2 {
+		t.Fatalf("len(orders) = %d, want 2", len(orders))
+	}
+	if o, ok := orders[0].(map[string]any); !ok || o["sku"] != "A1" {
+		t.Errorf("orders[0] = %#v", orders[0])
+	}
+}
+
 func TestHJSONLoader(t *testing.T) {
 	l := &HJSONLoader{}
 	fr, err := l.Load(absPath("config.settings.json"), "config.settings.json")
@@ -137,6 +338,146 @@ func TestHJSONLoader(t *testing.T) {
 	}
 }
 
+func TestJSONLinesLoader(t *testing.T) {
+	fr, err := NewRegistry().LoadFile(absPath("events.logs.jsonl"), "events.logs.jsonl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "logs" || !fr.MultiRecord {
+		t.Errorf("EntityType = %q, MultiRecord = %v", fr.EntityType, fr.MultiRecord)
+	}
+	if len(fr.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(fr.Records))
+	}
+	first, second := fr.Records[0], fr.Records[1]
+	if fr.RecordPK(first) != "events#e1" || fr.RecordPK(second) != "events#1" {
+		t.Errorf("record PKs = %q, %q", fr.RecordPK(first), fr.RecordPK(second))
+	}
+	if first.Fields["at"] != float64(12) || !reflect.DeepEqual(first.Fields["tags"], []any{"a"}) {
+		t.Errorf("Fields = %v", first.Fields)
+	}
+	if got := first.FieldOrder(); !reflect.DeepEqual(got, []string{"id", "kind", "at", "tags"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+	if first.Lines[""] != 1 || second.Lines[""] != 3 {
+		t.Errorf("lines = %d, %d, want 1, 3", first.Lines[""], second.Lines[""])
+	}
+	if !reflect.DeepEqual(second.DuplicateKeys, []string{"kind"}) {
+		t.Errorf("DuplicateKeys = %v", second.DuplicateKeys)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bad.logs.ndjson")
+	if err := os.WriteFile(path, []byte("{\"a\": 1}\n[1, 2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&JSONLinesLoader{}).Load(path, "bad.logs.ndjson"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("non-object line: err = %v", err)
+	}
+}
+
+func TestParquetLoader(t *testing.T) {
+	data, err := os.ReadFile("../parquet/testdata/sample.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "sample.people.parquet")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fr, err := NewRegistry().LoadFile(path, "sample.people.parquet")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "people" || !fr.MultiRecord {
+		t.Errorf("EntityType = %q, MultiRecord = %v", fr.EntityType, fr.MultiRecord)
+	}
+	if len(fr.Records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(fr.Records))
+	}
+	first := fr.Records[0]
+	if fr.RecordPK(first) != "sample#1" {
+		t.Errorf("record PK = %q", fr.RecordPK(first))
+	}
+	if first.Fields["name"] != "alice" || first.Fields["id"] != int64(1) {
+		t.Errorf("Fields = %v", first.Fields)
+	}
+	if first.Fields["tags"] != `["a","b"]` || first.Fields["address"] != `{"city":"Oslo","zip":150}` {
+		t.Errorf("nested fields = %q, %q", first.Fields["tags"], first.Fields["address"])
+	}
+	if got := first.FieldOrder(); !reflect.DeepEqual(got, []string{"id", "name", "score", "active", "day", "tags", "address", "ts"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+	if fr.Records[2].Lines[""] != 3 {
+		t.Errorf("third record line = %d, want 3", fr.Records[2].Lines[""])
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.people.parquet")
+	if err := os.WriteFile(bad, []byte("id,name\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ParquetLoader{}).Load(bad, "bad.people.parquet"); err == nil {
+		t.Error("want an error for a file that is not Parquet")
+	}
+}
+
+func TestXLSXLoader(t *testing.T) {
+	fr, err := NewRegistry().LoadFile(absPath("q3.budget.xlsx"), "q3.budget.xlsx")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "budget" || fr.MultiRecord || len(fr.Records) != 1 {
+		t.Fatalf("EntityType = %q, MultiRecord = %v, %d records", fr.EntityType, fr.MultiRecord, len(fr.Records))
+	}
+	rec := fr.Records[0]
+	if rec.Key != "q3" {
+		t.Errorf("Key = %q, want q3", rec.Key)
+	}
+	if got := rec.FieldOrder(); !reflect.DeepEqual(got, []string{"staff", "trips"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+
+	want := []any{
+		map[string]any{"name": "Ada Lovelace", "salary": 5200.5, "start": "2024-01-15", "active": true},
+		map[string]any{"name": "Alan Turing", "salary": int64(10401), "active": false},
+	}
+	if !reflect.DeepEqual(rec.Fields["staff"], want) {
+		t.Errorf("staff = %#v, want %#v", rec.Fields["staff"], want)
+	}
+	if rec.Lines[LinePath("", "staff", 0)] != 2 || rec.Lines[LinePath("", "staff", 1)] != 5 {
+		t.Errorf("Lines = %v, want rows 2 and 5", rec.Lines)
+	}
+	want = []any{map[string]any{"city": "Oslo", "note": "xy"}}
+	if !reflect.DeepEqual(rec.Fields["trips"], want) {
+		t.Errorf("trips = %#v, want %#v", rec.Fields["trips"], want)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.budget.xlsx")
+	if err := os.WriteFile(bad, []byte("name,salary\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&XLSXLoader{}).Load(bad, "bad.budget.xlsx"); err == nil {
+		t.Error("want an error for a file that is not a workbook")
+	}
+}
+
+func TestIsDateFormat(t *testing.T) {
+	for code, want := range map[string]bool{
+		"yyyy-mm-dd":      true,
+		"[h]:mm:ss":       true,
+		"h:mm AM/PM":      true,
+		"0.00":            false,
+		"#,##0 \"days\"":  false,
+		"[Red]0.00":       false,
+		"General":         false,
+		"0;[Red]-0;\"d\"": false,
+	} {
+		if got := isDateFormat(code); got != want {
+			t.Errorf("isDateFormat(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
 func TestXMLLoader(t *testing.T) {
 	l := &XMLLoader{}
 	fr, err := l.Load(absPath("catalog.items.xml"), "catalog.items.xml")
@@ -183,6 +524,236 @@ func TestPlistLoader(t *testing.T) {
 	}
 }
 
+func TestINILoader(t *testing.T) {
+	fr, err := NewRegistry().LoadFile(absPath("app.services.ini"), "app.services.ini")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "services" || len(fr.Records) != 1 {
+		t.Fatalf("EntityType = %q, %d records", fr.EntityType, len(fr.Records))
+	}
+	rec := fr.Records[0]
+	if rec.Key != "app" || rec.Fields["name"] != "billing" || rec.Fields["owner"] != "payments team" {
+		t.Errorf("record = %q %v", rec.Key, rec.Fields)
+	}
+	if got := rec.Fields["database"]; got != `{"host":"db.internal","password":"p;w#d","port":"5432"}` {
+		t.Errorf("database = %v", got)
+	}
+	if got := rec.Fields["cache"]; got != `{"url":"redis://cache:6379"}` {
+		t.Errorf("cache = %v", got)
+	}
+	if got := rec.FieldOrder(); !reflect.DeepEqual(got, []string{"name", "owner", "database", "cache"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+
+	for src, want := range map[string]string{
+		"[db\nhost = x\n":      "line 1: unterminated section header",
+		"a = 1\n[]\n":          "line 2: empty section name",
+		"a = 1\njust a line\n": "line 2: expected key = value",
+		"a = 1\n[a]\nb = 2\n":  `line 2: section "a" has the name of a key`,
+		"[s] extra\n":          `line 1: unexpected "extra" after section header`,
+	} {
+		if _, _, _, err := parseINI([]byte(src)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseINI(%q) error = %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestDotenvLoader(t *testing.T) {
+	fr, err := NewRegistry().LoadFile(absPath("staging.deploys.env"), "staging.deploys.env")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "deploys" || len(fr.Records) != 1 {
+		t.Fatalf("EntityType = %q, %d records", fr.EntityType, len(fr.Records))
+	}
+	rec := fr.Records[0]
+	want := map[string]any{
+		"REGION":   "eu-west-1",
+		"REPLICAS": "3",
+		"GREETING": "hello\nworld",
+		"CERT":     "-----BEGIN-----\nabc\n-----END-----",
+		"LITERAL":  `no $expansion \n here`,
+		"EMPTY":    "",
+	}
+	if !reflect.DeepEqual(rec.Fields, want) {
+		t.Errorf("Fields = %#v", rec.Fields)
+	}
+	if got := rec.FieldOrder(); !reflect.DeepEqual(got, []string{"REGION", "REPLICAS", "GREETING", "CERT", "LITERAL", "EMPTY"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+
+	for src, want := range map[string]string{
+		"A=1\nB\n":            "line 2: expected KEY=VALUE",
+		"A=1\n\nB=\"x\ny\n":   `line 3: unterminated "-quoted value`,
+		"A=\"x\ny\"\nC D=1\n": `line 3: invalid variable name "C D"`,
+		"A='x' y\n":           `line 1: unexpected "y" after the value`,
+	} {
+		if _, _, err := parseDotenv(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseDotenv(%q) error = %v, want %q", src, err, want)
+		}
+	}
+	fields, keys, err := parseDotenv("A=1\nA=2")
+	if err != nil || fields["A"] != "2" || !reflect.DeepEqual(repeatedKeys(keys), []string{"A"}) {
+		t.Errorf("repeated key: %v, %v, %v", fields, keys, err)
+	}
+}
+
+func TestExecLoader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "q3.sales.csv")
+	if err := os.WriteFile(path, []byte("{\"b\": 1, \"a\": \"x\"}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	load := func(script string) (*FileRecord, error) {
+		reg := NewRegistry()
+		reg.Register(&ExecLoader{Ext: ".csv", Command: []string{"sh", "-c", script}, Dir: dir, Allowed: true})
+		return reg.LoadFile(path, "q3.sales.csv")
+	}
+
+	fr, err := load("cat")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.EntityType != "sales" || fr.MultiRecord || len(fr.Records) != 1 || fr.Records[0].Key != "q3" {
+		t.Fatalf("EntityType = %q, MultiRecord = %v, records %+v", fr.EntityType, fr.MultiRecord, fr.Records)
+	}
+	if got := fr.Records[0].FieldOrder(); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("FieldOrder = %v", got)
+	}
+	if want, _, _ := FileChecksum(path); fr.Checksum != want {
+		t.Errorf("Checksum = %q, want that of the file", fr.Checksum)
+	}
+
+	fr, err = load(`printf '{"id":"a","file":"%s"}\n{"id":"b","dir":"%s"}\n' "$SQLFS_FILE" "$PWD"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !fr.MultiRecord || len(fr.Records) != 2 || fr.Records[0].Key != "a" || fr.Records[1].Key != "b" {
+		t.Fatalf("MultiRecord = %v, records %+v", fr.MultiRecord, fr.Records)
+	}
+	if fr.Records[0].Fields["file"] != "q3.sales.csv" || fr.Records[1].Fields["dir"] != dir {
+		t.Errorf("Fields = %v, %v", fr.Records[0].Fields, fr.Records[1].Fields)
+	}
+
+	// The command sees little of sqlfs's environment.
+	t.Setenv("SQLFS_PASSWORD", "hunter2")
+	fr, err = load(`printf '{"password":"%s","path":"%s"}' "$SQLFS_PASSWORD" "$PATH"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr.Records[0].Fields["password"] != "" || fr.Records[0].Fields["path"] != os.Getenv("PATH") {
+		t.Errorf("environment: %v", fr.Records[0].Fields)
+	}
+
+	fr, err = load(`echo '[{"n": 1}, {"n": 2}, {"n": 3}]'`)
+	if err != nil || !fr.MultiRecord || len(fr.Records) != 3 || fr.Records[2].Key != "2" {
+		t.Fatalf("array: %+v, %v", fr, err)
+	}
+
+	for script, want := range map[string]string{
+		"echo 'no such sheet' >&2; exit 3": "sh: exit status 3: no such sheet",
+		"true":                             "sh: output: no records",
+		"echo 1":                           "sh: output: expected JSON objects, got 1",
+		`echo '{"a":1} [{"a":2}]'`:         "sh: output: record 2 is not a JSON object",
+		`echo '[{"a":1}, 2]'`:              "sh: output: record 2 is not a JSON object",
+		`echo '{"a":'`:                     "sh: output: unexpected EOF",
+	} {
+		if _, err := load(script); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error = %v, want %q", script, err, want)
+		}
+	}
+
+	// Commands only run when allowed, and stop with the registry's context.
+	reg := NewRegistry()
+	reg.Register(&ExecLoader{Ext: ".csv", Command: []string{"cat"}, Dir: dir})
+	if _, err := reg.LoadFile(path, "q3.sales.csv"); err == nil || !strings.Contains(err.Error(), AllowExecEnvVar+"=1") {
+		t.Errorf("not allowed: error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	reg.Register(&ExecLoader{Ext: ".csv", Command: []string{"sleep", "10"}, Dir: dir, Allowed: true})
+	reg.SetContext(ctx)
+	start := time.Now()
+	if _, err := reg.LoadFile(path, "q3.sales.csv"); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("timed out: error = %v after %s", err, time.Since(start))
+	}
+}
+
+func TestLoaders_DuplicateKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"dup.users.yaml", "name: Alice\nage: 30\nname: Bob\n", []string{"name"}},
+		{"dup.users.json", `{"name": "Alice", "nested": {"a": 1, "a": 2}, "name": "Bob"}`, []string{"name"}},
+		{"dup.users.jsonc", "{\n  // comment\n  name: Alice\n  name: Bob\n}\n", []string{"name"}},
+		{"dup.users.plist", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+  <key>name</key><string>Alice</string>
+  <key>name</key><string>Bob</string>
+</dict></plist>`, []string{"name"}},
+		{"ok.users.yaml", "name: Alice\nage: 30\n", nil},
+		{"ok.users.json", `{"name": "Alice", "nested": {"a": 1, "a": 2}}`, nil},
+	}
+
+	dir := t.TempDir()
+	reg := NewRegistry()
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(path, []byte(tc.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fr, err := reg.LoadFile(path, tc.name)
+		if err != nil {
+			t.Fatalf("%s: Load: %v", tc.name, err)
+		}
+		rec := fr.Records[0]
+		if !reflect.DeepEqual(rec.DuplicateKeys, tc.want) {
+			t.Errorf("%s: DuplicateKeys = %v, want %v", tc.name, rec.DuplicateKeys, tc.want)
+		}
+		if tc.want != nil && rec.Fields["name"] != "Bob" {
+			t.Errorf("%s: name = %v, want last value Bob", tc.name, rec.Fields["name"])
+		}
+	}
+}
+
+func TestLoaders_FieldOrder(t *testing.T) {
+	want := []string{"zeta", "alpha", "mid"}
+	cases := []struct{ name, src string }{
+		{"o.users.yaml", "zeta: 1\nalpha: 2\nmid: 3\n"},
+		{"o.users.json", `{"zeta": 1, "alpha": 2, "mid": 3}`},
+		{"o.users.jsonc", "{\n  // comment\n  zeta: 1\n  alpha: 2\n  mid: 3\n}\n"},
+		{"o.users.toml", "zeta = 1\nalpha = 2\n\n[mid]\nx = 3\n"},
+	}
+
+	dir := t.TempDir()
+	reg := NewRegistry()
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(path, []byte(tc.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fr, err := reg.LoadFile(path, tc.name)
+		if err != nil {
+			t.Fatalf("%s: Load: %v", tc.name, err)
+		}
+		if got := fr.Records[0].FieldOrder(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: FieldOrder = %v, want %v", tc.name, got, want)
+		}
+	}
+}
+
+func TestOrderedKeys(t *testing.T) {
+	fields := map[string]any{"b": 1, "a": 2, "c": 3, "d": 4}
+	got := OrderedKeys(fields, []string{"c", "missing", "a", "c"})
+	want := []string{"c", "a", "b", "d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OrderedKeys = %v, want %v", got, want)
+	}
+}
+
 func TestRegistry_Dispatch(t *testing.T) {
 	reg := NewRegistry()
 
@@ -226,6 +797,70 @@ func TestRegistry_IsSupported(t *testing.T) {
 	if reg.IsSupported("file.dbml") {
 		t.Error(".dbml should not be supported")
 	}
+	if !reg.IsSupported("file.yaml.gz") || !reg.IsSupported("file.JSONL.GZ") {
+		t.Error("compressed files of supported formats should be supported")
+	}
+	if reg.IsSupported("file.gz") || reg.IsSupported("file.dbml.gz") {
+		t.Error("compressed files of other formats should not be supported")
+	}
+}
+
+func TestRegistry_Gzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("- id: a\n  n: 1\n"))
+	zw.Close()
+	// A second member, as written by concatenating gzip files.
+	zw = gzip.NewWriter(&buf)
+	zw.Write([]byte("- id: b\n  n: 2\n"))
+	zw.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "people.users.yaml.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	if name := reg.LoaderName(path); name != "yaml" {
+		t.Errorf("LoaderName = %q, want yaml", name)
+	}
+	fr, err := reg.LoadFile(path, "people.users.yaml.gz")
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if fr.EntityType != "users" || len(fr.Records) != 2 || fr.RecordPK(fr.Records[1]) != "people#b" || fmt.Sprint(fr.Records[1].Fields["n"]) != "2" {
+		t.Errorf("EntityType = %q, records = %+v", fr.EntityType, fr.Records)
+	}
+	sum, size, err := FileChecksum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Checksum != sum || fr.Size != size {
+		t.Errorf("Checksum, Size = %s, %d, want those of the compressed file: %s, %d", fr.Checksum, fr.Size, sum, size)
+	}
+
+	bad := filepath.Join(dir, "bad.users.yaml.gz")
+	if err := os.WriteFile(bad, []byte("name: not compressed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.LoadFile(bad, "bad.users.yaml.gz"); err == nil || !strings.Contains(err.Error(), "decompressing") {
+		t.Errorf("LoadFile of a file that is not gzip: err = %v", err)
+	}
+}
+
+func TestRegistry_Loaders(t *testing.T) {
+	infos := NewRegistry().Loaders()
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name+":"+strings.Join(info.Extensions, ","))
+	}
+	want := "dotenv:.env hjson:.json,.json5,.jsonc ini:.ini jsonl:.jsonl,.ndjson markdown:.markdown,.md parquet:.parquet plist:.plist toml:.toml xlsx:.xlsx xml:.xml yaml:.yaml,.yml"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("Loaders = %s, want %s", got, want)
+	}
+	if name := NewRegistry().LoaderName("users/alice.users.YML"); name != "yaml" {
+		t.Errorf("LoaderName = %q, want yaml", name)
+	}
 }
 
 func TestRegistry_UnsupportedExtension(t *testing.T) {
@@ -236,6 +871,41 @@ func TestRegistry_UnsupportedExtension(t *testing.T) {
 	}
 }
 
+func TestRegistry_SettleTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alice.users.yaml")
+	os.WriteFile(path, []byte("name: [Alice\n"), 0644) // as if half written
+
+	r := NewRegistry()
+	if _, err := r.LoadFile(path, "alice.users.yaml"); err == nil {
+		t.Fatal("expected a parse error without a settle time")
+	}
+
+	r.SetSettleTime(2 * time.Second)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(path, []byte("name: Alice\n"), 0644)
+	}()
+	fr, err := r.LoadFile(path, "alice.users.yaml")
+	if err != nil {
+		t.Fatalf("LoadFile of a file finished while settling: %v", err)
+	}
+	if fr.Records[0].Fields["name"] != "Alice" {
+		t.Errorf("name = %v, want Alice", fr.Records[0].Fields["name"])
+	}
+
+	// Files modified longer ago fail at once.
+	os.WriteFile(path, []byte("name: [Alice\n"), 0644)
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(path, old, old)
+	start := time.Now()
+	if _, err := r.LoadFile(path, "alice.users.yaml"); err == nil {
+		t.Fatal("expected a parse error for a settled file")
+	}
+	if d := time.Since(start); d > settleRetryDelay {
+		t.Errorf("LoadFile of a settled file took %s, want no retries", d)
+	}
+}
+
 func TestFlattenValue(t *testing.T) {
 	tests := []struct {
 		in   any
@@ -281,6 +951,8 @@ func TestEntityType(t *testing.T) {
 		{"alice.yaml", ""},
 		{"users/alice.yaml", ""},
 		{"post_tags/hw.post_tags.json", "post_tags"},
+		{"users/alice.users.yaml.gz", "users"},
+		{"alice.yaml.GZ", ""},
 	}
 	for _, tt := range tests {
 		got := EntityType(tt.path)
@@ -300,6 +972,7 @@ func TestEntityKey(t *testing.T) {
 		{"celeriac-veloute.recipe.yaml", "celeriac-veloute"},
 		{"alice.yaml", "alice"},
 		{"widget.toml", "widget"},
+		{"users/alice.users.json.gz", "alice"},
 	}
 	for _, tt := range tests {
 		got := EntityKey(tt.path)
@@ -318,6 +991,7 @@ func TestEntityPK(t *testing.T) {
 		{"users/alice.users.yaml", "users/alice"},
 		{"recipes/celeriac-veloute.recipe.yaml", "recipes/celeriac-veloute"},
 		{"alice.yaml", "alice"},
+		{"users/alice.users.yaml.gz", "users/alice"},
 	}
 	for _, tt := range tests {
 		got := EntityPK(tt.path)
@@ -326,3 +1000,104 @@ func TestEntityPK(t *testing.T) {
 		}
 	}
 }
+
+func TestRecordChecksum(t *testing.T) {
+	a := RecordChecksum(map[string]any{"name": "Alice", "age": int64(30), "mentor": EntityRef{Path: "users/bob"}})
+	b := RecordChecksum(map[string]any{"mentor": "users/bob", "age": int64(30), "name": "Alice"})
+	if a != b {
+		t.Errorf("equal field maps gave different checksums: %s vs %s", a, b)
+	}
+	if c := RecordChecksum(map[string]any{"name": "Alice", "age": int64(31), "mentor": "users/bob"}); c == a {
+		t.Error("changed field should change the checksum")
+	}
+}
+
+func TestReadFile_SizeAndChecksum(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("name: Alice\nage: 30\n")
+	path := filepath.Join(dir, "alice.users.yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fr, err := (&YAMLLoader{}).Load(path, "alice.users.yaml")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if fr.Size != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", fr.Size, len(data))
+	}
+	if want := rawBytesChecksum(data); fr.Checksum != want {
+		t.Errorf("Checksum = %s, want %s", fr.Checksum, want)
+	}
+
+	sum, n, err := FileChecksum(path)
+	if err != nil {
+		t.Fatalf("FileChecksum: %v", err)
+	}
+	if sum != fr.Checksum || n != fr.Size {
+		t.Errorf("FileChecksum = %s, %d; want %s, %d", sum, n, fr.Checksum, fr.Size)
+	}
+}
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("SQLFS_TEST_HOST", "db.internal")
+	fields := map[string]any{
+		"url":    "https://${SQLFS_TEST_HOST}/api",
+		"other":  "${HOME}",
+		"nested": []any{map[string]any{"host": "${SQLFS_TEST_HOST}"}},
+		"port":   int64(5432),
+	}
+	if err := InterpolateEnv(fields, []string{"SQLFS_TEST_HOST"}); err != nil {
+		t.Fatalf("InterpolateEnv: %v", err)
+	}
+	want := map[string]any{
+		"url":    "https://db.internal/api",
+		"other":  "${HOME}",
+		"nested": []any{map[string]any{"host": "db.internal"}},
+		"port":   int64(5432),
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+
+	err := InterpolateEnv(map[string]any{"a": "${SQLFS_TEST_UNSET}"}, []string{"SQLFS_TEST_UNSET"})
+	if err == nil {
+		t.Error("expected error for unset allowed variable")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		in   any
+		want time.Time
+	}{
+		{want, want},
+		{"2030-01-02T03:04:05Z", want},
+		{"2030-01-02T03:04:05+01:00", want.Add(-time.Hour)},
+		{"2030-01-02 03:04:05", time.Date(2030, 1, 2, 3, 4, 5, 0, ny)},
+		{"2030-01-02", time.Date(2030, 1, 2, 0, 0, 0, 0, ny)},
+		{want.Unix(), want},
+		{float64(want.Unix()), want},
+	}
+	for _, c := range cases {
+		got, err := ParseTime(c.in, ny)
+		if err != nil {
+			t.Errorf("ParseTime(%v): %v", c.in, err)
+			continue
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("ParseTime(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+	for _, bad := range []any{"soon", true, nil} {
+		if _, err := ParseTime(bad, ny); err == nil {
+			t.Errorf("ParseTime(%v): expected error", bad)
+		}
+	}
+}
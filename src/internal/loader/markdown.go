@@ -0,0 +1,93 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DefaultBodyField is the field that holds a Markdown file's body unless
+// MarkdownLoader.BodyField says otherwise.
+const DefaultBodyField = "body"
+
+// MarkdownLoader loads .md and .markdown files. YAML front matter, delimited
+// by "---" lines at the very start of the file, supplies the fields as in a
+// YAML file; the rest of the file is stored verbatim in BodyField. A file
+// without front matter is all body.
+type MarkdownLoader struct {
+	// BodyField names the field holding the body. Empty means
+	// DefaultBodyField.
+	BodyField string
+}
+
+func (MarkdownLoader) Extensions() []string { return []string{".md", ".markdown"} }
+
+func (MarkdownLoader) Name() string { return "markdown" }
+
+func (l MarkdownLoader) Options() []string {
+	return []string{
+		"YAML front matter between --- lines becomes fields, as in a YAML file",
+		fmt.Sprintf("the Markdown body is stored in the %q field", l.bodyField()),
+	}
+}
+
+func (l MarkdownLoader) bodyField() string {
+	if l.BodyField == "" {
+		return DefaultBodyField
+	}
+	return l.BodyField
+}
+
+func (l MarkdownLoader) Load(absPath, relPath string) (*FileRecord, error) {
+	data, fr, err := readFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	frontMatter, body, err := splitFrontMatter(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", relPath, err)
+	}
+	rec, err := yamlRecord(frontMatter, EntityKey(relPath))
+	if err != nil {
+		return nil, err
+	}
+	// The front matter follows the opening "---" line, where the record
+	// starts.
+	for p := range rec.Lines {
+		rec.Lines[p]++
+	}
+	rec.Lines[""] = 1
+
+	field := l.bodyField()
+	if _, ok := rec.Fields[field]; ok {
+		rec.DuplicateKeys = append(rec.DuplicateKeys, field)
+	}
+	rec.Fields[field] = string(body)
+	rec.Keys = append(rec.Keys, field)
+
+	fr.EntityType = EntityType(relPath)
+	fr.Records = []Record{rec}
+	return fr, nil
+}
+
+// splitFrontMatter separates the YAML front matter of a Markdown file from
+// its body. The front matter starts with a "---" line at the top of the file
+// and ends at the next "---" or "..." line.
+func splitFrontMatter(data []byte) (frontMatter, body []byte, err error) {
+	line, rest, _ := bytes.Cut(data, []byte("\n"))
+	if string(bytes.TrimRight(line, " \t\r")) != "---" {
+		return nil, data, nil
+	}
+	for off := 0; off < len(rest); {
+		line, next, found := bytes.Cut(rest[off:], []byte("\n"))
+		switch string(bytes.TrimRight(line, " \t\r")) {
+		case "---", "...":
+			return rest[:off], next, nil
+		}
+		if !found {
+			break
+		}
+		off += len(line) + 1
+	}
+	return nil, nil, fmt.Errorf("front matter is not closed with a --- line")
+}
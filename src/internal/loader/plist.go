@@ -1,6 +1,9 @@
 package loader
 
 import (
+	"bytes"
+	"encoding/xml"
+
 	"howett.net/plist"
 )
 
@@ -9,6 +12,16 @@ type PlistLoader struct{}
 
 func (PlistLoader) Extensions() []string { return []string{".plist"} }
 
+func (PlistLoader) Name() string { return "plist" }
+
+func (PlistLoader) Options() []string {
+	return []string{
+		"XML, binary and OpenStep property lists",
+		"duplicate top-level keys are reported for XML property lists",
+		"array of records: a top-level list of objects holds a record per element",
+	}
+}
+
 func (PlistLoader) Load(absPath, relPath string) (*FileRecord, error) {
 	data, fr, err := readFile(absPath, relPath)
 	if err != nil {
@@ -20,12 +33,55 @@ func (PlistLoader) Load(absPath, relPath string) (*FileRecord, error) {
 		return nil, err
 	}
 
+	fr.EntityType = EntityType(relPath)
+	if objs, ok := objectList(raw); ok {
+		setObjectRecords(fr, objs)
+		return fr, nil
+	}
+
 	m, ok := raw.(map[string]any)
 	if !ok {
 		m = map[string]any{"value": raw}
 	}
 
-	fr.EntityType = EntityType(relPath)
-	fr.Records = []Record{buildRecord(EntityKey(relPath), m)}
+	rec := buildRecord(EntityKey(relPath), m)
+	if ok {
+		rec.Keys = plistKeys(data)
+		rec.DuplicateKeys = repeatedKeys(rec.Keys)
+	}
+
+	fr.Records = []Record{rec}
 	return fr, nil
 }
+
+// plistKeys returns the keys of the top-level dict of an XML property list in
+// document order, repeats included. Binary and OpenStep plists return nil.
+func plistKeys(data []byte) []string {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return nil
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var keys []string
+	depth := 0 // 1 = <plist>, 2 = top-level <dict>, 3 = its children
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 3 && t.Name.Local == "key" {
+				var key string
+				if err := dec.DecodeElement(&key, &t); err != nil {
+					return nil
+				}
+				keys = append(keys, key)
+				depth--
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return keys
+}
@@ -0,0 +1,46 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the text forms ParseTime accepts, tried in order.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime interprets a field value as a point in time: a time.Time as
+// decoded by the YAML, TOML and plist loaders, a number of seconds since the
+// Unix epoch, or an RFC 3339 timestamp or date. Text without a zone is read
+// in loc.
+func ParseTime(v any, loc *time.Location) (time.Time, error) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, nil
+	case int:
+		return time.Unix(int64(val), 0), nil
+	case int64:
+		return time.Unix(val, 0), nil
+	case float64:
+		sec := int64(val)
+		return time.Unix(sec, int64((val-float64(sec))*1e9)), nil
+	case string:
+		s := strings.TrimSpace(val)
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		for _, layout := range timeLayouts[1:] {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("value %v is not a time", v)
+}
@@ -9,6 +9,10 @@ type TOMLLoader struct{}
 
 func (TOMLLoader) Extensions() []string { return []string{".toml"} }
 
+func (TOMLLoader) Name() string { return "toml" }
+
+func (TOMLLoader) Options() []string { return nil }
+
 func (TOMLLoader) Load(absPath, relPath string) (*FileRecord, error) {
 	data, fr, err := readFile(absPath, relPath)
 	if err != nil {
@@ -16,13 +20,21 @@ func (TOMLLoader) Load(absPath, relPath string) (*FileRecord, error) {
 	}
 
 	var raw map[string]any
-	if err := toml.Unmarshal(data, &raw); err != nil {
+	md, err := toml.Decode(string(data), &raw)
+	if err != nil {
 		return nil, err
 	}
 
 	// TOML Unmarshal returns int64/float64 etc — convert to consistent types.
+	rec := buildRecord(EntityKey(relPath), normaliseMap(raw))
+	for _, k := range md.Keys() {
+		if len(k) == 1 {
+			rec.Keys = append(rec.Keys, k[0])
+		}
+	}
+
 	fr.EntityType = EntityType(relPath)
-	fr.Records = []Record{buildRecord(EntityKey(relPath), normaliseMap(raw))}
+	fr.Records = []Record{rec}
 	return fr, nil
 }
 
@@ -45,6 +57,13 @@ func normaliseValue(v any) any {
 			out[i] = normaliseValue(elem)
 		}
 		return out
+	case []map[string]any:
+		// Arrays of tables ([[orders]]) decode as []map[string]any.
+		out := make([]any, len(val))
+		for i, elem := range val {
+			out[i] = normaliseMap(elem)
+		}
+		return out
 	default:
 		return v
 	}
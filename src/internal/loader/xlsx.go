@@ -0,0 +1,458 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XLSXLoader loads Excel workbooks (.xlsx). The workbook is one record and
+// each worksheet an array field named after the sheet, holding a row object
+// per spreadsheet row keyed by the sheet's first-row headers. The builder
+// expands every sheet into a child table of its own, <table>_<sheet>, which
+// a children entry in sqlfs.yaml can rename.
+type XLSXLoader struct{}
+
+func (XLSXLoader) Extensions() []string { return []string{".xlsx"} }
+
+func (XLSXLoader) Name() string { return "xlsx" }
+
+func (XLSXLoader) Options() []string {
+	return []string{
+		"each worksheet becomes a child table, one row per spreadsheet row",
+		"the first row of a sheet holds its column headers; columns without one are skipped",
+		"formulas load their last calculated value",
+		"cells formatted as dates load as ISO 8601 text",
+	}
+}
+
+// maxXLSXPart bounds the uncompressed size of a part of a workbook, so that
+// a small corrupt or hostile file cannot make the loader read without end.
+const maxXLSXPart = 256 << 20
+
+func (XLSXLoader) Load(absPath, relPath string) (*FileRecord, error) {
+	data, fr, err := readFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+	fr.EntityType = EntityType(relPath)
+
+	wb, err := openWorkbook(data)
+	if err != nil {
+		return nil, err
+	}
+	rec := Record{Key: EntityKey(relPath), Fields: map[string]any{}, Lines: map[string]int{"": 1}}
+	for _, s := range wb.sheets {
+		if _, dup := rec.Fields[s.name]; dup {
+			rec.DuplicateKeys = append(rec.DuplicateKeys, s.name)
+		}
+		rows, lines, err := wb.readSheet(s.target)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", s.name, err)
+		}
+		rec.Keys = append(rec.Keys, s.name)
+		rec.Fields[s.name] = rows
+		for i, line := range lines {
+			// A sheet's rows have no lines of their own; their row numbers
+			// stand in for them.
+			rec.Lines[LinePath("", s.name, i)] = line
+		}
+	}
+	fr.Records = []Record{rec}
+	return fr, nil
+}
+
+// workbook is an opened .xlsx file: the parts the loader reads and what it
+// has decoded of them.
+type workbook struct {
+	files    map[string]*zip.File
+	sheets   []xlsxSheet
+	strings  []string
+	dates    []bool // by cell style index: whether the style formats a date
+	date1904 bool
+}
+
+type xlsxSheet struct {
+	name   string
+	target string // the worksheet part, e.g. "xl/worksheets/sheet1.xml"
+}
+
+func openWorkbook(data []byte) (*workbook, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("not an Excel workbook")
+	}
+	wb := &workbook{files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		wb.files[f.Name] = f
+	}
+	if _, ok := wb.files["xl/workbook.xml"]; !ok {
+		return nil, errors.New("not an Excel workbook: xl/workbook.xml is missing")
+	}
+
+	var book struct {
+		Pr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := wb.decode("xl/workbook.xml", &book); err != nil {
+		return nil, err
+	}
+	wb.date1904 = book.Pr.Date1904 == "1" || book.Pr.Date1904 == "true"
+
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := wb.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Rels))
+	for _, r := range rels.Rels {
+		// Chart sheets and macro sheets hold no cells to load.
+		if !strings.HasSuffix(r.Type, "/worksheet") {
+			continue
+		}
+		if strings.HasPrefix(r.Target, "/") {
+			targets[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			targets[r.ID] = path.Join("xl", r.Target)
+		}
+	}
+	for _, s := range book.Sheets {
+		if t, ok := targets[s.RID]; ok {
+			wb.sheets = append(wb.sheets, xlsxSheet{name: s.Name, target: t})
+		}
+	}
+
+	if err := wb.readSharedStrings(); err != nil {
+		return nil, err
+	}
+	if err := wb.readStyles(); err != nil {
+		return nil, err
+	}
+	return wb, nil
+}
+
+// open returns the uncompressed contents of the part name, limited to
+// maxXLSXPart bytes, or nil when the workbook has no such part.
+func (wb *workbook) open(name string) ([]byte, error) {
+	f, ok := wb.files[name]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxXLSXPart+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(data) > maxXLSXPart {
+		return nil, fmt.Errorf("%s is larger than %d MB", name, maxXLSXPart>>20)
+	}
+	return data, nil
+}
+
+// decode unmarshals the part name into v, leaving v alone when the workbook
+// has no such part.
+func (wb *workbook) decode(name string, v any) error {
+	data, err := wb.open(name)
+	if err != nil || data == nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// xlsxText is a string in the shared string table or an inline string:
+// plain text, or runs of rich text whose texts are joined.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+func (wb *workbook) readSharedStrings() error {
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if err := wb.decode("xl/sharedStrings.xml", &sst); err != nil {
+		return err
+	}
+	wb.strings = make([]string, len(sst.Items))
+	for i, si := range sst.Items {
+		wb.strings[i] = si.String()
+	}
+	return nil
+}
+
+// readStyles records which cell styles format numbers as dates, since a
+// workbook stores dates as day counts told apart only by their format.
+func (wb *workbook) readStyles() error {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Xfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := wb.decode("xl/styles.xml", &styles); err != nil {
+		return err
+	}
+	custom := make(map[int]bool, len(styles.NumFmts))
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = isDateFormat(f.Code)
+	}
+	wb.dates = make([]bool, len(styles.Xfs))
+	for i, xf := range styles.Xfs {
+		if d, ok := custom[xf.NumFmtID]; ok {
+			wb.dates[i] = d
+		} else {
+			wb.dates[i] = builtinDateFormat(xf.NumFmtID)
+		}
+	}
+	return nil
+}
+
+// builtinDateFormat reports whether the built-in number format id shows a
+// date or time.
+func builtinDateFormat(id int) bool {
+	return id >= 14 && id <= 22 || id >= 45 && id <= 47
+}
+
+// isDateFormat reports whether the number format code shows a date or
+// time: whether it has a date or time part outside quoted text, escapes and
+// bracketed colours and conditions.
+func isDateFormat(code string) bool {
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"':
+			for i++; i < len(code) && code[i] != '"'; i++ {
+			}
+		case '\\', '_', '*':
+			i++
+		case '[':
+			end := strings.IndexByte(code[i:], ']')
+			if end < 0 {
+				return false
+			}
+			// Elapsed time, such as [h]:mm, is a time.
+			if inner := strings.ToLower(code[i+1 : i+end]); strings.Trim(inner, "hms") == "" && inner != "" {
+				return true
+			}
+			i += end
+		case ';':
+			// Only the format of positive numbers is looked at.
+			return false
+		default:
+			switch c | 0x20 {
+			case 'd', 'm', 'y', 'h', 's':
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readSheet returns the rows of the worksheet part name below its header
+// row, with the spreadsheet row number of each. Rows without a value under
+// any header are skipped.
+func (wb *workbook) readSheet(name string) ([]any, []int, error) {
+	var ws struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				Ref   string   `xml:"r,attr"`
+				Type  string   `xml:"t,attr"`
+				Style int      `xml:"s,attr"`
+				V     *string  `xml:"v"`
+				IS    xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	data, err := wb.open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("%s is missing", name)
+	}
+	if err := xml.Unmarshal(data, &ws); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	rows := []any{}
+	var lines []int
+	var headers map[int]string
+	rowNum := 0
+	for _, row := range ws.Rows {
+		if row.R > 0 {
+			rowNum = row.R
+		} else {
+			rowNum++
+		}
+		values := make(map[int]any, len(row.Cells))
+		col := -1
+		for _, c := range row.Cells {
+			if i, ok := columnIndex(c.Ref); ok {
+				col = i
+			} else {
+				col++
+			}
+			v, err := wb.cellValue(c.Type, c.Style, c.V, c.IS)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cell %s%d: %w", columnName(col), rowNum, err)
+			}
+			if v != nil {
+				values[col] = v
+			}
+		}
+
+		if headers == nil {
+			headers = make(map[int]string, len(values))
+			for i, v := range values {
+				if h := strings.TrimSpace(fmt.Sprint(v)); h != "" {
+					headers[i] = h
+				}
+			}
+			continue
+		}
+		fields := make(map[string]any, len(values))
+		for i, v := range values {
+			if h, ok := headers[i]; ok {
+				fields[h] = v
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		rows = append(rows, fields)
+		lines = append(lines, rowNum)
+	}
+	return rows, lines, nil
+}
+
+// cellValue returns the value of a cell of type typ and style, whose value
+// element holds v and inline string is: nil for an empty cell, and
+// otherwise a string, bool, int64 or float64.
+func (wb *workbook) cellValue(typ string, style int, v *string, is xlsxText) (any, error) {
+	switch typ {
+	case "inlineStr":
+		return is.String(), nil
+	case "s":
+		if v == nil {
+			return nil, nil
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(*v))
+		if err != nil || i < 0 || i >= len(wb.strings) {
+			return nil, fmt.Errorf("shared string %q out of range", *v)
+		}
+		return wb.strings[i], nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	text := strings.TrimSpace(*v)
+	switch typ {
+	case "str", "e", "d":
+		// A formula's text, an error such as #DIV/0!, or an ISO 8601 date.
+		return text, nil
+	case "b":
+		return text == "1" || text == "true", nil
+	}
+	if text == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	if style >= 0 && style < len(wb.dates) && wb.dates[style] {
+		return wb.dateText(f), nil
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f), nil
+	}
+	return f, nil
+}
+
+// dateText formats a serial date, a count of days since the workbook's
+// epoch, as "2006-01-02" when it is a whole day, "15:04:05" when it is a
+// time of day alone, and with both otherwise.
+func (wb *workbook) dateText(serial float64) string {
+	// Day 60 of the 1900 system is 29 February 1900, which did not exist;
+	// counting from 30 December 1899 is right for the days after it.
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if wb.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if serial < 60 {
+		epoch = epoch.AddDate(0, 0, 1)
+	}
+	t := epoch.Add(time.Duration(math.Round(serial*86400)) * time.Second)
+	switch {
+	case serial >= 0 && serial < 1:
+		return t.Format(time.TimeOnly)
+	case serial == math.Trunc(serial):
+		return t.Format(time.DateOnly)
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// columnIndex returns the 0-based column of a cell reference such as "AB12".
+func columnIndex(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for ; n < len(ref) && ref[n] >= 'A' && ref[n] <= 'Z'; n++ {
+		col = col*26 + int(ref[n]-'A') + 1
+		if col > 1<<14 {
+			return 0, false
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return col - 1, true
+}
+
+// columnName returns the letters of the 0-based column i, e.g. "AB" for 27.
+func columnName(i int) string {
+	var b []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		b = append([]byte{byte('A' + (i-1)%26)}, b...)
+	}
+	return string(b)
+}
@@ -13,6 +13,16 @@ type XMLLoader struct{}
 
 func (XMLLoader) Extensions() []string { return []string{".xml"} }
 
+func (XMLLoader) Name() string { return "xml" }
+
+func (XMLLoader) Options() []string {
+	return []string{
+		"children of the root element become fields",
+		"attributes become fields",
+		"repeated child elements become arrays",
+	}
+}
+
 func (XMLLoader) Load(absPath, relPath string) (*FileRecord, error) {
 	data, fr, err := readFile(absPath, relPath)
 	if err != nil {
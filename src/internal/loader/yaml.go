@@ -1,6 +1,9 @@
 package loader
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -12,37 +15,161 @@ type YAMLLoader struct{}
 
 func (YAMLLoader) Extensions() []string { return []string{".yaml", ".yml"} }
 
+func (YAMLLoader) Name() string { return "yaml" }
+
+func (YAMLLoader) Options() []string {
+	return []string{
+		`entity references: a string such as "&users/alice" refers to another entity`,
+		"duplicate top-level keys are reported",
+		`multiple documents: each "---"-separated document is a record`,
+		"array of records: a top-level list of mappings holds a record per element",
+	}
+}
+
 func (YAMLLoader) Load(absPath, relPath string) (*FileRecord, error) {
 	data, fr, err := readFile(absPath, relPath)
 	if err != nil {
 		return nil, err
 	}
+	fr.EntityType = EntityType(relPath)
 
-	var doc yaml.Node
-	if err := yaml.Unmarshal(data, &doc); err != nil {
+	docs, err := yamlDocuments(data)
+	if err != nil {
 		return nil, err
 	}
+	if len(docs) == 0 {
+		fr.Records = []Record{yamlNodeRecord(nil, EntityKey(relPath))}
+		return fr, nil
+	}
+
+	// Each document is a record, or a list of records in the array-of-records
+	// layout. Keys of multi-record files are set by the Registry.
+	for _, doc := range docs {
+		root := doc.Content[0]
+		if elems, ok := yamlMappingList(root); ok {
+			fr.MultiRecord = true
+			for _, elem := range elems {
+				fr.Records = append(fr.Records, yamlNodeRecord(elem, ""))
+			}
+			continue
+		}
+		fr.Records = append(fr.Records, yamlNodeRecord(root, EntityKey(relPath)))
+	}
+	if len(docs) > 1 {
+		fr.MultiRecord = true
+	}
+	return fr, nil
+}
+
+// yamlDocuments returns the documents of a YAML stream, leaving out empty
+// ones such as the one a trailing "---" starts.
+func yamlDocuments(data []byte) ([]*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []*yaml.Node
+	for {
+		doc := new(yaml.Node)
+		if err := dec.Decode(doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, err
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// yamlMappingList returns the elements of n when n is a non-empty sequence
+// of mappings, the array-of-records layout, and false otherwise.
+func yamlMappingList(n *yaml.Node) ([]*yaml.Node, bool) {
+	if n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return nil, false
+	}
+	elems := make([]*yaml.Node, len(n.Content))
+	for i, elem := range n.Content {
+		if elem.Kind == yaml.AliasNode && elem.Alias != nil {
+			elem = elem.Alias
+		}
+		if elem.Kind != yaml.MappingNode {
+			return nil, false
+		}
+		elems[i] = elem
+	}
+	return elems, true
+}
 
+// yamlRecord parses a YAML document into the record with the given key. An
+// empty document or one that is not a mapping yields no fields.
+func yamlRecord(data []byte, key string) (Record, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Record{}, err
+	}
 	// Empty file or null document.
 	if doc.Kind == 0 || len(doc.Content) == 0 {
-		fr.EntityType = EntityType(relPath)
-		fr.Records = []Record{{Key: EntityKey(relPath), Fields: map[string]any{}}}
-		return fr, nil
+		return yamlNodeRecord(nil, key), nil
 	}
+	return yamlNodeRecord(doc.Content[0], key), nil
+}
 
-	root := doc.Content[0]
-	raw := nodeToValue(root)
+// yamlNodeRecord converts the root node of a record into the record with
+// the given key. A nil root or one that is not a mapping yields no fields.
+func yamlNodeRecord(root *yaml.Node, key string) Record {
+	if root == nil {
+		return Record{Key: key, Fields: map[string]any{}, Lines: map[string]int{"": 1}}
+	}
 
-	var fields map[string]any
-	if m, ok := raw.(map[string]any); ok {
-		fields = m
-	} else {
+	fields, ok := nodeToValue(root).(map[string]any)
+	if !ok {
 		fields = map[string]any{}
 	}
 
-	fr.EntityType = EntityType(relPath)
-	fr.Records = []Record{{Key: EntityKey(relPath), Fields: fields}}
-	return fr, nil
+	keys := yamlKeys(root)
+	lines := map[string]int{"": root.Line}
+	yamlLines(root, "", lines)
+	return Record{Key: key, Fields: fields, Keys: keys, DuplicateKeys: repeatedKeys(keys), Lines: lines}
+}
+
+// yamlLines records in lines where the elements of each array field of the
+// mapping node n start, and recurses into elements that are mappings. Keys
+// are Record.Lines paths below at.
+func yamlLines(n *yaml.Node, at string, lines map[string]int) {
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		seq := n.Content[i+1]
+		if seq.Kind == yaml.AliasNode && seq.Alias != nil {
+			seq = seq.Alias
+		}
+		if seq.Kind != yaml.SequenceNode {
+			continue
+		}
+		for j, elem := range seq.Content {
+			p := LinePath(at, n.Content[i].Value, j)
+			lines[p] = elem.Line
+			yamlLines(elem, p, lines)
+		}
+	}
+}
+
+// yamlKeys returns the keys of a top-level mapping node in document order,
+// repeats included. yaml.v3 only rejects duplicates when decoding into Go
+// maps, so they have to be found on the node tree.
+func yamlKeys(root *yaml.Node) []string {
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	keys := make([]string, 0, len(root.Content)/2)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keys = append(keys, root.Content[i].Value)
+	}
+	return keys
 }
 
 // nodeToValue converts a yaml.Node to a Go value.
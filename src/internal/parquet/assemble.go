@@ -0,0 +1,242 @@
+package parquet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Rows are rebuilt from their leaf columns the Dremel way: each leaf's
+// levels say how far down its path a value is defined (definition level)
+// and at which repeated ancestor a new element starts (repetition level).
+// Every leaf is assembled into a tree of its own for each row, the trees of
+// a row are merged, and LIST and MAP groups are then unwrapped.
+
+// repeatedValue collects the elements of a repeated node during assembly.
+type repeatedValue struct {
+	elems []any
+}
+
+func assemble(root *node, leaves []*node, columns []*columnData, numRows int) ([]map[string]any, error) {
+	var rows []map[string]any
+	for li, leaf := range leaves {
+		col := columns[li]
+		if len(col.values) > col.count {
+			return nil, fmt.Errorf("column %s has more values than levels", leaf.path())
+		}
+		path := leaf.ancestry()
+		row, vi := -1, 0
+		var tree map[string]any
+		for i := range col.count {
+			rep, def := 0, leaf.maxDef
+			if col.reps != nil {
+				rep = col.reps[i]
+			}
+			if col.defs != nil {
+				def = col.defs[i]
+			}
+			if rep == 0 {
+				if tree != nil {
+					merge(rows[row], tree)
+				}
+				row++
+				if li == 0 {
+					rows = append(rows, map[string]any{})
+				} else if row >= len(rows) {
+					return nil, fmt.Errorf("column %s has more rows than column %s", leaf.path(), leaves[0].path())
+				}
+				tree = map[string]any{}
+			} else if tree == nil {
+				return nil, fmt.Errorf("column %s does not start with a new row", leaf.path())
+			}
+			var v any
+			if def == leaf.maxDef {
+				if vi >= len(col.values) {
+					return nil, fmt.Errorf("column %s has fewer values than levels", leaf.path())
+				}
+				v = col.values[vi]
+				vi++
+			}
+			place(tree, path, rep, def, v)
+		}
+		if tree != nil {
+			merge(rows[row], tree)
+		}
+		if row+1 != len(rows) {
+			return nil, fmt.Errorf("column %s has %d rows, want %d", leaf.path(), row+1, len(rows))
+		}
+	}
+	if len(leaves) > 0 && len(rows) != numRows {
+		return nil, fmt.Errorf("file has %d rows, but its footer says %d", len(rows), numRows)
+	}
+	if len(leaves) == 0 && numRows > 0 {
+		return nil, errors.New("file has rows but no columns")
+	}
+
+	for i, row := range rows {
+		rows[i] = finishGroup(root, row)
+	}
+	return rows, nil
+}
+
+// ancestry returns the nodes from the top-level column down to n.
+func (n *node) ancestry() []*node {
+	var path []*node
+	for ; n.parent != nil; n = n.parent {
+		path = append([]*node{n}, path...)
+	}
+	return path
+}
+
+// place puts the value of one level pair of a leaf into tree, the leaf's
+// tree for the current row. path runs from the top-level column to the leaf.
+func place(tree map[string]any, path []*node, rep, def int, v any) {
+	m := tree
+	for _, n := range path {
+		leaf := n.typ >= 0
+		if n.repetition == repeated {
+			rv, _ := m[n.name].(*repeatedValue)
+			if rv == nil {
+				rv = &repeatedValue{}
+				m[n.name] = rv
+			}
+			if def < n.maxDef {
+				return // an empty list
+			}
+			// A new element starts at the repeated node the repetition
+			// level names and at every repeated node below it.
+			if rep <= n.maxRep || len(rv.elems) == 0 {
+				if leaf {
+					rv.elems = append(rv.elems, v)
+					return
+				}
+				rv.elems = append(rv.elems, map[string]any{})
+			}
+			if leaf {
+				return
+			}
+			m = rv.elems[len(rv.elems)-1].(map[string]any)
+			continue
+		}
+		if def < n.maxDef {
+			if _, ok := m[n.name]; !ok {
+				m[n.name] = nil
+			}
+			return
+		}
+		if leaf {
+			m[n.name] = v
+			return
+		}
+		child, _ := m[n.name].(map[string]any)
+		if child == nil {
+			child = map[string]any{}
+			m[n.name] = child
+		}
+		m = child
+	}
+}
+
+// merge adds the fields of src, a leaf's tree for a row, to dst. The trees
+// of a row's leaves have the same elements in their shared repeated nodes.
+func merge(dst, src map[string]any) {
+	for k, sv := range src {
+		dv, ok := dst[k]
+		if !ok || dv == nil {
+			dst[k] = sv
+			continue
+		}
+		switch d := dv.(type) {
+		case map[string]any:
+			if s, ok := sv.(map[string]any); ok {
+				merge(d, s)
+			}
+		case *repeatedValue:
+			s, ok := sv.(*repeatedValue)
+			if !ok {
+				continue
+			}
+			for i := range min(len(d.elems), len(s.elems)) {
+				dm, dok := d.elems[i].(map[string]any)
+				sm, sok := s.elems[i].(map[string]any)
+				if dok && sok {
+					merge(dm, sm)
+				}
+			}
+		}
+	}
+}
+
+// finishGroup returns the assembled value of group n: its fields with
+// repeated nodes turned into slices and LIST and MAP groups unwrapped.
+func finishGroup(n *node, m map[string]any) map[string]any {
+	for _, c := range n.children {
+		v, ok := m[c.name]
+		if !ok {
+			continue
+		}
+		m[c.name] = finish(c, v)
+	}
+	return m
+}
+
+func finish(n *node, v any) any {
+	switch val := v.(type) {
+	case *repeatedValue:
+		out := make([]any, len(val.elems))
+		for i, e := range val.elems {
+			if em, ok := e.(map[string]any); ok && n.typ < 0 {
+				out[i] = finishGroup(n, em)
+			} else {
+				out[i] = e
+			}
+		}
+		return out
+	case map[string]any:
+		g := finishGroup(n, val)
+		switch {
+		case n.list && len(n.children) == 1 && n.children[0].repetition == repeated:
+			return listElements(n.children[0], g[n.children[0].name])
+		case n.isMap && len(n.children) == 1 && n.children[0].repetition == repeated:
+			return mapEntries(g[n.children[0].name])
+		}
+		return g
+	}
+	return v
+}
+
+// listElements unwraps the repeated node of a LIST group. In the standard
+// three-level layout each element is a group holding the element alone.
+func listElements(rep *node, v any) any {
+	elems, ok := v.([]any)
+	if !ok {
+		return v
+	}
+	if rep.typ >= 0 || len(rep.children) != 1 {
+		return elems
+	}
+	name := rep.children[0].name
+	for i, e := range elems {
+		if em, ok := e.(map[string]any); ok {
+			elems[i] = em[name]
+		}
+	}
+	return elems
+}
+
+// mapEntries turns the key/value groups of a MAP into a map keyed by the
+// text of each key.
+func mapEntries(v any) any {
+	entries, ok := v.([]any)
+	if !ok {
+		return v
+	}
+	out := make(map[string]any, len(entries))
+	for _, e := range entries {
+		em, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		out[fmt.Sprint(em["key"])] = em["value"]
+	}
+	return out
+}
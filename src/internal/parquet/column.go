@@ -0,0 +1,274 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Page types.
+const (
+	pageData       = 0
+	pageIndex      = 1
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// maxPageValues bounds the values of a page, so that a corrupt header
+// cannot make the reader allocate without end. Writers start a new page
+// every megabyte or so, far below it.
+const maxPageValues = 1 << 24
+
+// columnData holds the decoded levels and values of a leaf column across
+// row groups. values holds a converted value for each of the count level
+// pairs whose definition level is the leaf's maxDef. reps and defs are nil
+// when the leaf's maximum level is zero.
+type columnData struct {
+	count      int
+	reps, defs []int
+	values     []any
+}
+
+// readChunk decodes the pages of a column chunk into col.
+func (f *File) readChunk(md tstruct, leaf *node, col *columnData) error {
+	if md == nil {
+		return errors.New("column chunk has no metadata")
+	}
+	if int(md.int(1)) != leaf.typ {
+		return fmt.Errorf("column chunk has type %d, want %d", md.int(1), leaf.typ)
+	}
+	start := md.int(9)
+	if dict := md.int(11); dict > 0 && dict < start {
+		start = dict
+	}
+	end := start + md.int(7)
+	if start < 4 || end > int64(len(f.data)) || end < start {
+		return errors.New("column chunk is outside the file")
+	}
+	data := f.data[start:end]
+	codec := md.int(4)
+	remaining := md.int(5)
+
+	var dict []any
+	pos := 0
+	for remaining > 0 {
+		if pos >= len(data) {
+			return errTruncated
+		}
+		r := &thriftReader{data: data[pos:]}
+		header, err := r.readStruct()
+		if err != nil {
+			return fmt.Errorf("reading page header: %w", err)
+		}
+		pos += r.pos
+		size, usize := int(header.int(3)), int(header.int(2))
+		if size < 0 || size > len(data)-pos || usize < 0 {
+			return errTruncated
+		}
+		page := data[pos : pos+size]
+		pos += size
+
+		switch header.int(1) {
+		case pageDictionary:
+			body, err := decompress(codec, page, usize)
+			if err != nil {
+				return err
+			}
+			dh := header.strct(7)
+			if dict, err = readPlain(body, leaf.typ, leaf.typeLength, int(dh.int(1))); err != nil {
+				return fmt.Errorf("reading dictionary: %w", err)
+			}
+		case pageData:
+			body, err := decompress(codec, page, usize)
+			if err != nil {
+				return err
+			}
+			n, err := readPageV1(body, header.strct(5), leaf, dict, col)
+			if err != nil {
+				return err
+			}
+			remaining -= int64(n)
+		case pageDataV2:
+			n, err := readPageV2(page, header.strct(8), codec, usize, leaf, dict, col)
+			if err != nil {
+				return err
+			}
+			remaining -= int64(n)
+		case pageIndex:
+		default:
+			return fmt.Errorf("unknown page type %d", header.int(1))
+		}
+	}
+	return nil
+}
+
+// readPageV1 decodes a DATA_PAGE, whose levels are stored in front of its
+// values with their length, and returns its number of level pairs.
+func readPageV1(body []byte, h tstruct, leaf *node, dict []any, col *columnData) (int, error) {
+	if h == nil {
+		return 0, errors.New("data page has no header")
+	}
+	count := int(h.int(1))
+	if count < 0 || count > maxPageValues {
+		return 0, fmt.Errorf("data page has %d values", count)
+	}
+	levels := func(max int, enc int64) ([]int, error) {
+		if max == 0 {
+			return nil, nil
+		}
+		if enc != encRLE {
+			return nil, fmt.Errorf("level encoding %d is not supported", enc)
+		}
+		if len(body) < 4 {
+			return nil, errTruncated
+		}
+		n := int(binary.LittleEndian.Uint32(body))
+		if n < 0 || n > len(body)-4 {
+			return nil, errTruncated
+		}
+		out, err := readHybrid(body[4:4+n], bits.Len(uint(max)), count)
+		body = body[4+n:]
+		return out, err
+	}
+	reps, err := levels(leaf.maxRep, h.int(4))
+	if err != nil {
+		return 0, fmt.Errorf("reading repetition levels: %w", err)
+	}
+	defs, err := levels(leaf.maxDef, h.int(3))
+	if err != nil {
+		return 0, fmt.Errorf("reading definition levels: %w", err)
+	}
+	return count, addValues(body, h.int(2), count, reps, defs, leaf, dict, col)
+}
+
+// readPageV2 decodes a DATA_PAGE_V2, whose levels are stored uncompressed
+// in front of its values, and returns its number of level pairs.
+func readPageV2(page []byte, h tstruct, codec int64, usize int, leaf *node, dict []any, col *columnData) (int, error) {
+	if h == nil {
+		return 0, errors.New("data page has no header")
+	}
+	count := int(h.int(1))
+	if count < 0 || count > maxPageValues {
+		return 0, fmt.Errorf("data page has %d values", count)
+	}
+	repLen, defLen := int(h.int(6)), int(h.int(5))
+	if repLen < 0 || defLen < 0 || repLen+defLen > len(page) || repLen+defLen > usize {
+		return 0, errTruncated
+	}
+	var reps, defs []int
+	var err error
+	if leaf.maxRep > 0 {
+		if reps, err = readHybrid(page[:repLen], bits.Len(uint(leaf.maxRep)), count); err != nil {
+			return 0, fmt.Errorf("reading repetition levels: %w", err)
+		}
+	}
+	if leaf.maxDef > 0 {
+		if defs, err = readHybrid(page[repLen:repLen+defLen], bits.Len(uint(leaf.maxDef)), count); err != nil {
+			return 0, fmt.Errorf("reading definition levels: %w", err)
+		}
+	}
+	body := page[repLen+defLen:]
+	if !h.has(7) || h.bool(7) {
+		if body, err = decompress(codec, body, usize-repLen-defLen); err != nil {
+			return 0, err
+		}
+	}
+	return count, addValues(body, h.int(4), count, reps, defs, leaf, dict, col)
+}
+
+// addValues decodes the values of a data page and appends them, with the
+// page's levels, to col. Only level pairs at the leaf's maxDef have a value.
+func addValues(body []byte, enc int64, count int, reps, defs []int, leaf *node, dict []any, col *columnData) error {
+	n := count
+	if defs != nil {
+		n = 0
+		for _, d := range defs {
+			if d == leaf.maxDef {
+				n++
+			}
+		}
+	}
+
+	var values []any
+	var err error
+	switch enc {
+	case encPlain:
+		values, err = readPlain(body, leaf.typ, leaf.typeLength, n)
+	case encPlainDictionary, encRLEDictionary:
+		if n == 0 {
+			break
+		}
+		if len(body) == 0 {
+			return errTruncated
+		}
+		var idx []int
+		if idx, err = readHybrid(body[1:], int(body[0]), n); err != nil {
+			break
+		}
+		values = make([]any, n)
+		for i, k := range idx {
+			if k < 0 || k >= len(dict) {
+				return fmt.Errorf("dictionary index %d out of range", k)
+			}
+			values[i] = dict[k]
+		}
+	case encRLE:
+		if leaf.typ != typeBoolean {
+			return fmt.Errorf("RLE encoding of type %d is not supported", leaf.typ)
+		}
+		if len(body) < 4 {
+			return errTruncated
+		}
+		var bs []int
+		if bs, err = readHybrid(body[4:], 1, n); err != nil {
+			break
+		}
+		values = make([]any, n)
+		for i, b := range bs {
+			values[i] = b == 1
+		}
+	case encDeltaBinaryPacked:
+		var ints []int64
+		if ints, _, err = readDeltaBinaryPacked(body); err != nil {
+			break
+		}
+		values = make([]any, len(ints))
+		for i, v := range ints {
+			if leaf.typ == typeInt32 {
+				values[i] = int32(v)
+			} else {
+				values[i] = v
+			}
+		}
+	case encDeltaLengthByteArray, encDeltaByteArray:
+		var arrays [][]byte
+		if enc == encDeltaLengthByteArray {
+			arrays, err = readDeltaLengthByteArray(body)
+		} else {
+			arrays, err = readDeltaByteArray(body)
+		}
+		values = make([]any, len(arrays))
+		for i, b := range arrays {
+			values[i] = b
+		}
+	case encByteStreamSplit:
+		values, err = readByteStreamSplit(body, leaf.typ, leaf.typeLength, n)
+	default:
+		return fmt.Errorf("value encoding %d is not supported", enc)
+	}
+	if err != nil {
+		return fmt.Errorf("reading values: %w", err)
+	}
+	if len(values) < n {
+		return errTruncated
+	}
+
+	col.count += count
+	col.reps = append(col.reps, reps...)
+	col.defs = append(col.defs, defs...)
+	for _, v := range values[:n] {
+		col.values = append(col.values, leaf.convert(v))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compression codecs of ColumnMetaData.codec.
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+)
+
+var codecNames = map[int64]string{
+	3: "LZO", 4: "BROTLI", 5: "LZ4", 6: "ZSTD", 7: "LZ4_RAW",
+}
+
+// maxPrealloc bounds the memory reserved up front for a page, whose size
+// comes from a header that may be corrupt.
+const maxPrealloc = 1 << 20
+
+// decompress returns the uncompressed form of a page compressed with codec,
+// which is size bytes long.
+func decompress(codec int64, data []byte, size int) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		return snappyDecode(data, size)
+	case codecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, min(size, maxPrealloc)))
+		if _, err := io.Copy(buf, io.LimitReader(zr, int64(size)+1)); err != nil {
+			return nil, err
+		}
+		if buf.Len() != size {
+			return nil, fmt.Errorf("gzip page is %d bytes, want %d", buf.Len(), size)
+		}
+		return buf.Bytes(), nil
+	}
+	if name, ok := codecNames[codec]; ok {
+		return nil, fmt.Errorf("%s compression is not supported; write the file with SNAPPY, GZIP or no compression", name)
+	}
+	return nil, fmt.Errorf("unknown compression codec %d", codec)
+}
+
+var errSnappy = errors.New("corrupt snappy data")
+
+// snappyDecode decodes a block in Snappy's raw format, whose length is
+// size.
+func snappyDecode(src []byte, size int) ([]byte, error) {
+	n, i := binary.Uvarint(src)
+	if i <= 0 || n != uint64(size) {
+		return nil, errSnappy
+	}
+	dst := make([]byte, 0, min(size, maxPrealloc))
+	for i < len(src) {
+		tag := src[i]
+		i++
+		switch tag & 3 {
+		case 0: // literal
+			length := int(tag >> 2)
+			if length >= 60 {
+				extra := length - 59
+				if i+extra > len(src) {
+					return nil, errSnappy
+				}
+				length = 0
+				for k := extra - 1; k >= 0; k-- {
+					length = length<<8 | int(src[i+k])
+				}
+				i += extra
+			}
+			length++
+			if length <= 0 || i+length > len(src) || len(dst)+length > size {
+				return nil, errSnappy
+			}
+			dst = append(dst, src[i:i+length]...)
+			i += length
+			continue
+		}
+
+		var length, offset int
+		switch tag & 3 {
+		case 1:
+			if i >= len(src) {
+				return nil, errSnappy
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[i])
+			i++
+		case 2:
+			if i+2 > len(src) {
+				return nil, errSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[i:]))
+			i += 2
+		case 3:
+			if i+4 > len(src) {
+				return nil, errSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[i:]))
+			i += 4
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > size {
+			return nil, errSnappy
+		}
+		// Copies may overlap the bytes they produce.
+		for k := 0; k < length; k++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != size {
+		return nil, errSnappy
+	}
+	return dst, nil
+}
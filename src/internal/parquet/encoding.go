@@ -0,0 +1,342 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Encodings of page values and levels.
+const (
+	encPlain                = 0
+	encPlainDictionary      = 2
+	encRLE                  = 3
+	encBitPacked            = 4
+	encDeltaBinaryPacked    = 5
+	encDeltaLengthByteArray = 6
+	encDeltaByteArray       = 7
+	encRLEDictionary        = 8
+	encByteStreamSplit      = 9
+)
+
+// Physical types.
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeInt96     = 3
+	typeFloat     = 4
+	typeDouble    = 5
+	typeByteArray = 6
+	typeFixed     = 7
+)
+
+var errTruncated = errors.New("page data ends unexpectedly")
+
+// int96 is the 12-byte timestamp of older writers such as Impala and Spark:
+// nanoseconds of the day followed by the Julian day number.
+type int96 [12]byte
+
+// bitReader reads little-endian bit-packed values, least significant bit
+// first, as Parquet packs them.
+type bitReader struct {
+	data []byte
+	bit  int
+}
+
+func (b *bitReader) read(width int) (uint64, error) {
+	if width == 0 {
+		return 0, nil
+	}
+	if b.bit+width > len(b.data)*8 {
+		return 0, errTruncated
+	}
+	var v uint64
+	for i := 0; i < width; {
+		byteIdx, shift := b.bit/8, b.bit%8
+		take := min(8-shift, width-i)
+		bits := uint64(b.data[byteIdx]>>shift) & (1<<take - 1)
+		v |= bits << i
+		i += take
+		b.bit += take
+	}
+	return v, nil
+}
+
+// readHybrid decodes count values of the given bit width encoded with the
+// RLE/bit-packing hybrid used for levels, dictionary indices and booleans.
+func readHybrid(data []byte, width, count int) ([]int, error) {
+	if width > 32 {
+		return nil, fmt.Errorf("bit width %d is too large", width)
+	}
+	if count < 0 {
+		return nil, errTruncated
+	}
+	out := make([]int, 0, min(count, 1<<16))
+	pos := 0
+	for len(out) < count {
+		header, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		pos += n
+		if header&1 == 0 {
+			run := int(header >> 1)
+			size := (width + 7) / 8
+			if pos+size > len(data) {
+				return nil, errTruncated
+			}
+			var v int
+			for i := size - 1; i >= 0; i-- {
+				v = v<<8 | int(data[pos+i])
+			}
+			pos += size
+			for i := 0; i < run && len(out) < count; i++ {
+				out = append(out, v)
+			}
+			continue
+		}
+		values := int(header>>1) * 8
+		size := values * width / 8
+		if size > len(data)-pos {
+			return nil, errTruncated
+		}
+		br := bitReader{data: data[pos : pos+size]}
+		for i := 0; i < values && len(out) < count; i++ {
+			v, err := br.read(width)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, int(v))
+		}
+		pos += size
+	}
+	return out, nil
+}
+
+// readPlain decodes count PLAIN-encoded values of the physical type typ;
+// typeLength is the size of fixed-length byte arrays. Values are bool,
+// int32, int64, int96, float32, float64 or []byte.
+func readPlain(data []byte, typ, typeLength, count int) ([]any, error) {
+	if count < 0 {
+		return nil, errTruncated
+	}
+	out := make([]any, 0, min(count, 1<<16))
+	if typ == typeBoolean {
+		br := bitReader{data: data}
+		for range count {
+			v, err := br.read(1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v == 1)
+		}
+		return out, nil
+	}
+
+	pos := 0
+	for range count {
+		switch typ {
+		case typeInt32:
+			if pos+4 > len(data) {
+				return nil, errTruncated
+			}
+			out = append(out, int32(binary.LittleEndian.Uint32(data[pos:])))
+			pos += 4
+		case typeInt64:
+			if pos+8 > len(data) {
+				return nil, errTruncated
+			}
+			out = append(out, int64(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case typeInt96:
+			if pos+12 > len(data) {
+				return nil, errTruncated
+			}
+			out = append(out, int96(data[pos:pos+12]))
+			pos += 12
+		case typeFloat:
+			if pos+4 > len(data) {
+				return nil, errTruncated
+			}
+			out = append(out, math.Float32frombits(binary.LittleEndian.Uint32(data[pos:])))
+			pos += 4
+		case typeDouble:
+			if pos+8 > len(data) {
+				return nil, errTruncated
+			}
+			out = append(out, math.Float64frombits(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case typeByteArray:
+			if pos+4 > len(data) {
+				return nil, errTruncated
+			}
+			n := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if n < 0 || n > len(data)-pos {
+				return nil, errTruncated
+			}
+			out = append(out, data[pos:pos+n])
+			pos += n
+		case typeFixed:
+			if typeLength <= 0 || pos+typeLength > len(data) {
+				return nil, errTruncated
+			}
+			out = append(out, data[pos:pos+typeLength])
+			pos += typeLength
+		default:
+			return nil, fmt.Errorf("unknown physical type %d", typ)
+		}
+	}
+	return out, nil
+}
+
+// readDeltaBinaryPacked decodes DELTA_BINARY_PACKED integers, returning
+// them and the number of bytes they took.
+func readDeltaBinaryPacked(data []byte) ([]int64, int, error) {
+	pos := 0
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return 0, errTruncated
+		}
+		pos += n
+		return v, nil
+	}
+	varint := func() (int64, error) {
+		v, err := uvarint()
+		return int64(v>>1) ^ -int64(v&1), err
+	}
+
+	blockSize, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	miniblocks, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	first, err := varint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if blockSize == 0 || miniblocks == 0 || blockSize%miniblocks != 0 || blockSize > 1<<20 {
+		return nil, 0, errors.New("corrupt delta encoding header")
+	}
+	perMiniblock := int(blockSize / miniblocks)
+
+	// Runs of equal deltas take no bytes, so total is not bounded by the
+	// size of data; each block takes at least a byte, though.
+	out := make([]int64, 0, min(total, 1<<16))
+	if total > 0 {
+		out = append(out, first)
+	}
+	prev := first
+	for uint64(len(out)) < total {
+		minDelta, err := varint()
+		if err != nil {
+			return nil, 0, err
+		}
+		if pos+int(miniblocks) > len(data) {
+			return nil, 0, errTruncated
+		}
+		widths := data[pos : pos+int(miniblocks)]
+		pos += int(miniblocks)
+		for _, width := range widths {
+			if uint64(len(out)) >= total {
+				break
+			}
+			if width > 64 {
+				return nil, 0, fmt.Errorf("bit width %d is too large", width)
+			}
+			size := perMiniblock * int(width) / 8
+			if pos+size > len(data) {
+				return nil, 0, errTruncated
+			}
+			br := bitReader{data: data[pos : pos+size]}
+			for i := 0; i < perMiniblock && uint64(len(out)) < total; i++ {
+				d, err := br.read(int(width))
+				if err != nil {
+					return nil, 0, err
+				}
+				prev += minDelta + int64(d)
+				out = append(out, prev)
+			}
+			pos += size
+		}
+	}
+	return out, pos, nil
+}
+
+// readDeltaLengthByteArray decodes DELTA_LENGTH_BYTE_ARRAY byte arrays:
+// their delta-encoded lengths followed by their concatenated bytes.
+func readDeltaLengthByteArray(data []byte) ([][]byte, error) {
+	lengths, pos, err := readDeltaBinaryPacked(data)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(lengths))
+	for i, n := range lengths {
+		if n < 0 || n > int64(len(data)-pos) {
+			return nil, errTruncated
+		}
+		out[i] = data[pos : pos+int(n)]
+		pos += int(n)
+	}
+	return out, nil
+}
+
+// readDeltaByteArray decodes DELTA_BYTE_ARRAY byte arrays, each stored as
+// the length of the prefix it shares with the one before and the rest.
+func readDeltaByteArray(data []byte) ([][]byte, error) {
+	prefixes, pos, err := readDeltaBinaryPacked(data)
+	if err != nil {
+		return nil, err
+	}
+	suffixes, err := readDeltaLengthByteArray(data[pos:])
+	if err != nil {
+		return nil, err
+	}
+	if len(suffixes) != len(prefixes) {
+		return nil, errors.New("corrupt delta byte array")
+	}
+	out := make([][]byte, len(prefixes))
+	var prev []byte
+	for i, n := range prefixes {
+		if n < 0 || n > int64(len(prev)) {
+			return nil, errors.New("corrupt delta byte array")
+		}
+		v := make([]byte, 0, int(n)+len(suffixes[i]))
+		v = append(append(v, prev[:n]...), suffixes[i]...)
+		out[i], prev = v, v
+	}
+	return out, nil
+}
+
+// readByteStreamSplit decodes BYTE_STREAM_SPLIT values, whose k-th bytes
+// are stored together, by gathering each value's bytes and decoding them as
+// PLAIN.
+func readByteStreamSplit(data []byte, typ, typeLength, count int) ([]any, error) {
+	width := typeLength
+	switch typ {
+	case typeInt32, typeFloat:
+		width = 4
+	case typeInt64, typeDouble:
+		width = 8
+	}
+	if width <= 0 || count < 0 || len(data)/width < count {
+		return nil, errTruncated
+	}
+	plain := make([]byte, width*count)
+	for i := range count {
+		for k := range width {
+			plain[i*width+k] = data[k*count+i]
+		}
+	}
+	return readPlain(plain, typ, typeLength, count)
+}
@@ -0,0 +1,96 @@
+// Package parquet reads the rows of Apache Parquet files.
+//
+// It is a small reader written for sqlfs's loader rather than a general
+// Parquet library: it decodes whole files held in memory, supports the
+// UNCOMPRESSED, SNAPPY and GZIP codecs and the PLAIN, dictionary, RLE, delta
+// and byte-stream-split encodings, and reassembles nested columns into maps
+// and slices.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var magic = []byte("PAR1")
+
+// File is a decoded Parquet file.
+type File struct {
+	data    []byte
+	meta    tstruct
+	root    *node
+	leaves  []*node
+	numRows int64
+}
+
+// Open parses the footer of the Parquet file data.
+func Open(data []byte) (*File, error) {
+	if len(data) < 12 || !bytes.Equal(data[:4], magic) || !bytes.Equal(data[len(data)-4:], magic) {
+		return nil, errors.New("not a Parquet file")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if size <= 0 || size > len(data)-12 {
+		return nil, errors.New("corrupt Parquet footer")
+	}
+	footer := data[len(data)-8-size : len(data)-8]
+	r := &thriftReader{data: footer}
+	meta, err := r.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("reading Parquet footer: %w", err)
+	}
+
+	f := &File{data: data, meta: meta, numRows: meta.int(3)}
+	if f.root, err = buildSchema(meta.list(2)); err != nil {
+		return nil, err
+	}
+	f.leaves = f.root.leaves(nil)
+	return f, nil
+}
+
+// Fields returns the names of the file's top-level columns in schema order.
+func (f *File) Fields() []string {
+	names := make([]string, len(f.root.children))
+	for i, c := range f.root.children {
+		names[i] = c.name
+	}
+	return names
+}
+
+// NumRows returns the number of rows the footer records.
+func (f *File) NumRows() int64 { return f.numRows }
+
+// Rows decodes every row of the file into a map from top-level column name
+// to value. Values are nil, bool, int64, uint64 (for unsigned 64-bit
+// columns), float64 or string; groups are map[string]any, and repeated
+// fields and LIST columns []any. Dates, times and timestamps are written as
+// text: "2006-01-02", "15:04:05.999999999" and RFC 3339 in UTC. Decimals
+// are float64, and byte arrays without a string annotation are strings when
+// they hold UTF-8 and base64 otherwise.
+func (f *File) Rows() ([]map[string]any, error) {
+	if f.numRows < 0 || f.numRows > int64(len(f.data))*8 {
+		return nil, errors.New("corrupt row count")
+	}
+	columns := make([]*columnData, len(f.leaves))
+	for i := range f.leaves {
+		columns[i] = &columnData{}
+	}
+	for gi, group := range f.meta.list(4) {
+		rg, _ := group.(tstruct)
+		chunks := rg.list(1)
+		if len(chunks) != len(f.leaves) {
+			return nil, fmt.Errorf("row group %d has %d columns, want %d", gi, len(chunks), len(f.leaves))
+		}
+		for ci, chunk := range chunks {
+			cc, _ := chunk.(tstruct)
+			if cc.str(1) != "" {
+				return nil, fmt.Errorf("column %s is stored in another file, %s", f.leaves[ci].path(), cc.str(1))
+			}
+			if err := f.readChunk(cc.strct(3), f.leaves[ci], columns[ci]); err != nil {
+				return nil, fmt.Errorf("column %s: %w", f.leaves[ci].path(), err)
+			}
+		}
+	}
+	return assemble(f.root, f.leaves, columns, int(f.numRows))
+}
@@ -0,0 +1,163 @@
+package parquet
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/sample.parquet has two row groups holding three rows. Its
+// columns use SNAPPY and GZIP compression, a dictionary, a DATA_PAGE_V2 with
+// DELTA_BINARY_PACKED values, a three-level LIST and an optional group.
+func TestRows(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(data)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got, want := f.Fields(), []string{"id", "name", "score", "active", "day", "tags", "address", "ts"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields = %v, want %v", got, want)
+	}
+	if f.NumRows() != 3 {
+		t.Errorf("NumRows = %d, want 3", f.NumRows())
+	}
+
+	rows, err := f.Rows()
+	if err != nil {
+		t.Fatalf("Rows: %v", err)
+	}
+	want := []map[string]any{
+		{
+			"id": int64(1), "name": "alice", "score": 9.5, "active": true, "day": "2024-01-02",
+			"tags":    []any{"a", "b"},
+			"address": map[string]any{"city": "Oslo", "zip": int64(150)},
+			"ts":      "2023-11-14T22:13:20Z",
+		},
+		{
+			"id": int64(2), "name": nil, "score": nil, "active": false, "day": nil,
+			"tags": []any{}, "address": nil, "ts": "2023-11-14T22:13:21Z",
+		},
+		{
+			"id": int64(3), "name": "carol\ttab", "score": 7.25, "active": true, "day": "2024-01-03",
+			"tags":    nil,
+			"address": map[string]any{"city": nil, "zip": int64(9)},
+			"ts":      nil,
+		},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(rows[i], want[i]) {
+			t.Errorf("row %d = %#v\nwant %#v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestOpen_NotParquet(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("PAR1"), []byte("id,name\n1,alice\n")} {
+		if _, err := Open(data); err == nil {
+			t.Errorf("Open(%q): want an error", data)
+		}
+	}
+}
+
+func TestRows_Corrupt(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Damaging the pages must give an error rather than a panic.
+	for i := 4; i < 200; i++ {
+		bad := bytes.Clone(data)
+		bad[i] ^= 0xff
+		f, err := Open(bad)
+		if err != nil {
+			continue
+		}
+		f.Rows()
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	// "abcabcabcabc": a literal "abc" and an overlapping copy of 9 bytes at
+	// offset 3.
+	src := []byte{12, 2 << 2, 'a', 'b', 'c', 1 | (9-4)<<2, 3}
+	got, err := snappyDecode(src, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcabcabcabc" {
+		t.Errorf("snappyDecode = %q", got)
+	}
+	if _, err := snappyDecode(src, 13); err == nil {
+		t.Error("want an error for a wrong length")
+	}
+}
+
+func TestReadHybrid(t *testing.T) {
+	// A run of five 3s, then a bit-packed group of 0..7 at width 3.
+	data := []byte{5 << 1, 3, 1<<1 | 1, 0x88, 0xc6, 0xfa}
+	got, err := readHybrid(data, 3, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{3, 3, 3, 3, 3, 0, 1, 2, 3, 4, 5, 6, 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readHybrid = %v, want %v", got, want)
+	}
+}
+
+func TestReadDeltaByteArray(t *testing.T) {
+	// "apple", "apply", "ape": prefix lengths 0, 4, 2 and suffixes "apple",
+	// "y", "e", each list delta encoded with a block of 128 in 4 miniblocks.
+	prefixes := []byte{128, 1, 4, 3, 0, 3, 3, 0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	lengths := []byte{128, 1, 4, 3, 10, 7, 3, 0, 0, 0, 32, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(append(prefixes, lengths...), "appleye"...)
+	got, err := readDeltaByteArray(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var words []string
+	for _, b := range got {
+		words = append(words, string(b))
+	}
+	if strings.Join(words, ",") != "apple,apply,ape" {
+		t.Errorf("readDeltaByteArray = %q", words)
+	}
+}
+
+func TestDecompress_Unsupported(t *testing.T) {
+	_, err := decompress(6, nil, 0)
+	if err == nil || !strings.Contains(err.Error(), "ZSTD") {
+		t.Errorf("err = %v, want one naming ZSTD", err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		n    node
+		in   any
+		want any
+	}{
+		{node{kind: kindDecimal, scale: 2}, int32(-1234), -12.34},
+		{node{kind: kindDecimal, scale: 3}, []byte{0xff, 0x85}, -0.123},
+		{node{kind: kindTimestamp, unit: 1000}, int64(1700000000123456), "2023-11-14T22:13:20.123456Z"},
+		{node{kind: kindTime, unit: 1000000}, int32(3723004), "01:02:03.004"},
+		{node{kind: kindUnsigned}, int32(-1), int64(4294967295)},
+		{node{kind: kindUUID}, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}, "12345678-9abc-def0-1234-56789abcdef0"},
+		{node{}, []byte{0xff, 0xfe}, "//4="},
+		{node{}, float32(0.1), 0.1},
+		{node{}, int96{0, 0, 0, 0, 0, 0, 0, 0, 0x8c, 0x3d, 0x25, 0}, "1970-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		if got := tt.n.convert(tt.in); got != tt.want {
+			t.Errorf("convert(%v) with kind %d = %#v, want %#v", tt.in, tt.n.kind, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package parquet
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Field repetition types.
+const (
+	required = 0
+	optional = 1
+	repeated = 2
+)
+
+// Converted types, the annotations of older writers.
+const (
+	convUTF8            = 0
+	convMap             = 1
+	convMapKeyValue     = 2
+	convList            = 3
+	convEnum            = 4
+	convDecimal         = 5
+	convDate            = 6
+	convTimeMillis      = 7
+	convTimeMicros      = 8
+	convTimestampMillis = 9
+	convTimestampMicros = 10
+	convUint8           = 11
+	convUint64          = 14
+	convJSON            = 19
+)
+
+// kind is how the values of a leaf column are presented.
+type kind int
+
+const (
+	kindPlain kind = iota
+	kindString
+	kindDate
+	kindTime
+	kindTimestamp
+	kindDecimal
+	kindUnsigned
+	kindUUID
+	kindFloat16
+)
+
+// node is an element of a file's schema: a group, or a leaf column when it
+// has a physical type.
+type node struct {
+	name       string
+	typ        int // physical type; -1 for groups
+	typeLength int
+	repetition int
+	children   []*node
+	parent     *node
+
+	kind  kind
+	scale int           // of decimals
+	unit  time.Duration // of times and timestamps
+	list  bool          // a LIST group
+	isMap bool          // a MAP group
+
+	// maxDef and maxRep are the definition and repetition levels at which
+	// this node is present, counting its ancestors.
+	maxDef, maxRep int
+}
+
+// buildSchema rebuilds the schema tree from its depth-first flattening in
+// the footer.
+func buildSchema(elems tlist) (*node, error) {
+	if len(elems) == 0 {
+		return nil, errors.New("Parquet file has no schema")
+	}
+	pos := 0
+	var build func(parent *node, depth int) (*node, error)
+	build = func(parent *node, depth int) (*node, error) {
+		if pos >= len(elems) {
+			return nil, errors.New("Parquet schema ends unexpectedly")
+		}
+		if depth > maxThriftDepth {
+			return nil, errors.New("Parquet schema is nested too deeply")
+		}
+		el, _ := elems[pos].(tstruct)
+		pos++
+		n := &node{name: el.str(4), typ: -1, parent: parent}
+		if parent != nil {
+			n.repetition = int(el.int(3))
+			n.maxDef, n.maxRep = parent.maxDef, parent.maxRep
+			switch n.repetition {
+			case optional:
+				n.maxDef++
+			case repeated:
+				n.maxDef++
+				n.maxRep++
+			}
+		}
+		if el.has(1) {
+			n.typ = int(el.int(1))
+			n.typeLength = int(el.int(2))
+			n.annotate(el)
+			return n, nil
+		}
+		conv := int64(-1)
+		if el.has(6) {
+			conv = el.int(6)
+		}
+		logical := el.strct(10)
+		n.list = conv == convList || logical.has(3)
+		n.isMap = conv == convMap || conv == convMapKeyValue || logical.has(2)
+		for range el.int(5) {
+			child, err := build(n, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, child)
+		}
+		return n, nil
+	}
+	root, err := build(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(elems) {
+		return nil, errors.New("Parquet schema has elements outside its root")
+	}
+	return root, nil
+}
+
+// annotate sets how a leaf's values are presented from its logical type,
+// or from its converted type when it has none.
+func (n *node) annotate(el tstruct) {
+	if logical := el.strct(10); len(logical) > 0 {
+		switch {
+		case logical.has(1), logical.has(4), logical.has(12):
+			n.kind = kindString
+		case logical.has(5):
+			n.kind, n.scale = kindDecimal, int(logical.strct(5).int(1))
+		case logical.has(6):
+			n.kind = kindDate
+		case logical.has(7):
+			n.kind, n.unit = kindTime, timeUnit(logical.strct(7).strct(2))
+		case logical.has(8):
+			n.kind, n.unit = kindTimestamp, timeUnit(logical.strct(8).strct(2))
+		case logical.has(10):
+			if !logical.strct(10).bool(2) {
+				n.kind = kindUnsigned
+			}
+		case logical.has(14):
+			n.kind = kindUUID
+		case logical.has(15):
+			n.kind = kindFloat16
+		}
+		return
+	}
+	if !el.has(6) {
+		return
+	}
+	switch conv := el.int(6); {
+	case conv == convUTF8, conv == convEnum, conv == convJSON:
+		n.kind = kindString
+	case conv == convDecimal:
+		n.kind, n.scale = kindDecimal, int(el.int(7))
+	case conv == convDate:
+		n.kind = kindDate
+	case conv == convTimeMillis:
+		n.kind, n.unit = kindTime, time.Millisecond
+	case conv == convTimeMicros:
+		n.kind, n.unit = kindTime, time.Microsecond
+	case conv == convTimestampMillis:
+		n.kind, n.unit = kindTimestamp, time.Millisecond
+	case conv == convTimestampMicros:
+		n.kind, n.unit = kindTimestamp, time.Microsecond
+	case conv >= convUint8 && conv <= convUint64:
+		n.kind = kindUnsigned
+	}
+}
+
+// timeUnit returns the duration of a TimeUnit union.
+func timeUnit(u tstruct) time.Duration {
+	switch {
+	case u.has(1):
+		return time.Millisecond
+	case u.has(2):
+		return time.Microsecond
+	default:
+		return time.Nanosecond
+	}
+}
+
+// leaves appends the leaf columns below n, in column order, to out.
+func (n *node) leaves(out []*node) []*node {
+	if n.typ >= 0 {
+		return append(out, n)
+	}
+	for _, c := range n.children {
+		out = c.leaves(out)
+	}
+	return out
+}
+
+// path returns the dotted path of n below the schema root.
+func (n *node) path() string {
+	var parts []string
+	for ; n != nil && n.parent != nil; n = n.parent {
+		parts = append(parts, n.name)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".")
+}
+
+// convert turns a decoded physical value of leaf n into the value Rows
+// returns for it.
+func (n *node) convert(v any) any {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case int32:
+		if n.kind == kindUnsigned {
+			return int64(uint32(val))
+		}
+		return n.convertInt(int64(val))
+	case int64:
+		if n.kind == kindUnsigned {
+			return uint64(val)
+		}
+		return n.convertInt(val)
+	case int96:
+		nanos := int64(binary.LittleEndian.Uint64(val[:8]))
+		day := int64(binary.LittleEndian.Uint32(val[8:]))
+		const unixEpochJulianDay = 2440588
+		return time.Unix((day-unixEpochJulianDay)*86400, nanos).UTC().Format(time.RFC3339Nano)
+	case float32:
+		// Go through the shortest text form so that 0.1 stays 0.1.
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(val), 'g', -1, 32), 64)
+		return f
+	case float64:
+		return val
+	case []byte:
+		return n.convertBytes(val)
+	}
+	return v
+}
+
+func (n *node) convertInt(v int64) any {
+	switch n.kind {
+	case kindDate:
+		return time.Unix(v*86400, 0).UTC().Format(time.DateOnly)
+	case kindTime:
+		return time.Unix(0, v*int64(n.unit)).UTC().Format("15:04:05.999999999")
+	case kindTimestamp:
+		sec, frac := v/int64(time.Second/n.unit), v%int64(time.Second/n.unit)
+		return time.Unix(sec, frac*int64(n.unit)).UTC().Format(time.RFC3339Nano)
+	case kindDecimal:
+		return decimal(big.NewInt(v), n.scale)
+	}
+	return v
+}
+
+func (n *node) convertBytes(b []byte) any {
+	switch n.kind {
+	case kindString:
+		return string(b)
+	case kindDecimal:
+		// Big-endian two's complement.
+		x := new(big.Int).SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+		}
+		return decimal(x, n.scale)
+	case kindUUID:
+		if len(b) == 16 {
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+		}
+	case kindFloat16:
+		if len(b) == 2 {
+			return float16(binary.LittleEndian.Uint16(b))
+		}
+	}
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// decimal returns unscaled × 10^-scale.
+func decimal(unscaled *big.Int, scale int) float64 {
+	s := unscaled.String()
+	if scale > 0 {
+		neg := strings.HasPrefix(s, "-")
+		s = strings.TrimPrefix(s, "-")
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+		if neg {
+			s = "-" + s
+		}
+	} else if scale < 0 {
+		s += strings.Repeat("0", -scale)
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// float16 converts an IEEE 754 half-precision value.
+func float16(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h >> 10 & 0x1f)
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * frac * math.Pow(2, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * (1 + frac/1024) * math.Pow(2, float64(exp-15))
+}
@@ -0,0 +1,234 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Parquet's metadata is serialized with Thrift's compact protocol. Rather
+// than generate code for the whole IDL, structs are decoded generically into
+// maps from field id to value, and the few fields sqlfs needs are read from
+// those.
+
+// Compact protocol type ids.
+const (
+	tBoolTrue  = 1
+	tBoolFalse = 2
+	tByte      = 3
+	tI16       = 4
+	tI32       = 5
+	tI64       = 6
+	tDouble    = 7
+	tBinary    = 8
+	tList      = 9
+	tSet       = 10
+	tMap       = 11
+	tStruct    = 12
+)
+
+// maxThriftDepth bounds the nesting of decoded structs, lists and maps so
+// that a corrupt footer cannot exhaust the stack.
+const maxThriftDepth = 64
+
+var errThriftEOF = errors.New("metadata ends unexpectedly")
+
+// tstruct is a decoded Thrift struct: field values by field id. Integers of
+// every width are int64, binaries []byte, lists tlist and structs tstruct.
+type tstruct map[int16]any
+
+type tlist []any
+
+func (s tstruct) has(id int16) bool { _, ok := s[id]; return ok }
+
+func (s tstruct) int(id int16) int64 {
+	n, _ := s[id].(int64)
+	return n
+}
+
+func (s tstruct) bool(id int16) bool {
+	b, _ := s[id].(bool)
+	return b
+}
+
+func (s tstruct) str(id int16) string {
+	b, _ := s[id].([]byte)
+	return string(b)
+}
+
+func (s tstruct) strct(id int16) tstruct {
+	v, _ := s[id].(tstruct)
+	return v
+}
+
+func (s tstruct) list(id int16) tlist {
+	v, _ := s[id].(tlist)
+	return v
+}
+
+// thriftReader decodes compact protocol values from data.
+type thriftReader struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// readStruct decodes a struct starting at the reader's position.
+func (r *thriftReader) readStruct() (tstruct, error) {
+	if r.depth++; r.depth > maxThriftDepth {
+		return nil, errors.New("metadata is nested too deeply")
+	}
+	defer func() { r.depth-- }()
+
+	s := tstruct{}
+	var last int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			n, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(n)
+		}
+		last = id
+		var v any
+		switch typ {
+		case tBoolTrue:
+			v = true
+		case tBoolFalse:
+			v = false
+		default:
+			if v, err = r.value(typ); err != nil {
+				return nil, err
+			}
+		}
+		s[id] = v
+	}
+}
+
+// value decodes a value of the given type. Booleans inside lists take a byte
+// of their own, unlike those of struct fields.
+func (r *thriftReader) value(typ byte) (any, error) {
+	switch typ {
+	case tBoolTrue, tBoolFalse:
+		b, err := r.byte()
+		return b == tBoolTrue, err
+	case tByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case tI16, tI32, tI64:
+		return r.varint()
+	case tDouble:
+		if r.pos+8 > len(r.data) {
+			return nil, errThriftEOF
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return f, nil
+	case tBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.data)-r.pos) {
+			return nil, errThriftEOF
+		}
+		b := r.data[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return b, nil
+	case tList, tSet:
+		return r.readList()
+	case tMap:
+		return nil, r.skipMap()
+	case tStruct:
+		return r.readStruct()
+	default:
+		return nil, fmt.Errorf("unknown metadata type %d", typ)
+	}
+}
+
+func (r *thriftReader) readList() (tlist, error) {
+	if r.depth++; r.depth > maxThriftDepth {
+		return nil, errors.New("metadata is nested too deeply")
+	}
+	defer func() { r.depth-- }()
+
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	n := uint64(b >> 4)
+	if n == 15 {
+		if n, err = r.uvarint(); err != nil {
+			return nil, err
+		}
+	}
+	// Every element takes at least a byte.
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errThriftEOF
+	}
+	l := make(tlist, 0, n)
+	for i := uint64(0); i < n; i++ {
+		v, err := r.value(b & 0x0f)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+// skipMap reads past a map, which no field sqlfs reads holds.
+func (r *thriftReader) skipMap() error {
+	n, err := r.uvarint()
+	if err != nil || n == 0 {
+		return err
+	}
+	types, err := r.byte()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.value(types >> 4); err != nil {
+			return err
+		}
+		if _, err := r.value(types & 0x0f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftEOF
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	n, size := binary.Uvarint(r.data[r.pos:])
+	if size <= 0 {
+		return 0, errThriftEOF
+	}
+	r.pos += size
+	return n, nil
+}
+
+// varint reads a zigzag-encoded integer.
+func (r *thriftReader) varint() (int64, error) {
+	n, err := r.uvarint()
+	return int64(n>>1) ^ -int64(n&1), err
+}
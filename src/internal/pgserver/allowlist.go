@@ -0,0 +1,168 @@
+package pgserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/notwillk/sqlfs/internal/accesslog"
+)
+
+// QueryAllowed reports whether query matches one of Options.AllowedQueries.
+// Whitespace runs outside quotes are collapsed and trailing semicolons
+// dropped before matching, so formatting does not matter. Every query is
+// allowed when no patterns are configured.
+func (s *Server) QueryAllowed(query string) bool {
+	if len(s.opts.AllowedQueries) == 0 {
+		return true
+	}
+	normalized := normalizeQuery(query)
+	for _, re := range s.opts.AllowedQueries {
+		if re.MatchString(normalized) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeQuery collapses runs of whitespace in query to single spaces and
+// drops trailing semicolons. String literals and quoted identifiers are kept
+// as they are, so queries differing only inside them stay distinct.
+func normalizeQuery(query string) string {
+	var b strings.Builder
+	var quote rune // the quote of the literal or identifier being copied
+	space := false
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0 // a doubled quote reopens on the next rune
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		}
+		if space {
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+// session identifies the client a query is run for. db is the key of its
+// database in Server.dbs; database and application are the names the client
+// sent in its startup message.
+type session struct {
+	db, user, client      string
+	database, application string
+}
+
+// runQuery executes query for the session's user against its database if the
+// allowlist permits it, and otherwise replies with an error. The user's row
+// filters, if any, apply. Results are answered from and stored in the query
+// cache when it is enabled, and the query is recorded in the access log.
+// While it runs, the query is listed in ConnectionsTable and can be canceled.
+func (s *Server) runQuery(backend *pgproto3.Backend, cc *clientConn, query string) {
+	ctx := cc.begin(query)
+	defer cc.end()
+	sess := cc.sess
+	if s.opts.AccessLog == nil {
+		s.answerQuery(ctx, backend, sess, query) //nolint:errcheck
+		return
+	}
+	start := time.Now()
+	out := &rowCounter{sender: backend}
+	err := s.answerQuery(ctx, out, sess, query)
+	e := accesslog.Entry{
+		Time:        start,
+		Client:      sess.client,
+		User:        sess.user,
+		Database:    sess.database,
+		Application: sess.application,
+		Fingerprint: accesslog.Fingerprint(query),
+		Rows:        out.rows,
+		Duration:    time.Since(start),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.opts.AccessLog.Log(e)
+}
+
+// answerQuery sends the response to query and returns the error the client
+// was sent, if any.
+func (s *Server) answerQuery(ctx context.Context, backend sender, sess session, query string) error {
+	if !s.QueryAllowed(query) {
+		const msg = "query is not in this server's allowed_queries list"
+		backend.Send(&pgproto3.ErrorResponse{ //nolint:errcheck
+			Severity: "ERROR",
+			Code:     "42501", // insufficient_privilege
+			Message:  msg,
+		})
+		return errors.New(msg)
+	}
+	filtered := s.RowFiltered(sess.user)
+
+	limits := resultLimits{rows: s.opts.MaxResultRows, bytes: s.opts.MaxResultBytes}
+	if readsConnections(query) {
+		// Sessions change from one query to the next, so these are never
+		// cached.
+		return s.runConnections(ctx, backend, sess, query, limits)
+	}
+
+	var key cacheKey
+	var gen uint64
+	var rec *recorder
+	out := backend
+	if s.cache != nil {
+		key = cacheKey{db: sess.db, query: normalizeQuery(query)}
+		if filtered {
+			key.user = sess.user
+		}
+		if msgs, ok := s.cache.get(key); ok {
+			for _, msg := range msgs {
+				if err := backend.Send(msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		gen = s.cache.generation(sess.db)
+		rec = &recorder{sender: backend, maxBytes: s.opts.QueryCacheBytes}
+		out = rec
+	}
+
+	var err error
+	if filtered {
+		err = s.runFiltered(ctx, out, sess, query, limits)
+	} else {
+		err = executeQuery(ctx, out, s.currentDB(sess.db), query, limits)
+	}
+	if rec != nil && err == nil && !rec.overflow {
+		s.cache.put(key, gen, rec.msgs, rec.bytes)
+	}
+	return err
+}
+
+// rowCounter counts the data rows sent through it.
+type rowCounter struct {
+	sender
+	rows int
+}
+
+func (c *rowCounter) Send(msg pgproto3.BackendMessage) error {
+	if _, ok := msg.(*pgproto3.DataRow); ok {
+		c.rows++
+	}
+	return c.sender.Send(msg)
+}
@@ -1,20 +1,80 @@
 package pgserver
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgproto3/v2"
 )
 
+// AuthMethod is how clients prove they know the password, named as in
+// PostgreSQL's pg_hba.conf.
+type AuthMethod string
+
+const (
+	AuthPassword AuthMethod = "password"      // cleartext password
+	AuthMD5      AuthMethod = "md5"           // salted MD5 hash of the password
+	AuthSCRAM    AuthMethod = "scram-sha-256" // SCRAM-SHA-256 challenge-response (the default)
+)
+
+// credentials returns the user name a client connecting as user must have
+// connected as, and the password it must know. Users with their own password
+// are always asked for it; any other user name must be Username. An empty
+// username means no credentials are required.
+func (s *Server) credentials(user string) (username, password string) {
+	if u, ok := s.opts.Users[user]; ok {
+		return user, u.Password
+	}
+	if s.opts.Username == "" && len(s.opts.Users) > 0 {
+		// Only Users may connect. Others are still asked for a password, as
+		// a user name no client can send, so that the failed login does not
+		// reveal that the user does not exist.
+		return user + "\x00", ""
+	}
+	return s.opts.Username, s.opts.Password
+}
+
+// AuthRequired reports whether clients must log in, as Username or one of
+// Users.
+func (s *Server) AuthRequired() bool {
+	return s.opts.Username != "" || len(s.opts.Users) > 0
+}
+
+// Authenticate reports whether user may log in with password, for other
+// front ends sharing this server's logins. It always succeeds when
+// AuthRequired is false.
+func (s *Server) Authenticate(user, password string) bool {
+	username, want := s.credentials(user)
+	if username == "" {
+		return true
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+	return userOK && passwordOK
+}
+
 // authResult is the outcome of authentication for a connection.
 type authResult struct {
 	ok  bool
 	err error
 }
 
-// handleAuth performs authentication with the client.
-// If username is empty, all connections are accepted without credentials.
-func handleAuth(backend *pgproto3.Backend, username, password string) error {
+// handleAuth performs authentication with the client, which connected as
+// user: it must connect as username and know password, whose SCRAM secret is
+// secret. If username is empty, all connections are accepted without
+// credentials. A client connecting as another user is still asked for its
+// password, so that a failed login does not reveal whether the user name
+// exists; mock is the SCRAM secret it is checked against, which no password
+// matches.
+func handleAuth(backend *pgproto3.Backend, method AuthMethod, user, username, password string, secret, mock *scramSecret) error {
 	if username == "" {
 		// No auth required.
 		if err := backend.Send(&pgproto3.AuthenticationOk{}); err != nil {
@@ -23,31 +83,224 @@ func handleAuth(backend *pgproto3.Backend, username, password string) error {
 		return nil
 	}
 
-	// Request cleartext password.
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+	var err error
+	switch method {
+	case AuthPassword:
+		err = cleartextAuth(backend, password)
+	case AuthMD5:
+		err = md5Auth(backend, user, password)
+	case AuthSCRAM, "":
+		// The exchange ends by proving the server knows the password, so
+		// it must fail before that for the wrong user.
+		if !userOK {
+			secret = mock
+		}
+		err = scramAuth(backend, secret)
+	default:
+		return sendAuthError(backend, fmt.Sprintf("unsupported authentication method %q", method))
+	}
+	if err != nil {
+		return err
+	}
+	if !userOK {
+		return sendAuthError(backend, "password authentication failed")
+	}
+
+	if err := backend.Send(&pgproto3.AuthenticationOk{}); err != nil {
+		return fmt.Errorf("send AuthOk: %w", err)
+	}
+	return nil
+}
+
+func cleartextAuth(backend *pgproto3.Backend, password string) error {
 	if err := backend.Send(&pgproto3.AuthenticationCleartextPassword{}); err != nil {
 		return fmt.Errorf("send AuthCleartextPassword: %w", err)
 	}
+	pw, err := receivePassword(backend)
+	if err != nil {
+		return err
+	}
+	if pw.Password != password {
+		return sendAuthError(backend, "password authentication failed")
+	}
+	return nil
+}
 
-	msg, err := backend.Receive()
+// md5Auth asks for "md5" + md5(md5(password + user) + salt) in hex, as
+// libpq computes it.
+func md5Auth(backend *pgproto3.Backend, user, password string) error {
+	var salt [4]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return fmt.Errorf("generate salt: %w", err)
+	}
+	if err := backend.Send(&pgproto3.AuthenticationMD5Password{Salt: salt}); err != nil {
+		return fmt.Errorf("send AuthMD5Password: %w", err)
+	}
+	pw, err := receivePassword(backend)
 	if err != nil {
-		return fmt.Errorf("receive password: %w", err)
+		return err
+	}
+
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt[:]...))
+	want := "md5" + hex.EncodeToString(outer[:])
+	if subtle.ConstantTimeCompare([]byte(pw.Password), []byte(want)) != 1 {
+		return sendAuthError(backend, "password authentication failed")
 	}
+	return nil
+}
 
+func receivePassword(backend *pgproto3.Backend) (*pgproto3.PasswordMessage, error) {
+	msg, err := backend.Receive()
+	if err != nil {
+		return nil, fmt.Errorf("receive password: %w", err)
+	}
 	pw, ok := msg.(*pgproto3.PasswordMessage)
 	if !ok {
-		return sendAuthError(backend, "expected password message")
+		return nil, sendAuthError(backend, "expected password message")
 	}
+	return pw, nil
+}
 
-	if pw.Password != password {
+// scramIterations is the PBKDF2 iteration count offered to clients,
+// PostgreSQL's default.
+const scramIterations = 4096
+
+// scramSecret is what the server needs of a password to verify SCRAM
+// exchanges, as PostgreSQL stores it: deriving it takes scramIterations
+// rounds of PBKDF2, so it is derived once per password rather than per
+// connection.
+type scramSecret struct {
+	salt      []byte
+	storedKey [sha256.Size]byte
+	serverKey []byte
+}
+
+// newSCRAMSecret derives the SCRAM secret of password with a random salt.
+func newSCRAMSecret(password string) (*scramSecret, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	saltedPassword, err := pbkdf2.Key(sha256.New, password, salt, scramIterations, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("derive SCRAM key: %w", err)
+	}
+	return &scramSecret{
+		salt:      salt,
+		storedKey: sha256.Sum256(hmacSHA256(saltedPassword, "Client Key")),
+		serverKey: hmacSHA256(saltedPassword, "Server Key"),
+	}, nil
+}
+
+// mockSCRAMSecret returns a secret that no password matches, for
+// connections as unknown users. Its salt is derived from user and key, a
+// random server secret, so that it is as stable as a real user's salt.
+func mockSCRAMSecret(key []byte, user string) *scramSecret {
+	mac := hmacSHA256(key, user)
+	return &scramSecret{salt: mac[:16], storedKey: sha256.Sum256(mac), serverKey: mac}
+}
+
+// scramAuth runs a SCRAM-SHA-256 exchange (RFC 5802, RFC 7677) without
+// channel binding. As in PostgreSQL, the user name in the client's messages
+// is ignored in favour of the one it connected as.
+func scramAuth(backend *pgproto3.Backend, secret *scramSecret) error {
+	if err := backend.Send(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}}); err != nil {
+		return fmt.Errorf("send AuthSASL: %w", err)
+	}
+	backend.SetAuthType(pgproto3.AuthTypeSASL) //nolint:errcheck
+	msg, err := backend.Receive()
+	if err != nil {
+		return fmt.Errorf("receive SASL initial response: %w", err)
+	}
+	initial, ok := msg.(*pgproto3.SASLInitialResponse)
+	if !ok || initial.AuthMechanism != "SCRAM-SHA-256" {
+		return sendAuthError(backend, "expected SCRAM-SHA-256 initial response")
+	}
+
+	// client-first-message: gs2-header client-first-message-bare, where the
+	// header is "n,," (no channel binding) or "y,," (client supports it but
+	// thinks the server does not).
+	clientFirst := string(initial.Data)
+	if !strings.HasPrefix(clientFirst, "n,,") && !strings.HasPrefix(clientFirst, "y,,") {
+		return sendAuthError(backend, "unsupported SCRAM channel binding")
+	}
+	gs2Header, clientFirstBare := clientFirst[:3], clientFirst[3:]
+	clientNonce := scramAttr(clientFirstBare, 'r')
+	if clientNonce == "" {
+		return sendAuthError(backend, "malformed SCRAM client-first-message")
+	}
+
+	serverNonce := make([]byte, 18)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	nonce := clientNonce + base64.StdEncoding.EncodeToString(serverNonce)
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(secret.salt), scramIterations)
+	if err := backend.Send(&pgproto3.AuthenticationSASLContinue{Data: []byte(serverFirst)}); err != nil {
+		return fmt.Errorf("send AuthSASLContinue: %w", err)
+	}
+
+	backend.SetAuthType(pgproto3.AuthTypeSASLContinue) //nolint:errcheck
+	msg, err = backend.Receive()
+	if err != nil {
+		return fmt.Errorf("receive SASL response: %w", err)
+	}
+	resp, ok := msg.(*pgproto3.SASLResponse)
+	if !ok {
+		return sendAuthError(backend, "expected SASL response")
+	}
+
+	// client-final-message: "c=<gs2 header>,r=<nonce>,...,p=<proof>".
+	clientFinal := string(resp.Data)
+	withoutProof, proofB64, found := strings.Cut(clientFinal, ",p=")
+	if !found {
+		return sendAuthError(backend, "malformed SCRAM client-final-message")
+	}
+	if scramAttr(withoutProof, 'c') != base64.StdEncoding.EncodeToString([]byte(gs2Header)) ||
+		scramAttr(withoutProof, 'r') != nonce {
+		return sendAuthError(backend, "SCRAM nonce or channel binding mismatch")
+	}
+	proof, err := base64.StdEncoding.DecodeString(proofB64)
+	if err != nil || len(proof) != sha256.Size {
+		return sendAuthError(backend, "malformed SCRAM proof")
+	}
+
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+	clientSignature := hmacSHA256(secret.storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientSignature[i]
+	}
+	if got := sha256.Sum256(proof); subtle.ConstantTimeCompare(got[:], secret.storedKey[:]) != 1 {
 		return sendAuthError(backend, "password authentication failed")
 	}
 
-	if err := backend.Send(&pgproto3.AuthenticationOk{}); err != nil {
-		return fmt.Errorf("send AuthOk: %w", err)
+	serverSignature := hmacSHA256(secret.serverKey, authMessage)
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(serverSignature)
+	if err := backend.Send(&pgproto3.AuthenticationSASLFinal{Data: []byte(serverFinal)}); err != nil {
+		return fmt.Errorf("send AuthSASLFinal: %w", err)
 	}
 	return nil
 }
 
+// scramAttr returns the value of the attribute named name in a
+// comma-separated SCRAM message, or "" if it is absent.
+func scramAttr(msg string, name byte) string {
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) >= 2 && attr[0] == name && attr[1] == '=' {
+			return attr[2:]
+		}
+	}
+	return ""
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
 func sendAuthError(backend *pgproto3.Backend, msg string) error {
 	backend.Send(&pgproto3.ErrorResponse{ //nolint:errcheck
 		Severity: "FATAL",
@@ -0,0 +1,151 @@
+package pgserver
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// maxCachedRows is the largest result the query cache keeps, so that one big
+// query cannot hold on to an unbounded amount of memory.
+const maxCachedRows = 10000
+
+// sender is the part of pgproto3.Backend that executeQuery writes to.
+type sender interface {
+	Send(msg pgproto3.BackendMessage) error
+}
+
+// cacheKey identifies a cached result: the database, the user when the user's
+// row filters shape the result, and the normalized query text.
+type cacheKey struct {
+	db, user, query string
+}
+
+// resultCache is an LRU cache of the messages answering a query, holding at
+// most size results and, unless maxBytes is zero, maxBytes bytes of values.
+type resultCache struct {
+	mu       sync.Mutex
+	size     int
+	maxBytes int64
+	bytes    int64
+	order    *list.List // of *cacheEntry, most recently used first
+	entries  map[cacheKey]*list.Element
+	gens     map[string]uint64 // database → times invalidated
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	msgs  []pgproto3.BackendMessage
+	bytes int64
+}
+
+func newResultCache(size int, maxBytes int64) *resultCache {
+	return &resultCache{size: size, maxBytes: maxBytes, order: list.New(), entries: make(map[cacheKey]*list.Element), gens: make(map[string]uint64)}
+}
+
+// generation returns the generation of database db, which changes whenever
+// its results are invalidated. A result is only stored under the generation
+// read before its query ran, so one computed from a database that has since
+// been reloaded is never cached.
+func (c *resultCache) generation(db string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gens[db]
+}
+
+// get returns the cached messages for key, if any.
+func (c *resultCache) get(key cacheKey) ([]pgproto3.BackendMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).msgs, true
+}
+
+// put stores msgs, of the given size in bytes, for key unless the database
+// has been invalidated since generation gen, evicting the least recently
+// used entries while the cache is over its limits. A result larger than the
+// whole byte limit is not stored.
+func (c *resultCache) put(key cacheKey, gen uint64, msgs []pgproto3.BackendMessage, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gens[key.db] || c.maxBytes > 0 && bytes > c.maxBytes {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, msgs: msgs, bytes: bytes})
+	c.bytes += bytes
+	for c.order.Len() > c.size || c.maxBytes > 0 && c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *resultCache) remove(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	c.order.Remove(el)
+	delete(c.entries, e.key)
+	c.bytes -= e.bytes
+}
+
+// invalidate drops every cached result of database db.
+func (c *resultCache) invalidate(db string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[db]++
+	for key, el := range c.entries {
+		if key.db == db {
+			c.remove(el)
+		}
+	}
+}
+
+// recorder forwards messages to a sender while keeping a copy of them, until
+// the result grows past maxCachedRows or, unless it is zero, maxBytes bytes
+// of values.
+type recorder struct {
+	sender
+	maxBytes int64
+	msgs     []pgproto3.BackendMessage
+	rows     int
+	bytes    int64
+	overflow bool
+}
+
+func (r *recorder) Send(msg pgproto3.BackendMessage) error {
+	if row, ok := msg.(*pgproto3.DataRow); ok {
+		r.rows++
+		for _, v := range row.Values {
+			r.bytes += int64(len(v))
+		}
+		if r.rows > maxCachedRows || r.maxBytes > 0 && r.bytes > r.maxBytes {
+			r.overflow = true
+			r.msgs = nil
+		}
+	}
+	if !r.overflow {
+		r.msgs = append(r.msgs, cloneMessage(msg))
+	}
+	return r.sender.Send(msg)
+}
+
+// cloneMessage copies a DataRow, whose values executeQuery reuses for the next
+// row once the message is sent. Other messages are returned as they are.
+func cloneMessage(msg pgproto3.BackendMessage) pgproto3.BackendMessage {
+	row, ok := msg.(*pgproto3.DataRow)
+	if !ok {
+		return msg
+	}
+	vals := make([][]byte, len(row.Values))
+	for i, v := range row.Values {
+		if v != nil {
+			vals[i] = append([]byte{}, v...)
+		}
+	}
+	return &pgproto3.DataRow{Values: vals}
+}
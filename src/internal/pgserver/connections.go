@@ -0,0 +1,226 @@
+package pgserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// ConnectionsTable is the table clients query to list the server's active
+// sessions, modeled on PostgreSQL's pg_stat_activity.
+const ConnectionsTable = "sqlfs_connections"
+
+// readsConnections reports whether query names ConnectionsTable, bare or
+// quoted, outside comments and string literals.
+func readsConnections(query string) bool {
+	rs := []rune(query)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i < len(rs) && !(rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == '\'':
+			// A doubled quote closes and reopens the literal.
+			for i++; i < len(rs) && rs[i] != '\''; i++ {
+			}
+		case r == '"' || r == '`' || r == '[':
+			end := r
+			if r == '[' {
+				end = ']'
+			}
+			start := i + 1
+			for i++; i < len(rs) && rs[i] != end; i++ {
+			}
+			if strings.EqualFold(string(rs[start:min(i, len(rs))]), ConnectionsTable) {
+				return true
+			}
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i+1 < len(rs) && (rs[i+1] == '_' || rs[i+1] == '$' || unicode.IsLetter(rs[i+1]) || unicode.IsDigit(rs[i+1])) {
+				i++
+			}
+			if strings.EqualFold(string(rs[start:i+1]), ConnectionsTable) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientConn is one client session: the key a cancel request must present,
+// who the client is, and the query it is running.
+type clientConn struct {
+	pid, secret uint32
+	sess        session
+	started     time.Time
+
+	mu         sync.Mutex
+	query      string // running query; "" when idle
+	queryStart time.Time
+	cancel     context.CancelFunc // cancels the running query
+}
+
+// begin records query as running and returns the context to run it in,
+// which a cancel request cancels. Call end when the query is done.
+func (c *clientConn) begin(query string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.query, c.queryStart, c.cancel = query, time.Now(), cancel
+	return ctx
+}
+
+// end marks the session idle again.
+func (c *clientConn) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancel()
+	c.query, c.queryStart, c.cancel = "", time.Time{}, nil
+}
+
+// addConn registers a new session under a fresh process ID and a random
+// secret key, which the client is sent in BackendKeyData.
+func (s *Server) addConn(sess session) (*clientConn, error) {
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	s.lastPID++
+	c := &clientConn{pid: s.lastPID, secret: binary.BigEndian.Uint32(key[:]), sess: sess, started: time.Now()}
+	s.conns[c.pid] = c
+	return c, nil
+}
+
+func (s *Server) removeConn(c *clientConn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, c.pid)
+}
+
+// cancelQuery handles a cancel request: the running query of the session
+// with process ID pid is canceled if secret is its key. As in PostgreSQL,
+// nothing is sent back either way.
+func (s *Server) cancelQuery(pid, secret uint32) {
+	s.connsMu.Lock()
+	c := s.conns[pid]
+	s.connsMu.Unlock()
+	if c == nil || subtle.ConstantTimeEq(int32(c.secret), int32(secret)) != 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// connectionRows returns a row of ConnectionsTable for each session user may
+// see, ordered by process ID. Sessions show who connected from where and
+// the queries they run, so Users only see their own; the Username login,
+// and every client when no login is required, sees all of them.
+func (s *Server) connectionRows(user string) [][]any {
+	_, own := s.opts.Users[user]
+	s.connsMu.Lock()
+	conns := make([]*clientConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		if !own || c.sess.user == user {
+			conns = append(conns, c)
+		}
+	}
+	s.connsMu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].pid < conns[j].pid })
+
+	rows := make([][]any, len(conns))
+	for i, c := range conns {
+		c.mu.Lock()
+		state, query, queryStart := "idle", any(nil), any(nil)
+		if c.query != "" {
+			state, query, queryStart = "active", c.query, c.queryStart.UTC().Format(time.RFC3339Nano)
+		}
+		c.mu.Unlock()
+		rows[i] = []any{
+			int64(c.pid), c.sess.user, c.sess.database, c.sess.application, c.sess.client,
+			c.started.UTC().Format(time.RFC3339Nano), state, query, queryStart,
+		}
+	}
+	return rows
+}
+
+// runConnections executes query with ConnectionsTable in scope: a temporary
+// table filled with the current sessions, on a connection to the database as
+// the session's user sees it that is discarded if the table cannot be
+// dropped.
+func (s *Server) runConnections(ctx context.Context, backend sender, sess session, query string, limits resultLimits) error {
+	conn, err := s.Conn(ctx, sess.db, sess.user)
+	if err != nil {
+		return sendQueryError(backend, err)
+	}
+	defer conn.Close()
+
+	table := "temp." + quoteIdent(ConnectionsTable)
+	err = fillConnections(ctx, conn, table, s.connectionRows(sess.user))
+	if err == nil {
+		err = executeQuery(ctx, backend, conn, query, limits)
+	} else {
+		sendQueryError(backend, err) //nolint:errcheck
+	}
+	if _, dropErr := conn.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+table); dropErr != nil {
+		conn.Raw(func(any) error { return driver.ErrBadConn }) //nolint:errcheck
+	}
+	return err
+}
+
+func fillConnections(ctx context.Context, conn *sql.Conn, table string, rows [][]any) error {
+	if _, err := conn.ExecContext(ctx, "CREATE TEMP TABLE "+quoteIdent(ConnectionsTable)+` (
+  "pid" INTEGER PRIMARY KEY,
+  "user" TEXT,
+  "database" TEXT,
+  "application_name" TEXT,
+  "client_addr" TEXT,
+  "backend_start" TEXT,
+  "state" TEXT,
+  "query" TEXT,
+  "query_start" TEXT
+)`); err != nil {
+		return fmt.Errorf("creating %s: %w", ConnectionsTable, err)
+	}
+	for _, row := range rows {
+		if _, err := conn.ExecContext(ctx, "INSERT INTO "+table+" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", row...); err != nil {
+			return fmt.Errorf("filling %s: %w", ConnectionsTable, err)
+		}
+	}
+	return nil
+}
+
+// sendCanceled reports a query stopped by a cancel request.
+func sendCanceled(backend sender) error {
+	const msg = "canceling statement due to user request"
+	backend.Send(&pgproto3.ErrorResponse{ //nolint:errcheck
+		Severity: "ERROR",
+		Code:     "57014", // query_canceled
+		Message:  msg,
+	})
+	return errors.New(msg)
+}
@@ -1,9 +1,12 @@
 package pgserver
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgproto3/v2"
 )
@@ -19,13 +22,29 @@ const (
 	oidUnknown = 705
 )
 
+// resultLimits caps the size of one query's result. Zero fields are unlimited.
+type resultLimits struct {
+	rows  int
+	bytes int64
+}
+
+// queryer is a database handle or a single connection.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
 // executeQuery runs a SQL statement against the database and writes results
-// back to the client via the pgproto3 backend.
-func executeQuery(backend *pgproto3.Backend, db *sql.DB, query string) error {
+// back to the client via the pgproto3 backend. Rows are sent as they are read;
+// a result that outgrows limits ends with an error instead of its remaining
+// rows, as does a query whose ctx is canceled.
+func executeQuery(ctx context.Context, backend sender, db queryer, query string, limits resultLimits) error {
 	query = strings.TrimSpace(query)
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
+		if ctx.Err() != nil {
+			return sendCanceled(backend)
+		}
 		return sendQueryError(backend, err)
 	}
 	defer rows.Close()
@@ -54,24 +73,49 @@ func executeQuery(backend *pgproto3.Backend, db *sql.DB, query string) error {
 
 	// Stream data rows.
 	rowCount := 0
+	var byteCount int64
 	scanDest := make([]any, len(cols))
 	scanPtrs := make([]any, len(cols))
 	for i := range scanDest {
 		scanPtrs[i] = &scanDest[i]
 	}
 
+	// Every row is encoded into the same buffers: Send encodes the message
+	// before returning, so they are free again once it does. buf starts
+	// non-nil so that empty strings are not sent as NULL.
+	vals := make([][]byte, len(cols))
+	ends := make([]int, len(cols))
+	buf := make([]byte, 0, 256)
 	for rows.Next() {
+		if ctx.Err() != nil {
+			return sendCanceled(backend)
+		}
 		if err := rows.Scan(scanPtrs...); err != nil {
 			return sendQueryError(backend, err)
 		}
 
-		vals := make([][]byte, len(cols))
+		buf = buf[:0]
+		for i, v := range scanDest {
+			if v != nil {
+				buf = appendText(buf, v)
+			}
+			ends[i] = len(buf)
+		}
+		start := 0
 		for i, v := range scanDest {
 			if v == nil {
 				vals[i] = nil
 			} else {
-				vals[i] = []byte(fmt.Sprintf("%v", v))
+				vals[i] = buf[start:ends[i]:ends[i]]
 			}
+			start = ends[i]
+		}
+		byteCount += int64(len(buf))
+		if limits.rows > 0 && rowCount >= limits.rows {
+			return sendLimitError(backend, fmt.Sprintf("result exceeds the server's limit of %d rows", limits.rows))
+		}
+		if limits.bytes > 0 && byteCount > limits.bytes {
+			return sendLimitError(backend, fmt.Sprintf("result exceeds the server's limit of %d bytes", limits.bytes))
 		}
 		if err := backend.Send(&pgproto3.DataRow{Values: vals}); err != nil {
 			return fmt.Errorf("send DataRow: %w", err)
@@ -79,6 +123,9 @@ func executeQuery(backend *pgproto3.Backend, db *sql.DB, query string) error {
 		rowCount++
 	}
 	if err := rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			return sendCanceled(backend)
+		}
 		return sendQueryError(backend, err)
 	}
 
@@ -89,6 +136,25 @@ func executeQuery(backend *pgproto3.Backend, db *sql.DB, query string) error {
 	return nil
 }
 
+// appendText appends the text encoding of a scanned value to buf. It formats
+// values the way fmt's %v verb does, without its per-call allocations.
+func appendText(buf []byte, v any) []byte {
+	switch val := v.(type) {
+	case int64:
+		return strconv.AppendInt(buf, val, 10)
+	case float64:
+		return strconv.AppendFloat(buf, val, 'g', -1, 64)
+	case string:
+		return append(buf, val...)
+	case bool:
+		return strconv.AppendBool(buf, val)
+	case time.Time:
+		return val.AppendFormat(buf, "2006-01-02 15:04:05.999999999 -0700 MST")
+	default:
+		return fmt.Appendf(buf, "%v", v)
+	}
+}
+
 func goTypeToOID(dbTypeName string) uint32 {
 	switch strings.ToUpper(dbTypeName) {
 	case "INTEGER", "INT", "INT2", "INT4", "INT8", "BIGINT", "SMALLINT":
@@ -104,7 +170,18 @@ func goTypeToOID(dbTypeName string) uint32 {
 	}
 }
 
-func sendQueryError(backend *pgproto3.Backend, err error) error {
+// sendLimitError reports a result that outgrew the server's limits.
+func sendLimitError(backend sender, msg string) error {
+	backend.Send(&pgproto3.ErrorResponse{ //nolint:errcheck
+		Severity: "ERROR",
+		Code:     "54000", // program_limit_exceeded
+		Message:  msg,
+		Hint:     "Add a LIMIT clause or select fewer columns.",
+	})
+	return fmt.Errorf("%s", msg)
+}
+
+func sendQueryError(backend sender, err error) error {
 	backend.Send(&pgproto3.ErrorResponse{ //nolint:errcheck
 		Severity: "ERROR",
 		Code:     "42601", // syntax_error
@@ -0,0 +1,35 @@
+package pgserver
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// notice returns Options.Notice for the session sess, with its placeholders
+// replaced.
+func (s *Server) notice(sess session) string {
+	s.mu.RLock()
+	built := s.builtAt[sess.db]
+	s.mu.RUnlock()
+	builtAt := ""
+	if !built.IsZero() {
+		builtAt = built.UTC().Format(time.RFC3339)
+	}
+	return strings.NewReplacer(
+		"{database}", sess.database,
+		"{user}", sess.user,
+		"{built_at}", builtAt,
+		"{dataset_hash}", s.datasetHash(sess.db),
+	).Replace(s.opts.Notice)
+}
+
+// modTime returns when the database file at path was last written, which is
+// when it was built, or the zero time if that is not known.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,160 @@
+package pgserver
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// maxPendingNotifications caps the notifications queued for a connection
+// that is not reading them; beyond it the oldest are dropped.
+const maxPendingNotifications = 1000
+
+// listener tracks the LISTEN channels of one connection and the notifications
+// queued for it. Like PostgreSQL, a connection is sent its notifications
+// between queries: at once while it is idle, waiting for the client's next
+// message, or otherwise before the ReadyForQuery ending the current query.
+type listener struct {
+	db string // the database the connection uses
+	// withhold is set for users with row filters, who are sent notifications
+	// without their payload, since it may name rows their filters exclude.
+	withhold bool
+
+	mu       sync.Mutex // guards channels and pending
+	channels map[string]struct{}
+	pending  []*pgproto3.NotificationResponse
+
+	// sendMu is held while the connection is idle and notifications are
+	// written to it, since the connection goroutine otherwise owns the
+	// socket.
+	sendMu sync.Mutex
+	idle   bool
+	wake   chan struct{}
+}
+
+var listenRe = regexp.MustCompile(`(?is)^(LISTEN|UNLISTEN)\s+("?)([A-Za-z_][A-Za-z0-9_$]*|\*)("?)\s*;?$`)
+
+// handleListen processes LISTEN / UNLISTEN statements. It reports whether
+// query was one of them, in which case the response has been sent.
+func (s *Server) handleListen(backend *pgproto3.Backend, l *listener, query string) bool {
+	m := listenRe.FindStringSubmatch(strings.TrimSpace(query))
+	if m == nil {
+		return false
+	}
+	verb := strings.ToUpper(m[1])
+	channel := m[3]
+	if m[2] == "" {
+		channel = strings.ToLower(channel)
+	}
+
+	l.mu.Lock()
+	switch {
+	case verb == "LISTEN":
+		l.channels[channel] = struct{}{}
+	case channel == "*":
+		l.channels = make(map[string]struct{})
+	default:
+		delete(l.channels, channel)
+	}
+	l.mu.Unlock()
+
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(verb)}) //nolint:errcheck
+	return true
+}
+
+// Notify queues a notification for every connection to the named database
+// listening on channel. Users with row filters get it with an empty payload.
+func (s *Server) Notify(database, channel, payload string) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	for l := range s.listeners {
+		if l.db != database {
+			continue
+		}
+		l.mu.Lock()
+		_, ok := l.channels[channel]
+		if ok {
+			if len(l.pending) == maxPendingNotifications {
+				l.pending = l.pending[1:]
+			}
+			n := &pgproto3.NotificationResponse{
+				PID:     1,
+				Channel: channel,
+				Payload: payload,
+			}
+			if l.withhold {
+				n.Payload = ""
+			}
+			l.pending = append(l.pending, n)
+		}
+		l.mu.Unlock()
+		if ok {
+			select {
+			case l.wake <- struct{}{}:
+			default: // already woken
+			}
+		}
+	}
+}
+
+func (s *Server) addListener(sess session) *listener {
+	l := &listener{
+		db:       sess.db,
+		withhold: s.RowFiltered(sess.user),
+		channels: make(map[string]struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+	s.listenersMu.Lock()
+	s.listeners[l] = struct{}{}
+	s.listenersMu.Unlock()
+	return l
+}
+
+func (s *Server) removeListener(l *listener) {
+	s.listenersMu.Lock()
+	delete(s.listeners, l)
+	s.listenersMu.Unlock()
+}
+
+// flush sends any queued notifications.
+func (l *listener) flush(backend *pgproto3.Backend) {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	for _, n := range pending {
+		backend.Send(n) //nolint:errcheck
+	}
+}
+
+// setIdle marks the connection idle, sending the notifications queued so
+// far, before it waits for the client's next message, or busy once one has
+// arrived.
+func (l *listener) setIdle(backend *pgproto3.Backend, idle bool) {
+	l.sendMu.Lock()
+	if idle {
+		l.flush(backend)
+	}
+	l.idle = idle
+	l.sendMu.Unlock()
+}
+
+// deliver sends the notifications queued for an idle connection as they
+// arrive, until done is closed.
+func (l *listener) deliver(backend *pgproto3.Backend, w *batchWriter, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-l.wake:
+		}
+		l.sendMu.Lock()
+		if l.idle {
+			l.flush(backend)
+			w.Flush() //nolint:errcheck
+		}
+		l.sendMu.Unlock()
+	}
+}
@@ -0,0 +1,295 @@
+package pgserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// User is a login with its own password whose queries only see the rows of
+// each filtered table that match the table's condition.
+type User struct {
+	Password string
+	// RowFilters maps table names to SQL conditions, e.g. "tenant = 'a'".
+	RowFilters map[string]string
+}
+
+// sqliteLimitAttached is SQLITE_LIMIT_ATTACHED, the most databases a
+// connection may attach.
+const sqliteLimitAttached = 7
+
+// filteredDB is a copy of a served database holding only the rows a user's
+// row filters permit, kept in a temporary directory until it is closed.
+type filteredDB struct {
+	src *sql.DB // the database it was copied from
+	db  *sql.DB
+	dir string
+}
+
+func (f *filteredDB) close() {
+	f.db.Close()
+	os.RemoveAll(f.dir)
+}
+
+// filterKey identifies the filtered copy of a database for a user.
+type filterKey struct {
+	db, user string
+}
+
+// RowFiltered reports whether user is one of Users with row filters.
+func (s *Server) RowFiltered(user string) bool {
+	return len(s.opts.Users[user].RowFilters) > 0
+}
+
+// Conn returns a connection to the named database as user sees it: for a
+// user with row filters, their filtered copy of it. Rows a filter excludes
+// are not in the copy at all, so no way of naming a table, and no view or
+// trigger reading it, can reach them; attaching databases is disabled so the
+// original cannot be opened either. Every query a user with row filters runs,
+// including those reading ConnectionsTable, runs on such a connection. The
+// caller must close the connection.
+func (s *Server) Conn(ctx context.Context, name, user string) (*sql.Conn, error) {
+	db := s.currentDB(name)
+	if db == nil {
+		return nil, fmt.Errorf("database %q does not exist", name)
+	}
+	if !s.RowFiltered(user) {
+		return db.Conn(ctx)
+	}
+	db, err := s.filteredDB(ctx, name, user, s.opts.Users[user].RowFilters)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sqlite.Limit(conn, sqliteLimitAttached, 0); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// runFiltered executes query for a user with row filters against the
+// user's filtered copy of the session's database.
+func (s *Server) runFiltered(ctx context.Context, backend sender, sess session, query string, limits resultLimits) error {
+	conn, err := s.Conn(ctx, sess.db, sess.user)
+	if err != nil {
+		return sendQueryError(backend, err)
+	}
+	defer conn.Close()
+	return executeQuery(ctx, backend, conn, query, limits)
+}
+
+// filteredDB returns the user's filtered copy of the named database, making
+// it on first use and again once the database has been reloaded.
+func (s *Server) filteredDB(ctx context.Context, name, user string, filters map[string]string) (*sql.DB, error) {
+	s.filteredMu.Lock()
+	defer s.filteredMu.Unlock()
+	src := s.currentDB(name)
+	key := filterKey{name, user}
+	if f := s.filtered[key]; f != nil {
+		if f.src == src {
+			return f.db, nil
+		}
+		f.close()
+		delete(s.filtered, key)
+	}
+	f, err := openFiltered(ctx, src, filters)
+	if err != nil {
+		return nil, err
+	}
+	if s.filtered == nil {
+		s.filtered = make(map[filterKey]*filteredDB)
+	}
+	s.filtered[key] = f
+	return f.db, nil
+}
+
+// dropFiltered closes the filtered copies of the named database.
+func (s *Server) dropFiltered(name string) {
+	s.filteredMu.Lock()
+	defer s.filteredMu.Unlock()
+	for key, f := range s.filtered {
+		if key.db == name {
+			f.close()
+			delete(s.filtered, key)
+		}
+	}
+}
+
+// openFiltered copies src to a temporary file, deletes the rows of each
+// filtered table that its condition does not match, along with the rows of
+// their child tables whose parent row was deleted and the changes table, and
+// opens the result read-only. The copy is vacuumed so that deleted rows do not linger in free
+// pages. SQLite resolves table names case-insensitively, whether quoted or
+// not, so a filter applies to the table whose name matches it in any case;
+// filtering the same table under two spellings is an error.
+func openFiltered(ctx context.Context, src *sql.DB, filters map[string]string) (_ *filteredDB, err error) {
+	dir, err := os.MkdirTemp("", "sqlfs-filtered-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	path := filepath.Join(dir, "filtered.db")
+	if _, err := src.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return nil, fmt.Errorf("copying database for row filters: %w", err)
+	}
+
+	rw, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+	keys := make([]string, 0, len(filters))
+	for t := range filters {
+		keys = append(keys, t)
+	}
+	sort.Strings(keys)
+	filtered := make(map[string]string)
+	for _, key := range keys {
+		var t string
+		err := rw.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE`, key).Scan(&t)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := filtered[strings.ToLower(t)]; ok {
+			return nil, fmt.Errorf("row filters for %q and %q name the same table", other, key)
+		}
+		filtered[strings.ToLower(t)] = key
+		stmt := fmt.Sprintf("DELETE FROM %s WHERE NOT coalesce((%s), 0)", quoteIdent(t), filters[key])
+		if _, err := rw.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("row filter for %q: %w", key, err)
+		}
+	}
+	if err := filterChildren(ctx, rw, filtered); err != nil {
+		return nil, err
+	}
+	// The changes of the last rebuild name the keys of every table's rows,
+	// filtered or not.
+	if err := clearTable(ctx, rw, changesTable); err != nil {
+		return nil, err
+	}
+	if _, err := rw.ExecContext(ctx, "VACUUM"); err != nil {
+		return nil, fmt.Errorf("compacting filtered database: %w", err)
+	}
+	if err := rw.Close(); err != nil {
+		return nil, err
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return &filteredDB{src: src, db: db, dir: dir}, nil
+}
+
+// changesTable and childrenTable are changes.TableName and
+// builder.ChildrenTable.
+const (
+	changesTable  = "__sqlfs_changes__"
+	childrenTable = "__sqlfs_children__"
+)
+
+// childTable is a row of childrenTable: a table holding the records of an
+// array field of parent, whose column holds the parent row's key.
+type childTable struct {
+	table, parent, column, key string
+}
+
+// filterChildren deletes the rows of the child tables of the filtered tables,
+// and of their children in turn, whose parent row is gone, so that nested
+// records of rows a filter excludes are excluded too. filtered holds the
+// lower-cased names of the filtered tables.
+func filterChildren(ctx context.Context, db *sql.DB, filtered map[string]string) error {
+	exists, err := tableExists(ctx, db, childrenTable)
+	if err != nil || !exists {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT "table_name", "parent_table", "parent_column", "parent_key" FROM `+quoteIdent(childrenTable)+` ORDER BY "table_name"`)
+	if err != nil {
+		return err
+	}
+	children := make(map[string][]childTable) // lower-cased parent → children
+	for rows.Next() {
+		var c childTable
+		if err := rows.Scan(&c.table, &c.parent, &c.column, &c.key); err != nil {
+			rows.Close()
+			return err
+		}
+		parent := strings.ToLower(c.parent)
+		children[parent] = append(children[parent], c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	queue := make([]string, 0, len(filtered))
+	for t := range filtered {
+		queue = append(queue, t)
+	}
+	sort.Strings(queue)
+	seen := make(map[string]bool, len(queue))
+	for _, t := range queue {
+		seen[t] = true
+	}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, c := range children[parent] {
+			exists, err := tableExists(ctx, db, c.table)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			stmt := fmt.Sprintf("DELETE FROM %s WHERE coalesce(%s NOT IN (SELECT %s FROM %s), 1)",
+				quoteIdent(c.table), quoteIdent(c.column), quoteIdent(c.key), quoteIdent(c.parent))
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("filtering child table %q: %w", c.table, err)
+			}
+			if child := strings.ToLower(c.table); !seen[child] {
+				seen[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return nil
+}
+
+// clearTable deletes every row of table, if db has it.
+func clearTable(ctx context.Context, db *sql.DB, table string) error {
+	exists, err := tableExists(ctx, db, table)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM "+quoteIdent(table))
+	return err
+}
+
+func tableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE`, table).Scan(&n)
+	return n > 0, err
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -2,64 +2,207 @@ package pgserver
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"fmt"
 	"net"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jackc/pgproto3/v2"
+	_ "github.com/notwillk/sqlfs/internal/sqlite" // register the collations built databases may use
 	_ "modernc.org/sqlite"
+
+	"github.com/notwillk/sqlfs/internal/accesslog"
 )
 
 // Options configures the PostgreSQL wire protocol server.
 type Options struct {
-	Port     int
-	DBPath   string
-	Username string // empty = no auth required
-	Password string
+	Port int
+	// Listen lists further addresses served alongside Port, all sharing the
+	// same databases: "host:port" for TCP or "unix:<path>" for a Unix socket.
+	Listen []string
+	// DBPath is the single database served to every connection, regardless
+	// of the database name in the startup message.
+	DBPath string
+	// Databases maps pg database names to SQLite paths. When non-empty it
+	// replaces DBPath and connections are routed by the startup "database"
+	// parameter; unknown names are rejected.
+	Databases map[string]string
+	Username  string // empty = no auth required
+	Password  string
+	// AuthMethod is how clients send their password. Empty means AuthSCRAM.
+	AuthMethod AuthMethod
+	// Users have their own passwords and row filters. Connections as other
+	// user names authenticate with Username and Password, and are refused
+	// when Users is set but Username is not.
+	Users map[string]User
+	// QueryCacheSize is the number of query results kept in an LRU cache
+	// that is cleared when a database is reloaded. Zero disables caching.
+	QueryCacheSize int
+	// QueryCacheBytes caps the bytes of values the cached results hold
+	// together. Zero means unlimited.
+	QueryCacheBytes int64
+	// MaxResultRows and MaxResultBytes cap the rows, and the bytes of their
+	// text-encoded values, that one query may return; a larger result ends
+	// with an error. Zero means unlimited.
+	MaxResultRows  int
+	MaxResultBytes int64
+	// AccessLog, when set, receives an entry for every query run.
+	AccessLog *accesslog.Logger
+	// AllowedQueries restricts clients to queries matching one of these
+	// patterns in full. Empty allows every query.
+	AllowedQueries []*regexp.Regexp
+	// Notice, when set, is sent to each client as a NOTICE once it has
+	// connected. {database} and {user} in it are replaced by the client's
+	// database and user names, {built_at} by when the database it is served
+	// was built (RFC 3339, in UTC), and {dataset_hash} by its dataset hash.
+	Notice string
 }
 
 // Server is a read-only PostgreSQL wire protocol server backed by SQLite.
 type Server struct {
 	opts     Options
 	mu       sync.RWMutex
-	db       *sql.DB
-	listener net.Listener
+	dbs      map[string]*sql.DB // keyed by database name; "" in single-database mode
+	builtAt  map[string]time.Time
+	listener net.Listener // the Port listener
+	netLns   []net.Listener
+
+	listenersMu sync.Mutex
+	listeners   map[*listener]struct{}
+
+	cache *resultCache // nil when QueryCacheSize is zero
+
+	scram    map[string]*scramSecret // keyed by user name; nil unless authenticating with SCRAM
+	scramKey []byte                  // derives the mock secrets of unknown users
+
+	filteredMu sync.Mutex
+	filtered   map[filterKey]*filteredDB // the copies of dbs seen by Users with row filters
+
+	connsMu sync.Mutex
+	conns   map[uint32]*clientConn // keyed by process ID
+	lastPID uint32
 }
 
 // New creates a new Server. Call Serve to start accepting connections.
 func New(opts Options) (*Server, error) {
-	db, err := openSQLite(opts.DBPath)
-	if err != nil {
-		return nil, fmt.Errorf("opening database: %w", err)
+	paths := opts.Databases
+	if len(paths) == 0 {
+		paths = map[string]string{"": opts.DBPath}
+	}
+
+	dbs := make(map[string]*sql.DB, len(paths))
+	builtAt := make(map[string]time.Time, len(paths))
+	for name, path := range paths {
+		db, err := openSQLite(path)
+		if err != nil {
+			for _, opened := range dbs {
+				opened.Close()
+			}
+			if name == "" {
+				return nil, fmt.Errorf("opening database: %w", err)
+			}
+			return nil, fmt.Errorf("opening database %q: %w", name, err)
+		}
+		dbs[name] = db
+		builtAt[name] = modTime(path)
+	}
+	s := &Server{opts: opts, dbs: dbs, builtAt: builtAt, listeners: make(map[*listener]struct{}), conns: make(map[uint32]*clientConn)}
+	if opts.QueryCacheSize > 0 {
+		s.cache = newResultCache(opts.QueryCacheSize, opts.QueryCacheBytes)
+	}
+	if err := s.deriveSCRAMSecrets(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// deriveSCRAMSecrets derives the SCRAM secrets of Username's and Users'
+// passwords when clients authenticate with SCRAM.
+func (s *Server) deriveSCRAMSecrets() error {
+	if s.opts.AuthMethod != AuthSCRAM && s.opts.AuthMethod != "" {
+		return nil
+	}
+	s.scramKey = make([]byte, 32)
+	if _, err := rand.Read(s.scramKey); err != nil {
+		return fmt.Errorf("generate SCRAM key: %w", err)
+	}
+	s.scram = make(map[string]*scramSecret, len(s.opts.Users)+1)
+	if s.opts.Username != "" {
+		secret, err := newSCRAMSecret(s.opts.Password)
+		if err != nil {
+			return err
+		}
+		s.scram[s.opts.Username] = secret
 	}
-	return &Server{opts: opts, db: db}, nil
+	for name, u := range s.opts.Users {
+		secret, err := newSCRAMSecret(u.Password)
+		if err != nil {
+			return err
+		}
+		s.scram[name] = secret
+	}
+	return nil
 }
 
-// Serve starts the server and blocks until ctx is cancelled.
+// Serve starts the server on Port and every Listen address, and blocks until
+// ctx is cancelled or one of the listeners fails.
 func (s *Server) Serve(ctx context.Context) error {
-	addr := fmt.Sprintf("0.0.0.0:%d", s.opts.Port)
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("listen %s: %w", addr, err)
+	addrs := append([]string{fmt.Sprintf("0.0.0.0:%d", s.opts.Port)}, s.opts.Listen...)
+	lns := make([]net.Listener, 0, len(addrs))
+	closeAll := func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
 	}
-	s.listener = ln
-	defer ln.Close()
+	for _, addr := range addrs {
+		ln, err := listen(addr)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("listen %s: %w", addr, err)
+		}
+		lns = append(lns, ln)
+	}
+	s.listener = lns[0]
+	s.netLns = lns
+	defer closeAll()
 
-	// Close the listener when context is cancelled.
+	// Close the listeners when context is cancelled.
 	go func() {
 		<-ctx.Done()
-		ln.Close()
+		closeAll()
 	}()
 
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			errc <- s.accept(ctx, ln)
+		}(ln)
+	}
+	return <-errc
+}
+
+// listen opens addr: "unix:<path>" for a Unix socket, otherwise TCP.
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// accept serves the connections of one listener until it is closed.
+func (s *Server) accept(ctx context.Context, ln net.Listener) error {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			if ctx.Err() != nil {
 				return nil // normal shutdown
 			}
-			return fmt.Errorf("accept: %w", err)
+			return fmt.Errorf("accept %s: %w", ln.Addr(), err)
 		}
 		go s.handleConn(conn)
 	}
@@ -74,15 +217,34 @@ func (s *Server) Addr() net.Addr {
 }
 
 // Reload atomically swaps the underlying SQLite database.
+// In multi-database mode use ReloadDatabase instead.
 func (s *Server) Reload(dbPath string) error {
+	return s.ReloadDatabase("", dbPath)
+}
+
+// ReloadDatabase atomically swaps the SQLite database served under name.
+func (s *Server) ReloadDatabase(name, dbPath string) error {
+	s.mu.RLock()
+	_, known := s.dbs[name]
+	s.mu.RUnlock()
+	if !known {
+		return fmt.Errorf("unknown database %q", name)
+	}
+
 	newDB, err := openSQLite(dbPath)
 	if err != nil {
 		return fmt.Errorf("opening new database: %w", err)
 	}
+	built := modTime(dbPath)
 	s.mu.Lock()
-	old := s.db
-	s.db = newDB
+	old := s.dbs[name]
+	s.dbs[name] = newDB
+	s.builtAt[name] = built
 	s.mu.Unlock()
+	if s.cache != nil {
+		s.cache.invalidate(name)
+	}
+	s.dropFiltered(name)
 	if old != nil {
 		old.Close()
 	}
@@ -91,21 +253,62 @@ func (s *Server) Reload(dbPath string) error {
 
 // Close shuts down the server.
 func (s *Server) Close() error {
-	if s.listener != nil {
-		s.listener.Close()
+	for _, ln := range s.netLns {
+		ln.Close()
 	}
+	s.filteredMu.Lock()
+	for _, f := range s.filtered {
+		f.close()
+	}
+	s.filtered = nil
+	s.filteredMu.Unlock()
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.db != nil {
-		return s.db.Close()
+	var firstErr error
+	for _, db := range s.dbs {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
+}
+
+// ResolveDatabase maps the database name a client asks for to the key it is
+// served under, as passed to ReloadDatabase. In single-database mode every
+// name resolves to "".
+func (s *Server) ResolveDatabase(requested string) (string, bool) {
+	if len(s.opts.Databases) == 0 {
+		return "", true
+	}
+	_, ok := s.opts.Databases[requested]
+	return requested, ok
+}
+
+// currentDB returns the live handle for a database, which may change on reload.
+func (s *Server) currentDB(name string) *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dbs[name]
+}
+
+// datasetHash returns the dataset hash recorded in the named database by the
+// build that produced it, or "" if it has none.
+func (s *Server) datasetHash(name string) string {
+	var hash string
+	// The table and key are builder.BuildInfoTable and builder.DatasetHashKey.
+	err := s.currentDB(name).QueryRow(`SELECT "value" FROM "__sqlfs_build__" WHERE "key" = 'dataset_hash'`).Scan(&hash)
+	if err != nil {
+		return ""
+	}
+	return hash
 }
 
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
 
-	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+	w := newBatchWriter(conn)
+	defer w.Flush() //nolint:errcheck
+	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(flushingReader{r: conn, w: w}), w)
 
 	// Read startup message (handles SSL negotiation internally via pgproto3).
 	startupMsg, err := backend.ReceiveStartupMessage()
@@ -113,9 +316,14 @@ func (s *Server) handleConn(conn net.Conn) {
 		return
 	}
 
-	switch startupMsg.(type) {
+	var startup *pgproto3.StartupMessage
+	switch m := startupMsg.(type) {
 	case *pgproto3.StartupMessage:
 		// Normal connection.
+		startup = m
+	case *pgproto3.CancelRequest:
+		s.cancelQuery(m.ProcessID, m.SecretKey)
+		return
 	case *pgproto3.SSLRequest:
 		// Decline SSL.
 		conn.Write([]byte{'N'}) //nolint:errcheck
@@ -124,15 +332,43 @@ func (s *Server) handleConn(conn net.Conn) {
 		if err != nil {
 			return
 		}
-		if _, ok := startupMsg.(*pgproto3.StartupMessage); !ok {
+		switch m2 := startupMsg.(type) {
+		case *pgproto3.StartupMessage:
+			startup = m2
+		case *pgproto3.CancelRequest:
+			s.cancelQuery(m2.ProcessID, m2.SecretKey)
+			return
+		default:
 			return
 		}
 	default:
 		return
 	}
 
-	// Authenticate.
-	if err := handleAuth(backend, s.opts.Username, s.opts.Password); err != nil {
+	// Authenticate. Sessions run as the user the client connected as.
+	user := startup.Parameters["user"]
+	username, password := s.credentials(user)
+	var secret, mock *scramSecret
+	if s.scram != nil {
+		secret, mock = s.scram[username], mockSCRAMSecret(s.scramKey, user)
+	}
+	if err := handleAuth(backend, s.opts.AuthMethod, user, username, password, secret, mock); err != nil {
+		return
+	}
+
+	// Route to the requested database. Clients default the database name to
+	// the user name when none is given, mirroring PostgreSQL.
+	requested := startup.Parameters["database"]
+	if requested == "" {
+		requested = user
+	}
+	dbName, ok := s.ResolveDatabase(requested)
+	if !ok {
+		backend.Send(&pgproto3.ErrorResponse{ //nolint:errcheck
+			Severity: "FATAL",
+			Code:     "3D000", // invalid_catalog_name
+			Message:  fmt.Sprintf("database %q does not exist", requested),
+		})
 		return
 	}
 
@@ -143,15 +379,41 @@ func (s *Server) handleConn(conn net.Conn) {
 		{"server_encoding", "UTF8"},
 		{"DateStyle", "ISO, MDY"},
 		{"integer_datetimes", "on"},
+		// SQLite has no backslash escapes. Clients that quote parameters
+		// themselves, such as pgx in simple protocol mode, need to know.
+		{"standard_conforming_strings", "on"},
+	}
+	// Like PostgreSQL, report the client's application_name back to it.
+	app := startup.Parameters["application_name"]
+	params = append(params, [2]string{"application_name", app})
+	if hash := s.datasetHash(dbName); hash != "" {
+		params = append(params, [2]string{"sqlfs.dataset_hash", hash})
 	}
 	for _, kv := range params {
 		if err := backend.Send(&pgproto3.ParameterStatus{Name: kv[0], Value: kv[1]}); err != nil {
 			return
 		}
 	}
-	if err := backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 0}); err != nil {
+	sess := session{
+		db:          dbName,
+		user:        user,
+		client:      conn.RemoteAddr().String(),
+		database:    requested,
+		application: app,
+	}
+	cc, err := s.addConn(sess)
+	if err != nil {
 		return
 	}
+	defer s.removeConn(cc)
+	if err := backend.Send(&pgproto3.BackendKeyData{ProcessID: cc.pid, SecretKey: cc.secret}); err != nil {
+		return
+	}
+	if s.opts.Notice != "" {
+		if err := backend.Send(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: s.notice(sess)}); err != nil {
+			return
+		}
+	}
 	if err := backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'}); err != nil {
 		return
 	}
@@ -162,9 +424,17 @@ func (s *Server) handleConn(conn net.Conn) {
 	}
 	state := &connState{}
 
+	l := s.addListener(sess)
+	defer s.removeListener(l)
+	done := make(chan struct{})
+	defer close(done)
+	go l.deliver(backend, w, done)
+
 	// Query loop — handles both simple and extended query protocols.
 	for {
+		l.setIdle(backend, true)
 		msg, err := backend.Receive()
+		l.setIdle(backend, false)
 		if err != nil {
 			return
 		}
@@ -174,14 +444,14 @@ func (s *Server) handleConn(conn net.Conn) {
 		case *pgproto3.Query:
 			query := strings.TrimSpace(m.String)
 			if query == "" || query == ";" {
-				backend.Send(&pgproto3.EmptyQueryResponse{})          //nolint:errcheck
+				backend.Send(&pgproto3.EmptyQueryResponse{})         //nolint:errcheck
 				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'}) //nolint:errcheck
 				continue
 			}
-			s.mu.RLock()
-			db := s.db
-			s.mu.RUnlock()
-			executeQuery(backend, db, query) //nolint:errcheck
+			if !s.handleListen(backend, l, query) {
+				s.runQuery(backend, cc, query)
+			}
+			l.flush(backend)
 			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'}) //nolint:errcheck
 
 		// ── Extended Query Protocol ──────────────────────────────────────
@@ -207,15 +477,13 @@ func (s *Server) handleConn(conn net.Conn) {
 			query := state.preparedQuery
 			if query == "" || query == ";" {
 				backend.Send(&pgproto3.EmptyQueryResponse{}) //nolint:errcheck
-			} else {
-				s.mu.RLock()
-				db := s.db
-				s.mu.RUnlock()
-				executeQuery(backend, db, query) //nolint:errcheck
+			} else if !s.handleListen(backend, l, query) {
+				s.runQuery(backend, cc, query)
 			}
 
 		case *pgproto3.Sync:
 			// End of extended query cycle — send ReadyForQuery.
+			l.flush(backend)
 			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'}) //nolint:errcheck
 
 		case *pgproto3.Terminate:
@@ -3,12 +3,25 @@ package pgserver
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/notwillk/sqlfs/internal/accesslog"
 )
 
 // findFreePort returns an available TCP port.
@@ -116,6 +129,52 @@ func TestServer_Auth_InvalidPassword(t *testing.T) {
 	}
 }
 
+func TestServer_Auth_Methods(t *testing.T) {
+	for _, method := range []AuthMethod{AuthPassword, AuthMD5, AuthSCRAM} {
+		_, port := startTestServer(t, Options{
+			Port:       0,
+			DBPath:     ":memory:",
+			Username:   "testuser",
+			Password:   "testpass",
+			AuthMethod: method,
+		})
+		if err := connectPG(t, port, "testuser", "testpass").Ping(); err != nil {
+			t.Errorf("%s: ping with valid credentials: %v", method, err)
+		}
+		if err := connectPG(t, port, "testuser", "wrongpassword").Ping(); err == nil {
+			t.Errorf("%s: expected error with wrong password", method)
+		}
+		if err := connectPG(t, port, "otheruser", "testpass").Ping(); err == nil {
+			t.Errorf("%s: expected error with the password of another user", method)
+		}
+	}
+}
+
+func TestServer_SCRAMSecrets(t *testing.T) {
+	srv, err := New(Options{
+		DBPath:   ":memory:",
+		Username: "testuser",
+		Password: "testpass",
+		Users:    map[string]User{"alice": {Password: "alicepw"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if len(srv.scram) != 2 || srv.scram["testuser"] == nil || srv.scram["alice"] == nil {
+		t.Fatalf("scram secrets = %v, want testuser's and alice's derived up front", srv.scram)
+	}
+
+	// Unknown users get the same salt on every attempt, as known ones do.
+	a, b := mockSCRAMSecret(srv.scramKey, "mallory"), mockSCRAMSecret(srv.scramKey, "mallory")
+	if string(a.salt) != string(b.salt) {
+		t.Error("mock salt changes between attempts")
+	}
+	if c := mockSCRAMSecret(srv.scramKey, "eve"); string(a.salt) == string(c.salt) {
+		t.Error("mock salt is the same for different users")
+	}
+}
+
 func TestServer_QuerySQLiteData(t *testing.T) {
 	// Create a temp SQLite DB with some data.
 	tmpDir := t.TempDir()
@@ -212,3 +271,908 @@ func TestServer_Reload(t *testing.T) {
 		t.Errorf("after reload: val = %q, want reloaded", val2)
 	}
 }
+
+func TestServer_MultipleDatabases(t *testing.T) {
+	tmpDir := t.TempDir()
+	makeDB := func(name, val string) string {
+		path := tmpDir + "/" + name + ".db"
+		d, _ := sql.Open("sqlite", path)
+		d.Exec("CREATE TABLE t (val TEXT)")
+		d.Exec("INSERT INTO t VALUES (?)", val)
+		d.Close()
+		return path
+	}
+
+	_, port := startTestServer(t, Options{
+		Port: 0,
+		Databases: map[string]string{
+			"alpha": makeDB("alpha", "from alpha"),
+			"beta":  makeDB("beta", "from beta"),
+		},
+	})
+
+	queryVal := func(dbname string) (string, error) {
+		dsn := fmt.Sprintf(
+			"host=127.0.0.1 port=%d user=any password=any dbname=%s sslmode=disable prefer_simple_protocol=true",
+			port, dbname,
+		)
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return "", err
+		}
+		defer db.Close()
+		var val string
+		err = db.QueryRow("SELECT val FROM t").Scan(&val)
+		return val, err
+	}
+
+	for _, tc := range []struct{ db, want string }{
+		{"alpha", "from alpha"},
+		{"beta", "from beta"},
+	} {
+		got, err := queryVal(tc.db)
+		if err != nil {
+			t.Fatalf("%s: query: %v", tc.db, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: val = %q, want %q", tc.db, got, tc.want)
+		}
+	}
+
+	if _, err := queryVal("gamma"); err == nil || !strings.Contains(err.Error(), `database "gamma" does not exist`) {
+		t.Errorf("unknown database: err = %v", err)
+	}
+}
+
+func TestServer_ListenNotify(t *testing.T) {
+	srv, port := startTestServer(t, Options{
+		Port:   0,
+		DBPath: ":memory:",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=any password=any dbname=postgres sslmode=disable default_query_exec_mode=simple_protocol", port))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "LISTEN sqlfs_changes"); err != nil {
+		t.Fatalf("LISTEN: %v", err)
+	}
+
+	srv.Notify("", "sqlfs_changes", `{"inserts":1}`)
+	srv.Notify("", "other_channel", "ignored")
+
+	// Notifications are delivered at the next query boundary.
+	if _, err := conn.Exec(ctx, "SELECT 1"); err != nil {
+		t.Fatalf("query after notify: %v", err)
+	}
+	n, err := conn.WaitForNotification(ctx)
+	if err != nil {
+		t.Fatalf("WaitForNotification: %v", err)
+	}
+	if n.Channel != "sqlfs_changes" || n.Payload != `{"inserts":1}` {
+		t.Errorf("notification = %s %q", n.Channel, n.Payload)
+	}
+
+	// An idle connection is sent notifications as they arrive.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		srv.Notify("", "sqlfs_changes", `{"inserts":2}`)
+	}()
+	n, err = conn.WaitForNotification(ctx)
+	if err != nil {
+		t.Fatalf("WaitForNotification while idle: %v", err)
+	}
+	if n.Payload != `{"inserts":2}` {
+		t.Errorf("idle notification = %q", n.Payload)
+	}
+
+	if _, err := conn.Exec(ctx, "UNLISTEN *"); err != nil {
+		t.Fatalf("UNLISTEN: %v", err)
+	}
+}
+
+func TestServer_NotifyQueueLimit(t *testing.T) {
+	srv, err := New(Options{DBPath: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	l := srv.addListener(session{})
+	l.channels["c"] = struct{}{}
+	for i := 0; i < maxPendingNotifications+10; i++ {
+		srv.Notify("", "c", strconv.Itoa(i))
+	}
+	if len(l.pending) != maxPendingNotifications || l.pending[0].Payload != "10" {
+		t.Errorf("queued %d notifications starting at %q, want the last %d", len(l.pending), l.pending[0].Payload, maxPendingNotifications)
+	}
+}
+
+func TestServer_AllowedQueries(t *testing.T) {
+	_, port := startTestServer(t, Options{
+		Port:           0,
+		DBPath:         ":memory:",
+		AllowedQueries: []*regexp.Regexp{regexp.MustCompile(`^(?:SELECT \d+ AS n)$`)},
+	})
+
+	db := connectPG(t, port, "any", "any")
+	var n int
+	if err := db.QueryRow("SELECT   7 AS n;").Scan(&n); err != nil {
+		t.Fatalf("allowed query: %v", err)
+	}
+	if n != 7 {
+		t.Errorf("n = %d, want 7", n)
+	}
+
+	_, err := db.Query("SELECT 1 AS m")
+	if err == nil || !strings.Contains(err.Error(), "allowed_queries") {
+		t.Errorf("disallowed query: err = %v, want an allowlist error", err)
+	}
+}
+
+func TestServer_RowFilters(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupDB.Exec("CREATE TABLE posts (id INTEGER, tenant TEXT)")
+	setupDB.Exec("INSERT INTO posts VALUES (1, 'a'), (2, 'b'), (3, 'a')")
+	setupDB.Exec("CREATE VIEW all_posts AS SELECT * FROM posts")
+	setupDB.Close()
+
+	srv, port := startTestServer(t, Options{
+		Port:   0,
+		DBPath: dbPath,
+		Users: map[string]User{
+			"tenant_a": {Password: "secret", RowFilters: map[string]string{"posts": "tenant = 'a'"}},
+		},
+	})
+
+	count := func(db *sql.DB) (int, error) {
+		var n int
+		err := db.QueryRow("SELECT count(*) FROM posts").Scan(&n)
+		return n, err
+	}
+
+	if n, err := count(connectPG(t, port, "tenant_a", "secret")); err != nil || n != 2 {
+		t.Errorf("tenant_a: count = %d, %v; want 2", n, err)
+	}
+	if n, err := count(connectPG(t, port, "other", "any")); err == nil {
+		t.Errorf("other user: count = %d, want an auth error without Username", n)
+	}
+	if _, err := count(connectPG(t, port, "tenant_a", "wrong")); err == nil {
+		t.Error("tenant_a with a wrong password: expected auth error")
+	}
+
+	// However the table is reached, the filtered rows are not there.
+	tenant := connectPG(t, port, "tenant_a", "secret")
+	for _, q := range []string{
+		"SELECT count(*) FROM main.posts",
+		"SELECT count(*) FROM [main].posts",
+		"SELECT count(*) FROM `main`.posts",
+		"SELECT count(*) FROM main/**/.posts",
+		"SELECT count(*) FROM all_posts",
+	} {
+		var n int
+		if err := tenant.QueryRow(q).Scan(&n); err != nil || n != 2 {
+			t.Errorf("%s: count = %d, %v; want 2", q, n, err)
+		}
+	}
+	// Naming sqlfs_connections, even in a comment, does not reach the
+	// unfiltered database.
+	for _, q := range []string{
+		"SELECT count(*) FROM posts /* sqlfs_connections */",
+		"SELECT (SELECT count(*) FROM posts) FROM sqlfs_connections LIMIT 1",
+	} {
+		var n int
+		if err := tenant.QueryRow(q).Scan(&n); err != nil || n != 2 {
+			t.Errorf("%s: count = %d, %v; want 2", q, n, err)
+		}
+	}
+	if _, err := tenant.Exec(fmt.Sprintf("ATTACH '%s' AS orig", dbPath)); err == nil {
+		t.Error("ATTACH: expected an error")
+	}
+
+	// A reload makes a new filtered copy.
+	setupDB, _ = sql.Open("sqlite", dbPath)
+	setupDB.Exec("INSERT INTO posts VALUES (4, 'a')")
+	setupDB.Close()
+	if err := srv.Reload(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := count(tenant); err != nil || n != 3 {
+		t.Errorf("after reload: count = %d, %v; want 3", n, err)
+	}
+}
+
+// valuesSender keeps the values of the data rows sent to it, and the error
+// message, if any.
+type valuesSender struct {
+	values []string
+	err    string
+}
+
+func (v *valuesSender) Send(msg pgproto3.BackendMessage) error {
+	switch m := msg.(type) {
+	case *pgproto3.DataRow:
+		for _, val := range m.Values {
+			v.values = append(v.values, string(val))
+		}
+	case *pgproto3.ErrorResponse:
+		v.err = m.Message
+	}
+	return nil
+}
+
+// TestServer_RowFiltersOnEveryRoute verifies that a user with row filters
+// sees only the rows they permit whichever way answerQuery takes a query:
+// through sqlfs_connections, the query cache, or the allowlist.
+func TestServer_RowFiltersOnEveryRoute(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupDB.Exec("CREATE TABLE posts (id INTEGER, tenant TEXT)")
+	setupDB.Exec("INSERT INTO posts VALUES (1, 'a'), (2, 'b'), (3, 'a')")
+	setupDB.Close()
+
+	const count = "SELECT count(*) FROM posts"
+	for name, opts := range map[string]Options{
+		"plain":     {},
+		"cache":     {QueryCacheSize: 10},
+		"allowlist": {AllowedQueries: []*regexp.Regexp{regexp.MustCompile(`^SELECT .*`)}},
+	} {
+		opts.DBPath = dbPath
+		opts.Username, opts.Password = "admin", "pw"
+		opts.Users = map[string]User{"tenant_a": {Password: "secret", RowFilters: map[string]string{"posts": "tenant = 'a'"}}}
+		srv, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each user has one session for sqlfs_connections to list.
+		for _, user := range []string{"admin", "tenant_a"} {
+			if _, err := srv.addConn(session{user: user}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, query := range []string{
+			count,
+			count + " /* sqlfs_connections */",
+			"SELECT (" + count + ") FROM sqlfs_connections LIMIT 1",
+		} {
+			// The admin asks first, so that a cached result would be
+			// there for the tenant to be given.
+			for _, user := range []string{"admin", "tenant_a", "tenant_a"} {
+				want := "3"
+				if user == "tenant_a" {
+					want = "2"
+				}
+				var out valuesSender
+				err := srv.answerQuery(context.Background(), &out, session{user: user}, query)
+				if err != nil || strings.Join(out.values, ",") != want {
+					t.Errorf("%s: %s as %s = %v, %v (%s); want %s", name, query, user, out.values, err, out.err, want)
+				}
+			}
+		}
+		srv.Close()
+	}
+}
+
+func TestServer_RowFiltersHideOtherTenantsKeys(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := dir + "/alpha.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE posts (__pk__ TEXT, tenant TEXT)",
+		"INSERT INTO posts VALUES ('a1', 'a'), ('b1', 'b')",
+		"CREATE TABLE posts_tags (__pk__ TEXT, posts_pk TEXT, value TEXT)",
+		"INSERT INTO posts_tags VALUES ('a1#posts_tags-0', 'a1', 'x'), ('b1#posts_tags-0', 'b1', 'y')",
+		"CREATE TABLE posts_tags_notes (__pk__ TEXT, posts_tags_pk TEXT, value TEXT)",
+		"INSERT INTO posts_tags_notes VALUES ('n1', 'a1#posts_tags-0', 'x'), ('n2', 'b1#posts_tags-0', 'y')",
+		`CREATE TABLE __sqlfs_children__ (table_name TEXT, parent_table TEXT, parent_column TEXT, parent_key TEXT)`,
+		`INSERT INTO __sqlfs_children__ VALUES ('posts_tags', 'posts', 'posts_pk', '__pk__'), ('posts_tags_notes', 'posts_tags', 'posts_tags_pk', '__pk__')`,
+		"CREATE TABLE __sqlfs_changes__ (table_name TEXT, op TEXT, key TEXT, changed_at TEXT)",
+		"INSERT INTO __sqlfs_changes__ VALUES ('posts', 'insert', 'a1', ''), ('posts', 'insert', 'b1', '')",
+	} {
+		if _, err := setupDB.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	setupDB.Close()
+
+	srv, port := startTestServer(t, Options{
+		Port:      0,
+		Databases: map[string]string{"alpha": dbPath, "beta": ":memory:"},
+		Username:  "any",
+		Password:  "any",
+		Users: map[string]User{
+			"tenant_a": {Password: "secret", RowFilters: map[string]string{"posts": "tenant = 'a'"}},
+		},
+	})
+
+	// Child tables follow their parent's filter, and the changes table is
+	// emptied.
+	tenant, err := sql.Open("pgx", fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=tenant_a password=secret dbname=alpha sslmode=disable prefer_simple_protocol=true", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenant.Close()
+	for q, want := range map[string]string{
+		"SELECT group_concat(posts_pk) FROM posts_tags":                 "a1",
+		"SELECT group_concat(__pk__) FROM posts_tags_notes":             "n1",
+		"SELECT coalesce(group_concat(key), '') FROM __sqlfs_changes__": "",
+	} {
+		var got string
+		if err := tenant.QueryRow(q).Scan(&got); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", q, got, err, want)
+		}
+	}
+
+	// Notifications reach only listeners of the rebuilt database, and
+	// without their payload for users with row filters.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	listen := func(user, password, database string) *pgx.Conn {
+		conn, err := pgx.Connect(ctx, fmt.Sprintf(
+			"host=127.0.0.1 port=%d user=%s password=%s dbname=%s sslmode=disable default_query_exec_mode=simple_protocol", port, user, password, database))
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close(context.Background()) })
+		if _, err := conn.Exec(ctx, "LISTEN sqlfs_changes"); err != nil {
+			t.Fatalf("LISTEN: %v", err)
+		}
+		return conn
+	}
+	tenantConn := listen("tenant_a", "secret", "alpha")
+	otherConn := listen("any", "any", "alpha")
+	betaConn := listen("any", "any", "beta")
+
+	srv.Notify("beta", "sqlfs_changes", `{"changes":[{"key":"b2"}]}`)
+	srv.Notify("alpha", "sqlfs_changes", `{"changes":[{"key":"b1"}]}`)
+	for _, tc := range []struct {
+		conn *pgx.Conn
+		want string
+	}{
+		{tenantConn, ""},
+		{otherConn, `{"changes":[{"key":"b1"}]}`},
+		{betaConn, `{"changes":[{"key":"b2"}]}`},
+	} {
+		n, err := tc.conn.WaitForNotification(ctx)
+		if err != nil {
+			t.Fatalf("WaitForNotification: %v", err)
+		}
+		if n.Payload != tc.want {
+			t.Errorf("payload = %q, want %q", n.Payload, tc.want)
+		}
+	}
+	// The tenant was sent one notification, not beta's too.
+	short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelShort()
+	if n, err := tenantConn.WaitForNotification(short); err == nil {
+		t.Errorf("tenant was sent a second notification %q", n.Payload)
+	}
+}
+
+func TestServer_QuotedIdentifiers(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupDB.Exec(`CREATE TABLE "User Accounts" ("Full Name" TEXT, "firstName" TEXT)`)
+	setupDB.Exec(`INSERT INTO "User Accounts" VALUES ('Alice Smith', 'Alice')`)
+	setupDB.Exec(`CREATE TABLE "Posts" (id INTEGER, tenant TEXT)`)
+	setupDB.Exec(`INSERT INTO "Posts" VALUES (1, 'a'), (2, 'b')`)
+	setupDB.Close()
+
+	_, port := startTestServer(t, Options{
+		Port:     0,
+		DBPath:   dbPath,
+		Username: "any",
+		Password: "any",
+		Users: map[string]User{
+			"tenant_a": {Password: "secret", RowFilters: map[string]string{"posts": "tenant = 'a'"}},
+		},
+	})
+	db := connectPG(t, port, "any", "any")
+
+	var name, first string
+	if err := db.QueryRow(`SELECT "Full Name", "firstName" FROM "User Accounts"`).Scan(&name, &first); err != nil {
+		t.Fatalf("quoted names: %v", err)
+	}
+	if name != "Alice Smith" || first != "Alice" {
+		t.Errorf("got %q, %q", name, first)
+	}
+	for _, q := range []string{`SELECT count(*) FROM posts`, `SELECT count(*) FROM POSTS`, `SELECT count(*) FROM "Posts"`} {
+		var n int
+		if err := db.QueryRow(q).Scan(&n); err != nil || n != 2 {
+			t.Errorf("%s: count = %d, %v; want 2", q, n, err)
+		}
+	}
+
+	// The filter on "posts" applies to the table named "Posts".
+	var n int
+	if err := connectPG(t, port, "tenant_a", "secret").QueryRow(`SELECT count(*) FROM Posts`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("tenant_a: count = %d, %v; want 1", n, err)
+	}
+}
+
+func TestServer_MultipleListeners(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "pg.sock")
+	extra := findFreePort(t)
+	_, port := startTestServer(t, Options{
+		Port:   0,
+		DBPath: ":memory:",
+		Listen: []string{fmt.Sprintf("127.0.0.1:%d", extra), "unix:" + sock},
+	})
+
+	for _, p := range []int{port, extra} {
+		var n int
+		if err := connectPG(t, p, "any", "any").QueryRow("SELECT 1").Scan(&n); err != nil {
+			t.Errorf("port %d: %v", p, err)
+		}
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("dial unix socket: %v", err)
+	}
+	conn.Close()
+}
+
+func TestServer_QueryCache(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setupDB.Close()
+	if _, err := setupDB.Exec("CREATE TABLE t (val TEXT); INSERT INTO t VALUES ('original')"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, port := startTestServer(t, Options{
+		Port:           0,
+		DBPath:         dbPath,
+		QueryCacheSize: 8,
+	})
+	client := connectPG(t, port, "", "")
+
+	query := func(q string) string {
+		t.Helper()
+		var val string
+		if err := client.QueryRow(q).Scan(&val); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		return val
+	}
+
+	if got := query("SELECT val FROM t"); got != "original" {
+		t.Fatalf("val = %q, want original", got)
+	}
+	if _, err := setupDB.Exec("UPDATE t SET val = 'changed'"); err != nil {
+		t.Fatal(err)
+	}
+	// The same query, however it is spaced, is answered from the cache.
+	if got := query("SELECT  val\n FROM t;"); got != "original" {
+		t.Errorf("cached val = %q, want original", got)
+	}
+
+	if err := srv.Reload(dbPath); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := query("SELECT val FROM t"); got != "changed" {
+		t.Errorf("after reload: val = %q, want changed", got)
+	}
+	// Whitespace inside literals is not collapsed in cache keys.
+	if got := query("SELECT 'a  b'"); got != "a  b" {
+		t.Errorf("literal = %q, want 'a  b'", got)
+	}
+	if got := query("SELECT 'a b'"); got != "a b" {
+		t.Errorf("literal = %q, want 'a b'", got)
+	}
+}
+
+func TestResultCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newResultCache(2, 0)
+	a, b, d := cacheKey{query: "a"}, cacheKey{query: "b"}, cacheKey{query: "d"}
+	c.put(a, 0, nil, 0)
+	c.put(b, 0, nil, 0)
+	c.get(a)
+	c.put(d, 0, nil, 0)
+	if _, ok := c.get(b); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, k := range []cacheKey{a, d} {
+		if _, ok := c.get(k); !ok {
+			t.Errorf("%q should still be cached", k.query)
+		}
+	}
+}
+
+func TestResultCache_Limits(t *testing.T) {
+	c := newResultCache(10, 100)
+	a, b, d := cacheKey{query: "a"}, cacheKey{query: "b"}, cacheKey{query: "d"}
+	c.put(a, 0, nil, 60)
+	c.put(b, 0, nil, 30)
+	c.put(d, 0, nil, 30) // evicts a to stay within 100 bytes
+	if _, ok := c.get(a); ok {
+		t.Error("a should have been evicted for space")
+	}
+	c.put(a, 0, nil, 101)
+	if _, ok := c.get(a); ok {
+		t.Error("a result over the byte limit should not be cached")
+	}
+
+	// A result computed before a reload is not stored after it.
+	gen := c.generation("")
+	c.invalidate("")
+	c.put(a, gen, nil, 1)
+	if _, ok := c.get(a); ok {
+		t.Error("a result from before the reload should not be cached")
+	}
+	c.put(a, c.generation(""), nil, 1)
+	if _, ok := c.get(a); !ok {
+		t.Error("a current result should be cached")
+	}
+}
+
+func TestServer_ResultLimits(t *testing.T) {
+	_, port := startTestServer(t, Options{
+		Port:           0,
+		DBPath:         ":memory:",
+		MaxResultRows:  3,
+		MaxResultBytes: 20,
+	})
+	// Each query gets its own client: pgx follows a failed query with a Close
+	// message, which the server does not support.
+	count := func(q string) (int, error) {
+		rows, err := connectPG(t, port, "", "").Query(q)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		return n, rows.Err()
+	}
+
+	if n, err := count("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 3) SELECT i FROM n"); err != nil || n != 3 {
+		t.Errorf("3 rows: n = %d, err = %v", n, err)
+	}
+	if _, err := count("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 4) SELECT i FROM n"); err == nil || !strings.Contains(err.Error(), "limit of 3 rows") {
+		t.Errorf("4 rows: err = %v, want a row limit error", err)
+	}
+	if _, err := count("SELECT printf('%030d', 1)"); err == nil || !strings.Contains(err.Error(), "limit of 20 bytes") {
+		t.Errorf("30 bytes: err = %v, want a byte limit error", err)
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := map[string]string{
+		"SELECT  1\n\tAS n ;":                "SELECT 1 AS n",
+		"  SELECT 'a  b' ,\"c  d\"":          "SELECT 'a  b' ,\"c  d\"",
+		"SELECT 'it''s   so'   FROM t;;":     "SELECT 'it''s   so' FROM t",
+		"SELECT * FROM t WHERE a = 'x\n\ny'": "SELECT * FROM t WHERE a = 'x\n\ny'",
+	}
+	for query, want := range tests {
+		if got := normalizeQuery(query); got != want {
+			t.Errorf("normalizeQuery(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestAppendText(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+	for _, v := range []any{int64(-42), 3.0, 0.1, 1e21, "héllo", "", true, ts, []byte("ab")} {
+		if got, want := string(appendText(nil, v)), fmt.Sprintf("%v", v); got != want {
+			t.Errorf("appendText(%#v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestServer_EmptyStringIsNotNull(t *testing.T) {
+	_, port := startTestServer(t, Options{Port: 0, DBPath: ":memory:"})
+	db := connectPG(t, port, "", "")
+
+	var s, n sql.NullString
+	if err := db.QueryRow("SELECT '', NULL").Scan(&s, &n); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Valid || s.String != "" {
+		t.Errorf("'' = %#v, want an empty string", s)
+	}
+	if n.Valid {
+		t.Errorf("NULL = %#v, want NULL", n)
+	}
+}
+
+func TestBatchWriter(t *testing.T) {
+	var out strings.Builder
+	w := newBatchWriter(&out)
+	r := flushingReader{r: strings.NewReader("x"), w: w}
+
+	w.Write([]byte("row1")) //nolint:errcheck
+	w.Write([]byte("row2")) //nolint:errcheck
+	if out.Len() != 0 {
+		t.Errorf("wrote %q before a flush", out.String())
+	}
+	r.Read(make([]byte, 1)) //nolint:errcheck
+	if out.String() != "row1row2" {
+		t.Errorf("after read: wrote %q, want both rows", out.String())
+	}
+
+	w.lastFlush = time.Now().Add(-maxFlushDelay)
+	w.Write([]byte("row3")) //nolint:errcheck
+	if out.String() != "row1row2row3" {
+		t.Errorf("after delay: wrote %q, want row3 flushed", out.String())
+	}
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	var buf syncBuffer
+	_, port := startTestServer(t, Options{
+		Port:      0,
+		DBPath:    ":memory:",
+		AccessLog: accesslog.New(&buf, accesslog.FormatJSON),
+	})
+	db, err := sql.Open("pgx", fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=alice password=any dbname=blog application_name=reports sslmode=disable prefer_simple_protocol=true", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow("SELECT 40 + 2").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	var e map[string]any
+	if err := json.Unmarshal([]byte(buf.String()), &e); err != nil {
+		t.Fatalf("log %q: %v", buf.String(), err)
+	}
+	if e["user"] != "alice" || e["database"] != "blog" || e["application"] != "reports" ||
+		e["fingerprint"] != "SELECT ? + ?" || e["rows"] != 1.0 {
+		t.Errorf("entry = %v", e)
+	}
+	if client, _ := e["client"].(string); !strings.HasPrefix(client, "127.0.0.1:") {
+		t.Errorf("client = %q", client)
+	}
+}
+
+// syncBuffer is a strings.Builder safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestServer_DatasetHashParameter(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setupDB.Exec(`CREATE TABLE "__sqlfs_build__" ("key" TEXT PRIMARY KEY, "value" TEXT NOT NULL);
+		INSERT INTO "__sqlfs_build__" VALUES ('dataset_hash', 'abc123')`); err != nil {
+		t.Fatal(err)
+	}
+	setupDB.Close()
+
+	_, port := startTestServer(t, Options{Port: 0, DBPath: dbPath})
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=any password=any dbname=postgres sslmode=disable default_query_exec_mode=simple_protocol", port))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(context.Background())
+	if got := conn.PgConn().ParameterStatus("sqlfs.dataset_hash"); got != "abc123" {
+		t.Errorf("sqlfs.dataset_hash = %q, want abc123", got)
+	}
+}
+
+func TestServer_StandardConformingStrings(t *testing.T) {
+	_, port := startTestServer(t, Options{Port: 0, DBPath: ":memory:"})
+	ctx := context.Background()
+	// pgx only runs the simple protocol against servers that report
+	// standard_conforming_strings, since it then escapes literals itself.
+	conn, err := pgx.Connect(ctx, fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=any password=any dbname=postgres sslmode=disable default_query_exec_mode=simple_protocol", port))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+	if got := conn.PgConn().ParameterStatus("standard_conforming_strings"); got != "on" {
+		t.Errorf("standard_conforming_strings = %q, want on", got)
+	}
+	var got string
+	if err := conn.QueryRow(ctx, "SELECT $1", `a\b'c`).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != `a\b'c` {
+		t.Errorf("round trip = %q, want %q", got, `a\b'c`)
+	}
+}
+
+func TestServer_Notice(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+	setupDB, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setupDB.Exec(`CREATE TABLE "__sqlfs_build__" ("key" TEXT PRIMARY KEY, "value" TEXT NOT NULL);
+		INSERT INTO "__sqlfs_build__" VALUES ('dataset_hash', 'abc123')`); err != nil {
+		t.Fatal(err)
+	}
+	setupDB.Close()
+	built := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(dbPath, built, built); err != nil {
+		t.Fatal(err)
+	}
+
+	_, port := startTestServer(t, Options{Port: 0, DBPath: dbPath, Notice: "read-only {database} for {user}, built {built_at} ({dataset_hash})"})
+	cfg, err := pgx.ParseConfig(fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=alice password=any dbname=blog sslmode=disable default_query_exec_mode=simple_protocol", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notices []string
+	cfg.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) {
+		notices = append(notices, n.Severity+": "+n.Message)
+	}
+	conn, err := pgx.ConnectConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(context.Background())
+	want := []string{"NOTICE: read-only blog for alice, built 2024-05-01T09:30:00Z (abc123)"}
+	if !reflect.DeepEqual(notices, want) {
+		t.Errorf("notices = %q, want %q", notices, want)
+	}
+}
+
+func TestServer_Connections(t *testing.T) {
+	_, port := startTestServer(t, Options{
+		Port:     0,
+		DBPath:   ":memory:",
+		Username: "admin",
+		Password: "pw",
+		Users: map[string]User{
+			"alice":  {Password: "pw"},
+			"bob":    {Password: "pw"},
+			"tenant": {Password: "pw", RowFilters: map[string]string{"t": "1"}},
+		},
+	})
+	ctx := context.Background()
+	connect := func(user, app string) *pgx.Conn {
+		t.Helper()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf(
+			"host=127.0.0.1 port=%d user=%s password=pw dbname=blog application_name=%s sslmode=disable default_query_exec_mode=simple_protocol", port, user, app))
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close(ctx) })
+		return conn
+	}
+	idle := connect("alice", "idler")
+	conn := connect("admin", "reports")
+	if idle.PgConn().PID() == conn.PgConn().PID() || conn.PgConn().SecretKey() == 0 && idle.PgConn().SecretKey() == 0 {
+		t.Errorf("backend keys not per connection: pids %d, %d", idle.PgConn().PID(), conn.PgConn().PID())
+	}
+
+	rows, err := conn.Query(ctx, `SELECT pid, "user", database, application_name, state, query FROM sqlfs_connections ORDER BY pid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type row struct {
+		pid                               int64
+		user, database, app, state, query string
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		var query *string
+		if err := rows.Scan(&r.pid, &r.user, &r.database, &r.app, &r.state, &query); err != nil {
+			t.Fatal(err)
+		}
+		if query != nil {
+			r.query = *query
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []row{
+		{int64(idle.PgConn().PID()), "alice", "blog", "idler", "idle", ""},
+		{int64(conn.PgConn().PID()), "admin", "blog", "reports", "active", `SELECT pid, "user", database, application_name, state, query FROM sqlfs_connections ORDER BY pid`},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sqlfs_connections:\n got %v\nwant %v", got, want)
+	}
+
+	// Users only see their own sessions, whether or not they have row
+	// filters.
+	for _, user := range []string{"bob", "tenant"} {
+		c := connect(user, "app")
+		var users string
+		if err := c.QueryRow(ctx, `SELECT group_concat("user") FROM sqlfs_connections`).Scan(&users); err != nil {
+			t.Fatalf("%s: %v", user, err)
+		}
+		if users != user {
+			t.Errorf("%s sees the sessions of %q, want only its own", user, users)
+		}
+	}
+}
+
+func TestReadsConnections(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT * FROM sqlfs_connections":                true,
+		`SELECT * FROM "SQLFS_Connections"`:              true,
+		"SELECT * FROM temp.[sqlfs_connections]":         true,
+		"SELECT * FROM posts /* sqlfs_connections */":    false,
+		"SELECT * FROM posts -- sqlfs_connections":       false,
+		"SELECT 'sqlfs_connections', 'it''s' FROM posts": false,
+		"SELECT * FROM my_sqlfs_connections":             false,
+	} {
+		if got := readsConnections(query); got != want {
+			t.Errorf("readsConnections(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestServer_CancelRequest(t *testing.T) {
+	_, port := startTestServer(t, Options{Port: 0, DBPath: ":memory:"})
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf(
+		"host=127.0.0.1 port=%d user=any password=any dbname=postgres sslmode=disable default_query_exec_mode=simple_protocol", port))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		conn.PgConn().CancelRequest(ctx) //nolint:errcheck
+	}()
+	start := time.Now()
+	var n int64
+	err = conn.QueryRow(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c").Scan(&n)
+	if err == nil || !strings.Contains(err.Error(), "57014") {
+		t.Fatalf("err = %v, want query_canceled", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancel took %v", d)
+	}
+
+	// The session stays usable.
+	var one string
+	if err := conn.QueryRow(ctx, "SELECT 1").Scan(&one); err != nil || one != "1" {
+		t.Errorf("after cancel: got %q, err = %v", one, err)
+	}
+}
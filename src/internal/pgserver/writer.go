@@ -0,0 +1,68 @@
+package pgserver
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// writeBufferSize is the size of each connection's write buffer. Rows are
+	// sent to the client in writes of up to this many bytes.
+	writeBufferSize = 64 << 10
+	// maxFlushDelay bounds how long a written message may sit in the buffer,
+	// so clients see the first rows of a slow query without waiting for it
+	// to finish.
+	maxFlushDelay = 50 * time.Millisecond
+)
+
+// batchWriter buffers the messages sent on a connection so that a large result
+// goes out in a few large writes instead of one per row. The buffer is flushed
+// when it fills, when a write finds it older than maxFlushDelay, and before the
+// connection reads from the client (see flushingReader).
+type batchWriter struct {
+	mu        sync.Mutex // notifications are written from another goroutine
+	buf       *bufio.Writer
+	lastFlush time.Time
+}
+
+func newBatchWriter(w io.Writer) *batchWriter {
+	return &batchWriter{buf: bufio.NewWriterSize(w, writeBufferSize), lastFlush: time.Now()}
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, err := b.buf.Write(p)
+	if err == nil && time.Since(b.lastFlush) >= maxFlushDelay {
+		err = b.flush()
+	}
+	return n, err
+}
+
+// Flush writes any buffered messages to the connection.
+func (b *batchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+func (b *batchWriter) flush() error {
+	b.lastFlush = time.Now()
+	return b.buf.Flush()
+}
+
+// flushingReader flushes a batchWriter before every read, so that a response
+// is never left in the buffer while the server waits on the client.
+type flushingReader struct {
+	r io.Reader
+	w *batchWriter
+}
+
+func (f flushingReader) Read(p []byte) (int, error) {
+	if err := f.w.Flush(); err != nil {
+		return 0, err
+	}
+	return f.r.Read(p)
+}
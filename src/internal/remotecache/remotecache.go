@@ -0,0 +1,155 @@
+// Package remotecache stores build caches outside the machine that built
+// them, so that CI runs without persistent disks can start from the cache of
+// an earlier run.
+//
+// A location is either an http:// or https:// URL, under which a cache named
+// key is read with GET <url>/<key> and written with PUT <url>/<key>, or a
+// local directory (a path or a file:// URL), typically one that CI saves
+// and restores between runs. Object stores are reached through their HTTP
+// interfaces: e.g. https://storage.googleapis.com/<bucket>/<prefix> with an
+// OAuth token for Google Cloud Storage, or a pre-authorized gateway for S3.
+package remotecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenEnvVar names the environment variable whose value, when set, is sent
+// to HTTP locations as a bearer token.
+const TokenEnvVar = "SQLFS_CACHE_TOKEN"
+
+// ErrNotFound is returned by Get when no cache is stored under the key.
+var ErrNotFound = errors.New("no cache stored")
+
+// Store reads and writes caches by key.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// Open returns the store at location.
+func Open(location string) (Store, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 { // "C:\..." is a path
+		return dirStore(location), nil
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpStore{base: strings.TrimSuffix(location, "/"), token: os.Getenv(TokenEnvVar)}, nil
+	case "file":
+		return dirStore(filepath.FromSlash(u.Path)), nil
+	default:
+		return nil, fmt.Errorf("unsupported cache location %q: use an http(s) URL or a directory", location)
+	}
+}
+
+// dirStore keeps caches as files in a directory.
+type dirStore string
+
+func (d dirStore) Get(_ context.Context, key string) ([]byte, error) {
+	f, err := os.Open(filepath.Join(string(d), key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f)
+}
+
+// Put writes the cache to a temporary file first, so that a concurrent Get
+// never reads a partial cache.
+func (d dirStore) Put(_ context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(string(d), key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readLimited reads r to the end, failing if it holds more than MaxSize
+// bytes.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxSize {
+		return nil, fmt.Errorf("cache is larger than %d bytes", MaxSize)
+	}
+	return data, nil
+}
+
+// httpStore keeps caches on an HTTP server that accepts PUT.
+type httpStore struct {
+	base  string
+	token string
+}
+
+func (h *httpStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := h.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("GET %s/%s returned %s", h.base, key, resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func (h *httpStore) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := h.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %s/%s returned %s", h.base, key, resp.Status)
+	}
+	return nil
+}
+
+func (h *httpStore) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.base+"/"+url.PathEscape(key), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,124 @@
+package remotecache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.Get(ctx, "sqlfs-abc.cache"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Put: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Put(ctx, "sqlfs-abc.cache", []byte("cached")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := s.Get(ctx, "sqlfs-abc.cache")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != "cached" {
+		t.Errorf("Get = %q, want cached", got)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	for _, location := range []string{dir, "file://" + filepath.ToSlash(dir)} {
+		s, err := Open(location)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", location, err)
+		}
+		if _, ok := s.(dirStore); !ok {
+			t.Fatalf("Open(%q) = %T, want a directory store", location, s)
+		}
+	}
+	s, _ := Open(dir)
+	testStore(t, s)
+}
+
+func TestHTTPStore(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv(TokenEnvVar, "s3cret")
+	s, err := Open(srv.URL + "/ci/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	if _, ok := objects["/ci/sqlfs-abc.cache"]; !ok {
+		t.Errorf("objects = %v, want /ci/sqlfs-abc.cache", objects)
+	}
+
+	t.Setenv(TokenEnvVar, "wrong")
+	s, _ = Open(srv.URL + "/ci")
+	if _, err := s.Get(context.Background(), "sqlfs-abc.cache"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get with a wrong token: err = %v, want an HTTP error", err)
+	}
+}
+
+func TestOpen_Unsupported(t *testing.T) {
+	_, err := Open("s3://bucket/prefix")
+	if err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("Open(s3://...) err = %v, want unsupported", err)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	for _, passphrase := range []string{"", "hunter2"} {
+		sealed, err := Seal([]byte("cached"), passphrase)
+		if err != nil {
+			t.Fatalf("Seal(%q): %v", passphrase, err)
+		}
+		if strings.Contains(string(sealed), "cached") == (passphrase != "") {
+			t.Errorf("Seal(%q) = %q, want the cache encrypted only with a passphrase", passphrase, sealed)
+		}
+		data, err := Unseal(sealed, passphrase)
+		if err != nil || string(data) != "cached" {
+			t.Errorf("Unseal(%q) = %q, %v; want cached", passphrase, data, err)
+		}
+
+		sealed[len(sealed)-1] ^= 1
+		if _, err := Unseal(sealed, passphrase); err == nil {
+			t.Errorf("Unseal(%q) accepted a tampered cache", passphrase)
+		}
+	}
+
+	plain, _ := Seal([]byte("cached"), "")
+	if _, err := Unseal(plain, "hunter2"); err == nil {
+		t.Error("Unseal accepted an unencrypted cache when a key is set")
+	}
+	if _, err := Unseal([]byte("cached"), ""); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Unseal of an unsealed cache: err = %v, want ErrCorrupt", err)
+	}
+}
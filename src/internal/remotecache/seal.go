@@ -0,0 +1,63 @@
+package remotecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/notwillk/sqlfs/internal/encrypt"
+)
+
+// MaxSize is the largest cache Get reads. A cache holds a whole database, so
+// the limit is generous; it only keeps a misbehaving location from exhausting
+// memory.
+const MaxSize int64 = 4 << 30
+
+// checksumMagic starts a cache sealed without a key.
+var checksumMagic = []byte("SQLFSSUM")
+
+// ErrCorrupt is returned by Unseal when a cache fails its integrity check.
+var ErrCorrupt = errors.New("cache is corrupted or was tampered with")
+
+// Seal prepares a cache for upload. With a passphrase the cache is encrypted
+// like build outputs, which also authenticates it; without one it is
+// prefixed with its SHA-256 digest, which detects corruption in transit.
+func Seal(data []byte, passphrase string) ([]byte, error) {
+	if passphrase != "" {
+		return encrypt.Encrypt(data, passphrase)
+	}
+	sum := sha256.Sum256(data)
+	sealed := make([]byte, 0, len(checksumMagic)+len(sum)+len(data))
+	sealed = append(sealed, checksumMagic...)
+	sealed = append(sealed, sum[:]...)
+	return append(sealed, data...), nil
+}
+
+// Unseal returns the cache sealed by Seal with the same passphrase. A cache
+// that is not encrypted is refused when a passphrase is given, so that
+// whoever can write to the location cannot substitute one.
+func Unseal(sealed []byte, passphrase string) ([]byte, error) {
+	if encrypt.IsEncrypted(sealed) {
+		if passphrase == "" {
+			return nil, errors.New("cache is encrypted but no encryption key is set")
+		}
+		data, err := encrypt.Decrypt(sealed, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting cache: %w", err)
+		}
+		return data, nil
+	}
+	if passphrase != "" {
+		return nil, errors.New("cache is not encrypted but an encryption key is set")
+	}
+	headerLen := len(checksumMagic) + sha256.Size
+	if len(sealed) < headerLen || !bytes.Equal(sealed[:len(checksumMagic)], checksumMagic) {
+		return nil, ErrCorrupt
+	}
+	data := sealed[headerLen:]
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], sealed[len(checksumMagic):headerLen]) {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
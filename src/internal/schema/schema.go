@@ -2,10 +2,12 @@ package schema
 
 import (
 	"fmt"
+	"log"
 	"strings"
 
 	"github.com/notwillk/sqlfs/internal/config"
 	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/sqlite"
 )
 
 // Generator converts a DBML schema AST into SQLite DDL statements.
@@ -20,8 +22,18 @@ func New(schema *dbml.Schema, cfg *config.Config) *Generator {
 }
 
 // DDL returns a slice of CREATE TABLE statements (one per table).
+// With Config.EnumTables, the enum lookup tables and their rows come first.
 func (g *Generator) DDL() ([]string, error) {
 	var stmts []string
+	if g.Config.EnumTables {
+		for _, en := range g.Schema.Enums {
+			if g.Schema.TableByName(en.Name) != nil {
+				return nil, fmt.Errorf("enum %q: a table of the same name already exists", en.Name)
+			}
+			stmts = append(stmts, g.enumTableSQL(en)...)
+		}
+	}
+	g.warnUnknownTypes()
 	for _, t := range g.Schema.Tables {
 		stmt, err := g.CreateTableSQL(t)
 		if err != nil {
@@ -33,7 +45,10 @@ func (g *Generator) DDL() ([]string, error) {
 			if idx.PK {
 				continue // handled in CREATE TABLE
 			}
-			idxSQL := g.createIndexSQL(t.Name, idx)
+			idxSQL, err := g.createIndexSQL(t.Name, idx)
+			if err != nil {
+				return nil, err
+			}
 			if idxSQL != "" {
 				stmts = append(stmts, idxSQL)
 			}
@@ -43,13 +58,24 @@ func (g *Generator) DDL() ([]string, error) {
 }
 
 // CreateTableSQL returns the CREATE TABLE statement for a single table,
-// appending the five standard columns after the user-defined columns.
+// appending the five standard columns after the user-defined columns and,
+// for a composite [pk] index, a table-level PRIMARY KEY clause. With
+// Config.ForeignKeys, a FOREIGN KEY clause follows for each relationship the
+// table references.
 func (g *Generator) CreateTableSQL(t *dbml.Table) (string, error) {
 	sc := g.Config.StandardColumns
 
+	pkClause, err := primaryKeyClause(t)
+	if err != nil {
+		return "", err
+	}
+	if err := checkExpiryColumns(t); err != nil {
+		return "", err
+	}
+
 	var cols []string
 	for _, col := range t.Columns {
-		colSQL, err := g.columnDef(col)
+		colSQL, err := g.columnDef(t, col)
 		if err != nil {
 			return "", err
 		}
@@ -59,22 +85,135 @@ func (g *Generator) CreateTableSQL(t *dbml.Table) (string, error) {
 	// Standard columns.
 	cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.PK)))
 	cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.Path)))
-	cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.CreatedAt)))
-	cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.ModifiedAt)))
+	tsType := TimestampType(g.Config)
+	cols = append(cols, fmt.Sprintf("  %s %s", sqliteName(sc.CreatedAt), tsType))
+	cols = append(cols, fmt.Sprintf("  %s %s", sqliteName(sc.ModifiedAt), tsType))
 	cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.Checksum)))
 	cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.ULID)))
+	if g.Config.KeepTombstones() {
+		cols = append(cols, fmt.Sprintf("  %s %s", sqliteName(sc.DeletedAt), tsType))
+	}
+	if g.Config.RecordChecksums {
+		cols = append(cols, fmt.Sprintf("  %s TEXT", sqliteName(sc.RecordChecksum)))
+	}
+	if g.Config.SourceLines {
+		cols = append(cols, fmt.Sprintf("  %s INTEGER", sqliteName(sc.SourceLine)))
+	}
+	if pkClause != "" {
+		cols = append(cols, "  "+pkClause)
+	}
+	if g.Config.ForeignKeys {
+		fks, err := g.foreignKeyClauses(t)
+		if err != nil {
+			return "", err
+		}
+		for _, fk := range fks {
+			cols = append(cols, "  "+fk)
+		}
+	}
 
 	tableName := sqliteName(t.Name)
 	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n)", tableName, strings.Join(cols, ",\n")), nil
 }
 
-func (g *Generator) columnDef(col *dbml.Column) (string, error) {
+// checkExpiryColumns rejects a table with more than one [expires] column.
+func checkExpiryColumns(t *dbml.Table) error {
+	var first *dbml.Column
+	for _, col := range t.Columns {
+		if !col.Expires {
+			continue
+		}
+		if first != nil {
+			return fmt.Errorf("table %q: columns %q and %q are both [expires]", t.Name, first.Name, col.Name)
+		}
+		first = col
+	}
+	return nil
+}
+
+// primaryKeyClause returns the PRIMARY KEY (...) table constraint for the
+// table's [pk] index, or "" if it has none.
+func primaryKeyClause(t *dbml.Table) (string, error) {
+	var pk *dbml.Index
+	for _, idx := range t.Indexes {
+		if !idx.PK {
+			continue
+		}
+		if pk != nil {
+			return "", fmt.Errorf("table %q: more than one [pk] index", t.Name)
+		}
+		pk = idx
+	}
+	if pk == nil {
+		return "", nil
+	}
+	if pk.IsExpr {
+		return "", fmt.Errorf("table %q: a [pk] index cannot be an expression", t.Name)
+	}
+	for _, col := range t.Columns {
+		if col.PK {
+			return "", fmt.Errorf("table %q: column %q is [pk] but the table also has a [pk] index", t.Name, col.Name)
+		}
+	}
+	cols := make([]string, len(pk.Columns))
+	for i, c := range pk.Columns {
+		if t.ColumnByName(c) == nil {
+			return "", fmt.Errorf("table %q: [pk] index column %q is not defined", t.Name, c)
+		}
+		cols[i] = sqliteName(c)
+	}
+	return "PRIMARY KEY (" + strings.Join(cols, ", ") + ")", nil
+}
+
+// foreignKeyClauses returns a FOREIGN KEY table constraint for each
+// relationship whose referencing column belongs to t. SQLite requires the
+// referenced column to be unique, so a reference to any other column is an
+// error.
+func (g *Generator) foreignKeyClauses(t *dbml.Table) ([]string, error) {
+	var clauses []string
+	for _, ref := range g.Schema.Relationships() {
+		if ref.From.Table != t.Name {
+			continue
+		}
+		if t.ColumnByName(ref.From.Column) == nil {
+			return nil, fmt.Errorf("table %q: reference from undefined column %q", t.Name, ref.From.Column)
+		}
+		to := g.Schema.TableByName(ref.To.Table)
+		if to == nil {
+			return nil, fmt.Errorf("%s.%s: referenced table %q is not defined", t.Name, ref.From.Column, ref.To.Table)
+		}
+		if !to.IsUnique(ref.To.Column) {
+			return nil, fmt.Errorf("%s.%s: referenced column %s.%s must be a primary key or unique",
+				t.Name, ref.From.Column, ref.To.Table, ref.To.Column)
+		}
+		clause := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)",
+			sqliteName(ref.From.Column), sqliteName(to.Name), sqliteName(ref.To.Column))
+		if ref.OnDelete != "" {
+			clause += " ON DELETE " + strings.ToUpper(ref.OnDelete)
+		}
+		if ref.OnUpdate != "" {
+			clause += " ON UPDATE " + strings.ToUpper(ref.OnUpdate)
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+func (g *Generator) columnDef(t *dbml.Table, col *dbml.Column) (string, error) {
 	affinity := DBMLTypeToSQLite(col.Type)
 	name := sqliteName(col.Name)
 
 	var parts []string
 	parts = append(parts, name+" "+affinity)
 
+	collate, err := CollateClause(g.columnCollation(t, col))
+	if err != nil {
+		return "", fmt.Errorf("%s.%s: %w", t.Name, col.Name, err)
+	}
+	if collate != "" {
+		parts = append(parts, collate)
+	}
+
 	if col.PK && col.Increment && strings.ToLower(affinity) == "integer" {
 		parts = append(parts, "PRIMARY KEY AUTOINCREMENT")
 	} else if col.PK {
@@ -95,13 +234,50 @@ func (g *Generator) columnDef(col *dbml.Column) (string, error) {
 		parts = append(parts, "DEFAULT "+def)
 	}
 
+	if col.IsJSON() && g.Config.JSONText {
+		parts = append(parts, fmt.Sprintf("CHECK (json_valid(%s))", name))
+	}
+
+	if g.Config.EnumTables && g.Schema.EnumByName(col.Type.Name) != nil {
+		parts = append(parts, fmt.Sprintf(`REFERENCES %s("value")`, sqliteName(col.Type.Name)))
+	}
+
 	return strings.Join(parts, " "), nil
 }
 
+// warnUnknownTypes logs a warning for each column whose type is neither an
+// enum nor a built-in type of the Project's database_type.
+func (g *Generator) warnUnknownTypes() {
+	d := g.Schema.Dialect()
+	for _, t := range g.Schema.Tables {
+		for _, col := range t.Columns {
+			if g.Schema.EnumByName(col.Type.Name) != nil || d.HasType(col.Type.Name) {
+				continue
+			}
+			log.Printf("warning: %s.%s: type %q is not a %s type", t.Name, col.Name, col.Type.Name, d)
+		}
+	}
+}
+
+// enumTableSQL returns the CREATE TABLE statement for an enum's lookup table
+// followed by one INSERT per enum value.
+func (g *Generator) enumTableSQL(en *dbml.Enum) []string {
+	name := sqliteName(en.Name)
+	stmts := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  \"value\" TEXT PRIMARY KEY,\n  \"note\" TEXT\n)", name)}
+	for _, v := range en.Values {
+		note := "NULL"
+		if v.Note != "" {
+			note = sqliteString(v.Note)
+		}
+		stmts = append(stmts, fmt.Sprintf(`INSERT INTO %s ("value", "note") VALUES (%s, %s)`, name, sqliteString(v.Name), note))
+	}
+	return stmts
+}
+
 func defaultSQL(dv *dbml.DefaultValue) (string, error) {
 	switch dv.Kind {
 	case dbml.DefaultString:
-		return "'" + strings.ReplaceAll(dv.Value, "'", "''") + "'", nil
+		return sqliteString(dv.Value), nil
 	case dbml.DefaultNumber:
 		return dv.Value, nil
 	case dbml.DefaultBool:
@@ -117,9 +293,42 @@ func defaultSQL(dv *dbml.DefaultValue) (string, error) {
 	return "", fmt.Errorf("unknown default kind %d", dv.Kind)
 }
 
-func (g *Generator) createIndexSQL(tableName string, idx *dbml.Index) string {
+// columnCollation returns the collation of col: the one sqlfs.yaml configures
+// for it, else its [collate] setting, else NOCASE for a citext column, whose
+// PostgreSQL counterpart compares case-insensitively.
+func (g *Generator) columnCollation(t *dbml.Table, col *dbml.Column) string {
+	if c := g.Config.Collation(t.Name, col.Name); c != "" {
+		return c
+	}
+	if col.Collate != "" {
+		return col.Collate
+	}
+	if strings.EqualFold(col.Type.Name, "citext") {
+		return "NOCASE"
+	}
+	return ""
+}
+
+// CollateClause returns the COLLATE clause for the named collation, or "" when
+// name is empty. Collation names are case-insensitive.
+func CollateClause(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	c, ok := sqlite.Collation(name)
+	if !ok {
+		return "", fmt.Errorf("unknown collation %q (expected binary, nocase, rtrim, or %s)", name, strings.ToLower(sqlite.UnicodeNoCase))
+	}
+	return "COLLATE " + c, nil
+}
+
+func (g *Generator) createIndexSQL(tableName string, idx *dbml.Index) (string, error) {
 	if len(idx.Columns) == 0 {
-		return ""
+		return "", nil
+	}
+	collate, err := CollateClause(idx.Collate)
+	if err != nil {
+		return "", fmt.Errorf("index on %s: %w", tableName, err)
 	}
 	unique := ""
 	if idx.Unique {
@@ -132,9 +341,19 @@ func (g *Generator) createIndexSQL(tableName string, idx *dbml.Index) string {
 	cols := make([]string, len(idx.Columns))
 	for i, c := range idx.Columns {
 		cols[i] = sqliteName(c)
+		if collate != "" {
+			cols[i] += " " + collate
+		}
+	}
+	if idx.Type != "" && !strings.EqualFold(idx.Type, "btree") {
+		log.Printf("warning: index %s on %s: SQLite does not support index type %q; creating a default index", name, tableName, idx.Type)
 	}
-	return fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)",
-		unique, sqliteName(name), sqliteName(tableName), strings.Join(cols, ", "))
+	where := ""
+	if idx.Where != "" {
+		where = " WHERE " + idx.Where
+	}
+	return fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)%s",
+		unique, sqliteName(name), sqliteName(tableName), strings.Join(cols, ", "), where), nil
 }
 
 // DBMLTypeToSQLite maps a DBML column type to a SQLite affinity type.
@@ -156,6 +375,19 @@ func DBMLTypeToSQLite(ct dbml.ColumnType) string {
 	}
 }
 
+// TimestampType returns the SQLite type of the standard timestamp columns.
+func TimestampType(cfg *config.Config) string {
+	if cfg.UnixTimestamps() {
+		return "INTEGER"
+	}
+	return "TEXT"
+}
+
+// sqliteString returns s as a single-quoted SQL string literal.
+func sqliteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
 // sqliteName quotes an identifier if necessary.
 func sqliteName(name string) string {
 	// SQLite uses double-quotes for identifiers.
@@ -54,6 +54,65 @@ Table users {
 	}
 }
 
+func TestCreateTableSQL_ColumnOrder(t *testing.T) {
+	src := `
+Table users {
+  zeta varchar
+  alpha varchar
+  mid integer
+}
+`
+	schema := makeSchema(src, t)
+	g := New(schema, defaultConfig())
+	sql, err := g.CreateTableSQL(schema.Tables[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	z, a, m := strings.Index(sql, `"zeta"`), strings.Index(sql, `"alpha"`), strings.Index(sql, `"mid"`)
+	if z < 0 || a < 0 || m < 0 || !(z < a && a < m) {
+		t.Errorf("columns not in DBML order: %s", sql)
+	}
+}
+
+func TestDDL_Collations(t *testing.T) {
+	src := `
+Table users {
+  email citext
+  name varchar [collate: unicode_nocase]
+  handle varchar [collate: nocase]
+  bio text
+  indexes {
+    bio [collate: nocase]
+  }
+}
+`
+	schema := makeSchema(src, t)
+	cfg := defaultConfig()
+	cfg.Collations = map[string]map[string]string{"users": {"handle": "rtrim"}}
+	ddl, err := New(schema, cfg).DDL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sql := strings.Join(ddl, ";\n")
+	for _, want := range []string{
+		`"email" TEXT COLLATE NOCASE`,
+		`"name" TEXT COLLATE UNICODE_NOCASE`,
+		`"handle" TEXT COLLATE RTRIM`,
+		`"bio" TEXT,`,
+		`("bio" COLLATE NOCASE)`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("missing %s:\n%s", want, sql)
+		}
+	}
+
+	bad := makeSchema(`Table t { name varchar [collate: klingon] }`, t)
+	if _, err := New(bad, defaultConfig()).DDL(); err == nil || !strings.Contains(err.Error(), "klingon") {
+		t.Errorf("unknown collation: err = %v", err)
+	}
+}
+
 func TestCreateTableSQL_StandardColumns(t *testing.T) {
 	src := `Table t { id integer [pk] }`
 	schema := makeSchema(src, t)
@@ -190,3 +249,244 @@ Table t {
 		t.Errorf("null default missing: %s", sql)
 	}
 }
+
+func TestDDL_EnumTables(t *testing.T) {
+	src := `
+enum status {
+  draft [note: 'Not yet published']
+  published
+}
+
+Table posts {
+  id integer [pk]
+  state status
+}
+`
+	schema := makeSchema(src, t)
+
+	// Disabled by default: no lookup table, no reference.
+	stmts, err := New(schema, defaultConfig()).DDL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if joined := strings.Join(stmts, "\n"); strings.Contains(joined, "REFERENCES") || strings.Contains(joined, `TABLE IF NOT EXISTS "status"`) {
+		t.Errorf("enum table emitted without enum_tables:\n%s", joined)
+	}
+
+	cfg := defaultConfig()
+	cfg.EnumTables = true
+	stmts, err = New(schema, cfg).DDL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	joined := strings.Join(stmts, "\n")
+	for _, want := range []string{
+		`CREATE TABLE IF NOT EXISTS "status"`,
+		`INSERT INTO "status" ("value", "note") VALUES ('draft', 'Not yet published')`,
+		`INSERT INTO "status" ("value", "note") VALUES ('published', NULL)`,
+		`"state" TEXT REFERENCES "status"("value")`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing %q in DDL:\n%s", want, joined)
+		}
+	}
+	if !strings.HasPrefix(stmts[0], `CREATE TABLE IF NOT EXISTS "status"`) {
+		t.Errorf("enum table should be created first, got %q", stmts[0])
+	}
+}
+
+func TestDDL_EnumTableNameClash(t *testing.T) {
+	schema := makeSchema(`
+enum status {
+  a
+}
+Table status { id integer [pk] }
+`, t)
+	cfg := defaultConfig()
+	cfg.EnumTables = true
+	if _, err := New(schema, cfg).DDL(); err == nil {
+		t.Error("expected error when an enum and a table share a name")
+	}
+}
+
+func TestDDL_PartialAndTypedIndexes(t *testing.T) {
+	src := `
+Table posts {
+  id integer [pk]
+  slug varchar
+  status varchar
+  indexes {
+    slug [name: 'idx_pub', where: ` + "`status = 'published'`" + `]
+    status [name: 'idx_status', type: hash]
+  }
+}
+`
+	schema := makeSchema(src, t)
+	stmts, err := New(schema, defaultConfig()).DDL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	joined := strings.Join(stmts, "\n")
+	if !strings.Contains(joined, `CREATE INDEX IF NOT EXISTS "idx_pub" ON "posts" ("slug") WHERE status = 'published'`) {
+		t.Errorf("missing partial index:\n%s", joined)
+	}
+	// Unsupported index types still produce an index.
+	if !strings.Contains(joined, `CREATE INDEX IF NOT EXISTS "idx_status" ON "posts" ("status")`) {
+		t.Errorf("missing hash-typed index:\n%s", joined)
+	}
+}
+
+func TestCreateTableSQL_UnixTimestamps(t *testing.T) {
+	schema := makeSchema(`Table t { id integer [pk] }`, t)
+	cfg := defaultConfig()
+	cfg.TimestampFormat = config.TimestampUnix
+	sql, err := New(schema, cfg).CreateTableSQL(schema.Tables[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, col := range []string{"__created_at__", "__modified_at__"} {
+		if !strings.Contains(sql, `"`+col+`" INTEGER`) {
+			t.Errorf("%s should be INTEGER: %s", col, sql)
+		}
+	}
+}
+
+func TestCreateTableSQL_JSONCheck(t *testing.T) {
+	schema := makeSchema(`Table t { id integer [pk]
+  meta json
+  doc jsonb
+  name text }`, t)
+	cfg := defaultConfig()
+	cfg.JSONText = true
+	sql, err := New(schema, cfg).CreateTableSQL(schema.Tables[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{`"meta" TEXT CHECK (json_valid("meta"))`, `"doc" TEXT CHECK (json_valid("doc"))`} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("missing %s: %s", want, sql)
+		}
+	}
+	if strings.Contains(sql, `json_valid("name")`) {
+		t.Errorf("text column should not be checked: %s", sql)
+	}
+}
+
+func TestDDL_CompositePrimaryKey(t *testing.T) {
+	src := `
+Table memberships {
+  org_id integer
+  user_id integer
+  role varchar
+  indexes {
+    (org_id, user_id) [pk]
+  }
+}
+`
+	schema := makeSchema(src, t)
+	stmts, err := New(schema, defaultConfig()).DDL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("expected only the CREATE TABLE statement, got %d:\n%s", len(stmts), strings.Join(stmts, "\n"))
+	}
+	if !strings.Contains(stmts[0], `PRIMARY KEY ("org_id", "user_id")`) {
+		t.Errorf("missing composite primary key: %s", stmts[0])
+	}
+}
+
+func TestDDL_CompositePrimaryKeyErrors(t *testing.T) {
+	cases := map[string]string{
+		"inline pk": `Table t {
+  a integer [pk]
+  b integer
+  indexes { (a, b) [pk] }
+}`,
+		"unknown column": `Table t {
+  a integer
+  indexes { (a, missing) [pk] }
+}`,
+		"two pk indexes": `Table t {
+  a integer
+  b integer
+  indexes {
+    (a, b) [pk]
+    a [pk]
+  }
+}`,
+	}
+	for name, src := range cases {
+		t.Run(name, func(t *testing.T) {
+			schema := makeSchema(src, t)
+			if _, err := New(schema, defaultConfig()).DDL(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCreateTableSQL_TwoExpiryColumns(t *testing.T) {
+	schema := makeSchema(`Table promos {
+  starts_at timestamp [expires]
+  ends_at timestamp [expires]
+}`, t)
+	if _, err := New(schema, defaultConfig()).DDL(); err == nil || !strings.Contains(err.Error(), "both [expires]") {
+		t.Errorf("err = %v, want an error naming both [expires] columns", err)
+	}
+}
+
+func TestCreateTableSQL_ForeignKeys(t *testing.T) {
+	src := `
+Table users {
+  id integer [pk]
+  email varchar [unique]
+}
+Table posts {
+  id integer [pk]
+  user_id integer [ref: > users.id]
+  editor varchar
+}
+Ref: posts.editor > users.email [delete: set null, update: cascade]
+`
+	schema := makeSchema(src, t)
+	cfg := defaultConfig()
+	sql, err := New(schema, cfg).CreateTableSQL(schema.TableByName("posts"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(sql, "FOREIGN KEY") {
+		t.Errorf("foreign keys emitted without the option: %s", sql)
+	}
+
+	cfg.ForeignKeys = true
+	sql, err = New(schema, cfg).CreateTableSQL(schema.TableByName("posts"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`FOREIGN KEY ("user_id") REFERENCES "users" ("id")`,
+		`FOREIGN KEY ("editor") REFERENCES "users" ("email") ON DELETE SET NULL ON UPDATE CASCADE`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("missing %s: %s", want, sql)
+		}
+	}
+}
+
+func TestCreateTableSQL_ForeignKeyToNonUniqueColumn(t *testing.T) {
+	schema := makeSchema(`
+Table users {
+  id integer [pk]
+  name varchar
+}
+Table posts {
+  author varchar [ref: > users.name]
+}
+`, t)
+	cfg := defaultConfig()
+	cfg.ForeignKeys = true
+	if _, err := New(schema, cfg).CreateTableSQL(schema.TableByName("posts")); err == nil {
+		t.Error("expected error for reference to a non-unique column")
+	}
+}
@@ -0,0 +1,155 @@
+// Package snapshot retains copies of previous build outputs so older builds
+// can be inspected after the live database has been replaced.
+//
+// A snapshot directory holds one <id>.db file per retained build plus an
+// index.json describing them, newest first. IDs are ULIDs.
+package snapshot
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const indexFile = "index.json"
+
+// Entry describes one retained build.
+type Entry struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	RootDir   string    `json:"root"`
+	Records   int       `json:"records"`
+	Tables    int       `json:"tables"`
+	File      string    `json:"file"` // file name within the snapshot directory
+}
+
+// Save copies the SQLite database srcFile into dir as a new snapshot described by e, then prunes
+// the oldest snapshots so that at most keep remain. e.ID, e.CreatedAt and
+// e.File are assigned by Save.
+func Save(dir, srcFile string, e Entry, keep int) (*Entry, error) {
+	if keep <= 0 {
+		return nil, fmt.Errorf("snapshot retention must be positive, got %d", keep)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+
+	entries, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e.ID = ulid.MustNew(ulid.Timestamp(now), rand.Reader).String()
+	e.CreatedAt = now.UTC()
+	e.File = e.ID + ".db"
+	if err := copyFile(srcFile, filepath.Join(dir, e.File)); err != nil {
+		return nil, fmt.Errorf("copying snapshot: %w", err)
+	}
+
+	// List returns newest first; prepend the new entry and drop the tail.
+	entries = append([]Entry{e}, entries...)
+	if len(entries) > keep {
+		for _, old := range entries[keep:] {
+			if err := os.Remove(filepath.Join(dir, old.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("pruning snapshot %s: %w", old.ID, err)
+			}
+		}
+		entries = entries[:keep]
+	}
+
+	if err := writeIndex(dir, entries); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// List returns the snapshots recorded in dir, newest first (the index is
+// kept in that order).
+// A missing directory or index yields an empty list.
+func List(dir string) ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("reading snapshot index: %w", err)
+	}
+	return entries, nil
+}
+
+// Find returns the snapshot whose ID equals or uniquely starts with id
+// (case-insensitive), along with the absolute path of its database file.
+func Find(dir, id string) (*Entry, string, error) {
+	entries, err := List(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	id = strings.ToUpper(id)
+	var match *Entry
+	for i := range entries {
+		if !strings.HasPrefix(entries[i].ID, id) {
+			continue
+		}
+		if entries[i].ID == id {
+			match = &entries[i]
+			break
+		}
+		if match != nil {
+			return nil, "", fmt.Errorf("snapshot id %q is ambiguous", id)
+		}
+		match = &entries[i]
+	}
+	if match == nil {
+		return nil, "", fmt.Errorf("snapshot %q not found in %s", id, dir)
+	}
+	return match, filepath.Join(dir, match.File), nil
+}
+
+func writeIndex(dir string, entries []Entry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, indexFile+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing snapshot index: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, indexFile))
+}
+
+// copyFile copies src to dst, which gets the permissions of src so that a
+// snapshot of a private database is not readable by others.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
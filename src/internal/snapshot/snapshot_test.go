@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSource(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.db")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSave_ListAndPrune(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+
+	var ids []string
+	for _, content := range []string{"one", "two", "three"} {
+		e, err := Save(dir, writeSource(t, content), Entry{RootDir: "root", Records: len(content)}, 2)
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		ids = append(ids, e.ID)
+	}
+
+	entries, err := List(dir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+	if entries[0].ID != ids[2] || entries[1].ID != ids[1] {
+		t.Errorf("entries = [%s %s], want newest first [%s %s]", entries[0].ID, entries[1].ID, ids[2], ids[1])
+	}
+	if _, err := os.Stat(filepath.Join(dir, ids[0]+".db")); !os.IsNotExist(err) {
+		t.Error("oldest snapshot file was not pruned")
+	}
+
+	_, path, err := Find(dir, ids[1])
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "two" {
+		t.Errorf("snapshot content = %q, want two", data)
+	}
+}
+
+func TestSave_KeepsMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	src := writeSource(t, "private")
+	if err := os.Chmod(src, 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Save(dir, src, Entry{}, 1)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, e.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("snapshot mode = %o, want 600", perm)
+	}
+}
+
+func TestList_MissingDir(t *testing.T) {
+	entries, err := List(filepath.Join(t.TempDir(), "nope"))
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("len(entries) = %d, want 0", len(entries))
+	}
+}
+
+func TestFind_NotFound(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Save(dir, writeSource(t, "x"), Entry{}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Find(dir, "ZZZZ"); err == nil {
+		t.Fatal("expected error for unknown id")
+	}
+}
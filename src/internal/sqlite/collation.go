@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"regexp"
+	"strings"
+
+	driver "modernc.org/sqlite"
+)
+
+// UnicodeNoCase is a collation that compares text ignoring the case of any
+// Unicode letter, where SQLite's NOCASE only folds ASCII letters. It is
+// registered with the driver for every connection this process opens; other
+// SQLite clients must register a collation of the same name to compare
+// values of columns that use it.
+const UnicodeNoCase = "UNICODE_NOCASE"
+
+// unicodeNoCaseClause matches a COLLATE clause naming UnicodeNoCase in SQL.
+var unicodeNoCaseClause = regexp.MustCompile(`(?i)\bCOLLATE\s+"?` + UnicodeNoCase + `"?`)
+
+// builtinCollations are the collations every SQLite provides.
+var builtinCollations = []string{"BINARY", "NOCASE", "RTRIM"}
+
+func init() {
+	driver.MustRegisterCollationUtf8(UnicodeNoCase, func(a, b string) int {
+		return strings.Compare(foldCase(a), foldCase(b))
+	})
+}
+
+// foldCase maps s to a form shared by all its case variants.
+func foldCase(s string) string {
+	return strings.ToLower(strings.ToUpper(s))
+}
+
+// Collation returns the canonical, upper-case name of the collation called
+// name in any case, and false if SQLite (as set up by this package) has no
+// such collation.
+func Collation(name string) (string, bool) {
+	upper := strings.ToUpper(name)
+	for _, c := range append(builtinCollations, UnicodeNoCase) {
+		if upper == c {
+			return c, true
+		}
+	}
+	return "", false
+}
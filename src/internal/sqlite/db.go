@@ -1,21 +1,35 @@
 package sqlite
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	_ "modernc.org/sqlite" // register the sqlite driver
+	driver "modernc.org/sqlite"
 )
 
 const driverName = "sqlite"
 
+// maxCachedStmts bounds the prepared INSERT statements a DB keeps; the cache
+// is emptied when it fills up.
+const maxCachedStmts = 256
+
 // DB wraps a *sql.DB backed by modernc.org/sqlite.
 type DB struct {
 	db   *sql.DB
 	path string
+
+	stmtsMu sync.Mutex
+	stmts   map[string]*sql.Stmt // prepared INSERTs, keyed by table and columns
 }
 
 // Open opens or creates a SQLite database at the given file path.
@@ -38,6 +52,44 @@ func OpenMemory() (*DB, error) {
 	return Open(":memory:")
 }
 
+// OpenSerialized opens an in-memory database holding a copy of data, the
+// bytes of a database file or of a Serialize result.
+func OpenSerialized(data []byte) (*DB, error) {
+	f, err := os.CreateTemp("", "sqlfs-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := OpenMemory()
+	if err != nil {
+		return nil, err
+	}
+	err = d.raw(func(c driverConn) error {
+		b, err := c.NewRestore(f.Name())
+		if err != nil {
+			return err
+		}
+		_, err = b.Step(-1)
+		if ferr := b.Finish(); err == nil {
+			err = ferr
+		}
+		return err
+	})
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("loading database: %w", err)
+	}
+	return d, nil
+}
+
 // OpenReadOnly opens an existing SQLite database in read-only mode.
 func OpenReadOnly(path string) (*DB, error) {
 	uri := fmt.Sprintf("file:%s?mode=ro", path)
@@ -72,26 +124,105 @@ func (d *DB) Exec(query string, args ...any) error {
 }
 
 // InsertRecord inserts a single row into a table.
-// cols and values must be the same length.
+// cols and values must be the same length. The INSERT statement is prepared
+// once for each table and column list and reused, so callers inserting many
+// rows should pass the columns in a consistent order.
 func (d *DB) InsertRecord(table string, cols []string, values []any) error {
 	if len(cols) == 0 {
 		return nil
 	}
-	quotedTable := quoteName(table)
+	stmt, err := d.insertStmt(table, cols)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(values...)
+	return err
+}
+
+// insertStmt returns the cached INSERT statement for cols of table,
+// preparing it on first use. SQLite prepares statements again by itself
+// when the schema changes, so a cached statement stays valid across DDL.
+func (d *DB) insertStmt(table string, cols []string) (*sql.Stmt, error) {
+	key := table + "\x00" + strings.Join(cols, "\x00")
+	d.stmtsMu.Lock()
+	defer d.stmtsMu.Unlock()
+	if stmt, ok := d.stmts[key]; ok {
+		return stmt, nil
+	}
+	stmt, err := d.db.Prepare(insertQuery(table, cols))
+	if err != nil {
+		return nil, err
+	}
+	if d.stmts == nil || len(d.stmts) >= maxCachedStmts {
+		d.closeStmts()
+		d.stmts = make(map[string]*sql.Stmt)
+	}
+	d.stmts[key] = stmt
+	return stmt, nil
+}
+
+// closeStmts closes the cached statements. d.stmtsMu must be held.
+func (d *DB) closeStmts() {
+	for _, stmt := range d.stmts {
+		stmt.Close()
+	}
+	d.stmts = nil
+}
+
+// InsertBatch inserts rows into a table with one prepared statement, all in
+// one transaction: either every row is inserted or none is. Each row holds
+// the values of cols in order. It uses a savepoint, so it may also be called
+// inside a transaction begun with Exec("BEGIN").
+func (d *DB) InsertBatch(table string, cols []string, rows [][]any) (err error) {
+	if len(cols) == 0 || len(rows) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SAVEPOINT sqlfs_batch"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(ctx, "ROLLBACK TO sqlfs_batch") //nolint:errcheck
+		}
+		if _, rerr := conn.ExecContext(ctx, "RELEASE sqlfs_batch"); err == nil {
+			err = rerr
+		}
+	}()
+
+	stmt, err := conn.PrepareContext(ctx, insertQuery(table, cols))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return fmt.Errorf("row %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// insertQuery returns the INSERT statement for one row of cols.
+func insertQuery(table string, cols []string) string {
 	quotedCols := make([]string, len(cols))
 	placeholders := make([]string, len(cols))
 	for i, col := range cols {
 		quotedCols[i] = quoteName(col)
 		placeholders[i] = "?"
 	}
-	query := fmt.Sprintf(
+	return fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES (%s)",
-		quotedTable,
+		quoteName(table),
 		strings.Join(quotedCols, ", "),
 		strings.Join(placeholders, ", "),
 	)
-	_, err := d.db.Exec(query, values...)
-	return err
 }
 
 // Query executes a SQL query and returns the rows.
@@ -106,6 +237,9 @@ func (d *DB) DB() *sql.DB {
 
 // Close closes the database.
 func (d *DB) Close() error {
+	d.stmtsMu.Lock()
+	d.closeStmts()
+	d.stmtsMu.Unlock()
 	return d.db.Close()
 }
 
@@ -125,6 +259,172 @@ func (d *DB) saveMemoryTo(path string) error {
 	return err
 }
 
+// Serialize returns the bytes of the database as they would be stored in a
+// database file.
+func (d *DB) Serialize() ([]byte, error) {
+	var data []byte
+	err := d.raw(func(c driverConn) error {
+		var err error
+		data, err = c.Serialize()
+		return err
+	})
+	return data, err
+}
+
+// driverConn is the part of the driver's connections used beyond
+// database/sql.
+type driverConn interface {
+	Serialize() ([]byte, error)
+	NewRestore(srcURI string) (*driver.Backup, error)
+}
+
+// raw runs f on the database's connection.
+func (d *DB) raw(f func(driverConn) error) error {
+	conn, err := d.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		c, ok := dc.(driverConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", dc)
+		}
+		return f(c)
+	})
+}
+
+// DumpSQL writes a plain-text SQL dump of the database to w: every table's
+// CREATE statement followed by its rows as INSERT statements, then any
+// indexes. Rows are emitted in rowid order so repeated dumps of the same
+// data are byte-identical. The UnicodeNoCase collation is written as NOCASE,
+// which every SQLite has, so the dump loads into stock clients; they fold the
+// case of ASCII letters only.
+func (d *DB) DumpSQL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	type object struct{ name, sql string }
+	var tables, views, indexes []object
+
+	rows, err := d.db.Query(`SELECT type, name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END, name`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var typ string
+		var obj object
+		if err := rows.Scan(&typ, &obj.name, &obj.sql); err != nil {
+			rows.Close()
+			return err
+		}
+		obj.sql = unicodeNoCaseClause.ReplaceAllString(obj.sql, "COLLATE NOCASE")
+		switch typ {
+		case "table":
+			tables = append(tables, obj)
+		case "view":
+			views = append(views, obj)
+		case "index":
+			indexes = append(indexes, obj)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(bw, "BEGIN TRANSACTION;")
+	for _, t := range tables {
+		fmt.Fprintf(bw, "%s;\n", t.sql)
+		if err := d.dumpRows(bw, t.name); err != nil {
+			return fmt.Errorf("dumping table %q: %w", t.name, err)
+		}
+	}
+	for _, v := range views {
+		fmt.Fprintf(bw, "%s;\n", v.sql)
+	}
+	for _, idx := range indexes {
+		fmt.Fprintf(bw, "%s;\n", idx.sql)
+	}
+	fmt.Fprintln(bw, "COMMIT;")
+	return bw.Flush()
+}
+
+// dumpRows writes one INSERT statement per row of table.
+func (d *DB) dumpRows(w io.Writer, table string) error {
+	rows, err := d.db.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY rowid", quoteName(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	quotedCols := make([]string, len(cols))
+	for i, col := range cols {
+		quotedCols[i] = quoteName(col)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES (", quoteName(table), strings.Join(quotedCols, ", "))
+
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	literals := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			literals[i] = sqlLiteral(v)
+		}
+		if _, err := fmt.Fprintf(w, "%s%s);\n", prefix, strings.Join(literals, ", ")); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sqlLiteral renders a scanned SQLite value as a SQL literal. SQL has no
+// literals for NaN and infinities, so NaN is written as NULL, which is what
+// SQLite stores for it, and infinities as numbers too large for a double,
+// which SQLite reads back as infinities.
+func sqlLiteral(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		switch {
+		case math.IsNaN(val):
+			return "NULL"
+		case math.IsInf(val, 1):
+			return "9e999"
+		case math.IsInf(val, -1):
+			return "-9e999"
+		}
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case bool:
+		if val {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return "X'" + hex.EncodeToString(val) + "'"
+	case string:
+		return "'" + strings.ReplaceAll(val, "'", "''") + "'"
+	case time.Time:
+		return "'" + val.UTC().Format(time.RFC3339Nano) + "'"
+	default:
+		return "'" + strings.ReplaceAll(fmt.Sprintf("%v", val), "'", "''") + "'"
+	}
+}
+
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -146,3 +446,87 @@ func copyFile(src, dst string) error {
 func quoteName(name string) string {
 	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
+
+// DumpCopy writes the rows of tables to w as a psql script that loads them
+// into existing PostgreSQL tables of the same names: one "\copy ... FROM
+// stdin" per table followed by its rows in COPY's text format, all in one
+// transaction. Tables are loaded in the given order, so referenced tables
+// should come first when the PostgreSQL tables have foreign keys. Rows are
+// emitted in rowid order.
+func (d *DB) DumpCopy(w io.Writer, tables []string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "BEGIN;")
+	for _, table := range tables {
+		if err := d.copyRows(bw, table); err != nil {
+			return fmt.Errorf("dumping table %q: %w", table, err)
+		}
+	}
+	fmt.Fprintln(bw, "COMMIT;")
+	return bw.Flush()
+}
+
+// copyRows writes table's rows as a psql \copy command and its data.
+func (d *DB) copyRows(w io.Writer, table string) error {
+	rows, err := d.db.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY rowid", quoteName(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	quotedCols := make([]string, len(cols))
+	for i, col := range cols {
+		quotedCols[i] = quoteName(col)
+	}
+	if _, err := fmt.Fprintf(w, "\\copy %s (%s) FROM stdin\n", quoteName(table), strings.Join(quotedCols, ", ")); err != nil {
+		return err
+	}
+
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	fields := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			fields[i] = copyValue(v)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, `\.`)
+	return err
+}
+
+// copyEscaper escapes the characters that are special in COPY's text format.
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// copyValue renders a scanned SQLite value as a field of COPY's text
+// format: NULL is \N and blobs are bytea hex strings.
+func copyValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return `\N`
+	case []byte:
+		return `\\x` + hex.EncodeToString(val)
+	case string:
+		return copyEscaper.Replace(val)
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case int64, float64, bool:
+		return sqlLiteral(val)
+	default:
+		return copyEscaper.Replace(fmt.Sprintf("%v", val))
+	}
+}
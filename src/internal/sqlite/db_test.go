@@ -1,6 +1,9 @@
 package sqlite
 
 import (
+	"fmt"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -96,6 +99,99 @@ func TestInsertRecord_MultipleRows(t *testing.T) {
 	}
 }
 
+func TestInsertRecord_ReusesStatements(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.ExecDDL([]string{`CREATE TABLE t (id INTEGER, val TEXT)`}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.InsertRecord("t", []string{"id", "val"}, []any{i, "v"}); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if len(db.stmts) != 1 {
+		t.Errorf("cached statements = %d, want 1", len(db.stmts))
+	}
+
+	// A cached statement survives the table being recreated.
+	if err := db.ExecDDL([]string{`DROP TABLE t`, `CREATE TABLE t (val TEXT, id INTEGER, extra TEXT)`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRecord("t", []string{"id", "val"}, []any{7, "w"}); err != nil {
+		t.Fatalf("insert after recreate: %v", err)
+	}
+	var val string
+	if err := db.DB().QueryRow("SELECT val FROM t WHERE id = 7").Scan(&val); err != nil || val != "w" {
+		t.Errorf("val = %q, err = %v", val, err)
+	}
+
+	for i := 0; i < maxCachedStmts+10; i++ {
+		if err := db.ExecDDL([]string{fmt.Sprintf(`CREATE TABLE t%d (id INTEGER)`, i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertRecord(fmt.Sprintf("t%d", i), []string{"id"}, []any{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.stmts) > maxCachedStmts {
+		t.Errorf("cached statements = %d, want at most %d", len(db.stmts), maxCachedStmts)
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.ExecDDL([]string{`CREATE TABLE t (id INTEGER PRIMARY KEY, val TEXT)`}); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		var n int
+		if err := db.DB().QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := db.InsertBatch("t", []string{"id", "val"}, [][]any{{1, "a"}, {2, "b"}, {3, "c"}}); err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("expected 3 rows, got %d", n)
+	}
+
+	// A failing row rolls back the whole batch.
+	err = db.InsertBatch("t", []string{"id", "val"}, [][]any{{4, "d"}, {1, "dup"}})
+	if err == nil || !strings.Contains(err.Error(), "row 2") {
+		t.Errorf("duplicate key: err = %v, want an error for row 2", err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("after failed batch: expected 3 rows, got %d", n)
+	}
+
+	// Inside an open transaction the batch joins it.
+	if err := db.Exec("BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertBatch("t", []string{"id", "val"}, [][]any{{5, "e"}}); err != nil {
+		t.Fatalf("InsertBatch in transaction: %v", err)
+	}
+	if err := db.Exec("ROLLBACK"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("after rollback: expected 3 rows, got %d", n)
+	}
+}
+
 func TestSaveMemoryTo(t *testing.T) {
 	db, err := OpenMemory()
 	if err != nil {
@@ -135,6 +231,47 @@ func TestSaveMemoryTo(t *testing.T) {
 	}
 }
 
+func TestSerialize(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ExecDDL([]string{`CREATE TABLE users (name TEXT)`, `INSERT INTO users VALUES ('alice')`}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := db.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	copied, err := OpenSerialized(data)
+	if err != nil {
+		t.Fatalf("OpenSerialized: %v", err)
+	}
+	defer copied.Close()
+	if err := copied.Exec(`INSERT INTO users VALUES ('bob')`); err != nil {
+		t.Fatalf("insert into copy: %v", err)
+	}
+	var n int
+	if err := copied.DB().QueryRow(`SELECT count(*) FROM users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("copy has %d rows, want 2", n)
+	}
+	if err := db.DB().QueryRow(`SELECT count(*) FROM users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("original has %d rows after writing the copy, want 1", n)
+	}
+
+	if _, err := OpenSerialized([]byte("not a database")); err == nil {
+		t.Error("expected error for bytes that are not a database")
+	}
+}
+
 func TestOpenReadOnly(t *testing.T) {
 	// Create a DB file first.
 	tmp := t.TempDir() + "/ro.db"
@@ -179,3 +316,161 @@ func TestQuoteName(t *testing.T) {
 		}
 	}
 }
+
+func TestDumpCopy(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.ExecDDL([]string{
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, author_id INTEGER REFERENCES users (id), body TEXT, data BLOB)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL)`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRecord("users", []string{"id", "name", "score"}, []any{1, "O'Brien", 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRecord("posts", []string{"id", "author_id", "body", "data"}, []any{1, 1, "a\tb\nc\\d", []byte{0xca, 0xfe}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRecord("posts", []string{"id", "author_id", "body", "data"}, []any{2, nil, nil, nil}); err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := db.DumpCopy(&sb, []string{"users", "posts"}); err != nil {
+		t.Fatalf("DumpCopy: %v", err)
+	}
+	want := "BEGIN;\n" +
+		"\\copy \"users\" (\"id\", \"name\", \"score\") FROM stdin\n" +
+		"1\tO'Brien\t1.5\n" +
+		"\\.\n" +
+		"\\copy \"posts\" (\"id\", \"author_id\", \"body\", \"data\") FROM stdin\n" +
+		"1\t1\ta\\tb\\nc\\\\d\t\\\\xcafe\n" +
+		"2\t\\N\t\\N\t\\N\n" +
+		"\\.\n" +
+		"COMMIT;\n"
+	if got := sb.String(); got != want {
+		t.Errorf("DumpCopy =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDumpSQL(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.ExecDDL([]string{
+		`CREATE TABLE t (id INTEGER, name TEXT, score REAL)`,
+		`CREATE INDEX idx_t_name ON t (name)`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRecord("t", []string{"id", "name", "score"}, []any{1, "O'Brien", 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRecord("t", []string{"id", "name", "score"}, []any{2, nil, nil}); err != nil {
+		t.Fatal(err)
+	}
+	for i, score := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		if err := db.InsertRecord("t", []string{"id", "name", "score"}, []any{3 + i, nil, score}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sb strings.Builder
+	if err := db.DumpSQL(&sb); err != nil {
+		t.Fatalf("DumpSQL: %v", err)
+	}
+	dump := sb.String()
+	if got := sqlLiteral(math.NaN()); got != "NULL" {
+		t.Errorf("sqlLiteral(NaN) = %s, want NULL", got)
+	}
+
+	for _, want := range []string{
+		"CREATE TABLE t (id INTEGER, name TEXT, score REAL);",
+		`INSERT INTO "t" ("id", "name", "score") VALUES (1, 'O''Brien', 1.5);`,
+		`INSERT INTO "t" ("id", "name", "score") VALUES (2, NULL, NULL);`,
+		`INSERT INTO "t" ("id", "name", "score") VALUES (3, NULL, 9e999);`,
+		`INSERT INTO "t" ("id", "name", "score") VALUES (4, NULL, -9e999);`,
+		`INSERT INTO "t" ("id", "name", "score") VALUES (5, NULL, NULL);`,
+		"CREATE INDEX idx_t_name ON t (name);",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump missing %q\n%s", want, dump)
+		}
+	}
+
+	// The dump must replay cleanly into a fresh database.
+	replay, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	if err := replay.Exec(dump); err != nil {
+		t.Fatalf("replaying dump: %v", err)
+	}
+	var inf, negInf float64
+	if err := replay.DB().QueryRow("SELECT (SELECT score FROM t WHERE id = 3), (SELECT score FROM t WHERE id = 4)").Scan(&inf, &negInf); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(inf, 1) || !math.IsInf(negInf, -1) {
+		t.Errorf("replayed infinities = %v, %v; want +Inf, -Inf", inf, negInf)
+	}
+	rows, err := replay.Query("SELECT COUNT(*) FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	rows.Next()
+	var count int
+	rows.Scan(&count)
+	if count != 5 {
+		t.Errorf("replayed count = %d, want 5", count)
+	}
+}
+
+func TestUnicodeNoCaseCollation(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.ExecDDL([]string{
+		`CREATE TABLE t (a TEXT COLLATE NOCASE, u TEXT COLLATE UNICODE_NOCASE)`,
+		`INSERT INTO t VALUES ('ÉCOLE', 'ÉCOLE')`,
+	}); err != nil {
+		t.Fatalf("ExecDDL: %v", err)
+	}
+	var ascii, unicode int
+	if err := db.DB().QueryRow(`SELECT count(*) FROM t WHERE a = 'école'`).Scan(&ascii); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB().QueryRow(`SELECT count(*) FROM t WHERE u = 'école'`).Scan(&unicode); err != nil {
+		t.Fatal(err)
+	}
+	if ascii != 0 || unicode != 1 {
+		t.Errorf("matches: NOCASE %d, UNICODE_NOCASE %d; want 0, 1", ascii, unicode)
+	}
+
+	if c, ok := Collation("unicode_nocase"); !ok || c != UnicodeNoCase {
+		t.Errorf("Collation(unicode_nocase) = %q, %v", c, ok)
+	}
+	if _, ok := Collation("klingon"); ok {
+		t.Error("Collation(klingon) reported as known")
+	}
+
+	var sb strings.Builder
+	if err := db.DumpSQL(&sb); err != nil {
+		t.Fatalf("DumpSQL: %v", err)
+	}
+	if want := "CREATE TABLE t (a TEXT COLLATE NOCASE, u TEXT COLLATE NOCASE);"; !strings.Contains(sb.String(), want) {
+		t.Errorf("dump missing %q\n%s", want, sb.String())
+	}
+}
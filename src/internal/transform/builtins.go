@@ -0,0 +1,622 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtin implements a function given its input and unevaluated arguments.
+type builtin func(in any, args []*node, sc *scope) ([]any, error)
+
+// builtins are keyed by name and arity, e.g. "split/1".
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"empty/0": func(any, []*node, *scope) ([]any, error) { return nil, nil },
+		"error/0": func(in any, _ []*node, _ *scope) ([]any, error) { return nil, &valueError{in} },
+		"error/1": withArgs(func(_ any, a []any) (any, error) { return nil, &valueError{a[0]} }),
+		"not/0":   unary(func(v any) (any, error) { return !truthy(v), nil }),
+		"type/0":  unary(func(v any) (any, error) { return typeName(v), nil }),
+		"select/1": func(in any, args []*node, sc *scope) ([]any, error) {
+			conds, err := eval(args[0], in, sc)
+			if err != nil {
+				return nil, err
+			}
+			var out []any
+			for _, c := range conds {
+				if truthy(c) {
+					out = append(out, in)
+				}
+			}
+			return out, nil
+		},
+		"map/1": func(in any, args []*node, sc *scope) ([]any, error) {
+			elems, err := eval(&node{op: opIterate, args: []*node{identity}}, in, sc)
+			if err != nil {
+				return nil, err
+			}
+			out := []any{}
+			for _, e := range elems {
+				vals, err := eval(args[0], e, sc)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, vals...)
+			}
+			return []any{out}, nil
+		},
+		"map_values/1": func(in any, args []*node, sc *scope) ([]any, error) {
+			each := &node{op: opIterate, args: []*node{identity}}
+			return assign(&node{op: opAssign, name: "|=", args: []*node{each, args[0]}}, in, sc)
+		},
+		"del/1": func(in any, args []*node, sc *scope) ([]any, error) {
+			paths, err := getPaths(args[0], in, sc)
+			if err != nil {
+				return nil, err
+			}
+			out, err := delPaths(in, paths)
+			if err != nil {
+				return nil, err
+			}
+			return []any{out}, nil
+		},
+		"with_entries/1": func(in any, args []*node, sc *scope) ([]any, error) {
+			entries, err := toEntries(in)
+			if err != nil {
+				return nil, err
+			}
+			mapped := []any{}
+			for _, e := range entries.([]any) {
+				vals, err := eval(args[0], e, sc)
+				if err != nil {
+					return nil, err
+				}
+				mapped = append(mapped, vals...)
+			}
+			out, err := fromEntries(mapped)
+			if err != nil {
+				return nil, err
+			}
+			return []any{out}, nil
+		},
+		"getpath/1": withArgs(func(in any, a []any) (any, error) {
+			p, ok := a[0].([]any)
+			if !ok {
+				return nil, errors.New("path must be an array")
+			}
+			keys := make([]any, len(p))
+			for i, k := range p {
+				keys[i] = pathKey(k)
+			}
+			v, err := getPath(in, keys)
+			if err != nil {
+				return nil, nil
+			}
+			return v, nil
+		}),
+
+		"length/0": unary(length),
+		"keys/0": unary(func(v any) (any, error) {
+			switch c := v.(type) {
+			case map[string]any:
+				return stringsToAny(sortedKeys(c)), nil
+			case []any:
+				out := make([]any, len(c))
+				for i := range c {
+					out[i] = int64(i)
+				}
+				return out, nil
+			}
+			return nil, fmt.Errorf("%s has no keys", describeValue(v))
+		}),
+		"has/1": withArgs(func(in any, a []any) (any, error) {
+			switch c := in.(type) {
+			case map[string]any:
+				if k, ok := a[0].(string); ok {
+					_, has := c[k]
+					return has, nil
+				}
+			case []any:
+				if _, _, _, ok := number(a[0]); ok {
+					i := pathKey(a[0]).(int)
+					return i >= 0 && i < len(c), nil
+				}
+			}
+			return nil, fmt.Errorf("cannot check whether %s has a key %s", typeName(in), describeValue(a[0]))
+		}),
+		"to_entries/0":   unary(toEntries),
+		"from_entries/0": unary(fromEntries),
+		"add/0": unary(func(v any) (any, error) {
+			elems, err := values(v)
+			if err != nil {
+				return nil, err
+			}
+			var sum any
+			for _, e := range elems {
+				if sum, err = binary("+", sum, e); err != nil {
+					return nil, err
+				}
+			}
+			return sum, nil
+		}),
+		"any/0": unary(func(v any) (any, error) {
+			elems, err := values(v)
+			for _, e := range elems {
+				if truthy(e) {
+					return true, nil
+				}
+			}
+			return false, err
+		}),
+		"all/0": unary(func(v any) (any, error) {
+			elems, err := values(v)
+			for _, e := range elems {
+				if !truthy(e) {
+					return false, nil
+				}
+			}
+			return true, err
+		}),
+		"first/0": unary(func(v any) (any, error) { return index(v, int64(0)) }),
+		"last/0":  unary(func(v any) (any, error) { return index(v, int64(-1)) }),
+		"min/0":   unary(func(v any) (any, error) { return extreme(v, -1) }),
+		"max/0":   unary(func(v any) (any, error) { return extreme(v, 1) }),
+		"sort/0": unary(func(v any) (any, error) {
+			arr, ok := v.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s cannot be sorted, as it is not an array", describeValue(v))
+			}
+			out := append([]any{}, arr...)
+			sort.SliceStable(out, func(i, j int) bool { return compare(out[i], out[j]) < 0 })
+			return out, nil
+		}),
+		"unique/0": unary(func(v any) (any, error) {
+			sorted, err := builtins["sort/0"](v, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			out := []any{}
+			for _, e := range sorted[0].([]any) {
+				if len(out) == 0 || compare(out[len(out)-1], e) != 0 {
+					out = append(out, e)
+				}
+			}
+			return out, nil
+		}),
+		"reverse/0": unary(func(v any) (any, error) {
+			switch c := v.(type) {
+			case nil:
+				return []any{}, nil
+			case string:
+				r := []rune(c)
+				for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+					r[i], r[j] = r[j], r[i]
+				}
+				return string(r), nil
+			case []any:
+				out := make([]any, len(c))
+				for i, e := range c {
+					out[len(c)-1-i] = e
+				}
+				return out, nil
+			}
+			return nil, fmt.Errorf("cannot reverse %s", describeValue(v))
+		}),
+		"contains/1": withArgs(func(in any, a []any) (any, error) {
+			if typeOrder(in) != typeOrder(a[0]) && !(isBool(in) && isBool(a[0])) {
+				return nil, fmt.Errorf("%s and %s cannot have their containment checked", describeValue(in), describeValue(a[0]))
+			}
+			return contains(in, a[0]), nil
+		}),
+
+		"tostring/0": unary(func(v any) (any, error) { return toString(v), nil }),
+		"tojson/0":   unary(func(v any) (any, error) { return toJSON(v), nil }),
+		"fromjson/0": unary(func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s cannot be parsed as JSON", describeValue(v))
+			}
+			var out any
+			dec := json.NewDecoder(strings.NewReader(s))
+			dec.UseNumber()
+			if err := dec.Decode(&out); err != nil {
+				return nil, fmt.Errorf("%s cannot be parsed as JSON: %w", describeValue(v), err)
+			}
+			return fromJSONNumbers(out), nil
+		}),
+		"tonumber/0": unary(func(v any) (any, error) {
+			if _, _, _, ok := number(v); ok {
+				return v, nil
+			}
+			if s, ok := v.(string); ok {
+				s = strings.TrimSpace(s)
+				if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+					return i, nil
+				}
+				if f, err := strconv.ParseFloat(s, 64); err == nil {
+					return f, nil
+				}
+			}
+			return nil, fmt.Errorf("%s cannot be parsed as a number", describeValue(v))
+		}),
+		"ascii_downcase/0": stringFunc(func(s string) any { return asciiMap(s, 'A', 'Z', 'a'-'A') }),
+		"ascii_upcase/0":   stringFunc(func(s string) any { return asciiMap(s, 'a', 'z', 'A'-'a') }),
+		"trim/0":           stringFunc(func(s string) any { return strings.TrimSpace(s) }),
+		"ltrim/0":          stringFunc(func(s string) any { return strings.TrimLeft(s, " \t\n\r\f\v") }),
+		"rtrim/0":          stringFunc(func(s string) any { return strings.TrimRight(s, " \t\n\r\f\v") }),
+		"ltrimstr/1": withArgs(func(in any, a []any) (any, error) {
+			s, sok := in.(string)
+			p, pok := a[0].(string)
+			if sok && pok {
+				return strings.TrimPrefix(s, p), nil
+			}
+			return in, nil
+		}),
+		"rtrimstr/1": withArgs(func(in any, a []any) (any, error) {
+			s, sok := in.(string)
+			p, pok := a[0].(string)
+			if sok && pok {
+				return strings.TrimSuffix(s, p), nil
+			}
+			return in, nil
+		}),
+		"startswith/1": stringPair("startswith", func(s, t string) (any, error) { return strings.HasPrefix(s, t), nil }),
+		"endswith/1":   stringPair("endswith", func(s, t string) (any, error) { return strings.HasSuffix(s, t), nil }),
+		"split/1":      stringPair("split", func(s, t string) (any, error) { return split(s, t), nil }),
+		"test/1": stringPair("test", func(s, re string) (any, error) {
+			r, err := regexp.Compile(re)
+			if err != nil {
+				return nil, err
+			}
+			return r.MatchString(s), nil
+		}),
+		"sub/2":  replace(false),
+		"gsub/2": replace(true),
+		"join/1": withArgs(func(in any, a []any) (any, error) {
+			sep, ok := a[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot join with %s", describeValue(a[0]))
+			}
+			elems, err := values(in)
+			if err != nil {
+				return nil, err
+			}
+			parts := make([]string, len(elems))
+			for i, e := range elems {
+				switch e.(type) {
+				case nil:
+				case []any, map[string]any:
+					return nil, fmt.Errorf("cannot join %s", describeValue(e))
+				default:
+					parts[i] = toString(e)
+				}
+			}
+			return strings.Join(parts, sep), nil
+		}),
+
+		"floor/0": mathFunc(math.Floor),
+		"ceil/0":  mathFunc(math.Ceil),
+		"round/0": mathFunc(math.Round),
+		"abs/0": unary(func(v any) (any, error) {
+			f, i, isInt, ok := number(v)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("%s has no absolute value", describeValue(v))
+			case isInt && i < 0 && i != math.MinInt64:
+				return -i, nil
+			case isInt:
+				return v, nil
+			}
+			return math.Abs(f), nil
+		}),
+	}
+	for name, t := range map[string]string{
+		"nulls": "null", "booleans": "boolean", "numbers": "number",
+		"strings": "string", "arrays": "array", "objects": "object",
+	} {
+		builtins[name+"/0"] = func(in any, _ []*node, _ *scope) ([]any, error) {
+			if typeName(in) == t {
+				return []any{in}, nil
+			}
+			return nil, nil
+		}
+	}
+	builtins["values/0"] = func(in any, _ []*node, _ *scope) ([]any, error) {
+		if in == nil {
+			return nil, nil
+		}
+		return []any{in}, nil
+	}
+}
+
+// unary makes a builtin of a function of the input alone.
+func unary(f func(any) (any, error)) builtin {
+	return func(in any, _ []*node, _ *scope) ([]any, error) {
+		v, err := f(in)
+		if err != nil {
+			return nil, err
+		}
+		return []any{v}, nil
+	}
+}
+
+// withArgs makes a builtin of a function of the input and the values of
+// its arguments, called for every combination of their outputs.
+func withArgs(f func(in any, args []any) (any, error)) builtin {
+	return func(in any, args []*node, sc *scope) ([]any, error) {
+		combos := [][]any{{}}
+		for _, a := range args {
+			vals, err := eval(a, in, sc)
+			if err != nil {
+				return nil, err
+			}
+			var next [][]any
+			for _, c := range combos {
+				for _, v := range vals {
+					next = append(next, append(append([]any(nil), c...), v))
+				}
+			}
+			combos = next
+		}
+		out := make([]any, 0, len(combos))
+		for _, c := range combos {
+			v, err := f(in, c)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+}
+
+func stringFunc(f func(string) any) builtin {
+	return unary(func(v any) (any, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string", describeValue(v))
+		}
+		return f(s), nil
+	})
+}
+
+func stringPair(name string, f func(s, t string) (any, error)) builtin {
+	return withArgs(func(in any, a []any) (any, error) {
+		s, sok := in.(string)
+		t, tok := a[0].(string)
+		if !sok || !tok {
+			return nil, fmt.Errorf("%s requires string inputs, got %s and %s", name, describeValue(in), describeValue(a[0]))
+		}
+		return f(s, t)
+	})
+}
+
+// replace makes sub, which replaces the first match of a regular
+// expression with a string, and gsub, which replaces every match.
+func replace(global bool) builtin {
+	return withArgs(func(in any, a []any) (any, error) {
+		s, sok := in.(string)
+		re, rok := a[0].(string)
+		with, wok := a[1].(string)
+		if !sok || !rok || !wok {
+			return nil, fmt.Errorf("sub and gsub require string inputs, got %s", describeValue(in))
+		}
+		r, err := regexp.Compile(re)
+		if err != nil {
+			return nil, err
+		}
+		if global {
+			return r.ReplaceAllLiteralString(s, with), nil
+		}
+		loc := r.FindStringIndex(s)
+		if loc == nil {
+			return s, nil
+		}
+		return s[:loc[0]] + with + s[loc[1]:], nil
+	})
+}
+
+func mathFunc(f func(float64) float64) builtin {
+	return unary(func(v any) (any, error) {
+		x, i, isInt, ok := number(v)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a number", describeValue(v))
+		}
+		if isInt {
+			return i, nil
+		}
+		r := f(x)
+		if r == math.Trunc(r) && math.Abs(r) < 1<<53 {
+			return int64(r), nil
+		}
+		return r, nil
+	})
+}
+
+func asciiMap(s string, lo, hi byte, shift int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= lo && c <= hi {
+			b[i] = byte(int(c) + shift)
+		}
+	}
+	return string(b)
+}
+
+func length(v any) (any, error) {
+	switch c := v.(type) {
+	case nil:
+		return int64(0), nil
+	case string:
+		return int64(utf8.RuneCountInString(c)), nil
+	case []any:
+		return int64(len(c)), nil
+	case map[string]any:
+		return int64(len(c)), nil
+	}
+	if f, i, isInt, ok := number(v); ok {
+		if isInt {
+			if i < 0 {
+				return -i, nil
+			}
+			return i, nil
+		}
+		return math.Abs(f), nil
+	}
+	return nil, fmt.Errorf("%s has no length", describeValue(v))
+}
+
+// values returns the elements of an array or the values of an object.
+func values(v any) ([]any, error) {
+	switch c := v.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		return c, nil
+	case map[string]any:
+		out := make([]any, 0, len(c))
+		for _, k := range sortedKeys(c) {
+			out = append(out, c[k])
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("cannot iterate over %s", describeValue(v))
+}
+
+func extreme(v any, sign int) (any, error) {
+	arr, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an array", describeValue(v))
+	}
+	var best any
+	for i, e := range arr {
+		if i == 0 || compare(e, best)*sign >= 0 {
+			best = e
+		}
+	}
+	return best, nil
+}
+
+func toEntries(v any) (any, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s has no keys", describeValue(v))
+	}
+	out := make([]any, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		out = append(out, map[string]any{"key": k, "value": m[k]})
+	}
+	return out, nil
+}
+
+func fromEntries(v any) (any, error) {
+	entries, err := values(v)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(entries))
+	for _, e := range entries {
+		m, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot use %s as an object entry", describeValue(e))
+		}
+		var key, val any
+		for _, name := range []string{"key", "k", "name", "Name", "K", "Key"} {
+			if k, ok := m[name]; ok && truthy(k) {
+				key = k
+				break
+			}
+		}
+		for _, name := range []string{"value", "v", "Value", "V"} {
+			if v, ok := m[name]; ok {
+				val = v
+				break
+			}
+		}
+		switch k := key.(type) {
+		case string:
+			out[k] = val
+		case nil:
+			return nil, errors.New("object entry has no key")
+		default:
+			if _, _, _, ok := number(k); !ok && !isBool(k) {
+				return nil, fmt.Errorf("cannot use %s as an object key", describeValue(k))
+			}
+			out[toJSON(k)] = val
+		}
+	}
+	return out, nil
+}
+
+func isBool(v any) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+// contains reports whether b is contained in a: a substring of a string, a
+// subset of an array's elements, or a subset of an object's fields.
+func contains(a, b any) bool {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return ok && strings.Contains(x, y)
+	case []any:
+		y, ok := b.([]any)
+		if !ok {
+			return false
+		}
+	elems:
+		for _, be := range y {
+			for _, ae := range x {
+				if typeOrder(ae) == typeOrder(be) && contains(ae, be) {
+					continue elems
+				}
+			}
+			return false
+		}
+		return true
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, bv := range y {
+			av, ok := x[k]
+			if !ok || !contains(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return compare(a, b) == 0
+}
+
+// fromJSONNumbers replaces the json.Numbers of a decoded value with int64
+// or float64.
+func fromJSONNumbers(v any) any {
+	switch c := v.(type) {
+	case json.Number:
+		if i, err := c.Int64(); err == nil {
+			return i
+		}
+		f, _ := c.Float64()
+		return f
+	case []any:
+		for i, e := range c {
+			c[i] = fromJSONNumbers(e)
+		}
+	case map[string]any:
+		for k, e := range c {
+			c[k] = fromJSONNumbers(e)
+		}
+	}
+	return v
+}
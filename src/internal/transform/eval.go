@@ -0,0 +1,1119 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// A program is evaluated the way jq does: every expression takes an input
+// value and produces a stream of zero or more outputs, here a slice.
+
+// scope holds the variables bound by "as" bindings.
+type scope struct {
+	name string
+	val  any
+	up   *scope
+}
+
+func (s *scope) lookup(name string) any {
+	for ; s != nil; s = s.up {
+		if s.name == name {
+			return s.val
+		}
+	}
+	return nil
+}
+
+// valueError is an error raised by the error builtin; try ... catch gives
+// its value to the handler.
+type valueError struct{ val any }
+
+func (e *valueError) Error() string {
+	if s, ok := e.val.(string); ok {
+		return s
+	}
+	return toJSON(e.val) + " (not a string)"
+}
+
+// errorValue returns the value try ... catch gives its handler for err.
+func errorValue(err error) any {
+	var ve *valueError
+	if errors.As(err, &ve) {
+		return ve.val
+	}
+	return err.Error()
+}
+
+func eval(n *node, in any, sc *scope) ([]any, error) {
+	switch n.op {
+	case opIdentity:
+		return []any{in}, nil
+	case opLiteral:
+		return []any{n.val}, nil
+	case opVar:
+		return []any{sc.lookup(n.name)}, nil
+
+	case opField:
+		targets, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]any, 0, len(targets))
+		for _, t := range targets {
+			v, err := index(t, n.name)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+
+	case opIndex:
+		keys, err := eval(n.args[1], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, k := range keys {
+			for _, t := range targets {
+				v, err := index(t, k)
+				if err != nil {
+					return out, err
+				}
+				out = append(out, v)
+			}
+		}
+		return out, nil
+
+	case opIterate:
+		targets, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, t := range targets {
+			switch v := t.(type) {
+			case []any:
+				out = append(out, v...)
+			case map[string]any:
+				for _, k := range sortedKeys(v) {
+					out = append(out, v[k])
+				}
+			default:
+				return out, fmt.Errorf("cannot iterate over %s", describeValue(t))
+			}
+		}
+		return out, nil
+
+	case opPipe:
+		left, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, l := range left {
+			right, err := eval(n.args[1], l, sc)
+			out = append(out, right...)
+			if err != nil {
+				return out, err
+			}
+		}
+		return out, nil
+
+	case opComma:
+		left, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return left, err
+		}
+		right, err := eval(n.args[1], in, sc)
+		return append(left, right...), err
+
+	case opArray:
+		if len(n.args) == 0 {
+			return []any{[]any{}}, nil
+		}
+		elems, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		if elems == nil {
+			elems = []any{}
+		}
+		return []any{elems}, nil
+
+	case opObject:
+		objs := []map[string]any{{}}
+		for i := 0; i < len(n.args); i += 2 {
+			keys, err := eval(n.args[i], in, sc)
+			if err != nil {
+				return nil, err
+			}
+			vals, err := eval(n.args[i+1], in, sc)
+			if err != nil {
+				return nil, err
+			}
+			var next []map[string]any
+			for _, obj := range objs {
+				for _, k := range keys {
+					ks, ok := k.(string)
+					if !ok {
+						return nil, fmt.Errorf("object keys must be strings, not %s", describeValue(k))
+					}
+					for _, v := range vals {
+						o := make(map[string]any, len(obj)+1)
+						for ok, ov := range obj {
+							o[ok] = ov
+						}
+						o[ks] = v
+						next = append(next, o)
+					}
+				}
+			}
+			objs = next
+		}
+		out := make([]any, len(objs))
+		for i, o := range objs {
+			out[i] = o
+		}
+		return out, nil
+
+	case opString:
+		strs := []string{""}
+		for _, part := range n.args {
+			vals, err := eval(part, in, sc)
+			if err != nil {
+				return nil, err
+			}
+			var next []string
+			for _, s := range strs {
+				for _, v := range vals {
+					next = append(next, s+toString(v))
+				}
+			}
+			strs = next
+		}
+		out := make([]any, len(strs))
+		for i, s := range strs {
+			out[i] = s
+		}
+		return out, nil
+
+	case opNeg:
+		vals, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]any, 0, len(vals))
+		for _, v := range vals {
+			neg, err := binary("-", int64(0), v)
+			if err != nil {
+				return out, fmt.Errorf("%s cannot be negated", describeValue(v))
+			}
+			out = append(out, neg)
+		}
+		return out, nil
+
+	case opBinary:
+		// Like jq, the right operand varies slowest.
+		right, err := eval(n.args[1], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		left, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, r := range right {
+			for _, l := range left {
+				v, err := binary(n.name, l, r)
+				if err != nil {
+					return out, err
+				}
+				out = append(out, v)
+			}
+		}
+		return out, nil
+
+	case opAnd, opOr:
+		left, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, l := range left {
+			if n.op == opAnd && !truthy(l) {
+				out = append(out, false)
+				continue
+			}
+			if n.op == opOr && truthy(l) {
+				out = append(out, true)
+				continue
+			}
+			right, err := eval(n.args[1], in, sc)
+			if err != nil {
+				return out, err
+			}
+			for _, r := range right {
+				out = append(out, truthy(r))
+			}
+		}
+		return out, nil
+
+	case opAlt:
+		// Errors on the left are ignored, as are its false and null outputs.
+		left, _ := eval(n.args[0], in, sc)
+		var out []any
+		for _, l := range left {
+			if truthy(l) {
+				out = append(out, l)
+			}
+		}
+		if len(out) > 0 {
+			return out, nil
+		}
+		return eval(n.args[1], in, sc)
+
+	case opIf:
+		conds, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, c := range conds {
+			branch := n.args[2]
+			if truthy(c) {
+				branch = n.args[1]
+			}
+			vals, err := eval(branch, in, sc)
+			out = append(out, vals...)
+			if err != nil {
+				return out, err
+			}
+		}
+		return out, nil
+
+	case opTry:
+		vals, err := eval(n.args[0], in, sc)
+		if err == nil {
+			return vals, nil
+		}
+		if n.args[1] == nil {
+			return vals, nil
+		}
+		handled, err := eval(n.args[1], errorValue(err), sc)
+		return append(vals, handled...), err
+
+	case opBind:
+		vals, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for _, v := range vals {
+			body, err := eval(n.args[1], in, &scope{n.name, v, sc})
+			out = append(out, body...)
+			if err != nil {
+				return out, err
+			}
+		}
+		return out, nil
+
+	case opAssign:
+		return assign(n, in, sc)
+
+	case opCall:
+		return builtins[fmt.Sprintf("%s/%d", n.name, len(n.args))](in, n.args, sc)
+	}
+	return nil, fmt.Errorf("unknown expression")
+}
+
+// assign evaluates the assignment n: "=" sets the paths on the left to each
+// output of the right, "|=" updates each path with the first output of the
+// right applied to its value (deleting it when there is none), and "+=" and
+// the like combine each path's value with each output of the right.
+func assign(n *node, in any, sc *scope) ([]any, error) {
+	paths, err := getPaths(n.args[0], in, sc)
+	if err != nil {
+		return nil, err
+	}
+	if n.name == "|=" {
+		out := in
+		var deleted [][]any
+		for _, p := range paths {
+			old, err := getPath(out, p)
+			if err != nil {
+				return nil, err
+			}
+			vals, err := eval(n.args[1], old, sc)
+			if err != nil {
+				return nil, err
+			}
+			if len(vals) == 0 {
+				deleted = append(deleted, p)
+				continue
+			}
+			if out, err = setPath(out, p, vals[0]); err != nil {
+				return nil, err
+			}
+		}
+		out, err := delPaths(out, deleted)
+		if err != nil {
+			return nil, err
+		}
+		return []any{out}, nil
+	}
+
+	vals, err := eval(n.args[1], in, sc)
+	if err != nil {
+		return nil, err
+	}
+	var outs []any
+	for _, v := range vals {
+		out := in
+		for _, p := range paths {
+			nv := v
+			if n.name != "=" {
+				old, err := getPath(out, p)
+				if err != nil {
+					return nil, err
+				}
+				if n.name == "//=" {
+					if truthy(old) {
+						nv = old
+					}
+				} else if nv, err = binary(strings.TrimSuffix(n.name, "="), old, v); err != nil {
+					return nil, err
+				}
+			}
+			if out, err = setPath(out, p, nv); err != nil {
+				return nil, err
+			}
+		}
+		outs = append(outs, out)
+	}
+	return outs, nil
+}
+
+// getPaths returns the paths into in of the values n produces, for the
+// expressions that can be assigned to or deleted: ., .name, .[k], .[], and
+// pipes, alternatives, conditions and selections of them.
+func getPaths(n *node, in any, sc *scope) ([][]any, error) {
+	switch n.op {
+	case opIdentity:
+		return [][]any{{}}, nil
+
+	case opField, opIndex:
+		keys := []any{n.name}
+		if n.op == opIndex {
+			var err error
+			if keys, err = eval(n.args[1], in, sc); err != nil {
+				return nil, err
+			}
+		}
+		prefixes, err := getPaths(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out [][]any
+		for _, k := range keys {
+			for _, p := range prefixes {
+				v, err := getPath(in, p)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := index(v, k); err != nil {
+					return nil, err
+				}
+				out = append(out, appendPath(p, pathKey(k)))
+			}
+		}
+		return out, nil
+
+	case opIterate:
+		prefixes, err := getPaths(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out [][]any
+		for _, p := range prefixes {
+			v, err := getPath(in, p)
+			if err != nil {
+				return nil, err
+			}
+			switch c := v.(type) {
+			case []any:
+				for i := range c {
+					out = append(out, appendPath(p, i))
+				}
+			case map[string]any:
+				for _, k := range sortedKeys(c) {
+					out = append(out, appendPath(p, k))
+				}
+			case nil:
+			default:
+				return nil, fmt.Errorf("cannot iterate over %s", describeValue(v))
+			}
+		}
+		return out, nil
+
+	case opPipe:
+		prefixes, err := getPaths(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out [][]any
+		for _, p := range prefixes {
+			v, err := getPath(in, p)
+			if err != nil {
+				return nil, err
+			}
+			rest, err := getPaths(n.args[1], v, sc)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range rest {
+				out = append(out, append(appendPath(p), r...))
+			}
+		}
+		return out, nil
+
+	case opComma:
+		left, err := getPaths(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		right, err := getPaths(n.args[1], in, sc)
+		return append(left, right...), err
+
+	case opAlt:
+		left, _ := getPaths(n.args[0], in, sc)
+		var out [][]any
+		for _, p := range left {
+			if v, err := getPath(in, p); err == nil && truthy(v) {
+				out = append(out, p)
+			}
+		}
+		if len(out) > 0 {
+			return out, nil
+		}
+		return getPaths(n.args[1], in, sc)
+
+	case opIf:
+		conds, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out [][]any
+		for _, c := range conds {
+			branch := n.args[2]
+			if truthy(c) {
+				branch = n.args[1]
+			}
+			paths, err := getPaths(branch, in, sc)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, paths...)
+		}
+		return out, nil
+
+	case opTry:
+		paths, err := getPaths(n.args[0], in, sc)
+		if err != nil && n.args[1] != nil {
+			return nil, err
+		}
+		return paths, nil
+
+	case opBind:
+		vals, err := eval(n.args[0], in, sc)
+		if err != nil {
+			return nil, err
+		}
+		var out [][]any
+		for _, v := range vals {
+			paths, err := getPaths(n.args[1], in, &scope{n.name, v, sc})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, paths...)
+		}
+		return out, nil
+
+	case opCall:
+		switch fmt.Sprintf("%s/%d", n.name, len(n.args)) {
+		case "empty/0":
+			return nil, nil
+		case "error/0", "error/1":
+			_, err := eval(n, in, sc)
+			return nil, err
+		case "select/1":
+			conds, err := eval(n.args[0], in, sc)
+			if err != nil {
+				return nil, err
+			}
+			var out [][]any
+			for _, c := range conds {
+				if truthy(c) {
+					out = append(out, []any{})
+				}
+			}
+			return out, nil
+		case "first/0":
+			return [][]any{{0}}, nil
+		case "last/0":
+			return [][]any{{-1}}, nil
+		case "getpath/1":
+			vals, err := eval(n.args[0], in, sc)
+			if err != nil {
+				return nil, err
+			}
+			var out [][]any
+			for _, v := range vals {
+				p, ok := v.([]any)
+				if !ok {
+					return nil, errors.New("path must be an array")
+				}
+				out = append(out, p)
+			}
+			return out, nil
+		}
+	}
+	return nil, errors.New("invalid path expression: only paths such as .name, .[0], .[] and their pipes can be assigned or deleted")
+}
+
+func appendPath(p []any, keys ...any) []any {
+	out := make([]any, len(p), len(p)+len(keys))
+	copy(out, p)
+	return append(out, keys...)
+}
+
+// pathKey normalizes an index to a path element: a string, or an int for
+// numbers.
+func pathKey(k any) any {
+	if f, i, isInt, ok := number(k); ok {
+		if isInt {
+			return int(i)
+		}
+		return int(math.Floor(f))
+	}
+	return k
+}
+
+// index returns v[k].
+func index(v, k any) (any, error) {
+	switch key := k.(type) {
+	case string:
+		switch c := v.(type) {
+		case nil:
+			return nil, nil
+		case map[string]any:
+			return c[key], nil
+		}
+	default:
+		if _, _, _, ok := number(k); !ok {
+			break
+		}
+		i := pathKey(k).(int)
+		switch c := v.(type) {
+		case nil:
+			return nil, nil
+		case []any:
+			if i < 0 {
+				i += len(c)
+			}
+			if i < 0 || i >= len(c) {
+				return nil, nil
+			}
+			return c[i], nil
+		}
+	}
+	if key, ok := k.(string); ok {
+		return nil, fmt.Errorf("cannot index %s with %q", typeName(v), key)
+	}
+	return nil, fmt.Errorf("cannot index %s with %s", typeName(v), typeName(k))
+}
+
+func getPath(v any, p []any) (any, error) {
+	for _, k := range p {
+		var err error
+		if v, err = index(v, k); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// setPath returns a copy of v with the value at p set to x. v itself is
+// not modified.
+func setPath(v any, p []any, x any) (any, error) {
+	if len(p) == 0 {
+		return x, nil
+	}
+	switch k := p[0].(type) {
+	case string:
+		var m map[string]any
+		switch c := v.(type) {
+		case nil:
+			m = map[string]any{}
+		case map[string]any:
+			m = make(map[string]any, len(c)+1)
+			for ck, cv := range c {
+				m[ck] = cv
+			}
+		default:
+			return nil, fmt.Errorf("cannot index %s with %q", typeName(v), k)
+		}
+		child, err := setPath(m[k], p[1:], x)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = child
+		return m, nil
+	case int:
+		var arr []any
+		switch c := v.(type) {
+		case nil:
+		case []any:
+			arr = append([]any(nil), c...)
+		default:
+			return nil, fmt.Errorf("cannot index %s with number", typeName(v))
+		}
+		if k < 0 {
+			k += len(arr)
+			if k < 0 {
+				return nil, errors.New("out of bounds negative array index")
+			}
+		}
+		for len(arr) <= k {
+			arr = append(arr, nil)
+		}
+		child, err := setPath(arr[k], p[1:], x)
+		if err != nil {
+			return nil, err
+		}
+		arr[k] = child
+		return arr, nil
+	}
+	return nil, fmt.Errorf("invalid path element %s", describeValue(p[0]))
+}
+
+// delPaths returns a copy of v without the values at paths. Later array
+// elements are deleted first, so that the indexes of the others hold.
+func delPaths(v any, paths [][]any) (any, error) {
+	paths = append([][]any(nil), paths...)
+	sort.Slice(paths, func(i, j int) bool {
+		return compare(paths[i], paths[j]) > 0
+	})
+	for _, p := range paths {
+		var err error
+		if v, err = delPath(v, p); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func delPath(v any, p []any) (any, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch c := v.(type) {
+	case map[string]any:
+		k, ok := p[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot delete number field of object")
+		}
+		if _, ok := c[k]; !ok {
+			return v, nil
+		}
+		m := make(map[string]any, len(c))
+		for ck, cv := range c {
+			m[ck] = cv
+		}
+		if len(p) == 1 {
+			delete(m, k)
+			return m, nil
+		}
+		child, err := delPath(c[k], p[1:])
+		if err != nil {
+			return nil, err
+		}
+		m[k] = child
+		return m, nil
+	case []any:
+		k, ok := p[0].(int)
+		if !ok {
+			return nil, fmt.Errorf("cannot delete field of array")
+		}
+		if k < 0 {
+			k += len(c)
+		}
+		if k < 0 || k >= len(c) {
+			return v, nil
+		}
+		if len(p) == 1 {
+			return append(append([]any(nil), c[:k]...), c[k+1:]...), nil
+		}
+		child, err := delPath(c[k], p[1:])
+		if err != nil {
+			return nil, err
+		}
+		arr := append([]any(nil), c...)
+		arr[k] = child
+		return arr, nil
+	}
+	return nil, fmt.Errorf("cannot delete fields of %s", typeName(v))
+}
+
+func truthy(v any) bool {
+	return v != nil && v != false
+}
+
+// number returns v as a float64, and as an int64 when it is an integer
+// type, reporting whether v is a number at all.
+func number(v any) (f float64, i int64, isInt bool, ok bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), int64(n), true, true
+	case int8:
+		return float64(n), int64(n), true, true
+	case int16:
+		return float64(n), int64(n), true, true
+	case int32:
+		return float64(n), int64(n), true, true
+	case int64:
+		return float64(n), n, true, true
+	case uint:
+		return number(uint64(n))
+	case uint8:
+		return float64(n), int64(n), true, true
+	case uint16:
+		return float64(n), int64(n), true, true
+	case uint32:
+		return float64(n), int64(n), true, true
+	case uint64:
+		if n > math.MaxInt64 {
+			return float64(n), 0, false, true
+		}
+		return float64(n), int64(n), true, true
+	case float32:
+		return float64(n), 0, false, true
+	case float64:
+		return n, 0, false, true
+	}
+	return 0, 0, false, false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	if _, _, _, ok := number(v); ok {
+		return "number"
+	}
+	return "opaque"
+}
+
+// describeValue names v's type and shows its start, as jq's errors do.
+func describeValue(v any) string {
+	s := toJSON(v)
+	if len(s) > 30 {
+		s = s[:27] + "..."
+	}
+	return fmt.Sprintf("%s (%s)", typeName(v), s)
+}
+
+// typeOrder ranks the types the way jq sorts them.
+func typeOrder(v any) int {
+	switch b := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if b {
+			return 2
+		}
+		return 1
+	case string:
+		return 4
+	case []any:
+		return 5
+	case map[string]any:
+		return 6
+	}
+	if _, _, _, ok := number(v); ok {
+		return 3
+	}
+	return 7
+}
+
+// compare orders a and b as jq does: null, false, true, numbers, strings,
+// arrays, then objects.
+func compare(a, b any) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return cmpInt(ta, tb)
+	}
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case []any:
+		y := b.([]any)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case map[string]any:
+		y := b.(map[string]any)
+		kx, ky := sortedKeys(x), sortedKeys(y)
+		if c := compare(stringsToAny(kx), stringsToAny(ky)); c != 0 {
+			return c
+		}
+		for _, k := range kx {
+			if c := compare(x[k], y[k]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	if ta == 3 {
+		fa, ia, inta, _ := number(a)
+		fb, ib, intb, _ := number(b)
+		if inta && intb {
+			return cmpInt(ia, ib)
+		}
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	if ta == 7 {
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+	return 0
+}
+
+func cmpInt[T int | int64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringsToAny(ss []string) []any {
+	out := make([]any, len(ss))
+	for i, s := range ss {
+		out[i] = s
+	}
+	return out
+}
+
+// binary applies the arithmetic or comparison operator op.
+func binary(op string, l, r any) (any, error) {
+	switch op {
+	case "==":
+		return compare(l, r) == 0, nil
+	case "!=":
+		return compare(l, r) != 0, nil
+	case "<":
+		return compare(l, r) < 0, nil
+	case "<=":
+		return compare(l, r) <= 0, nil
+	case ">":
+		return compare(l, r) > 0, nil
+	case ">=":
+		return compare(l, r) >= 0, nil
+	}
+
+	lf, li, lint, lnum := number(l)
+	rf, ri, rint, rnum := number(r)
+	if lnum && rnum {
+		return arithmetic(op, lf, li, lint, rf, ri, rint)
+	}
+	switch op {
+	case "+":
+		switch {
+		case l == nil:
+			return r, nil
+		case r == nil:
+			return l, nil
+		}
+		switch x := l.(type) {
+		case string:
+			if y, ok := r.(string); ok {
+				return x + y, nil
+			}
+		case []any:
+			if y, ok := r.([]any); ok {
+				return append(append([]any(nil), x...), y...), nil
+			}
+		case map[string]any:
+			if y, ok := r.(map[string]any); ok {
+				m := make(map[string]any, len(x)+len(y))
+				for k, v := range x {
+					m[k] = v
+				}
+				for k, v := range y {
+					m[k] = v
+				}
+				return m, nil
+			}
+		}
+	case "-":
+		if x, ok := l.([]any); ok {
+			if y, ok := r.([]any); ok {
+				out := []any{}
+			elems:
+				for _, e := range x {
+					for _, d := range y {
+						if compare(e, d) == 0 {
+							continue elems
+						}
+					}
+					out = append(out, e)
+				}
+				return out, nil
+			}
+		}
+	case "*":
+		x, xok := l.(map[string]any)
+		y, yok := r.(map[string]any)
+		if xok && yok {
+			return deepMerge(x, y), nil
+		}
+	case "/":
+		x, xok := l.(string)
+		y, yok := r.(string)
+		if xok && yok {
+			return split(x, y), nil
+		}
+	}
+	verbs := map[string]string{"+": "added", "-": "subtracted", "*": "multiplied", "/": "divided", "%": "divided"}
+	return nil, fmt.Errorf("%s and %s cannot be %s", describeValue(l), describeValue(r), verbs[op])
+}
+
+func arithmetic(op string, lf float64, li int64, lint bool, rf float64, ri int64, rint bool) (any, error) {
+	ints := lint && rint
+	switch op {
+	case "+":
+		if ints && (ri > 0 && li <= math.MaxInt64-ri || ri <= 0 && li >= math.MinInt64-ri) {
+			return li + ri, nil
+		}
+		return lf + rf, nil
+	case "-":
+		if ints && (ri < 0 && li <= math.MaxInt64+ri || ri >= 0 && li >= math.MinInt64+ri) {
+			return li - ri, nil
+		}
+		return lf - rf, nil
+	case "*":
+		if ints && (li == 0 || (li*ri)/li == ri && !(li == -1 && ri == math.MinInt64)) {
+			return li * ri, nil
+		}
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, errors.New("cannot divide by zero")
+		}
+		if ints && li%ri == 0 && !(li == math.MinInt64 && ri == -1) {
+			return li / ri, nil
+		}
+		return lf / rf, nil
+	case "%":
+		a, b := li, ri
+		if !lint {
+			a = int64(lf)
+		}
+		if !rint {
+			b = int64(rf)
+		}
+		if b == 0 {
+			return nil, errors.New("cannot divide by zero")
+		}
+		if b == -1 {
+			return int64(0), nil
+		}
+		return a % b, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func deepMerge(x, y map[string]any) map[string]any {
+	m := make(map[string]any, len(x)+len(y))
+	for k, v := range x {
+		m[k] = v
+	}
+	for k, v := range y {
+		xm, xok := m[k].(map[string]any)
+		ym, yok := v.(map[string]any)
+		if xok && yok {
+			m[k] = deepMerge(xm, ym)
+		} else {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func split(s, sep string) []any {
+	if s == "" {
+		return []any{}
+	}
+	return stringsToAny(strings.Split(s, sep))
+}
+
+// toJSON encodes v as JSON, with object keys sorted.
+func toJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// toString returns strings as they are and everything else as JSON.
+func toString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return toJSON(v)
+}
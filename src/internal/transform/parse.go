@@ -0,0 +1,801 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokIdent            // a name or keyword
+	tokField            // .name
+	tokVar              // $name
+	tokNumber           // a number literal; val holds int64 or float64
+	tokString           // a string literal; parts holds its text and interpolations
+	tokPunct            // an operator or punctuation; text holds it
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	val   any
+	parts []strPart
+	pos   int
+}
+
+// strPart is a piece of a string literal: literal text, or the source of an
+// interpolated \(...) expression starting at pos.
+type strPart struct {
+	text   string
+	expr   bool
+	pos    int
+	endPos int
+}
+
+// puncts lists the operators longest first, so that "//=" is not read as
+// "//" followed by "=".
+var puncts = []string{
+	"//=", "|=", "+=", "-=", "*=", "/=", "%=", "==", "!=", "<=", ">=", "//",
+	"|", ",", ".", "[", "]", "{", "}", "(", ")", ":", ";", "=", "<", ">",
+	"+", "-", "*", "/", "%", "?",
+}
+
+type syntaxError struct {
+	src string
+	pos int
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	line, col := 1, 1
+	for _, r := range e.src[:min(e.pos, len(e.src))] {
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	if strings.Contains(e.src, "\n") {
+		return fmt.Sprintf("line %d, column %d: %s", line, col, e.msg)
+	}
+	return fmt.Sprintf("column %d: %s", col, e.msg)
+}
+
+// lex splits src[start:end] into tokens.
+func lex(src string, start, end int) ([]token, error) {
+	var toks []token
+	fail := func(pos int, format string, args ...any) ([]token, error) {
+		return nil, &syntaxError{src, pos, fmt.Sprintf(format, args...)}
+	}
+	for i := start; i < end; {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#':
+			for i < end && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			parts, next, err := lexString(src, i, end)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tokString, parts: parts, pos: i})
+			i = next
+		case isDigit(c) || c == '.' && i+1 < end && isDigit(src[i+1]):
+			j := i
+			for j < end && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			if j < end && (src[j] == 'e' || src[j] == 'E') {
+				j++
+				if j < end && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				for j < end && isDigit(src[j]) {
+					j++
+				}
+			}
+			text := src[i:j]
+			var val any
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				val = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				val = f
+			} else {
+				return fail(i, "invalid number %q", text)
+			}
+			toks = append(toks, token{kind: tokNumber, text: text, val: val, pos: i})
+			i = j
+		case c == '.' && i+1 < end && isIdentStart(src[i+1]):
+			j := identEnd(src, i+1, end)
+			toks = append(toks, token{kind: tokField, text: src[i+1 : j], pos: i})
+			i = j
+		case c == '$':
+			if i+1 >= end || !isIdentStart(src[i+1]) {
+				return fail(i, "expected a variable name after $")
+			}
+			j := identEnd(src, i+1, end)
+			toks = append(toks, token{kind: tokVar, text: src[i+1 : j], pos: i})
+			i = j
+		case isIdentStart(c):
+			j := identEnd(src, i, end)
+			toks = append(toks, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			matched := false
+			for _, p := range puncts {
+				if strings.HasPrefix(src[i:end], p) {
+					toks = append(toks, token{kind: tokPunct, text: p, pos: i})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				r, _ := utf8.DecodeRuneInString(src[i:end])
+				return fail(i, "unexpected character %q", r)
+			}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: end}), nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func identEnd(src string, i, end int) int {
+	for i < end && (isIdentStart(src[i]) || isDigit(src[i])) {
+		i++
+	}
+	return i
+}
+
+// lexString reads the string literal starting at the quote at src[i] and
+// returns its parts and the position after its closing quote.
+func lexString(src string, i, end int) ([]strPart, int, error) {
+	start := i
+	var parts []strPart
+	var b strings.Builder
+	for i++; i < end; {
+		c := src[i]
+		switch c {
+		case '"':
+			if b.Len() > 0 || len(parts) == 0 {
+				parts = append(parts, strPart{text: b.String()})
+			}
+			return parts, i + 1, nil
+		case '\\':
+			if i+1 >= end {
+				return nil, 0, &syntaxError{src, i, "unterminated string"}
+			}
+			esc := src[i+1]
+			i += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 > end {
+					return nil, 0, &syntaxError{src, i - 2, "invalid \\u escape"}
+				}
+				n, err := strconv.ParseUint(src[i:i+4], 16, 32)
+				if err != nil {
+					return nil, 0, &syntaxError{src, i - 2, "invalid \\u escape"}
+				}
+				b.WriteRune(rune(n))
+				i += 4
+			case '(':
+				close, err := matchParen(src, i, end)
+				if err != nil {
+					return nil, 0, err
+				}
+				if b.Len() > 0 {
+					parts = append(parts, strPart{text: b.String()})
+					b.Reset()
+				}
+				parts = append(parts, strPart{expr: true, pos: i, endPos: close})
+				i = close + 1
+			default:
+				return nil, 0, &syntaxError{src, i - 2, fmt.Sprintf("invalid escape \\%c", esc)}
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return nil, 0, &syntaxError{src, start, "unterminated string"}
+}
+
+// matchParen returns the position of the ")" closing the expression that
+// starts at src[i], skipping parentheses inside nested string literals.
+func matchParen(src string, i, end int) (int, error) {
+	start := i
+	depth := 1
+	for i < end {
+		switch src[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		case '"':
+			_, next, err := lexString(src, i, end)
+			if err != nil {
+				return 0, err
+			}
+			i = next
+			continue
+		}
+		i++
+	}
+	return 0, &syntaxError{src, start - 2, "unterminated \\( in string"}
+}
+
+type op int
+
+const (
+	opIdentity op = iota
+	opLiteral     // val
+	opField       // args[0].name
+	opIndex       // args[0][args[1]]
+	opIterate     // args[0][]
+	opString      // args joined; literal parts are opLiteral strings
+	opArray       // [args[0]], or [] with no args
+	opObject      // args are key, value pairs
+	opPipe        // args[0] | args[1]
+	opComma       // args[0], args[1]
+	opAlt         // args[0] // args[1]
+	opAssign      // args[0] name args[1], name being "=", "|=", "+=" ...
+	opOr          // args[0] or args[1]
+	opAnd         // args[0] and args[1]
+	opBinary      // args[0] name args[1], name being "+", "==" ...
+	opNeg         // -args[0]
+	opIf          // if args[0] then args[1] else args[2] end
+	opTry         // try args[0] catch args[1]; args[1] is nil without catch
+	opVar         // $name
+	opBind        // args[0] as $name | args[1]
+	opCall        // name(args...)
+)
+
+type node struct {
+	op   op
+	name string
+	val  any
+	args []*node
+	pos  int
+}
+
+var identity = &node{op: opIdentity}
+
+type parser struct {
+	src  string
+	toks []token
+	i    int
+	vars []string // the variables in scope
+}
+
+// parse parses src[start:end] as a program.
+func parse(src string, start, end int, vars []string) (*node, error) {
+	toks, err := lex(src, start, end)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks, vars: vars}
+	if p.peek().kind == tokEOF {
+		return identity, nil
+	}
+	n, err := p.pipe()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuation or keyword text.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokIdent) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return &syntaxError{p.src, t.pos, fmt.Sprintf("expected %q, found the end of the program", text)}
+		}
+		return &syntaxError{p.src, t.pos, fmt.Sprintf("expected %q, found %s", text, describe(t))}
+	}
+	return nil
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokEOF {
+		return &syntaxError{p.src, t.pos, "unexpected end of the program"}
+	}
+	return &syntaxError{p.src, t.pos, "unexpected " + describe(t)}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokField:
+		return "." + t.text
+	case tokVar:
+		return "$" + t.text
+	case tokString:
+		return "string"
+	}
+	return strconv.Quote(t.text)
+}
+
+var keywords = map[string]bool{
+	"if": true, "then": true, "elif": true, "else": true, "end": true,
+	"and": true, "or": true, "as": true, "try": true, "catch": true,
+}
+
+// The grammar follows jq's precedence, lowest first: "|", ",", "//", the
+// assignments, "or", "and", comparisons, "+" and "-", then "*", "/" and "%".
+
+func (p *parser) pipe() (*node, error) {
+	l, err := p.comma()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); p.accept("|") {
+		r, err := p.pipe()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: opPipe, args: []*node{l, r}, pos: t.pos}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) comma() (*node, error) {
+	l, err := p.alt()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(",") {
+		t := p.next()
+		r, err := p.alt()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: opComma, args: []*node{l, r}, pos: t.pos}
+	}
+	return l, nil
+}
+
+func (p *parser) alt() (*node, error) {
+	l, err := p.assign()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); p.accept("//") {
+		r, err := p.alt()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: opAlt, args: []*node{l, r}, pos: t.pos}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) assign() (*node, error) {
+	l, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range []string{"=", "|=", "+=", "-=", "*=", "/=", "%=", "//="} {
+		if t := p.peek(); p.accept(a) {
+			r, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return &node{op: opAssign, name: a, args: []*node{l, r}, pos: t.pos}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) or() (*node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("or") {
+		t := p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: opOr, args: []*node{l, r}, pos: t.pos}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (*node, error) {
+	l, err := p.compare()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("and") {
+		t := p.next()
+		r, err := p.compare()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: opAnd, args: []*node{l, r}, pos: t.pos}
+	}
+	return l, nil
+}
+
+func (p *parser) compare() (*node, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if t := p.peek(); p.accept(c) {
+			r, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			return &node{op: opBinary, name: c, args: []*node{l, r}, pos: t.pos}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) additive() (*node, error) {
+	l, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+") || p.is("-") {
+		t := p.next()
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: opBinary, name: t.text, args: []*node{l, r}, pos: t.pos}
+	}
+	return l, nil
+}
+
+func (p *parser) multiplicative() (*node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*") || p.is("/") || p.is("%") {
+		t := p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: opBinary, name: t.text, args: []*node{l, r}, pos: t.pos}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (*node, error) {
+	if t := p.peek(); p.accept("-") {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: opNeg, args: []*node{n}, pos: t.pos}, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses a term with its suffixes, and a binding of it to a
+// variable for the rest of the pipeline.
+func (p *parser) postfix() (*node, error) {
+	n, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokField:
+			p.next()
+			n = &node{op: opField, name: t.text, args: []*node{n}, pos: t.pos}
+		case p.is(".") && p.toks[p.i+1].kind == tokString:
+			p.next()
+			key, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			n = &node{op: opIndex, args: []*node{n, key}, pos: t.pos}
+		case p.is(".") && p.toks[p.i+1].kind == tokPunct && p.toks[p.i+1].text == "[":
+			p.next()
+		case p.is("["):
+			p.next()
+			if p.accept("]") {
+				n = &node{op: opIterate, args: []*node{n}, pos: t.pos}
+				continue
+			}
+			idx, err := p.pipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &node{op: opIndex, args: []*node{n, idx}, pos: t.pos}
+		case p.is("?"):
+			p.next()
+			n = &node{op: opTry, args: []*node{n, nil}, pos: t.pos}
+		case p.is("as"):
+			p.next()
+			v := p.next()
+			if v.kind != tokVar {
+				return nil, &syntaxError{p.src, v.pos, "expected a variable after as"}
+			}
+			if err := p.expect("|"); err != nil {
+				return nil, err
+			}
+			p.vars = append(p.vars, v.text)
+			body, err := p.pipe()
+			p.vars = p.vars[:len(p.vars)-1]
+			if err != nil {
+				return nil, err
+			}
+			return &node{op: opBind, name: v.text, args: []*node{n, body}, pos: t.pos}, nil
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) term() (*node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &node{op: opLiteral, val: t.val, pos: t.pos}, nil
+	case tokString:
+		return p.str(t)
+	case tokField:
+		return &node{op: opField, name: t.text, args: []*node{identity}, pos: t.pos}, nil
+	case tokVar:
+		if !p.bound(t.text) {
+			return nil, &syntaxError{p.src, t.pos, fmt.Sprintf("$%s is not defined", t.text)}
+		}
+		return &node{op: opVar, name: t.text, pos: t.pos}, nil
+	case tokIdent:
+		switch t.text {
+		case "null":
+			return &node{op: opLiteral, pos: t.pos}, nil
+		case "true", "false":
+			return &node{op: opLiteral, val: t.text == "true", pos: t.pos}, nil
+		case "if":
+			return p.ifThen(t)
+		case "try":
+			body, err := p.postfix()
+			if err != nil {
+				return nil, err
+			}
+			var handler *node
+			if p.accept("catch") {
+				if handler, err = p.postfix(); err != nil {
+					return nil, err
+				}
+			}
+			return &node{op: opTry, args: []*node{body, handler}, pos: t.pos}, nil
+		}
+		if keywords[t.text] {
+			return nil, p.unexpected(t)
+		}
+		return p.call(t)
+	case tokPunct:
+		switch t.text {
+		case ".":
+			if s := p.peek(); s.kind == tokString {
+				p.next()
+				key, err := p.str(s)
+				if err != nil {
+					return nil, err
+				}
+				return &node{op: opIndex, args: []*node{identity, key}, pos: t.pos}, nil
+			}
+			return identity, nil
+		case "(":
+			n, err := p.pipe()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			if p.accept("]") {
+				return &node{op: opArray, pos: t.pos}, nil
+			}
+			n, err := p.pipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return &node{op: opArray, args: []*node{n}, pos: t.pos}, nil
+		case "{":
+			return p.object(t)
+		}
+	}
+	return nil, p.unexpected(t)
+}
+
+func (p *parser) bound(name string) bool {
+	for _, v := range p.vars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// str returns the node of a string literal, parsing its interpolations.
+func (p *parser) str(t token) (*node, error) {
+	if len(t.parts) == 1 && !t.parts[0].expr {
+		return &node{op: opLiteral, val: t.parts[0].text, pos: t.pos}, nil
+	}
+	n := &node{op: opString, pos: t.pos}
+	for _, part := range t.parts {
+		if !part.expr {
+			n.args = append(n.args, &node{op: opLiteral, val: part.text})
+			continue
+		}
+		e, err := parse(p.src, part.pos, part.endPos, p.vars)
+		if err != nil {
+			return nil, err
+		}
+		n.args = append(n.args, e)
+	}
+	return n, nil
+}
+
+func (p *parser) ifThen(t token) (*node, error) {
+	cond, err := p.pipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("then"); err != nil {
+		return nil, err
+	}
+	then, err := p.pipe()
+	if err != nil {
+		return nil, err
+	}
+	n := &node{op: opIf, args: []*node{cond, then, identity}, pos: t.pos}
+	switch e := p.peek(); {
+	case p.accept("elif"):
+		if n.args[2], err = p.ifThen(e); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case p.accept("else"):
+		if n.args[2], err = p.pipe(); err != nil {
+			return nil, err
+		}
+	}
+	return n, p.expect("end")
+}
+
+func (p *parser) object(t token) (*node, error) {
+	n := &node{op: opObject, pos: t.pos}
+	for !p.accept("}") {
+		if len(n.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		k := p.next()
+		var key, val *node
+		switch {
+		case k.kind == tokIdent:
+			key = &node{op: opLiteral, val: k.text, pos: k.pos}
+		case k.kind == tokVar:
+			if !p.bound(k.text) {
+				return nil, &syntaxError{p.src, k.pos, fmt.Sprintf("$%s is not defined", k.text)}
+			}
+			key = &node{op: opLiteral, val: k.text, pos: k.pos}
+			val = &node{op: opVar, name: k.text, pos: k.pos}
+		case k.kind == tokString:
+			var err error
+			if key, err = p.str(k); err != nil {
+				return nil, err
+			}
+		case k.kind == tokPunct && k.text == "(":
+			var err error
+			if key, err = p.pipe(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected(k)
+		}
+		if val == nil {
+			if p.accept(":") {
+				var err error
+				if val, err = p.alt(); err != nil {
+					return nil, err
+				}
+			} else if k.kind == tokIdent || k.kind == tokString && key.op == opLiteral {
+				// {name} is short for {name: .name}.
+				val = &node{op: opIndex, args: []*node{identity, key}, pos: k.pos}
+			} else {
+				return nil, p.expect(":")
+			}
+		}
+		n.args = append(n.args, key, val)
+	}
+	return n, nil
+}
+
+func (p *parser) call(t token) (*node, error) {
+	n := &node{op: opCall, name: t.text, pos: t.pos}
+	if p.accept("(") {
+		for {
+			arg, err := p.pipe()
+			if err != nil {
+				return nil, err
+			}
+			n.args = append(n.args, arg)
+			if !p.accept(";") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := builtins[fmt.Sprintf("%s/%d", n.name, len(n.args))]; !ok {
+		return nil, &syntaxError{p.src, t.pos, fmt.Sprintf("%s/%d is not defined", n.name, len(n.args))}
+	}
+	return n, nil
+}
@@ -0,0 +1,62 @@
+// Package transform runs the record transformations of sqlfs.yaml: small jq
+// programs that reshape each record of a table before it is validated.
+//
+// It implements the part of jq's language that reshaping a record needs,
+// with jq's semantics: paths (.a, .a.b, ."a b", .[0], .[]), literals and
+// string interpolation, array and object construction, pipes and commas,
+// arithmetic, comparisons, and, or and //, the assignments =, |=, += and
+// the like, if-then-elif-else, try-catch and ?, "as $name" bindings, and
+// the common builtins (del, select, map, with_entries, tostring, split,
+// test, sub and so on). Function definitions, reduce, foreach, formats
+// such as @base64 and everything reading from outside the record (input,
+// env, now) are not supported.
+package transform
+
+import (
+	"fmt"
+)
+
+// Program is a compiled transformation.
+type Program struct {
+	src  string
+	root *node
+}
+
+// Compile parses the jq program src.
+func Compile(src string) (*Program, error) {
+	root, err := parse(src, 0, len(src), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{src: src, root: root}, nil
+}
+
+// String returns the program's source.
+func (p *Program) String() string { return p.src }
+
+// Run applies the program to the fields of a record and returns the
+// record's new fields. A program that produces no output, such as one
+// ending in a select that fails, drops the record: Run then returns false.
+// Producing more than one output, or anything but an object, is an error.
+// fields is not modified.
+//
+// Fields of types the language does not know, such as references to other
+// entities, pass through unchanged but cannot be operated on.
+func (p *Program) Run(fields map[string]any) (map[string]any, bool, error) {
+	out, err := eval(p.root, fields, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	switch len(out) {
+	case 0:
+		return nil, false, nil
+	case 1:
+	default:
+		return nil, false, fmt.Errorf("the transformation produced %d records, want one", len(out))
+	}
+	m, ok := out[0].(map[string]any)
+	if !ok {
+		return nil, false, fmt.Errorf("the transformation produced %s, want an object", describeValue(out[0]))
+	}
+	return m, true, nil
+}
@@ -0,0 +1,128 @@
+package transform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	in := map[string]any{
+		"first":  "Ada",
+		"last":   "Lovelace",
+		"born":   1815,
+		"weight": 52.5,
+		"tags":   []any{"math", "poetry"},
+		"legacy": "x",
+		"notes":  nil,
+	}
+	tests := []struct {
+		prog string
+		want map[string]any
+	}{
+		{`{name: "\(.first) \(.last)"}`, map[string]any{"name": "Ada Lovelace"}},
+		{`.name = .first + " " + .last | del(.first, .last, .legacy, .tags, .notes, .born, .weight)`, map[string]any{"name": "Ada Lovelace"}},
+		{`{born} | .age = 1852 - .born`, map[string]any{"born": 1815, "age": int64(37)}},
+		{`{w: (.weight * 2), half: (.born / 2), q: (.born % 100)}`, map[string]any{"w": 105.0, "half": 907.5, "q": int64(15)}},
+		{`{tags: (.tags | join(",")), n: (.tags | length), up: (.tags | map(ascii_upcase))}`, map[string]any{"tags": "math,poetry", "n": int64(2), "up": []any{"MATH", "POETRY"}}},
+		{`.tags[] |= "#" + . | {tags}`, map[string]any{"tags": []any{"#math", "#poetry"}}},
+		{`.born += 1 | {born}`, map[string]any{"born": int64(1816)}},
+		{`{n: (.notes // "none"), m: (.missing // .first)}`, map[string]any{"n": "none", "m": "Ada"}},
+		{`.notes //= "none" | {notes}`, map[string]any{"notes": "none"}},
+		{`{era: (if .born < 1800 then "old" elif .born < 1900 then "19th" else "new" end)}`, map[string]any{"era": "19th"}},
+		{`with_entries(select(.value != null) | .key |= ascii_upcase) | {FIRST, LAST}`, map[string]any{"FIRST": "Ada", "LAST": "Lovelace"}},
+		{`.first as $f | {greeting: "hi \($f)", $f}`, map[string]any{"greeting": "hi Ada", "f": "Ada"}},
+		{`{x: (try error("bad") catch .), y: (.first | tonumber? // 0)}`, map[string]any{"x": "bad", "y": int64(0)}},
+		{`{s: ("a-b-c" | split("-")), t: ("2024-01-15" | test("^\\d{4}-")), g: ("a.b.c" | gsub("\\."; "/")), u: ("a.b" | sub("\\."; ""))}`, map[string]any{"s": []any{"a", "b", "c"}, "t": true, "g": "a/b/c", "u": "ab"}},
+		{`{k: (. | keys | length), h: has("first"), e: ([.tags[] | select(startswith("p"))])}`, map[string]any{"k": int64(7), "h": true, "e": []any{"poetry"}}},
+		{`{j: ({a: 1} | tojson), p: ("{\"b\": 2.5}" | fromjson), n: ("12" | tonumber), s: (12 | tostring)}`, map[string]any{"j": `{"a":1}`, "p": map[string]any{"b": 2.5}, "n": int64(12), "s": "12"}},
+		{`{t: ([.born, .weight, .first, null, true, .tags] | map(type))}`, map[string]any{"t": []any{"number", "number", "string", "null", "boolean", "array"}}},
+		{`{a: ([3, 1, 2] | sort), b: ([1, 1, 2] | unique), c: ([1, 2] | add), d: ([4, 9] | max), e: ("  x " | trim), f: (2.5 | floor)}`, map[string]any{"a": []any{int64(1), int64(2), int64(3)}, "b": []any{int64(1), int64(2)}, "c": int64(3), "d": int64(9), "e": "x", "f": int64(2)}},
+		{`{x: (.tags | first), y: (.tags | .[-1]), z: (.tags[5])}`, map[string]any{"x": "math", "y": "poetry", "z": nil}},
+		{`{a: ({a: {b: 1}} * {a: {c: 2}}), b: ([1, 2, 3] - [2]), c: (. == .), d: ("abc" | contains("b"))}`, map[string]any{"a": map[string]any{"a": map[string]any{"b": int64(1), "c": int64(2)}}, "b": []any{int64(1), int64(3)}, "c": true, "d": true}},
+		{"# a comment\n{first} # another\n", map[string]any{"first": "Ada"}},
+		{`{"full name": .first} | ."full name" |= ltrimstr("A")`, map[string]any{"full name": "da"}},
+		{`del(.tags[0]) | {tags}`, map[string]any{"tags": []any{"poetry"}}},
+		{`.a.b.c = 1 | {a}`, map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1)}}}},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.prog)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.prog, err)
+			continue
+		}
+		got, ok, err := p.Run(in)
+		if err != nil || !ok {
+			t.Errorf("Run(%q) = %v, %v", tt.prog, ok, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Run(%q) = %#v, want %#v", tt.prog, got, tt.want)
+		}
+	}
+	if _, ok := in["name"]; ok || in["born"] != 1815 || len(in["tags"].([]any)) != 2 {
+		t.Errorf("Run modified its input: %v", in)
+	}
+}
+
+func TestRun_Drop(t *testing.T) {
+	p, err := Compile(`select(.draft | not)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := p.Run(map[string]any{"draft": true}); ok || err != nil {
+		t.Errorf("Run of a draft = %v, %v, want it dropped", ok, err)
+	}
+	if got, ok, err := p.Run(map[string]any{"draft": false}); !ok || err != nil || got["draft"] != false {
+		t.Errorf("Run of a final record = %v, %v, %v", got, ok, err)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	in := map[string]any{"n": 1, "s": "x", "tags": []any{"a"}}
+	for prog, want := range map[string]string{
+		`.n + .s`:              `number (1) and string ("x") cannot be added`,
+		`.s.x`:                 `cannot index string with "x"`,
+		`.n[]`:                 `cannot iterate over number (1)`,
+		`.tags, .tags`:         `produced 2 records`,
+		`.tags`:                `produced array (["a"]), want an object`,
+		`error("stop here")`:   `stop here`,
+		`.n / 0`:               `cannot divide by zero`,
+		`{(.n): 1}`:            `object keys must be strings`,
+		`(.s | length) = 1`:    `invalid path expression`,
+		`.s | tonumber`:        `cannot be parsed as a number`,
+		`.s | test("(")`:       `missing closing )`,
+		`{s} | .s |= split(1)`: `split requires string inputs`,
+	} {
+		p, err := Compile(prog)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", prog, err)
+			continue
+		}
+		if _, _, err := p.Run(in); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Run(%q) error = %v, want %q", prog, err, want)
+		}
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for prog, want := range map[string]string{
+		`.a |`:                         `column 5: unexpected end of the program`,
+		`.a | frobnicate`:              `column 6: frobnicate/0 is not defined`,
+		`{a: $x}`:                      `column 5: $x is not defined`,
+		`"abc`:                         `column 1: unterminated string`,
+		`.a ^ .b`:                      `column 4: unexpected character '^'`,
+		`if .a then 1`:                 `expected "end"`,
+		"{a: 1}\n| .b = )":             `line 2, column 8: unexpected ")"`,
+		`"\(.a`:                        `unterminated \( in string`,
+		`[.a, .b`:                      `expected "]", found the end of the program`,
+		`.a as x | .`:                  `expected a variable after as`,
+		`"x\q"`:                        `invalid escape \q`,
+		`reduce .[] as $x (0; . + $x)`: `reduce/0 is not defined`,
+	} {
+		_, err := Compile(prog)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Compile(%q) error = %v, want %q", prog, err, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	jsonvalidator "github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/notwillk/sqlfs/internal/dbml"
+	"github.com/notwillk/sqlfs/internal/loader"
+)
+
+// jsonSchemaErrors checks the values of the table's json columns against the
+// JSON Schemas attached to them (see dbml.Column.JSONSchema). The returned
+// error is non-nil only when an attached schema does not compile.
+func (v *Validator) jsonSchemaErrors(rec loader.Record, table *dbml.Table, filePath string) ([]ValidationError, error) {
+	var errs []ValidationError
+	for _, col := range table.Columns {
+		val, exists := rec.Fields[col.Name]
+		if !exists || val == nil {
+			continue
+		}
+		sch, err := v.columnSchema(table, col)
+		if err != nil {
+			return nil, err
+		}
+		if sch == nil {
+			continue
+		}
+		instance, err := jsonInstance(val)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", col.Name, err)
+		}
+		verr := sch.Validate(instance)
+		if verr == nil {
+			continue
+		}
+		ve, ok := verr.(*jsonvalidator.ValidationError)
+		if !ok {
+			return nil, verr
+		}
+		for _, msg := range leafMessages(ve.BasicOutput()) {
+			errs = append(errs, ValidationError{
+				FilePath:  filePath,
+				RecordKey: rec.Key,
+				Field:     col.Name,
+				Message:   msg,
+			})
+		}
+	}
+	return errs, nil
+}
+
+// columnSchema compiles (once) and returns the JSON Schema attached to col,
+// or nil if it has none.
+func (v *Validator) columnSchema(table *dbml.Table, col *dbml.Column) (*jsonvalidator.Schema, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if sch, ok := v.jsonSchemas[col]; ok {
+		return sch, nil
+	}
+	doc := col.JSONSchema()
+	var sch *jsonvalidator.Schema
+	if doc != nil {
+		url := table.Name + "." + col.Name + ".json"
+		c := jsonvalidator.NewCompiler()
+		if err := c.AddResource(url, doc); err != nil {
+			return nil, fmt.Errorf("%s.%s: JSON Schema in note: %w", table.Name, col.Name, err)
+		}
+		var err error
+		if sch, err = c.Compile(url); err != nil {
+			return nil, fmt.Errorf("%s.%s: JSON Schema in note: %w", table.Name, col.Name, err)
+		}
+	}
+	if v.jsonSchemas == nil {
+		v.jsonSchemas = make(map[*dbml.Column]*jsonvalidator.Schema)
+	}
+	v.jsonSchemas[col] = sch
+	return sch, nil
+}
+
+// jsonInstance round-trips a loaded value through JSON so the validator sees
+// JSON types (e.g. int64 → float64) whatever format the file was in.
+func jsonInstance(val any) (any, error) {
+	if ref, ok := val.(loader.EntityRef); ok {
+		val = ref.Path
+	}
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var instance any
+	err = json.Unmarshal(raw, &instance)
+	return instance, err
+}
+
+// leafMessages flattens basic output into one message per failing keyword,
+// prefixed with the JSON pointer into the value when it is not the root.
+func leafMessages(out *jsonvalidator.OutputUnit) []string {
+	var msgs []string
+	for _, u := range out.Errors {
+		if u.Error == nil || len(u.Errors) > 0 {
+			continue
+		}
+		msgs = append(msgs, pointerMessage(u.InstanceLocation, u.Error.String()))
+	}
+	if len(msgs) == 0 && out.Error != nil {
+		msgs = append(msgs, pointerMessage(out.InstanceLocation, out.Error.String()))
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+func pointerMessage(pointer, msg string) string {
+	if pointer == "" {
+		return msg
+	}
+	return pointer + ": " + msg
+}
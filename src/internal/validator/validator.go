@@ -3,6 +3,10 @@ package validator
 import (
 	"fmt"
 	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	jsonvalidator "github.com/santhosh-tekuri/jsonschema/v6"
 
 	"github.com/notwillk/sqlfs/internal/config"
 	"github.com/notwillk/sqlfs/internal/dbml"
@@ -25,6 +29,9 @@ func (e ValidationError) Error() string {
 type Validator struct {
 	Schema *dbml.Schema
 	Config *config.Config
+
+	mu          sync.Mutex                             // guards jsonSchemas
+	jsonSchemas map[*dbml.Column]*jsonvalidator.Schema // compiled json column schemas; nil = none attached
 }
 
 // New returns a new Validator.
@@ -38,11 +45,14 @@ func New(schema *dbml.Schema, cfg *config.Config) *Validator {
 //   - fail mode:   returns error on the first violation
 //   - warn mode:   collects all violations as warnings, returns valid records
 //   - silent mode: drops invalid records silently, returns valid records
+//
+// Duplicate top-level keys are reported for every table. Other checks only
+// apply when the schema defines the table; a nil Schema (schema-less mode)
+// defines none. Validate is safe for concurrent use.
 func (v *Validator) Validate(fr *loader.FileRecord) ([]loader.Record, []ValidationError, error) {
-	table := v.Schema.TableByName(fr.EntityType)
-	if table == nil {
-		// No schema for this table: pass all records through without validation.
-		return fr.Records, nil, nil
+	var table *dbml.Table
+	if v.Schema != nil {
+		table = v.Schema.TableByName(fr.EntityType)
 	}
 
 	stdCols := v.Config.StandardColumnNames()
@@ -50,7 +60,18 @@ func (v *Validator) Validate(fr *loader.FileRecord) ([]loader.Record, []Validati
 	var warnings []ValidationError
 
 	for _, rec := range fr.Records {
-		errs := v.validateRecord(rec, table, stdCols, fr.FilePath)
+		errs := duplicateKeyErrors(rec, fr.FilePath)
+		if v.Config.ForbidStandardColumns {
+			errs = append(errs, standardColumnErrors(rec, stdCols, fr.FilePath)...)
+		}
+		if table != nil {
+			errs = append(errs, v.validateRecord(rec, table, stdCols, fr.FilePath)...)
+			jsonErrs, err := v.jsonSchemaErrors(rec, table, fr.FilePath)
+			if err != nil {
+				return nil, nil, err
+			}
+			errs = append(errs, jsonErrs...)
+		}
 		if len(errs) == 0 {
 			valid = append(valid, rec)
 			continue
@@ -74,12 +95,13 @@ func (v *Validator) Validate(fr *loader.FileRecord) ([]loader.Record, []Validati
 func (v *Validator) validateRecord(rec loader.Record, table *dbml.Table, stdCols map[string]struct{}, filePath string) []ValidationError {
 	var errs []ValidationError
 
-	// Check required columns (not null, no default).
+	// Check required columns (not null, no default, or part of a composite
+	// primary key).
 	for _, col := range table.Columns {
 		if _, isStd := stdCols[col.Name]; isStd {
 			continue
 		}
-		if col.NotNull && col.Default == nil && !col.PK {
+		if (col.NotNull && col.Default == nil && !col.PK) || table.InPrimaryKey(col.Name) {
 			if _, exists := rec.Fields[col.Name]; !exists {
 				errs = append(errs, ValidationError{
 					FilePath:  filePath,
@@ -112,6 +134,37 @@ func (v *Validator) validateRecord(rec loader.Record, table *dbml.Table, stdCols
 		}
 	}
 
+	// Check uuid columns hold well-formed UUIDs.
+	for _, col := range table.Columns {
+		val, exists := rec.Fields[col.Name]
+		if !exists || val == nil || !strings.EqualFold(col.Type.Name, "uuid") {
+			continue
+		}
+		valStr := fmt.Sprintf("%v", val)
+		if _, err := uuid.Parse(valStr); err != nil {
+			errs = append(errs, ValidationError{
+				FilePath:  filePath,
+				RecordKey: rec.Key,
+				Field:     col.Name,
+				Message:   fmt.Sprintf("value %q is not a valid UUID", valStr),
+			})
+		}
+	}
+
+	// Check the [expires] column holds a time.
+	if col := table.ExpiryColumn(); col != nil {
+		if val, exists := rec.Fields[col.Name]; exists && val != nil {
+			if _, err := loader.ParseTime(val, v.Config.TimestampLocation); err != nil {
+				errs = append(errs, ValidationError{
+					FilePath:  filePath,
+					RecordKey: rec.Key,
+					Field:     col.Name,
+					Message:   fmt.Sprintf("value %q is not a valid expiry time", fmt.Sprintf("%v", val)),
+				})
+			}
+		}
+	}
+
 	// Check for unknown fields (fields not in schema and not standard columns).
 	colSet := make(map[string]struct{}, len(table.Columns))
 	for _, col := range table.Columns {
@@ -142,6 +195,41 @@ func (v *Validator) validateRecord(rec loader.Record, table *dbml.Table, stdCols
 	return errs
 }
 
+// duplicateKeyErrors reports each top-level key repeated in the record's file.
+func duplicateKeyErrors(rec loader.Record, filePath string) []ValidationError {
+	var errs []ValidationError
+	for _, key := range rec.DuplicateKeys {
+		errs = append(errs, ValidationError{
+			FilePath:  filePath,
+			RecordKey: rec.Key,
+			Field:     key,
+			Message:   "duplicate key (the last value is used)",
+		})
+	}
+	return errs
+}
+
+// standardColumnErrors reports each field of the record named like a
+// standard column, ignoring case as SQLite does, in field order.
+func standardColumnErrors(rec loader.Record, stdCols map[string]struct{}, filePath string) []ValidationError {
+	std := make(map[string]struct{}, len(stdCols))
+	for name := range stdCols {
+		std[strings.ToLower(name)] = struct{}{}
+	}
+	var errs []ValidationError
+	for _, field := range rec.FieldOrder() {
+		if _, ok := std[strings.ToLower(field)]; ok {
+			errs = append(errs, ValidationError{
+				FilePath:  filePath,
+				RecordKey: rec.Key,
+				Field:     field,
+				Message:   "standard columns are set by sqlfs and may not be given in files",
+			})
+		}
+	}
+	return errs
+}
+
 func enumContains(en *dbml.Enum, val string) bool {
 	for _, ev := range en.Values {
 		if ev.Name == val {
@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/notwillk/sqlfs/internal/config"
@@ -153,6 +154,80 @@ enum post_status {
 	}
 }
 
+func TestValidate_UUIDValidation(t *testing.T) {
+	schema := makeSchema(`
+Table users {
+  id uuid [pk]
+}
+`, t)
+
+	v := New(schema, config.Default())
+
+	fr := makeFileRecord("users", []loader.Record{
+		{Key: "u1", Fields: map[string]any{"id": "0190b6e4-2c1a-7f3e-9a4b-1c2d3e4f5a6b"}},
+	})
+	if _, _, err := v.Validate(fr); err != nil {
+		t.Fatalf("unexpected error for valid UUID: %v", err)
+	}
+
+	fr = makeFileRecord("users", []loader.Record{
+		{Key: "u2", Fields: map[string]any{"id": "not-a-uuid"}},
+	})
+	if _, _, err := v.Validate(fr); err == nil {
+		t.Fatal("expected error for invalid UUID")
+	}
+}
+
+func TestValidate_ExpiryValidation(t *testing.T) {
+	schema := makeSchema(`
+Table promos {
+  code varchar [pk]
+  ends_at timestamp [expires]
+}
+`, t)
+
+	v := New(schema, config.Default())
+	for _, ok := range []any{"2030-01-02", "2030-01-02T03:04:05Z", int64(1893456000)} {
+		fr := makeFileRecord("promos", []loader.Record{{Key: "p", Fields: map[string]any{"ends_at": ok}}})
+		if _, _, err := v.Validate(fr); err != nil {
+			t.Errorf("unexpected error for %v: %v", ok, err)
+		}
+	}
+
+	fr := makeFileRecord("promos", []loader.Record{{Key: "p", Fields: map[string]any{"ends_at": "next tuesday"}}})
+	if _, _, err := v.Validate(fr); err == nil || !strings.Contains(err.Error(), "expiry") {
+		t.Fatalf("err = %v, want an invalid expiry error", err)
+	}
+}
+
+func TestValidate_JSONColumnSchema(t *testing.T) {
+	schema := makeSchema(`
+Table users {
+  id integer [pk]
+  settings json [note: '{"type": "object", "properties": {"theme": {"enum": ["light", "dark"]}}, "required": ["theme"]}']
+}
+`, t)
+
+	cfg := config.Default()
+	cfg.Invalid = config.InvalidWarn
+	v := New(schema, cfg)
+
+	fr := makeFileRecord("users", []loader.Record{
+		{Key: "ok", Fields: map[string]any{"id": int64(1), "settings": map[string]any{"theme": "dark"}}},
+		{Key: "bad", Fields: map[string]any{"id": int64(2), "settings": map[string]any{"theme": "blue"}}},
+	})
+	_, warns, err := v.Validate(fr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warns) != 1 || warns[0].RecordKey != "bad" || warns[0].Field != "settings" {
+		t.Fatalf("warnings = %v, want one for bad.settings", warns)
+	}
+	if !strings.HasPrefix(warns[0].Message, "/theme: ") {
+		t.Errorf("message = %q, want it to point at /theme", warns[0].Message)
+	}
+}
+
 func TestValidate_UnknownField_Fail(t *testing.T) {
 	schema := makeSchema(`
 Table users {
@@ -213,3 +288,109 @@ Table posts {
 		t.Errorf("expected 1 valid record, got %d", len(valid))
 	}
 }
+
+func TestValidate_DuplicateKeys(t *testing.T) {
+	schema := makeSchema(`
+Table users {
+  name varchar
+}
+`, t)
+	rec := loader.Record{Key: "alice", Fields: map[string]any{"name": "Bob"}, DuplicateKeys: []string{"name"}}
+
+	// fail
+	if _, _, err := New(schema, config.Default()).Validate(makeFileRecord("users", []loader.Record{rec})); err == nil {
+		t.Fatal("expected error for duplicate key")
+	}
+
+	// warn
+	valid, warns, err := New(schema, config.Default().WithInvalid("warn")).Validate(makeFileRecord("users", []loader.Record{rec}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(valid) != 1 || len(warns) != 1 || warns[0].Field != "name" {
+		t.Errorf("warn: valid=%d warns=%v", len(valid), warns)
+	}
+
+	// silent, with no schema for the table
+	valid, warns, err = New(nil, config.Default().WithInvalid("silent")).Validate(makeFileRecord("users", []loader.Record{rec}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(valid) != 0 || len(warns) != 0 {
+		t.Errorf("silent: valid=%d warns=%d, want 0, 0", len(valid), len(warns))
+	}
+}
+
+func TestValidate_CompositePKRequired(t *testing.T) {
+	schema := makeSchema(`
+Table memberships {
+  org_id integer
+  user_id integer
+  indexes {
+    (org_id, user_id) [pk]
+  }
+}
+`, t)
+
+	v := New(schema, config.Default())
+	fr := makeFileRecord("memberships", []loader.Record{
+		{Key: "m", Fields: map[string]any{"org_id": 1}}, // missing 'user_id'
+	})
+
+	_, _, err := v.Validate(fr)
+	if err == nil || !strings.Contains(err.Error(), "user_id") {
+		t.Fatalf("expected missing user_id error, got %v", err)
+	}
+}
+
+func TestValidate_PrefixedStandardColumns(t *testing.T) {
+	schema := makeSchema(`
+Table users {
+  name varchar
+  _sqlfs_pk varchar [not null]
+}
+`, t)
+	fr := makeFileRecord("users", []loader.Record{
+		{Key: "alice", Fields: map[string]any{"name": "Alice", "_sqlfs_ulid": "x"}},
+	})
+
+	cfg := config.Default()
+	cfg.Invalid = config.InvalidWarn
+	if _, warns, _ := New(schema, cfg).Validate(fr); len(warns) != 2 {
+		t.Errorf("default columns: expected 2 warnings, got %v", warns)
+	}
+
+	cfg.StandardColumns = config.PrefixedColumns("_sqlfs_")
+	if _, warns, err := New(schema, cfg).Validate(fr); err != nil || len(warns) != 0 {
+		t.Errorf("prefixed columns: warnings = %v, err = %v", warns, err)
+	}
+}
+
+func TestValidate_ForbidStandardColumns(t *testing.T) {
+	schema := makeSchema(`
+Table users {
+  name varchar
+}
+`, t)
+	fr := makeFileRecord("users", []loader.Record{
+		{Key: "alice", Fields: map[string]any{"name": "Alice", "__PATH__": "elsewhere", "__ulid__": "x"}, Keys: []string{"name", "__PATH__", "__ulid__"}},
+	})
+
+	cfg := config.Default()
+	if _, _, err := New(schema, cfg).Validate(fr); err != nil {
+		t.Errorf("allowed by default: err = %v", err)
+	}
+
+	cfg.ForbidStandardColumns = true
+	_, _, err := New(schema, cfg).Validate(fr)
+	if ve, ok := err.(ValidationError); !ok || ve.Field != "__PATH__" {
+		t.Errorf("fail: err = %v, want one for __PATH__", err)
+	}
+
+	// Without a schema for the table, too.
+	cfg.Invalid = config.InvalidWarn
+	valid, warns, err := New(nil, cfg).Validate(fr)
+	if err != nil || len(valid) != 1 || len(warns) != 2 || warns[1].Field != "__ulid__" {
+		t.Errorf("warn: valid=%d warns=%v err=%v", len(valid), warns, err)
+	}
+}
@@ -1,3 +1,60 @@
 package version
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 const Version = "0.1.3"
+
+// Require returns an error if Version is older than min, a dotted version
+// such as "0.2" or "v1.4.0". An empty min is always satisfied.
+func Require(min string) error {
+	return require(Version, min)
+}
+
+func require(current, min string) error {
+	if min == "" {
+		return nil
+	}
+	want, err := parse(min)
+	if err != nil {
+		return fmt.Errorf("invalid minimum sqlfs version %q: %w", min, err)
+	}
+	have, err := parse(current)
+	if err != nil {
+		return err
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			if have[i] < want[i] {
+				return fmt.Errorf("this project requires sqlfs %s or newer, but this is sqlfs %s", min, current)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// parse splits a version into major, minor, and patch numbers. A leading "v"
+// and any pre-release or build suffix ("-rc1", "+meta") are ignored.
+func parse(v string) ([3]int, error) {
+	var out [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return out, fmt.Errorf("too many components")
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return out, fmt.Errorf("component %q is not a number", p)
+		}
+		out[i] = n
+	}
+	return out, nil
+}
@@ -0,0 +1,31 @@
+package version
+
+import "testing"
+
+func TestRequire_Current(t *testing.T) {
+	if err := Require(Version); err != nil {
+		t.Errorf("Require(Version) = %v", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	cases := []struct {
+		min     string
+		wantErr bool
+	}{
+		{"", false},
+		{"0.1", false},
+		{"0.1.3", false},
+		{"v0.1.2", false},
+		{"0.1.4", true},
+		{"0.2.0-rc1", true},
+		{"1", true},
+		{"one.two", true},
+	}
+	for _, tc := range cases {
+		err := require("0.1.3", tc.min)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("require(0.1.3, %q) = %v, wantErr %v", tc.min, err, tc.wantErr)
+		}
+	}
+}
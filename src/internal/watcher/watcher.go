@@ -5,20 +5,31 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+
+	"github.com/notwillk/sqlfs/internal/config"
 )
 
 // RebuildFn is called whenever watched files change.
 type RebuildFn func(ctx context.Context) error
 
-// Watcher watches a directory tree for changes and calls RebuildFn with debouncing.
+// Watcher watches a directory tree for changes and calls RebuildFn with
+// debouncing. Rebuilds never overlap: a change during a rebuild queues one
+// more rebuild, which starts once the running one returns.
 type Watcher struct {
 	rootDir  string
 	debounce time.Duration
 	fn       RebuildFn
 	fw       *fsnotify.Watcher
+
+	mu       sync.Mutex
+	schedule chan time.Time // latest RebuildAt time not yet picked up by Start
+	ignore   []string       // see SetIgnore
+
+	queued chan<- struct{} // when set, receives each rebuild queued behind a running one; for tests
 }
 
 // New creates a new Watcher.
@@ -32,6 +43,8 @@ func New(rootDir string, debounce time.Duration, fn RebuildFn) (*Watcher, error)
 		debounce: debounce,
 		fn:       fn,
 		fw:       fw,
+		schedule: make(chan time.Time, 1),
+		ignore:   config.DefaultIgnore,
 	}
 	if err := w.addAll(rootDir); err != nil {
 		fw.Close()
@@ -51,15 +64,75 @@ func (w *Watcher) Start(ctx context.Context) error {
 	}
 	pending := false
 
+	// running is set while a rebuild runs, and again when another was
+	// requested meanwhile; any number of requests queue a single rebuild.
+	var running, again bool
+	rebuilt := make(chan struct{})
+	rebuild := func() {
+		if running {
+			again = true
+			if w.queued != nil {
+				w.queued <- struct{}{}
+			}
+			return
+		}
+		running = true
+		go func() {
+			if err := w.fn(ctx); err != nil {
+				log.Printf("watcher: rebuild error: %v", err)
+			}
+			rebuilt <- struct{}{}
+		}()
+	}
+	// Let a running rebuild finish before returning.
+	defer func() {
+		if running {
+			<-rebuilt
+		}
+	}()
+
+	// The rebuild scheduled by RebuildAt; due is nil when there is none.
+	var at *time.Timer
+	var due <-chan time.Time
+	defer func() {
+		if at != nil {
+			at.Stop()
+		}
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 
+		case t := <-w.schedule:
+			if at != nil {
+				at.Stop()
+			}
+			at, due = nil, nil
+			if !t.IsZero() {
+				at = time.NewTimer(time.Until(t))
+				due = at.C
+			}
+
+		case <-due:
+			at, due = nil, nil
+			rebuild()
+
+		case <-rebuilt:
+			running = false
+			if again {
+				again = false
+				rebuild()
+			}
+
 		case event, ok := <-w.fw.Events:
 			if !ok {
 				return nil
 			}
+			if w.ignored(event.Name) {
+				continue
+			}
 			// If a new directory was created, watch it too.
 			if event.Has(fsnotify.Create) {
 				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
@@ -80,9 +153,7 @@ func (w *Watcher) Start(ctx context.Context) error {
 
 		case <-timer.C:
 			pending = false
-			if err := w.fn(ctx); err != nil {
-				log.Printf("watcher: rebuild error: %v", err)
-			}
+			rebuild()
 
 		case err, ok := <-w.fw.Errors:
 			if !ok {
@@ -93,6 +164,40 @@ func (w *Watcher) Start(ctx context.Context) error {
 	}
 }
 
+// RebuildAt schedules a rebuild at t even if no file changes, replacing the
+// rebuild scheduled by an earlier call; a zero t cancels it. It may be called
+// from the RebuildFn.
+func (w *Watcher) RebuildAt(t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.schedule:
+	default:
+	}
+	w.schedule <- t
+}
+
+// SetIgnore replaces the patterns of the files and directories whose changes
+// do not trigger a rebuild, config.DefaultIgnore until it is called. See
+// config.MatchIgnore.
+func (w *Watcher) SetIgnore(patterns []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ignore = patterns
+}
+
+// ignored reports whether path matches the ignore patterns. The root itself
+// is never ignored.
+func (w *Watcher) ignored(path string) bool {
+	rel, err := filepath.Rel(w.rootDir, path)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return config.MatchIgnore(w.ignore, rel)
+}
+
 // Close stops the watcher.
 func (w *Watcher) Close() error {
 	return w.fw.Close()
@@ -104,9 +209,12 @@ func (w *Watcher) addAll(dir string) error {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
-			return w.fw.Add(path)
+		if !d.IsDir() {
+			return nil
+		}
+		if w.ignored(path) {
+			return filepath.SkipDir
 		}
-		return nil
+		return w.fw.Add(path)
 	})
 }
@@ -7,6 +7,8 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/notwillk/sqlfs/internal/config"
 )
 
 func TestWatcher_CallbackOnChange(t *testing.T) {
@@ -133,3 +135,160 @@ func TestWatcher_Close(t *testing.T) {
 		t.Errorf("Close: %v", err)
 	}
 }
+
+func TestWatcher_RebuildAt(t *testing.T) {
+	dir := t.TempDir()
+
+	var callCount atomic.Int32
+	w, err := New(dir, 50*time.Millisecond, func(ctx context.Context) error {
+		callCount.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx) //nolint:errcheck
+		close(done)
+	}()
+
+	// The later call replaces the earlier one; a zero time cancels.
+	w.RebuildAt(time.Now().Add(50 * time.Millisecond))
+	w.RebuildAt(time.Now().Add(100 * time.Millisecond))
+	time.Sleep(80 * time.Millisecond)
+	if n := callCount.Load(); n != 0 {
+		t.Errorf("rebuilds before the scheduled time = %d, want 0", n)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if n := callCount.Load(); n != 1 {
+		t.Errorf("rebuilds after the scheduled time = %d, want 1", n)
+	}
+
+	w.RebuildAt(time.Now().Add(50 * time.Millisecond))
+	w.RebuildAt(time.Time{})
+	time.Sleep(150 * time.Millisecond)
+
+	cancel()
+	<-done
+
+	if n := callCount.Load(); n != 1 {
+		t.Errorf("rebuilds after cancelling = %d, want 1", n)
+	}
+}
+
+func TestWatcher_SingleFlight(t *testing.T) {
+	dir := t.TempDir()
+
+	// Each rebuild reports that it started and runs until released.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var callCount, active, maxActive atomic.Int32
+	w, err := New(dir, 20*time.Millisecond, func(ctx context.Context) error {
+		callCount.Add(1)
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		select {
+		case started <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	queued := make(chan struct{})
+	w.queued = queued
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx) //nolint:errcheck
+		close(done)
+	}()
+
+	wait := func(ch <-chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+
+	// Requests while the first rebuild runs queue a single rebuild after it.
+	w.RebuildAt(time.Now())
+	wait(started, "the first rebuild")
+	w.RebuildAt(time.Now())
+	wait(queued, "the second request to be queued")
+	w.RebuildAt(time.Now())
+	wait(queued, "the third request to be queued")
+	release <- struct{}{}
+	wait(started, "the queued rebuild")
+	release <- struct{}{}
+
+	cancel()
+	<-done
+
+	if n := maxActive.Load(); n != 1 {
+		t.Errorf("concurrent rebuilds = %d, want 1", n)
+	}
+	if n := callCount.Load(); n != 2 {
+		t.Errorf("rebuilds = %d, want 2", n)
+	}
+}
+
+func TestWatcher_Ignore(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "drafts"), 0755)
+
+	var callCount atomic.Int32
+	w, err := New(dir, 20*time.Millisecond, func(ctx context.Context) error {
+		callCount.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	w.SetIgnore(append(config.DefaultIgnore, "drafts/**"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx) //nolint:errcheck
+		close(done)
+	}()
+
+	// Editor temp files, hidden files and ignored paths change nothing.
+	time.Sleep(20 * time.Millisecond)
+	for _, name := range []string{".a.yaml.swp", "a.yaml~", ".#a.yaml", ".DS_Store", "drafts/b.yaml"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("a: 1"), 0644)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := callCount.Load(); n != 0 {
+		t.Errorf("rebuilds after ignored changes = %d, want 0", n)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("a: 1"), 0644)
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	<-done
+
+	if n := callCount.Load(); n != 1 {
+		t.Errorf("rebuilds = %d, want 1", n)
+	}
+}
@@ -0,0 +1,66 @@
+// Package sqlfs is the API for applications that embed sqlfs. Such an
+// application registers loaders for its own file formats, then runs the
+// sqlfs commands, whose builds read those formats alongside the built-in
+// ones:
+//
+//	func main() {
+//		sqlfs.RegisterLoader(&csvLoader{})
+//		os.Exit(commands.Execute(os.Args[1:], os.Stdout, os.Stderr))
+//	}
+//
+// A loader's Load reads a file with ReadFile, parses it, and sets the
+// FileRecord's EntityType (usually EntityType(relPath)) and its Records,
+// made with NewRecord: one keyed EntityKey(relPath) for a file holding a
+// single entity, or one per entity, with MultiRecord set, for a file holding
+// several. Those are keyed by their id or key field, or by their position.
+package sqlfs
+
+import "github.com/notwillk/sqlfs/internal/loader"
+
+type (
+	// Loader reads the data files of the extensions it declares.
+	Loader = loader.Loader
+	// Describer is implemented by loaders that name and describe
+	// themselves for the loaders command.
+	Describer = loader.Describer
+	// FileRecord is what a Loader returns for a file.
+	FileRecord = loader.FileRecord
+	// Record is one entity of a file.
+	Record = loader.Record
+)
+
+// RegisterLoader adds l to the loaders of every build started afterwards.
+// It replaces the built-in loader of any extension they share, while an
+// external loader that sqlfs.yaml declares for the extension replaces it in
+// turn. Register loaders before running any build, and as pointers or other
+// comparable values.
+func RegisterLoader(l Loader) {
+	loader.RegisterPlugin(l)
+}
+
+// ReadFile reads the file at absPath, whose path relative to the root is
+// relPath, returning its contents, decompressed if it is compressed with
+// gzip, and its FileRecord with the file's path, times, checksum and size.
+func ReadFile(absPath, relPath string) ([]byte, *FileRecord, error) {
+	return loader.ReadFile(absPath, relPath)
+}
+
+// NewRecord returns a Record keyed key with the top-level fields m. Arrays
+// are kept for the build to expand into child tables, and other nested
+// values are stored as JSON text.
+func NewRecord(key string, m map[string]any) Record {
+	return loader.NewRecord(key, m)
+}
+
+// EntityType returns the table of the file at relPath by its name, e.g.
+// "users" for "people/alice.users.yaml". Builds may still assign the file
+// to another table by the tables patterns of sqlfs.yaml.
+func EntityType(relPath string) string {
+	return loader.EntityType(relPath)
+}
+
+// EntityKey returns the key of the file at relPath, e.g. "alice" for
+// "people/alice.users.yaml".
+func EntityKey(relPath string) string {
+	return loader.EntityKey(relPath)
+}
@@ -0,0 +1,79 @@
+package sqlfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/notwillk/sqlfs/internal/builder"
+	"github.com/notwillk/sqlfs/internal/config"
+	"github.com/notwillk/sqlfs/internal/loader"
+	"github.com/notwillk/sqlfs/internal/sqlite"
+)
+
+// linesLoader loads .lines files, with a record holding a name field per
+// line.
+type linesLoader struct{}
+
+func (*linesLoader) Extensions() []string { return []string{".lines"} }
+
+func (*linesLoader) Name() string { return "lines" }
+
+func (*linesLoader) Options() []string { return []string{"a record per line"} }
+
+func (*linesLoader) Load(absPath, relPath string) (*FileRecord, error) {
+	data, fr, err := ReadFile(absPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+	fr.EntityType = EntityType(relPath)
+	fr.MultiRecord = true
+	for _, line := range strings.Fields(string(data)) {
+		fr.Records = append(fr.Records, NewRecord("", map[string]any{"id": line, "name": strings.ToUpper(line)}))
+	}
+	return fr, nil
+}
+
+func TestRegisterLoader(t *testing.T) {
+	l := &linesLoader{}
+	RegisterLoader(l)
+
+	var found bool
+	for _, info := range loader.NewRegistry().Loaders() {
+		if info.Name == "lines" {
+			found = info.Plugin && len(info.Extensions) == 1 && info.Extensions[0] == ".lines"
+		} else if info.Plugin {
+			t.Errorf("built-in loader %s is reported as a plugin", info.Name)
+		}
+	}
+	if !found {
+		t.Error("Loaders does not list the lines plugin")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "team.people.lines"), []byte("ada\ngrace\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(t.TempDir(), "out.db")
+	result, err := builder.Build(context.Background(), builder.Options{RootDir: dir, OutputFile: outFile, Config: config.Default()})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if result.RecordsTotal != 2 {
+		t.Fatalf("RecordsTotal = %d, want 2", result.RecordsTotal)
+	}
+	db, err := sqlite.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var pk, name string
+	if err := db.DB().QueryRow(`SELECT __pk__, name FROM people WHERE id = 'grace'`).Scan(&pk, &name); err != nil {
+		t.Fatal(err)
+	}
+	if pk != "team#grace" || name != "GRACE" {
+		t.Errorf("row = %q, %q", pk, name)
+	}
+}